@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ActionConfig runs something when matching hosts change state, such as
+// power-cycling a camera's PoE port through the switch's API when it goes
+// down. An action either runs a command locally, runs it over SSH, or
+// calls a URL:
+//
+//	actions:
+//	  - name: poe-cycle
+//	    tags: [camera]
+//	    url: https://switch.lan/api/poe/{{.Stats.DisplayName}}/cycle
+//	    headers: {Authorization: Bearer SECRET}
+//	    cooldown: 15m
+//	  - hosts: [nas.lan]
+//	    ssh: admin@nas.lan
+//	    command: [sudo, systemctl, restart, smbd]
+//	  - on: [up, down]
+//	    command: [/usr/local/bin/announce, "{{.Host}} is {{.Status}}"]
+//
+// Command arguments, URLs, headers and bodies are templates like those of
+// notifications, executed with the alert. Commands are run without a shell
+// and also get NETMONITOR_HOST, NETMONITOR_STATUS and NETMONITOR_PREVIOUS
+// in their environment. Webhooks POST the alert as JSON unless a body is
+// given.
+type ActionConfig struct {
+	Name  string   `yaml:"name"`
+	Hosts []string `yaml:"hosts"`
+	Tags  []string `yaml:"tags"`
+	On    []string `yaml:"on"` // statuses entered, down unless set
+
+	Command []string `yaml:"command"`
+	SSH     string   `yaml:"ssh"`     // [user@]host[:port] to run the command on
+	SSHKey  string   `yaml:"ssh_key"` // identity file, ssh's default unless set
+
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+
+	// Timeout bounds a run, 30 seconds unless set. Cooldown is the least
+	// time between runs for the same host, so a host that keeps flapping
+	// is not power-cycled over and over.
+	Timeout  time.Duration `yaml:"timeout"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// action is an ActionConfig with its templates parsed.
+type action struct {
+	name     string
+	hosts    []string
+	tags     []string
+	on       []string
+	command  []*template.Template
+	ssh      string
+	sshKey   string
+	url      *template.Template
+	method   string
+	headers  map[string]*template.Template
+	body     *template.Template
+	timeout  time.Duration
+	cooldown time.Duration
+
+	mu      sync.Mutex
+	lastRun map[string]time.Time // by host
+}
+
+func newAction(ac ActionConfig) (*action, error) {
+	a := &action{
+		name:     ac.Name,
+		hosts:    ac.Hosts,
+		tags:     ac.Tags,
+		on:       ac.On,
+		ssh:      ac.SSH,
+		sshKey:   ac.SSHKey,
+		method:   strings.ToUpper(cmp.Or(ac.Method, http.MethodPost)),
+		timeout:  cmp.Or(ac.Timeout, 30*time.Second),
+		cooldown: ac.Cooldown,
+		lastRun:  map[string]time.Time{},
+	}
+	if len(a.on) == 0 {
+		a.on = []string{"down"}
+	}
+	for _, status := range a.on {
+		if status != "up" && status != "down" && status != "degraded" {
+			return nil, fmt.Errorf("unknown status %q, expected up, down or degraded", status)
+		}
+	}
+	switch {
+	case ac.URL != "" && len(ac.Command) > 0:
+		return nil, errors.New("set either a command or a url, not both")
+	case ac.URL == "" && len(ac.Command) == 0:
+		return nil, errors.New("no command or url")
+	case ac.SSH != "" && len(ac.Command) == 0:
+		return nil, errors.New("ssh needs a command")
+	}
+	if a.name == "" {
+		a.name = cmp.Or(ac.URL, strings.Join(ac.Command, " "))
+	}
+
+	parse := func(name, src string) (*template.Template, error) {
+		t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s template: %v", name, err)
+		}
+		return t, nil
+	}
+	var err error
+	for _, arg := range ac.Command {
+		t, err := parse("command", arg)
+		if err != nil {
+			return nil, err
+		}
+		a.command = append(a.command, t)
+	}
+	if ac.URL != "" {
+		if a.url, err = parse("url", ac.URL); err != nil {
+			return nil, err
+		}
+	}
+	if ac.Body != "" {
+		if a.body, err = parse("body", ac.Body); err != nil {
+			return nil, err
+		}
+	}
+	a.headers = map[string]*template.Template{}
+	for k, v := range ac.Headers {
+		if a.headers[k], err = parse("header "+k, v); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// matches reports whether the action runs for the alert.
+func (a *action) matches(al Alert) bool {
+	if !slices.Contains(a.on, al.Status) {
+		return false
+	}
+	if len(a.hosts) > 0 && !slices.Contains(a.hosts, al.Host) {
+		return false
+	}
+	return len(a.tags) == 0 || slices.ContainsFunc(a.tags, func(tag string) bool {
+		return slices.Contains(al.Stats.Tags, tag)
+	})
+}
+
+// due reports whether the cooldown for host has passed, and starts a new
+// one if so.
+func (a *action) due(host string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.lastRun[host]; ok && now.Sub(last) < a.cooldown {
+		return false
+	}
+	a.lastRun[host] = now
+	return true
+}
+
+func renderAction(t *template.Template, al Alert) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, al)
+	return b.String(), err
+}
+
+// run carries out the action for an alert.
+func (a *action) run(al Alert) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	if a.url != nil {
+		return a.call(ctx, al)
+	}
+
+	var args []string
+	for _, t := range a.command {
+		arg, err := renderAction(t, al)
+		if err != nil {
+			return err
+		}
+		args = append(args, arg)
+	}
+	if a.ssh != "" {
+		sshArgs := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+		host := a.ssh
+		if h, port, ok := strings.Cut(a.ssh, ":"); ok {
+			host = h
+			sshArgs = append(sshArgs, "-p", port)
+		}
+		if a.sshKey != "" {
+			sshArgs = append(sshArgs, "-i", a.sshKey)
+		}
+		args = append(append(append([]string{"ssh"}, sshArgs...), host, "--"), args...)
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"NETMONITOR_HOST="+al.Host,
+		"NETMONITOR_STATUS="+al.Status,
+		"NETMONITOR_PREVIOUS="+al.Previous,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, firstLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// call sends the action's webhook request.
+func (a *action) call(ctx context.Context, al Alert) error {
+	endpoint, err := renderAction(a.url, al)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if a.body != nil {
+		s, err := renderAction(a.body, al)
+		if err != nil {
+			return err
+		}
+		body = []byte(s)
+	} else if body, err = json.Marshal(al); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, a.method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if a.body == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, t := range a.headers {
+		v, err := renderAction(t, al)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, firstLine(strings.TrimSpace(string(msg))))
+	}
+	return nil
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// runActions carries out every action matching a status change. It runs
+// in its own goroutine, like notify.
+func (m *Monitor) runActions(al Alert) {
+	if !m.leads() {
+		return
+	}
+	for _, a := range m.actions {
+		if !a.matches(al) || !a.due(al.Host, al.Time) {
+			continue
+		}
+		if err := a.run(al); err != nil {
+			log.Printf("Action %s for %s failed: %v", a.name, al.Host, err)
+			continue
+		}
+		log.Printf("Action %s ran for %s (%s)", a.name, al.Host, al.Status)
+	}
+}
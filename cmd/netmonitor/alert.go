@@ -0,0 +1,271 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Alert is sent to notifiers whenever a host changes state.
+type Alert struct {
+	Host     string    `json:"host"`
+	Status   string    `json:"status"`
+	Previous string    `json:"previous"`
+	Severity string    `json:"severity"`
+	Resolved bool      `json:"resolved"`
+	Message  string    `json:"message,omitempty"`
+	Time     time.Time `json:"time"`
+	Stats    PingStats `json:"stats"`
+
+	// Duration is how long the host was in its previous status.
+	Duration     time.Duration `json:"duration"`
+	DashboardURL string        `json:"dashboardUrl,omitempty"`
+
+	// IncidentID links the alert to the outage it belongs to. Repeat marks
+	// a reminder for an incident nobody has acknowledged yet.
+	IncidentID int  `json:"incidentId,omitempty"`
+	Repeat     bool `json:"repeat,omitempty"`
+
+	// Escalated marks a reminder sent to the next step of an escalation
+	// policy.
+	Escalated bool `json:"escalated,omitempty"`
+
+	// Device is set on alerts about a new device on the network, which
+	// have the status "new".
+	Device *Device `json:"device,omitempty"`
+
+	// Ports is set on alerts about a change in a host's open ports.
+	Ports *PortChange `json:"ports,omitempty"`
+
+	// Route is set on alerts about how a watched prefix is routed, whose
+	// host is the prefix.
+	Route *BGPEvent `json:"route,omitempty"`
+
+	// title and text are rendered from the notifier's templates and
+	// replace the built-in wording when set.
+	title, text string
+}
+
+// Alert severities, from least to most urgent.
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+var severityRank = map[string]int{
+	severityInfo:     0,
+	severityWarning:  1,
+	severityCritical: 2,
+}
+
+// statusSeverity maps a host status to the severity of being in it.
+func statusSeverity(status string) string {
+	switch status {
+	case "down":
+		return severityCritical
+	case "degraded":
+		return severityWarning
+	default:
+		return severityInfo
+	}
+}
+
+// Title is a one line summary suitable for push notification headings.
+func (a Alert) Title() string {
+	if a.title != "" {
+		return a.title
+	}
+	if a.Device != nil {
+		return fmt.Sprintf("New device %s", a.Host)
+	}
+	if a.Ports != nil {
+		return fmt.Sprintf("Open ports of %s changed", a.Host)
+	}
+	if a.Route != nil {
+		if a.Resolved {
+			return fmt.Sprintf("Routing of %s recovered", a.Host)
+		}
+		return fmt.Sprintf("Routing of %s changed", a.Host)
+	}
+	if a.Resolved {
+		return fmt.Sprintf("%s recovered", a.Host)
+	}
+	if a.Repeat {
+		return fmt.Sprintf("%s is still %s", a.Host, strings.ToUpper(a.Status))
+	}
+	return fmt.Sprintf("%s is %s", a.Host, strings.ToUpper(a.Status))
+}
+
+// Text is the notification body: the probe's message followed by the
+// latency and loss figures at the time of the change.
+func (a Alert) Text() string {
+	if a.text != "" {
+		return a.text
+	}
+	var b strings.Builder
+	if a.Device != nil {
+		fmt.Fprintf(&b, "%s appeared on the network.\n%s", a.Host, a.Message)
+		if a.DashboardURL != "" {
+			fmt.Fprintf(&b, "\n%s", a.DashboardURL)
+		}
+		return b.String()
+	}
+	if a.Ports != nil {
+		fmt.Fprintf(&b, "%s on %s.", a.Message, a.Host)
+		if a.DashboardURL != "" {
+			fmt.Fprintf(&b, "\n%s", a.DashboardURL)
+		}
+		return b.String()
+	}
+	if a.Route != nil {
+		fmt.Fprintf(&b, "%s: %s.", a.Host, a.Message)
+		for _, h := range a.Route.Hosts {
+			fmt.Fprintf(&b, "\n%s is %s", h.Host, h.Status)
+		}
+		if a.DashboardURL != "" {
+			fmt.Fprintf(&b, "\n%s", a.DashboardURL)
+		}
+		return b.String()
+	}
+	if a.Resolved {
+		fmt.Fprintf(&b, "%s is %s again (was %s for %v).", a.Host, a.Status, a.Previous, a.Duration.Round(time.Second))
+	} else if a.Repeat {
+		fmt.Fprintf(&b, "%s has been %s for %v.", a.Host, a.Status, a.Duration.Round(time.Second))
+	} else if a.Previous == a.Status {
+		fmt.Fprintf(&b, "%s is %s, now %s.", a.Host, a.Status, a.Severity)
+	} else {
+		fmt.Fprintf(&b, "%s changed from %s to %s.", a.Host, a.Previous, a.Status)
+	}
+	if a.Message != "" {
+		fmt.Fprintf(&b, "\n%s", a.Message)
+	}
+	fmt.Fprintf(&b, "\nLatency %.1f ms (avg %.1f ms), packet loss %.1f%%",
+		a.Stats.CurrentLatency, a.Stats.AvgLatency, a.Stats.PacketLoss)
+	if a.DashboardURL != "" {
+		fmt.Fprintf(&b, "\n%s", a.DashboardURL)
+	}
+	return b.String()
+}
+
+// Notifier delivers alerts to an external system.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// notifierFactories maps the scheme of a -notify URL to the constructor of
+// its notifier. Backends register themselves from their own files.
+var notifierFactories = map[string]func(u *url.URL) (Notifier, error){}
+
+// namedNotifier carries the name routes refer to, which defaults to the
+// redacted URL, along with the least severe alert the notifier wants and
+// the schedule of whoever it reaches instead of its own recipient.
+type namedNotifier struct {
+	name        string
+	minSeverity string
+	templates   alertTemplates
+	oncall      *onCallSchedule
+	Notifier
+}
+
+// parseNotifier builds a notifier from a -notify entry such as
+// ntfy://ntfy.sh/my-topic?min_severity=critical.
+func parseNotifier(raw string, schedules []*onCallSchedule) (*namedNotifier, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid notifier %q", raw)
+	}
+	factory, ok := notifierFactories[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("invalid notifier %q: unknown type %q", u.Redacted(), u.Scheme)
+	}
+	minSeverity := u.Query().Get("min_severity")
+	if minSeverity == "" {
+		minSeverity = severityInfo
+	}
+	if _, ok := severityRank[minSeverity]; !ok {
+		return nil, fmt.Errorf("invalid notifier %q: unknown severity %q", u.Redacted(), minSeverity)
+	}
+	n, err := factory(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifier %q: %v", u.Redacted(), err)
+	}
+	named := &namedNotifier{name: u.Redacted(), minSeverity: minSeverity, Notifier: n}
+	if name := u.Query().Get("oncall"); name != "" {
+		if _, ok := n.(directNotifier); !ok {
+			return nil, fmt.Errorf("invalid notifier %q: %s notifiers cannot reach whoever is on call", u.Redacted(), u.Scheme)
+		}
+		i := slices.IndexFunc(schedules, func(s *onCallSchedule) bool { return s.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("invalid notifier %q: unknown on-call schedule %q", u.Redacted(), name)
+		}
+		named.oncall = schedules[i]
+	}
+	return named, nil
+}
+
+// notify fans an alert out to every notifier interested in its severity.
+// It runs in its own goroutine so a slow backend never holds up the probe
+// loops.
+func (m *Monitor) notify(a Alert) {
+	if !m.shouldNotify(a) {
+		return
+	}
+	m.send(a, m.routeAlert(a))
+}
+
+// send delivers an alert to the notifiers interested in its severity.
+func (m *Monitor) send(a Alert, notifiers []*namedNotifier) {
+	for _, n := range notifiers {
+		if severityRank[a.Severity] < severityRank[n.minSeverity] {
+			continue
+		}
+		var err error
+		if n.oncall != nil {
+			err = m.notifyOnCall(n, n.templates.apply(a))
+		} else {
+			err = n.Notify(n.templates.apply(a))
+		}
+		if err != nil {
+			log.Printf("Notifier %s failed: %v", n.name, err)
+		}
+	}
+}
+
+// alertFor describes the transition of stats into its current status after
+// the host spent since..now in the previous one, or into another severity
+// in the same status. The startup transition from unknown to up is not
+// worth an alert, unless it resolves an incident restored from a backup.
+func (m *Monitor) alertFor(stats *PingStats, previous, previousSeverity string, since time.Time, err error) (Alert, bool) {
+	if previous == "unknown" && stats.Status == "up" && !m.incidents.isOpen(stats.Host) {
+		return Alert{}, false
+	}
+	now := m.clock.Now()
+	a := Alert{
+		Host:         stats.Host,
+		Status:       stats.Status,
+		Previous:     previous,
+		Severity:     cmp.Or(stats.Severity, severityInfo),
+		Time:         now,
+		Stats:        *stats,
+		Duration:     now.Sub(since),
+		DashboardURL: m.dashboardURL,
+	}
+	// A recovery is as important as the problem it resolves, so notifiers
+	// that only want critical alerts still learn the host came back.
+	if stats.Status == "up" {
+		a.Resolved = true
+		a.Severity = cmp.Or(previousSeverity, statusSeverity(previous))
+	}
+	switch {
+	case stats.Status == "degraded":
+		a.Message = stats.Warning
+	case err != nil:
+		a.Message = err.Error()
+	}
+	return a, true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// failingProber fails every probe.
+type failingProber struct{}
+
+func (failingProber) Probe(t *target) (probeResult, error) {
+	return probeResult{}, errors.New("no route to host")
+}
+
+// recordingNotifier hands the alerts it gets to the test.
+type recordingNotifier chan Alert
+
+func (n recordingNotifier) Notify(a Alert) error {
+	n <- a
+	return nil
+}
+
+// next returns the next alert, or fails when none is sent.
+func (n recordingNotifier) next(t *testing.T) Alert {
+	t.Helper()
+	select {
+	case a := <-n:
+		return a
+	case <-time.After(time.Second):
+		t.Fatal("no alert was sent")
+		return Alert{}
+	}
+}
+
+// none fails when an alert is sent.
+func (n recordingNotifier) none(t *testing.T) {
+	t.Helper()
+	select {
+	case a := <-n:
+		t.Fatalf("unexpected alert %q", a.Title())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAlertReminders(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tgt, err := parseTarget("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	notifier := make(recordingNotifier, 10)
+	m := NewMonitor([]*target{tgt}, 0, time.Minute)
+	m.clock, m.prober = clock, failingProber{}
+	m.notifiers = []*namedNotifier{{name: "test", minSeverity: severityInfo, Notifier: notifier}}
+	m.incidents.repeat = 10 * time.Minute
+	h := &hostState{t: tgt, stats: m.hosts().stats[tgt.name], statusSince: start}
+
+	m.probeOnce(h)
+	a := notifier.next(t)
+	if a.Status != "down" || a.Repeat || !a.Time.Equal(start) {
+		t.Fatalf("first alert: status %s, repeat %v at %v", a.Status, a.Repeat, a.Time)
+	}
+
+	clock.advance(5 * time.Minute)
+	m.probeOnce(h)
+	notifier.none(t)
+
+	clock.advance(5 * time.Minute)
+	m.probeOnce(h)
+	a = notifier.next(t)
+	if !a.Repeat || a.Duration != 10*time.Minute {
+		t.Fatalf("reminder: repeat %v after %v, want a reminder after 10m", a.Repeat, a.Duration)
+	}
+
+	// Acknowledged incidents are not repeated.
+	inc, err := m.incidents.acknowledge(a.IncidentID, "oncall", "looking", clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inc.AcknowledgedAt.Equal(start.Add(10 * time.Minute)) {
+		t.Fatalf("acknowledged at %v, want the clock's time", inc.AcknowledgedAt)
+	}
+	clock.advance(30 * time.Minute)
+	m.probeOnce(h)
+	notifier.none(t)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APILimitConfig keeps a misbehaving dashboard or script from starving the
+// probes of CPU. Rates are in requests per second, zero meaning unlimited:
+// per_ip for each client address, per_token for each bearer token however
+// many addresses use it. Burst is how many requests may come at once, a
+// second's worth unless set. Request bodies over max_body bytes (1 MiB
+// unless set) are refused; host imports have a limit of their own.
+//
+//	api_limits:
+//	  per_ip: 20
+//	  per_token: 50
+type APILimitConfig struct {
+	PerIP    float64 `yaml:"per_ip"`
+	PerToken float64 `yaml:"per_token"`
+	Burst    float64 `yaml:"burst"`
+	MaxBody  int64   `yaml:"max_body"`
+}
+
+const defaultMaxBody = 1 << 20
+
+// apiLimiter holds a token bucket for each client address and token seen
+// lately.
+type apiLimiter struct {
+	perIP, perToken float64
+	burst           float64
+	maxBody         int64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+func newAPILimiter(cfg APILimitConfig) *apiLimiter {
+	return &apiLimiter{
+		perIP:    cfg.PerIP,
+		perToken: cfg.PerToken,
+		burst:    cfg.Burst,
+		maxBody:  cmp.Or(cfg.MaxBody, defaultMaxBody),
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// admit takes a request from key's bucket, or returns how long to wait
+// before trying again.
+func (l *apiLimiter) admit(key string, rate float64, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > time.Minute {
+		// Buckets that have filled up again are as good as new.
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*b.rate >= b.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.pruned = now
+	}
+	b := l.buckets[key]
+	if b == nil {
+		burst := max(cmp.Or(l.burst, rate), 1)
+		b = &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if wait := b.wait(now); wait > 0 {
+		return wait
+	}
+	b.tokens--
+	return 0
+}
+
+// wrap applies the limits to every request but health checks.
+func (l *apiLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/healthz") || strings.HasSuffix(r.URL.Path, "/readyz") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		var wait time.Duration
+		if l.perIP > 0 {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			wait = l.admit("ip:"+ip, l.perIP, now)
+		}
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && l.perToken > 0 && wait == 0 {
+			sum := sha256.Sum256([]byte(token))
+			wait = l.admit(fmt.Sprintf("token:%x", sum[:8]), l.perToken, now)
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "too many requests, slow down")
+			return
+		}
+
+		if !strings.HasSuffix(r.URL.Path, "/api/import") {
+			if r.ContentLength > l.maxBody {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", l.maxBody))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, l.maxBody)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPILimiterAdmit(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		cfg   APILimitConfig
+		rate  float64
+		at    []time.Duration // request times after now
+		waits []time.Duration
+	}{
+		{"a second's worth at once", APILimitConfig{}, 2,
+			[]time.Duration{0, 0, 0, 250 * time.Millisecond, 500 * time.Millisecond},
+			[]time.Duration{0, 0, 500 * time.Millisecond, 250 * time.Millisecond, 0}},
+		{"burst", APILimitConfig{Burst: 3}, 1,
+			[]time.Duration{0, 0, 0, 0, time.Second},
+			[]time.Duration{0, 0, 0, time.Second, 0}},
+		{"slow rates burst one", APILimitConfig{}, 0.5,
+			[]time.Duration{0, 0, 2 * time.Second},
+			[]time.Duration{0, 2 * time.Second, 0}},
+	}
+	for _, tt := range tests {
+		l := newAPILimiter(tt.cfg)
+		for i, at := range tt.at {
+			if wait := l.admit("ip:192.0.2.1", tt.rate, now.Add(at)); wait != tt.waits[i] {
+				t.Errorf("%s: request %d waits %v, want %v", tt.name, i+1, wait, tt.waits[i])
+			}
+		}
+	}
+
+	// Buckets that filled up again are forgotten once a minute.
+	l := newAPILimiter(APILimitConfig{})
+	l.admit("ip:192.0.2.1", 1, now)
+	l.admit("ip:192.0.2.2", 0.01, now.Add(30*time.Second))
+	l.admit("ip:192.0.2.3", 1, now.Add(61*time.Second))
+	if _, ok := l.buckets["ip:192.0.2.1"]; ok || len(l.buckets) != 2 {
+		t.Errorf("buckets after a minute: %v", l.buckets)
+	}
+}
+
+func TestAPILimiterWrap(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	})
+	request := func(method, path, addr, token, body string) *http.Request {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.RemoteAddr = addr
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+	large := strings.Repeat("x", 200)
+
+	tests := []struct {
+		name     string
+		cfg      APILimitConfig
+		requests []*http.Request
+		codes    []int
+	}{
+		{"per ip", APILimitConfig{PerIP: 1}, []*http.Request{
+			request("GET", "/api/stats", "192.0.2.1:4000", "", ""),
+			request("GET", "/api/stats", "192.0.2.1:4001", "", ""),
+			request("GET", "/api/stats", "192.0.2.2:4000", "", ""),
+			request("GET", "/healthz", "192.0.2.1:4000", "", ""),
+		}, []int{200, 429, 200, 200}},
+		{"per token", APILimitConfig{PerToken: 1}, []*http.Request{
+			request("GET", "/api/stats", "192.0.2.1:4000", "t0ken", ""),
+			request("GET", "/api/stats", "192.0.2.2:4000", "t0ken", ""),
+			request("GET", "/api/stats", "192.0.2.3:4000", "other", ""),
+			request("GET", "/api/stats", "192.0.2.3:4000", "", ""),
+		}, []int{200, 429, 200, 200}},
+		{"body", APILimitConfig{MaxBody: 100}, []*http.Request{
+			request("POST", "/api/hosts", "192.0.2.1:4000", "", large[:100]),
+			request("POST", "/api/hosts", "192.0.2.1:4000", "", large),
+			request("POST", "/api/import?format=hosts", "192.0.2.1:4000", "", large),
+		}, []int{200, 413, 200}},
+	}
+	for _, tt := range tests {
+		h := newAPILimiter(tt.cfg).wrap(handler)
+		for i, r := range tt.requests {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.codes[i] {
+				t.Errorf("%s: request %d got %d %s, want %d", tt.name, i+1, w.Code, w.Body, tt.codes[i])
+			}
+			if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+				t.Errorf("%s: request %d has Retry-After %q, want 1", tt.name, i+1, w.Header().Get("Retry-After"))
+			}
+		}
+	}
+
+	// Bodies without a length are cut off while they are read.
+	h := newAPILimiter(APILimitConfig{MaxBody: 100}).wrap(handler)
+	r := request("POST", "/api/hosts", "192.0.2.1:4000", "", "")
+	r.Body, r.ContentLength = io.NopCloser(strings.NewReader(large)), -1
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked body over the limit: %d", w.Code)
+	}
+}
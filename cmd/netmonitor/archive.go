@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyArchive keeps the day files of the history that fall out of the
+// retention in a directory or bucket (history_archive in the config file)
+// instead of removing them, so the history directory stays small while
+// years of history stay available. Ranges older than the history in memory
+// are read back from the archive when asked for, a day file at a time.
+type historyArchive struct {
+	store objectStore
+
+	moving sync.Mutex // held while day files are being archived
+
+	mu    sync.Mutex // guards days, and is held while fetching one
+	days  map[string]*archivedDay
+	clock int // ticks on every use of a day, for evicting the least used
+}
+
+// archivedDay is a day file fetched from the archive.
+type archivedDay struct {
+	chunks  []hostChunk // nil if the day is not archived
+	fetched time.Time
+	used    int
+}
+
+const (
+	// archiveCacheDays is how many days fetched from the archive are
+	// kept in memory.
+	archiveCacheDays = 31
+
+	// archiveMissingFor is how long a day missing from the archive is not
+	// asked for again, as it may be on its way.
+	archiveMissingFor = 10 * time.Minute
+)
+
+func newHistoryArchive(dest string) (*historyArchive, error) {
+	if dest == "" {
+		return nil, nil
+	}
+	store, err := newObjectStore(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &historyArchive{store: store, days: map[string]*archivedDay{}}, nil
+}
+
+// moveExpired archives the day files in dir that ended before cutoff and
+// removes them. A file that cannot be archived is kept and tried again the
+// next time.
+func (a *historyArchive) moveExpired(dir string, cutoff time.Time) {
+	a.moving.Lock()
+	defer a.moving.Unlock()
+	archived := map[string]bool{}
+	for _, f := range historyFiles(dir) {
+		if !f.day.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		day := f.day.Format("2006-01-02")
+		path := f.path
+		if strings.HasSuffix(path, ".jsonl") {
+			if archived[day] {
+				os.Remove(path) // compacted just before a crash
+				continue
+			}
+			if err := compactHistory(path); err != nil {
+				log.Printf("Compacting history %s failed: %v", path, err)
+				continue
+			}
+			path = strings.TrimSuffix(path, ".jsonl") + ".chunks"
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Archiving history %s failed: %v", path, err)
+			continue
+		}
+		if err := a.store.put(filepath.Base(path), data); err != nil {
+			log.Printf("Archiving history %s to %s failed: %v", path, a.store, err)
+			return
+		}
+		os.Remove(path)
+		archived[day] = true
+		log.Printf("Archived history %s to %s", filepath.Base(path), a.store)
+	}
+}
+
+// rangeOf returns the archived samples of host within [from, to).
+func (a *historyArchive) rangeOf(host string, from, to time.Time) []Sample {
+	var samples []Sample
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, c := range a.day(day.Format("2006-01-02")) {
+			if c.host != host || c.last.Before(from) || !c.first.Before(to) {
+				continue
+			}
+			decoded, err := c.samples()
+			if err != nil {
+				log.Printf("Archived history of %s from %s is corrupt: %v", host, c.first.Format(time.DateTime), err)
+				continue
+			}
+			for _, s := range decoded {
+				if !s.Time.Before(from) && s.Time.Before(to) {
+					samples = append(samples, s)
+				}
+			}
+		}
+	}
+	return samples
+}
+
+// day returns the chunks of an archived day, fetching them unless they
+// were recently.
+func (a *historyArchive) day(name string) []hostChunk {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock++
+	if d := a.days[name]; d != nil && (d.chunks != nil || time.Since(d.fetched) < archiveMissingFor) {
+		d.used = a.clock
+		return d.chunks
+	}
+
+	d := &archivedDay{fetched: time.Now(), used: a.clock}
+	data, err := a.store.get(name + ".chunks")
+	if err == nil {
+		d.chunks, err = readChunks(bufio.NewReader(bytes.NewReader(data)))
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Not remembered, so it is asked for again.
+		log.Printf("Reading archived history %s from %s failed: %v", name, a.store, err)
+		return nil
+	}
+	if len(a.days) >= archiveCacheDays {
+		var oldest string
+		for n, d := range a.days {
+			if oldest == "" || d.used < a.days[oldest].used {
+				oldest = n
+			}
+		}
+		delete(a.days, oldest)
+	}
+	a.days[name] = d
+	return d.chunks
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ARPWatchConfig watches the neighbor table of the machine netmonitor runs
+// on and alerts when a device that was never seen before shows up on a
+// local segment:
+//
+//	arp_watch:
+//	  enabled: true
+//	  interfaces: [eth0]
+//	  known: ["3c:22:fb:12:34:56"]
+//	  tags: [lan]
+//
+// The devices present at the first scan are learned without alerts. Known
+// lists MAC addresses that never alert, and tags are given to the alerts
+// so routes can pick them out.
+type ARPWatchConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interval   time.Duration `yaml:"interval"` // a minute unless set
+	Interfaces []string      `yaml:"interfaces"`
+	Known      []string      `yaml:"known"`
+	Tags       []string      `yaml:"tags"`
+	Severity   string        `yaml:"severity"` // warning unless set
+}
+
+// Device is a MAC address seen in the neighbor table.
+type Device struct {
+	MAC       string    `json:"mac"`
+	IPs       []string  `json:"ips"`
+	Interface string    `json:"interface"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// neighbor is one entry of the neighbor table.
+type neighbor struct {
+	ip, mac, iface string
+}
+
+// arpWatcher keeps the inventory of devices, in the history directory when
+// one is configured so they are not announced again after a restart.
+type arpWatcher struct {
+	interval   time.Duration
+	interfaces []string
+	known      map[string]bool
+	tags       []string
+	severity   string
+
+	mu       sync.Mutex
+	path     string
+	devices  map[string]*Device // by MAC
+	learning bool               // the inventory is empty and the next scan fills it
+}
+
+func newARPWatcher(cfg ARPWatchConfig) (*arpWatcher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	w := &arpWatcher{
+		interval:   cmp.Or(cfg.Interval, time.Minute),
+		interfaces: cfg.Interfaces,
+		known:      map[string]bool{},
+		tags:       cfg.Tags,
+		severity:   cmp.Or(cfg.Severity, severityWarning),
+		devices:    map[string]*Device{},
+		learning:   true,
+	}
+	if _, ok := severityRank[w.severity]; !ok {
+		return nil, fmt.Errorf("unknown severity %q", w.severity)
+	}
+	for _, mac := range cfg.Known {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return nil, err
+		}
+		w.known[hw.String()] = true
+	}
+	return w, nil
+}
+
+// open loads the inventory saved in dir.
+func (w *arpWatcher) open(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.path = filepath.Join(dir, "devices.json")
+	b, err := os.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var devices []*Device
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		w.devices[d.MAC] = d
+	}
+	w.learning = len(w.devices) == 0
+	return nil
+}
+
+// saveLocked writes the inventory out. w.mu must be held.
+func (w *arpWatcher) saveLocked() error {
+	if w.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(w.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(w.path, b, 0644)
+}
+
+func (w *arpWatcher) listLocked() []Device {
+	devices := make([]Device, 0, len(w.devices))
+	for _, d := range w.devices {
+		devices = append(devices, *d)
+	}
+	slices.SortFunc(devices, func(a, b Device) int { return strings.Compare(a.MAC, b.MAC) })
+	return devices
+}
+
+func (w *arpWatcher) list() []Device {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.listLocked()
+}
+
+// update records a scan of the neighbor table and returns the devices
+// that are new to the inventory.
+func (w *arpWatcher) update(neighbors []neighbor, now time.Time) ([]Device, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var added []string
+	for _, n := range neighbors {
+		if len(w.interfaces) > 0 && !slices.Contains(w.interfaces, n.iface) {
+			continue
+		}
+		d, ok := w.devices[n.mac]
+		if !ok {
+			d = &Device{MAC: n.mac, Interface: n.iface, FirstSeen: now}
+			w.devices[n.mac] = d
+			if !w.learning && !w.known[n.mac] {
+				added = append(added, n.mac)
+			}
+		}
+		if !slices.Contains(d.IPs, n.ip) {
+			d.IPs = append(d.IPs, n.ip)
+		}
+		d.Interface, d.LastSeen = n.iface, now
+	}
+	if w.learning && len(w.devices) > 0 {
+		log.Printf("Learned %d devices from the neighbor table", len(w.devices))
+	}
+	w.learning = w.learning && len(w.devices) == 0
+	devices := make([]Device, len(added))
+	for i, mac := range added {
+		devices[i] = *w.devices[mac]
+	}
+	return devices, w.saveLocked()
+}
+
+// watchNeighbors scans the neighbor table every interval and sends an
+// alert for every new device.
+func (m *Monitor) watchNeighbors(w *arpWatcher) {
+	for {
+		neighbors, err := readNeighbors()
+		if err != nil {
+			log.Printf("Reading the neighbor table failed: %v", err)
+		} else {
+			now := time.Now()
+			added, err := w.update(neighbors, now)
+			if err != nil {
+				log.Printf("Saving devices failed: %v", err)
+			}
+			for _, d := range added {
+				log.Printf("New device %s at %s on %s", d.MAC, strings.Join(d.IPs, ", "), d.Interface)
+				go m.notify(m.deviceAlert(w, d, now))
+			}
+		}
+		time.Sleep(w.interval)
+	}
+}
+
+// deviceAlert announces a new device through the notifiers.
+func (m *Monitor) deviceAlert(w *arpWatcher, d Device, now time.Time) Alert {
+	host := cmp.Or(strings.Join(d.IPs, ", "), d.MAC)
+	return Alert{
+		Host:         host,
+		Status:       "new",
+		Severity:     w.severity,
+		Message:      fmt.Sprintf("MAC address %s on %s", d.MAC, d.Interface),
+		Time:         now,
+		Stats:        PingStats{Host: host, Status: "new", Tags: w.tags},
+		DashboardURL: m.dashboardURL,
+		Device:       &d,
+	}
+}
+
+func (m *Monitor) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if m.arpWatcher == nil {
+		writeJSON(w, http.StatusOK, []Device{})
+		return
+	}
+	writeJSON(w, http.StatusOK, m.arpWatcher.list())
+}
+
+// parseMAC normalizes a MAC address from the neighbor table, rejecting
+// incomplete entries and the multicast and broadcast addresses some
+// systems list.
+func parseMAC(s string) (string, bool) {
+	hw, err := net.ParseMAC(s)
+	if err != nil || len(hw) != 6 || hw[0]&1 != 0 || slices.Equal(hw, make(net.HardwareAddr, 6)) {
+		return "", false
+	}
+	return hw.String(), true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readNeighbors reads the kernel's IPv4 neighbor table.
+func readNeighbors() ([]neighbor, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// IP address, HW type, Flags, HW address, Mask, Device
+	var neighbors []neighbor
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[2] == "0x0" {
+			continue // incomplete
+		}
+		if mac, ok := parseMAC(fields[3]); ok {
+			neighbors = append(neighbors, neighbor{ip: fields[0], mac: mac, iface: fields[5]})
+		}
+	}
+	return neighbors, scanner.Err()
+}
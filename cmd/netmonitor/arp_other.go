@@ -0,0 +1,69 @@
+//go:build !linux
+
+package main
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// readNeighbors parses the output of arp -a, which lists entries as
+// "? (192.168.1.1) at 0:11:22:33:44:55 on en0 ifscope [ethernet]" on macOS
+// and the BSDs, and under an "Interface: 192.168.1.5 --- 0x4" heading per
+// interface on Windows.
+func readNeighbors() ([]neighbor, error) {
+	args := []string{"-an"}
+	if runtime.GOOS == "windows" {
+		args = []string{"-a"}
+	}
+	out, err := exec.Command("arp", args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var neighbors []neighbor
+	iface := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "Interface:" {
+			iface = fields[1]
+			continue
+		}
+		var n neighbor
+		for i, f := range fields {
+			f = strings.Trim(f, "()")
+			switch {
+			case n.ip == "" && net.ParseIP(f) != nil:
+				n.ip = f
+			case n.mac == "":
+				n.mac, _ = parseMAC(padMAC(f))
+			case f == "on" && i+1 < len(fields):
+				n.iface = fields[i+1]
+			}
+		}
+		if n.ip == "" || n.mac == "" {
+			continue
+		}
+		if n.iface == "" {
+			n.iface = iface
+		}
+		neighbors = append(neighbors, n)
+	}
+	return neighbors, nil
+}
+
+// padMAC turns the MAC addresses of arp -a into ones net.ParseMAC takes:
+// macOS leaves out leading zeros and Windows separates with dashes.
+func padMAC(s string) string {
+	octets := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '-' })
+	if len(octets) != 6 {
+		return s
+	}
+	for i, o := range octets {
+		if len(o) == 1 {
+			octets[i] = "0" + o
+		}
+	}
+	return strings.Join(octets, ":")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEvent records a change: who made it, when, to what, and the values
+// before and after where there are any.
+type AuditEvent struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Email  string    `json:"email,omitempty"`
+	Remote string    `json:"remote,omitempty"` // client address of API changes
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Before any       `json:"before,omitempty"`
+	After  any       `json:"after,omitempty"`
+}
+
+// auditLog keeps every change, in audit.jsonl in the history directory when
+// one is configured. Changes are rare enough that all of them stay in
+// memory for querying.
+type auditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+	file   *os.File
+}
+
+// open loads the changes recorded in dir and appends new ones to it.
+func (a *auditLog) open(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	path := filepath.Join(dir, "audit.jsonl")
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var e AuditEvent
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				a.events = append(a.events, e)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	a.file = f
+	return nil
+}
+
+func (a *auditLog) add(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	log.Printf("Audit: %s %s %s", e.Actor, e.Action, e.Target)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, e)
+	if a.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		log.Printf("Writing the audit log failed: %v", err)
+	}
+}
+
+// last returns the latest event with the given action.
+func (a *auditLog) last(action string) (AuditEvent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if a.events[i].Action == action {
+			return a.events[i], true
+		}
+	}
+	return AuditEvent{}, false
+}
+
+// auditRequest records a change made through the API by the signed-in
+// user, or by the client's address without login.
+func (m *Monitor) auditRequest(r *http.Request, action, target string, before, after any) {
+	e := AuditEvent{Action: action, Target: target, Before: before, After: after}
+	e.Remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+	e.Actor = "anonymous"
+	if u := userFrom(r.Context()); u != nil {
+		e.Actor, e.Email = u.Name, u.Email
+	}
+	m.audit.add(e)
+}
+
+// auditConfig records the config file when it differs from the one the
+// last run started with.
+func (m *Monitor) auditConfig(path string, hosts int) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	config := map[string]any{"path": path, "sha256": hex.EncodeToString(sum[:]), "hosts": hosts}
+	e := AuditEvent{Actor: "config file", Action: "config.change", Target: path, After: config}
+	if last, ok := m.audit.last(e.Action); ok {
+		if before, ok := last.After.(map[string]any); ok && before["sha256"] == config["sha256"] {
+			return
+		}
+		e.Before = last.After
+	}
+	m.audit.add(e)
+}
+
+// handleAudit serves GET /api/audit, newest first, optionally limited to
+// from/to, an actor (name or email), action or target, and limit events
+// (100).
+func (m *Monitor) handleAudit(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r, 100*365*24*time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: %q", v))
+			return
+		}
+	}
+	match := func(key, value string) bool {
+		want := q.Get(key)
+		return want == "" || want == value
+	}
+
+	events := []AuditEvent{}
+	m.audit.mu.Lock()
+	for _, e := range slices.Backward(m.audit.events) {
+		if len(events) == limit {
+			break
+		}
+		if e.Time.Before(from) || !e.Time.Before(to) || !(match("actor", e.Actor) || match("actor", e.Email)) || !match("action", e.Action) || !match("target", e.Target) {
+			continue
+		}
+		events = append(events, e)
+	}
+	m.audit.mu.Unlock()
+	writeJSON(w, http.StatusOK, events)
+}
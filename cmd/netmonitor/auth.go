@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// role is what a signed-in user may do. Each role includes the ones
+// before it.
+type role int
+
+const (
+	roleViewer   role = iota + 1 // see the dashboard and read the API
+	roleOperator                 // acknowledge incidents, edit hosts and UI settings
+	roleAdmin                    // import hosts
+)
+
+var roleNames = map[string]role{
+	"viewer":   roleViewer,
+	"operator": roleOperator,
+	"admin":    roleAdmin,
+}
+
+func parseRole(s string) (role, error) {
+	if r, ok := roleNames[s]; ok {
+		return r, nil
+	}
+	return 0, fmt.Errorf("unknown role %q, expected viewer, operator or admin", s)
+}
+
+func (r role) String() string {
+	for name, v := range roleNames {
+		if v == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+func (r role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *role) UnmarshalText(b []byte) error {
+	v, err := parseRole(string(b))
+	*r = v
+	return err
+}
+
+// User is whoever a request was made by.
+type User struct {
+	Subject string    `json:"sub"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email,omitempty"`
+	Role    role      `json:"role"`
+	Expires time.Time `json:"expires"`
+
+	// Scopes limit an API token, which has no role, to what they allow.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type userKey struct{}
+
+// userFrom returns the user of a request, or nil when login is not
+// configured.
+func userFrom(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+// requireRole refuses requests of signed-in users below role. Without
+// login every request is let through, as before.
+func requireRole(min role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := userFrom(r.Context())
+		if u != nil && u.Scopes != nil {
+			writeError(w, http.StatusForbidden, "not allowed with an API token")
+			return
+		}
+		if u != nil && u.Role < min {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s role required", min))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// requireLogin is requireRole that also refuses every request when login
+// is not configured, for what must not be left open to anyone.
+func requireLogin(min role, h http.HandlerFunc) http.HandlerFunc {
+	return requireRole(min, func(w http.ResponseWriter, r *http.Request) {
+		if userFrom(r.Context()) == nil {
+			writeError(w, http.StatusForbidden, "login is not configured")
+			return
+		}
+		h(w, r)
+	})
+}
+
+// requireAccess is requireRole that also lets through API tokens with
+// scope.
+func requireAccess(min role, scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if u := userFrom(r.Context()); u != nil && u.Scopes != nil {
+			if !slices.Contains(u.Scopes, scope) {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("%s scope required", scope))
+				return
+			}
+			h(w, r)
+			return
+		}
+		requireRole(min, h)(w, r)
+	}
+}
+
+// handleMe serves GET /api/me, the signed-in user.
+func (m *Monitor) handleMe(w http.ResponseWriter, r *http.Request) {
+	u := userFrom(r.Context())
+	if u == nil {
+		writeError(w, http.StatusNotFound, "login is not configured")
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// signer makes tamper-proof cookie values: JSON with an HMAC-SHA256 of it.
+type signer struct {
+	key []byte
+}
+
+func (s signer) sign(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(b)
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s signer) verify(value string, v any) error {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return errors.New("malformed value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return err
+	}
+	given, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(b)
+	if !hmac.Equal(given, mac.Sum(nil)) {
+		return errors.New("bad signature")
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	discovererFactories["aws"] = newAWSDiscoverer
+}
+
+// awsDiscoverer lists EC2 instances in a region, given as the URL's host
+// or by $AWS_REGION. Credentials come from $AWS_ACCESS_KEY_ID and
+// $AWS_SECRET_ACCESS_KEY, the shared credentials file (profile parameter
+// or $AWS_PROFILE) or the instance's IAM role, in that order. The Name tag
+// becomes the display name.
+type awsDiscoverer struct {
+	cloudOptions
+	awsCredentialSource
+	region string
+}
+
+// awsCredentialSource finds credentials the way the AWS tools do and keeps
+// them until they are about to expire.
+type awsCredentialSource struct {
+	profile string
+	creds   awsCredentials
+}
+
+// awsCredentials are access keys, with the session token and expiry of
+// temporary ones.
+type awsCredentials struct {
+	accessKey, secretKey, sessionToken string
+	expires                            time.Time
+}
+
+func newAWSDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	opts, err := newCloudOptions(q)
+	if err != nil {
+		return nil, err
+	}
+	d := &awsDiscoverer{
+		cloudOptions:        opts,
+		awsCredentialSource: newAWSCredentialSource(q.Get("profile")),
+		region:              cmp.Or(u.Host, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")),
+	}
+	if d.region == "" {
+		return nil, errors.New("missing region")
+	}
+	return &pollDiscoverer{list: d.list, refresh: d.refresh}, nil
+}
+
+// newAWSCredentialSource reads the given profile of the shared credentials
+// file, or $AWS_PROFILE or the default profile.
+func newAWSCredentialSource(profile string) awsCredentialSource {
+	return awsCredentialSource{profile: cmp.Or(profile, os.Getenv("AWS_PROFILE"), "default")}
+}
+
+// credentials returns the access keys to sign requests with, refreshing
+// temporary ones from the instance role before they expire.
+func (d *awsCredentialSource) credentials() (awsCredentials, error) {
+	if d.creds.accessKey != "" && (d.creds.expires.IsZero() || time.Until(d.creds.expires) > 5*time.Minute) {
+		return d.creds, nil
+	}
+	if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+		d.creds = awsCredentials{accessKey: key, secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), sessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		return d.creds, nil
+	}
+	creds, err := awsSharedCredentials(d.profile)
+	if err == nil {
+		d.creds = creds
+		return creds, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return creds, err
+	}
+	d.creds, err = awsRoleCredentials()
+	return d.creds, err
+}
+
+// awsSharedCredentials reads a profile of ~/.aws/credentials.
+func awsSharedCredentials(profile string) (awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer f.Close()
+	var creds awsCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.accessKey = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.secretKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.sessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return creds, err
+	}
+	if creds.accessKey == "" {
+		return creds, fmt.Errorf("%s: no keys for profile %q", path, profile)
+	}
+	return creds, nil
+}
+
+// awsMetadata is the EC2 instance metadata service.
+const awsMetadata = "http://169.254.169.254"
+
+// awsRoleCredentials fetches the temporary credentials of the instance's
+// IAM role from the metadata service (IMDSv2).
+func awsRoleCredentials() (awsCredentials, error) {
+	req, _ := http.NewRequest(http.MethodPut, awsMetadata+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := metadataText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials: %v", err)
+	}
+	get := func(path string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, awsMetadata+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+		return req
+	}
+	const path = "/latest/meta-data/iam/security-credentials/"
+	role, err := metadataText(get(path))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role: %v", err)
+	}
+	var resp struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := cloudJSON(get(path+strings.TrimSpace(role)), &resp); err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role: %v", err)
+	}
+	return awsCredentials{resp.AccessKeyID, resp.SecretAccessKey, resp.Token, resp.Expiration}, nil
+}
+
+// awsInstances is a page of DescribeInstances results.
+type awsInstances struct {
+	Reservations []struct {
+		Instances []struct {
+			ID        string `xml:"instanceId"`
+			PrivateIP string `xml:"privateIpAddress"`
+			PublicIP  string `xml:"ipAddress"`
+			Tags      []struct {
+				Key   string `xml:"key"`
+				Value string `xml:"value"`
+			} `xml:"tagSet>item"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+func (d *awsDiscoverer) list() ([]HostConfig, error) {
+	form := url.Values{
+		"Action":           {"DescribeInstances"},
+		"Version":          {"2016-11-15"},
+		"Filter.1.Name":    {"instance-state-name"},
+		"Filter.1.Value.1": {"running"},
+		"MaxResults":       {"1000"},
+	}
+	// Tags are filtered by EC2 as well, sparing pages of other instances.
+	for i, t := range d.filter {
+		n := strconv.Itoa(i + 2)
+		if t.any {
+			form.Set("Filter."+n+".Name", "tag-key")
+			form.Set("Filter."+n+".Value.1", t.key)
+		} else {
+			form.Set("Filter."+n+".Name", "tag:"+t.key)
+			form.Set("Filter."+n+".Value.1", t.value)
+		}
+	}
+
+	var hosts []HostConfig
+	for {
+		var page awsInstances
+		if err := d.call(form, &page); err != nil {
+			return nil, err
+		}
+		for _, r := range page.Reservations {
+			for _, in := range r.Instances {
+				tags := map[string]string{}
+				for _, t := range in.Tags {
+					tags[t.Key] = t.Value
+				}
+				if h, ok := d.host(cmp.Or(tags["Name"], in.ID), in.PrivateIP, in.PublicIP, tags); ok {
+					hosts = append(hosts, h)
+				}
+			}
+		}
+		if page.NextToken == "" {
+			break
+		}
+		form.Set("NextToken", page.NextToken)
+	}
+	slices.SortFunc(hosts, func(a, b HostConfig) int { return strings.Compare(a.DisplayName, b.DisplayName) })
+	return hosts, nil
+}
+
+// call sends an EC2 API request and decodes its XML response.
+func (d *awsDiscoverer) call(form url.Values, v any) error {
+	creds, err := d.credentials()
+	if err != nil {
+		return err
+	}
+	body := form.Encode()
+	req, err := http.NewRequest(http.MethodPost, "https://ec2."+d.region+".amazonaws.com/", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsSign(req, []byte(body), creds, d.region, "ec2", time.Now())
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Errors>Error>Code"`
+			Message string `xml:"Errors>Error>Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return fmt.Errorf("DescribeInstances: %s %s: %s", resp.Status, e.Code, e.Message)
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// awsSign adds a Signature Version 4 Authorization header to a request
+// whose query, if any, is encoded as url.Values.Encode does.
+func awsSign(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := []string{"host"}
+	for name := range req.Header {
+		headers = append(headers, strings.ToLower(name))
+	}
+	slices.Sort(headers)
+	var canonical strings.Builder
+	for _, name := range headers {
+		value := req.Host
+		if value == "" {
+			value = req.URL.Host
+		}
+		if name != "host" {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signed := strings.Join(headers, ";")
+	payload := sha256.Sum256(body)
+	request := strings.Join([]string{req.Method, cmp.Or(req.URL.EscapedPath(), "/"), req.URL.RawQuery, canonical.String(), signed, hex.EncodeToString(payload[:])}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(request))
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + creds.secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		creds.accessKey, scope, signed, hmacSHA256(key, toSign)))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+func init() {
+	discovererFactories["azure"] = newAzureDiscoverer
+}
+
+// azureDiscoverer lists the virtual machines of a subscription, given as
+// the URL's host or by $AZURE_SUBSCRIPTION_ID, or of one resource group
+// with the resource_group parameter. A service principal from
+// $AZURE_TENANT_ID, $AZURE_CLIENT_ID and $AZURE_CLIENT_SECRET signs in,
+// or else the managed identity of the machine netmonitor runs on.
+type azureDiscoverer struct {
+	cloudOptions
+	scope string // subscription or resource group path
+	token oauthToken
+}
+
+// azureManagement is the Azure Resource Manager endpoint.
+const azureManagement = "https://management.azure.com"
+
+func newAzureDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	opts, err := newCloudOptions(q)
+	if err != nil {
+		return nil, err
+	}
+	subscription := cmp.Or(u.Host, os.Getenv("AZURE_SUBSCRIPTION_ID"))
+	if subscription == "" {
+		return nil, errors.New("missing subscription")
+	}
+	d := &azureDiscoverer{cloudOptions: opts, scope: "/subscriptions/" + url.PathEscape(subscription)}
+	if rg := q.Get("resource_group"); rg != "" {
+		d.scope += "/resourceGroups/" + url.PathEscape(rg)
+	}
+
+	tenant, client, secret := os.Getenv("AZURE_TENANT_ID"), os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET")
+	if secret != "" {
+		d.token.fetch = func() (*http.Request, error) {
+			form := url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {client},
+				"client_secret": {secret},
+				"scope":         {azureManagement + "/.default"},
+			}
+			req, err := http.NewRequest(http.MethodPost, "https://login.microsoftonline.com/"+url.PathEscape(tenant)+"/oauth2/v2.0/token", strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req, nil
+		}
+	} else {
+		d.token.fetch = func() (*http.Request, error) {
+			query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureManagement + "/"}}
+			if client != "" {
+				query.Set("client_id", client) // a user-assigned identity
+			}
+			req, err := http.NewRequest(http.MethodGet, "http://169.254.169.254/metadata/identity/oauth2/token?"+query.Encode(), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata", "true")
+			return req, nil
+		}
+	}
+	return &pollDiscoverer{list: d.list, refresh: d.refresh}, nil
+}
+
+// azureResource holds the fields of virtual machines, network interfaces
+// and public IP addresses discovery needs.
+type azureResource struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		// Virtual machines
+		InstanceView struct {
+			Statuses []struct {
+				Code string `json:"code"`
+			} `json:"statuses"`
+		} `json:"instanceView"`
+		NetworkProfile struct {
+			NetworkInterfaces []struct {
+				ID         string `json:"id"`
+				Properties struct {
+					Primary bool `json:"primary"`
+				} `json:"properties"`
+			} `json:"networkInterfaces"`
+		} `json:"networkProfile"`
+
+		// Network interfaces
+		IPConfigurations []struct {
+			Properties struct {
+				Primary          bool   `json:"primary"`
+				PrivateIPAddress string `json:"privateIPAddress"`
+				PublicIPAddress  struct {
+					ID string `json:"id"`
+				} `json:"publicIPAddress"`
+			} `json:"properties"`
+		} `json:"ipConfigurations"`
+
+		// Public IP addresses
+		IPAddress string `json:"ipAddress"`
+	} `json:"properties"`
+}
+
+// resources lists resources of a type in the scope, following nextLink.
+func (d *azureDiscoverer) resources(provider, apiVersion string, extra url.Values) ([]azureResource, error) {
+	query := url.Values{"api-version": {apiVersion}}
+	for k, v := range extra {
+		query[k] = v
+	}
+	next := azureManagement + d.scope + "/providers/" + provider + "?" + query.Encode()
+	var all []azureResource
+	for next != "" {
+		token, err := d.token.get()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		var page struct {
+			Value    []azureResource `json:"value"`
+			NextLink string          `json:"nextLink"`
+		}
+		if err := cloudJSON(req, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Value...)
+		next = page.NextLink
+	}
+	return all, nil
+}
+
+func (d *azureDiscoverer) list() ([]HostConfig, error) {
+	// statusOnly adds the instance view, telling running machines.
+	vms, err := d.resources("Microsoft.Compute/virtualMachines", "2024-03-01", url.Values{"statusOnly": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	nics, err := d.resources("Microsoft.Network/networkInterfaces", "2023-09-01", nil)
+	if err != nil {
+		return nil, err
+	}
+	publicIPs := map[string]string{}
+	if d.public {
+		ips, err := d.resources("Microsoft.Network/publicIPAddresses", "2023-09-01", nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			publicIPs[strings.ToLower(ip.ID)] = ip.Properties.IPAddress
+		}
+	}
+	// Resource IDs are case-insensitive.
+	nicByID := map[string]azureResource{}
+	for _, nic := range nics {
+		nicByID[strings.ToLower(nic.ID)] = nic
+	}
+
+	var hosts []HostConfig
+	for _, vm := range vms {
+		running := false
+		for _, status := range vm.Properties.InstanceView.Statuses {
+			running = running || status.Code == "PowerState/running"
+		}
+		interfaces := vm.Properties.NetworkProfile.NetworkInterfaces
+		if !running || len(interfaces) == 0 {
+			continue
+		}
+		// Use the primary IP configuration of the primary interface.
+		nicID := interfaces[0].ID
+		for _, n := range interfaces {
+			if n.Properties.Primary {
+				nicID = n.ID
+			}
+		}
+		nic, ok := nicByID[strings.ToLower(nicID)]
+		if !ok || len(nic.Properties.IPConfigurations) == 0 {
+			continue
+		}
+		configs := nic.Properties.IPConfigurations
+		ipConfig := configs[0].Properties
+		for _, c := range configs {
+			if c.Properties.Primary {
+				ipConfig = c.Properties
+			}
+		}
+		public := publicIPs[strings.ToLower(ipConfig.PublicIPAddress.ID)]
+		if h, ok := d.host(vm.Name, ipConfig.PrivateIPAddress, public, vm.Tags); ok {
+			hosts = append(hosts, h)
+		}
+	}
+	slices.SortFunc(hosts, func(a, b HostConfig) int { return strings.Compare(a.DisplayName, b.DisplayName) })
+	return hosts, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A backup is a gzipped tar archive of what a server needs to carry on
+// elsewhere:
+//
+//	manifest.json    when and where it was made
+//	config.yaml      the config file
+//	state/*          the files of the history directory: host edits,
+//	                 added and paused hosts, UI settings, API tokens,
+//	                 devices, the audit log, the days exported to
+//	                 Parquet and the incidents
+//	history/*        the day files of the history, if asked for
+//
+// `netmonitor restore` unpacks it on the new server.
+
+// stateFiles are the files of the history directory a backup includes.
+var stateFiles = []string{"hosts.json", "added-hosts.json", "paused.json", "ui.json", "tokens.json", "devices.json", "audit.jsonl", parquetStateFile}
+
+// incidentsFile holds the incidents of a backup until the restored server
+// reads them on startup.
+const incidentsFile = "incidents.json"
+
+// BackupManifest describes a backup.
+type BackupManifest struct {
+	Created time.Time `json:"created"`
+	Server  string    `json:"server,omitempty"`
+	Config  bool      `json:"config"`
+	History bool      `json:"history"`
+}
+
+// handleBackup serves GET /api/backup[?history=true], the archive of the
+// config and state, and with history=true the probe history too.
+func (m *Monitor) handleBackup(w http.ResponseWriter, r *http.Request) {
+	withHistory := r.URL.Query().Get("history") == "true"
+	dir := m.config.HistoryDir
+	if withHistory && dir == "" {
+		writeError(w, http.StatusBadRequest, "no history directory is configured")
+		return
+	}
+	name := fmt.Sprintf("netmonitor-backup-%s.tar.gz", time.Now().Format("2006-01-02-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	m.auditRequest(r, "backup.create", name, nil, map[string]bool{"history": withHistory})
+	if err := m.writeBackup(w, withHistory); err != nil {
+		// Too late for an error response; the archive ends early.
+		log.Printf("Writing backup failed: %v", err)
+	}
+}
+
+func (m *Monitor) writeBackup(w io.Writer, withHistory bool) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	manifest := BackupManifest{Created: now, History: withHistory}
+	manifest.Server, _ = os.Hostname()
+	var config []byte
+	if m.configPath != "" {
+		var err error
+		if config, err = os.ReadFile(m.configPath); err != nil {
+			return err
+		}
+		manifest.Config = true
+	}
+	b, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := add("manifest.json", b); err != nil {
+		return err
+	}
+	if config != nil {
+		if err := add("config.yaml", config); err != nil {
+			return err
+		}
+	}
+
+	b, _ = json.MarshalIndent(m.incidents.list(false), "", "  ")
+	if err := add("state/"+incidentsFile, b); err != nil {
+		return err
+	}
+	if dir := m.config.HistoryDir; dir != "" {
+		for _, name := range stateFiles {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := add("state/"+name, data); err != nil {
+				return err
+			}
+		}
+		if withHistory {
+			for _, f := range historyFiles(dir) {
+				data, err := os.ReadFile(f.path)
+				if os.IsNotExist(err) {
+					continue // compacted meanwhile, and in the list too
+				}
+				if err != nil {
+					return err
+				}
+				if err := add("history/"+filepath.Base(f.path), data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// runRestore unpacks a backup for `netmonitor restore`.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("config", "netmonitor.yaml", "Where to write the config file of the backup")
+	historyDir := flags.String("history-dir", "", "Directory to restore the state and history into (history_dir of the config file if empty)")
+	force := flags.Bool("force", false, "Overwrite files that exist")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: netmonitor restore [-config FILE] [-history-dir DIR] [-force] ARCHIVE\n\n")
+		fmt.Fprintf(flags.Output(), "Restores a backup from /api/backup, or from standard input with ARCHIVE -, e.g.\n")
+		fmt.Fprintf(flags.Output(), "  netmonitor ctl -server http://old:8080 backup -history | netmonitor restore -\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var in io.Reader = os.Stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	files, err := readBackup(in)
+	if err != nil {
+		return err
+	}
+
+	var writes []restoreFile
+	if config, ok := files["config.yaml"]; ok {
+		writes = append(writes, restoreFile{*configPath, config})
+		if *historyDir == "" {
+			var cfg Config
+			if err := yaml.Unmarshal(config, &cfg); err != nil {
+				return fmt.Errorf("config.yaml: %v", err)
+			}
+			*historyDir = cfg.HistoryDir
+		}
+	}
+	for name, data := range files {
+		dir, base := path.Split(name)
+		if dir != "state/" && dir != "history/" {
+			continue
+		}
+		if *historyDir == "" {
+			return errors.New("the backup has state but no history directory to restore it into, use -history-dir")
+		}
+		writes = append(writes, restoreFile{filepath.Join(*historyDir, base), data})
+	}
+	slices.SortFunc(writes, func(a, b restoreFile) int { return strings.Compare(a.path, b.path) })
+	if !*force {
+		for _, f := range writes {
+			if _, err := os.Stat(f.path); err == nil {
+				return fmt.Errorf("%s exists, use -force to overwrite it", f.path)
+			}
+		}
+	}
+	if *historyDir != "" {
+		if err := os.MkdirAll(*historyDir, 0755); err != nil {
+			return err
+		}
+	}
+	for _, f := range writes {
+		if err := os.WriteFile(f.path, f.data, 0644); err != nil {
+			return err
+		}
+		fmt.Println("restored", f.path)
+	}
+	return nil
+}
+
+type restoreFile struct {
+	path string
+	data []byte
+}
+
+// readBackup returns the files of a backup by name, checking that each is
+// one a backup has.
+func readBackup(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string][]byte{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		dir, base := path.Split(h.Name)
+		switch {
+		case dir == "" && (base == "manifest.json" || base == "config.yaml"):
+		case dir == "state/" && (strings.HasSuffix(base, ".json") || strings.HasSuffix(base, ".jsonl")):
+		case dir == "history/" && (strings.HasSuffix(base, ".jsonl") || strings.HasSuffix(base, ".chunks")):
+		default:
+			return nil, fmt.Errorf("unexpected file %q in backup", h.Name)
+		}
+		if strings.HasPrefix(base, ".") || base == "" {
+			return nil, fmt.Errorf("unexpected file %q in backup", h.Name)
+		}
+		if files[h.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := files["manifest.json"]; !ok {
+		return nil, errors.New("not a backup: no manifest.json")
+	}
+	return files, nil
+}
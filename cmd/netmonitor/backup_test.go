@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	src := t.TempDir()
+	configPath := filepath.Join(src, "netmonitor.yaml")
+	historyDir := filepath.Join(src, "history")
+	files := map[string]string{
+		configPath:                                     "hosts:\n  - target: 192.0.2.1\nhistory_dir: " + historyDir + "\n",
+		filepath.Join(historyDir, "ui.json"):           `{"title": "Lab"}`,
+		filepath.Join(historyDir, "paused.json"):       `["192.0.2.1"]`,
+		filepath.Join(historyDir, "2026-01-01.chunks"): "chunks",
+		filepath.Join(historyDir, "2026-01-02.jsonl"):  "{}\n",
+		filepath.Join(historyDir, "notes.txt"):         "not part of a backup",
+	}
+	os.Mkdir(historyDir, 0o755)
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := NewMonitor(nil, 0, time.Minute)
+	m.config = &Config{HistoryDir: historyDir}
+	m.configPath = configPath
+
+	tests := []struct {
+		history bool
+		want    []string
+	}{
+		{false, []string{"config.yaml", "manifest.json", "state/incidents.json", "state/paused.json", "state/ui.json"}},
+		{true, []string{"config.yaml", "history/2026-01-01.chunks", "history/2026-01-02.jsonl", "manifest.json", "state/incidents.json", "state/paused.json", "state/ui.json"}},
+	}
+	for _, tt := range tests {
+		var archive bytes.Buffer
+		if err := m.writeBackup(&archive, tt.history); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readBackup(bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatalf("history %v: %v", tt.history, err)
+		}
+		if names := slices.Sorted(maps.Keys(got)); !slices.Equal(names, tt.want) {
+			t.Errorf("history %v: files %q, want %q", tt.history, names, tt.want)
+		}
+		var manifest BackupManifest
+		if err := json.Unmarshal(got["manifest.json"], &manifest); err != nil || !manifest.Config || manifest.History != tt.history {
+			t.Errorf("history %v: manifest %+v, %v", tt.history, manifest, err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := m.writeBackup(&archive, true); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(src, "backup.tar.gz")
+	if err := os.WriteFile(archivePath, archive.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	args := []string{"-config", filepath.Join(dst, "netmonitor.yaml"), "-history-dir", filepath.Join(dst, "history"), archivePath}
+	if err := runRestore(args); err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range files {
+		restored := filepath.Join(dst, strings.TrimPrefix(path, src))
+		data, err := os.ReadFile(restored)
+		if strings.HasSuffix(path, ".txt") {
+			if err == nil {
+				t.Errorf("%s restored", restored)
+			}
+			continue
+		}
+		if err != nil || string(data) != content {
+			t.Errorf("%s: %q, %v; want %q", restored, data, err, content)
+		}
+	}
+	if err := runRestore(args); err == nil || !strings.Contains(err.Error(), "use -force") {
+		t.Errorf("restoring over files: error %v, want a refusal", err)
+	}
+	if err := runRestore(append([]string{"-force"}, args...)); err != nil {
+		t.Errorf("restoring with -force: %v", err)
+	}
+}
+
+func TestReadBackup(t *testing.T) {
+	archive := func(names ...string) []byte {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gz)
+		for _, name := range names {
+			if strings.HasSuffix(name, "/") {
+				tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0o755})
+				continue
+			}
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 2})
+			tw.Write([]byte("{}"))
+		}
+		tw.Close()
+		gz.Close()
+		return b.Bytes()
+	}
+	tests := []struct {
+		name    string
+		archive []byte
+		err     string
+	}{
+		{"backup", archive("manifest.json", "config.yaml", "state/", "state/ui.json", "history/2026-01-01.jsonl"), ""},
+		{"no manifest", archive("config.yaml"), "no manifest.json"},
+		{"not gzip", []byte("manifest.json"), "not a backup"},
+		{"outside the state", archive("manifest.json", "state/../../etc/cron.d/x.json"), "unexpected file"},
+		{"absolute", archive("manifest.json", "/etc/netmonitor.yaml"), "unexpected file"},
+		{"hidden", archive("manifest.json", "state/.json"), "unexpected file"},
+		{"other kind of file", archive("manifest.json", "history/run.sh"), "unexpected file"},
+	}
+	for _, tt := range tests {
+		_, err := readBackup(bytes.NewReader(tt.archive))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestHandleBackup(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	m := NewMonitor(nil, 0, time.Minute)
+	m.config = &Config{}
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/backup", http.StatusOK},
+		{"/api/backup?history=true", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.path, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		files, err := readBackup(rec.Body)
+		if _, ok := files["config.yaml"]; err != nil || ok {
+			t.Errorf("%s: %v, config %v; want a backup without a config file", tt.path, err, ok)
+		}
+	}
+}
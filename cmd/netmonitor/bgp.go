@@ -0,0 +1,595 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// BGPConfig watches how the rest of the Internet routes our prefixes and
+// alerts when they are announced by another AS or withdrawn by many peers:
+//
+//	bgp:
+//	  enabled: true
+//	  prefixes:
+//	    - prefix: 192.0.2.0/24
+//	      origins: [64500]
+//	    - prefix: 2001:db8::/32
+//	  tags: [routing]
+//
+// Updates come from RIPE RIS Live, whose collectors peer with hundreds of
+// networks, or from the routers of the network itself over BMP (RFC 7854),
+// or both. Origins lists the ASes allowed to originate a prefix and its
+// more specifics; without them, the origin of the first announcement seen
+// is taken as the expected one. RIS Live only sends changes, so
+// visibility is judged by the peers withdrawing a prefix: once
+// WithdrawnPeers of them have, an alert is sent, and another once enough
+// announce it again.
+type BGPConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Prefixes []BGPPrefixConfig `yaml:"prefixes"`
+
+	// RISLive is the RIS Live websocket, used unless only BMP is set.
+	RISLive string `yaml:"ris_live"`
+	// BMP is the TCP address, such as :11019, routers send BMP to.
+	BMP string `yaml:"bmp"`
+
+	WithdrawnPeers int      `yaml:"withdrawn_peers"` // 10 unless set
+	Tags           []string `yaml:"tags"`
+	Severity       string   `yaml:"severity"` // critical unless set
+}
+
+// BGPPrefixConfig is a prefix to watch and the ASes expected to originate
+// it.
+type BGPPrefixConfig struct {
+	Prefix  string   `yaml:"prefix"`
+	Origins []uint32 `yaml:"origins"`
+}
+
+const (
+	defaultRISLive        = "wss://ris-live.ripe.net/v1/ws/?client=netmonitor"
+	defaultWithdrawnPeers = 10
+	maxBGPEvents          = 200
+)
+
+// Statuses of a watched prefix.
+const (
+	bgpStatusUnknown   = "unknown" // no update seen yet
+	bgpStatusOK        = "ok"
+	bgpStatusOrigin    = "unexpected_origin"
+	bgpStatusWithdrawn = "withdrawn"
+)
+
+// BGPEvent is a change in how a watched prefix is routed, with the
+// monitored hosts inside the prefix and their status at the time.
+type BGPEvent struct {
+	Time     time.Time `json:"time"`
+	Prefix   string    `json:"prefix"`
+	Status   string    `json:"status"`
+	Resolved bool      `json:"resolved,omitempty"`
+	Message  string    `json:"message"`
+
+	// Route and Origin are the announcement with an unexpected origin,
+	// which may be more specific than Prefix.
+	Route  string `json:"route,omitempty"`
+	Origin uint32 `json:"origin,omitempty"`
+
+	// Peers announce the prefix and Withdrawn peers withdrew it.
+	Peers     int `json:"peers"`
+	Withdrawn int `json:"withdrawn"`
+
+	Hosts []BGPHost `json:"hosts,omitempty"`
+}
+
+// BGPHost is a monitored host whose address is inside a watched prefix.
+type BGPHost struct {
+	Host   string `json:"host"`
+	Status string `json:"status"`
+}
+
+// BGPPrefix is the state of a watched prefix served by /api/bgp.
+type BGPPrefix struct {
+	Prefix    string      `json:"prefix"`
+	Status    string      `json:"status"`
+	Origins   []uint32    `json:"origins"`
+	Seen      []BGPOrigin `json:"seen"`
+	Peers     int         `json:"peers"`
+	Withdrawn int         `json:"withdrawn"`
+	Updated   time.Time   `json:"updated,omitzero"`
+	Hosts     []BGPHost   `json:"hosts,omitempty"`
+}
+
+// BGPOrigin is an announcement of a watched prefix or one of its more
+// specifics, and how many peers carry it.
+type BGPOrigin struct {
+	Route  string `json:"route"`
+	Origin uint32 `json:"origin"`
+	Peers  int    `json:"peers"`
+}
+
+// bgpUpdate is what one BGP update says: the routes a peer announces with
+// the AS that originates them, and those it withdraws.
+type bgpUpdate struct {
+	peer      string
+	origin    uint32 // 0 when it cannot be told, as with AS sets
+	announced []netip.Prefix
+	withdrawn []netip.Prefix
+}
+
+type bgpRouteKey struct {
+	peer  string
+	route netip.Prefix
+}
+
+type bgpRoute struct {
+	origin    uint32
+	withdrawn bool
+}
+
+type bgpOriginKey struct {
+	route  netip.Prefix
+	origin uint32
+}
+
+// watchedPrefix holds the routes of every peer to a prefix and its more
+// specifics, and the alerts it has open.
+type watchedPrefix struct {
+	prefix     netip.Prefix
+	origins    []uint32
+	routes     map[bgpRouteKey]bgpRoute
+	unexpected map[bgpOriginKey]bool
+	lost       bool
+	updated    time.Time
+}
+
+// bgpWatcher tracks the watched prefixes from the updates of every source.
+type bgpWatcher struct {
+	risURL         string
+	bmp            net.Listener
+	withdrawnPeers int
+	tags           []string
+	severity       string
+
+	mu       sync.Mutex
+	prefixes []*watchedPrefix
+	events   []BGPEvent // oldest first
+}
+
+func newBGPWatcher(cfg BGPConfig) (*bgpWatcher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if len(cfg.Prefixes) == 0 {
+		return nil, errors.New("no prefixes to watch")
+	}
+	w := &bgpWatcher{
+		risURL:         cfg.RISLive,
+		withdrawnPeers: cmp.Or(cfg.WithdrawnPeers, defaultWithdrawnPeers),
+		tags:           cfg.Tags,
+		severity:       cmp.Or(cfg.Severity, severityCritical),
+	}
+	if _, ok := severityRank[w.severity]; !ok {
+		return nil, fmt.Errorf("unknown severity %q", w.severity)
+	}
+	for _, p := range cfg.Prefixes {
+		prefix, err := netip.ParsePrefix(p.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		w.prefixes = append(w.prefixes, &watchedPrefix{
+			prefix:     prefix.Masked(),
+			origins:    slices.Clone(p.Origins),
+			routes:     map[bgpRouteKey]bgpRoute{},
+			unexpected: map[bgpOriginKey]bool{},
+		})
+	}
+	if cfg.RISLive == "" && cfg.BMP == "" {
+		w.risURL = defaultRISLive
+	}
+	if cfg.BMP != "" {
+		ln, err := net.Listen("tcp", cfg.BMP)
+		if err != nil {
+			return nil, err
+		}
+		w.bmp = ln
+	}
+	return w, nil
+}
+
+// covers says whether a route is the prefix or one of its more specifics.
+func (p *watchedPrefix) covers(route netip.Prefix) bool {
+	return route.Addr().Is4() == p.prefix.Addr().Is4() && route.Bits() >= p.prefix.Bits() && p.prefix.Contains(route.Addr())
+}
+
+// update applies an update to the prefixes it concerns and returns the
+// events it caused.
+func (w *bgpWatcher) update(u bgpUpdate, now time.Time) []BGPEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []BGPEvent
+	for _, p := range w.prefixes {
+		changed := false
+		for _, route := range u.announced {
+			if !p.covers(route) {
+				continue
+			}
+			if len(p.origins) == 0 && u.origin != 0 {
+				log.Printf("Learned origin AS%d for %s", u.origin, p.prefix)
+				p.origins = []uint32{u.origin}
+			}
+			p.routes[bgpRouteKey{u.peer, route}] = bgpRoute{origin: u.origin}
+			changed = true
+		}
+		for _, route := range u.withdrawn {
+			key := bgpRouteKey{u.peer, route}
+			if r, ok := p.routes[key]; ok {
+				r.withdrawn = true
+				p.routes[key] = r
+				changed = true
+			} else if p.covers(route) {
+				p.routes[key] = bgpRoute{withdrawn: true}
+				changed = true
+			}
+		}
+		if changed {
+			p.updated = now
+			events = append(events, w.evaluateLocked(p, now)...)
+		}
+	}
+	return events
+}
+
+// peerDown withdraws every route of a peer whose session went down.
+func (w *bgpWatcher) peerDown(peer string, now time.Time) []BGPEvent {
+	return w.dropRoutes(now, func(k bgpRouteKey, r *bgpRoute) bool {
+		if k.peer == peer {
+			r.withdrawn = true
+		}
+		return false
+	})
+}
+
+// forget drops the routes of the peers a source, such as a BMP session
+// that ended, no longer tells about.
+func (w *bgpWatcher) forget(source string, now time.Time) []BGPEvent {
+	return w.dropRoutes(now, func(k bgpRouteKey, _ *bgpRoute) bool {
+		return strings.HasPrefix(k.peer, source+" ")
+	})
+}
+
+// dropRoutes passes every route to f, which may change it or ask for it
+// to be removed, and returns the events that caused.
+func (w *bgpWatcher) dropRoutes(now time.Time, f func(bgpRouteKey, *bgpRoute) bool) []BGPEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var events []BGPEvent
+	for _, p := range w.prefixes {
+		changed := false
+		for k, r := range p.routes {
+			before := r
+			if f(k, &r) {
+				delete(p.routes, k)
+				changed = true
+			} else if r != before {
+				p.routes[k] = r
+				changed = true
+			}
+		}
+		if changed {
+			p.updated = now
+			events = append(events, w.evaluateLocked(p, now)...)
+		}
+	}
+	return events
+}
+
+// countLocked returns the peers that announce a prefix, or any of its
+// more specifics, and those that withdrew all of their routes to it, along
+// with the announcements by route and origin. w.mu must be held.
+func (p *watchedPrefix) countLocked() (peers, withdrawn int, seen map[bgpOriginKey]int) {
+	announcing := map[string]bool{}
+	seen = map[bgpOriginKey]int{}
+	for k, r := range p.routes {
+		if r.withdrawn {
+			if _, ok := announcing[k.peer]; !ok {
+				announcing[k.peer] = false
+			}
+			continue
+		}
+		announcing[k.peer] = true
+		seen[bgpOriginKey{k.route, r.origin}]++
+	}
+	for _, a := range announcing {
+		if a {
+			peers++
+		} else {
+			withdrawn++
+		}
+	}
+	return peers, withdrawn, seen
+}
+
+// evaluateLocked compares a prefix with what is expected of it and
+// returns the events for the alerts that open or close. w.mu must be held.
+func (w *bgpWatcher) evaluateLocked(p *watchedPrefix, now time.Time) []BGPEvent {
+	peers, withdrawn, seen := p.countLocked()
+	event := func(status, message string) BGPEvent {
+		return BGPEvent{Time: now, Prefix: p.prefix.String(), Status: status, Message: message, Peers: peers, Withdrawn: withdrawn}
+	}
+	var events []BGPEvent
+	for k, n := range seen {
+		if k.origin == 0 || slices.Contains(p.origins, k.origin) || p.unexpected[k] {
+			continue
+		}
+		p.unexpected[k] = true
+		e := event(bgpStatusOrigin, fmt.Sprintf("%s announced by AS%d instead of %s, seen by %d peers", k.route, k.origin, formatASNs(p.origins), n))
+		e.Route, e.Origin = k.route.String(), k.origin
+		events = append(events, e)
+	}
+	for k := range p.unexpected {
+		if seen[k] > 0 {
+			continue
+		}
+		delete(p.unexpected, k)
+		e := event(bgpStatusOK, fmt.Sprintf("AS%d no longer announces %s", k.origin, k.route))
+		e.Route, e.Origin, e.Resolved = k.route.String(), k.origin, true
+		events = append(events, e)
+	}
+	switch {
+	case !p.lost && withdrawn >= w.withdrawnPeers:
+		p.lost = true
+		events = append(events, event(bgpStatusWithdrawn, fmt.Sprintf("withdrawn by %d peers, %d still announce it", withdrawn, peers)))
+	case p.lost && withdrawn < w.withdrawnPeers:
+		p.lost = false
+		e := event(bgpStatusOK, fmt.Sprintf("announced again, %d peers announce it and %d withdrew it", peers, withdrawn))
+		e.Resolved = true
+		events = append(events, e)
+	}
+	return events
+}
+
+func formatASNs(asns []uint32) string {
+	s := make([]string, len(asns))
+	for i, asn := range asns {
+		s[i] = "AS" + strconv.FormatUint(uint64(asn), 10)
+	}
+	return strings.Join(s, ", ")
+}
+
+// record keeps an event for /api/bgp.
+func (w *bgpWatcher) record(e BGPEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = append(w.events, e)
+	if len(w.events) > maxBGPEvents {
+		w.events = slices.Delete(w.events, 0, len(w.events)-maxBGPEvents)
+	}
+}
+
+// state returns the watched prefixes and the recent events.
+func (w *bgpWatcher) state() ([]BGPPrefix, []BGPEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefixes := make([]BGPPrefix, len(w.prefixes))
+	for i, p := range w.prefixes {
+		peers, withdrawn, seen := p.countLocked()
+		s := BGPPrefix{
+			Prefix:    p.prefix.String(),
+			Status:    bgpStatusOK,
+			Origins:   slices.Clone(p.origins),
+			Seen:      []BGPOrigin{},
+			Peers:     peers,
+			Withdrawn: withdrawn,
+			Updated:   p.updated,
+		}
+		for k, n := range seen {
+			s.Seen = append(s.Seen, BGPOrigin{Route: k.route.String(), Origin: k.origin, Peers: n})
+		}
+		slices.SortFunc(s.Seen, func(a, b BGPOrigin) int {
+			return cmp.Or(strings.Compare(a.Route, b.Route), cmp.Compare(a.Origin, b.Origin))
+		})
+		switch {
+		case len(p.unexpected) > 0:
+			s.Status = bgpStatusOrigin
+		case p.lost:
+			s.Status = bgpStatusWithdrawn
+		case len(p.routes) == 0:
+			s.Status = bgpStatusUnknown
+		}
+		prefixes[i] = s
+	}
+	return prefixes, slices.Clone(w.events)
+}
+
+// applyBGP records the events an update caused and alerts about them.
+func (m *Monitor) applyBGP(w *bgpWatcher, events []BGPEvent) {
+	for _, e := range events {
+		prefix := netip.MustParsePrefix(e.Prefix)
+		e.Hosts = m.prefixHosts(prefix)
+		log.Printf("BGP: %s: %s", e.Prefix, e.Message)
+		w.record(e)
+		go m.notify(m.bgpAlert(w, e))
+	}
+}
+
+// prefixHosts returns the monitored hosts whose address is inside prefix,
+// so an event shows whether the hosts it concerns are still reachable.
+func (m *Monitor) prefixHosts(prefix netip.Prefix) []BGPHost {
+	hosts := []BGPHost{}
+	hs := m.hosts()
+	for _, t := range hs.targets {
+		s := hs.stats[t.name].load()
+		addr, err := netip.ParseAddr(s.Address)
+		if err != nil {
+			if addr, err = netip.ParseAddr(t.host); err != nil {
+				continue
+			}
+		}
+		if prefix.Contains(addr.Unmap()) {
+			hosts = append(hosts, BGPHost{Host: t.name, Status: s.Status})
+		}
+	}
+	return hosts
+}
+
+// bgpAlert announces an event through the notifiers.
+func (m *Monitor) bgpAlert(w *bgpWatcher, e BGPEvent) Alert {
+	previous := bgpStatusOK
+	if e.Resolved {
+		previous = bgpStatusOrigin
+		if e.Route == "" {
+			previous = bgpStatusWithdrawn
+		}
+	}
+	severity := w.severity
+	if e.Resolved {
+		severity = severityInfo
+	}
+	return Alert{
+		Host:         e.Prefix,
+		Status:       e.Status,
+		Previous:     previous,
+		Severity:     severity,
+		Resolved:     e.Resolved,
+		Message:      e.Message,
+		Time:         e.Time,
+		Stats:        PingStats{Host: e.Prefix, Status: e.Status, Tags: w.tags},
+		DashboardURL: m.dashboardURL,
+		Route:        &e,
+	}
+}
+
+func (m *Monitor) handleBGP(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Prefixes []BGPPrefix `json:"prefixes"`
+		Events   []BGPEvent  `json:"events"`
+	}
+	if m.bgpWatcher == nil {
+		writeJSON(w, http.StatusOK, response{Prefixes: []BGPPrefix{}, Events: []BGPEvent{}})
+		return
+	}
+	prefixes, events := m.bgpWatcher.state()
+	for i, p := range prefixes {
+		prefixes[i].Hosts = m.prefixHosts(netip.MustParsePrefix(p.Prefix))
+	}
+	writeJSON(w, http.StatusOK, response{Prefixes: prefixes, Events: events})
+}
+
+// risMessage is a message of the RIS Live protocol.
+type risMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// risUpdate is the data of a ris_message of type UPDATE. The last element
+// of the path is the origin AS, or an AS set as an array.
+type risUpdate struct {
+	Type          string            `json:"type"`
+	Host          string            `json:"host"` // the collector
+	Peer          string            `json:"peer"`
+	Path          []json.RawMessage `json:"path"`
+	Announcements []struct {
+		Prefixes []string `json:"prefixes"`
+	} `json:"announcements"`
+	Withdrawals []string `json:"withdrawals"`
+}
+
+// watchRISLive follows RIS Live, connecting again a minute after it fails.
+func (m *Monitor) watchRISLive(w *bgpWatcher) {
+	for {
+		err := m.readRISLive(w)
+		log.Printf("RIS Live: %v", err)
+		time.Sleep(time.Minute)
+	}
+}
+
+func (m *Monitor) readRISLive(w *bgpWatcher) error {
+	ws, err := websocket.Dial(w.risURL, "", "https://netmonitor/")
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	for _, p := range w.prefixes {
+		sub := map[string]any{"prefix": p.prefix.String(), "moreSpecific": true, "type": "UPDATE"}
+		data, _ := json.Marshal(sub)
+		if err := websocket.JSON.Send(ws, risMessage{Type: "ris_subscribe", Data: data}); err != nil {
+			return err
+		}
+	}
+	log.Printf("Subscribed to %d prefixes on RIS Live", len(w.prefixes))
+
+	// Prefixes that are stable can go quiet for hours, so pings tell a
+	// connection that died.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		tick := time.NewTicker(30 * time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				websocket.JSON.Send(ws, risMessage{Type: "ping"})
+			}
+		}
+	}()
+	for {
+		ws.SetReadDeadline(time.Now().Add(2 * time.Minute))
+		var msg risMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "ris_message":
+			var data risUpdate
+			if err := json.Unmarshal(msg.Data, &data); err != nil || data.Type != "UPDATE" {
+				continue
+			}
+			m.applyBGP(w, w.update(data.update(), time.Now()))
+		case "ris_error":
+			var data struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(msg.Data, &data)
+			return errors.New(data.Message)
+		}
+	}
+}
+
+// update converts a RIS Live update.
+func (d risUpdate) update() bgpUpdate {
+	u := bgpUpdate{peer: "ris/" + d.Host + " " + d.Peer}
+	if len(d.Path) > 0 {
+		last := d.Path[len(d.Path)-1]
+		var set []uint32
+		if json.Unmarshal(last, &u.origin) != nil && json.Unmarshal(last, &set) == nil && len(set) == 1 {
+			u.origin = set[0]
+		}
+	}
+	for _, a := range d.Announcements {
+		for _, s := range a.Prefixes {
+			if p, err := netip.ParsePrefix(s); err == nil {
+				u.announced = append(u.announced, p.Masked())
+			}
+		}
+	}
+	for _, s := range d.Withdrawals {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			u.withdrawn = append(u.withdrawn, p.Masked())
+		}
+	}
+	return u
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewBGPWatcher(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  BGPConfig
+		err  bool
+	}{
+		{"disabled", BGPConfig{}, false},
+		{"no prefixes", BGPConfig{Enabled: true}, true},
+		{"bad prefix", BGPConfig{Enabled: true, Prefixes: []BGPPrefixConfig{{Prefix: "192.0.2.0"}}}, true},
+		{"bad severity", BGPConfig{Enabled: true, Severity: "loud", Prefixes: []BGPPrefixConfig{{Prefix: "192.0.2.0/24"}}}, true},
+		{"ris live", BGPConfig{Enabled: true, Prefixes: []BGPPrefixConfig{{Prefix: "192.0.2.1/24"}}}, false},
+	}
+	for _, tt := range tests {
+		w, err := newBGPWatcher(tt.cfg)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+		if w != nil && (w.risURL != defaultRISLive || w.withdrawnPeers != defaultWithdrawnPeers || w.prefixes[0].prefix.String() != "192.0.2.0/24") {
+			t.Errorf("%s: watcher %+v", tt.name, w)
+		}
+	}
+}
+
+func TestBGPWatcher(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	w, err := newBGPWatcher(BGPConfig{Enabled: true, WithdrawnPeers: 2, Prefixes: []BGPPrefixConfig{{Prefix: "192.0.2.0/24"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	announce := func(peer string, origin uint32, routes ...string) func(time.Time) []BGPEvent {
+		return func(now time.Time) []BGPEvent {
+			return w.update(bgpUpdate{peer: peer, origin: origin, announced: prefixes(routes...)}, now)
+		}
+	}
+	withdraw := func(peer string, routes ...string) func(time.Time) []BGPEvent {
+		return func(now time.Time) []BGPEvent {
+			return w.update(bgpUpdate{peer: peer, withdrawn: prefixes(routes...)}, now)
+		}
+	}
+
+	type event struct {
+		status   string
+		resolved bool
+		route    string
+	}
+	tests := []struct {
+		name   string
+		apply  func(time.Time) []BGPEvent
+		events []event
+		status string
+	}{
+		{"first announcement", announce("ris/rrc00 a", 64500, "192.0.2.0/24"), nil, bgpStatusOK},
+		{"another prefix", announce("ris/rrc00 a", 64666, "198.51.100.0/24", "2001:db8::/32"), nil, bgpStatusOK},
+		{"second peer", announce("ris/rrc00 b", 64500, "192.0.2.0/24"), nil, bgpStatusOK},
+		{"hijacked more specific", announce("ris/rrc00 c", 64666, "192.0.2.128/25"), []event{{bgpStatusOrigin, false, "192.0.2.128/25"}}, bgpStatusOrigin},
+		{"seen by another peer", announce("ris/rrc00 b", 64666, "192.0.2.128/25"), nil, bgpStatusOrigin},
+		{"one withdraws it", withdraw("ris/rrc00 c", "192.0.2.128/25"), nil, bgpStatusOrigin},
+		{"hijack gone", withdraw("ris/rrc00 b", "192.0.2.128/25"), []event{{bgpStatusOK, true, "192.0.2.128/25"}}, bgpStatusOK},
+		{"withdrawn by two", withdraw("ris/rrc00 a", "192.0.2.0/24"), []event{{bgpStatusWithdrawn, false, ""}}, bgpStatusWithdrawn},
+		{"still withdrawn", withdraw("ris/rrc00 b", "192.0.2.0/24"), nil, bgpStatusWithdrawn},
+		{"announced again", announce("ris/rrc00 a", 64500, "192.0.2.0/24"), nil, bgpStatusWithdrawn},
+		{"below the threshold", announce("ris/rrc00 b", 64500, "192.0.2.0/24"), []event{{bgpStatusOK, true, ""}}, bgpStatusOK},
+		// c still counts as having withdrawn its only route.
+		{"peer down", func(now time.Time) []BGPEvent { return w.peerDown("ris/rrc00 a", now) }, []event{{bgpStatusWithdrawn, false, ""}}, bgpStatusWithdrawn},
+		{"session gone", func(now time.Time) []BGPEvent { return w.forget("ris/rrc00", now) }, []event{{bgpStatusOK, true, ""}}, bgpStatusUnknown},
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		now = now.Add(time.Minute)
+		var got []event
+		for _, e := range tt.apply(now) {
+			if e.Prefix != "192.0.2.0/24" || !e.Time.Equal(now) {
+				t.Errorf("%s: event %+v", tt.name, e)
+			}
+			got = append(got, event{e.Status, e.Resolved, e.Route})
+		}
+		if !slices.Equal(got, tt.events) {
+			t.Errorf("%s: events %+v, want %+v", tt.name, got, tt.events)
+		}
+		if prefixes, _ := w.state(); prefixes[0].Status != tt.status {
+			t.Errorf("%s: status %s, want %s", tt.name, prefixes[0].Status, tt.status)
+		}
+	}
+	if prefixes, _ := w.state(); !slices.Equal(prefixes[0].Origins, []uint32{64500}) {
+		t.Errorf("origins %v, want the first seen", prefixes[0].Origins)
+	}
+}
+
+func TestRISUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		origin    uint32
+		announced []netip.Prefix
+		withdrawn []netip.Prefix
+	}{
+		{"announcement", `{"host": "rrc00", "peer": "192.0.2.1", "path": [64496, 64500], "announcements": [{"prefixes": ["192.0.2.0/24", "2001:db8::/32"]}]}`,
+			64500, prefixes("192.0.2.0/24", "2001:db8::/32"), nil},
+		{"set of one", `{"path": [64496, [64501]], "announcements": [{"prefixes": ["192.0.2.1/24"]}]}`, 64501, prefixes("192.0.2.0/24"), nil},
+		{"set of two", `{"path": [64496, [64501, 64502]], "announcements": [{"prefixes": ["192.0.2.0/24"]}]}`, 0, prefixes("192.0.2.0/24"), nil},
+		{"withdrawal", `{"withdrawals": ["192.0.2.0/24", "not a prefix"]}`, 0, nil, prefixes("192.0.2.0/24")},
+	}
+	for _, tt := range tests {
+		var d risUpdate
+		if err := json.Unmarshal([]byte(tt.data), &d); err != nil {
+			t.Fatal(err)
+		}
+		u := d.update()
+		if u.origin != tt.origin || !slices.Equal(u.announced, tt.announced) || !slices.Equal(u.withdrawn, tt.withdrawn) {
+			t.Errorf("%s: origin %d, announced %v, withdrawn %v", tt.name, u.origin, u.announced, u.withdrawn)
+		}
+	}
+	if d := (risUpdate{Host: "rrc00", Peer: "192.0.2.1"}); d.update().peer != "ris/rrc00 192.0.2.1" {
+		t.Errorf("peer %q", d.update().peer)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlackoutConfig takes maintenance windows from an iCal calendar, such as
+// the change calendar of a team, and suppresses alerts and actions for
+// hosts while one of its events is going on:
+//
+//	blackouts:
+//	  - calendar: https://calendar.example.com/changes.ics
+//	    match: "(?i)maintenance|change"
+//	    tags: [prod]
+//
+// Calendar is an http(s) or webcal URL or a file. Match narrows down the
+// events by their summary, and Hosts and Tags the hosts they apply to,
+// every host if neither is set.
+type BlackoutConfig struct {
+	Calendar string        `yaml:"calendar"`
+	Refresh  time.Duration `yaml:"refresh"` // 15 minutes unless set
+	Match    string        `yaml:"match"`
+	Hosts    []string      `yaml:"hosts"`
+	Tags     []string      `yaml:"tags"`
+}
+
+const defaultBlackoutRefresh = 15 * time.Minute
+
+// blackoutHorizon is how far ahead occurrences of recurring events are
+// expanded on each refresh.
+const blackoutHorizon = 7 * 24 * time.Hour
+
+var blackoutClient = &http.Client{Timeout: 30 * time.Second}
+
+// blackoutCalendar is a BlackoutConfig and the occurrences of its events
+// around now.
+type blackoutCalendar struct {
+	BlackoutConfig
+	match *regexp.Regexp
+
+	mu          sync.Mutex
+	occurrences []occurrence
+}
+
+type blackouts []*blackoutCalendar
+
+func newBlackouts(configs []BlackoutConfig) (blackouts, error) {
+	var b blackouts
+	for i, c := range configs {
+		if c.Calendar == "" {
+			return nil, fmt.Errorf("blackout %d: calendar is required", i+1)
+		}
+		cal := &blackoutCalendar{BlackoutConfig: c}
+		cal.Refresh = cmp.Or(c.Refresh, defaultBlackoutRefresh)
+		if c.Match != "" {
+			var err error
+			if cal.match, err = regexp.Compile(c.Match); err != nil {
+				return nil, fmt.Errorf("blackout %d: match: %v", i+1, err)
+			}
+		}
+		b = append(b, cal)
+	}
+	return b, nil
+}
+
+// run loads every calendar and keeps reloading it. A calendar that fails
+// to load keeps its last events.
+func (b blackouts) run(clock Clock) {
+	for _, cal := range b {
+		go func() {
+			ticks, stop := clock.NewTicker(cal.Refresh)
+			defer stop()
+			for {
+				if err := cal.load(clock.Now()); err != nil {
+					log.Printf("Blackout calendar %s: %v", cal.name(), err)
+				}
+				<-ticks
+			}
+		}()
+	}
+}
+
+// name is the calendar's location without credentials.
+func (cal *blackoutCalendar) name() string {
+	if u, err := url.Parse(cal.Calendar); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return cal.Calendar
+}
+
+// load fetches the calendar and expands the occurrences of its events
+// from a day back to blackoutHorizon ahead.
+func (cal *blackoutCalendar) load(now time.Time) error {
+	r, err := openCalendar(cal.Calendar)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	events, err := parseCalendar(r)
+	if err != nil {
+		return err
+	}
+	var occurrences []occurrence
+	for _, e := range events {
+		if cal.match != nil && !cal.match.MatchString(e.summary) {
+			continue
+		}
+		occurrences = append(occurrences, e.occurrences(now.Add(-24*time.Hour), now.Add(blackoutHorizon+cal.Refresh))...)
+	}
+	cal.mu.Lock()
+	cal.occurrences = occurrences
+	cal.mu.Unlock()
+	return nil
+}
+
+func openCalendar(location string) (io.ReadCloser, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		return os.Open(strings.TrimPrefix(location, "file://"))
+	}
+	if u.Scheme == "webcal" || u.Scheme == "webcals" {
+		u.Scheme = "https"
+	}
+	resp, err := blackoutClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// active returns the event a host is blacked out by at a time.
+func (b blackouts) active(host string, tags []string, at time.Time) (occurrence, bool) {
+	for _, cal := range b {
+		if len(cal.Hosts) > 0 || len(cal.Tags) > 0 {
+			if !slices.Contains(cal.Hosts, host) && !slices.ContainsFunc(cal.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+				continue
+			}
+		}
+		cal.mu.Lock()
+		i := slices.IndexFunc(cal.occurrences, func(o occurrence) bool { return !at.Before(o.start) && at.Before(o.end) })
+		var o occurrence
+		if i >= 0 {
+			o = cal.occurrences[i]
+		}
+		cal.mu.Unlock()
+		if i >= 0 {
+			return o, true
+		}
+	}
+	return occurrence{}, false
+}
+
+// blackedOut reports whether an alert falls into a blackout, logging that
+// it is suppressed.
+func (m *Monitor) blackedOut(a Alert) bool {
+	o, ok := m.blackouts.active(a.Host, a.Stats.Tags, a.Time)
+	if ok {
+		log.Printf("Alert for %s (%s) suppressed by blackout %q until %s", a.Host, a.Status, o.summary, o.end.Local().Format(time.DateTime))
+	}
+	return ok
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// BMP message types (RFC 7854).
+const (
+	bmpRouteMonitoring = 0
+	bmpPeerDown        = 2
+	bmpTermination     = 5
+
+	bmpCommonHeaderLen = 6
+	bmpPeerHeaderLen   = 42
+	bmpMaxMessage      = 1 << 20
+
+	bmpFlagIPv6      = 0x80
+	bmpFlagPostRIB   = 0x40
+	bmpFlagLegacyASN = 0x20 // the AS_PATH has 2-byte ASNs
+)
+
+// BGP message and path attribute types (RFC 4271, RFC 4760, RFC 6793).
+const (
+	bgpHeaderLen  = 19
+	bgpTypeUpdate = 2
+
+	bgpAttrASPath      = 2
+	bgpAttrMPReach     = 14
+	bgpAttrMPUnreach   = 15
+	bgpAttrAS4Path     = 17
+	bgpAttrExtendedLen = 0x10
+
+	bgpASSet      = 1
+	bgpASSequence = 2
+
+	bgpAFIIPv4 = 1
+	bgpAFIIPv6 = 2
+	bgpSAFIUni = 1
+)
+
+// serveBMP accepts BMP sessions from routers. Every router tells about the
+// peers it has, each counted as a peer of the watched prefixes.
+func (m *Monitor) serveBMP(w *bgpWatcher) {
+	for {
+		conn, err := w.bmp.Accept()
+		if err != nil {
+			log.Printf("BMP: %v", err)
+			return
+		}
+		go m.readBMP(w, conn)
+	}
+}
+
+// readBMP follows one BMP session, forgetting the routes it told about
+// when it ends since nothing tells how they change anymore.
+func (m *Monitor) readBMP(w *bgpWatcher, conn net.Conn) {
+	defer conn.Close()
+	router, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	source := "bmp/" + router
+	log.Printf("BMP session from %s", router)
+	err := m.readBMPMessages(w, source, bufio.NewReader(conn))
+	log.Printf("BMP session from %s ended: %v", router, err)
+	m.applyBGP(w, w.forget(source, time.Now()))
+}
+
+func (m *Monitor) readBMPMessages(w *bgpWatcher, source string, r *bufio.Reader) error {
+	head := make([]byte, bmpCommonHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			return err
+		}
+		if head[0] != 3 {
+			return fmt.Errorf("unsupported BMP version %d", head[0])
+		}
+		n := int(binary.BigEndian.Uint32(head[1:]))
+		if n < bmpCommonHeaderLen || n > bmpMaxMessage {
+			return fmt.Errorf("invalid BMP message length %d", n)
+		}
+		msg := make([]byte, n-bmpCommonHeaderLen)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			return err
+		}
+		switch head[5] {
+		case bmpRouteMonitoring, bmpPeerDown:
+			peer, flags, body, err := parseBMPPeer(msg)
+			if err != nil {
+				return err
+			}
+			peer = source + " " + peer
+			if head[5] == bmpPeerDown {
+				m.applyBGP(w, w.peerDown(peer, time.Now()))
+				continue
+			}
+			u, err := parseBGPUpdate(body, flags&bmpFlagLegacyASN != 0)
+			if err != nil {
+				log.Printf("BMP: %s: %v", peer, err)
+				continue
+			}
+			u.peer = peer
+			m.applyBGP(w, w.update(u, time.Now()))
+		case bmpTermination:
+			return errors.New("terminated by the router")
+		}
+	}
+}
+
+// parseBMPPeer parses the per-peer header that starts most BMP messages
+// and names the peer by its address, and whether its routes are from
+// before or after the router's policy.
+func parseBMPPeer(b []byte) (peer string, flags byte, rest []byte, err error) {
+	if len(b) < bmpPeerHeaderLen {
+		return "", 0, nil, errors.New("short BMP peer header")
+	}
+	flags = b[1]
+	addr := netip.AddrFrom16([16]byte(b[10:26]))
+	if flags&bmpFlagIPv6 == 0 {
+		addr = netip.AddrFrom4([4]byte(b[22:26]))
+	}
+	peer = addr.String()
+	if flags&bmpFlagPostRIB != 0 {
+		peer += " post-policy"
+	}
+	return peer, flags, b[bmpPeerHeaderLen:], nil
+}
+
+// parseBGPUpdate parses a BGP UPDATE message for the unicast routes it
+// announces and withdraws and the AS that originates them. Other messages
+// come out empty.
+func parseBGPUpdate(b []byte, legacyASN bool) (bgpUpdate, error) {
+	var u bgpUpdate
+	if len(b) < bgpHeaderLen+4 || int(binary.BigEndian.Uint16(b[16:])) != len(b) {
+		return u, errors.New("invalid BGP message")
+	}
+	if b[18] != bgpTypeUpdate {
+		return u, nil
+	}
+	b = b[bgpHeaderLen:]
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n+2 {
+		return u, errors.New("invalid BGP update")
+	}
+	var err error
+	if u.withdrawn, err = parseNLRI(b[2:2+n], false); err != nil {
+		return u, err
+	}
+	b = b[2+n:]
+	n = int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return u, errors.New("invalid BGP update")
+	}
+	attrs := b[2 : 2+n]
+	if u.announced, err = parseNLRI(b[2+n:], false); err != nil {
+		return u, err
+	}
+
+	var as4Origin uint32
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return u, errors.New("invalid BGP path attribute")
+		}
+		flags, typ := attrs[0], attrs[1]
+		size, head := int(attrs[2]), 3
+		if flags&bgpAttrExtendedLen != 0 {
+			if len(attrs) < 4 {
+				return u, errors.New("invalid BGP path attribute")
+			}
+			size, head = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		}
+		if len(attrs) < head+size {
+			return u, errors.New("invalid BGP path attribute")
+		}
+		value := attrs[head : head+size]
+		attrs = attrs[head+size:]
+		switch typ {
+		case bgpAttrASPath:
+			u.origin = pathOrigin(value, legacyASN)
+		case bgpAttrAS4Path:
+			as4Origin = pathOrigin(value, false)
+		case bgpAttrMPReach:
+			// AFI, SAFI, the next hop and a reserved byte precede the
+			// routes.
+			if len(value) < 5 || len(value) < 5+int(value[3]) {
+				return u, errors.New("invalid MP_REACH_NLRI")
+			}
+			if v6, ok := unicastAFI(value); ok {
+				routes, err := parseNLRI(value[5+int(value[3]):], v6)
+				if err != nil {
+					return u, err
+				}
+				u.announced = append(u.announced, routes...)
+			}
+		case bgpAttrMPUnreach:
+			if len(value) < 3 {
+				return u, errors.New("invalid MP_UNREACH_NLRI")
+			}
+			if v6, ok := unicastAFI(value); ok {
+				routes, err := parseNLRI(value[3:], v6)
+				if err != nil {
+					return u, err
+				}
+				u.withdrawn = append(u.withdrawn, routes...)
+			}
+		}
+	}
+	// Routers that only speak 2-byte ASNs carry the real path in AS4_PATH.
+	if legacyASN && as4Origin != 0 {
+		u.origin = as4Origin
+	}
+	return u, nil
+}
+
+// unicastAFI says whether multiprotocol routes are IPv4 or IPv6 unicast
+// routes, and which.
+func unicastAFI(b []byte) (v6, ok bool) {
+	afi := binary.BigEndian.Uint16(b)
+	return afi == bgpAFIIPv6, (afi == bgpAFIIPv4 || afi == bgpAFIIPv6) && b[2] == bgpSAFIUni
+}
+
+// parseNLRI parses routes encoded as a length in bits followed by as many
+// bytes of the prefix as it takes.
+func parseNLRI(b []byte, v6 bool) ([]netip.Prefix, error) {
+	var routes []netip.Prefix
+	for len(b) > 0 {
+		bits := int(b[0])
+		size := (bits + 7) / 8
+		var addr [16]byte
+		if (!v6 && bits > 32) || bits > 128 || len(b) < 1+size {
+			return nil, errors.New("invalid BGP route")
+		}
+		copy(addr[:], b[1:1+size])
+		ip := netip.AddrFrom16(addr)
+		if !v6 {
+			ip = netip.AddrFrom4([4]byte(addr[:4]))
+		}
+		routes = append(routes, netip.PrefixFrom(ip, bits).Masked())
+		b = b[1+size:]
+	}
+	return routes, nil
+}
+
+// pathOrigin returns the AS at the end of an AS path: the last of its last
+// sequence, or the only member of a set. Other sets do not tell and give 0.
+func pathOrigin(b []byte, legacyASN bool) uint32 {
+	size := 4
+	if legacyASN {
+		size = 2
+	}
+	var origin uint32
+	for len(b) >= 2 {
+		typ, count := b[0], int(b[1])
+		if len(b) < 2+count*size {
+			return 0
+		}
+		asns := b[2 : 2+count*size]
+		b = b[2+count*size:]
+		if count == 0 || (typ != bgpASSequence && typ != bgpASSet) {
+			continue
+		}
+		last := asns[len(asns)-size:]
+		switch {
+		case typ == bgpASSet && count > 1:
+			origin = 0
+		case size == 2:
+			origin = uint32(binary.BigEndian.Uint16(last))
+		default:
+			origin = binary.BigEndian.Uint32(last)
+		}
+	}
+	return origin
+}
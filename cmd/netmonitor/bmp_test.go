@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bgpMessage frames an UPDATE with withdrawn routes, path attributes and
+// announced routes.
+func bgpMessage(withdrawn, attrs, nlri []byte) []byte {
+	b := bytes.Repeat([]byte{0xff}, 16)
+	b = binary.BigEndian.AppendUint16(b, uint16(bgpHeaderLen+2+len(withdrawn)+2+len(attrs)+len(nlri)))
+	b = append(b, bgpTypeUpdate)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(withdrawn)))
+	b = append(b, withdrawn...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attrs)))
+	b = append(b, attrs...)
+	return append(b, nlri...)
+}
+
+// bgpAttr encodes a transitive path attribute.
+func bgpAttr(typ byte, value []byte) []byte {
+	return append([]byte{0x40, typ, byte(len(value))}, value...)
+}
+
+// asSegment encodes an AS path segment of ASNs of size bytes.
+func asSegment(typ byte, size int, asns ...uint32) []byte {
+	b := []byte{typ, byte(len(asns))}
+	for _, asn := range asns {
+		if size == 2 {
+			b = binary.BigEndian.AppendUint16(b, uint16(asn))
+		} else {
+			b = binary.BigEndian.AppendUint32(b, asn)
+		}
+	}
+	return b
+}
+
+// nlri encodes routes such as 192.0.2.0/24.
+func nlri(routes ...string) []byte {
+	var b []byte
+	for _, s := range routes {
+		p := netip.MustParsePrefix(s)
+		b = append(b, byte(p.Bits()))
+		b = append(b, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+	}
+	return b
+}
+
+func prefixes(routes ...string) []netip.Prefix {
+	var ps []netip.Prefix
+	for _, s := range routes {
+		ps = append(ps, netip.MustParsePrefix(s))
+	}
+	return ps
+}
+
+func TestParseNLRI(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		v6   bool
+		want []netip.Prefix
+		err  bool
+	}{
+		{"ipv4", nlri("192.0.2.0/24", "198.51.100.0/22", "10.0.0.0/8"), false, prefixes("192.0.2.0/24", "198.51.100.0/22", "10.0.0.0/8"), false},
+		{"default route", []byte{0}, false, prefixes("0.0.0.0/0"), false},
+		{"host bits set", []byte{25, 192, 0, 2, 0x81}, false, prefixes("192.0.2.128/25"), false},
+		{"ipv6", nlri("2001:db8::/32", "2001:db8:1::/48"), true, prefixes("2001:db8::/32", "2001:db8:1::/48"), false},
+		{"too long for ipv4", []byte{33, 192, 0, 2, 0, 0}, false, nil, true},
+		{"too long for ipv6", append([]byte{129}, make([]byte, 17)...), true, nil, true},
+		{"truncated", []byte{24, 192, 0}, false, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseNLRI(tt.b, tt.v6)
+		if (err != nil) != tt.err || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestPathOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   []byte
+		legacy bool
+		want   uint32
+	}{
+		{"sequence", asSegment(bgpASSequence, 4, 64496, 64497, 4200000000), false, 4200000000},
+		{"2-byte sequence", asSegment(bgpASSequence, 2, 64496, 64497), true, 64497},
+		{"set of one", append(asSegment(bgpASSequence, 4, 64496), asSegment(bgpASSet, 4, 64500)...), false, 64500},
+		{"set of two", append(asSegment(bgpASSequence, 4, 64496), asSegment(bgpASSet, 4, 64500, 64501)...), false, 0},
+		{"confederation after", append(asSegment(bgpASSequence, 4, 64496), asSegment(3, 4, 65000)...), false, 64496},
+		{"empty", nil, false, 0},
+		{"truncated", asSegment(bgpASSequence, 4, 64496, 64497)[:7], false, 0},
+	}
+	for _, tt := range tests {
+		if got := pathOrigin(tt.path, tt.legacy); got != tt.want {
+			t.Errorf("%s: origin %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseBGPUpdate(t *testing.T) {
+	path := bgpAttr(bgpAttrASPath, asSegment(bgpASSequence, 4, 64496, 64500))
+	mpReach := func(afi uint16, safi byte, routes []byte) []byte {
+		v := binary.BigEndian.AppendUint16(nil, afi)
+		v = append(v, safi, 16)
+		v = append(v, netip.MustParseAddr("2001:db8::1").AsSlice()...)
+		return bgpAttr(bgpAttrMPReach, append(append(v, 0), routes...))
+	}
+	notification := append(bytes.Repeat([]byte{0xff}, 16), 0, 23, 3, 6, 2, 0, 0)
+
+	tests := []struct {
+		name      string
+		msg       []byte
+		legacy    bool
+		origin    uint32
+		announced []netip.Prefix
+		withdrawn []netip.Prefix
+		err       bool
+	}{
+		{"announcement", bgpMessage(nil, path, nlri("192.0.2.0/24", "198.51.100.0/22")), false, 64500, prefixes("192.0.2.0/24", "198.51.100.0/22"), nil, false},
+		{"withdrawal", bgpMessage(nlri("192.0.2.0/24"), nil, nil), false, 0, nil, prefixes("192.0.2.0/24"), false},
+		{"ipv6", bgpMessage(nil, append(slices.Clone(path), mpReach(bgpAFIIPv6, bgpSAFIUni, nlri("2001:db8::/32"))...), nil), false, 64500, prefixes("2001:db8::/32"), nil, false},
+		{"ipv6 withdrawal", bgpMessage(nil, bgpAttr(bgpAttrMPUnreach, append([]byte{0, bgpAFIIPv6, bgpSAFIUni}, nlri("2001:db8::/32")...)), nil), false, 0, nil, prefixes("2001:db8::/32"), false},
+		{"multicast", bgpMessage(nil, append(slices.Clone(path), mpReach(bgpAFIIPv6, 2, nlri("2001:db8::/32"))...), nil), false, 64500, nil, nil, false},
+		{"2-byte router", bgpMessage(nil, append(bgpAttr(bgpAttrASPath, asSegment(bgpASSequence, 2, 64496, 23456)), bgpAttr(bgpAttrAS4Path, asSegment(bgpASSequence, 4, 64496, 4200000000))...), nlri("192.0.2.0/24")),
+			true, 4200000000, prefixes("192.0.2.0/24"), nil, false},
+		{"extended length", bgpMessage(nil, append([]byte{0x50, bgpAttrASPath, 0, 10}, asSegment(bgpASSequence, 4, 64496, 64500)...), nlri("192.0.2.0/24")), false, 64500, prefixes("192.0.2.0/24"), nil, false},
+		{"not an update", notification, false, 0, nil, nil, false},
+		{"wrong length", bgpMessage(nil, path, nlri("192.0.2.0/24"))[:30], false, 0, nil, nil, true},
+		{"withdrawn overrun", bgpMessage([]byte{24, 192, 0, 2}, nil, nil)[:bgpHeaderLen+4], false, 0, nil, nil, true},
+		{"attribute overrun", bgpMessage(nil, []byte{0x40, bgpAttrASPath, 40, 2, 1}, nil), false, 0, nil, nil, true},
+		{"bad route", bgpMessage(nil, path, []byte{40, 192, 0, 2, 0, 0}), false, 0, nil, nil, true},
+		{"bad next hop", bgpMessage(nil, bgpAttr(bgpAttrMPReach, []byte{0, 2, 1, 16, 0}), nil), false, 0, nil, nil, true},
+	}
+	for _, tt := range tests {
+		u, err := parseBGPUpdate(tt.msg, tt.legacy)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if u.origin != tt.origin || !slices.Equal(u.announced, tt.announced) || !slices.Equal(u.withdrawn, tt.withdrawn) {
+			t.Errorf("%s: origin %d, announced %v, withdrawn %v; want %d, %v, %v", tt.name, u.origin, u.announced, u.withdrawn, tt.origin, tt.announced, tt.withdrawn)
+		}
+	}
+}
+
+// bmpPeerHeader encodes the per-peer header of a BMP message.
+func bmpPeerHeader(flags byte, addr string) []byte {
+	b := make([]byte, bmpPeerHeaderLen)
+	b[1] = flags
+	a := netip.MustParseAddr(addr)
+	if a.Is4() {
+		copy(b[22:26], a.AsSlice())
+	} else {
+		copy(b[10:26], a.AsSlice())
+	}
+	return b
+}
+
+// bmpMessage frames a BMP message.
+func bmpMessage(typ byte, body ...[]byte) []byte {
+	b := slices.Concat(body...)
+	head := binary.BigEndian.AppendUint32([]byte{3}, uint32(bmpCommonHeaderLen+len(b)))
+	return append(append(head, typ), b...)
+}
+
+func TestParseBMPPeer(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		peer   string
+		err    bool
+	}{
+		{"ipv4", bmpPeerHeader(0, "192.0.2.1"), "192.0.2.1", false},
+		{"ipv6 after policy", bmpPeerHeader(bmpFlagIPv6|bmpFlagPostRIB, "2001:db8::1"), "2001:db8::1 post-policy", false},
+		{"short", bmpPeerHeader(0, "192.0.2.1")[:30], "", true},
+	}
+	for _, tt := range tests {
+		peer, _, rest, err := parseBMPPeer(append(tt.header, "update"...))
+		if (err != nil) != tt.err || !tt.err && (peer != tt.peer || string(rest) != "update") {
+			t.Errorf("%s: peer %q, rest %q, %v; want %q", tt.name, peer, rest, err, tt.peer)
+		}
+	}
+}
+
+func TestReadBMPMessages(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	m := NewMonitor(nil, 0, time.Minute)
+	m.config = &Config{}
+	w, err := newBGPWatcher(BGPConfig{Enabled: true, BMP: "127.0.0.1:0", WithdrawnPeers: 1, Prefixes: []BGPPrefixConfig{{Prefix: "192.0.2.0/24"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.bmp.Close()
+
+	announce := bgpMessage(nil, bgpAttr(bgpAttrASPath, asSegment(bgpASSequence, 4, 64496, 64500)), nlri("192.0.2.0/24"))
+	stream := slices.Concat(
+		bmpMessage(4, []byte("initiation")),
+		bmpMessage(bmpRouteMonitoring, bmpPeerHeader(0, "198.51.100.1"), announce),
+		bmpMessage(bmpRouteMonitoring, bmpPeerHeader(0, "198.51.100.2"), announce),
+		bmpMessage(bmpRouteMonitoring, bmpPeerHeader(0, "198.51.100.3"), []byte("garbage")),
+		bmpMessage(bmpPeerDown, bmpPeerHeader(0, "198.51.100.2"), []byte{1}),
+		bmpMessage(bmpTermination),
+	)
+	err = m.readBMPMessages(w, "bmp/192.0.2.254", bufio.NewReader(bytes.NewReader(stream)))
+	if err == nil || !strings.Contains(err.Error(), "terminated") {
+		t.Errorf("error %v, want the termination", err)
+	}
+	prefixes, events := w.state()
+	if p := prefixes[0]; p.Peers != 1 || p.Withdrawn != 1 || !slices.Equal(p.Origins, []uint32{64500}) || p.Status != bgpStatusWithdrawn {
+		t.Errorf("prefix %+v, want one peer announcing it from AS64500 and one down", p)
+	}
+	if len(events) != 1 || events[0].Status != bgpStatusWithdrawn {
+		t.Errorf("events %+v, want the withdrawal", events)
+	}
+
+	for name, stream := range map[string][]byte{
+		"version 2":  {2, 0, 0, 0, 6, 0},
+		"too short":  {3, 0, 0, 0, 5, 0},
+		"no peer":    bmpMessage(bmpRouteMonitoring, []byte("short")),
+		"truncated":  bmpMessage(bmpRouteMonitoring, bmpPeerHeader(0, "198.51.100.1"), announce)[:50],
+		"no message": nil,
+	} {
+		if err := m.readBMPMessages(w, "bmp/192.0.2.254", bufio.NewReader(bytes.NewReader(stream))); err == nil {
+			t.Errorf("%s: read to the end", name)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Browser targets load a page in headless Chrome, scripts, styles and
+// images included, the way a visitor would:
+//
+//	browser://shop.example.com/checkout
+//	browser+http://intranet.lan/?refresh=5m
+//
+// browser:// loads the page over HTTPS and browser+http:// over HTTP.
+// Pages are loaded once per refresh (a minute), in a fresh browser each
+// time. The latency is the time to the load event, with the time to the
+// first byte and the first contentful paint as metrics. A page that fails
+// to load, answers 4xx or 5xx or takes longer than the timeout fails the
+// probe, and a screenshot of how far it got is kept and named in the
+// error.
+func init() {
+	probers["browser"] = every("1m", probeBrowser)
+	probers["browser+http"] = every("1m", probeBrowser)
+	probeDurations["browser"] = browserDuration
+	probeDurations["browser+http"] = browserDuration
+	localProbes["browser"] = true
+	localProbes["browser+http"] = true
+}
+
+// BrowserConfig sets up the browser probes:
+//
+//	browser:
+//	  chrome: /usr/bin/chromium
+//	  timeout: 30s
+//	  screenshots: /var/lib/netmonitor/screenshots
+//
+// Screenshots are kept in the screenshots directory of history_dir unless
+// set, and served by /api/screenshots.
+type BrowserConfig struct {
+	Chrome      string        `yaml:"chrome"`  // found on the PATH unless set
+	Timeout     time.Duration `yaml:"timeout"` // for loading a page, 30 seconds unless set
+	Screenshots string        `yaml:"screenshots"`
+	Keep        int           `yaml:"keep"` // screenshots kept, 100 unless set
+}
+
+// Screenshot describes a stored screenshot.
+type Screenshot struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// browserSettings are the browser settings in effect, shared by every
+// browser target.
+var browserSettings = struct {
+	sync.Mutex
+	chrome  string
+	timeout time.Duration
+	dir     string
+	keep    int
+}{timeout: 30 * time.Second, keep: 100}
+
+// chromeNames are what Chrome is looked for as on the PATH.
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome", "headless-shell"}
+
+func configureBrowser(cfg BrowserConfig, historyDir string) error {
+	if cfg.Timeout < 0 || cfg.Keep < 0 {
+		return errors.New("timeout and keep must not be negative")
+	}
+	s := &browserSettings
+	s.Lock()
+	defer s.Unlock()
+	s.chrome = cfg.Chrome
+	s.timeout = cmp.Or(cfg.Timeout, 30*time.Second)
+	s.keep = cmp.Or(cfg.Keep, 100)
+	s.dir = cfg.Screenshots
+	if s.dir == "" && historyDir != "" {
+		s.dir = filepath.Join(historyDir, "screenshots")
+	}
+	return nil
+}
+
+func browserDuration(*target) time.Duration {
+	browserSettings.Lock()
+	defer browserSettings.Unlock()
+	return browserSettings.timeout + 10*time.Second
+}
+
+// browserPage is what a page says about its loading, in milliseconds.
+type browserPage struct {
+	Status int     `json:"status"`
+	TTFB   float64 `json:"ttfb"`
+	FCP    float64 `json:"fcp"`
+	Load   float64 `json:"load"`
+}
+
+// browserTimingScript reads the navigation and paint timings of a page.
+const browserTimingScript = `(() => {
+	const n = performance.getEntriesByType('navigation')[0] || {};
+	const p = performance.getEntriesByName('first-contentful-paint')[0];
+	return {status: n.responseStatus || 0, ttfb: n.responseStart || 0, fcp: p ? p.startTime : 0, load: n.loadEventEnd || 0};
+})()`
+
+func probeBrowser(t *target) (probeResult, error) {
+	browserSettings.Lock()
+	chrome, timeout := browserSettings.chrome, browserSettings.timeout
+	browserSettings.Unlock()
+	if chrome == "" {
+		for _, name := range chromeNames {
+			if path, err := exec.LookPath(name); err == nil {
+				chrome = path
+				break
+			}
+		}
+		if chrome == "" {
+			return probeResult{}, errors.New("chrome not found, set browser.chrome")
+		}
+	}
+
+	page := *t.url
+	page.Scheme = "https"
+	if t.kind == "browser+http" {
+		page.Scheme = "http"
+	}
+	if q := page.Query(); q.Has("refresh") {
+		q.Del("refresh")
+		page.RawQuery = q.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+10*time.Second)
+	defer cancel()
+	b, err := startChrome(ctx, chrome, t.proxy)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer b.close()
+
+	p, err := b.load(page.String(), time.Now().Add(timeout))
+	if err == nil && p.Status >= 400 {
+		err = fmt.Errorf("page answered %d %s", p.Status, http.StatusText(p.Status))
+	}
+	if err != nil {
+		if name, shotErr := b.screenshot(t.name); shotErr == nil {
+			err = fmt.Errorf("%w (screenshot %s)", err, name)
+		}
+		return probeResult{}, err
+	}
+	return probeResult{
+		Latency: p.Load,
+		Metrics: map[string]float64{"ttfb_ms": p.TTFB, "fcp_ms": p.FCP},
+	}, nil
+}
+
+// chromeBrowser is a headless Chrome driven over the DevTools protocol.
+type chromeBrowser struct {
+	cmd     *exec.Cmd
+	dataDir string
+	ws      *websocket.Conn
+	session string // of the page
+	nextID  int
+	events  []cdpMessage // received while waiting for a reply
+}
+
+type cdpMessage struct {
+	ID        int             `json:"id,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    any             `json:"params,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// chromeListening is the line Chrome logs with the address of its
+// DevTools endpoint.
+var chromeListening = regexp.MustCompile(`DevTools listening on (ws://\S+)`)
+
+// startChrome runs a headless Chrome with a profile of its own and opens a
+// blank page in it. Chrome takes proxies without their credentials.
+func startChrome(ctx context.Context, chrome string, proxy *url.URL) (*chromeBrowser, error) {
+	dataDir, err := os.MkdirTemp("", "netmonitor-chrome-")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--headless=new", "--disable-gpu", "--no-first-run", "--no-default-browser-check",
+		"--hide-scrollbars", "--mute-audio", "--window-size=1280,800",
+		"--remote-debugging-port=0", "--user-data-dir=" + dataDir,
+	}
+	if proxy != nil {
+		args = append(args, "--proxy-server="+strings.Replace(proxy.Scheme, "socks5h", "socks5", 1)+"://"+proxy.Host)
+	}
+	if os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox") // Chrome refuses to run as root otherwise
+	}
+	b := &chromeBrowser{cmd: exec.CommandContext(ctx, chrome, append(args, "about:blank")...), dataDir: dataDir}
+	stderr, err := b.cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	if err := b.cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	endpoint := ""
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		if m := chromeListening.FindStringSubmatch(scanner.Text()); m != nil {
+			endpoint = m[1]
+			break
+		}
+	}
+	if endpoint == "" {
+		b.close()
+		return nil, errors.New("chrome exited without starting DevTools")
+	}
+	go func() {
+		for scanner.Scan() {
+		}
+	}()
+	if b.ws, err = websocket.Dial(endpoint, "", "http://localhost/"); err != nil {
+		b.close()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		b.ws.SetDeadline(deadline)
+	}
+
+	var created struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := b.call("Target.createTarget", map[string]any{"url": "about:blank"}, &created); err != nil {
+		b.close()
+		return nil, err
+	}
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := b.call("Target.attachToTarget", map[string]any{"targetId": created.TargetID, "flatten": true}, &attached); err != nil {
+		b.close()
+		return nil, err
+	}
+	b.session = attached.SessionID
+	if err := b.call("Page.enable", nil, nil); err != nil {
+		b.close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// call sends a command, to the page unless it is one of the browser's, and
+// waits for its result.
+func (b *chromeBrowser) call(method string, params, result any) error {
+	b.nextID++
+	msg := cdpMessage{ID: b.nextID, Method: method, Params: params}
+	if b.session != "" && !strings.HasPrefix(method, "Target.") {
+		msg.SessionID = b.session
+	}
+	if err := websocket.JSON.Send(b.ws, msg); err != nil {
+		return err
+	}
+	for {
+		var reply cdpMessage
+		if err := websocket.JSON.Receive(b.ws, &reply); err != nil {
+			return err
+		}
+		if reply.ID == 0 {
+			b.events = append(b.events, reply)
+			continue
+		}
+		if reply.ID != msg.ID {
+			continue
+		}
+		if reply.Error != nil {
+			return fmt.Errorf("%s: %s", method, reply.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(reply.Result, result)
+	}
+}
+
+// waitEvent waits for an event of the page until deadline.
+func (b *chromeBrowser) waitEvent(method string, deadline time.Time) error {
+	b.ws.SetReadDeadline(deadline)
+	for {
+		if slices.ContainsFunc(b.events, func(e cdpMessage) bool { return e.Method == method && e.SessionID == b.session }) {
+			return nil
+		}
+		var msg cdpMessage
+		if err := websocket.JSON.Receive(b.ws, &msg); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return errors.New("page did not load in time")
+			}
+			return err
+		}
+		b.events = append(b.events, msg)
+	}
+}
+
+// load navigates to a page and reads its timings once it has loaded.
+func (b *chromeBrowser) load(page string, deadline time.Time) (browserPage, error) {
+	var nav struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := b.call("Page.navigate", map[string]any{"url": page}, &nav); err != nil {
+		return browserPage{}, err
+	}
+	if nav.ErrorText != "" {
+		return browserPage{}, errors.New(nav.ErrorText)
+	}
+	if err := b.waitEvent("Page.loadEventFired", deadline); err != nil {
+		return browserPage{}, err
+	}
+	b.ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var eval struct {
+		Result struct {
+			Value browserPage `json:"value"`
+		} `json:"result"`
+	}
+	err := b.call("Runtime.evaluate", map[string]any{"expression": browserTimingScript, "returnByValue": true}, &eval)
+	return eval.Result.Value, err
+}
+
+// screenshot stores what the page shows and returns the name of the file.
+func (b *chromeBrowser) screenshot(host string) (string, error) {
+	browserSettings.Lock()
+	dir, keep := browserSettings.dir, browserSettings.keep
+	browserSettings.Unlock()
+	if dir == "" {
+		return "", errors.New("no screenshots directory")
+	}
+	b.ws.SetDeadline(time.Now().Add(10 * time.Second))
+	var shot struct {
+		Data string `json:"data"`
+	}
+	if err := b.call("Page.captureScreenshot", map[string]any{"format": "png"}, &shot); err != nil {
+		return "", err
+	}
+	png, err := base64.StdEncoding.DecodeString(shot.Data)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format("20060102-150405") + "-" + strings.Trim(unsafeFileChars.ReplaceAllString(host, "_"), "_") + ".png"
+	if err := os.WriteFile(filepath.Join(dir, name), png, 0o644); err != nil {
+		return "", err
+	}
+	shots := listScreenshots(dir)
+	for len(shots) > keep {
+		os.Remove(filepath.Join(dir, shots[0].Name))
+		shots = shots[1:]
+	}
+	return name, nil
+}
+
+// close shuts the browser down and removes its profile.
+func (b *chromeBrowser) close() {
+	if b.ws != nil {
+		b.ws.SetDeadline(time.Now().Add(time.Second))
+		b.session = ""
+		b.call("Browser.close", nil, nil)
+		b.ws.Close()
+	}
+	b.cmd.Process.Kill()
+	b.cmd.Wait()
+	os.RemoveAll(b.dataDir)
+}
+
+// listScreenshots returns the screenshots in dir, oldest first.
+func listScreenshots(dir string) []Screenshot {
+	entries, _ := os.ReadDir(dir)
+	shots := []Screenshot{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.Type().IsRegular() || filepath.Ext(e.Name()) != ".png" {
+			continue
+		}
+		shots = append(shots, Screenshot{Name: e.Name(), Time: info.ModTime(), Size: info.Size()})
+	}
+	slices.SortFunc(shots, func(a, b Screenshot) int { return strings.Compare(a.Name, b.Name) })
+	return shots
+}
+
+func (m *Monitor) handleScreenshots(w http.ResponseWriter, r *http.Request) {
+	browserSettings.Lock()
+	dir := browserSettings.dir
+	browserSettings.Unlock()
+	if dir == "" {
+		writeJSON(w, http.StatusOK, []Screenshot{})
+		return
+	}
+	writeJSON(w, http.StatusOK, listScreenshots(dir))
+}
+
+// handleScreenshot serves GET /api/screenshots/{name}, the PNG itself.
+func (m *Monitor) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	browserSettings.Lock()
+	dir := browserSettings.dir
+	browserSettings.Unlock()
+	name := r.PathValue("name")
+	if dir == "" || !slices.ContainsFunc(listScreenshots(dir), func(s Screenshot) bool { return s.Name == name }) {
+		writeError(w, http.StatusNotFound, "no such screenshot")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	http.ServeFile(w, r, filepath.Join(dir, name))
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureConfig records a few seconds of a host's traffic when it goes
+// down or degraded, or its latency or loss crosses its critical threshold,
+// for looking at in Wireshark afterwards:
+//
+//	capture:
+//	  enabled: true
+//	  duration: 10s
+//	  interface: eth0
+//
+// Captures are written to dir, or the captures directory of history_dir,
+// and served by /api/captures. Keep bounds how many are kept and cooldown
+// how often one host is captured. Capturing is Linux only and needs
+// CAP_NET_RAW, like ICMP; the socket is opened before switching to an
+// unprivileged user.
+type CaptureConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Duration  time.Duration `yaml:"duration"`  // 10 seconds unless set
+	Interface string        `yaml:"interface"` // every interface unless set
+	Dir       string        `yaml:"dir"`
+	Snaplen   int           `yaml:"snaplen"`  // bytes kept per packet, 65535 unless set
+	Keep      int           `yaml:"keep"`     // captures kept, 100 unless set
+	Cooldown  time.Duration `yaml:"cooldown"` // 10 minutes unless set
+}
+
+// Capture describes a stored capture.
+type Capture struct {
+	Name     string    `json:"name"`
+	Host     string    `json:"host"`
+	Address  string    `json:"address"`
+	Reason   string    `json:"reason"`
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration"` // seconds
+	Packets  int       `json:"packets"`
+	Size     int64     `json:"size"`
+}
+
+// capturer takes captures from one packet socket, which only lets packets
+// through while a capture runs.
+type capturer struct {
+	duration time.Duration
+	snaplen  int
+	keep     int
+	cooldown time.Duration
+	dir      string
+	socket   packetSocket
+
+	mu       sync.Mutex
+	active   []*activeCapture
+	captures []Capture            // oldest first
+	last     map[string]time.Time // last capture by host
+}
+
+// packetSocket receives IP packets from the network interfaces.
+type packetSocket interface {
+	// setOpen lets packets through, or drops them in the kernel.
+	setOpen(open bool) error
+	// read returns the next packet and its original length.
+	read(buf []byte) (n, length int, err error)
+}
+
+// activeCapture is a capture being written.
+type activeCapture struct {
+	Capture
+	addr netip.Addr
+	file *os.File
+	w    *bufio.Writer
+}
+
+func newCapturer(cfg CaptureConfig, historyDir string) (*capturer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	c := &capturer{
+		duration: cmp.Or(cfg.Duration, 10*time.Second),
+		snaplen:  cmp.Or(cfg.Snaplen, 65535),
+		keep:     cmp.Or(cfg.Keep, 100),
+		cooldown: cmp.Or(cfg.Cooldown, 10*time.Minute),
+		dir:      cfg.Dir,
+		last:     map[string]time.Time{},
+	}
+	if c.dir == "" && historyDir != "" {
+		c.dir = filepath.Join(historyDir, "captures")
+	}
+	if c.dir == "" {
+		return nil, errors.New("set dir or history_dir to keep captures in")
+	}
+	socket, err := openPacketSocket(cfg.Interface)
+	if err != nil {
+		return nil, err
+	}
+	c.socket = socket
+	return c, nil
+}
+
+func (c *capturer) indexPath() string {
+	return filepath.Join(c.dir, "captures.json")
+}
+
+// open creates the capture directory, reads the index of earlier captures
+// and starts reading packets. It runs after switching users so the files
+// belong to the one writing them.
+func (c *capturer) open() error {
+	if err := os.MkdirAll(c.dir, 0750); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(c.indexPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &c.captures); err != nil {
+			return err
+		}
+	}
+	go c.read()
+	return nil
+}
+
+// saveLocked writes the index out, removing the oldest captures past the
+// limit first. c.mu must be held.
+func (c *capturer) saveLocked() error {
+	for len(c.captures) > c.keep {
+		os.Remove(filepath.Join(c.dir, c.captures[0].Name))
+		c.captures = c.captures[1:]
+	}
+	b, err := json.MarshalIndent(c.captures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.indexPath(), b, 0640)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// start begins capturing the traffic of a host unless it was captured
+// within the cooldown.
+func (c *capturer) start(host string, addr netip.Addr, reason string) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.last[host]; ok && now.Sub(last) < c.cooldown {
+		return nil
+	}
+	c.last[host] = now
+
+	name := fmt.Sprintf("%s-%s.pcap", now.UTC().Format("20060102T150405Z"), strings.Trim(unsafeFileChars.ReplaceAllString(host, "_"), "_"))
+	f, err := os.OpenFile(filepath.Join(c.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	ac := &activeCapture{
+		Capture: Capture{Name: name, Host: host, Address: addr.String(), Reason: reason, Started: now},
+		addr:    addr,
+		file:    f,
+		w:       bufio.NewWriter(f),
+	}
+	// pcap file header for raw IP packets (LINKTYPE_RAW).
+	var header [24]byte
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], uint32(c.snaplen))
+	binary.LittleEndian.PutUint32(header[20:], 101)
+	ac.w.Write(header[:])
+
+	if len(c.active) == 0 {
+		if err := c.socket.setOpen(true); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	c.active = append(c.active, ac)
+	log.Printf("Capturing traffic of %s for %v: %s", host, c.duration, reason)
+	time.AfterFunc(c.duration, func() { c.finish(ac) })
+	return nil
+}
+
+// finish closes a capture and adds it to the index.
+func (c *capturer) finish(ac *activeCapture) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = slices.DeleteFunc(c.active, func(a *activeCapture) bool { return a == ac })
+	if len(c.active) == 0 {
+		if err := c.socket.setOpen(false); err != nil {
+			log.Printf("Stopping capture failed: %v", err)
+		}
+	}
+	err := ac.w.Flush()
+	if info, statErr := ac.file.Stat(); statErr == nil {
+		ac.Size = info.Size()
+	}
+	err = cmp.Or(err, ac.file.Close())
+	if err != nil {
+		log.Printf("Writing capture %s failed: %v", ac.Name, err)
+	}
+	ac.Duration = time.Since(ac.Started).Seconds()
+	c.captures = append(c.captures, ac.Capture)
+	if err := c.saveLocked(); err != nil {
+		log.Printf("Saving the capture index failed: %v", err)
+	}
+}
+
+// read hands every packet to the captures of its source or destination.
+func (c *capturer) read() {
+	buf := make([]byte, c.snaplen)
+	var record [16]byte
+	for {
+		n, length, err := c.socket.read(buf)
+		if err != nil {
+			log.Printf("Reading packets failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		src, dst, ok := packetAddrs(buf[:n])
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		c.mu.Lock()
+		for _, ac := range c.active {
+			if ac.addr != src && ac.addr != dst {
+				continue
+			}
+			binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+			binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+			binary.LittleEndian.PutUint32(record[8:], uint32(n))
+			binary.LittleEndian.PutUint32(record[12:], uint32(length))
+			ac.w.Write(record[:])
+			ac.w.Write(buf[:n])
+			ac.Packets++
+		}
+		c.mu.Unlock()
+	}
+}
+
+// packetAddrs returns the source and destination of an IP packet.
+func packetAddrs(p []byte) (src, dst netip.Addr, ok bool) {
+	if len(p) < 1 {
+		return src, dst, false
+	}
+	switch p[0] >> 4 {
+	case 4:
+		if len(p) < 20 {
+			return src, dst, false
+		}
+		return netip.AddrFrom4([4]byte(p[12:16])), netip.AddrFrom4([4]byte(p[16:20])), true
+	case 6:
+		if len(p) < 40 {
+			return src, dst, false
+		}
+		return netip.AddrFrom16([16]byte(p[8:24])), netip.AddrFrom16([16]byte(p[24:40])), true
+	}
+	return src, dst, false
+}
+
+func (c *capturer) list() []Capture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := slices.Clone(c.captures)
+	slices.Reverse(list)
+	return list
+}
+
+// anomaly tells what is wrong with a host after a probe, if anything: it
+// is down or degraded, or its latency or loss is at or above the critical
+// threshold.
+func anomaly(stats *PingStats, latency float64, err error) string {
+	th := stats.Thresholds
+	switch {
+	case err != nil:
+		return fmt.Sprintf("%s is down: %v", stats.Host, err)
+	case stats.Status == "degraded":
+		return fmt.Sprintf("%s is degraded: %s", stats.Host, stats.Warning)
+	case latency >= th.LatencyCritical:
+		return fmt.Sprintf("latency %.1f ms is at or above %g ms", latency, th.LatencyCritical)
+	case stats.PacketLoss >= th.LossCritical:
+		return fmt.Sprintf("packet loss %.1f%% is at or above %g%%", stats.PacketLoss, th.LossCritical)
+	}
+	return ""
+}
+
+// captureAnomaly starts capturing a host's traffic when it turns
+// anomalous, or goes down or degraded while it already was.
+func (m *Monitor) captureAnomaly(h *hostState, stats *PingStats, previous string, latency float64, err error, addr netip.Addr) {
+	if m.capturer == nil {
+		return
+	}
+	reason := anomaly(stats, latency, err)
+	wasAnomalous := h.anomalous
+	h.anomalous = reason != ""
+	worse := stats.Status != previous && (stats.Status == "down" || stats.Status == "degraded")
+	if reason == "" || wasAnomalous && !worse || !addr.IsValid() {
+		return
+	}
+	go func() {
+		if err := m.capturer.start(stats.Host, addr, reason); err != nil {
+			log.Printf("Capturing traffic of %s failed: %v", stats.Host, err)
+		}
+	}()
+}
+
+func (m *Monitor) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	if m.capturer == nil {
+		writeJSON(w, http.StatusOK, []Capture{})
+		return
+	}
+	writeJSON(w, http.StatusOK, m.capturer.list())
+}
+
+// handleCapture serves GET /api/captures/{name}, the pcap file itself.
+func (m *Monitor) handleCapture(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if m.capturer == nil || !slices.ContainsFunc(m.capturer.list(), func(c Capture) bool { return c.Name == name }) {
+		writeError(w, http.StatusNotFound, "no such capture")
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, filepath.Join(m.capturer.dir, name))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// linuxPacketSocket is an AF_PACKET socket, which sees the packets of
+// every protocol with their link layer headers taken off.
+type linuxPacketSocket struct {
+	fd int
+}
+
+func openPacketSocket(iface string) (packetSocket, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, fmt.Errorf("opening packet socket: %v", err)
+	}
+	s := &linuxPacketSocket{fd: fd}
+	// Drop everything until a capture starts.
+	if err := s.setOpen(false); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			unix.Close(fd)
+			return nil, err
+		}
+		if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifi.Index}); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("binding packet socket to %s: %v", iface, err)
+		}
+	}
+	return s, nil
+}
+
+// setOpen attaches a BPF program that accepts up to the socket buffer's
+// worth of every packet, or none.
+func (s *linuxPacketSocket) setOpen(open bool) error {
+	var accept uint32
+	if open {
+		accept = 0x40000
+	}
+	filter := []unix.SockFilter{{Code: unix.BPF_RET | unix.BPF_K, K: accept}}
+	return unix.SetsockoptSockFprog(s.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]})
+}
+
+func (s *linuxPacketSocket) read(buf []byte) (n, length int, err error) {
+	for {
+		length, from, err := unix.Recvfrom(s.fd, buf, unix.MSG_TRUNC)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		// Loopback packets show up once going out and once coming in.
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Hatype == unix.ARPHRD_LOOPBACK && ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+		return min(length, len(buf)), length, nil
+	}
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openPacketSocket(iface string) (packetSocket, error) {
+	return nil, errors.New("packet capture is only supported on Linux")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runCheck is `netmonitor check`, also run when the binary is invoked as
+// check_netmonitor: a Nagios plugin reporting the state of hosts as a
+// running instance sees them, so Nagios, Icinga or any NMS that runs
+// plugins can pick up what netmonitor watches. It prints one line of
+// status with perfdata and exits with the plugin code of the worst host.
+func runCheck(name string, args []string) int {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	server := flags.String("server", cmp.Or(os.Getenv("NETMONITOR_URL"), "http://localhost:8080"), "URL of the instance, including any base path ($NETMONITOR_URL)")
+	token := flags.String("token", os.Getenv("NETMONITOR_TOKEN"), "API token to authenticate with ($NETMONITOR_TOKEN)")
+	host := flags.String("host", "", "Check this host only")
+	tags := flags.String("tags", "", "Check the hosts with any of these tags, comma-separated")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [-server URL] [-host HOST | -tags TAGS]\n\n", name)
+		fmt.Fprintf(flags.Output(), "Reports the state of hosts as a Nagios plugin: OK when all are up, WARNING when\n")
+		fmt.Fprintf(flags.Output(), "some are degraded, CRITICAL when some are down and UNKNOWN when none are known.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nagiosUnknown
+	}
+	c := &ctlClient{server: strings.TrimSuffix(*server, "/"), token: *token, out: io.Discard}
+	q := url.Values{}
+	if *tags != "" {
+		q.Set("tag", *tags)
+	}
+	var stats []PingStats
+	if err := c.call(http.MethodGet, "/api/stats?"+q.Encode(), nil, &stats, false); err != nil {
+		fmt.Printf("NETMONITOR UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+	if *host != "" {
+		var found []PingStats
+		for _, s := range stats {
+			if s.Host == *host {
+				found = append(found, s)
+			}
+		}
+		if len(found) == 0 {
+			fmt.Printf("NETMONITOR UNKNOWN - %s is not monitored\n", *host)
+			return nagiosUnknown
+		}
+		stats = found
+	}
+	line, code := nagiosCheck(stats)
+	fmt.Println(line)
+	return code
+}
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// nagiosCheck renders the plugin output for some hosts: the worst state
+// with the hosts in it, then the latency and loss of each as perfdata with
+// their thresholds.
+func nagiosCheck(stats []PingStats) (string, int) {
+	byCode := map[int][]string{}
+	var perfdata []string
+	known := 0
+	for _, s := range stats {
+		code := nagiosUnknown
+		switch {
+		case s.Paused || s.OffSchedule:
+			continue
+		case s.Status == "up":
+			code = nagiosOK
+		case s.Status == "degraded":
+			code = nagiosWarning
+		case s.Status == "down":
+			code = nagiosCritical
+		}
+		if code != nagiosUnknown {
+			known++
+		}
+		byCode[code] = append(byCode[code], s.Host)
+
+		label := ""
+		if len(stats) > 1 {
+			label = nagiosLabel(s.Host) + " "
+		}
+		t := s.Thresholds
+		if s.CurrentLatency > 0 {
+			perfdata = append(perfdata, fmt.Sprintf("'%slatency'=%.3fms;%g;%g;0", label, s.CurrentLatency, t.LatencyWarning, t.LatencyCritical))
+		}
+		perfdata = append(perfdata, fmt.Sprintf("'%sloss'=%.1f%%;%g;%g;0;100", label, s.PacketLoss, t.LossWarning, t.LossCritical))
+	}
+
+	var code int
+	var text string
+	switch {
+	case known == 0:
+		code, text = nagiosUnknown, "no hosts with a known state"
+	case len(byCode[nagiosCritical]) > 0:
+		code, text = nagiosCritical, nagiosHostList(byCode[nagiosCritical], "down")
+		if len(byCode[nagiosWarning]) > 0 {
+			text += ", " + nagiosHostList(byCode[nagiosWarning], "degraded")
+		}
+	case len(byCode[nagiosWarning]) > 0:
+		code, text = nagiosWarning, nagiosHostList(byCode[nagiosWarning], "degraded")
+	default:
+		code = nagiosOK
+		text = fmt.Sprintf("%d hosts up", known)
+		if len(stats) == 1 {
+			text = fmt.Sprintf("%s is up", stats[0].Host)
+			if stats[0].CurrentLatency > 0 {
+				text += fmt.Sprintf(", %.1f ms", stats[0].CurrentLatency)
+			}
+		}
+	}
+	line := "NETMONITOR " + nagiosStates[code] + " - " + text
+	if len(perfdata) > 0 {
+		line += " | " + strings.Join(perfdata, " ")
+	}
+	return line, code
+}
+
+// nagiosHostList names the hosts in a state, up to a few of them.
+func nagiosHostList(hosts []string, state string) string {
+	const shown = 5
+	names := strings.Join(hosts[:min(len(hosts), shown)], ", ")
+	if len(hosts) > shown {
+		names += fmt.Sprintf(" and %d more", len(hosts)-shown)
+	}
+	if len(hosts) == 1 {
+		return names + " is " + state
+	}
+	return names + " are " + state
+}
+
+// nagiosLabel makes text usable in a quoted perfdata label, which cannot
+// hold quotes or equals signs.
+func nagiosLabel(s string) string {
+	return strings.NewReplacer("'", "", "=", "_").Replace(s)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNagiosCheck(t *testing.T) {
+	th := Thresholds{LatencyWarning: 50, LatencyCritical: 100, LossCritical: 5}
+	up := func(host string, latency float64) PingStats {
+		return PingStats{Host: host, Status: "up", CurrentLatency: latency, Thresholds: th}
+	}
+	tests := []struct {
+		name  string
+		stats []PingStats
+		line  string
+		code  int
+	}{
+		{"one up", []PingStats{up("8.8.8.8", 12.3456)},
+			"NETMONITOR OK - 8.8.8.8 is up, 12.3 ms | 'latency'=12.346ms;50;100;0 'loss'=0.0%;0;5;0;100", nagiosOK},
+		{"all up", []PingStats{up("8.8.8.8", 12), up("o'brien=1", 0)},
+			"NETMONITOR OK - 2 hosts up | '8.8.8.8 latency'=12.000ms;50;100;0 '8.8.8.8 loss'=0.0%;0;5;0;100 'obrien_1 loss'=0.0%;0;5;0;100", nagiosOK},
+		{"degraded", []PingStats{up("a", 0), {Host: "b", Status: "degraded", PacketLoss: 2.5}},
+			"NETMONITOR WARNING - b is degraded | 'a loss'=0.0%;0;5;0;100 'b loss'=2.5%;0;0;0;100", nagiosWarning},
+		{"down and degraded", []PingStats{{Host: "a", Status: "down"}, {Host: "b", Status: "down"}, {Host: "c", Status: "degraded"}},
+			"NETMONITOR CRITICAL - a, b are down, c is degraded | 'a loss'=0.0%;0;0;0;100 'b loss'=0.0%;0;0;0;100 'c loss'=0.0%;0;0;0;100", nagiosCritical},
+		{"paused and off schedule", []PingStats{{Host: "a", Status: "down", Paused: true}, {Host: "b", Status: "down", OffSchedule: true}},
+			"NETMONITOR UNKNOWN - no hosts with a known state", nagiosUnknown},
+		{"not probed yet", []PingStats{{Host: "a", Status: "unknown"}},
+			"NETMONITOR UNKNOWN - no hosts with a known state | 'loss'=0.0%;0;0;0;100", nagiosUnknown},
+		{"none", nil, "NETMONITOR UNKNOWN - no hosts with a known state", nagiosUnknown},
+	}
+	for _, tt := range tests {
+		line, code := nagiosCheck(tt.stats)
+		if line != tt.line || code != tt.code {
+			t.Errorf("%s: %q, %d\nwant %q, %d", tt.name, line, code, tt.line, tt.code)
+		}
+	}
+
+	if got, want := nagiosHostList([]string{"a", "b", "c", "d", "e", "f", "g"}, "down"), "a, b, c, d, e and 2 more are down"; got != want {
+		t.Errorf("long list: %q, want %q", got, want)
+	}
+}
+
+// captureStdout returns what f prints.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+func TestRunCheck(t *testing.T) {
+	t.Setenv("NETMONITOR_TOKEN", "")
+	stats := []PingStats{
+		{Host: "192.0.2.1", Status: "up", Tags: []string{"core"}},
+		{Host: "192.0.2.2", Status: "down", Tags: []string{"edge"}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+			return
+		}
+		var found []PingStats
+		for _, s := range stats {
+			if tag := r.URL.Query().Get("tag"); tag == "" || tag == s.Tags[0] {
+				found = append(found, s)
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+		out  string
+	}{
+		{"all", []string{"-token", "t0ken"}, nagiosCritical, "NETMONITOR CRITICAL - 192.0.2.2 is down"},
+		{"one host", []string{"-token", "t0ken", "-host", "192.0.2.1"}, nagiosOK, "NETMONITOR OK - 192.0.2.1 is up"},
+		{"by tag", []string{"-token", "t0ken", "-tags", "core"}, nagiosOK, "NETMONITOR OK - 192.0.2.1 is up"},
+		{"unknown host", []string{"-token", "t0ken", "-host", "192.0.2.3"}, nagiosUnknown, "NETMONITOR UNKNOWN - 192.0.2.3 is not monitored"},
+		{"no token", nil, nagiosUnknown, "NETMONITOR UNKNOWN - GET /api/stats?: unauthorized"},
+		{"wrong token", []string{"-token", "guess"}, nagiosUnknown, "unauthorized"},
+	}
+	for _, tt := range tests {
+		var code int
+		out := captureStdout(t, func() {
+			code = runCheck("check_netmonitor", append([]string{"-server", server.URL + "/"}, tt.args...))
+		})
+		if code != tt.code || !strings.Contains(out, tt.out) {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, code, out, tt.code, tt.out)
+		}
+	}
+
+	t.Setenv("NETMONITOR_URL", server.URL)
+	t.Setenv("NETMONITOR_TOKEN", "t0ken")
+	var code int
+	captureStdout(t, func() { code = runCheck("check_netmonitor", []string{"-host", "192.0.2.2"}) })
+	if code != nagiosCritical {
+		t.Errorf("configured from the environment: code %d", code)
+	}
+}
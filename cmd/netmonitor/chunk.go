@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"slices"
+	"time"
+)
+
+// History is kept in compressed chunks the way Gorilla and the Prometheus
+// TSDB store series: timestamps as the difference between consecutive
+// deltas, which is zero for a host probed at a steady interval, and
+// latencies XORed with the previous one, which leaves only the bits that
+// changed. A sample takes around ten bytes, against some 50 for a Sample,
+// so months of history of a host probed every 30 seconds fit in a few MB.
+// Timestamps are kept to the millisecond.
+
+// chunkSamples is how many samples a chunk holds before a new one starts.
+const chunkSamples = 120
+
+// chunk is a run of compressed samples of one host. The last chunk of a
+// host is appended to until it is full; chunks loaded from disk are
+// sealed.
+type chunk struct {
+	first, last time.Time
+	count       int
+	sealed      bool
+	data        bitWriter
+
+	// metrics of the samples that keep them, by index. Few probes do, so
+	// they are not worth compressing.
+	metrics map[int]map[string]float64
+
+	// Encoder state: the previous timestamp (ms), delta and latency bits,
+	// and the window of meaningful bits of the previous XOR.
+	t, delta          int64
+	v                 uint64
+	leading, trailing uint8
+}
+
+// noWindow marks that no XOR window has been written yet.
+const noWindow = 0xff
+
+func newChunk() *chunk {
+	return &chunk{leading: noWindow}
+}
+
+func (c *chunk) full() bool {
+	return c.sealed || c.count >= chunkSamples
+}
+
+// append adds a sample, which should not be older than the last one.
+func (c *chunk) append(s Sample) {
+	t := s.Time.UnixMilli()
+	v := math.Float64bits(s.Latency)
+	if c.count == 0 {
+		c.data.writeBits(uint64(t), 64)
+		c.data.writeBits(v, 64)
+		c.first = time.UnixMilli(t)
+	} else {
+		delta := t - c.t
+		c.writeDeltaOfDelta(delta - c.delta)
+		c.delta = delta
+		c.writeValue(v ^ c.v)
+	}
+	c.data.writeBit(s.Up)
+	if len(s.Metrics) > 0 {
+		if c.metrics == nil {
+			c.metrics = map[int]map[string]float64{}
+		}
+		c.metrics[c.count] = s.Metrics
+	}
+	c.t, c.v = t, v
+	c.last = time.UnixMilli(t)
+	c.count++
+	if c.count == chunkSamples {
+		c.data.buf = slices.Clip(c.data.buf)
+	}
+}
+
+// deltaBuckets are the sizes of the delta-of-delta encodings after their
+// prefixes 10, 110 and 1110; 1111 is followed by all 64 bits.
+var deltaBuckets = []int{14, 17, 20}
+
+func (c *chunk) writeDeltaOfDelta(dod int64) {
+	if dod == 0 {
+		c.data.writeBit(false)
+		return
+	}
+	for _, n := range deltaBuckets {
+		c.data.writeBit(true)
+		if -(1<<(n-1))+1 <= dod && dod <= 1<<(n-1) {
+			c.data.writeBit(false)
+			c.data.writeBits(uint64(dod)&(1<<n-1), n)
+			return
+		}
+	}
+	c.data.writeBit(true)
+	c.data.writeBits(uint64(dod), 64)
+}
+
+func (c *chunk) writeValue(xor uint64) {
+	if xor == 0 {
+		c.data.writeBit(false)
+		return
+	}
+	c.data.writeBit(true)
+	leading, trailing := uint8(bits.LeadingZeros64(xor)), uint8(bits.TrailingZeros64(xor))
+	leading = min(leading, 31) // it has 5 bits
+	if c.leading != noWindow && leading >= c.leading && trailing >= c.trailing {
+		// Fits into the window of the previous value.
+		c.data.writeBit(false)
+		c.data.writeBits(xor>>c.trailing, int(64-c.leading-c.trailing))
+		return
+	}
+	c.leading, c.trailing = leading, trailing
+	significant := 64 - leading - trailing
+	c.data.writeBit(true)
+	c.data.writeBits(uint64(leading), 5)
+	c.data.writeBits(uint64(significant)&63, 6) // 64 is written as 0
+	c.data.writeBits(xor>>trailing, int(significant))
+}
+
+// samples decodes the samples of the chunk.
+func (c *chunk) samples() ([]Sample, error) {
+	r := bitReader{buf: c.data.buf}
+	samples := make([]Sample, 0, c.count)
+	var t, delta int64
+	var v uint64
+	var leading, trailing uint8
+	for i := range c.count {
+		if i == 0 {
+			raw, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+			t = int64(raw)
+			if v, err = r.readBits(64); err != nil {
+				return nil, err
+			}
+		} else {
+			dod, err := r.readDeltaOfDelta()
+			if err != nil {
+				return nil, err
+			}
+			delta += dod
+			t += delta
+			control, err := r.readBit()
+			if err != nil {
+				return nil, err
+			}
+			if control {
+				window, err := r.readBit()
+				if err != nil {
+					return nil, err
+				}
+				if window {
+					l, err := r.readBits(5)
+					if err != nil {
+						return nil, err
+					}
+					n, err := r.readBits(6)
+					if err != nil {
+						return nil, err
+					}
+					if n == 0 {
+						n = 64
+					}
+					leading, trailing = uint8(l), uint8(64-l-n)
+				}
+				xor, err := r.readBits(int(64 - leading - trailing))
+				if err != nil {
+					return nil, err
+				}
+				v ^= xor << trailing
+			}
+		}
+		up, err := r.readBit()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, Sample{Time: time.UnixMilli(t), Latency: math.Float64frombits(v), Up: up, Metrics: c.metrics[i]})
+	}
+	return samples, nil
+}
+
+func (r *bitReader) readDeltaOfDelta() (int64, error) {
+	// The prefix is up to four ones, ended by a zero before that.
+	ones := 0
+	for ones < 4 {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		ones++
+	}
+	switch ones {
+	case 0:
+		return 0, nil
+	case 4:
+		raw, err := r.readBits(64)
+		return int64(raw), err
+	}
+	n := deltaBuckets[ones-1]
+	raw, err := r.readBits(n)
+	if err != nil {
+		return 0, err
+	}
+	dod := int64(raw)
+	if dod > 1<<(n-1) {
+		dod -= 1 << n
+	}
+	return dod, nil
+}
+
+// size is the memory the compressed samples take.
+func (c *chunk) size() int {
+	return len(c.data.buf)
+}
+
+type bitWriter struct {
+	buf  []byte
+	free uint8 // unused bits in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits writes the n low bits of v, the most significant first.
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v>>i&1 == 1)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // in bits
+}
+
+var errShortChunk = errors.New("chunk ends early")
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errShortChunk
+	}
+	bit := r.buf[r.pos/8]>>(7-r.pos%8)&1 == 1
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for range n {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+// chunkFileHeader starts the files finished days of history are compacted
+// into. Each chunk follows as its host, sample count, last timestamp (ms),
+// data and metrics as JSON, with the strings and bytes prefixed by their
+// length and the numbers written as varints.
+const chunkFileHeader = "netmonitor chunks 1\n"
+
+// hostChunk is a chunk in a file, tagged with its host.
+type hostChunk struct {
+	host string
+	*chunk
+}
+
+func writeChunkFile(path string, chunks []hostChunk) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(chunkFileHeader)
+	for _, c := range chunks {
+		var metrics []byte
+		if c.metrics != nil {
+			if metrics, err = json.Marshal(c.metrics); err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return err
+			}
+		}
+		w.Write(binary.AppendUvarint(nil, uint64(len(c.host))))
+		w.WriteString(c.host)
+		w.Write(binary.AppendUvarint(nil, uint64(c.count)))
+		w.Write(binary.AppendVarint(nil, c.last.UnixMilli()))
+		w.Write(binary.AppendUvarint(nil, uint64(len(c.data.buf))))
+		w.Write(c.data.buf)
+		w.Write(binary.AppendUvarint(nil, uint64(len(metrics))))
+		w.Write(metrics)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readChunkFile(path string) ([]hostChunk, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readChunks(bufio.NewReader(f))
+}
+
+// readChunks reads the chunks of a chunk file.
+func readChunks(r *bufio.Reader) ([]hostChunk, error) {
+	header := make([]byte, len(chunkFileHeader))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != chunkFileHeader {
+		return nil, fmt.Errorf("not a chunk file")
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > 1<<24 {
+			return nil, fmt.Errorf("chunk field of %d bytes", n)
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+	var chunks []hostChunk
+	for {
+		host, err := readBytes()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := &chunk{sealed: true}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		last, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if c.data.buf, err = readBytes(); err != nil {
+			return nil, err
+		}
+		metrics, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(metrics) > 0 {
+			if err := json.Unmarshal(metrics, &c.metrics); err != nil {
+				return nil, err
+			}
+		}
+		if count == 0 || count > chunkSamples || len(c.data.buf) < 16 {
+			return nil, fmt.Errorf("invalid chunk of %d samples", count)
+		}
+		c.count = int(count)
+		c.first = time.UnixMilli(int64(binary.BigEndian.Uint64(c.data.buf)))
+		c.last = time.UnixMilli(last)
+		chunks = append(chunks, hostChunk{host: string(host), chunk: c})
+	}
+}
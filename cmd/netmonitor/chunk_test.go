@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkOf compresses samples into a chunk.
+func chunkOf(samples []Sample) *chunk {
+	c := newChunk()
+	for _, s := range samples {
+		c.append(s)
+	}
+	return c
+}
+
+func sameSamples(got, want []Sample) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		g, w := got[i], want[i]
+		if !g.Time.Equal(w.Time) || math.Float64bits(g.Latency) != math.Float64bits(w.Latency) || g.Up != w.Up || !maps.Equal(g.Metrics, w.Metrics) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestChunkRoundTrip(t *testing.T) {
+	start := time.UnixMilli(1767225600123)
+	at := func(ms ...int64) []Sample {
+		var samples []Sample
+		for i, d := range ms {
+			samples = append(samples, Sample{Time: start.Add(time.Duration(d) * time.Millisecond), Latency: float64(i) * 1.5, Up: i%3 != 0})
+		}
+		return samples
+	}
+	steady := make([]int64, chunkSamples)
+	for i := range steady {
+		steady[i] = int64(i) * 30000
+	}
+
+	tests := []struct {
+		name    string
+		samples []Sample
+	}{
+		{"one", at(0)},
+		{"steady", at(steady...)},
+		// Deltas of deltas for each bucket and for all 64 bits.
+		{"14 bits", at(0, 1000, 1000+1000+8192, 1000+1000+8192+1000)},
+		{"17 bits", at(0, 1000, 1000+1000+8193, 1000+1000+8193+1000+65536)},
+		{"20 bits", at(0, 1000, 1000+1000+65537, 1000+1000+65537+1000+524288)},
+		{"64 bits", at(0, 1000, 1000+1000+524289, 1000+1000+524289+1000+int64(24*time.Hour/time.Millisecond))},
+		{"same time", at(0, 0, 0)},
+		{"slowing down and speeding up", at(0, 100000, 100001, 300000, 300500)},
+		{"latencies", []Sample{
+			{Time: start, Latency: 12.25, Up: true},
+			{Time: start.Add(time.Second), Latency: 12.25, Up: true},
+			{Time: start.Add(2 * time.Second), Latency: 12.5, Up: true},
+			{Time: start.Add(3 * time.Second), Latency: 0, Up: false},
+			{Time: start.Add(4 * time.Second), Latency: 1e-9, Up: true},
+			{Time: start.Add(5 * time.Second), Latency: math.MaxFloat64, Up: true},
+			{Time: start.Add(6 * time.Second), Latency: -math.MaxFloat64, Up: true},
+			{Time: start.Add(7 * time.Second), Latency: math.Float64frombits(1), Up: true},
+			{Time: start.Add(8 * time.Second), Latency: 3, Up: true},
+		}},
+		{"metrics", []Sample{
+			{Time: start, Latency: 5, Up: true, Metrics: map[string]float64{"download_mbps": 94.2}},
+			{Time: start.Add(time.Minute), Latency: 6, Up: true},
+			{Time: start.Add(2 * time.Minute), Latency: 7, Up: true, Metrics: map[string]float64{"download_mbps": 91, "upload_mbps": 40.5}},
+		}},
+	}
+	for _, tt := range tests {
+		c := chunkOf(tt.samples)
+		got, err := c.samples()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameSamples(got, tt.samples) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.samples)
+		}
+		if !c.first.Equal(tt.samples[0].Time) || !c.last.Equal(tt.samples[len(tt.samples)-1].Time) {
+			t.Errorf("%s: chunk spans %v-%v", tt.name, c.first, c.last)
+		}
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	c := newChunk()
+	start := time.UnixMilli(1767225600000)
+	for i := range chunkSamples {
+		if c.full() {
+			t.Fatalf("full after %d samples", i)
+		}
+		c.append(Sample{Time: start.Add(time.Duration(i) * 30 * time.Second), Latency: 20, Up: true})
+	}
+	if !c.full() {
+		t.Error("not full after chunkSamples samples")
+	}
+	// After the first two samples, which set the delta, a steady host takes
+	// a bit each for the time, the value and Up.
+	if want := (2*64 + 1 + 3 + 17 + 2 + (chunkSamples-2)*3 + 7) / 8; c.size() != want {
+		t.Errorf("steady chunk takes %d bytes, want %d", c.size(), want)
+	}
+}
+
+func TestChunkTruncated(t *testing.T) {
+	start := time.UnixMilli(1767225600000)
+	c := chunkOf([]Sample{
+		{Time: start, Latency: 10, Up: true},
+		{Time: start.Add(time.Second), Latency: 11.5, Up: true},
+		{Time: start.Add(5 * time.Second), Latency: 9, Up: false},
+	})
+	full := c.data.buf
+	for n := range len(full) - 1 {
+		c.data.buf = full[:n]
+		if _, err := c.samples(); err != errShortChunk {
+			t.Errorf("%d of %d bytes: error %v", n, len(full), err)
+		}
+	}
+}
+
+func TestBitWriter(t *testing.T) {
+	var w bitWriter
+	w.writeBit(true)
+	w.writeBits(0b0110, 4)
+	w.writeBits(0xabcdef, 24)
+	w.writeBits(math.MaxUint64, 64)
+	if len(w.buf) != 12 || w.free != 3 {
+		t.Fatalf("%d bytes, %d free bits", len(w.buf), w.free)
+	}
+	r := bitReader{buf: w.buf}
+	tests := []struct {
+		n    int
+		want uint64
+	}{
+		{1, 1},
+		{4, 0b0110},
+		{24, 0xabcdef},
+		{64, math.MaxUint64},
+		{3, 0},
+	}
+	for _, tt := range tests {
+		if got, err := r.readBits(tt.n); err != nil || got != tt.want {
+			t.Errorf("%d bits: %#x, %v; want %#x", tt.n, got, err, tt.want)
+		}
+	}
+	if _, err := r.readBit(); err != errShortChunk {
+		t.Errorf("read past the end: %v", err)
+	}
+}
+
+func TestChunkFile(t *testing.T) {
+	start := time.UnixMilli(1767225600000)
+	a := chunkOf([]Sample{
+		{Time: start, Latency: 10, Up: true},
+		{Time: start.Add(30 * time.Second), Latency: 12, Up: true, Metrics: map[string]float64{"jitter": 1.5}},
+	})
+	b := chunkOf([]Sample{{Time: start.Add(time.Hour), Up: false}})
+	path := filepath.Join(t.TempDir(), "2026-01-01.chunks")
+	if err := writeChunkFile(path, []hostChunk{{"192.0.2.1", a}, {"example.com", b}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	chunks, err := readChunkFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 2 || chunks[0].host != "192.0.2.1" || chunks[1].host != "example.com" {
+		t.Fatalf("chunks %+v", chunks)
+	}
+	for i, want := range []*chunk{a, b} {
+		c := chunks[i]
+		if !c.sealed || !c.full() || c.count != want.count || !c.first.Equal(want.first) || !c.last.Equal(want.last) {
+			t.Errorf("%s: %d samples %v-%v, sealed %v", c.host, c.count, c.first, c.last, c.sealed)
+		}
+		got, err := c.samples()
+		if err != nil {
+			t.Errorf("%s: %v", c.host, err)
+			continue
+		}
+		if wantSamples, _ := want.samples(); !sameSamples(got, wantSamples) {
+			t.Errorf("%s: got %v, want %v", c.host, got, wantSamples)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "not a chunk file"},
+		{"other header", []byte("netmonitor chunks 2\n"), "not a chunk file"},
+		{"no chunks", []byte(chunkFileHeader), ""},
+		{"truncated", data[:len(data)-3], "EOF"},
+		{"huge field", append([]byte(chunkFileHeader), 0xff, 0xff, 0xff, 0xff, 0x0f), "chunk field of"},
+		{"no samples", append([]byte(chunkFileHeader), 1, 'h', 0, 0, 0, 0), "invalid chunk of 0 samples"},
+		{"short data", append([]byte(chunkFileHeader), 1, 'h', 1, 0, 2, 0, 0, 0), "invalid chunk of 1 samples"},
+		{"bad metrics", append([]byte(chunkFileHeader), 1, 'h', 1, 0, 0, 1, '{'), "unexpected end of JSON"},
+	}
+	for _, tt := range tests {
+		_, err := readChunks(bufio.NewReader(bytes.NewReader(tt.data)))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+// Clock is where the monitor takes the time from: when probes are due,
+// when results and alerts happened and how long a host has been down.
+// Tests can drive a fake one to run the scheduler and alerting without
+// waiting.
+type Clock interface {
+	Now() time.Time
+	// NewTicker sends the time every d until stop is called.
+	NewTicker(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// since is time.Since by the monitor's clock.
+func (m *Monitor) since(t time.Time) time.Duration {
+	return m.clock.Now().Sub(t)
+}
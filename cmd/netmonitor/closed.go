@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Closed targets assert that ports of a host are not reachable from the
+// monitor, such as remote desktop and telnet from a DMZ:
+//
+//	closed://10.0.0.5?ports=23,3389
+//	closed://dmz-web.example.com?ports=1-1024
+//
+// The host is up while every port refuses connections or does not answer,
+// and goes down as soon as one accepts a TCP connection, naming the open
+// ports, so a firewall rule that regresses raises an alert. A host that
+// is unreachable altogether passes too; pair it with a ping to tell.
+func init() {
+	probers["closed"] = probeClosed
+	probeDurations["closed"] = func(t *target) time.Duration {
+		ports, _ := closedPorts(t)
+		batches := (len(ports) + portScanParallel - 1) / portScanParallel
+		return time.Duration(max(batches, 1)) * t.timeout()
+	}
+}
+
+func probeClosed(t *target) (probeResult, error) {
+	ports, err := closedPorts(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	start := time.Now()
+	open, err := scanPorts(t, ports)
+	if err != nil {
+		return probeResult{}, err
+	}
+	if len(open) > 0 {
+		list := strings.Trim(fmt.Sprint(open), "[]")
+		return probeResult{}, fmt.Errorf("ports that should be closed accept connections: %s", strings.ReplaceAll(list, " ", ", "))
+	}
+	return probeResult{
+		Latency: msSince(start),
+		Metrics: map[string]float64{"ports_checked": float64(len(ports))},
+	}, nil
+}
+
+// closedPorts returns the ports a closed target checks.
+func closedPorts(t *target) ([]int, error) {
+	spec := t.param("ports", "")
+	if spec == "" {
+		return nil, errors.New("missing ports")
+	}
+	return parsePorts(strings.Split(spec, ","))
+}
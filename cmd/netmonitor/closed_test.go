@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenPort returns the port of a TCP listener, or of one already closed.
+func listenPort(t *testing.T, keep bool) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keep {
+		t.Cleanup(func() { l.Close() })
+	} else {
+		l.Close()
+	}
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbeClosed(t *testing.T) {
+	open1, open2 := listenPort(t, true), listenPort(t, true)
+	closed1, closed2 := listenPort(t, false), listenPort(t, false)
+	first, second := min(open1, open2), max(open1, open2)
+	tests := []struct {
+		name  string
+		ports string
+		count int
+		err   string
+	}{
+		{"closed", fmt.Sprintf("%d,%d", closed1, closed2), 2, ""},
+		{"duplicates", fmt.Sprintf("%d, %d,%d", closed1, closed1, closed2), 2, ""},
+		{"one open", fmt.Sprintf("%d,%d", closed1, open1), 0, fmt.Sprintf("ports that should be closed accept connections: %d", open1)},
+		{"both open", fmt.Sprintf("%d,%d,%d", second, closed1, first), 0, fmt.Sprintf("ports that should be closed accept connections: %d, %d", first, second)},
+		{"range", fmt.Sprintf("%d-%d", open1, open1), 0, fmt.Sprintf("ports that should be closed accept connections: %d", open1)},
+		{"missing", "", 0, "missing ports"},
+		{"zero", "0", 0, `invalid port "0"`},
+		{"too high", "65530-65536", 0, `invalid port "65530-65536"`},
+		{"backwards", "25-23", 0, `invalid port "25-23"`},
+		{"name", "telnet", 0, `invalid port "telnet"`},
+		{"too many", "1-5000", 0, "5000 ports to scan, at most 4096 allowed"},
+	}
+	for _, tt := range tests {
+		raw := "closed://127.0.0.1"
+		if tt.ports != "" {
+			raw += "?ports=" + tt.ports
+		}
+		tgt, err := parseTarget(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeClosed(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Metrics["ports_checked"] != float64(tt.count) {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+		}
+	}
+}
+
+func TestClosedProbeDuration(t *testing.T) {
+	tests := []struct {
+		ports string
+		want  time.Duration
+	}{
+		{"23", 2 * time.Second},
+		{"1-64", 2 * time.Second},
+		{"1-65", 4 * time.Second},
+		{"1-1024", 32 * time.Second},
+		{"nope", 2 * time.Second},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("closed://192.0.2.1?ports=" + tt.ports)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgt.retry.Timeout = 2 * time.Second
+		if got := probeDurations["closed"](tgt); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.ports, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cloud providers only offer listing instances, so their discovery polls.
+//
+//	aws://eu-west-1?tag=env%3Dprod
+//	gcp://my-project?tag=role%3Dweb&address=public
+//	azure://SUBSCRIPTION_ID?resource_group=prod&refresh=5m
+//
+// Every cloud provider takes these parameters besides the hostTemplate ones:
+// tag filters instances by tag (labels on GCP), either key=value or just a
+// key that must be present, and can be repeated; address picks the private
+// (default) or public IP; refresh sets how often to list, a minute unless
+// set. Only running instances are monitored.
+type cloudOptions struct {
+	filter   []cloudTag
+	public   bool
+	refresh  time.Duration
+	template hostTemplate
+}
+
+// cloudTag is a tag instances must carry, with the value unless any.
+type cloudTag struct {
+	key, value string
+	any        bool
+}
+
+func newCloudOptions(q url.Values) (cloudOptions, error) {
+	o := cloudOptions{refresh: time.Minute, template: newHostTemplate(q)}
+	for _, tag := range q["tag"] {
+		key, value, ok := strings.Cut(tag, "=")
+		o.filter = append(o.filter, cloudTag{key: key, value: value, any: !ok})
+	}
+	switch q.Get("address") {
+	case "", "private":
+	case "public":
+		o.public = true
+	default:
+		return o, fmt.Errorf("address must be private or public, not %q", q.Get("address"))
+	}
+	if s := q.Get("refresh"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < time.Second {
+			return o, fmt.Errorf("invalid refresh %q", s)
+		}
+		o.refresh = d
+	}
+	return o, nil
+}
+
+func (o cloudOptions) matches(tags map[string]string) bool {
+	for _, t := range o.filter {
+		value, ok := tags[t.key]
+		if !ok || !t.any && value != t.value {
+			return false
+		}
+	}
+	return true
+}
+
+// host returns the entry for an instance, or false if it does not match
+// the filter or lacks the wanted address.
+func (o cloudOptions) host(name, private, public string, tags map[string]string) (HostConfig, bool) {
+	address := private
+	if o.public {
+		address = public
+	}
+	if address == "" || !o.matches(tags) {
+		return HostConfig{}, false
+	}
+	return o.template.host(address, "", name, nil), true
+}
+
+// pollDiscoverer lists hosts every refresh interval.
+type pollDiscoverer struct {
+	list    func() ([]HostConfig, error)
+	refresh time.Duration
+}
+
+func (d *pollDiscoverer) run(update func([]HostConfig)) error {
+	for {
+		hosts, err := d.list()
+		if err != nil {
+			return err
+		}
+		update(hosts)
+		time.Sleep(d.refresh)
+	}
+}
+
+var cloudClient = &http.Client{Timeout: 30 * time.Second}
+
+// cloudJSON sends a request and decodes its JSON response.
+func cloudJSON(req *http.Request, v any) error {
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// metadataText returns the body of a request to an instance metadata
+// service.
+func metadataText(req *http.Request) (string, error) {
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", req.URL.Path, resp.Status)
+	}
+	return string(b), err
+}
+
+// oauthToken caches an access token until shortly before it expires.
+type oauthToken struct {
+	fetch   func() (*http.Request, error) // request to an OAuth token endpoint
+	token   string
+	expires time.Time
+}
+
+func (t *oauthToken) get() (string, error) {
+	if t.token != "" && time.Until(t.expires) > time.Minute {
+		return t.token, nil
+	}
+	req, err := t.fetch()
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		// A number, but a string from Azure's managed identity endpoint.
+		ExpiresIn json.Number `json:"expires_in"`
+	}
+	if err := cloudJSON(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.AccessToken == "" {
+		return "", errors.New("no access token in response")
+	}
+	ttl, _ := resp.ExpiresIn.Int64()
+	t.token, t.expires = resp.AccessToken, time.Now().Add(time.Duration(ttl)*time.Second)
+	return t.token, nil
+}
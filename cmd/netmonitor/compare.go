@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Comparison sets a period against the one before it, host by host, to show
+// whether a link got worse and by how much: evidence to take to a
+// provider.
+type Comparison struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	BaselineFrom time.Time        `json:"baselineFrom"`
+	BaselineTo   time.Time        `json:"baselineTo"`
+	Current      PeriodSummary    `json:"current"`
+	Baseline     PeriodSummary    `json:"baseline"`
+	Hosts        []HostComparison `json:"hosts"`
+}
+
+// PeriodSummary is how a host, or all of them, did over one period. Loss
+// is the percentage of probes that failed. The P95 latency is only known
+// per host.
+type PeriodSummary struct {
+	Samples    int     `json:"samples"`
+	Uptime     float64 `json:"uptime"`
+	Loss       float64 `json:"loss"`
+	AvgLatency float64 `json:"avgLatency"`
+	P95Latency float64 `json:"p95Latency,omitempty"`
+	Incidents  int     `json:"incidents"`
+
+	answered float64 // probes answered, while adding up hosts
+}
+
+// HostComparison holds the two periods of a host and the changes between
+// them: latency in ms, loss in percentage points. Deltas are 0 when either
+// period has no data.
+type HostComparison struct {
+	Host           string        `json:"host"`
+	Tags           []string      `json:"tags,omitempty"`
+	Current        PeriodSummary `json:"current"`
+	Baseline       PeriodSummary `json:"baseline"`
+	LatencyDelta   float64       `json:"latencyDelta"`
+	P95Delta       float64       `json:"p95Delta"`
+	LossDelta      float64       `json:"lossDelta"`
+	IncidentsDelta int           `json:"incidentsDelta"`
+}
+
+func summarize(h HostReport) PeriodSummary {
+	s := PeriodSummary{Samples: h.Samples, Uptime: h.Uptime, AvgLatency: h.AvgLatency, P95Latency: h.P95Latency, Incidents: h.Incidents}
+	if h.Samples > 0 {
+		s.Loss = 100 - h.Uptime
+	}
+	return s
+}
+
+// add counts the samples of a host into a summary of all of them, summing
+// latencies until finish averages them.
+func (s *PeriodSummary) add(h HostReport) {
+	s.Samples += h.Samples
+	answered := float64(h.Samples) * h.Uptime / 100
+	s.AvgLatency += h.AvgLatency * answered
+	s.answered += answered
+}
+
+func (s *PeriodSummary) finish() {
+	if s.answered > 0 {
+		s.AvgLatency /= s.answered
+	}
+	if s.Samples > 0 {
+		s.Loss = 100 - s.Uptime
+	}
+}
+
+// buildComparison compares [from, to) with [baselineFrom, baselineTo).
+// Hosts that got worse come first: most added loss, then most added
+// latency.
+func (m *Monitor) buildComparison(from, to, baselineFrom, baselineTo time.Time) *Comparison {
+	current := m.buildReport("comparison", from, to)
+	baseline := m.buildReport("comparison", baselineFrom, baselineTo)
+	c := &Comparison{
+		From:         from,
+		To:           to,
+		BaselineFrom: baselineFrom,
+		BaselineTo:   baselineTo,
+		Current:      PeriodSummary{Uptime: current.Uptime, Incidents: len(current.Incidents)},
+		Baseline:     PeriodSummary{Uptime: baseline.Uptime, Incidents: len(baseline.Incidents)},
+	}
+	before := make(map[string]HostReport, len(baseline.Hosts))
+	for _, h := range baseline.Hosts {
+		before[h.Host] = h
+		c.Baseline.add(h)
+	}
+	for _, h := range current.Hosts {
+		c.Current.add(h)
+		hc := HostComparison{
+			Host:     h.Host,
+			Tags:     h.Tags,
+			Current:  summarize(h),
+			Baseline: summarize(before[h.Host]),
+		}
+		hc.IncidentsDelta = hc.Current.Incidents - hc.Baseline.Incidents
+		if hc.Current.Samples > 0 && hc.Baseline.Samples > 0 {
+			hc.LossDelta = hc.Current.Loss - hc.Baseline.Loss
+			if hc.Current.AvgLatency > 0 && hc.Baseline.AvgLatency > 0 {
+				hc.LatencyDelta = hc.Current.AvgLatency - hc.Baseline.AvgLatency
+				hc.P95Delta = hc.Current.P95Latency - hc.Baseline.P95Latency
+			}
+		}
+		c.Hosts = append(c.Hosts, hc)
+	}
+	c.Current.finish()
+	c.Baseline.finish()
+	slices.SortStableFunc(c.Hosts, func(a, b HostComparison) int {
+		return cmp.Or(
+			cmp.Compare(b.LossDelta, a.LossDelta),
+			cmp.Compare(b.LatencyDelta, a.LatencyDelta),
+			cmp.Compare(b.IncidentsDelta, a.IncidentsDelta),
+		)
+	})
+	return c
+}
+
+func (c *Comparison) Title() string {
+	return fmt.Sprintf("Network Monitor comparison %s – %s", c.From.Format("2006-01-02"), c.To.Format("2006-01-02"))
+}
+
+// LossDelta is the change of the loss over all hosts, in percentage
+// points.
+func (c *Comparison) LossDelta() float64 {
+	if c.Current.Samples == 0 || c.Baseline.Samples == 0 {
+		return 0
+	}
+	return c.Current.Loss - c.Baseline.Loss
+}
+
+// LatencyDelta is the change of the average latency over all hosts.
+func (c *Comparison) LatencyDelta() float64 {
+	if c.Current.AvgLatency == 0 || c.Baseline.AvgLatency == 0 {
+		return 0
+	}
+	return c.Current.AvgLatency - c.Baseline.AvgLatency
+}
+
+// IncidentsDelta is the change of the number of incidents.
+func (c *Comparison) IncidentsDelta() int {
+	return c.Current.Incidents - c.Baseline.Incidents
+}
+
+var comparisonFuncs = template.FuncMap{
+	"ms":      reportFuncs["ms"],
+	"percent": reportFuncs["percent"],
+	"join":    reportFuncs["join"],
+	// delta formats a change with format, marking increases, which are
+	// worse for every measure compared, as bad.
+	"delta": func(v any, format string) template.HTML {
+		var f float64
+		switch v := v.(type) {
+		case int:
+			f = float64(v)
+		case float64:
+			f = v
+		}
+		s := template.HTMLEscapeString(fmt.Sprintf(format, f))
+		switch {
+		case f >= 0.005:
+			return template.HTML(`<span class="bad">` + s + `</span>`)
+		case f <= -0.005:
+			return template.HTML(`<span class="good">` + s + `</span>`)
+		}
+		return "–"
+	},
+}
+
+var comparisonTemplate = template.Must(template.New("comparison").Funcs(comparisonFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+    body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; margin: 0; padding: 20px; background: #f5f5f5; color: #333; }
+    .container { max-width: 1100px; margin: 0 auto; }
+    .card { background: white; border-radius: 8px; padding: 20px; margin-bottom: 20px; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+    table { width: 100%; border-collapse: collapse; font-size: 14px; }
+    th, td { text-align: left; padding: 8px; border-bottom: 1px solid #f0f0f0; }
+    th { color: #666; font-weight: normal; }
+    .good { color: #4caf50; }
+    .bad { color: #f44336; }
+    .muted { color: #999; }
+</style>
+</head>
+<body>
+<div class="container">
+<h1>{{.Title}}</h1>
+<p class="muted">{{.From.Format "Mon 2006-01-02 15:04"}} – {{.To.Format "Mon 2006-01-02 15:04 MST"}},
+compared with {{.BaselineFrom.Format "Mon 2006-01-02 15:04"}} – {{.BaselineTo.Format "Mon 2006-01-02 15:04 MST"}}</p>
+
+<div class="card">
+    <table>
+        <tr><th></th><th>Before</th><th>Now</th><th>Change</th></tr>
+        <tr><td>Failed probes</td><td>{{percent .Baseline.Loss}}</td><td>{{percent .Current.Loss}}</td><td>{{delta .LossDelta "%+.2f pp"}}</td></tr>
+        <tr><td>Average latency</td><td>{{ms .Baseline.AvgLatency}}</td><td>{{ms .Current.AvgLatency}}</td><td>{{delta .LatencyDelta "%+.2f ms"}}</td></tr>
+        <tr><td>Incidents</td><td>{{.Baseline.Incidents}}</td><td>{{.Current.Incidents}}</td><td>{{delta .IncidentsDelta "%+.0f"}}</td></tr>
+    </table>
+</div>
+
+<div class="card">
+    <h2>Hosts</h2>
+    <table>
+        <tr><th>Host</th><th>Loss before</th><th>Loss now</th><th></th><th>Avg before</th><th>Avg now</th><th></th><th>P95</th><th>Incidents</th><th></th></tr>
+        {{range .Hosts}}
+        <tr>
+            <td>{{.Host}}{{with .Tags}} <span class="muted">{{join . ", "}}</span>{{end}}</td>
+            <td>{{if .Baseline.Samples}}{{percent .Baseline.Loss}}{{else}}<span class="muted">no data</span>{{end}}</td>
+            <td>{{if .Current.Samples}}{{percent .Current.Loss}}{{else}}<span class="muted">no data</span>{{end}}</td>
+            <td>{{delta .LossDelta "%+.2f pp"}}</td>
+            <td>{{ms .Baseline.AvgLatency}}</td>
+            <td>{{ms .Current.AvgLatency}}</td>
+            <td>{{delta .LatencyDelta "%+.2f ms"}}</td>
+            <td>{{delta .P95Delta "%+.2f ms"}}</td>
+            <td>{{.Baseline.Incidents}} → {{.Current.Incidents}}</td>
+            <td>{{delta .IncidentsDelta "%+.0f"}}</td>
+        </tr>
+        {{end}}
+    </table>
+</div>
+</div>
+</body>
+</html>
+`))
+
+// HTML renders the comparison as a standalone page.
+func (c *Comparison) HTML() ([]byte, error) {
+	var b bytes.Buffer
+	if err := comparisonTemplate.Execute(&b, c); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// handleComparison serves GET /api/report/compare, comparing the last
+// period=daily|weekly with the one before, or from/to with the span of the
+// same length before it, or offset earlier when set (such as 168h for the
+// same days a week before). format=html renders a page instead of JSON.
+func (m *Monitor) handleComparison(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var from, to time.Time
+	if q.Has("from") || q.Has("to") {
+		var err error
+		if from, to, err = parseTimeRange(r, 7*24*time.Hour); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !from.Before(to) {
+			writeError(w, http.StatusBadRequest, "from must be before to")
+			return
+		}
+	} else {
+		period := cmp.Or(q.Get("period"), "weekly")
+		span, ok := reportSpans[period]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "period must be daily or weekly")
+			return
+		}
+		to = time.Now()
+		from = to.Add(-span)
+	}
+	offset := to.Sub(from)
+	if v := q.Get("offset"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid offset: %q", v))
+			return
+		}
+		offset = d
+	}
+	c := m.buildComparison(from, to, from.Add(-offset), to.Add(-offset))
+
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, http.StatusOK, c)
+	case "html":
+		html, err := c.HTML()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or html")
+	}
+}
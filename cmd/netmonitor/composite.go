@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Composite targets are a service made of several probes, shown and
+// alerted on as one host:
+//
+//	hosts:
+//	  - target: composite://mail
+//	    name: Mail
+//	    checks:
+//	      ping: mail.example.com
+//	      smtp: smtp://mail.example.com?starttls=true
+//	      imap: imaps://mail.example.com
+//	    expression: ping && smtp
+//
+// The checks run at once on every probe. The service is up while all of
+// them pass, degraded while some fail but the expression over their names
+// still holds, and down once it does not. Without an expression every
+// check has to pass. Expressions combine names with && (and), || (or), !
+// (not) and parentheses. The latency is that of the slowest check, and
+// each check's latency is a metric of its own.
+func init() {
+	probers["composite"] = probeComposite
+	probeDurations["composite"] = func(t *target) time.Duration {
+		var longest time.Duration
+		for _, c := range t.composite.checks {
+			d := c.retry.duration()
+			if sub, ok := probeDurations[c.kind]; ok {
+				d += sub(c.target)
+			}
+			longest = max(longest, d)
+		}
+		return longest
+	}
+}
+
+// composite is the checks of a composite target.
+type composite struct {
+	checks     []compositeCheck // by name
+	expression compositeExpr
+	source     string // of the expression
+}
+
+type compositeCheck struct {
+	name string
+	*target
+}
+
+// compositeExpr reports whether an expression holds for the checks up.
+type compositeExpr func(up map[string]bool) bool
+
+// newComposite parses the checks and expression of the composite target
+// t, whose source, DSCP marking, proxy and timeout they share.
+func newComposite(t *target, checks map[string]string, expression string) (*composite, error) {
+	if len(checks) == 0 {
+		return nil, errors.New("composite targets need checks")
+	}
+	c := &composite{source: expression}
+	for _, name := range slices.Sorted(maps.Keys(checks)) {
+		if !isCheckName(name) || name == "and" || name == "or" || name == "not" {
+			return nil, fmt.Errorf("invalid check name %q", name)
+		}
+		sub, err := parseTarget(checks[name])
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %v", name, err)
+		}
+		if sub.kind == "composite" {
+			return nil, fmt.Errorf("check %s: composite targets cannot be nested", name)
+		}
+		sub.tags, sub.source, sub.iface, sub.dscp, sub.proxy = t.tags, t.source, t.iface, t.dscp, t.proxy
+		sub.retry = RetryPolicy{Attempts: 1, Timeout: t.retry.Timeout}
+		if raw := sub.param("timeout", ""); raw != "" {
+			if sub.retry.Timeout, err = time.ParseDuration(raw); err != nil || sub.retry.Timeout <= 0 {
+				return nil, fmt.Errorf("check %s: invalid timeout: %s", name, raw)
+			}
+		}
+		sub.resolveTTL = t.resolveTTL
+		c.checks = append(c.checks, compositeCheck{name, sub})
+	}
+	if strings.TrimSpace(expression) == "" {
+		c.expression = func(up map[string]bool) bool {
+			for _, check := range c.checks {
+				if !up[check.name] {
+					return false
+				}
+			}
+			return true
+		}
+		return c, nil
+	}
+	var err error
+	if c.expression, err = parseCompositeExpr(expression, checks); err != nil {
+		return nil, fmt.Errorf("expression: %v", err)
+	}
+	return c, nil
+}
+
+// isCheckName reports whether s is made of letters, digits, _ and -.
+func isCheckName(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+	}) < 0
+}
+
+// compositeParser is a recursive descent parser of expressions:
+//
+//	or   = and { ("||" | "or") and }
+//	and  = not { ("&&" | "and") not }
+//	not  = ("!" | "not") not | name | "(" or ")"
+type compositeParser struct {
+	tokens []string
+	pos    int
+	checks map[string]string
+}
+
+func parseCompositeExpr(s string, checks map[string]string) (compositeExpr, error) {
+	tokens, err := tokenizeCompositeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &compositeParser{tokens: tokens, checks: checks}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func tokenizeCompositeExpr(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ' || s[i] == '\t':
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case s[i] == '!' || s[i] == '(' || s[i] == ')':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		default:
+			j := i
+			for j < len(s) && isCheckName(s[j:j+1]) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %q", s[i:i+1])
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *compositeParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *compositeParser) or() (compositeExpr, error) {
+	left, err := p.and()
+	for err == nil && (p.peek() == "||" || p.peek() == "or") {
+		p.pos++
+		var right compositeExpr
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(up map[string]bool) bool { return l(up) || right(up) }
+		}
+	}
+	return left, err
+}
+
+func (p *compositeParser) and() (compositeExpr, error) {
+	left, err := p.not()
+	for err == nil && (p.peek() == "&&" || p.peek() == "and") {
+		p.pos++
+		var right compositeExpr
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(up map[string]bool) bool { return l(up) && right(up) }
+		}
+	}
+	return left, err
+}
+
+func (p *compositeParser) not() (compositeExpr, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "!", "not":
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(up map[string]bool) bool { return !e(up) }, nil
+	case "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return e, nil
+	case "":
+		return nil, errors.New("unexpected end")
+	case ")", "&&", "||", "and", "or":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	if _, ok := p.checks[token]; !ok {
+		return nil, fmt.Errorf("unknown check %q", token)
+	}
+	return func(up map[string]bool) bool { return up[token] }, nil
+}
+
+// compositeError lists the checks that failed.
+type compositeError []error
+
+func (e compositeError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e compositeError) Unwrap() []error { return e }
+
+func probeComposite(t *target) (probeResult, error) {
+	c := t.composite
+	results := make([]probeResult, len(c.checks))
+	errs := make([]error, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Go(func() {
+			results[i], errs[i] = probeWithRetries(check.target)
+		})
+	}
+	wg.Wait()
+
+	result := probeResult{Metrics: map[string]float64{}}
+	up := map[string]bool{}
+	var failed compositeError
+	var warnings []string
+	for i, check := range c.checks {
+		if errs[i] != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", check.name, errs[i]))
+			continue
+		}
+		up[check.name] = true
+		result.Latency = max(result.Latency, results[i].Latency)
+		result.Metrics[check.name+"_ms"] = results[i].Latency
+		if results[i].Warning != "" {
+			warnings = append(warnings, check.name+": "+results[i].Warning)
+		}
+	}
+	if !c.expression(up) {
+		if len(failed) == 0 {
+			return probeResult{}, fmt.Errorf("%s does not hold", c.source)
+		}
+		return probeResult{}, failed
+	}
+	if len(failed) > 0 {
+		warnings = append([]string{failed.Error()}, warnings...)
+	}
+	result.Warning = strings.Join(warnings, "; ")
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCompositeExpr(t *testing.T) {
+	checks := map[string]string{"ping": "", "smtp": "", "imap": "", "web-1": ""}
+	tests := []struct {
+		expr string
+		up   map[string]bool
+		want bool
+		err  string
+	}{
+		{"ping", map[string]bool{"ping": true}, true, ""},
+		{"ping && smtp", map[string]bool{"ping": true}, false, ""},
+		{"ping and smtp", map[string]bool{"ping": true, "smtp": true}, true, ""},
+		{"smtp || imap", map[string]bool{"imap": true}, true, ""},
+		{"smtp or imap", map[string]bool{}, false, ""},
+		{"!ping", map[string]bool{}, true, ""},
+		{"not not ping", map[string]bool{"ping": true}, true, ""},
+		{"ping && smtp || imap", map[string]bool{"imap": true}, true, ""},
+		{"ping && (smtp || imap)", map[string]bool{"imap": true}, false, ""},
+		{"!(smtp&&imap)", map[string]bool{"smtp": true}, true, ""},
+		{"web-1", map[string]bool{"web-1": true}, true, ""},
+		{"", nil, false, "unexpected end"},
+		{"ping &&", nil, false, "unexpected end"},
+		{"(ping", nil, false, "missing )"},
+		{"ping)", nil, false, `unexpected ")"`},
+		{"|| ping", nil, false, `unexpected "||"`},
+		{"ping smtp", nil, false, `unexpected "smtp"`},
+		{"ping & smtp", nil, false, `unexpected "&"`},
+		{"dns", nil, false, `unknown check "dns"`},
+	}
+	for _, tt := range tests {
+		e, err := parseCompositeExpr(tt.expr, checks)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.expr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if got := e(tt.up); got != tt.want {
+			t.Errorf("%q with %v up: %v, want %v", tt.expr, tt.up, got, tt.want)
+		}
+	}
+}
+
+func TestNewComposite(t *testing.T) {
+	cfg := &Config{}
+	tests := []struct {
+		name string
+		host HostConfig
+		err  string
+	}{
+		{"ok", HostConfig{Target: "composite://mail", Checks: map[string]string{"ping": "192.0.2.1", "smtp": "smtp://192.0.2.1?timeout=2s"}, Expression: "ping"}, ""},
+		{"no checks", HostConfig{Target: "composite://mail"}, "composite targets need checks"},
+		{"bad name", HostConfig{Target: "composite://mail", Checks: map[string]string{"a b": "192.0.2.1"}}, `invalid check name "a b"`},
+		{"operator name", HostConfig{Target: "composite://mail", Checks: map[string]string{"or": "192.0.2.1"}}, `invalid check name "or"`},
+		{"bad check", HostConfig{Target: "composite://mail", Checks: map[string]string{"web": "gopher://192.0.2.1"}}, "check web:"},
+		{"nested", HostConfig{Target: "composite://mail", Checks: map[string]string{"inner": "composite://other"}}, "check inner: composite targets cannot be nested"},
+		{"bad timeout", HostConfig{Target: "composite://mail", Checks: map[string]string{"smtp": "smtp://192.0.2.1?timeout=soon"}}, "check smtp: invalid timeout: soon"},
+		{"bad expression", HostConfig{Target: "composite://mail", Checks: map[string]string{"ping": "192.0.2.1"}, Expression: "ping && dns"}, `expression: unknown check "dns"`},
+		{"checks elsewhere", HostConfig{Target: "192.0.2.1", Checks: map[string]string{"ping": "192.0.2.1"}}, "checks and expression are for composite:// targets"},
+	}
+	for _, tt := range tests {
+		targets, err := cfg.hostTargets(tt.host)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || len(targets) != 1 || targets[0].composite == nil {
+			t.Errorf("%s: %+v, %v", tt.name, targets, err)
+			continue
+		}
+		c := targets[0].composite
+		if len(c.checks) != 2 || c.checks[0].name != "ping" || c.checks[1].name != "smtp" || c.checks[1].retry.Timeout != 2*time.Second || c.checks[0].retry.Attempts != 1 {
+			t.Errorf("%s: checks %+v", tt.name, c.checks)
+		}
+	}
+}
+
+func TestProbeComposite(t *testing.T) {
+	// Simulated checks that always answer, or never.
+	const up, slow, down = "sim://up?latency=10&jitter=0&loss=0&outages=0", "sim://slow?latency=30&jitter=0&loss=0&outages=0", "sim://down?loss=100"
+	tests := []struct {
+		name    string
+		checks  map[string]string
+		expr    string
+		metrics []string
+		warning string
+		err     string
+	}{
+		{"all up", map[string]string{"a": up, "b": slow}, "", []string{"a_ms", "b_ms"}, "", ""},
+		{"degraded", map[string]string{"a": up, "b": down}, "a", []string{"a_ms"}, "b: simulated timeout", ""},
+		{"down", map[string]string{"a": up, "b": down}, "", nil, "", "b: simulated timeout"},
+		{"holds without the failed check", map[string]string{"a": up, "b": down}, "a || b", []string{"a_ms"}, "b: simulated timeout", ""},
+		{"does not hold", map[string]string{"a": up, "b": slow}, "!a", nil, "", "!a does not hold"},
+	}
+	for _, tt := range tests {
+		targets, err := (&Config{}).hostTargets(HostConfig{Target: "composite://svc", Checks: tt.checks, Expression: tt.expr})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		result, err := probeComposite(targets[0])
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Warning != tt.warning || len(result.Metrics) != len(tt.metrics) {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+			continue
+		}
+		// The latency is that of the slowest check.
+		var slowest float64
+		for _, name := range tt.metrics {
+			v, ok := result.Metrics[name]
+			if !ok || v <= 0 {
+				t.Errorf("%s: metrics %v", tt.name, result.Metrics)
+			}
+			slowest = max(slowest, v)
+		}
+		if result.Latency != slowest {
+			t.Errorf("%s: latency %v, want %v", tt.name, result.Latency, slowest)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinSize is the smallest body worth compressing.
+const gzipMinSize = 1024
+
+// bufferedResponse holds a response until it is complete, so it can be
+// tagged and compressed.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// cached adds ETag/If-None-Match handling and gzip compression to API
+// responses that dashboards poll, so unchanged data costs a 304 and large
+// responses travel compressed.
+func cached(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := &bufferedResponse{ResponseWriter: w}
+		h(b, r)
+		if b.status == 0 {
+			b.status = http.StatusOK
+		}
+		body := b.body.Bytes()
+		header := w.Header()
+		header.Add("Vary", "Accept-Encoding")
+
+		if b.status == http.StatusOK {
+			// Weak, as the tag covers the content before compression.
+			sum := sha256.Sum256(body)
+			etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
+			header.Set("ETag", etag)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		if len(body) >= gzipMinSize && acceptsGzip(r) {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(body)
+			zw.Close()
+			body = buf.Bytes()
+			header.Set("Content-Encoding", "gzip")
+		}
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(b.status)
+		w.Write(body)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// comparison is used, as RFC 9110 requires for If-None-Match.
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
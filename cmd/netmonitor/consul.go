@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	discovererFactories["consul"] = newConsulDiscoverer
+}
+
+// consulDiscoverer monitors the instances of services registered in the
+// Consul catalog, following changes with blocking queries:
+//
+//	consul://localhost:8500?service=web,api&tag=prod
+//	consul://consul.example.com?tls=true&dc=eu1&token=SECRET
+//
+// Without service, every service but Consul itself is monitored. Each tag
+// parameter must be among an instance's tags for it to be monitored, and
+// the instance's tags are added to its host's. The ACL token can also come
+// from $CONSUL_HTTP_TOKEN.
+type consulDiscoverer struct {
+	endpoint string
+	services []string // empty for all
+	tags     []string // required service tags
+	dc       string
+	token    string
+	template hostTemplate
+	http     *http.Client
+}
+
+// consulWait is how long Consul may hold a blocking query open.
+const consulWait = 5 * time.Minute
+
+func newConsulDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	scheme := "http"
+	if q.Get("tls") == "true" {
+		scheme = "https"
+	}
+	host := cmp.Or(u.Host, "localhost:8500")
+	if u.Port() == "" && u.Host != "" {
+		host = net.JoinHostPort(u.Hostname(), "8500")
+	}
+	return &consulDiscoverer{
+		endpoint: scheme + "://" + host,
+		services: splitList(q.Get("service")),
+		tags:     q["tag"],
+		dc:       q.Get("dc"),
+		token:    cmp.Or(q.Get("token"), os.Getenv("CONSUL_HTTP_TOKEN")),
+		template: newHostTemplate(q),
+		http:     &http.Client{Timeout: consulWait + 30*time.Second},
+	}, nil
+}
+
+// consulInstance is an entry of /v1/catalog/service/:service.
+type consulInstance struct {
+	Node           string
+	Address        string
+	ServiceID      string
+	ServiceName    string
+	ServiceAddress string
+	ServicePort    int
+	ServiceTags    []string
+}
+
+func (d *consulDiscoverer) run(update func([]HostConfig)) error {
+	var index uint64
+	for {
+		var catalog map[string][]string // service name to tags
+		next, err := d.get("/v1/catalog/services", index, true, &catalog)
+		if err != nil {
+			return err
+		}
+		if next == index {
+			continue // the query timed out without changes
+		}
+		// An index going backwards means Consul's state was reset, so
+		// start over with a query that returns at once.
+		if next < index {
+			index = 0
+		} else {
+			index = max(next, 1)
+		}
+
+		var hosts []HostConfig
+		for name, tags := range catalog {
+			if name == "consul" && len(d.services) == 0 ||
+				len(d.services) > 0 && !slices.Contains(d.services, name) ||
+				!containsAll(tags, d.tags) {
+				continue
+			}
+			var instances []consulInstance
+			if _, err := d.get("/v1/catalog/service/"+url.PathEscape(name), 0, false, &instances); err != nil {
+				return err
+			}
+			for _, in := range instances {
+				if !containsAll(in.ServiceTags, d.tags) {
+					continue
+				}
+				port := ""
+				if in.ServicePort > 0 {
+					port = strconv.Itoa(in.ServicePort)
+				}
+				hosts = append(hosts, d.template.host(cmp.Or(in.ServiceAddress, in.Address), port, in.ServiceName+" on "+in.Node, in.ServiceTags))
+			}
+		}
+		slices.SortFunc(hosts, func(a, b HostConfig) int { return strings.Compare(a.DisplayName, b.DisplayName) })
+		update(hosts)
+	}
+}
+
+// get decodes a Consul API response, returning its X-Consul-Index. A
+// blocking query waits until the index passes the given one or consulWait
+// passes.
+func (d *consulDiscoverer) get(path string, index uint64, blocking bool, v any) (uint64, error) {
+	query := url.Values{}
+	if d.dc != "" {
+		query.Set("dc", d.dc)
+	}
+	if blocking {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), consulWait+30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if d.token != "" {
+		req.Header.Set("X-Consul-Token", d.token)
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("%s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	index, _ = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return index, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// containsAll reports whether tags includes every one of want.
+func containsAll(tags, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(tags, w) {
+			return false
+		}
+	}
+	return true
+}
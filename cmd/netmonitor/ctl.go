@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ctlClient calls the API of a running instance for `netmonitor ctl`.
+type ctlClient struct {
+	server string // base URL, including any base path
+	token  string
+	json   bool // print the API's JSON instead of tables
+	out    io.Writer
+}
+
+const ctlUsage = `Usage: netmonitor ctl [flags] COMMAND [ARGS]
+
+Talks to a running netmonitor.
+
+Commands:
+  status [-status down,degraded] [-tags prod,db] [-watch]
+                        hosts and their state, kept up to date with -watch
+  add-host [-tags prod,db] [-name NAME] TARGET
+                        monitor another host
+  remove-host TARGET    stop monitoring a host added with add-host
+  pause HOST            stop probing HOST, keeping its statistics
+  resume HOST           probe a paused host again
+  reset [-status down] [-tags prod] [HOST]
+                        start the statistics of HOST, or of every
+                        matching host, afresh
+  silence [-note TEXT] HOST
+                        acknowledge the open incidents of HOST, which
+                        stops their reminders
+  events [-open] [-n 20]
+                        incidents, newest first
+  backup [-history] [FILE]
+                        save the config and state, and the history with
+                        -history, to FILE or standard output, for
+                        netmonitor restore
+
+Flags:
+`
+
+func runCtl(args []string) error {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	server := flags.String("server", cmp.Or(os.Getenv("NETMONITOR_URL"), "http://localhost:8080"), "URL of the instance, including any base path ($NETMONITOR_URL)")
+	token := flags.String("token", os.Getenv("NETMONITOR_TOKEN"), "API token to authenticate with ($NETMONITOR_TOKEN)")
+	output := flags.String("o", "table", "Output format: table or json; also accepted after the command")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output format %q, use -o table or json", *output)
+	}
+	c := &ctlClient{server: strings.TrimSuffix(*server, "/"), token: *token, json: *output == "json", out: os.Stdout}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "status":
+		return c.status(args)
+	case "add-host":
+		return c.addHost(args)
+	case "remove-host":
+		return c.removeHost(args)
+	case "pause", "resume":
+		return c.pause(command, args)
+	case "reset":
+		return c.reset(args)
+	case "silence":
+		return c.silence(args)
+	case "events":
+		return c.events(args)
+	case "backup":
+		return c.backup(args)
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown command %q", command)
+}
+
+// call makes an API request, decoding the JSON response into v unless it
+// is nil. With -o json the response is printed as well when print is set.
+func (c *ctlClient) call(method, path string, body, v any, print bool) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.server+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, path, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if print && c.json && len(b) > 0 {
+		var out bytes.Buffer
+		if json.Indent(&out, b, "", "  ") == nil {
+			out.WriteByte('\n')
+			out.WriteTo(c.out)
+		}
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// flagSet returns the flags of a command, which include -o.
+func (c *ctlClient) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Func("o", "Output format: table or json", func(v string) error {
+		if v != "table" && v != "json" {
+			return errors.New("use table or json")
+		}
+		c.json = v == "json"
+		return nil
+	})
+	return flags
+}
+
+func (c *ctlClient) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+}
+
+func (c *ctlClient) status(args []string) error {
+	flags := c.flagSet("status")
+	status := flags.String("status", "", "Only hosts in these states, comma-separated")
+	tags := flags.String("tags", "", "Only hosts with any of these tags, comma-separated")
+	watch := flags.Bool("watch", false, "Keep the table up to date as results come in")
+	flags.Parse(args)
+	q := url.Values{}
+	if *tags != "" {
+		q.Set("tag", *tags)
+	}
+	if *watch {
+		return c.watch(q, splitList(*status))
+	}
+	if *status != "" {
+		q.Set("status", *status)
+	}
+	var stats []PingStats
+	if err := c.call(http.MethodGet, "/api/stats?"+q.Encode(), nil, &stats, true); err != nil {
+		return err
+	}
+	if !c.json {
+		c.printStatus(stats)
+	}
+	return nil
+}
+
+func (c *ctlClient) printStatus(stats []PingStats) {
+	tw := c.table()
+	fmt.Fprintln(tw, "HOST\tTYPE\tSTATUS\tLATENCY\tLOSS\tLAST SEEN\tTAGS")
+	for _, s := range stats {
+		latency, seen := "-", "never"
+		if s.CurrentLatency > 0 {
+			latency = fmt.Sprintf("%.1f ms", s.CurrentLatency)
+		}
+		if !s.LastSeen.IsZero() {
+			seen = ago(time.Since(s.LastSeen))
+		}
+		status := s.Status
+		if s.Paused {
+			status = "paused, " + status
+		} else if s.OffSchedule {
+			status = "off schedule, " + status
+		}
+		if s.OutageDuration > 0 {
+			status += " for " + (time.Duration(s.OutageDuration) * time.Second).String()
+		}
+		if s.FailureReason != "" {
+			status += " (" + s.FailureReason + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\n", cmp.Or(s.DisplayName, s.Host), s.Type, status, latency, s.PacketLoss, seen, strings.Join(s.Tags, ","))
+	}
+	tw.Flush()
+}
+
+// watch redraws the status table every second from the results streamed
+// by the server, like watch(1) but without polling. States only narrows
+// down what is shown, so hosts come and go as their state changes.
+func (c *ctlClient) watch(q url.Values, states []string) error {
+	var (
+		mu    sync.Mutex
+		hosts = map[string]*PingStats{}
+		order []string
+		err   error // of the stream, shown until it is back
+	)
+	// load starts over from the full list, catching hosts that were
+	// removed while the stream was down.
+	load := func() error {
+		var stats []PingStats
+		if err := c.call(http.MethodGet, "/api/stats?"+q.Encode(), nil, &stats, false); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		clear(hosts)
+		order = order[:0]
+		for _, s := range stats {
+			hosts[s.Host] = &s
+			order = append(order, s.Host)
+		}
+		return nil
+	}
+	if err := load(); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			streamErr := c.stream("/api/results/stream?"+q.Encode(), func(e ProbeEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				err = nil
+				s, ok := hosts[e.Host]
+				if !ok {
+					s = &PingStats{Host: e.Host, Type: e.Type, Tags: e.Tags}
+					hosts[e.Host] = s
+					order = append(order, e.Host)
+				}
+				s.Status, s.CurrentLatency, s.PacketLoss = e.Status, e.Latency, e.Loss
+				s.LastError, s.FailureReason = e.Error, e.Reason
+				switch {
+				case e.Status != "down":
+					s.DownSince = time.Time{}
+				case s.DownSince.IsZero():
+					s.DownSince = e.Time
+				}
+				if e.Error == "" {
+					s.LastSeen = e.Time
+				}
+			})
+			mu.Lock()
+			err = streamErr
+			mu.Unlock()
+			time.Sleep(5 * time.Second)
+			if loadErr := load(); loadErr != nil {
+				mu.Lock()
+				err = loadErr
+				mu.Unlock()
+			}
+		}
+	}()
+
+	for {
+		mu.Lock()
+		var stats []PingStats
+		for _, host := range order {
+			if s := hosts[host]; len(states) == 0 || slices.Contains(states, s.Status) {
+				s.OutageDuration = 0
+				if !s.DownSince.IsZero() {
+					s.OutageDuration = time.Since(s.DownSince).Round(time.Second).Seconds()
+				}
+				stats = append(stats, *s)
+			}
+		}
+		streamErr := err
+		mu.Unlock()
+
+		// Clear the screen and draw from the top.
+		fmt.Fprint(c.out, "\033[H\033[2J")
+		fmt.Fprintf(c.out, "%s  %s\n", c.server, time.Now().Format(time.DateTime))
+		if streamErr != nil {
+			fmt.Fprintf(c.out, "Reconnecting: %v\n", streamErr)
+		}
+		fmt.Fprintln(c.out)
+		if c.json {
+			c.printJSON(stats)
+		} else {
+			c.printStatus(stats)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// stream calls f with every result of an NDJSON stream until it ends.
+func (c *ctlClient) stream(path string, f func(ProbeEvent)) error {
+	req, err := http.NewRequest(http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var e ProbeEvent
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return errors.New("stream ended")
+			}
+			return err
+		}
+		f(e)
+	}
+}
+
+// backup downloads a backup to a file or standard output.
+func (c *ctlClient) backup(args []string) error {
+	flags := c.flagSet("backup")
+	history := flags.Bool("history", false, "Include the probe history")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return errors.New("usage: netmonitor ctl backup [-history] [FILE]")
+	}
+	path := "/api/backup"
+	if *history {
+		path += "?history=true"
+	}
+	req, err := http.NewRequest(http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	out := c.out
+	if flags.NArg() == 1 {
+		f, err := os.Create(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return err
+	}
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// ago formats how long ago something was, to the second.
+func ago(d time.Duration) string {
+	return d.Round(time.Second).String() + " ago"
+}
+
+func (c *ctlClient) addHost(args []string) error {
+	flags := c.flagSet("add-host")
+	tags := flags.String("tags", "", "Tags of the host, comma-separated")
+	name := flags.String("name", "", "Name to show instead of the target")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: netmonitor ctl add-host [-tags prod,db] [-name NAME] TARGET")
+	}
+	h := AddedHost{Target: flags.Arg(0), Tags: splitList(*tags)}
+	h.DisplayName = *name
+	var stats []PingStats
+	if err := c.call(http.MethodPost, "/api/hosts", h, &stats, true); err != nil {
+		return err
+	}
+	if !c.json {
+		c.printStatus(stats)
+	}
+	return nil
+}
+
+func (c *ctlClient) removeHost(args []string) error {
+	flags := c.flagSet("remove-host")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: netmonitor ctl remove-host TARGET")
+	}
+	target := flags.Arg(0)
+	if err := c.call(http.MethodDelete, "/api/hosts?host="+url.QueryEscape(target), nil, nil, false); err != nil {
+		return err
+	}
+	if !c.json {
+		fmt.Fprintf(c.out, "%s is no longer monitored\n", target)
+	}
+	return nil
+}
+
+// pause pauses or resumes a host, as command says.
+func (c *ctlClient) pause(command string, args []string) error {
+	flags := c.flagSet(command)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: netmonitor ctl %s HOST", command)
+	}
+	var s PingStats
+	if err := c.call(http.MethodPost, "/api/hosts/"+url.PathEscape(flags.Arg(0))+"/"+command, nil, &s, true); err != nil {
+		return err
+	}
+	if !c.json {
+		c.printStatus([]PingStats{s})
+	}
+	return nil
+}
+
+func (c *ctlClient) reset(args []string) error {
+	flags := c.flagSet("reset")
+	status := flags.String("status", "", "Only hosts in these states, comma-separated")
+	tags := flags.String("tags", "", "Only hosts with any of these tags, comma-separated")
+	flags.Parse(args)
+	var stats []PingStats
+	switch flags.NArg() {
+	case 0:
+		q := url.Values{}
+		if *status != "" {
+			q.Set("status", *status)
+		}
+		if *tags != "" {
+			q.Set("tag", *tags)
+		}
+		if err := c.call(http.MethodPost, "/api/hosts/reset?"+q.Encode(), nil, &stats, true); err != nil {
+			return err
+		}
+	case 1:
+		if *status != "" || *tags != "" {
+			return errors.New("-status and -tags select hosts to reset instead of naming one")
+		}
+		var s PingStats
+		if err := c.call(http.MethodPost, "/api/hosts/"+url.PathEscape(flags.Arg(0))+"/reset", nil, &s, true); err != nil {
+			return err
+		}
+		stats = append(stats, s)
+	default:
+		return errors.New("usage: netmonitor ctl reset [-status down] [-tags prod] [HOST]")
+	}
+	if !c.json {
+		if len(stats) == 1 {
+			fmt.Fprintf(c.out, "Reset the statistics of %s\n", stats[0].Host)
+		} else {
+			fmt.Fprintf(c.out, "Reset the statistics of %d hosts\n", len(stats))
+		}
+	}
+	return nil
+}
+
+func (c *ctlClient) silence(args []string) error {
+	flags := c.flagSet("silence")
+	note := flags.String("note", "", "Note to add, such as why or who is on it")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: netmonitor ctl silence [-note TEXT] HOST")
+	}
+	host := flags.Arg(0)
+	var open []Incident
+	if err := c.call(http.MethodGet, "/api/incidents?open=true", nil, &open, false); err != nil {
+		return err
+	}
+	var acked []Incident
+	for _, inc := range open {
+		if inc.Host != host || inc.Acknowledged {
+			continue
+		}
+		var updated Incident
+		body := incidentRequest{By: "netmonitor ctl", Note: *note}
+		if err := c.call(http.MethodPost, fmt.Sprintf("/api/incidents/%d/ack", inc.ID), body, &updated, false); err != nil {
+			return err
+		}
+		acked = append(acked, updated)
+	}
+	if c.json {
+		return c.printJSON(acked)
+	}
+	if len(acked) == 0 {
+		return fmt.Errorf("%s has no unacknowledged open incidents", host)
+	}
+	c.printEvents(acked)
+	return nil
+}
+
+func (c *ctlClient) events(args []string) error {
+	flags := c.flagSet("events")
+	open := flags.Bool("open", false, "Only incidents still open")
+	n := flags.Int("n", 20, "Number of incidents to show, 0 for all")
+	flags.Parse(args)
+	var incidents []Incident
+	if err := c.call(http.MethodGet, fmt.Sprintf("/api/incidents?open=%t", *open), nil, &incidents, false); err != nil {
+		return err
+	}
+	if *n > 0 && len(incidents) > *n {
+		incidents = incidents[:*n]
+	}
+	if c.json {
+		return c.printJSON(incidents)
+	}
+	c.printEvents(incidents)
+	return nil
+}
+
+func (c *ctlClient) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *ctlClient) printEvents(incidents []Incident) {
+	tw := c.table()
+	fmt.Fprintln(tw, "ID\tHOST\tSTATUS\tSEVERITY\tOPENED\tRESOLVED\tACKNOWLEDGED")
+	for _, inc := range incidents {
+		resolved, acked := "open", "-"
+		if !inc.Resolved.IsZero() {
+			resolved = inc.Resolved.Local().Format(time.DateTime)
+		}
+		if inc.Acknowledged {
+			acked = cmp.Or(inc.AcknowledgedBy, "yes")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", inc.ID, inc.Host, inc.Status, inc.Severity, inc.Opened.Local().Format(time.DateTime), resolved, acked)
+	}
+	tw.Flush()
+}
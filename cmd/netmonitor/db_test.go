@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestRedisCmd(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+		err   string
+	}{
+		{"+PONG\r\n", "PONG", ""},
+		{"-WRONGPASS invalid username-password pair\r\n", "", "redis AUTH: WRONGPASS invalid"},
+		{":1\r\n", "", `unexpected reply ":1"`},
+		{"+PON", "", "EOF"},
+	}
+	for _, tt := range tests {
+		var sent bytes.Buffer
+		got, err := redisCmd(&sent, bufio.NewReader(strings.NewReader(tt.reply)), "AUTH", "monitor", "secret")
+		if want := "*3\r\n$4\r\nAUTH\r\n$7\r\nmonitor\r\n$6\r\nsecret\r\n"; sent.String() != want {
+			t.Errorf("%q: sent %q, want %q", tt.reply, sent.String(), want)
+		}
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.reply, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%q: %q, %v; want %q", tt.reply, got, err, tt.want)
+		}
+	}
+}
+
+// The exchange from RFC 7677 section 3. Postgres leaves the user name out
+// of client-first, so the example's is put back by hand.
+func TestScramRFC7677(t *testing.T) {
+	s := &scramClient{password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO", firstBare: "n=user,r=rOprNGfwEbeRWgbNEkqO"}
+	final, err := s.clientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("client-final %q, want %q", final, want)
+	}
+	if !s.verifyServer("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("server signature from the RFC refused")
+	}
+	if s.verifyServer("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("forged server signature accepted")
+	}
+
+	for _, serverFirst := range []string{
+		"r=someoneelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+		"r=rOprNGfwEbeRWgbNEkqO%hvY,s=W22ZaJ0SNY7soEsUEjb6gQ==",
+		"r=rOprNGfwEbeRWgbNEkqO%hvY,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=-1",
+		"r=rOprNGfwEbeRWgbNEkqO%hvY,s=not base64,i=4096",
+	} {
+		if _, err := s.clientFinal(serverFirst); err == nil {
+			t.Errorf("%q accepted", serverFirst)
+		}
+	}
+}
+
+// pgAuthServer asks for authentication with the given method and checks
+// the answer against user and password the way a server would.
+func pgAuthServer(conn net.Conn, method uint32, user, password string) {
+	r := bufio.NewReader(conn)
+	auth := func(code uint32, data []byte) {
+		pgWrite(conn, 'R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+	}
+	answer := func() string {
+		if typ, payload, err := pgRead(r); err == nil && typ == 'p' {
+			return string(payload)
+		}
+		return ""
+	}
+
+	ok := true
+	switch method {
+	case 3:
+		auth(3, nil)
+		ok = answer() == password+"\x00"
+	case 5:
+		salt := []byte{1, 2, 3, 4}
+		auth(5, salt)
+		inner := md5.Sum([]byte(password + user))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+		ok = answer() == "md5"+hex.EncodeToString(outer[:])+"\x00"
+	case 10:
+		auth(10, []byte("SCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00"))
+		first, found := strings.CutPrefix(answer(), "SCRAM-SHA-256\x00")
+		if !found || len(first) < 4 {
+			return
+		}
+		firstBare := strings.TrimPrefix(first[4:], "n,,")
+		_, clientNonce, _ := strings.Cut(firstBare, "r=")
+		salt := []byte("pepper")
+		serverFirst := "r=" + clientNonce + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+		auth(11, []byte(serverFirst))
+
+		withoutProof, proof, _ := strings.Cut(answer(), ",p=")
+		authMessage := firstBare + "," + serverFirst + "," + withoutProof
+		salted, _ := pbkdf2.Key(sha256.New, password, salt, 4096, sha256.Size)
+		clientKey := hmacSHA256(salted, "Client Key")
+		storedKey := sha256.Sum256(clientKey)
+		want := hmacSHA256(storedKey[:], authMessage)
+		for i := range want {
+			want[i] ^= clientKey[i]
+		}
+		if ok = proof == base64.StdEncoding.EncodeToString(want); !ok {
+			break
+		}
+		auth(12, []byte("v="+base64.StdEncoding.EncodeToString(hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage))))
+		auth(0, nil)
+		return
+	case 0:
+	default:
+		auth(method, nil)
+		return
+	}
+	if !ok {
+		pgWrite(conn, 'E', []byte("SFATAL\x00C28P01\x00Mpassword authentication failed for user \""+user+"\"\x00\x00"))
+		return
+	}
+	auth(0, nil)
+}
+
+func TestPgAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   uint32
+		password string
+		err      string
+	}{
+		{"trust", 0, "", ""},
+		{"cleartext", 3, "secret", ""},
+		{"cleartext wrong", 3, "guess", "password authentication failed"},
+		{"md5", 5, "secret", ""},
+		{"md5 wrong", 5, "guess", "password authentication failed"},
+		{"scram", 10, "secret", ""},
+		{"scram wrong", 10, "guess", "password authentication failed"},
+		{"kerberos", 2, "secret", "unsupported authentication method 2"},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			pgAuthServer(server, tt.method, "monitor", "secret")
+		}()
+		err := pgAuthenticate(client, bufio.NewReader(client), "monitor", tt.password)
+		client.Close()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// Servers that get the protocol wrong are refused before any password is
+// sent.
+func TestPgAuthenticateMalformed(t *testing.T) {
+	auth := func(code uint32, data string) []byte {
+		var b bytes.Buffer
+		pgWrite(&b, 'R', append(binary.BigEndian.AppendUint32(nil, code), data...))
+		return b.Bytes()
+	}
+	tests := []struct {
+		name  string
+		reply []byte
+		err   string
+	}{
+		{"md5 without salt", auth(5, "\x01\x02"), "without a salt"},
+		{"only channel binding", auth(10, "SCRAM-SHA-256-PLUS\x00\x00"), "no supported SASL mechanism"},
+		{"continue first", auth(11, "r=x,s=eA==,i=1"), "unexpected SASL continue"},
+		{"final first", auth(12, "v=eA=="), "signature mismatch"},
+		{"ready for query", []byte{'Z', 0, 0, 0, 5, 'I'}, "unexpected message"},
+		{"oversized", []byte{'R', 0x7f, 0, 0, 0}, "malformed"},
+	}
+	for _, tt := range tests {
+		var sent bytes.Buffer
+		err := pgAuthenticate(&sent, bufio.NewReader(bytes.NewReader(tt.reply)), "monitor", "secret")
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+		if sent.Len() != 0 {
+			t.Errorf("%s: sent %q", tt.name, sent.Bytes())
+		}
+	}
+}
+
+// mysqlGreeting builds a protocol 10 handshake offering a 20 byte nonce.
+func mysqlGreeting(nonce []byte, plugin string) []byte {
+	p := append([]byte{10}, "8.0.36\x00"...)
+	p = append(p, 1, 0, 0, 0) // connection id
+	p = append(p, nonce[:8]...)
+	p = append(p, 0, 0xff, 0xf7, 45, 2, 0, 0xff, 0x81, byte(len(nonce)+1))
+	p = append(p, make([]byte, 10)...)
+	p = append(append(p, nonce[8:]...), 0)
+	return append(append(p, plugin...), 0)
+}
+
+// mysqlCheckScramble verifies an auth response the way the server does,
+// knowing only the hash it stores for password.
+func mysqlCheckScramble(plugin, password string, nonce, scramble []byte) bool {
+	if plugin == "caching_sha2_password" {
+		h1 := sha256.Sum256([]byte(password))
+		stored := sha256.Sum256(h1[:])
+		h3 := sha256.Sum256(append(stored[:], nonce...))
+		if len(scramble) != len(h3) {
+			return false
+		}
+		for i := range h3 {
+			h3[i] ^= scramble[i]
+		}
+		return sha256.Sum256(h3[:]) == stored
+	}
+	h1 := sha1.Sum([]byte(password))
+	stored := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte{}, nonce...), stored[:]...))
+	if len(scramble) != len(h3) {
+		return false
+	}
+	for i := range h3 {
+		h3[i] ^= scramble[i]
+	}
+	return sha1.Sum(h3[:]) == stored
+}
+
+func TestParseMySQLGreeting(t *testing.T) {
+	nonce := []byte("abcdefghijklmnopqrst")
+	tests := []struct {
+		name     string
+		greeting []byte
+		plugin   string
+		err      string
+	}{
+		{"mysql 8", mysqlGreeting(nonce, "caching_sha2_password"), "caching_sha2_password", ""},
+		{"mariadb", mysqlGreeting(nonce, "mysql_native_password"), "mysql_native_password", ""},
+		{"no plugin name", mysqlGreeting(nonce, ""), "mysql_native_password", ""},
+		{"protocol 9", append([]byte{9}, mysqlGreeting(nonce, "")[1:]...), "", "unsupported protocol"},
+		{"truncated", mysqlGreeting(nonce, "")[:30], "", "short handshake"},
+		{"no version end", []byte{10, '8', '.', '0'}, "", "short handshake"},
+	}
+	for _, tt := range tests {
+		got, plugin, err := parseMySQLGreeting(tt.greeting)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, nonce) || plugin != tt.plugin {
+			t.Errorf("%s: nonce %q, plugin %q, %v", tt.name, got, plugin, err)
+		}
+	}
+}
+
+func TestMySQLScramble(t *testing.T) {
+	nonce := []byte("abcdefghijklmnopqrst")
+	for _, plugin := range []string{"mysql_native_password", "caching_sha2_password"} {
+		if got := mysqlScramble(plugin, "", nonce); got != nil {
+			t.Errorf("%s: %x for an empty password", plugin, got)
+		}
+		scramble := mysqlScramble(plugin, "secret", nonce)
+		if !mysqlCheckScramble(plugin, "secret", nonce, scramble) {
+			t.Errorf("%s: server refused %x", plugin, scramble)
+		}
+		if mysqlCheckScramble(plugin, "secret", []byte("another nonce"), scramble) {
+			t.Errorf("%s: %x replays with another nonce", plugin, scramble)
+		}
+	}
+}
+
+// mysqlServer accepts a login for monitor/secret the way auth says:
+// fast for a cached caching_sha2 login, switch to move the client to
+// mysql_native_password, or full to have it send the password under the
+// server's RSA key. It then answers one query.
+func mysqlServer(auth string, key *rsa.PrivateKey) func(net.Conn) {
+	return func(conn net.Conn) {
+		c := &mysqlConn{conn: conn, r: bufio.NewReader(conn)}
+		read := func() []byte {
+			var header [4]byte
+			if _, err := io.ReadFull(c.r, header[:]); err != nil {
+				return nil
+			}
+			p := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+			io.ReadFull(c.r, p)
+			c.seq = header[3] + 1
+			return p
+		}
+
+		nonce := []byte("abcdefghijklmnopqrst")
+		c.write(mysqlGreeting(nonce, "caching_sha2_password"))
+		login := read()
+		if len(login) < 33 {
+			return
+		}
+		user, rest, _ := bytes.Cut(login[32:], []byte{0})
+		scramble := rest[1:][:min(int(rest[0]), len(rest)-1)]
+
+		var ok bool
+		switch auth {
+		case "fast":
+			ok = mysqlCheckScramble("caching_sha2_password", "secret", nonce, scramble)
+			if ok {
+				c.write([]byte{1, 3})
+			}
+		case "switch":
+			nonce = []byte("ABCDEFGHIJKLMNOPQRST")
+			c.write(append(append([]byte{0xfe}, "mysql_native_password\x00"...), append(nonce, 0)...))
+			ok = mysqlCheckScramble("mysql_native_password", "secret", nonce, read())
+		case "full":
+			c.write([]byte{1, 4})
+			if !bytes.Equal(read(), []byte{2}) {
+				return
+			}
+			der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			c.write(append([]byte{1}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...))
+			plain, err := rsa.DecryptOAEP(sha1.New(), nil, key, read(), nil)
+			for i := range plain {
+				plain[i] ^= nonce[i%len(nonce)]
+			}
+			ok = err == nil && string(plain) == "secret\x00"
+		}
+		if !ok || string(user) != "monitor" {
+			c.write(append([]byte{0xff, 0x15, 0x04}, "#28000Access denied for user 'monitor'"...))
+			return
+		}
+		c.write([]byte{0, 0, 0, 2, 0, 0, 0})
+
+		if query := read(); string(query) != "\x03SELECT 1" {
+			return
+		}
+		eof := []byte{0xfe, 0, 0, 2, 0}
+		c.write([]byte{1})
+		c.write([]byte("\x03def\x00\x00\x00\x011\x00\x0c\x3f\x00\x01\x00\x00\x00\x08\x81\x00\x00\x00\x00"))
+		c.write(eof)
+		c.write([]byte{1, '1'})
+		c.write(eof)
+		read() // COM_QUIT
+	}
+}
+
+func TestProbeMySQL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		auth     string
+		password string
+		err      string
+	}{
+		{"fast auth", "fast", "secret", ""},
+		{"auth switch", "switch", "secret", ""},
+		{"full auth", "full", "secret", ""},
+		{"wrong password", "fast", "guess", "mysql: Access denied for user 'monitor'"},
+		{"wrong password after switch", "switch", "guess", "Access denied"},
+		{"wrong password in full", "full", "guess", "Access denied"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("mysql://monitor:" + tt.password + "@" + serveTCP(t, mysqlServer(tt.auth, key)) + "/app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeMySQL(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if _, ok := result.Metrics["query_ms"]; !ok {
+			t.Errorf("%s: no query_ms in %v", tt.name, result.Metrics)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// enableDebug serves the Go profiler under /debug/pprof/ for diagnosing
+// CPU and memory use in production. With a token, requests must present
+// it as a bearer token or as the basic auth password, which lets
+// go tool pprof http://:TOKEN@host:8080/debug/pprof/heap work. Without
+// one, only loopback clients are served.
+func (m *Monitor) enableDebug(token string) {
+	guard := func(h http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !debugAllowed(r, token) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="netmonitor debug"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		})
+	}
+	m.mux.Handle("/debug/pprof/", guard(pprof.Index))
+	m.mux.Handle("/debug/pprof/cmdline", guard(pprof.Cmdline))
+	m.mux.Handle("/debug/pprof/profile", guard(pprof.Profile))
+	m.mux.Handle("/debug/pprof/symbol", guard(pprof.Symbol))
+	m.mux.Handle("/debug/pprof/trace", guard(pprof.Trace))
+}
+
+func debugAllowed(r *http.Request, token string) bool {
+	if token == "" {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, given, _ = r.BasicAuth()
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+)
+
+// DHCP targets name the interface whose DHCP servers are checked:
+//
+//	dhcp://eth0
+//	dhcp://eth0?server=192.168.1.1
+//
+// The probe broadcasts a DHCPDISCOVER on the interface and waits for an
+// offer, without ever requesting the address, so no lease is taken. The
+// latency is the time to the offer and the lease time offered is a
+// metric. With server set, only an offer from that server counts, and
+// offers from others, such as a rogue server plugged into the segment,
+// mark the host degraded. The client hardware address is the interface's
+// unless mac is set. Listening on port 68 needs root or
+// CAP_NET_BIND_SERVICE, and is only supported on Linux.
+func init() {
+	probers["dhcp"] = probeDHCP
+}
+
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+
+	dhcpOptionSubnet     = 1
+	dhcpOptionRouter     = 3
+	dhcpOptionDNS        = 6
+	dhcpOptionLeaseTime  = 51
+	dhcpOptionType       = 53
+	dhcpOptionServerID   = 54
+	dhcpOptionParameters = 55
+	dhcpOptionEnd        = 255
+)
+
+// dhcpMagic is the cookie that starts the options (RFC 2131, 3).
+var dhcpMagic = []byte{99, 130, 83, 99}
+
+// dhcpOfferInfo is what matters of an offer.
+type dhcpOfferInfo struct {
+	server netip.Addr
+	addr   netip.Addr
+	lease  time.Duration
+}
+
+func probeDHCP(t *target) (probeResult, error) {
+	iface, err := net.InterfaceByName(t.host)
+	if err != nil {
+		return probeResult{}, err
+	}
+	mac := iface.HardwareAddr
+	if raw := t.param("mac", ""); raw != "" {
+		if mac, err = net.ParseMAC(raw); err != nil {
+			return probeResult{}, fmt.Errorf("invalid mac %q", raw)
+		}
+	}
+	if len(mac) != 6 {
+		return probeResult{}, fmt.Errorf("%s has no Ethernet address, set mac", t.host)
+	}
+	var server netip.Addr
+	if raw := t.param("server", ""); raw != "" {
+		if server, err = netip.ParseAddr(raw); err != nil || !server.Is4() {
+			return probeResult{}, fmt.Errorf("invalid server %q", raw)
+		}
+	}
+
+	conn, err := listenDHCP(t.host)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(t.timeout())
+	conn.SetDeadline(deadline)
+
+	xid := make([]byte, 4)
+	rand.Read(xid)
+	start := time.Now()
+	if _, err := conn.WriteTo(dhcpDiscoverPacket(xid, mac), &net.UDPAddr{IP: net.IPv4bcast, Port: 67}); err != nil {
+		return probeResult{}, err
+	}
+	var others []string
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if len(others) > 0 {
+				return probeResult{}, fmt.Errorf("no offer from %s, only from %v", server, others)
+			}
+			return probeResult{}, errors.New("no DHCP offer")
+		}
+		if err != nil {
+			return probeResult{}, err
+		}
+		offer, ok := parseDHCPOffer(buf[:n], xid)
+		if !ok {
+			continue
+		}
+		if server.IsValid() && offer.server != server {
+			if s := offer.server.String(); !slices.Contains(others, s) {
+				others = append(others, s)
+			}
+			continue
+		}
+		result := probeResult{
+			Latency: msSince(start),
+			Metrics: map[string]float64{"lease_s": offer.lease.Seconds()},
+		}
+		if len(others) > 0 {
+			result.Warning = fmt.Sprintf("offers from other DHCP servers: %v", others)
+		}
+		return result, nil
+	}
+}
+
+// dhcpDiscoverPacket builds a DHCPDISCOVER asking for the offer to be
+// broadcast, since the client has no address yet.
+func dhcpDiscoverPacket(xid []byte, mac net.HardwareAddr) []byte {
+	p := make([]byte, 236, 300)
+	p[0], p[1], p[2] = 1, 1, 6 // BOOTREQUEST, Ethernet, address length
+	copy(p[4:8], xid)
+	binary.BigEndian.PutUint16(p[10:], 0x8000) // broadcast flag
+	copy(p[28:], mac)
+	p = append(p, dhcpMagic...)
+	p = append(p, dhcpOptionType, 1, dhcpDiscover)
+	p = append(p, dhcpOptionParameters, 4, dhcpOptionSubnet, dhcpOptionRouter, dhcpOptionDNS, dhcpOptionLeaseTime)
+	p = append(p, dhcpOptionEnd)
+	// BOOTP relays drop packets shorter than 300 bytes.
+	return append(p, make([]byte, 300-len(p))...)
+}
+
+// parseDHCPOffer returns the offer in a reply to the discover with xid.
+func parseDHCPOffer(p, xid []byte) (dhcpOfferInfo, bool) {
+	if len(p) < 240 || p[0] != 2 || string(p[4:8]) != string(xid) || string(p[236:240]) != string(dhcpMagic) {
+		return dhcpOfferInfo{}, false
+	}
+	offer := dhcpOfferInfo{addr: netip.AddrFrom4([4]byte(p[16:20]))}
+	msgType := 0
+	for opts := p[240:]; len(opts) > 0; {
+		code := opts[0]
+		if code == dhcpOptionEnd {
+			break
+		}
+		if code == 0 { // pad
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return dhcpOfferInfo{}, false
+		}
+		value := opts[2 : 2+opts[1]]
+		switch {
+		case code == dhcpOptionType && len(value) == 1:
+			msgType = int(value[0])
+		case code == dhcpOptionServerID && len(value) == 4:
+			offer.server = netip.AddrFrom4([4]byte(value))
+		case code == dhcpOptionLeaseTime && len(value) == 4:
+			offer.lease = time.Duration(binary.BigEndian.Uint32(value)) * time.Second
+		}
+		opts = opts[2+len(value):]
+	}
+	if msgType != dhcpOffer {
+		return dhcpOfferInfo{}, false
+	}
+	if !offer.server.IsValid() {
+		offer.server = netip.AddrFrom4([4]byte(p[20:24])) // siaddr
+	}
+	return offer, true
+}
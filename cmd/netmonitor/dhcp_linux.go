@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// listenDHCP opens the DHCP client port on iface, able to broadcast.
+// Other DHCP clients on the box may hold the port too.
+func listenDHCP(iface string) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			if err = bindToDevice(fd, iface); err != nil {
+				return
+			}
+			if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+				return
+			}
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}}
+	return lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:68")
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func listenDHCP(iface string) (net.PacketConn, error) {
+	return nil, errors.New("DHCP probes are only supported on Linux")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDHCPDiscoverPacket(t *testing.T) {
+	xid := []byte{1, 2, 3, 4}
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	p := dhcpDiscoverPacket(xid, mac)
+	if len(p) != 300 || p[0] != 1 || !bytes.Equal(p[4:8], xid) || !bytes.Equal(p[28:34], mac) || !bytes.Equal(p[236:240], dhcpMagic) {
+		t.Fatalf("discover % x", p)
+	}
+	if binary.BigEndian.Uint16(p[10:]) != 0x8000 {
+		t.Error("broadcast flag not set")
+	}
+	if !bytes.Equal(p[240:243], []byte{dhcpOptionType, 1, dhcpDiscover}) {
+		t.Errorf("options % x", p[240:250])
+	}
+}
+
+// dhcpReply builds a reply to the discover with xid, with the offered
+// address, siaddr and options.
+func dhcpReply(xid []byte, siaddr string, options ...byte) []byte {
+	p := make([]byte, 236)
+	p[0] = 2
+	copy(p[4:8], xid)
+	copy(p[16:20], net.ParseIP("192.168.1.50").To4())
+	copy(p[20:24], net.ParseIP(siaddr).To4())
+	p = append(p, dhcpMagic...)
+	return append(p, options...)
+}
+
+func TestParseDHCPOffer(t *testing.T) {
+	xid := []byte{1, 2, 3, 4}
+	offer := []byte{dhcpOptionType, 1, dhcpOffer}
+	lease := []byte{dhcpOptionLeaseTime, 4, 0, 0, 0x0e, 0x10}
+	serverID := []byte{dhcpOptionServerID, 4, 192, 168, 1, 1}
+	join := func(opts ...[]byte) []byte {
+		return append(bytes.Join(opts, nil), dhcpOptionEnd)
+	}
+	tests := []struct {
+		name   string
+		p      []byte
+		ok     bool
+		server string
+		lease  time.Duration
+	}{
+		{"offer", dhcpReply(xid, "0.0.0.0", join(offer, serverID, lease)...), true, "192.168.1.1", time.Hour},
+		{"padded", dhcpReply(xid, "0.0.0.0", join([]byte{0, 0}, offer, serverID)...), true, "192.168.1.1", 0},
+		{"server from siaddr", dhcpReply(xid, "192.168.1.2", join(offer, lease)...), true, "192.168.1.2", time.Hour},
+		{"other transaction", dhcpReply([]byte{4, 3, 2, 1}, "0.0.0.0", join(offer, serverID)...), false, "", 0},
+		{"ack", dhcpReply(xid, "0.0.0.0", join([]byte{dhcpOptionType, 1, 5}, serverID)...), false, "", 0},
+		{"no type", dhcpReply(xid, "0.0.0.0", join(serverID)...), false, "", 0},
+		{"truncated option", dhcpReply(xid, "0.0.0.0", append(offer, dhcpOptionServerID, 4, 192)...), false, "", 0},
+		{"request", append([]byte{1}, dhcpReply(xid, "0.0.0.0", join(offer)...)[1:]...), false, "", 0},
+		{"no magic", dhcpReply(xid, "0.0.0.0")[:236], false, "", 0},
+	}
+	for _, tt := range tests {
+		got, ok := parseDHCPOffer(tt.p, xid)
+		if ok != tt.ok {
+			t.Errorf("%s: ok %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.server != netip.MustParseAddr(tt.server) || got.addr != netip.MustParseAddr("192.168.1.50") || got.lease != tt.lease {
+			t.Errorf("%s: %+v", tt.name, got)
+		}
+	}
+}
+
+func TestProbeDHCPParams(t *testing.T) {
+	// Loopback has no Ethernet address, so nothing is ever sent.
+	if _, err := net.InterfaceByName("lo"); err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		target string
+		err    string
+	}{
+		{"dhcp://lo", "lo has no Ethernet address, set mac"},
+		{"dhcp://lo?mac=nope", `invalid mac "nope"`},
+		{"dhcp://lo?mac=02:00:00:00:00:01&server=::1", `invalid server "::1"`},
+		{"dhcp://nonexistent0", "no such network interface"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := probeDHCP(tgt); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.target, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// discoverer keeps a changing set of hosts, such as the pods of a
+// Kubernetes deployment, calling update with all of them whenever the set
+// changes. run returns when discovery broke off; it is then started again.
+type discoverer interface {
+	run(update func([]HostConfig)) error
+}
+
+// discovererFactories maps the scheme of a discovery URL to the constructor
+// of its provider. Providers register themselves from their own files.
+var discovererFactories = map[string]func(u *url.URL) (discoverer, error){}
+
+// discoveryRetry is how long to wait before restarting a failed provider.
+const discoveryRetry = 10 * time.Second
+
+func parseDiscoverer(raw string) (discoverer, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid discovery %q", raw)
+	}
+	factory, ok := discovererFactories[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("invalid discovery %q: unknown type %q", u.Redacted(), u.Scheme)
+	}
+	d, err := factory(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery %q: %v", u.Redacted(), err)
+	}
+	return d, nil
+}
+
+// hostTemplate turns discovered addresses into hosts entries. Every
+// provider takes the same URL parameters: probe selects the probe type
+// (icmp unless set), port and path complete probe URLs such as
+// dns://10.0.0.53/example.com, and tags are added to every host.
+type hostTemplate struct {
+	probe string
+	port  string
+	path  string
+	tags  []string
+}
+
+func newHostTemplate(q url.Values) hostTemplate {
+	return hostTemplate{
+		probe: strings.ToLower(q.Get("probe")),
+		port:  q.Get("port"),
+		path:  q.Get("path"),
+		tags:  splitList(q.Get("tags")),
+	}
+}
+
+// host returns the entry for a discovered address. A port configured on
+// the provider wins over the discovered one.
+func (ht hostTemplate) host(address, port, name string, tags []string) HostConfig {
+	h := HostConfig{Target: address, Tags: append(append([]string{}, ht.tags...), tags...)}
+	h.DisplayName = name
+	if ht.probe != "" && ht.probe != "icmp" {
+		hostPort := address
+		if p := cmp.Or(ht.port, port); p != "" {
+			hostPort = net.JoinHostPort(address, p)
+		} else if strings.Contains(address, ":") {
+			hostPort = "[" + address + "]"
+		}
+		h.Target = ht.probe + "://" + hostPort + ht.path
+	}
+	return h
+}
+
+// discover runs a provider for good, keeping the hosts it finds monitored.
+// While it is failing, the hosts it found last stay.
+func (m *Monitor) discover(name string, d discoverer, cfg *Config) {
+	for {
+		err := d.run(func(hosts []HostConfig) {
+			var targets []*target
+			for _, h := range hosts {
+				ts, err := cfg.hostTargets(h)
+				if err != nil {
+					log.Printf("Discovery %s: %v", name, err)
+					continue
+				}
+				targets = append(targets, ts...)
+			}
+			m.syncHosts(name, targets)
+		})
+		log.Printf("Discovery %s failed: %v; retrying in %v", name, err, discoveryRetry)
+		time.Sleep(discoveryRetry)
+	}
+}
+
+// syncHosts makes targets the hosts monitored for a provider: new ones
+// are scheduled and those gone are dropped. Hosts that are already
+// monitored, from the config file or another provider, are left alone.
+func (m *Monitor) syncHosts(provider string, targets []*target) {
+	m.hostsMu.Lock()
+	defer m.hostsMu.Unlock()
+	// The first sync after starting brings back what was there before, so
+	// its hosts are not announced to webhooks as new.
+	first := !m.synced[provider]
+	if m.synced == nil {
+		m.synced = map[string]bool{}
+	}
+	m.synced[provider] = true
+
+	want := map[string]bool{}
+	for _, t := range targets {
+		want[t.name] = true
+	}
+	old := m.hosts()
+	next := &hostSet{stats: make(map[string]*hostStats, len(old.stats))}
+	var removed []*target
+	for _, t := range old.targets {
+		if t.provider == provider && !want[t.name] {
+			removed = append(removed, t)
+			continue
+		}
+		next.targets = append(next.targets, t)
+		next.stats[t.name] = old.stats[t.name]
+	}
+
+	var added []*target
+	for _, t := range targets {
+		if _, ok := next.stats[t.name]; ok {
+			continue
+		}
+		t.provider = provider
+		m.adopt(t)
+		stats := newTargetStats(t)
+		if meta, ok := m.hostEdits.lookup(t.name); ok {
+			stats.update(func(s *PingStats) { s.HostMeta = meta })
+		}
+		if since, ok := m.paused.lookup(t.name); ok {
+			stats.update(func(s *PingStats) { s.Paused, s.PausedSince = true, since })
+		}
+		next.targets = append(next.targets, t)
+		next.stats[t.name] = stats
+		added = append(added, t)
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	m.hostSet.Store(next)
+	log.Printf("Discovery %s: %d hosts added, %d removed", provider, len(added), len(removed))
+	change := map[string][]string{}
+	for _, t := range added {
+		change["added"] = append(change["added"], t.name)
+		if !first {
+			event := "discovery_found"
+			if provider == apiHostsProvider {
+				event = "host_added"
+			}
+			m.emit(event, t.name, t.tags, WebhookHost{Type: t.kind, Provider: provider})
+		}
+	}
+	for _, t := range removed {
+		change["removed"] = append(change["removed"], t.name)
+		m.emit("host_removed", t.name, t.tags, WebhookHost{Type: t.kind, Provider: provider})
+	}
+	if provider != apiHostsProvider {
+		// The API records its own changes.
+		m.audit.add(AuditEvent{Actor: "discovery", Action: "hosts.discover", Target: provider, After: change})
+	}
+
+	// Like at startup, first probes are spread over one interval.
+	now := m.clock.Now()
+	for i, t := range added {
+		stats := next.stats[t.name]
+		if m.wheel != nil {
+			offset := m.interval * time.Duration(i) / time.Duration(len(added))
+			stats.lastRun.Store(now.UnixNano())
+			m.wheel.add(&hostState{t: t, stats: stats, due: now.Add(offset), statusSince: now})
+		}
+		if m.enricher != nil {
+			go m.enrich(m.enricher, t, stats)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS targets look like
+//
+//	dns://1.1.1.1/example.com?type=AAAA    plain UDP, port 53
+//	dot://1.1.1.1/example.com?sni=one.one.one.one    DNS-over-TLS, port 853
+//	doh://cloudflare-dns.com/dns-query?name=example.com    DNS-over-HTTPS
+//	dnscompare://example.com?resolvers=system,1.1.1.1,8.8.8.8    several resolvers
+//
+// For dns and dot the path is the name to query; DoH uses the path as the
+// endpoint and takes the name from the "name" parameter. dnscompare asks
+// every resolver for the host name in parallel and reports the target as
+// degraded when their answers differ, or differ from ?expect=ip,ip; "system"
+// stands for the first nameserver in /etc/resolv.conf.
+func init() {
+	probers["dns"] = probeDNS
+	probers["dot"] = probeDoT
+	probers["doh"] = probeDoH
+	probers["dnscompare"] = probeDNSCompare
+}
+
+func probeDNS(t *target) (probeResult, error) {
+	query, id, err := buildDNSQuery(t, dnsQueryName(t))
+	if err != nil {
+		return probeResult{}, err
+	}
+
+	conn, err := t.dial("udp", t.hostPort("53"))
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(t.timeout()))
+
+	start := time.Now()
+	if _, err := conn.Write(query); err != nil {
+		return probeResult{}, err
+	}
+	reply := make([]byte, 4096)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return probeResult{}, err
+	}
+	queryMs := msSince(start)
+
+	answers, err := checkDNSReply(reply[:n], id)
+	if err != nil {
+		return probeResult{}, err
+	}
+	return probeResult{
+		Latency: queryMs,
+		Metrics: map[string]float64{"query_ms": queryMs, "answers": float64(answers)},
+	}, nil
+}
+
+func probeDoT(t *target) (probeResult, error) {
+	query, id, err := buildDNSQuery(t, dnsQueryName(t))
+	if err != nil {
+		return probeResult{}, err
+	}
+
+	config := t.tlsConfig(t.param("sni", t.host))
+
+	start := time.Now()
+	raw, err := t.dial("tcp", t.hostPort("853"))
+	if err != nil {
+		return probeResult{}, err
+	}
+	conn := tls.Client(raw, config)
+	defer conn.Close()
+	conn.SetDeadline(start.Add(t.timeout()))
+	if err := conn.Handshake(); err != nil {
+		return probeResult{}, err
+	}
+	handshakeMs := msSince(start)
+	conn.SetDeadline(time.Now().Add(t.timeout()))
+
+	// DNS over a stream is prefixed with a two byte length (RFC 7858).
+	queryStart := time.Now()
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(frame, query...)); err != nil {
+		return probeResult{}, err
+	}
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return probeResult{}, err
+	}
+	reply := make([]byte, length)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return probeResult{}, err
+	}
+	queryMs := msSince(queryStart)
+
+	answers, err := checkDNSReply(reply, id)
+	if err != nil {
+		return probeResult{}, err
+	}
+	return probeResult{
+		Latency: msSince(start),
+		Metrics: map[string]float64{
+			"handshake_ms": handshakeMs,
+			"query_ms":     queryMs,
+			"answers":      float64(answers),
+		},
+	}, nil
+}
+
+func probeDoH(t *target) (probeResult, error) {
+	name := t.param("name", "")
+	if name == "" {
+		return probeResult{}, errors.New("doh target needs a name parameter")
+	}
+	// DoH clients should use ID 0 so responses stay cacheable (RFC 8484).
+	query, _, err := buildDNSQuery(t, name)
+	if err != nil {
+		return probeResult{}, err
+	}
+	query[0], query[1] = 0, 0
+
+	endpoint := url.URL{Scheme: "https", Host: t.url.Host, Path: t.url.Path}
+	if endpoint.Path == "" {
+		endpoint.Path = "/dns-query"
+	}
+	endpoint.RawQuery = "dns=" + base64.RawURLEncoding.EncodeToString(query)
+
+	// Measure the handshake of a fresh connection on every probe.
+	var handshakeStart, handshakeDone time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { handshakeDone = time.Now() },
+	}
+	client := &http.Client{
+		Timeout: t.timeout(),
+		Transport: t.proxied(&http.Transport{
+			DisableKeepAlives: true,
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   t.tlsConfig(t.host),
+		}),
+	}
+
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return probeResult{}, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return probeResult{}, fmt.Errorf("doh server returned %s", resp.Status)
+	}
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return probeResult{}, err
+	}
+	total := msSince(start)
+
+	answers, err := checkDNSReply(reply, 0)
+	if err != nil {
+		return probeResult{}, err
+	}
+	handshakeMs := handshakeDone.Sub(handshakeStart).Seconds() * 1000
+	return probeResult{
+		Latency: total,
+		Metrics: map[string]float64{
+			"handshake_ms": handshakeMs,
+			"query_ms":     total - handshakeMs,
+			"answers":      float64(answers),
+		},
+	}, nil
+}
+
+// dnsAnswer is what one resolver said in a comparison.
+type dnsAnswer struct {
+	resolver string
+	answers  []string
+	ms       float64
+	err      error
+}
+
+func probeDNSCompare(t *target) (probeResult, error) {
+	resolvers := splitList(t.param("resolvers", ""))
+	if len(resolvers) < 2 && t.param("expect", "") == "" {
+		return probeResult{}, errors.New("dnscompare needs at least two resolvers or an expect parameter")
+	}
+	query, id, err := buildDNSQuery(t, t.host)
+	if err != nil {
+		return probeResult{}, err
+	}
+
+	results := make([]dnsAnswer, len(resolvers))
+	done := make(chan struct{})
+	for i, resolver := range resolvers {
+		go func() {
+			results[i] = queryResolver(t, resolver, query, id)
+			done <- struct{}{}
+		}()
+	}
+	for range resolvers {
+		<-done
+	}
+
+	// Group the resolvers by the answer set they returned.
+	var failed []string
+	groups := map[string][]string{}
+	metrics := map[string]float64{}
+	var total float64
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.resolver, r.err))
+			continue
+		}
+		metrics[r.resolver+"_ms"] = r.ms
+		total += r.ms
+		key := strings.Join(r.answers, " ")
+		groups[key] = append(groups[key], r.resolver)
+	}
+	if len(failed) == len(results) {
+		return probeResult{}, errors.New(strings.Join(failed, "; "))
+	}
+	metrics["answer_sets"] = float64(len(groups))
+
+	var warnings []string
+	if expect := t.param("expect", ""); expect != "" {
+		want := splitList(expect)
+		slices.Sort(want)
+		for answers, names := range groups {
+			if answers != strings.Join(want, " ") {
+				warnings = append(warnings, fmt.Sprintf("%s answered %s, expected %s", strings.Join(names, ","), describeAnswers(answers), strings.Join(want, " ")))
+			}
+		}
+		slices.Sort(warnings)
+	} else if len(groups) > 1 {
+		var sets []string
+		for answers, names := range groups {
+			sets = append(sets, fmt.Sprintf("%s: %s", strings.Join(names, ","), describeAnswers(answers)))
+		}
+		slices.Sort(sets)
+		warnings = append(warnings, "resolvers disagree ("+strings.Join(sets, "; ")+")")
+	}
+	warnings = append(warnings, failed...)
+
+	return probeResult{
+		Latency: total / float64(len(results)-len(failed)),
+		Metrics: metrics,
+		Warning: strings.Join(warnings, "; "),
+	}, nil
+}
+
+func describeAnswers(answers string) string {
+	if answers == "" {
+		return "nothing"
+	}
+	return answers
+}
+
+// queryResolver sends a query over UDP to resolver, an IP with optional
+// port or "system".
+func queryResolver(t *target, resolver string, query []byte, id uint16) dnsAnswer {
+	result := dnsAnswer{resolver: resolver}
+	addr := resolver
+	if resolver == "system" {
+		if addr, result.err = systemResolver(); result.err != nil {
+			return result
+		}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+
+	conn, err := t.dial("udp", addr)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(t.timeout()))
+
+	start := time.Now()
+	if _, err := conn.Write(query); err != nil {
+		result.err = err
+		return result
+	}
+	reply := make([]byte, 4096)
+	n, err := conn.Read(reply)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.ms = msSince(start)
+	result.answers, result.err = parseDNSAnswers(reply[:n], id)
+	return result
+}
+
+// systemResolver returns the first nameserver of /etc/resolv.conf.
+func systemResolver() (string, error) {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", errors.New("no nameserver in /etc/resolv.conf")
+}
+
+// dnsQueryName returns the name a dns:// or dot:// target asks for.
+func dnsQueryName(t *target) string {
+	if name := strings.Trim(t.url.Path, "/"); name != "" {
+		return name
+	}
+	return t.param("name", ".")
+}
+
+// buildDNSQuery packs a recursive query for name using the record type from
+// the target's "type" parameter (A by default).
+func buildDNSQuery(t *target, name string) ([]byte, uint16, error) {
+	qtype, err := parseDNSType(t.param("type", "A"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	id := uint16(rand.Uint32())
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  qname,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := msg.Pack()
+	return packed, id, err
+}
+
+// checkDNSReply validates a response and returns the number of answers.
+func checkDNSReply(reply []byte, id uint16) (int, error) {
+	answers, err := parseDNSAnswers(reply, id)
+	return len(answers), err
+}
+
+// parseDNSAnswers validates a response and returns its answers as sorted
+// strings, addresses for A and AAAA records.
+func parseDNSAnswers(reply []byte, id uint16) ([]string, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(reply); err != nil {
+		return nil, fmt.Errorf("malformed dns reply: %v", err)
+	}
+	if !msg.Header.Response || msg.Header.ID != id {
+		return nil, errors.New("unexpected dns reply")
+	}
+	if msg.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns query failed: %s", strings.TrimPrefix(msg.Header.RCode.String(), "RCode"))
+	}
+	answers := make([]string, 0, len(msg.Answers))
+	for _, rr := range msg.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			answers = append(answers, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			answers = append(answers, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, body.CNAME.String())
+		case *dnsmessage.NSResource:
+			answers = append(answers, body.NS.String())
+		case *dnsmessage.PTRResource:
+			answers = append(answers, body.PTR.String())
+		case *dnsmessage.MXResource:
+			answers = append(answers, fmt.Sprintf("%d %s", body.Pref, body.MX))
+		case *dnsmessage.TXTResource:
+			answers = append(answers, strings.Join(body.TXT, ""))
+		default:
+			answers = append(answers, rr.Body.GoString())
+		}
+	}
+	slices.Sort(answers)
+	return answers, nil
+}
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+func parseDNSType(s string) (dnsmessage.Type, error) {
+	if t, ok := dnsTypes[strings.ToUpper(s)]; ok {
+		return t, nil
+	}
+	return 0, fmt.Errorf("unsupported dns record type %q", s)
+}
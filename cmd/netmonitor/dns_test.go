@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS answers DNS queries over UDP on a local port with the records
+// of answer for the question asked, and returns the address.
+func serveDNS(t *testing.T, answer func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource)) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		b := make([]byte, 4096)
+		for {
+			n, from, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(b[:n]) != nil || len(query.Questions) != 1 {
+				continue
+			}
+			rcode, answers := answer(query.Questions[0])
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: rcode},
+				Questions: query.Questions,
+				Answers:   answers,
+			}
+			packed, err := reply.Pack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.WriteTo(packed, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// aRecords answers with the IPv4 addresses.
+func aRecords(q dnsmessage.Question, addrs ...string) []dnsmessage.Resource {
+	var rrs []dnsmessage.Resource
+	for _, a := range addrs {
+		rrs = append(rrs, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: [4]byte(net.ParseIP(a).To4())},
+		})
+	}
+	return rrs
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	tests := []struct {
+		target string
+		name   string
+		qtype  dnsmessage.Type
+		err    bool
+	}{
+		{"dns://1.1.1.1/example.com", "example.com.", dnsmessage.TypeA, false},
+		{"dns://1.1.1.1/example.com.?type=aaaa", "example.com.", dnsmessage.TypeAAAA, false},
+		{"dot://1.1.1.1?name=example.org&type=MX", "example.org.", dnsmessage.TypeMX, false},
+		{"dns://1.1.1.1", ".", dnsmessage.TypeA, false},
+		{"dns://1.1.1.1/example.com?type=AXFR", "", 0, true},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, id, err := buildDNSQuery(tgt, dnsQueryName(tgt))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.target, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			t.Fatalf("%s: %v", tt.target, err)
+		}
+		q := msg.Questions[0]
+		if msg.ID != id || !msg.RecursionDesired || q.Name.String() != tt.name || q.Type != tt.qtype {
+			t.Errorf("%s: asked for %s %s, id %d of %d", tt.target, q.Type, q.Name, msg.ID, id)
+		}
+	}
+}
+
+func TestParseDNSAnswers(t *testing.T) {
+	name := dnsmessage.MustNewName("example.com.")
+	header := func(typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET}
+	}
+	reply := func(h dnsmessage.Header, answers ...dnsmessage.Resource) []byte {
+		b, err := (&dnsmessage.Message{Header: h, Answers: answers}).Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	ok := dnsmessage.Header{ID: 7, Response: true}
+
+	tests := []struct {
+		name  string
+		reply []byte
+		want  []string
+		err   string
+	}{
+		{"addresses sorted", reply(ok,
+			dnsmessage.Resource{Header: header(dnsmessage.TypeAAAA), Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}},
+			dnsmessage.Resource{Header: header(dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 9}}},
+			dnsmessage.Resource{Header: header(dnsmessage.TypeA), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+		), []string{"192.0.2.1", "192.0.2.9", "2001:db8::1"}, ""},
+		{"mx and txt", reply(ok,
+			dnsmessage.Resource{Header: header(dnsmessage.TypeMX), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx.example.com.")}},
+			dnsmessage.Resource{Header: header(dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}}},
+		), []string{"10 mx.example.com.", "v=spf1 -all"}, ""},
+		{"no answers", reply(ok), []string{}, ""},
+		{"other id", reply(dnsmessage.Header{ID: 8, Response: true}), nil, "unexpected"},
+		{"a query", reply(dnsmessage.Header{ID: 7}), nil, "unexpected"},
+		{"nxdomain", reply(dnsmessage.Header{ID: 7, Response: true, RCode: dnsmessage.RCodeNameError}), nil, "NameError"},
+		{"truncated", reply(ok)[:5], nil, "malformed"},
+	}
+	for _, tt := range tests {
+		got, err := parseDNSAnswers(tt.reply, 7)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestProbeDNS(t *testing.T) {
+	addr := serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		if q.Name.String() != "example.com." {
+			return dnsmessage.RCodeNameError, nil
+		}
+		return dnsmessage.RCodeSuccess, aRecords(q, "192.0.2.1", "192.0.2.2")
+	})
+
+	tgt, err := parseTarget("dns://" + addr + "/example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := probeDNS(tgt)
+	if err != nil || result.Metrics["answers"] != 2 {
+		t.Errorf("example.com: %+v, %v; want two answers", result, err)
+	}
+
+	tgt, _ = parseTarget("dns://" + addr + "/missing.example.com")
+	if _, err := probeDNS(tgt); err == nil || !strings.Contains(err.Error(), "NameError") {
+		t.Errorf("missing.example.com: error %v, want NXDOMAIN", err)
+	}
+}
+
+func TestProbeDNSCompare(t *testing.T) {
+	answering := func(addrs ...string) string {
+		return serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+			return dnsmessage.RCodeSuccess, aRecords(q, addrs...)
+		})
+	}
+	one, two := answering("192.0.2.1", "192.0.2.2"), answering("192.0.2.2", "192.0.2.1")
+	other := answering("198.51.100.1")
+	failing := serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		return dnsmessage.RCodeServerFailure, nil
+	})
+
+	tests := []struct {
+		name      string
+		params    string
+		sets      float64
+		warning   []string // parts of the warning
+		err       string
+		resolvers []string
+	}{
+		{"agree", "resolvers=" + one + "," + two, 1, nil, "", []string{one, two}},
+		{"disagree", "resolvers=" + one + "," + other, 2,
+			[]string{"resolvers disagree (", one + ": 192.0.2.1 192.0.2.2", other + ": 198.51.100.1"}, "", []string{one, other}},
+		{"expected", "resolvers=" + one + "&expect=192.0.2.2,192.0.2.1", 1, nil, "", []string{one}},
+		{"unexpected", "resolvers=" + one + "," + other + "&expect=192.0.2.1,192.0.2.2", 2,
+			[]string{other + " answered 198.51.100.1, expected 192.0.2.1 192.0.2.2"}, "", []string{one, other}},
+		{"one failing", "resolvers=" + one + "," + failing, 1, []string{failing + ": dns query failed: ServerFailure"}, "", []string{one}},
+		{"all failing", "resolvers=" + failing + "," + failing, 0, nil, "ServerFailure", nil},
+		{"one resolver", "resolvers=" + one, 0, nil, "at least two resolvers", nil},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("dnscompare://example.com?" + tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeDNSCompare(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Metrics["answer_sets"] != tt.sets {
+			t.Errorf("%s: %v answer sets, want %v", tt.name, result.Metrics["answer_sets"], tt.sets)
+		}
+		if (result.Warning == "") != (len(tt.warning) == 0) {
+			t.Errorf("%s: warning %q", tt.name, result.Warning)
+		}
+		for _, part := range tt.warning {
+			if !strings.Contains(result.Warning, part) {
+				t.Errorf("%s: warning %q, want %q in it", tt.name, result.Warning, part)
+			}
+		}
+		for _, r := range tt.resolvers {
+			if _, ok := result.Metrics[r+"_ms"]; !ok {
+				t.Errorf("%s: no time for %s in %v", tt.name, r, result.Metrics)
+			}
+		}
+	}
+}
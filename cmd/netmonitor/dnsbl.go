@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSBL targets look like dnsbl://203.0.113.5 and check whether a public
+// address, such as that of a mail server, is listed on DNS blacklists. The
+// host is degraded while any list has it, naming the lists and the reason
+// they give. lists replaces the default blacklists with a comma-separated
+// set of zones, server asks a nameserver other than the system's (some
+// lists refuse queries from big public resolvers), and refresh sets how
+// often the lists are asked again, 30 minutes unless set; probes in
+// between report the last answers.
+func init() {
+	probers["dnsbl"] = every("30m", probeDNSBL)
+}
+
+// defaultDNSBLs are widely used blacklists that are free to query at low
+// volume.
+var defaultDNSBLs = []string{
+	"zen.spamhaus.org",
+	"bl.spamcop.net",
+	"b.barracudacentral.org",
+	"dnsbl.sorbs.net",
+	"psbl.surriel.com",
+	"bl.mailspike.net",
+}
+
+// dnsblListing is the answer of one blacklist.
+type dnsblListing struct {
+	list   string
+	listed bool
+	reason string
+	err    error
+}
+
+func probeDNSBL(t *target) (probeResult, error) {
+	addr, err := t.resolve()
+	if err != nil {
+		return probeResult{}, err
+	}
+	lists := defaultDNSBLs
+	if s := t.param("lists", ""); s != "" {
+		lists = splitList(s)
+	}
+	resolver := net.DefaultResolver
+	if server := t.param("server", ""); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return t.dialer(network).DialContext(ctx, network, server)
+		}}
+	}
+
+	start := time.Now()
+	listings := make([]dnsblListing, len(lists))
+	var wg sync.WaitGroup
+	for i, list := range lists {
+		wg.Go(func() { listings[i] = queryDNSBL(t, resolver, addr, list) })
+	}
+	wg.Wait()
+
+	var listed, failed []string
+	for _, l := range listings {
+		switch {
+		case l.err != nil:
+			failed = append(failed, fmt.Sprintf("%s: %v", l.list, l.err))
+		case l.listed && l.reason != "":
+			listed = append(listed, fmt.Sprintf("%s (%s)", l.list, l.reason))
+		case l.listed:
+			listed = append(listed, l.list)
+		}
+	}
+	if len(failed) == len(lists) {
+		return probeResult{}, errors.New(strings.Join(failed, "; "))
+	}
+	result := probeResult{
+		Latency: time.Since(start).Seconds() * 1000,
+		Metrics: map[string]float64{
+			"listings": float64(len(listed)),
+			"lists":    float64(len(lists) - len(failed)),
+		},
+	}
+	if len(listed) > 0 {
+		result.Warning = fmt.Sprintf("%s is listed on %s", addr, strings.Join(listed, ", "))
+	}
+	return result, nil
+}
+
+// queryDNSBL looks the address up in one list. Lists answer with an
+// address in 127.0.0.0/8 for listed addresses and NXDOMAIN otherwise;
+// Spamhaus answers 127.255.255.x when it refuses the query.
+func queryDNSBL(t *target, resolver *net.Resolver, addr netip.Addr, list string) dnsblListing {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+	defer cancel()
+	name := dnsblName(addr, list)
+	l := dnsblListing{list: list}
+	answers, err := resolver.LookupNetIP(ctx, "ip4", name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return l
+	}
+	if err != nil {
+		l.err = err
+		return l
+	}
+	for _, a := range answers {
+		a = a.Unmap()
+		switch {
+		case netip.MustParsePrefix("127.255.255.0/24").Contains(a):
+			l.err = fmt.Errorf("query refused (%s)", a)
+			return l
+		case netip.MustParsePrefix("127.0.0.0/8").Contains(a):
+			l.listed = true
+		}
+	}
+	if l.listed {
+		if txt, err := resolver.LookupTXT(ctx, name); err == nil && len(txt) > 0 {
+			l.reason = txt[0]
+		}
+	}
+	return l
+}
+
+// dnsblName is the name an address is looked up as in a list: the octets
+// of IPv4 addresses and the nibbles of IPv6 ones, reversed, under the
+// list's zone.
+func dnsblName(addr netip.Addr, list string) string {
+	var labels []string
+	if addr.Is4() {
+		for _, b := range addr.As4() {
+			labels = append(labels, fmt.Sprint(b))
+		}
+	} else {
+		for _, b := range addr.As16() {
+			labels = append(labels, fmt.Sprintf("%x", b>>4), fmt.Sprintf("%x", b&0xf))
+		}
+	}
+	slices.Reverse(labels)
+	return strings.Join(labels, ".") + "." + strings.TrimSuffix(list, ".")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNSBLName(t *testing.T) {
+	tests := []struct {
+		addr string
+		list string
+		want string
+	}{
+		{"203.0.113.5", "zen.spamhaus.org", "5.113.0.203.zen.spamhaus.org"},
+		{"192.0.2.99", "bl.spamcop.net.", "99.2.0.192.bl.spamcop.net"},
+		{"2001:db8::1", "bl.test", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.test"},
+	}
+	for _, tt := range tests {
+		if got := dnsblName(netip.MustParseAddr(tt.addr), tt.list); got != tt.want {
+			t.Errorf("%s on %s: %s, want %s", tt.addr, tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestProbeDNSBL(t *testing.T) {
+	// Lists under .test answer for 192.0.2.5 as their first label says.
+	server := serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		name := q.Name.String()
+		if !strings.HasPrefix(name, "5.2.0.192.") || q.Type == dnsmessage.TypeAAAA {
+			return dnsmessage.RCodeNameError, nil
+		}
+		list, _, _ := strings.Cut(strings.TrimPrefix(name, "5.2.0.192."), ".")
+		switch {
+		case list == "broken":
+			return dnsmessage.RCodeServerFailure, nil
+		case list == "refused":
+			return dnsmessage.RCodeSuccess, aRecords(q, "127.255.255.254")
+		case list == "clean":
+			return dnsmessage.RCodeNameError, nil
+		case q.Type == dnsmessage.TypeTXT && list == "listed":
+			return dnsmessage.RCodeSuccess, []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+				Body:   &dnsmessage.TXTResource{TXT: []string{"Listed for spam, see https://bl.test/192.0.2.5"}},
+			}}
+		case q.Type == dnsmessage.TypeA:
+			return dnsmessage.RCodeSuccess, aRecords(q, "127.0.0.2")
+		}
+		return dnsmessage.RCodeSuccess, nil
+	})
+
+	tests := []struct {
+		name     string
+		lists    string
+		listings float64
+		warning  string
+		err      string
+	}{
+		{"clean", "clean.test", 0, "", ""},
+		{"listed", "clean.test,listed.test", 1, "192.0.2.5 is listed on listed.test (Listed for spam, see https://bl.test/192.0.2.5)", ""},
+		{"listed without reason", "silent.test,listed.test", 2, "192.0.2.5 is listed on silent.test, listed.test (Listed for spam", ""},
+		{"some failing", "listed.test,broken.test,refused.test", 1, "192.0.2.5 is listed on listed.test", ""},
+		{"all failing", "broken.test,refused.test", 0, "", "refused.test: query refused (127.255.255.254)"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("dnsbl://192.0.2.5?lists=" + tt.lists + "&server=" + server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeDNSBL(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Metrics["listings"] != tt.listings || !strings.HasPrefix(result.Warning, tt.warning) || (tt.warning == "") != (result.Warning == "") {
+			t.Errorf("%s: %v listings, warning %q; want %v, %q", tt.name, result.Metrics["listings"], result.Warning, tt.listings, tt.warning)
+		}
+	}
+}
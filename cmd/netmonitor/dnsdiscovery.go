@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	discovererFactories["dns"] = newDNSDiscoverer
+}
+
+// dnsDiscoverer monitors every address a name resolves to, or the hosts
+// of its SRV records, resolving it again every refresh interval:
+//
+//	dns://web.example.com
+//	dns://_imaps._tcp.example.com?probe=imaps&server=10.0.0.53
+//
+// Names starting with an underscore, or given type=srv, are looked up as
+// SRV records; the record's port completes probe URLs. server picks a
+// nameserver other than the system's. Round-robin servers may answer with
+// part of a record set, so an endpoint is only dropped after it was
+// missing from dnsMissingLimit lookups in a row, and failed lookups keep
+// the endpoints as they are.
+type dnsDiscoverer struct {
+	name     string
+	srv      bool
+	resolver *net.Resolver
+	template hostTemplate
+	missing  map[string]int // lookups in a row each known endpoint was missing from
+	last     map[string]HostConfig
+}
+
+const dnsMissingLimit = 3
+
+func newDNSDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing name")
+	}
+	d := &dnsDiscoverer{
+		name:     u.Hostname(),
+		resolver: net.DefaultResolver,
+		template: newHostTemplate(q),
+		missing:  map[string]int{},
+		last:     map[string]HostConfig{},
+	}
+	switch strings.ToLower(q.Get("type")) {
+	case "":
+		d.srv = strings.HasPrefix(d.name, "_")
+	case "a", "aaaa":
+	case "srv":
+		d.srv = true
+	default:
+		return nil, fmt.Errorf("type must be a or srv, not %q", q.Get("type"))
+	}
+	if server := q.Get("server"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		d.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		}}
+	}
+	refresh := time.Minute
+	if s := q.Get("refresh"); s != "" {
+		r, err := time.ParseDuration(s)
+		if err != nil || r < time.Second {
+			return nil, fmt.Errorf("invalid refresh %q", s)
+		}
+		refresh = r
+	}
+	return &pollDiscoverer{list: d.list, refresh: refresh}, nil
+}
+
+// lookup returns the endpoints the name currently resolves to.
+func (d *dnsDiscoverer) lookup() ([]HostConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var hosts []HostConfig
+	if d.srv {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			if host == "" {
+				continue // "." means the service is not available
+			}
+			hosts = append(hosts, d.template.host(host, strconv.Itoa(int(r.Port)), "", nil))
+		}
+		return hosts, nil
+	}
+	addrs, err := d.resolver.LookupNetIP(ctx, "ip", d.name)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		hosts = append(hosts, d.template.host(addr.Unmap().String(), "", d.name, nil))
+	}
+	return hosts, nil
+}
+
+func (d *dnsDiscoverer) list() ([]HostConfig, error) {
+	hosts, err := d.lookup()
+	// A name that no longer exists has no endpoints left; other errors
+	// may pass.
+	if dnsErr, ok := err.(*net.DNSError); err != nil && !(ok && dnsErr.IsNotFound) {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, h := range hosts {
+		seen[h.Target] = true
+		d.last[h.Target] = h
+		delete(d.missing, h.Target)
+	}
+	for target := range d.last {
+		if seen[target] {
+			continue
+		}
+		if d.missing[target]++; d.missing[target] >= dnsMissingLimit {
+			delete(d.last, target)
+			delete(d.missing, target)
+		}
+	}
+	all := make([]HostConfig, 0, len(d.last))
+	for _, h := range d.last {
+		all = append(all, h)
+	}
+	slices.SortFunc(all, func(a, b HostConfig) int { return strings.Compare(a.Target, b.Target) })
+	return all, nil
+}
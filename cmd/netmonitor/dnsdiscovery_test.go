@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestNewDNSDiscoverer(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"dns://web.example.com", ""},
+		{"dns://_imaps._tcp.example.com?probe=imaps&server=10.0.0.53&refresh=5m", ""},
+		{"dns:///web", "missing name"},
+		{"dns://web.example.com?type=mx", `type must be a or srv, not "mx"`},
+		{"dns://web.example.com?refresh=10ms", `invalid refresh "10ms"`},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		_, err := newDNSDiscoverer(u)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error %v, want %q", tt.url, err, tt.err)
+		}
+	}
+}
+
+// dnsLister returns the list function of a dns:// discoverer asking
+// server.
+func dnsLister(t *testing.T, rawURL, server string) func() ([]HostConfig, error) {
+	t.Helper()
+	u, err := url.Parse(rawURL + "&server=" + server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := newDNSDiscoverer(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*pollDiscoverer).list
+}
+
+func TestDNSDiscovererAddresses(t *testing.T) {
+	var mu sync.Mutex
+	rcode, addrs := dnsmessage.RCodeSuccess, []string{"192.0.2.1", "192.0.2.2"}
+	server := serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case q.Name.String() != "web.example.com.":
+			return dnsmessage.RCodeNameError, nil
+		case rcode != dnsmessage.RCodeSuccess:
+			return rcode, nil
+		case q.Type != dnsmessage.TypeA:
+			return dnsmessage.RCodeSuccess, nil
+		}
+		return dnsmessage.RCodeSuccess, aRecords(q, addrs...)
+	})
+	list := dnsLister(t, "dns://web.example.com?tags=web", server)
+
+	// The second address is kept until it was missing from three lookups,
+	// failed lookups change nothing and a vanished name drops everything
+	// the same way.
+	steps := []struct {
+		rcode   dnsmessage.RCode
+		addrs   []string
+		targets []string
+		err     bool
+	}{
+		{dnsmessage.RCodeSuccess, []string{"192.0.2.1", "192.0.2.2"}, []string{"192.0.2.1", "192.0.2.2"}, false},
+		{dnsmessage.RCodeSuccess, []string{"192.0.2.1"}, []string{"192.0.2.1", "192.0.2.2"}, false},
+		{dnsmessage.RCodeServerFailure, nil, nil, true},
+		{dnsmessage.RCodeSuccess, []string{"192.0.2.1"}, []string{"192.0.2.1", "192.0.2.2"}, false},
+		{dnsmessage.RCodeSuccess, []string{"192.0.2.1"}, []string{"192.0.2.1"}, false},
+		{dnsmessage.RCodeNameError, nil, []string{"192.0.2.1"}, false},
+		{dnsmessage.RCodeNameError, nil, []string{"192.0.2.1"}, false},
+		{dnsmessage.RCodeNameError, nil, []string{}, false},
+	}
+	for i, step := range steps {
+		mu.Lock()
+		rcode, addrs = step.rcode, step.addrs
+		mu.Unlock()
+		hosts, err := list()
+		if (err != nil) != step.err {
+			t.Fatalf("lookup %d: error %v", i+1, err)
+		}
+		if step.err {
+			continue
+		}
+		targets := []string{}
+		for _, h := range hosts {
+			targets = append(targets, h.Target)
+			if h.DisplayName != "web.example.com" || !slices.Equal(h.Tags, []string{"web"}) {
+				t.Errorf("lookup %d: %+v", i+1, h)
+			}
+		}
+		if !slices.Equal(targets, step.targets) {
+			t.Errorf("lookup %d: %v, want %v", i+1, targets, step.targets)
+		}
+	}
+}
+
+func TestDNSDiscovererSRV(t *testing.T) {
+	server := serveDNS(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		if q.Type != dnsmessage.TypeSRV {
+			return dnsmessage.RCodeNameError, nil
+		}
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}
+		srv := func(target string, port uint16) dnsmessage.Resource {
+			return dnsmessage.Resource{Header: header, Body: &dnsmessage.SRVResource{Priority: 10, Weight: 5, Port: port, Target: dnsmessage.MustNewName(target)}}
+		}
+		switch q.Name.String() {
+		case "_imaps._tcp.example.com.":
+			return dnsmessage.RCodeSuccess, []dnsmessage.Resource{srv("mail2.example.com.", 993), srv("mail1.example.com.", 9993)}
+		case "_submission._tcp.example.com.":
+			return dnsmessage.RCodeSuccess, []dnsmessage.Resource{srv(".", 0)}
+		}
+		return dnsmessage.RCodeNameError, nil
+	})
+
+	tests := []struct {
+		url     string
+		targets []string
+	}{
+		{"dns://_imaps._tcp.example.com?probe=imaps", []string{"imaps://mail1.example.com:9993", "imaps://mail2.example.com:993"}},
+		{"dns://_imaps._tcp.example.com?probe=imaps&port=143", []string{"imaps://mail1.example.com:143", "imaps://mail2.example.com:143"}},
+		{"dns://_imaps._tcp.example.com?type=srv", []string{"mail1.example.com", "mail2.example.com"}},
+		{"dns://_submission._tcp.example.com?probe=smtp", []string{}},
+	}
+	for _, tt := range tests {
+		hosts, err := dnsLister(t, tt.url, server)()
+		targets := []string{}
+		for _, h := range hosts {
+			targets = append(targets, h.Target)
+		}
+		if err != nil || !slices.Equal(targets, tt.targets) {
+			t.Errorf("%s: %v, %v; want %v", tt.url, targets, err, tt.targets)
+		}
+	}
+}
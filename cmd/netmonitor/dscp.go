@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dscpNames maps the common per-hop behaviours to their code points.
+var dscpNames = map[string]int{"EF": 46, "VA": 44, "LE": 1}
+
+func init() {
+	for i := range 8 {
+		dscpNames[fmt.Sprintf("CS%d", i)] = i * 8
+	}
+	for class := 1; class <= 4; class++ {
+		for drop := 1; drop <= 3; drop++ {
+			dscpNames[fmt.Sprintf("AF%d%d", class, drop)] = class*8 + drop*2
+		}
+	}
+}
+
+// parseDSCP accepts a code point name such as EF or AF41, or a number
+// from 0 to 63.
+func parseDSCP(s string) (int, error) {
+	if v, ok := dscpNames[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid dscp %q", s)
+	}
+	return v, nil
+}
+
+// setDSCP marks the target's probe packets with a DSCP value so QoS
+// treatment along the path can be verified.
+func (t *target) setDSCP(s string) error {
+	if s == "" {
+		return nil
+	}
+	if !canSetTOS {
+		return fmt.Errorf("dscp marking is not supported on this platform")
+	}
+	v, err := parseDSCP(s)
+	if err != nil {
+		return err
+	}
+	t.dscp = v
+	return nil
+}
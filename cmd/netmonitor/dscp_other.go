@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+const canSetTOS = false
+
+func setTOS(fd uintptr, ipv6 bool, tos int) error {
+	return errors.New("dscp marking is not supported on this platform")
+}
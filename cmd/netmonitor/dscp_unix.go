@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+const canSetTOS = true
+
+// setTOS sets the traffic class byte of outgoing packets.
+func setTOS(fd uintptr, ipv6 bool, tos int) error {
+	if ipv6 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+}
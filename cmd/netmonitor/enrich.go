@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnrichConfig adds network details to every host: its address, reverse
+// DNS name, the autonomous system announcing it and its location. ASN data
+// comes from Team Cymru's DNS service ("cymru") or a local MaxMind-format
+// ASN database such as GeoLite2-ASN.mmdb, locations from a city database
+// such as GeoLite2-City.mmdb.
+//
+//	enrich:
+//	  ptr: true
+//	  asn: /var/lib/GeoIP/GeoLite2-ASN.mmdb
+//	  geo: /var/lib/GeoIP/GeoLite2-City.mmdb
+type EnrichConfig struct {
+	PTR bool   `yaml:"ptr"`
+	ASN string `yaml:"asn"`
+	Geo string `yaml:"geo"`
+}
+
+// enrichRefresh is how often host details are looked up again.
+const enrichRefresh = 24 * time.Hour
+
+// HostInfo describes where a host lives on the network.
+type HostInfo struct {
+	IP      string `json:"ip,omitempty"`
+	PTR     string `json:"ptr,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	Org     string `json:"org,omitempty"`
+	Network string `json:"network,omitempty"` // announced prefix
+
+	Location *GeoLocation `json:"location,omitempty"`
+}
+
+// asnLookup resolves the autonomous system an address belongs to.
+type asnLookup interface {
+	LookupASN(ip net.IP) (HostInfo, error)
+}
+
+// enricher holds the configured lookups.
+type enricher struct {
+	ptr bool
+	asn asnLookup
+	geo *mmdb
+}
+
+// newEnricher returns nil when no lookups are configured.
+func newEnricher(cfg EnrichConfig) (*enricher, error) {
+	if !cfg.PTR && cfg.ASN == "" && cfg.Geo == "" {
+		return nil, nil
+	}
+	e := &enricher{ptr: cfg.PTR}
+	switch cfg.ASN {
+	case "":
+	case "cymru":
+		e.asn = cymruLookup{}
+	default:
+		db, err := openMMDB(cfg.ASN)
+		if err != nil {
+			return nil, err
+		}
+		e.asn = mmdbASNLookup{db}
+	}
+	if cfg.Geo != "" {
+		db, err := openMMDB(cfg.Geo)
+		if err != nil {
+			return nil, err
+		}
+		e.geo = db
+	}
+	return e, nil
+}
+
+// enrichHosts looks up the details of every host now and then daily.
+func (m *Monitor) enrichHosts(e *enricher) {
+	for {
+		hs := m.hosts()
+		for _, t := range hs.targets {
+			m.enrich(e, t, hs.stats[t.name])
+		}
+		time.Sleep(enrichRefresh)
+	}
+}
+
+// enrich looks up the details of one host.
+func (m *Monitor) enrich(e *enricher, t *target, stats *hostStats) {
+	info, err := e.lookup(t)
+	if err != nil {
+		log.Printf("Looking up details of %s failed: %v", t.name, err)
+	}
+	stats.update(func(stats *PingStats) { stats.Info = info })
+}
+
+// lookup returns what could be found out about a target. Targets without a
+// host, such as exec, get nil.
+func (e *enricher) lookup(t *target) (*HostInfo, error) {
+	if t.host == "" {
+		return nil, nil
+	}
+	ip := net.ParseIP(t.host)
+	if ip == nil {
+		ips, err := net.LookupIP(t.host)
+		if err != nil {
+			return nil, err
+		}
+		// Prefer IPv4 as that is what the probes use.
+		i := slices.IndexFunc(ips, func(ip net.IP) bool { return ip.To4() != nil })
+		ip = ips[max(i, 0)]
+	}
+
+	info := &HostInfo{IP: ip.String()}
+	var errs []error
+	if e.ptr {
+		names, err := net.LookupAddr(info.IP)
+		var dnsErr *net.DNSError
+		if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+			errs = append(errs, err)
+		} else if len(names) > 0 {
+			info.PTR = strings.TrimSuffix(names[0], ".")
+		}
+	}
+	if ip.IsPrivate() || ip.IsLoopback() {
+		return info, errors.Join(errs...)
+	}
+	if e.asn != nil {
+		a, err := e.asn.LookupASN(ip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("asn: %v", err))
+		}
+		info.ASN, info.Org, info.Network = a.ASN, a.Org, a.Network
+	}
+	if e.geo != nil {
+		loc, err := lookupGeo(e.geo, ip)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("geo: %v", err))
+		}
+		info.Location = loc
+	}
+	return info, errors.Join(errs...)
+}
+
+// cymruLookup queries Team Cymru's IP to ASN mapping over DNS:
+// 4.3.2.1.origin.asn.cymru.com TXT "15169 | 8.8.8.0/24 | US | arin | 2023-12-28"
+// and AS15169.asn.cymru.com TXT "15169 | US | arin | 2000-03-30 | GOOGLE, US".
+type cymruLookup struct{}
+
+func (cymruLookup) LookupASN(ip net.IP) (HostInfo, error) {
+	var name string
+	if ip4 := ip.To4(); ip4 != nil {
+		name = fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", ip4[3], ip4[2], ip4[1], ip4[0])
+	} else {
+		var nibbles []string
+		for i := len(ip) - 1; i >= 0; i-- {
+			nibbles = append(nibbles, fmt.Sprintf("%x.%x", ip[i]&0xf, ip[i]>>4))
+		}
+		name = strings.Join(nibbles, ".") + ".origin6.asn.cymru.com"
+	}
+
+	origin, err := cymruTXT(name)
+	if err != nil {
+		return HostInfo{}, err
+	}
+	// Multiple origins are listed space separated; use the first.
+	asn, err := strconv.ParseUint(strings.Fields(origin[0])[0], 10, 32)
+	if err != nil {
+		return HostInfo{}, fmt.Errorf("unexpected answer %q", strings.Join(origin, "|"))
+	}
+	info := HostInfo{ASN: uint32(asn)}
+	if len(origin) > 1 {
+		info.Network = origin[1]
+	}
+
+	if as, err := cymruTXT(fmt.Sprintf("AS%d.asn.cymru.com", asn)); err == nil && len(as) >= 5 {
+		info.Org = as[4]
+	}
+	return info, nil
+}
+
+// cymruTXT returns the pipe separated fields of the first TXT record.
+func cymruTXT(name string) ([]string, error) {
+	records, err := net.LookupTXT(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no answer")
+	}
+	fields := strings.Split(records[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, nil
+}
+
+// mmdbASNLookup reads GeoLite2-ASN compatible databases.
+type mmdbASNLookup struct{ db *mmdb }
+
+func (l mmdbASNLookup) LookupASN(ip net.IP) (HostInfo, error) {
+	record, prefix, err := l.db.Lookup(ip)
+	if err != nil || record == nil {
+		return HostInfo{}, err
+	}
+	fields, _ := record.(map[string]any)
+	info := HostInfo{ASN: uint32(mmdbUint(fields["autonomous_system_number"]))}
+	info.Org, _ = fields["autonomous_system_organization"].(string)
+
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+	info.Network = network.String()
+	return info, nil
+}
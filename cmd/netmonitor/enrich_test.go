@@ -0,0 +1,60 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewEnricher(t *testing.T) {
+	if e, err := newEnricher(EnrichConfig{}); e != nil || err != nil {
+		t.Errorf("no lookups: %v, %v; want nil", e, err)
+	}
+	missing := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	if _, err := newEnricher(EnrichConfig{ASN: missing}); err == nil {
+		t.Error("missing ASN database accepted")
+	}
+	if _, err := newEnricher(EnrichConfig{Geo: missing}); err == nil {
+		t.Error("missing city database accepted")
+	}
+	if e, err := newEnricher(EnrichConfig{ASN: "cymru"}); err != nil || e.asn != (cymruLookup{}) {
+		t.Errorf("cymru: %+v, %v", e, err)
+	}
+}
+
+func TestEnricherLookup(t *testing.T) {
+	asn := writeMMDB(t, 6, 28, map[string]any{
+		"8.8.8.0/24": map[string]any{"autonomous_system_number": uint32(15169), "autonomous_system_organization": "GOOGLE"},
+	})
+	e, err := newEnricher(EnrichConfig{ASN: asn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want HostInfo
+	}{
+		{"8.8.8.8", HostInfo{IP: "8.8.8.8", ASN: 15169, Org: "GOOGLE", Network: "8.8.8.0/24"}},
+		{"9.9.9.9", HostInfo{IP: "9.9.9.9"}},
+		{"192.168.1.10", HostInfo{IP: "192.168.1.10"}}, // private addresses are not looked up
+		{"127.0.0.1", HostInfo{IP: "127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := e.lookup(tgt)
+		if err != nil || info == nil || *info != tt.want {
+			t.Errorf("%s: %+v, %v; want %+v", tt.host, info, err, tt.want)
+		}
+	}
+
+	if info, err := e.lookup(&target{}); info != nil || err != nil {
+		t.Errorf("no host: %+v, %v; want nil", info, err)
+	}
+	if _, err := e.lookup(&target{host: "host.invalid"}); err == nil || !strings.Contains(err.Error(), "host.invalid") {
+		t.Errorf("unresolvable host: error %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// EscalationConfig notifies more people the longer an incident goes
+// unacknowledged. Routes name the policy to follow:
+//
+//	escalations:
+//	  - name: standard
+//	    steps:
+//	      - notifiers: [slack]
+//	      - after: 10m
+//	        notifiers: [email]
+//	      - after: 30m
+//	        notifiers: [pagerduty]
+//	routes:
+//	  - tags: [prod]
+//	    escalation: standard
+//
+// Each step is notified once the incident has been open for its after,
+// unless it was acknowledged by then; steps without one are notified with
+// the route's own notifiers. Reminders and the recovery go to every step
+// reached.
+type EscalationConfig struct {
+	Name  string           `yaml:"name"`
+	Steps []EscalationStep `yaml:"steps"`
+}
+
+type EscalationStep struct {
+	After     time.Duration `yaml:"after"`
+	Notifiers []string      `yaml:"notifiers"`
+}
+
+// escalationTick is how often incidents are checked for steps due.
+const escalationTick = 5 * time.Second
+
+type escalation struct {
+	name  string
+	steps []escalationStep
+}
+
+type escalationStep struct {
+	after     time.Duration
+	notifiers []*namedNotifier
+}
+
+// incidentEscalation is how far an incident got in a policy.
+type incidentEscalation struct {
+	policy  *escalation
+	reached int // steps notified
+}
+
+func newEscalation(ec EscalationConfig, notifiers []*namedNotifier) (*escalation, error) {
+	if ec.Name == "" {
+		return nil, fmt.Errorf("escalation has no name")
+	}
+	if len(ec.Steps) == 0 {
+		return nil, fmt.Errorf("escalation %s has no steps", ec.Name)
+	}
+	e := &escalation{name: ec.Name}
+	for i, sc := range ec.Steps {
+		if sc.After < 0 || i > 0 && sc.After <= ec.Steps[i-1].After {
+			return nil, fmt.Errorf("escalation %s: step %d must come after the one before it", ec.Name, i+1)
+		}
+		ns, err := lookupNotifiers(sc.Notifiers, notifiers)
+		if err != nil {
+			return nil, fmt.Errorf("escalation %s: step %d: %v", ec.Name, i+1, err)
+		}
+		e.steps = append(e.steps, escalationStep{after: sc.After, notifiers: ns})
+	}
+	return e, nil
+}
+
+// escalate starts the policies routed to on an open incident that has none
+// yet, and returns the notifiers of the steps it has reached: those of
+// step zero when it just opened, or everyone notified so far.
+func (l *incidentLog) escalate(id int, policies []*escalation) []*namedNotifier {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.find(id)
+	if inc == nil {
+		return nil
+	}
+	if inc.escalations == nil && inc.Resolved.IsZero() {
+		for _, p := range policies {
+			e := &incidentEscalation{policy: p}
+			for e.reached < len(p.steps) && p.steps[e.reached].after == 0 {
+				e.reached++
+			}
+			inc.escalations = append(inc.escalations, e)
+		}
+	}
+	var notifiers []*namedNotifier
+	for _, e := range inc.escalations {
+		for _, step := range e.policy.steps[:e.reached] {
+			notifiers = appendUnique(notifiers, step.notifiers...)
+		}
+	}
+	return notifiers
+}
+
+// escalatedAlert is an alert for the steps of an incident that came due.
+type escalatedAlert struct {
+	alert     Alert
+	notifiers []*namedNotifier
+}
+
+// dueEscalations returns the alerts for the steps of open, unacknowledged
+// incidents that are due by now, counting them as notified.
+func (l *incidentLog) dueEscalations(now time.Time) []escalatedAlert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var due []escalatedAlert
+	for _, inc := range l.open {
+		if inc.Acknowledged {
+			continue
+		}
+		var notifiers []*namedNotifier
+		for _, e := range inc.escalations {
+			for e.reached < len(e.policy.steps) && now.Sub(inc.Opened) >= e.policy.steps[e.reached].after {
+				notifiers = appendUnique(notifiers, e.policy.steps[e.reached].notifiers...)
+				e.reached++
+			}
+		}
+		if len(notifiers) == 0 {
+			continue
+		}
+		a := inc.alert
+		a.Repeat, a.Escalated = true, true
+		a.Time, a.Duration = now, now.Sub(inc.Opened)
+		inc.lastNotified = now
+		due = append(due, escalatedAlert{a, notifiers})
+	}
+	return due
+}
+
+// runEscalations notifies the later steps of escalation policies as they
+// come due. Like alerts, they are only sent by the leader.
+func (m *Monitor) runEscalations() {
+	ticker := time.NewTicker(escalationTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !m.leads() {
+			continue
+		}
+		for _, e := range m.incidents.dueEscalations(m.clock.Now()) {
+			a := e.alert
+			if s, ok := m.hosts().stats[a.Host]; ok {
+				a.Stats = s.load()
+			}
+			if m.blackedOut(a) {
+				continue
+			}
+			log.Printf("Escalating incident %d of %s, %s for %v", a.IncidentID, a.Host, a.Status, a.Duration.Round(time.Second))
+			m.send(a, e.notifiers)
+		}
+	}
+}
+
+func appendUnique(notifiers []*namedNotifier, more ...*namedNotifier) []*namedNotifier {
+	for _, n := range more {
+		if !slices.Contains(notifiers, n) {
+			notifiers = append(notifiers, n)
+		}
+	}
+	return notifiers
+}
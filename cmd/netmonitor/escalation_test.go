@@ -0,0 +1,134 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewEscalation(t *testing.T) {
+	notifiers := []*namedNotifier{{name: "chat"}, {name: "pager"}}
+	tests := []struct {
+		name string
+		ec   EscalationConfig
+		err  string
+	}{
+		{"ok", EscalationConfig{Name: "standard", Steps: []EscalationStep{{Notifiers: []string{"chat"}}, {After: 10 * time.Minute, Notifiers: []string{"pager"}}}}, ""},
+		{"no name", EscalationConfig{Steps: []EscalationStep{{Notifiers: []string{"chat"}}}}, "escalation has no name"},
+		{"no steps", EscalationConfig{Name: "standard"}, "escalation standard has no steps"},
+		{"negative", EscalationConfig{Name: "standard", Steps: []EscalationStep{{After: -time.Minute}}}, "step 1 must come after"},
+		{"out of order", EscalationConfig{Name: "standard", Steps: []EscalationStep{{After: 10 * time.Minute}, {After: 10 * time.Minute}}}, "step 2 must come after"},
+		{"unknown notifier", EscalationConfig{Name: "standard", Steps: []EscalationStep{{Notifiers: []string{"email"}}}}, `step 1: unknown notifier "email"`},
+	}
+	for _, tt := range tests {
+		e, err := newEscalation(tt.ec, notifiers)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || e.name != "standard" || len(e.steps) != 2 || e.steps[1].after != 10*time.Minute || e.steps[1].notifiers[0] != notifiers[1] {
+			t.Errorf("%s: %+v, %v", tt.name, e, err)
+		}
+	}
+}
+
+func TestEscalation(t *testing.T) {
+	var notifiers []*namedNotifier
+	for _, name := range []string{"ops", "chat", "email", "pager"} {
+		notifiers = append(notifiers, &namedNotifier{name: name})
+	}
+	standard, err := newEscalation(EscalationConfig{Name: "standard", Steps: []EscalationStep{
+		{Notifiers: []string{"chat"}},
+		{After: 10 * time.Minute, Notifiers: []string{"email"}},
+		{After: 30 * time.Minute, Notifiers: []string{"chat", "pager"}},
+	}}, notifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMonitor(nil, 0, time.Minute)
+	m.notifiers = notifiers
+	for _, rc := range []RouteConfig{
+		{Tags: []string{"prod"}, Notifiers: []string{"ops"}, Escalation: "standard", Continue: true},
+		{Hosts: []string{"db1"}, Escalation: "standard"},
+	} {
+		r, err := newRoute(rc, notifiers, []*escalation{standard})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.routes = append(m.routes, r)
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := func(ns []*namedNotifier) []string {
+		var names []string
+		for _, n := range ns {
+			names = append(names, n.name)
+		}
+		return names
+	}
+	open := func(host string, tags ...string) Alert {
+		a := Alert{Host: host, Status: "down", Severity: severityCritical, Time: start, Stats: PingStats{Host: host, Tags: tags}}
+		m.incidents.record(&a)
+		return a
+	}
+	web := open("web1", "prod")
+	if got := names(m.routeAlert(web)); !slices.Equal(got, []string{"ops", "chat"}) {
+		t.Errorf("opening alert sent to %q", got)
+	}
+	db := open("db1", "prod")
+	m.routeAlert(db)
+	staging := open("web2", "staging")
+	if got := names(m.routeAlert(staging)); got != nil {
+		t.Errorf("unrouted alert sent to %q", got)
+	}
+
+	type due struct {
+		host      string
+		notifiers []string
+	}
+	tests := []struct {
+		name  string
+		after time.Duration
+		ack   *Alert
+		want  []due
+	}{
+		{"before the second step", 5 * time.Minute, nil, nil},
+		{"second step", 10 * time.Minute, nil, []due{{"web1", []string{"email"}}, {"db1", []string{"email"}}}},
+		{"notified once", 15 * time.Minute, nil, nil},
+		{"acknowledged", 30 * time.Minute, &db, []due{{"web1", []string{"chat", "pager"}}}},
+		{"last step reached", 2 * time.Hour, nil, nil},
+	}
+	for _, tt := range tests {
+		now := start.Add(tt.after)
+		if tt.ack != nil {
+			if _, err := m.incidents.acknowledge(tt.ack.IncidentID, "oncall", "", now); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var got []due
+		for _, e := range m.incidents.dueEscalations(now) {
+			a := e.alert
+			if !a.Repeat || !a.Escalated || !a.Time.Equal(now) || a.Duration != tt.after || a.Severity != severityCritical {
+				t.Errorf("%s: alert %+v", tt.name, a)
+			}
+			got = append(got, due{a.Host, names(e.notifiers)})
+		}
+		slices.SortFunc(got, func(a, b due) int { return -strings.Compare(a.host, b.host) })
+		if !slices.EqualFunc(got, tt.want, func(a, b due) bool { return a.host == b.host && slices.Equal(a.notifiers, b.notifiers) }) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The recovery goes to everyone notified.
+	up := Alert{Host: "web1", Status: "up", Time: start.Add(3 * time.Hour), Stats: PingStats{Host: "web1", Tags: []string{"prod"}}}
+	m.incidents.record(&up)
+	if got := names(m.routeAlert(up)); !slices.Equal(got, []string{"ops", "chat", "email", "pager"}) {
+		t.Errorf("recovery sent to %q", got)
+	}
+	if got := names(m.routeAlert(open("web1", "prod"))); !slices.Equal(got, []string{"ops", "chat"}) {
+		t.Errorf("next incident starts over, but sent to %q", got)
+	}
+}
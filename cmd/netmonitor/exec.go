@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec targets run a local command on every interval, for example
+//
+//	exec:///usr/lib/nagios/plugins/check_http?arg=-H&arg=example.com
+//
+// The command follows the Nagios plugin conventions: exit code 0 is up, 1 is
+// degraded, 2 and 3 are down, and the first line of output is the status
+// text optionally followed by "|" and perfdata. Perfdata values show up as
+// metrics, and a "time" or "rta" value is used as the latency.
+func init() {
+	probers["exec"] = probeExec
+	localProbes["exec"] = true
+}
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+func probeExec(t *target) (probeResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, t.url.Path, t.url.Query()["arg"]...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := msSince(start)
+
+	code := nagiosOK
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		return probeResult{}, fmt.Errorf("command timed out after %v", t.timeout())
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		return probeResult{}, err
+	}
+
+	text, metrics := parsePluginOutput(stdout.Bytes())
+	result := probeResult{Latency: elapsed, Metrics: metrics}
+	for _, name := range []string{"time", "rta"} {
+		if v, ok := metrics[name]; ok {
+			result.Latency = v
+			break
+		}
+	}
+
+	switch code {
+	case nagiosOK:
+		return result, nil
+	case nagiosWarning:
+		result.Warning = text
+		if result.Warning == "" {
+			result.Warning = "command returned WARNING"
+		}
+		return result, nil
+	default:
+		if text == "" {
+			text = fmt.Sprintf("command exited with status %d", code)
+		}
+		return probeResult{}, errors.New(text)
+	}
+}
+
+// parsePluginOutput splits the first output line into status text and
+// perfdata. Perfdata entries look like 'label'=value[UOM];warn;crit;min;max
+// and values with a time unit are converted to milliseconds.
+func parsePluginOutput(out []byte) (string, map[string]float64) {
+	line, _, _ := bytes.Cut(out, []byte("\n"))
+	text, perf, _ := strings.Cut(string(line), "|")
+	text = strings.TrimSpace(text)
+
+	metrics := map[string]float64{}
+	for _, field := range splitPerfdata(perf) {
+		label, rest, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		label = strings.Trim(label, "'")
+		value, _, _ := strings.Cut(rest, ";")
+
+		num := strings.TrimRight(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ%")
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimPrefix(value, num) {
+		case "s":
+			v *= 1000
+		case "us":
+			v /= 1000
+		}
+		metrics[label] = v
+	}
+	if len(metrics) == 0 {
+		metrics = nil
+	}
+	return text, metrics
+}
+
+// splitPerfdata splits perfdata on spaces outside single quoted labels.
+func splitPerfdata(perf string) []string {
+	var fields []string
+	var field strings.Builder
+	quoted := false
+	for _, r := range perf {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			field.WriteRune(r)
+		case r == ' ' && !quoted:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePluginOutput(t *testing.T) {
+	tests := []struct {
+		out     string
+		text    string
+		metrics map[string]float64
+	}{
+		{"HTTP OK: 200 in 0.05s|time=0.052s;1;2;0 size=1024B;;;0\nmore detail\n", "HTTP OK: 200 in 0.05s", map[string]float64{"time": 52, "size": 1024}},
+		{"PING OK - rta 1.2ms | rta=1.2ms;100;500;0 pl=0%;20;60;0", "PING OK - rta 1.2ms", map[string]float64{"rta": 1.2, "pl": 0}},
+		{"OK | 'free space /'=42.5% 'latency'=250us", "OK", map[string]float64{"free space /": 42.5, "latency": 0.25}},
+		{"DISK WARNING - 91% used", "DISK WARNING - 91% used", nil},
+		{"OK | novalue label=;1;2 count=U", "OK", nil},
+		{"", "", nil},
+	}
+	for _, tt := range tests {
+		text, metrics := parsePluginOutput([]byte(tt.out))
+		if text != tt.text || !maps.Equal(metrics, tt.metrics) {
+			t.Errorf("%q: %q, %v; want %q, %v", tt.out, text, metrics, tt.text, tt.metrics)
+		}
+	}
+}
+
+func TestProbeExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	tests := []struct {
+		script  string
+		latency float64
+		warning string
+		err     string
+	}{
+		{"echo 'OK | time=0.25s'", 250, "", ""},
+		{"echo 'LOAD WARNING - 4.2'; exit 1", -1, "LOAD WARNING - 4.2", ""},
+		{"exit 1", -1, "command returned WARNING", ""},
+		{"echo 'CRITICAL - disk full'; exit 2", 0, "", "CRITICAL - disk full"},
+		{"exit 3", 0, "", "command exited with status 3"},
+		{"exec sleep 5", 0, "", "timed out"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("exec:///bin/sh?arg=-c&arg=" + url.QueryEscape(tt.script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgt.retry.Timeout = 500 * time.Millisecond
+		result, err := probeExec(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.script, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Warning != tt.warning || tt.latency >= 0 && result.Latency != tt.latency {
+			t.Errorf("%q: %+v, %v; want latency %v, warning %q", tt.script, result, err, tt.latency, tt.warning)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ProbeEvent is one probe result as seen by exporters. Previous differs
+// from Status when the probe changed the host's state.
+type ProbeEvent struct {
+	Host     string             `json:"host"`
+	Type     string             `json:"type"`
+	Tags     []string           `json:"tags,omitempty"`
+	Time     time.Time          `json:"time"`
+	Status   string             `json:"status"`
+	Previous string             `json:"previous"`
+	Latency  float64            `json:"latency"`
+	Loss     float64            `json:"loss"`
+	Metrics  map[string]float64 `json:"metrics,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	// Reason classifies Error like PingStats.FailureReason.
+	Reason string `json:"reason,omitempty"`
+
+	// Address is what the hostname resolved to. PreviousAddress is set
+	// on the event that first saw it change.
+	Address         string `json:"address,omitempty"`
+	PreviousAddress string `json:"previousAddress,omitempty"`
+}
+
+// Changed reports whether the event is a status change.
+func (e ProbeEvent) Changed() bool {
+	return e.Status != e.Previous
+}
+
+// Exporter streams probe results to an external system. Export is called
+// from the probing goroutines for every result and must not block; slow
+// backends queue events and send them in the background.
+type Exporter interface {
+	Export(e ProbeEvent)
+}
+
+// exporterFactories maps the scheme of an -export URL to the constructor
+// of its exporter. Backends register themselves from their own files.
+var exporterFactories = map[string]func(u *url.URL) (Exporter, error){}
+
+func parseExporter(raw string) (Exporter, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return nil, fmt.Errorf("invalid exporter %q", raw)
+	}
+	factory, ok := exporterFactories[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("invalid exporter %q: unknown type %q", u.Redacted(), u.Scheme)
+	}
+	e, err := factory(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exporter %q: %v", u.Redacted(), err)
+	}
+	return e, nil
+}
+
+func (m *Monitor) export(e ProbeEvent) {
+	for _, x := range m.exporters {
+		x.Export(e)
+	}
+	m.streams.Export(e)
+}
+
+// exportQueue bounds how many events a streaming exporter holds while its
+// backend is slow or unreachable.
+const (
+	exportQueue = 256
+	exportRetry = 10 * time.Second
+)
+
+// streamExporter is embedded by exporters that keep a connection to a
+// broker. It queues events and runs the connection in the background,
+// reconnecting after failures.
+type streamExporter struct {
+	name   string
+	events chan ProbeEvent
+
+	// failing is set while the broker is unreachable so the error is only
+	// logged once. It is only used by the run goroutine.
+	failing bool
+}
+
+func newStreamExporter(name string) streamExporter {
+	return streamExporter{name: name, events: make(chan ProbeEvent, exportQueue)}
+}
+
+// Export queues the event, dropping it when the broker cannot keep up.
+func (s *streamExporter) Export(ev ProbeEvent) {
+	select {
+	case s.events <- ev:
+	default:
+		telemetry.exportDropped.Add(1)
+	}
+}
+
+// run calls session until the process exits. A session returns when its
+// connection breaks and calls connected once it is established.
+func (s *streamExporter) run(session func() error) {
+	for {
+		err := session()
+		if !s.failing {
+			log.Printf("%s export failed, retrying: %v", s.name, err)
+			s.failing = true
+		}
+		time.Sleep(exportRetry)
+	}
+}
+
+func (s *streamExporter) connected() {
+	log.Printf("%s export connected", s.name)
+	s.failing = false
+}
+
+var topicUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// topicID turns a host into something usable as a single topic or subject
+// level.
+func topicID(host string) string {
+	return strings.Trim(topicUnsafe.ReplaceAllString(host, "_"), "_")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Failure reasons say in a word why a probe failed, so a dashboard full
+// of down hosts can be told apart at a glance: a resolver outage, a
+// firewall dropping probes, routers reporting a host unreachable, or the
+// monitor itself lacking permission to send.
+const (
+	reasonDNS         = "dns_error"        // the host name did not resolve
+	reasonTimeout     = "timeout"          // no answer in time
+	reasonUnreachable = "icmp_unreachable" // a router or the host said it cannot be reached
+	reasonRefused     = "connection_refused"
+	reasonSocket      = "socket_error" // the monitor could not open or use its socket
+	reasonCheck       = "check_failed" // an answer came, but not the expected one
+
+	reasonCertExpired         = "cert_expired"         // the server's certificate is out of its validity period
+	reasonIntermediateExpired = "intermediate_expired" // so is an intermediate of its chain
+	reasonCertRevoked         = "cert_revoked"         // the CA revoked a certificate of the chain
+	reasonHostnameMismatch    = "hostname_mismatch"    // the certificate is for other names
+	reasonUntrustedChain      = "untrusted_chain"      // no chain to a trusted root
+)
+
+// failureReason classifies the error of a failed probe.
+func failureReason(err error) string {
+	var dnsErr *net.DNSError
+	var icmpErr *icmpError
+	var opErr *net.OpError
+	var timeout interface{ Timeout() bool }
+	var certErr *certError
+	switch {
+	case errors.As(err, &certErr):
+		return certErr.reason
+	case errors.As(err, &dnsErr):
+		return reasonDNS
+	case errors.As(err, &icmpErr),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return reasonUnreachable
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return reasonRefused
+	case errors.Is(err, os.ErrPermission),
+		errors.As(err, &opErr) && strings.HasPrefix(opErr.Net, "ip"): // raw sockets
+		return reasonSocket
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &timeout) && timeout.Timeout(),
+		errors.Is(err, errSimTimeout), errors.Is(err, errSimOutage):
+		return reasonTimeout
+	}
+	return reasonCheck
+}
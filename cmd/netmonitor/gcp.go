@@ -0,0 +1,231 @@
+package main
+
+import (
+	"cmp"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	discovererFactories["gcp"] = newGCPDiscoverer
+}
+
+// gcpDiscoverer lists Compute Engine instances of a project in every zone
+// or the one named by the zone parameter. Credentials come from the service
+// account key file named by the credentials parameter or
+// $GOOGLE_APPLICATION_CREDENTIALS, or else the metadata server of the
+// instance netmonitor runs on. The project is the URL's host, or else the
+// one of the credentials.
+type gcpDiscoverer struct {
+	cloudOptions
+	project string
+	zone    string
+	token   oauthToken
+}
+
+// gcpMetadata is the Compute Engine metadata server.
+const gcpMetadata = "http://metadata.google.internal/computeMetadata/v1"
+
+func newGCPDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	opts, err := newCloudOptions(q)
+	if err != nil {
+		return nil, err
+	}
+	d := &gcpDiscoverer{cloudOptions: opts, project: u.Host, zone: q.Get("zone")}
+	if file := cmp.Or(q.Get("credentials"), os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")); file != "" {
+		key, err := readGCPKey(file)
+		if err != nil {
+			return nil, err
+		}
+		d.project = cmp.Or(d.project, key.ProjectID)
+		d.token.fetch = key.tokenRequest
+	} else {
+		d.token.fetch = func() (*http.Request, error) {
+			return gcpMetadataRequest("/instance/service-accounts/default/token")
+		}
+	}
+	return &pollDiscoverer{list: d.list, refresh: d.refresh}, nil
+}
+
+func gcpMetadataRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, gcpMetadata+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return req, nil
+}
+
+// gcpKey is a service account key file.
+type gcpKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+func readGCPKey(path string) (*gcpKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k gcpKey
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if k.Type != "service_account" {
+		return nil, fmt.Errorf("%s: not a service account key", path)
+	}
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s: invalid private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var ok bool
+	if k.key, ok = parsed.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("%s: private key is not RSA", path)
+	}
+	k.TokenURI = cmp.Or(k.TokenURI, "https://oauth2.googleapis.com/token")
+	return &k, nil
+}
+
+// tokenRequest exchanges a JWT signed with the key for an access token.
+func (k *gcpKey) tokenRequest() (*http.Request, error) {
+	now := time.Now()
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]any{
+		"iss":   k.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/compute.readonly",
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	hash := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest(http.MethodPost, k.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// gcpInstance holds the fields of a Compute Engine instance discovery
+// needs.
+type gcpInstance struct {
+	Name              string            `json:"name"`
+	Status            string            `json:"status"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+func (d *gcpDiscoverer) list() ([]HostConfig, error) {
+	if d.project == "" {
+		req, err := gcpMetadataRequest("/project/project-id")
+		if err != nil {
+			return nil, err
+		}
+		project, err := metadataText(req)
+		if err != nil {
+			return nil, fmt.Errorf("no project given and none from the metadata server: %v", err)
+		}
+		d.project = strings.TrimSpace(project)
+	}
+	endpoint := "https://compute.googleapis.com/compute/v1/projects/" + url.PathEscape(d.project)
+	if d.zone != "" {
+		endpoint += "/zones/" + url.PathEscape(d.zone) + "/instances"
+	} else {
+		endpoint += "/aggregated/instances"
+	}
+	query := url.Values{"filter": {`status = "RUNNING"`}}
+
+	var hosts []HostConfig
+	for {
+		token, err := d.token.get()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		var page struct {
+			Items         json.RawMessage `json:"items"`
+			NextPageToken string          `json:"nextPageToken"`
+		}
+		if err := cloudJSON(req, &page); err != nil {
+			return nil, err
+		}
+		// Aggregated lists group instances by zone.
+		var instances []gcpInstance
+		if len(page.Items) > 0 && d.zone != "" {
+			err = json.Unmarshal(page.Items, &instances)
+		} else if len(page.Items) > 0 {
+			var zones map[string]struct {
+				Instances []gcpInstance `json:"instances"`
+			}
+			err = json.Unmarshal(page.Items, &zones)
+			for _, z := range zones {
+				instances = append(instances, z.Instances...)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, in := range instances {
+			if in.Status != "RUNNING" || len(in.NetworkInterfaces) == 0 {
+				continue
+			}
+			nic := in.NetworkInterfaces[0]
+			public := ""
+			if len(nic.AccessConfigs) > 0 {
+				public = nic.AccessConfigs[0].NatIP
+			}
+			if h, ok := d.host(in.Name, nic.NetworkIP, public, in.Labels); ok {
+				hosts = append(hosts, h)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+	slices.SortFunc(hosts, func(a, b HostConfig) int { return strings.Compare(a.DisplayName, b.DisplayName) })
+	return hosts, nil
+}
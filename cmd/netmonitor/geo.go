@@ -0,0 +1,67 @@
+package main
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// GeoLocation is where a GeoIP city database places an address.
+type GeoLocation struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	City    string  `json:"city,omitempty"`
+	Country string  `json:"country,omitempty"` // ISO 3166 code
+}
+
+// lookupGeo reads GeoLite2-City compatible records, returning nil for
+// addresses without coordinates.
+func lookupGeo(db *mmdb, ip net.IP) (*GeoLocation, error) {
+	record, _, err := db.Lookup(ip)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	fields, _ := record.(map[string]any)
+	location, _ := fields["location"].(map[string]any)
+	lat, ok1 := location["latitude"].(float64)
+	lon, ok2 := location["longitude"].(float64)
+	if !ok1 || !ok2 {
+		return nil, nil
+	}
+	loc := &GeoLocation{Lat: lat, Lon: lon}
+	if city, ok := fields["city"].(map[string]any); ok {
+		names, _ := city["names"].(map[string]any)
+		loc.City, _ = names["en"].(string)
+	}
+	if country, ok := fields["country"].(map[string]any); ok {
+		loc.Country, _ = country["iso_code"].(string)
+	}
+	return loc, nil
+}
+
+// GeoHost is a located host on the map.
+type GeoHost struct {
+	Host    string  `json:"host"`
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency"`
+	GeoLocation
+}
+
+// handleGeo serves GET /api/geo with every host that has a location.
+func (m *Monitor) handleGeo(w http.ResponseWriter, r *http.Request) {
+	hosts := []GeoHost{}
+	for _, s := range m.GetStats() {
+		if s.Info == nil || s.Info.Location == nil {
+			continue
+		}
+		hosts = append(hosts, GeoHost{
+			Host:        s.Host,
+			Status:      s.Status,
+			Latency:     s.CurrentLatency,
+			GeoLocation: *s.Info.Location,
+		})
+	}
+	slices.SortFunc(hosts, func(a, b GeoHost) int { return cmp.Compare(a.Host, b.Host) })
+	writeJSON(w, http.StatusOK, hosts)
+}
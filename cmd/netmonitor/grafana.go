@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Grafana JSON datasource (SimpleJSON) endpoints under /grafana/. Add a
+// JSON datasource pointing at http://netmonitor:8080/grafana and query
+// series named "<host> <metric>", where metric is one of grafanaMetrics.
+// Annotations show incidents as regions; the annotation query may name a
+// host or tag to limit them.
+
+// grafanaMetrics are the series available for every host. latency is the
+// mean of the answered probes in each interval, up the fraction of probes
+// that answered and loss the percentage that did not.
+var grafanaMetrics = []string{"latency", "up", "loss"}
+
+// maxGrafanaRange bounds the range of a query, whose table is computed
+// like a report with a bucket per day.
+const maxGrafanaRange = 366 * 24 * time.Hour
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaQuery struct {
+	Range         grafanaRange `json:"range"`
+	IntervalMs    int64        `json:"intervalMs"`
+	MaxDataPoints int          `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Type   string `json:"type"`
+	} `json:"targets"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, unix ms]
+}
+
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type grafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []grafanaColumn `json:"columns"`
+	Rows    [][]any         `json:"rows"`
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	IsRegion   bool            `json:"isRegion"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// handleGrafanaTest answers the datasource connection test.
+func (m *Monitor) handleGrafanaTest(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleGrafanaSearch lists the series names, filtered by the request's
+// target substring.
+func (m *Monitor) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	names := []string{}
+	for _, t := range m.hosts().targets {
+		for _, metric := range grafanaMetrics {
+			name := t.name + " " + metric
+			if strings.Contains(name, req.Target) {
+				names = append(names, name)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (m *Monitor) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafanaQuery
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+	if req.Range.To.IsZero() {
+		req.Range.To = time.Now()
+	}
+	if req.Range.From.IsZero() || !req.Range.From.Before(req.Range.To) {
+		writeError(w, http.StatusBadRequest, "invalid query: range must start before it ends")
+		return
+	}
+	if req.Range.To.Sub(req.Range.From) > maxGrafanaRange {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid query: range is longer than %d days", maxGrafanaRange/(24*time.Hour)))
+		return
+	}
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if req.MaxDataPoints > 0 {
+		// Never return more points than the panel can draw.
+		interval = max(interval, req.Range.To.Sub(req.Range.From)/time.Duration(req.MaxDataPoints))
+	}
+
+	results := []any{}
+	for _, q := range req.Targets {
+		if q.Type == "table" {
+			results = append(results, m.grafanaTable(req.Range))
+			continue
+		}
+		i := strings.LastIndexByte(q.Target, ' ')
+		if i < 0 {
+			continue
+		}
+		host, metric := q.Target[:i], q.Target[i+1:]
+		if !slices.Contains(grafanaMetrics, metric) {
+			continue
+		}
+		samples := m.history.rangeOf(host, req.Range.From, req.Range.To)
+		results = append(results, grafanaSeries{Target: q.Target, Datapoints: grafanaPoints(samples, metric, interval)})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// grafanaPoints aggregates samples into one point per interval. A zero
+// interval returns every sample.
+func grafanaPoints(samples []Sample, metric string, interval time.Duration) [][2]float64 {
+	points := [][2]float64{}
+	for len(samples) > 0 {
+		end := len(samples)
+		if interval > 0 {
+			start := samples[0].Time.Truncate(interval)
+			end = slices.IndexFunc(samples, func(s Sample) bool { return s.Time.Sub(start) >= interval })
+			if end < 0 {
+				end = len(samples)
+			}
+		} else {
+			end = 1
+		}
+
+		bucket := samples[:end]
+		samples = samples[end:]
+		var up int
+		var latency float64
+		for _, s := range bucket {
+			if s.Up {
+				up++
+				latency += s.Latency
+			}
+		}
+
+		var value float64
+		switch metric {
+		case "latency":
+			if up == 0 {
+				continue
+			}
+			value = latency / float64(up)
+		case "up":
+			value = float64(up) / float64(len(bucket))
+		case "loss":
+			value = float64(len(bucket)-up) / float64(len(bucket)) * 100
+		}
+		points = append(points, [2]float64{value, float64(bucket[0].Time.UnixMilli())})
+	}
+	return points
+}
+
+// grafanaTable summarises every host over the range.
+func (m *Monitor) grafanaTable(rng grafanaRange) grafanaTable {
+	table := grafanaTable{
+		Type: "table",
+		Columns: []grafanaColumn{
+			{Text: "Host", Type: "string"},
+			{Text: "Status", Type: "string"},
+			{Text: "Uptime", Type: "number"},
+			{Text: "Avg latency", Type: "number"},
+			{Text: "Tags", Type: "string"},
+		},
+		Rows: [][]any{},
+	}
+	report := m.buildReport("", rng.From, rng.To)
+	for _, h := range report.Hosts {
+		stats, _ := m.statsOf(h.Host)
+		table.Rows = append(table.Rows, []any{h.Host, stats.Status, h.Uptime, h.AvgLatency, strings.Join(h.Tags, ",")})
+	}
+	return table
+}
+
+// handleGrafanaAnnotations returns the incidents in the range as regions
+// from the first alert to the recovery.
+func (m *Monitor) handleGrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Range      grafanaRange    `json:"range"`
+		Annotation json.RawMessage `json:"annotation"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+	var annotation struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(req.Annotation, &annotation)
+	filter := strings.TrimSpace(annotation.Query)
+	if req.Range.To.IsZero() {
+		req.Range.To = time.Now()
+	}
+
+	tags := map[string][]string{}
+	for _, t := range m.hosts().targets {
+		tags[t.name] = t.tags
+	}
+
+	result := []grafanaAnnotation{}
+	for _, inc := range m.incidents.list(false) {
+		if !inc.Opened.Before(req.Range.To) || (!inc.Resolved.IsZero() && inc.Resolved.Before(req.Range.From)) {
+			continue
+		}
+		if filter != "" && inc.Host != filter && !slices.Contains(tags[inc.Host], filter) {
+			continue
+		}
+		a := grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       inc.Opened.UnixMilli(),
+			Title:      inc.Host + " " + inc.Status,
+			Text:       inc.Message,
+			Tags:       append([]string{inc.Host, inc.Severity}, tags[inc.Host]...),
+		}
+		if !inc.Resolved.IsZero() {
+			a.TimeEnd = inc.Resolved.UnixMilli()
+			a.IsRegion = true
+		}
+		result = append(result, a)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,1155 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GraphQL at /api/graphql lets a client ask for hosts, their stats, history
+// and incidents in one request, with only the fields it needs:
+//
+//	{
+//	  hosts(status: ["down"]) {
+//	    host status downSince
+//	    history(from: "6h") { time latency up }
+//	    incidents(open: true) { id opened message }
+//	  }
+//	}
+//
+// It is read-only: queries, not mutations or subscriptions. The types are
+// those of the REST API, with fields named as in its JSON; GET without a
+// query serves the schema. Introspection is not supported.
+
+// gqlType is an object type of the schema.
+type gqlType struct {
+	name   string
+	fields []*gqlField
+}
+
+func (t *gqlType) field(name string) *gqlField {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// gqlField is a field of an object type. resolve returns its value from
+// the value of the object, which is nil for Query. Values of object types
+// are structs, pointers to them or slices of them, and any other value is
+// sent as is.
+type gqlField struct {
+	name    string
+	typ     string // such as [Host!]!
+	args    []gqlArg
+	object  *gqlType // the type of the value or its elements, unless scalar
+	resolve func(m *Monitor, parent any, args map[string]any) (any, error)
+}
+
+type gqlArg struct {
+	name, typ string
+}
+
+// graphqlQuery is the root of the schema.
+var graphqlQuery = newGraphQLSchema()
+
+func newGraphQLSchema() *gqlType {
+	host := gqlObjectType("Host", reflect.TypeFor[PingStats]())
+	incident := gqlObjectType("Incident", reflect.TypeFor[Incident]())
+	sample := gqlObjectType("Sample", reflect.TypeFor[Sample]())
+	self := gqlObjectType("Self", reflect.TypeFor[Self]())
+
+	incidents := func(m *Monitor, host string, args map[string]any) []Incident {
+		list := slices.DeleteFunc(m.incidents.list(args["open"] == true), func(inc Incident) bool {
+			return host != "" && inc.Host != host
+		})
+		if limit, ok := args["limit"].(int); ok {
+			list = list[:min(max(limit, 0), len(list))]
+		}
+		return list
+	}
+	host.fields = append(host.fields,
+		&gqlField{
+			name: "history", typ: "[Sample!]!", object: sample,
+			args: []gqlArg{{"from", "String"}, {"to", "String"}},
+			resolve: func(m *Monitor, parent any, args map[string]any) (any, error) {
+				from, to, err := parseTimeBounds(gqlString(args["from"]), gqlString(args["to"]), time.Hour)
+				if err != nil {
+					return nil, err
+				}
+				return m.history.rangeOf(parent.(PingStats).Host, from, to), nil
+			},
+		},
+		&gqlField{
+			name: "incidents", typ: "[Incident!]!", object: incident,
+			args: []gqlArg{{"open", "Boolean"}, {"limit", "Int"}},
+			resolve: func(m *Monitor, parent any, args map[string]any) (any, error) {
+				return incidents(m, parent.(PingStats).Host, args), nil
+			},
+		},
+	)
+
+	return &gqlType{name: "Query", fields: []*gqlField{
+		{
+			name: "hosts", typ: "[Host!]!", object: host,
+			args: []gqlArg{{"status", "[String!]"}, {"tag", "[String!]"}, {"sort", "String"}, {"offset", "Int"}, {"limit", "Int"}},
+			resolve: func(m *Monitor, _ any, args map[string]any) (any, error) {
+				sq := statsQuery{status: gqlStrings(args["status"]), tags: gqlStrings(args["tag"]), sort: gqlString(args["sort"])}
+				sq.offset, _ = args["offset"].(int)
+				sq.limit, _ = args["limit"].(int)
+				if sq.offset < 0 || sq.limit < 0 {
+					return nil, fmt.Errorf("offset and limit must not be negative")
+				}
+				if err := sortStats(nil, sq.sort); err != nil {
+					return nil, err
+				}
+				stats, _ := sq.filter(m.GetStats())
+				return stats, nil
+			},
+		},
+		{
+			name: "host", typ: "Host", object: host,
+			args: []gqlArg{{"name", "String!"}},
+			resolve: func(m *Monitor, _ any, args map[string]any) (any, error) {
+				for _, s := range m.GetStats() {
+					if s.Host == args["name"] {
+						return s, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		{
+			name: "incidents", typ: "[Incident!]!", object: incident,
+			args: []gqlArg{{"open", "Boolean"}, {"host", "String"}, {"limit", "Int"}},
+			resolve: func(m *Monitor, _ any, args map[string]any) (any, error) {
+				return incidents(m, gqlString(args["host"]), args), nil
+			},
+		},
+		{
+			name: "self", typ: "Self!", object: self,
+			resolve: func(m *Monitor, _ any, _ map[string]any) (any, error) {
+				return m.self(), nil
+			},
+		},
+	}}
+}
+
+// gqlObjectType describes a struct as an object type, with its fields named
+// as in JSON unless a graphql tag names them otherwise.
+func gqlObjectType(name string, t reflect.Type) *gqlType {
+	gt := &gqlType{name: name}
+	gqlAddFields(gt, t, nil)
+	return gt
+}
+
+func gqlAddFields(gt *gqlType, t reflect.Type, index []int) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		fieldIndex := append(slices.Clip(index), i)
+		if f.Anonymous {
+			gqlAddFields(gt, f.Type, fieldIndex)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		name = cmp.Or(f.Tag.Get("graphql"), name)
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		typ, object := gqlTypeOf(f.Type)
+		gt.fields = append(gt.fields, &gqlField{name: name, typ: typ, object: object,
+			resolve: func(_ *Monitor, parent any, _ map[string]any) (any, error) {
+				v := reflect.ValueOf(parent).FieldByIndex(fieldIndex).Interface()
+				if t, ok := v.(time.Time); ok && t.IsZero() {
+					return nil, nil
+				}
+				return v, nil
+			},
+		})
+	}
+}
+
+// gqlTypeOf returns the GraphQL type of a Go type, and the object type of
+// structs. Times are RFC 3339 strings, null when unset, and maps are JSON.
+func gqlTypeOf(t reflect.Type) (string, *gqlType) {
+	if t == reflect.TypeFor[time.Time]() {
+		return "String", nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		typ, object := gqlTypeOf(t.Elem())
+		return strings.TrimSuffix(typ, "!"), object
+	case reflect.Slice:
+		typ, object := gqlTypeOf(t.Elem())
+		return "[" + typ + "]", object
+	case reflect.Map:
+		return "JSON", nil
+	case reflect.Struct:
+		return t.Name() + "!", gqlObjectType(t.Name(), t)
+	case reflect.String:
+		return "String!", nil
+	case reflect.Bool:
+		return "Boolean!", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return "Int!", nil
+	default:
+		// Float is also what GraphQL has for integers past 32 bits.
+		return "Float!", nil
+	}
+}
+
+// graphqlSchema describes the schema in the GraphQL schema language.
+func graphqlSchema() string {
+	var b strings.Builder
+	seen := map[string]bool{}
+	var write func(t *gqlType)
+	write = func(t *gqlType) {
+		if seen[t.name] {
+			return
+		}
+		seen[t.name] = true
+		fmt.Fprintf(&b, "type %s {\n", t.name)
+		for _, f := range t.fields {
+			var args []string
+			for _, a := range f.args {
+				args = append(args, a.name+": "+a.typ)
+			}
+			if len(args) > 0 {
+				fmt.Fprintf(&b, "  %s(%s): %s\n", f.name, strings.Join(args, ", "), f.typ)
+			} else {
+				fmt.Fprintf(&b, "  %s: %s\n", f.name, f.typ)
+			}
+		}
+		b.WriteString("}\n\n")
+		for _, f := range t.fields {
+			if f.object != nil {
+				write(f.object)
+			}
+		}
+	}
+	write(graphqlQuery)
+	b.WriteString("\"Any JSON value, such as the metrics of a host by name.\"\nscalar JSON\n")
+	return b.String()
+}
+
+type gqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type gqlResponse struct {
+	Data   any        `json:"data,omitempty"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+type gqlError struct {
+	Message   string        `json:"message"`
+	Locations []gqlLocation `json:"locations,omitempty"`
+	Path      []any         `json:"path,omitempty"`
+}
+
+type gqlLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// handleGraphQL serves /api/graphql: a query as the query parameter of a
+// GET, or in a POST as JSON or application/graphql.
+func (m *Monitor) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, graphqlSchema())
+			return
+		}
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid variables: "+err.Error())
+				return
+			}
+		}
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, 1<<20)
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/graphql" {
+			b, err := io.ReadAll(body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.Query = string(b)
+		} else if err := json.NewDecoder(body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	resp := m.graphql(req)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, resp)
+}
+
+// graphql runs a query. The response has no data if the query could not be
+// run at all, and data with errors for fields that failed.
+func (m *Monitor) graphql(req gqlRequest) gqlResponse {
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{gqlErrorOf(err, req.Query)}}
+	}
+	e := &gqlExecution{m: m, fragments: doc.fragments}
+	op, err := doc.operation(req.OperationName)
+	if err == nil {
+		err = e.setVariables(op, req.Variables)
+	}
+	if err == nil {
+		err = e.validate(graphqlQuery, op.sel, nil)
+	}
+	if err != nil {
+		return gqlResponse{Errors: []gqlError{gqlErrorOf(err, req.Query)}}
+	}
+	data := e.object(graphqlQuery, nil, op.sel, nil)
+	return gqlResponse{Data: data, Errors: e.errors}
+}
+
+// gqlSyntaxError is an error at a position of the query.
+type gqlSyntaxError struct {
+	pos int
+	msg string
+}
+
+func (e *gqlSyntaxError) Error() string { return e.msg }
+
+func gqlErrorf(pos int, format string, args ...any) error {
+	return &gqlSyntaxError{pos, fmt.Sprintf(format, args...)}
+}
+
+func gqlErrorOf(err error, src string) gqlError {
+	ge := gqlError{Message: err.Error()}
+	if se, ok := err.(*gqlSyntaxError); ok {
+		before := src[:min(se.pos, len(src))]
+		line := strings.Count(before, "\n") + 1
+		column := len(before) - strings.LastIndexByte(before, '\n')
+		ge.Locations = []gqlLocation{{line, column}}
+	}
+	return ge
+}
+
+// gqlDocument is a parsed query: its operations and fragments.
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name string
+	vars []gqlVariable
+	sel  []*gqlSelection
+}
+
+type gqlVariable struct {
+	name, typ  string
+	def        any
+	hasDefault bool
+}
+
+type gqlFragment struct {
+	on  string
+	sel []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread or an inline fragment.
+type gqlSelection struct {
+	alias, name string // of a field
+	args        map[string]any
+	directives  []gqlDirective
+	sel         []*gqlSelection // of a field or an inline fragment
+	spread      string          // the fragment of a fragment spread
+	inline      bool            // an inline fragment, on the type on if set
+	on          string
+	pos         int
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]any
+	pos  int
+}
+
+// gqlVar is a reference to a variable in a value of the query.
+type gqlVar string
+
+func (d *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(d.operations) != 1 {
+			return nil, fmt.Errorf("operationName is required with several operations")
+		}
+		return d.operations[0], nil
+	}
+	for _, op := range d.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// gqlParser reads a query a token at a time. Token kinds are the
+// punctuators ('.' for ...), 'n' for names, 'i' for integers, 'f' for
+// floats, 's' for strings, and 0 at the end or after an error, which
+// stays in err.
+type gqlParser struct {
+	src   string
+	pos   int // of what follows the token
+	kind  byte
+	val   string
+	start int // of the token
+	err   error
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{src: src}
+	p.next()
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.kind != 0 {
+		switch {
+		case p.kind == '{' || p.kind == 'n' && p.val == "query":
+			doc.operations = append(doc.operations, p.operation())
+		case p.kind == 'n' && p.val == "fragment":
+			pos := p.start
+			p.next()
+			name := p.name()
+			if name == "on" {
+				p.fail("unexpected name on")
+			}
+			if doc.fragments[name] != nil {
+				p.err = cmp.Or(p.err, gqlErrorf(pos, "there can be only one fragment named %q", name))
+			}
+			doc.fragments[name] = p.fragment()
+		case p.kind == 'n' && (p.val == "mutation" || p.val == "subscription"):
+			p.fail("only queries are supported")
+		default:
+			p.unexpected()
+		}
+	}
+	if p.err == nil && len(doc.operations) == 0 {
+		return nil, fmt.Errorf("no query")
+	}
+	return doc, p.err
+}
+
+// next moves to the next token.
+func (p *gqlParser) next() {
+	if p.err != nil {
+		p.kind = 0
+		return
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos++
+			}
+		} else if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+		} else if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+		} else {
+			break
+		}
+	}
+	p.start = p.pos
+	if p.pos == len(p.src) {
+		p.kind = 0
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		p.kind, p.val = c, string(c)
+		p.pos++
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.kind, p.val = '.', "..."
+		p.pos += 3
+	case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		end := p.pos + 1
+		for end < len(p.src) && gqlNameChar(p.src[end]) {
+			end++
+		}
+		p.kind, p.val, p.pos = 'n', p.src[p.pos:end], end
+	case c == '-' || '0' <= c && c <= '9':
+		p.number()
+	case c == '"':
+		p.string()
+	default:
+		p.fail("unexpected character %q", c)
+	}
+}
+
+func gqlNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+func (p *gqlParser) number() {
+	end := p.pos
+	digits := func() int {
+		n := 0
+		for end < len(p.src) && '0' <= p.src[end] && p.src[end] <= '9' {
+			end++
+			n++
+		}
+		return n
+	}
+	if p.src[end] == '-' {
+		end++
+	}
+	ok := digits() > 0
+	p.kind = 'i'
+	if end < len(p.src) && p.src[end] == '.' {
+		end++
+		ok = ok && digits() > 0
+		p.kind = 'f'
+	}
+	if end < len(p.src) && (p.src[end] == 'e' || p.src[end] == 'E') {
+		end++
+		if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+			end++
+		}
+		ok = ok && digits() > 0
+		p.kind = 'f'
+	}
+	if !ok || end < len(p.src) && (gqlNameChar(p.src[end]) || p.src[end] == '.') {
+		p.fail("invalid number")
+		return
+	}
+	p.val, p.pos = p.src[p.pos:end], end
+}
+
+// string reads a string, whose escapes are those of JSON. Block strings are
+// not supported.
+func (p *gqlParser) string() {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		p.fail("block strings are not supported")
+		return
+	}
+	for end := p.pos + 1; end < len(p.src); end++ {
+		switch p.src[end] {
+		case '\\':
+			end++
+		case '\n', '\r':
+			p.fail("unterminated string")
+			return
+		case '"':
+			if err := json.Unmarshal([]byte(p.src[p.pos:end+1]), &p.val); err != nil {
+				p.fail("invalid string")
+				return
+			}
+			p.kind, p.pos = 's', end+1
+			return
+		}
+	}
+	p.fail("unterminated string")
+}
+
+func (p *gqlParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = gqlErrorf(p.start, format, args...)
+	}
+	p.kind = 0
+}
+
+func (p *gqlParser) unexpected() {
+	if p.kind == 0 {
+		p.fail("unexpected end of query")
+	} else {
+		p.fail("unexpected %s", p.val)
+	}
+}
+
+// expect moves past the punctuator c.
+func (p *gqlParser) expect(c byte) {
+	if p.kind != c {
+		p.unexpected()
+		return
+	}
+	p.next()
+}
+
+func (p *gqlParser) name() string {
+	if p.kind != 'n' {
+		p.unexpected()
+		return ""
+	}
+	name := p.val
+	p.next()
+	return name
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	op := &gqlOperation{}
+	if p.kind == 'n' {
+		p.next()
+		if p.kind == 'n' {
+			op.name = p.name()
+		}
+		if p.kind == '(' {
+			p.next()
+			for p.kind != ')' && p.kind != 0 {
+				var v gqlVariable
+				p.expect('$')
+				v.name = p.name()
+				p.expect(':')
+				v.typ = p.typeRef()
+				if p.kind == '=' {
+					p.next()
+					v.def, v.hasDefault = p.value(true), true
+				}
+				p.directives()
+				op.vars = append(op.vars, v)
+			}
+			p.expect(')')
+		}
+		if len(p.directives()) > 0 {
+			p.fail("directives on operations are not supported")
+		}
+	}
+	op.sel = p.selectionSet()
+	return op
+}
+
+func (p *gqlParser) fragment() *gqlFragment {
+	f := &gqlFragment{}
+	if p.kind != 'n' || p.val != "on" {
+		p.unexpected()
+		return f
+	}
+	p.next()
+	f.on = p.name()
+	if len(p.directives()) > 0 {
+		p.fail("directives on fragment definitions are not supported")
+	}
+	f.sel = p.selectionSet()
+	return f
+}
+
+// typeRef reads a type such as [String!]!.
+func (p *gqlParser) typeRef() string {
+	var typ string
+	if p.kind == '[' {
+		p.next()
+		typ = "[" + p.typeRef() + "]"
+		p.expect(']')
+	} else {
+		typ = p.name()
+	}
+	if p.kind == '!' {
+		p.next()
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	var sel []*gqlSelection
+	p.expect('{')
+	for p.kind != '}' && p.kind != 0 {
+		sel = append(sel, p.selection())
+	}
+	if p.err == nil && len(sel) == 0 {
+		p.fail("empty selection")
+	}
+	p.expect('}')
+	return sel
+}
+
+func (p *gqlParser) selection() *gqlSelection {
+	s := &gqlSelection{pos: p.start}
+	if p.kind == '.' {
+		p.next()
+		if p.kind == 'n' && p.val != "on" {
+			s.spread = p.name()
+			s.directives = p.directives()
+			return s
+		}
+		s.inline = true
+		if p.kind == 'n' {
+			p.next()
+			s.on = p.name()
+		}
+		s.directives = p.directives()
+		s.sel = p.selectionSet()
+		return s
+	}
+	s.name = p.name()
+	if p.kind == ':' {
+		p.next()
+		s.alias, s.name = s.name, p.name()
+	}
+	s.args = p.arguments()
+	s.directives = p.directives()
+	if p.kind == '{' {
+		s.sel = p.selectionSet()
+	}
+	return s
+}
+
+func (p *gqlParser) arguments() map[string]any {
+	if p.kind != '(' {
+		return nil
+	}
+	args := map[string]any{}
+	p.next()
+	for p.kind != ')' && p.kind != 0 {
+		pos := p.start
+		name := p.name()
+		if _, ok := args[name]; ok {
+			p.err = cmp.Or(p.err, gqlErrorf(pos, "there can be only one argument named %q", name))
+		}
+		p.expect(':')
+		args[name] = p.value(false)
+	}
+	p.expect(')')
+	return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var dirs []gqlDirective
+	for p.kind == '@' {
+		pos := p.start
+		p.next()
+		dirs = append(dirs, gqlDirective{name: p.name(), args: p.arguments(), pos: pos})
+	}
+	return dirs
+}
+
+// value reads a value: a variable unless constant, a scalar, an enum value,
+// which is read as a string, a list or an object.
+func (p *gqlParser) value(constant bool) any {
+	kind, val := p.kind, p.val
+	switch kind {
+	case '$':
+		if constant {
+			p.fail("unexpected variable")
+			return nil
+		}
+		p.next()
+		return gqlVar(p.name())
+	case 'i':
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			p.fail("invalid integer %s", val)
+		}
+		p.next()
+		return n
+	case 'f':
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			p.fail("invalid number %s", val)
+		}
+		p.next()
+		return f
+	case 's':
+		p.next()
+		return val
+	case 'n':
+		p.next()
+		switch val {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return val
+	case '[':
+		list := []any{}
+		p.next()
+		for p.kind != ']' && p.kind != 0 {
+			list = append(list, p.value(constant))
+		}
+		p.expect(']')
+		return list
+	case '{':
+		object := map[string]any{}
+		p.next()
+		for p.kind != '}' && p.kind != 0 {
+			name := p.name()
+			p.expect(':')
+			object[name] = p.value(constant)
+		}
+		p.expect('}')
+		return object
+	}
+	p.unexpected()
+	return nil
+}
+
+// gqlExecution is the state of a query being run.
+type gqlExecution struct {
+	m         *Monitor
+	fragments map[string]*gqlFragment
+	vars      map[string]any // of the operation, defined ones included even if null
+	errors    []gqlError
+}
+
+// setVariables checks the values of the variables of op against their
+// types, with their defaults for those not given.
+func (e *gqlExecution) setVariables(op *gqlOperation, values map[string]any) error {
+	e.vars = map[string]any{}
+	for _, v := range op.vars {
+		value, ok := values[v.name]
+		if !ok {
+			value = v.def
+		}
+		coerced, err := gqlCoerce(v.typ, value)
+		if err != nil {
+			return fmt.Errorf("variable $%s: %v", v.name, err)
+		}
+		e.vars[v.name] = coerced
+	}
+	return nil
+}
+
+// validate checks a selection on type t before anything runs, so a
+// mistake is reported as such even where there is no data to run into it.
+func (e *gqlExecution) validate(t *gqlType, sel []*gqlSelection, fragments []string) error {
+	for _, s := range sel {
+		for _, d := range s.directives {
+			if d.name != "skip" && d.name != "include" {
+				return gqlErrorf(d.pos, "unknown directive @%s", d.name)
+			}
+			if err := e.checkArgs([]gqlArg{{"if", "Boolean!"}}, d.args, d.pos, "directive @"+d.name); err != nil {
+				return err
+			}
+		}
+		switch {
+		case s.spread != "":
+			f := e.fragments[s.spread]
+			switch {
+			case f == nil:
+				return gqlErrorf(s.pos, "unknown fragment %q", s.spread)
+			case slices.Contains(fragments, s.spread):
+				return gqlErrorf(s.pos, "fragment %q spreads itself", s.spread)
+			case f.on != t.name:
+				return gqlErrorf(s.pos, "fragment %q on %s cannot be spread on %s", s.spread, f.on, t.name)
+			}
+			if err := e.validate(t, f.sel, append(fragments, s.spread)); err != nil {
+				return err
+			}
+		case s.inline:
+			if s.on != "" && s.on != t.name {
+				return gqlErrorf(s.pos, "fragment on %s cannot be spread on %s", s.on, t.name)
+			}
+			if err := e.validate(t, s.sel, fragments); err != nil {
+				return err
+			}
+		case s.name == "__typename":
+			if s.sel != nil || s.args != nil {
+				return gqlErrorf(s.pos, "__typename takes no arguments or selection")
+			}
+		default:
+			f := t.field(s.name)
+			switch {
+			case f == nil:
+				return gqlErrorf(s.pos, "cannot query field %q on type %s", s.name, t.name)
+			case f.object == nil && s.sel != nil:
+				return gqlErrorf(s.pos, "field %q of type %s has no fields to select", s.name, f.typ)
+			case f.object != nil && s.sel == nil:
+				return gqlErrorf(s.pos, "field %q of type %s needs a selection of its fields", s.name, f.typ)
+			}
+			if err := e.checkArgs(f.args, s.args, s.pos, "field "+t.name+"."+f.name); err != nil {
+				return err
+			}
+			if f.object != nil {
+				if err := e.validate(f.object, s.sel, fragments); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkArgs checks the arguments given to a field or directive against
+// those it takes.
+func (e *gqlExecution) checkArgs(declared []gqlArg, args map[string]any, pos int, of string) error {
+	for name, v := range args {
+		i := slices.IndexFunc(declared, func(a gqlArg) bool { return a.name == name })
+		if i < 0 {
+			return gqlErrorf(pos, "unknown argument %q of %s", name, of)
+		}
+		if err := e.checkVars(v); err != nil {
+			return gqlErrorf(pos, "%v", err)
+		}
+		if _, err := gqlCoerce(declared[i].typ, e.resolve(v)); err != nil {
+			return gqlErrorf(pos, "argument %q of %s: %v", name, of, err)
+		}
+	}
+	for _, a := range declared {
+		if _, ok := args[a.name]; !ok && strings.HasSuffix(a.typ, "!") {
+			return gqlErrorf(pos, "argument %q of %s is required", a.name, of)
+		}
+	}
+	return nil
+}
+
+// checkVars checks that the variables a value uses are defined.
+func (e *gqlExecution) checkVars(v any) error {
+	switch v := v.(type) {
+	case gqlVar:
+		if _, ok := e.vars[string(v)]; !ok {
+			return fmt.Errorf("variable $%s is not defined", v)
+		}
+	case []any:
+		for _, elem := range v {
+			if err := e.checkVars(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, elem := range v {
+			if err := e.checkVars(elem); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve replaces the variables in a value with their values.
+func (e *gqlExecution) resolve(v any) any {
+	switch v := v.(type) {
+	case gqlVar:
+		return e.vars[string(v)]
+	case []any:
+		list := make([]any, len(v))
+		for i, elem := range v {
+			list[i] = e.resolve(elem)
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for k, elem := range v {
+			object[k] = e.resolve(elem)
+		}
+		return object
+	}
+	return v
+}
+
+// gqlCoerce checks a value against a type, such as [String!], and turns it
+// into the Go value resolvers get: a string, int, float64, bool or []any of
+// them. A single value is a list of one where a list is expected.
+func gqlCoerce(typ string, v any) (any, error) {
+	if v == nil {
+		if strings.HasSuffix(typ, "!") {
+			return nil, fmt.Errorf("expected %s, not null", typ)
+		}
+		return nil, nil
+	}
+	base := strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(base, "[") {
+		elem := base[1 : len(base)-1]
+		values, ok := v.([]any)
+		if !ok {
+			values = []any{v}
+		}
+		list := make([]any, len(values))
+		for i, value := range values {
+			var err error
+			if list[i], err = gqlCoerce(elem, value); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	switch v := v.(type) {
+	case string:
+		if base == "String" {
+			return v, nil
+		}
+	case bool:
+		if base == "Boolean" {
+			return v, nil
+		}
+	case int:
+		return gqlCoerce(typ, int64(v))
+	case int64:
+		switch {
+		case base == "Float":
+			return float64(v), nil
+		case base == "Int" && v >= math.MinInt32 && v <= math.MaxInt32:
+			return int(v), nil
+		}
+	case float64:
+		// Numbers of JSON variables are floats.
+		switch {
+		case base == "Float":
+			return v, nil
+		case base == "Int" && v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32:
+			return int(v), nil
+		}
+	}
+	if !slices.Contains([]string{"String", "Boolean", "Int", "Float"}, base) {
+		return nil, fmt.Errorf("unknown type %s", base)
+	}
+	return nil, fmt.Errorf("expected %s, got %v", typ, v)
+}
+
+func gqlString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+func gqlStrings(v any) []string {
+	var list []string
+	values, _ := v.([]any)
+	for _, elem := range values {
+		list = append(list, gqlString(elem))
+	}
+	return list
+}
+
+// gqlCollected is a field of the response: all the selections for the same
+// key, which are merged.
+type gqlCollected struct {
+	key string
+	*gqlSelection
+	sel []*gqlSelection
+}
+
+// collect flattens the fragments of a selection on type t and merges the
+// fields selected under the same key, skipping those directives exclude.
+func (e *gqlExecution) collect(t *gqlType, sel []*gqlSelection, fields []*gqlCollected) []*gqlCollected {
+	for _, s := range sel {
+		if !e.included(s.directives) {
+			continue
+		}
+		switch {
+		case s.spread != "":
+			fields = e.collect(t, e.fragments[s.spread].sel, fields)
+		case s.inline:
+			fields = e.collect(t, s.sel, fields)
+		default:
+			key := cmp.Or(s.alias, s.name)
+			i := slices.IndexFunc(fields, func(f *gqlCollected) bool { return f.key == key })
+			if i < 0 {
+				i = len(fields)
+				fields = append(fields, &gqlCollected{key: key, gqlSelection: s})
+			}
+			fields[i].sel = append(fields[i].sel, s.sel...)
+		}
+	}
+	return fields
+}
+
+func (e *gqlExecution) included(dirs []gqlDirective) bool {
+	for _, d := range dirs {
+		cond, _ := gqlCoerce("Boolean!", e.resolve(d.args["if"]))
+		if (d.name == "skip") == (cond == true) {
+			return false
+		}
+	}
+	return true
+}
+
+// gqlObject is an object of the response, whose fields are in the order
+// they were selected in.
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value any
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, entry := range o {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(append(b, key...), ':'), value...)
+	}
+	return append(b, '}'), nil
+}
+
+// object runs a selection on a value of type t. A field that fails is null,
+// with an error at its path.
+func (e *gqlExecution) object(t *gqlType, parent any, sel []*gqlSelection, path []any) gqlObject {
+	object := gqlObject{}
+	for _, f := range e.collect(t, sel, nil) {
+		if f.name == "__typename" {
+			object = append(object, gqlEntry{f.key, t.name})
+			continue
+		}
+		fieldPath := append(slices.Clip(path), f.key)
+		field := t.field(f.name)
+		// The arguments were checked by validate.
+		args := map[string]any{}
+		for name, v := range f.args {
+			i := slices.IndexFunc(field.args, func(a gqlArg) bool { return a.name == name })
+			args[name], _ = gqlCoerce(field.args[i].typ, e.resolve(v))
+		}
+		v, err := field.resolve(e.m, parent, args)
+		if err != nil {
+			e.errors = append(e.errors, gqlError{Message: err.Error(), Path: fieldPath})
+			object = append(object, gqlEntry{f.key, nil})
+			continue
+		}
+		if field.object != nil {
+			v = e.value(field.object, v, f.sel, fieldPath)
+		}
+		object = append(object, gqlEntry{f.key, v})
+	}
+	return object
+}
+
+// value runs a selection on a value of an object type, or on each of a
+// list of them.
+func (e *gqlExecution) value(t *gqlType, v any, sel []*gqlSelection, path []any) any {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Slice:
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = e.value(t, rv.Index(i).Interface(), sel, append(slices.Clip(path), i))
+		}
+		return list
+	}
+	return e.object(t, rv.Interface(), sel, path)
+}
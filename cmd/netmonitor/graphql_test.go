@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   string
+		line  int
+		col   int
+	}{
+		{"shorthand", "{ hosts { host } }", "", 0, 0},
+		{"named with variables", `query Down($s: [String!] = ["down"], $n: Int!) { hosts(status: $s, limit: $n) { host } }`, "", 0, 0},
+		{"fragments", "query { ...F }\nfragment F on Query { self { hosts } }", "", 0, 0},
+		{"comments and commas", "# hosts\n{ hosts(sort: \"-latency\",,) { host, status } }\r\n", "", 0, 0},
+		{"numbers and strings", `{ a(i: -12, f: 1.5e3, s: "\u00e9\n", l: [1 2], o: {k: true, n: null}, e: DOWN) }`, "", 0, 0},
+		{"empty", "  ", "no query", 0, 0},
+		{"mutation", "mutation { pause }", "only queries are supported", 1, 1},
+		{"unterminated", "{ hosts {\n host", "unexpected end of query", 2, 6},
+		{"empty selection", "{ hosts { } }", "empty selection", 1, 11},
+		{"bad character", "{ hosts; }", `unexpected character ';'`, 1, 8},
+		{"bad number", "{ hosts(limit: 1x) { host } }", "invalid number", 1, 16},
+		{"huge integer", "{ hosts(limit: 99999999999999999999) { host } }", "invalid integer", 1, 16},
+		{"block string", `{ a(s: """x""") }`, "block strings are not supported", 1, 8},
+		{"unterminated string", "{ a(s: \"x\n\") }", "unterminated string", 1, 8},
+		{"bad escape", `{ a(s: "\x") }`, "invalid string", 1, 8},
+		{"argument twice", "{ hosts(limit: 1, limit: 2) { host } }", `only one argument named "limit"`, 1, 19},
+		{"fragment twice", "{ ...F } fragment F on Query { self { hosts } } fragment F on Query { self { goroutines } }", `only one fragment named "F"`, 1, 49},
+		{"fragment named on", "fragment on on Query { self { hosts } }", "unexpected name on", 1, 13},
+		{"variable in default", "query ($a: Int = $b) { hosts { host } }", "unexpected variable", 1, 18},
+		{"operation directive", "query @skip(if: true) { hosts { host } }", "directives on operations are not supported", 1, 23},
+	}
+	for _, tt := range tests {
+		doc, err := parseGraphQL(tt.query)
+		if tt.err == "" {
+			if err != nil || len(doc.operations) != 1 {
+				t.Errorf("%s: %+v, %v", tt.name, doc, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			continue
+		}
+		var want []gqlLocation
+		if tt.line > 0 {
+			want = []gqlLocation{{tt.line, tt.col}}
+		}
+		if ge := gqlErrorOf(err, tt.query); !reflect.DeepEqual(ge.Locations, want) {
+			t.Errorf("%s: at %v, want %v", tt.name, ge.Locations, want)
+		}
+	}
+
+	doc, err := parseGraphQL(`query Q($s: [String!] = ["down"]) { down: hosts(status: $s) @include(if: true) { host ... on Host { status } } }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := doc.operations[0]
+	if op.name != "Q" || len(op.vars) != 1 || op.vars[0].typ != "[String!]" || !reflect.DeepEqual(op.vars[0].def, []any{"down"}) {
+		t.Errorf("operation %+v", op)
+	}
+	s := op.sel[0]
+	if s.alias != "down" || s.name != "hosts" || s.args["status"] != gqlVar("s") || len(s.directives) != 1 || len(s.sel) != 2 || !s.sel[1].inline || s.sel[1].on != "Host" {
+		t.Errorf("selection %+v", s)
+	}
+}
+
+func TestGQLCoerce(t *testing.T) {
+	tests := []struct {
+		typ  string
+		v    any
+		want any
+		err  string
+	}{
+		{"String", "x", "x", ""},
+		{"String", nil, nil, ""},
+		{"String!", nil, nil, "expected String!, not null"},
+		{"Int", int64(3), 3, ""},
+		{"Int", 3.0, 3, ""},
+		{"Int", 3.5, nil, "expected Int, got 3.5"},
+		{"Int", int64(1 << 40), nil, "expected Int"},
+		{"Float", int64(3), 3.0, ""},
+		{"Float!", 2.5, 2.5, ""},
+		{"Boolean", true, true, ""},
+		{"Boolean", "true", nil, "expected Boolean, got true"},
+		{"[String!]", "down", []any{"down"}, ""},
+		{"[String!]", []any{"down", "up"}, []any{"down", "up"}, ""},
+		{"[String!]", []any{"down", nil}, nil, "expected String!, not null"},
+		{"[Int]!", []any{int64(1), nil}, []any{1, nil}, ""},
+		{"Status", "down", nil, "unknown type Status"},
+	}
+	for _, tt := range tests {
+		got, err := gqlCoerce(tt.typ, tt.v)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s %v: error %v, want %q", tt.typ, tt.v, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v: %#v, %v; want %#v", tt.typ, tt.v, got, err, tt.want)
+		}
+	}
+}
+
+func newGraphQLMonitor(t *testing.T) *Monitor {
+	t.Helper()
+	var targets []*target
+	for _, raw := range []string{"192.0.2.1", "192.0.2.2"} {
+		tgt, err := parseTarget(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, tgt)
+	}
+	m := NewMonitor(targets, 0, time.Second)
+	m.config = &Config{}
+	return m
+}
+
+func TestGraphQL(t *testing.T) {
+	m := newGraphQLMonitor(t)
+
+	tests := []struct {
+		name string
+		req  gqlRequest
+		want string
+	}{
+		{"hosts", gqlRequest{Query: "{ hosts { host } }"},
+			`{"data":{"hosts":[{"host":"192.0.2.1"},{"host":"192.0.2.2"}]}}`},
+		{"aliases in order", gqlRequest{Query: "{ b: host(name: \"192.0.2.2\") { name: host __typename } a: hosts(limit: 1) { host } }"},
+			`{"data":{"b":{"name":"192.0.2.2","__typename":"Host"},"a":[{"host":"192.0.2.1"}]}}`},
+		{"unknown host", gqlRequest{Query: `{ host(name: "192.0.2.9") { host } }`},
+			`{"data":{"host":null}}`},
+		{"variables", gqlRequest{Query: "query ($n: Int = 5, $o: Int) { hosts(offset: $o, limit: $n) { host } }", Variables: map[string]any{"o": 1.0}},
+			`{"data":{"hosts":[{"host":"192.0.2.2"}]}}`},
+		{"fragments merged", gqlRequest{Query: "{ hosts(limit: 1) { ...A ... on Host { host } ... @skip(if: true) { status } } } fragment A on Host { host paused }"},
+			`{"data":{"hosts":[{"host":"192.0.2.1","paused":false}]}}`},
+		{"include", gqlRequest{Query: "query ($all: Boolean!) { hosts(limit: 1) { host paused @include(if: $all) } }", Variables: map[string]any{"all": false}},
+			`{"data":{"hosts":[{"host":"192.0.2.1"}]}}`},
+		{"nested", gqlRequest{Query: `{ host(name: "192.0.2.1") { history(from: "1h") { time } incidents(open: true) { id } } }`},
+			`{"data":{"host":{"history":[],"incidents":[]}}}`},
+		{"named operation", gqlRequest{Query: "query A { self { hosts } } query B { hosts(limit: 1) { host } }", OperationName: "B"},
+			`{"data":{"hosts":[{"host":"192.0.2.1"}]}}`},
+		{"field error", gqlRequest{Query: `{ hosts(sort: "uptime") { host } self { hosts } }`},
+			`{"data":{"hosts":null,"self":{"hosts":2}},"errors":[{"message":"unknown sort order \"uptime\"","path":["hosts"]}]}`},
+		{"nested field error", gqlRequest{Query: `{ hosts(limit: 1) { history(from: "soon") { time } } }`},
+			`{"data":{"hosts":[{"history":null}]},"errors":[{"message":"invalid from: \"soon\"","path":["hosts",0,"history"]}]}`},
+		{"negative limit", gqlRequest{Query: "{ hosts(limit: -1) { host } }"},
+			`{"data":{"hosts":null},"errors":[{"message":"offset and limit must not be negative","path":["hosts"]}]}`},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(m.graphql(tt.req))
+		if err != nil || string(b) != tt.want {
+			t.Errorf("%s: %s, %v\nwant %s", tt.name, b, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  gqlRequest
+		err  string
+	}{
+		{"syntax", gqlRequest{Query: "{ hosts"}, "unexpected end of query"},
+		{"unknown field", gqlRequest{Query: "{ hosts { password } }"}, `cannot query field "password" on type Host`},
+		{"scalar selection", gqlRequest{Query: "{ hosts { host { x } } }"}, `field "host" of type String! has no fields to select`},
+		{"object without selection", gqlRequest{Query: "{ hosts }"}, `field "hosts" of type [Host!]! needs a selection of its fields`},
+		{"unknown argument", gqlRequest{Query: "{ hosts(page: 2) { host } }"}, `unknown argument "page" of field Query.hosts`},
+		{"wrong argument type", gqlRequest{Query: `{ hosts(limit: "ten") { host } }`}, `argument "limit" of field Query.hosts: expected Int, got ten`},
+		{"missing argument", gqlRequest{Query: "{ host { host } }"}, `argument "name" of field Query.host is required`},
+		{"undefined variable", gqlRequest{Query: "{ hosts(limit: $n) { host } }"}, "variable $n is not defined"},
+		{"wrong variable", gqlRequest{Query: "query ($n: Int!) { hosts(limit: $n) { host } }"}, "variable $n: expected Int!, not null"},
+		{"unknown directive", gqlRequest{Query: "{ hosts @cached { host } }"}, "unknown directive @cached"},
+		{"directive without if", gqlRequest{Query: "{ hosts @skip { host } }"}, `argument "if" of directive @skip is required`},
+		{"unknown fragment", gqlRequest{Query: "{ ...F }"}, `unknown fragment "F"`},
+		{"fragment cycle", gqlRequest{Query: "{ hosts { ...F } } fragment F on Host { ...F }"}, `fragment "F" spreads itself`},
+		{"fragment on other type", gqlRequest{Query: "{ hosts { ...F } } fragment F on Query { self { hosts } }"}, `fragment "F" on Query cannot be spread on Host`},
+		{"inline fragment on other type", gqlRequest{Query: "{ ... on Host { host } }"}, "fragment on Host cannot be spread on Query"},
+		{"typename with arguments", gqlRequest{Query: "{ __typename(x: 1) }"}, "__typename takes no arguments or selection"},
+		{"several operations", gqlRequest{Query: "query A { self { hosts } } query B { self { goroutines } }"}, "operationName is required"},
+		{"unknown operation", gqlRequest{Query: "query A { self { hosts } }", OperationName: "B"}, `unknown operation "B"`},
+	} {
+		resp := m.graphql(tt.req)
+		if resp.Data != nil || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0].Message, tt.err) {
+			t.Errorf("%s: %+v, want error %q", tt.name, resp, tt.err)
+		}
+	}
+}
+
+func TestHandleGraphQL(t *testing.T) {
+	m := newGraphQLMonitor(t)
+	const query = "{ hosts(limit: 1) { host } }"
+	const want = `{"data":{"hosts":[{"host":"192.0.2.1"}]}}`
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		code        int
+		want        string
+	}{
+		{"get", "GET", "/api/graphql?query=" + url.QueryEscape(query), "", "", http.StatusOK, want},
+		{"get with variables", "GET", "/api/graphql?query=" + url.QueryEscape("query ($n: Int) { hosts(limit: $n) { host } }") + "&variables=" + url.QueryEscape(`{"n":1}`), "", "", http.StatusOK, want},
+		{"bad variables", "GET", "/api/graphql?query=" + url.QueryEscape(query) + "&variables=x", "", "", http.StatusBadRequest, "invalid variables"},
+		{"post json", "POST", "/api/graphql", "application/json", `{"query":` + strconv.Quote(query) + `}`, http.StatusOK, want},
+		{"post graphql", "POST", "/api/graphql", "application/graphql; charset=utf-8", query, http.StatusOK, want},
+		{"bad json", "POST", "/api/graphql", "application/json", "{", http.StatusBadRequest, "invalid request body"},
+		{"invalid query", "POST", "/api/graphql", "application/graphql", "{ hosts {", http.StatusBadRequest, `"locations":[{"line":1,"column":10}]`},
+		{"put", "PUT", "/api/graphql", "", "", http.StatusMethodNotAllowed, "method not allowed"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		m.handleGraphQL(w, r)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s: %d %s, want %d %s", tt.name, w.Code, w.Body, tt.code, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	m.handleGraphQL(w, httptest.NewRequest("GET", "/api/graphql", nil))
+	schema := w.Body.String()
+	for _, want := range []string{
+		"type Query {\n  hosts(status: [String!], tag: [String!], sort: String, offset: Int, limit: Int): [Host!]!\n",
+		"  history(from: String, to: String): [Sample!]!\n",
+		"type Sample {\n  time: String\n  latency: Float!\n  up: Boolean!\n  metrics: JSON\n}\n",
+		"scalar JSON\n",
+	} {
+		if !strings.Contains(schema, want) {
+			t.Errorf("schema lacks %q:\n%s", want, schema)
+		}
+	}
+}
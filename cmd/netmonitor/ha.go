@@ -0,0 +1,341 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HAConfig runs two or more instances as an active/standby pair that share
+// state through Redis:
+//
+//	ha:
+//	  redis: redis://:secret@redis.lan:6379/0
+//	  name: monitor-a
+//
+// All instances probe, but only the one holding the leader lease sends
+// notifications, runs actions and mails reports. The leader keeps the
+// statistics of the hosts in Redis, which an instance starting up takes
+// over, and remembers the last status it announced of every host, so a
+// new leader does not announce it again. Acknowledging an incident on any
+// instance acknowledges it on all of them.
+type HAConfig struct {
+	Redis  string        `yaml:"redis"`
+	Name   string        `yaml:"name"`   // the host name unless set
+	Prefix string        `yaml:"prefix"` // of the Redis keys, netmonitor unless set
+	Lease  time.Duration `yaml:"lease"`  // 15 seconds unless set
+
+	// Quorum is how many instances must see a host down before it is
+	// alerted.
+	Quorum int `yaml:"quorum"`
+}
+
+const defaultLease = 15 * time.Second
+
+// renewLease extends the leader key if this instance still holds it.
+const renewLease = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+type haState struct {
+	redis  *redisClient
+	name   string
+	prefix string
+	lease  time.Duration
+	quorum int
+
+	leader atomic.Bool
+
+	mu        sync.Mutex
+	holder    string    // the current leader as last seen, guarded by mu
+	contacted time.Time // last successful call to Redis, guarded by mu
+	reachable bool      // guarded by mu
+}
+
+// HAStatus is the state of high availability in /api/self.
+type HAStatus struct {
+	Name      string `json:"name"`
+	Leader    bool   `json:"leader"`
+	Holder    string `json:"holder,omitempty"`
+	Reachable bool   `json:"redisReachable"`
+}
+
+func newHA(cfg *HAConfig) (*haState, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Redis == "" {
+		return nil, fmt.Errorf("redis is required")
+	}
+	if cfg.Quorum < 0 {
+		return nil, fmt.Errorf("invalid quorum %d", cfg.Quorum)
+	}
+	client, err := newRedisClient(cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.Name
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("name is required: %v", err)
+		}
+	}
+	return &haState{
+		redis:     client,
+		name:      name,
+		prefix:    cmp.Or(cfg.Prefix, "netmonitor"),
+		lease:     cmp.Or(cfg.Lease, defaultLease),
+		quorum:    cfg.Quorum,
+		contacted: time.Now(), // leaderless without Redis for a lease at most
+		reachable: true,
+	}, nil
+}
+
+func (ha *haState) key(parts ...string) string {
+	k := ha.prefix
+	for _, p := range parts {
+		k += ":" + p
+	}
+	return k
+}
+
+// leads reports whether this instance sends notifications: always without
+// high availability.
+func (m *Monitor) leads() bool {
+	return m.ha == nil || m.ha.leader.Load()
+}
+
+// do runs a Redis command, keeping track of whether Redis is reachable.
+func (ha *haState) do(args ...string) (any, error) {
+	reply, err := ha.redis.do(args...)
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+	if _, isReply := err.(redisError); err != nil && !isReply {
+		if ha.reachable {
+			log.Printf("HA: Redis is unreachable: %v", err)
+		}
+		ha.reachable = false
+		return nil, err
+	}
+	if !ha.reachable {
+		log.Printf("HA: Redis is reachable again")
+	}
+	ha.reachable, ha.contacted = true, time.Now()
+	return reply, err
+}
+
+// elect takes or renews the leader lease. Without Redis for longer than
+// a lease every instance leads, as duplicate alerts beat none at all.
+func (ha *haState) elect() {
+	ms := strconv.FormatInt(ha.lease.Milliseconds(), 10)
+	var leads bool
+	reply, err := ha.do("SET", ha.key("leader"), ha.name, "NX", "PX", ms)
+	if err == nil && reply == nil {
+		// Taken, possibly by this instance before.
+		reply, err = ha.do("EVAL", renewLease, "1", ha.key("leader"), ha.name, ms)
+	}
+	switch {
+	case err != nil:
+		ha.mu.Lock()
+		leads = ha.leader.Load() || time.Since(ha.contacted) > ha.lease
+		ha.mu.Unlock()
+	default:
+		leads = reply == "OK" || reply == int64(1)
+	}
+	holder := ha.name
+	if !leads && err == nil {
+		if h, err := ha.do("GET", ha.key("leader")); err == nil {
+			holder, _ = h.(string)
+		}
+	}
+
+	ha.mu.Lock()
+	first := ha.holder == ""
+	ha.holder = holder
+	ha.mu.Unlock()
+	if was := ha.leader.Swap(leads); was != leads || first {
+		switch {
+		case leads && err != nil:
+			log.Printf("HA: %s leads as Redis is unreachable", ha.name)
+		case leads:
+			log.Printf("HA: %s is now the leader", ha.name)
+		default:
+			log.Printf("HA: %s is now on standby, %s leads", ha.name, holder)
+		}
+	}
+}
+
+func (ha *haState) status() *HAStatus {
+	ha.mu.Lock()
+	defer ha.mu.Unlock()
+	return &HAStatus{Name: ha.name, Leader: ha.leader.Load(), Holder: ha.holder, Reachable: ha.reachable}
+}
+
+// run keeps electing the leader and sharing state, three times a lease.
+func (ha *haState) run(m *Monitor) {
+	ticks, stop := m.clock.NewTicker(ha.lease / 3)
+	defer stop()
+	for range ticks {
+		ha.elect()
+		if !ha.status().Reachable {
+			continue
+		}
+		ha.heartbeat()
+		ha.syncAcks(m)
+		if ha.leader.Load() {
+			ha.saveStats(m)
+		}
+	}
+}
+
+// sharedStats are the statistics of a host kept in Redis.
+type sharedStats struct {
+	Status      string    `json:"status"`
+	LastSeen    time.Time `json:"lastSeen,omitzero"`
+	PacketsSent int       `json:"packetsSent"`
+	PacketsRecv int       `json:"packetsRecv"`
+	AvgLatency  float64   `json:"avgLatency"`
+	MinLatency  float64   `json:"minLatency"`
+	MaxLatency  float64   `json:"maxLatency"`
+	Jitter      float64   `json:"jitter"`
+	StatsSince  time.Time `json:"statsSince,omitzero"`
+	DownSince   time.Time `json:"downSince,omitzero"`
+}
+
+// saveStats writes the statistics of every host to Redis.
+func (ha *haState) saveStats(m *Monitor) {
+	args := []string{"HSET", ha.key("stats")}
+	for _, s := range m.GetStats() {
+		if s.Status == "unknown" {
+			continue
+		}
+		b, _ := json.Marshal(sharedStats{
+			Status: s.Status, LastSeen: s.LastSeen,
+			PacketsSent: s.PacketsSent, PacketsRecv: s.PacketsRecv,
+			AvgLatency: s.AvgLatency, MinLatency: s.MinLatency, MaxLatency: s.MaxLatency, Jitter: s.Jitter,
+			StatsSince: s.StatsSince, DownSince: s.DownSince,
+		})
+		args = append(args, s.Host, string(b))
+	}
+	if len(args) == 2 {
+		return
+	}
+	if _, err := ha.do(args...); err != nil {
+		log.Printf("HA: saving statistics failed: %v", err)
+	}
+}
+
+// loadStats starts the statistics of the hosts from those in Redis, so
+// they carry on from where the leader left them. It runs before probing
+// starts.
+func (ha *haState) loadStats(m *Monitor) {
+	reply, err := ha.do("HGETALL", ha.key("stats"))
+	if err != nil {
+		log.Printf("HA: loading statistics failed: %v", err)
+		return
+	}
+	items, _ := reply.([]any)
+	hs := m.hosts()
+	loaded := 0
+	for i := 0; i+1 < len(items); i += 2 {
+		host, _ := items[i].(string)
+		raw, _ := items[i+1].(string)
+		h, ok := hs.stats[host]
+		var s sharedStats
+		if !ok || json.Unmarshal([]byte(raw), &s) != nil {
+			continue
+		}
+		h.update(func(stats *PingStats) {
+			stats.Status, stats.LastSeen = s.Status, s.LastSeen
+			stats.PacketsSent, stats.PacketsRecv = s.PacketsSent, s.PacketsRecv
+			if stats.PacketsSent > 0 {
+				stats.PacketLoss = float64(stats.PacketsSent-stats.PacketsRecv) / float64(stats.PacketsSent) * 100
+			}
+			stats.AvgLatency, stats.MinLatency, stats.MaxLatency, stats.Jitter = s.AvgLatency, s.MinLatency, s.MaxLatency, s.Jitter
+			stats.StatsSince, stats.DownSince = s.StatsSince, s.DownSince
+		})
+		loaded++
+	}
+	if loaded > 0 {
+		log.Printf("HA: took over the statistics of %d hosts", loaded)
+	}
+}
+
+// announced records the status of an alert as the last one announced for
+// its host and reports whether it was new.
+func (ha *haState) announced(a Alert) bool {
+	previous, err := ha.do("SET", ha.key("announced", a.Host), a.Status, "GET")
+	if err != nil {
+		return true
+	}
+	return previous != a.Status
+}
+
+// shouldNotify reports whether this instance sends an alert: when it
+// leads and, for a status change, no instance announced the status
+// before. Reminders and other alerts are always sent.
+func (m *Monitor) shouldNotify(a Alert) bool {
+	if m.ha == nil {
+		return true
+	}
+	if !m.ha.leader.Load() {
+		log.Printf("Alert for %s (%s) left to the leader", a.Host, a.Status)
+		return false
+	}
+	if a.Repeat || a.Previous == "" || a.Previous == a.Status {
+		return true
+	}
+	if !m.ha.announced(a) {
+		log.Printf("Alert for %s (%s) was already sent by another instance", a.Host, a.Status)
+		return false
+	}
+	return true
+}
+
+// sharedAck is an acknowledgement of the open incident of a host.
+type sharedAck struct {
+	By   string    `json:"by,omitempty"`
+	Note string    `json:"note,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// shareAck tells the other instances about an acknowledged incident.
+func (ha *haState) shareAck(inc Incident, note string) {
+	if ha == nil || !inc.Resolved.IsZero() {
+		return
+	}
+	b, _ := json.Marshal(sharedAck{By: inc.AcknowledgedBy, Note: note, Time: inc.AcknowledgedAt})
+	if _, err := ha.do("HSET", ha.key("acks"), inc.Host, string(b)); err != nil {
+		log.Printf("HA: sharing the acknowledgement of incident %d failed: %v", inc.ID, err)
+	}
+}
+
+// syncAcks acknowledges the open incidents acknowledged elsewhere, and
+// forgets the acknowledgements of hosts that recovered.
+func (ha *haState) syncAcks(m *Monitor) {
+	reply, err := ha.do("HGETALL", ha.key("acks"))
+	if err != nil {
+		return
+	}
+	items, _ := reply.([]any)
+	for i := 0; i+1 < len(items); i += 2 {
+		host, _ := items[i].(string)
+		raw, _ := items[i+1].(string)
+		var ack sharedAck
+		if json.Unmarshal([]byte(raw), &ack) != nil {
+			continue
+		}
+		switch m.incidents.acknowledgeShared(host, ack) {
+		case ackApplied:
+			log.Printf("Incident of %s acknowledged on another instance by %s", host, cmp.Or(ack.By, "someone"))
+		case ackStale:
+			if ha.leader.Load() {
+				ha.do("HDEL", ha.key("acks"), host)
+			}
+		}
+	}
+}
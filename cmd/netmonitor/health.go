@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Health is the body of /healthz.
+type Health struct {
+	Status     string    `json:"status"` // "ok" or "failing"
+	Started    time.Time `json:"started"`
+	Uptime     float64   `json:"uptimeSeconds"`
+	ProbeLoops int       `json:"probeLoops"`
+	LastTick   time.Time `json:"lastTick"`          // latest finished probe
+	Stalled    []string  `json:"stalled,omitempty"` // hosts whose loop is stuck
+}
+
+// handleHealthz serves GET /healthz: 200 while every probe loop keeps
+// finishing probes, 503 otherwise.
+func (m *Monitor) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	h := Health{Status: "ok", ProbeLoops: len(m.hosts().targets), Stalled: m.stalled()}
+	m.mu.RLock()
+	h.Started = m.started
+	m.mu.RUnlock()
+	for _, stats := range m.hosts().stats {
+		if last := stats.lastRunTime(); last.After(h.LastTick) {
+			h.LastTick = last
+		}
+	}
+	if !h.Started.IsZero() {
+		h.Uptime = time.Since(h.Started).Seconds()
+	}
+
+	status := http.StatusOK
+	if h.Started.IsZero() || len(h.Stalled) > 0 {
+		h.Status = "failing"
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
+
+// Readiness is the body of /readyz. Checks maps each check to "ok" or the
+// reason it fails.
+type Readiness struct {
+	Status string            `json:"status"` // "ready" or "not ready"
+	Checks map[string]string `json:"checks"`
+}
+
+// handleReadyz serves GET /readyz: 200 once the configuration is loaded,
+// the probes are running and the raw sockets they need are open.
+func (m *Monitor) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	// The configuration is validated before the web server starts, so
+	// answering at all means it loaded.
+	rd := Readiness{Status: "ready", Checks: map[string]string{"config": "ok", "listener": "ok"}}
+
+	m.mu.RLock()
+	started := !m.started.IsZero()
+	m.mu.RUnlock()
+	rd.Checks["probes"] = "ok"
+	if !started {
+		rd.Checks["probes"] = "not started"
+	}
+	rd.Checks["icmp_sockets"] = "ok"
+	if err := openICMPSockets(m.hosts().targets); err != nil {
+		rd.Checks["icmp_sockets"] = err.Error()
+	}
+
+	status := http.StatusOK
+	for _, result := range rd.Checks {
+		if result != "ok" {
+			rd.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, rd)
+}
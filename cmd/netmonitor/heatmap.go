@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// HeatmapCell sums up the probes of one hour of one day of the week.
+type HeatmapCell struct {
+	Samples int     `json:"samples"`
+	Latency float64 `json:"latency"` // average of the answered probes, ms
+	Loss    float64 `json:"loss"`    // percent of the probes that failed
+}
+
+// Heatmap lays out a host's history by day of the week, Monday first, and
+// hour of the day, so congestion that recurs at the same times stands out.
+type Heatmap struct {
+	Host     string             `json:"host"`
+	From     time.Time          `json:"from"`
+	To       time.Time          `json:"to"`
+	Timezone string             `json:"timezone"`
+	Cells    [7][24]HeatmapCell `json:"cells"`
+}
+
+// buildHeatmap sorts samples into the cells of their local weekday and
+// hour in loc.
+func buildHeatmap(samples []Sample, loc *time.Location) [7][24]HeatmapCell {
+	var cells [7][24]HeatmapCell
+	var answered [7][24]int
+	for _, s := range samples {
+		t := s.Time.In(loc)
+		day, hour := (int(t.Weekday())+6)%7, t.Hour()
+		c := &cells[day][hour]
+		c.Samples++
+		if s.Up {
+			c.Latency += s.Latency
+			answered[day][hour]++
+		}
+	}
+	for day := range cells {
+		for hour := range cells[day] {
+			c := &cells[day][hour]
+			if n := answered[day][hour]; n > 0 {
+				c.Latency /= float64(n)
+			}
+			if c.Samples > 0 {
+				c.Loss = float64(c.Samples-answered[day][hour]) / float64(c.Samples) * 100
+			}
+		}
+	}
+	return cells
+}
+
+// handleHeatmap serves GET /api/heatmap?host=...&from=...&to=...&tz=...,
+// covering the whole history unless from is given. tz is an IANA time zone
+// name; hours are the server's local time unless set.
+func (m *Monitor) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		writeError(w, http.StatusBadRequest, "host parameter is required")
+		return
+	}
+	if _, ok := m.hosts().stats[host]; !ok {
+		writeError(w, http.StatusNotFound, "unknown host")
+		return
+	}
+	from, to, err := parseTimeRange(r, m.history.retention)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	loc := time.Local
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			writeError(w, http.StatusBadRequest, "unknown time zone: "+tz)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, Heatmap{
+		Host:     host,
+		From:     from,
+		To:       to,
+		Timezone: loc.String(),
+		Cells:    buildHeatmap(m.history.rangeOf(host, from, to), loc),
+	})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is the outcome of one probe as kept in the history.
+type Sample struct {
+	Time    time.Time `json:"t" graphql:"time"`
+	Latency float64   `json:"l" graphql:"latency"` // milliseconds, 0 when the probe failed
+	Up      bool      `json:"up"`
+
+	// Metrics are kept for probes that measure something worth charting,
+	// such as the throughput of speed tests.
+	Metrics map[string]float64 `json:"m,omitempty" graphql:"metrics"`
+}
+
+// defaultRetention is how long samples are kept when the config file does
+// not say otherwise. A week is enough for the weekly report.
+const defaultRetention = 7 * 24 * time.Hour
+
+// history keeps samples per host in compressed chunks in memory and, when
+// dir is set, appends them to one JSON lines file per day so they survive
+// restarts. Once a day is over its file is compacted into a chunk file,
+// which is moved to the archive, if any, once past the retention.
+type history struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	series    map[string][]*chunk
+
+	dir     string
+	file    *os.File
+	fileDay string
+
+	archive *historyArchive
+}
+
+// historyRecord is a sample on disk, tagged with its host.
+type historyRecord struct {
+	Host string `json:"h"`
+	Sample
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{retention: retention, series: make(map[string][]*chunk)}
+}
+
+// open starts persisting samples to dir after loading what it already has.
+func (h *history) open(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	h.dir = dir
+	return h.load()
+}
+
+// historyFile is a file of one day of history, in JSON lines or chunks.
+type historyFile struct {
+	path string
+	day  time.Time
+}
+
+// historyFiles returns the day files in dir, oldest first and the chunk
+// file of a day before its JSON lines.
+func historyFiles(dir string) []historyFile {
+	var files []historyFile
+	for _, ext := range []string{".chunks", ".jsonl"} {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*"+ext))
+		for _, path := range paths {
+			day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(filepath.Base(path), ext), time.Local)
+			if err == nil {
+				files = append(files, historyFile{path, day})
+			}
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].day.Before(files[j].day) })
+	return files
+}
+
+// load reads the day files that still fall within the retention window and
+// archives or removes older ones. JSON lines files of days before today are compacted
+// first, which a crash or stop at the end of the day may have missed.
+func (h *history) load() error {
+	now := time.Now()
+	cutoff := now.Add(-h.retention)
+	today := now.Format("2006-01-02")
+
+	compacted := map[string]bool{}
+	for _, f := range historyFiles(h.dir) {
+		if f.day.AddDate(0, 0, 1).Before(cutoff) {
+			continue // for expireFiles below
+		}
+		day := f.day.Format("2006-01-02")
+		if strings.HasSuffix(f.path, ".chunks") {
+			compacted[day] = true
+			if err := h.loadChunks(f.path, cutoff); err != nil {
+				return fmt.Errorf("loading history %s: %v", f.path, err)
+			}
+			continue
+		}
+		if compacted[day] {
+			os.Remove(f.path) // compacted just before a crash
+			continue
+		}
+		if day != today {
+			if err := compactHistory(f.path); err != nil {
+				return fmt.Errorf("compacting history %s: %v", f.path, err)
+			}
+			if err := h.loadChunks(strings.TrimSuffix(f.path, ".jsonl")+".chunks", cutoff); err != nil {
+				return fmt.Errorf("loading history %s: %v", f.path, err)
+			}
+			continue
+		}
+		if err := h.loadFile(f.path, cutoff); err != nil {
+			return fmt.Errorf("loading history %s: %v", f.path, err)
+		}
+	}
+	h.expireFiles(now)
+	return nil
+}
+
+// readHistoryFile calls fn with each record of a JSON lines file.
+func readHistoryFile(path string, fn func(historyRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // a torn last line after a crash
+		}
+		fn(rec)
+	}
+	return scanner.Err()
+}
+
+func (h *history) loadFile(path string, cutoff time.Time) error {
+	return readHistoryFile(path, func(rec historyRecord) {
+		if rec.Time.After(cutoff) {
+			h.append(rec.Host, rec.Sample)
+		}
+	})
+}
+
+func (h *history) loadChunks(path string, cutoff time.Time) error {
+	chunks, err := readChunkFile(path)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if c.last.After(cutoff) {
+			h.series[c.host] = append(h.series[c.host], c.chunk)
+		}
+	}
+	return nil
+}
+
+// compactHistory rewrites a JSON lines file as a chunk file next to it and
+// removes it.
+func compactHistory(path string) error {
+	var chunks []hostChunk
+	open := map[string]*chunk{}
+	err := readHistoryFile(path, func(rec historyRecord) {
+		c := open[rec.Host]
+		if c == nil || c.full() {
+			c = newChunk()
+			open[rec.Host] = c
+			chunks = append(chunks, hostChunk{rec.Host, c})
+		}
+		c.append(rec.Sample)
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeChunkFile(strings.TrimSuffix(path, ".jsonl")+".chunks", chunks); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// add records a sample for host and drops chunks past the retention.
+func (h *history) add(host string, s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.append(host, s)
+	chunks := h.series[host]
+	cutoff := s.Time.Add(-h.retention)
+	if i := sort.Search(len(chunks), func(i int) bool { return chunks[i].last.After(cutoff) }); i > 0 {
+		h.series[host] = slices.Delete(chunks, 0, i)
+	}
+
+	if h.dir != "" {
+		if err := h.persist(historyRecord{Host: host, Sample: s}); err != nil {
+			log.Printf("Writing history failed: %v", err)
+		}
+	}
+}
+
+// append adds a sample to the last chunk of host, starting a new one when
+// it is full. Callers hold h.mu.
+func (h *history) append(host string, s Sample) {
+	chunks := h.series[host]
+	if len(chunks) == 0 || chunks[len(chunks)-1].full() {
+		chunks = append(chunks, newChunk())
+		h.series[host] = chunks
+	}
+	chunks[len(chunks)-1].append(s)
+}
+
+// persist appends a record to the file of its day, compacting the file of
+// the day before once a new one starts. Callers hold h.mu.
+func (h *history) persist(rec historyRecord) error {
+	day := rec.Time.Format("2006-01-02")
+	if day != h.fileDay {
+		if h.file != nil {
+			h.file.Close()
+			h.file = nil
+			previous := filepath.Join(h.dir, h.fileDay+".jsonl")
+			go func() {
+				if err := compactHistory(previous); err != nil {
+					log.Printf("Compacting history %s failed: %v", previous, err)
+				}
+			}()
+		}
+		f, err := os.OpenFile(filepath.Join(h.dir, day+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		h.file, h.fileDay = f, day
+		h.expireFiles(rec.Time)
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(append(line, '\n'))
+	return err
+}
+
+// expireFiles removes day files that are entirely older than the
+// retention, or moves them to the archive in the background.
+func (h *history) expireFiles(now time.Time) {
+	cutoff := now.Add(-h.retention)
+	if h.archive != nil {
+		go h.archive.moveExpired(h.dir, cutoff)
+		return
+	}
+	for _, f := range historyFiles(h.dir) {
+		if f.day.AddDate(0, 0, 1).Before(cutoff) {
+			os.Remove(f.path)
+		}
+	}
+}
+
+// rangeOf returns the samples of host within [from, to), from the archive
+// for the part before the history in memory.
+func (h *history) rangeOf(host string, from, to time.Time) []Sample {
+	samples, first := h.recent(host, from, to)
+	if h.archive != nil && from.Before(first) {
+		samples = append(h.archive.rangeOf(host, from, first), samples...)
+	}
+	return samples
+}
+
+// recent returns the samples of host within [from, to) that are in memory,
+// and the time the history in memory starts at, or to without any.
+func (h *history) recent(host string, from, to time.Time) ([]Sample, time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	first := to
+	if chunks := h.series[host]; len(chunks) > 0 && chunks[0].first.Before(to) {
+		first = chunks[0].first
+	}
+	samples := []Sample{}
+	for _, c := range h.series[host] {
+		if c.last.Before(from) || !c.first.Before(to) {
+			continue
+		}
+		decoded, err := c.samples()
+		if err != nil {
+			log.Printf("History of %s from %s is corrupt: %v", host, c.first.Format(time.DateTime), err)
+			continue
+		}
+		for _, s := range decoded {
+			if !s.Time.Before(from) && s.Time.Before(to) {
+				samples = append(samples, s)
+			}
+		}
+	}
+	return samples, first
+}
+
+// hostNames returns the hosts with history, sorted.
+func (h *history) hostNames() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return slices.Sorted(maps.Keys(h.series))
+}
+
+// size returns the number of samples kept and the bytes they take.
+func (h *history) size() (samples, bytes int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, chunks := range h.series {
+		for _, c := range chunks {
+			samples += c.count
+			bytes += c.size()
+		}
+	}
+	return samples, bytes
+}
+
+// parseTimeRange reads the from and to query parameters as RFC 3339 times
+// or durations relative to now ("24h" means the last 24 hours).
+func parseTimeRange(r *http.Request, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	return parseTimeBounds(q.Get("from"), q.Get("to"), defaultSpan)
+}
+
+// parseTimeBounds is parseTimeRange for a from and a to given otherwise,
+// either empty.
+func parseTimeBounds(fromArg, toArg string, defaultSpan time.Duration) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := now
+	from := now.Add(-defaultSpan)
+
+	parse := func(v string) (time.Time, error) {
+		if d, err := time.ParseDuration(v); err == nil {
+			return now.Add(-d), nil
+		}
+		return time.Parse(time.RFC3339, v)
+	}
+	var err error
+	if fromArg != "" {
+		if from, err = parse(fromArg); err != nil {
+			return from, to, fmt.Errorf("invalid from: %q", fromArg)
+		}
+	}
+	if toArg != "" {
+		if to, err = parse(toArg); err != nil {
+			return from, to, fmt.Errorf("invalid to: %q", toArg)
+		}
+	}
+	return from, to, nil
+}
+
+// handleHistory serves GET /api/history?host=...&from=...&to=...
+func (m *Monitor) handleHistory(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		writeError(w, http.StatusBadRequest, "host parameter is required")
+		return
+	}
+	if _, ok := m.hosts().stats[host]; !ok {
+		writeError(w, http.StatusNotFound, "unknown host")
+		return
+	}
+	from, to, err := parseTimeRange(r, time.Hour)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, m.history.rangeOf(host, from, to))
+}
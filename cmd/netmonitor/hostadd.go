@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// apiHostsProvider is the provider of hosts added through the API, which
+// are scheduled and dropped like discovered ones.
+const apiHostsProvider = "api"
+
+// AddedHost is a host added through the API.
+type AddedHost struct {
+	Target string   `json:"target"`
+	Tags   []string `json:"tags,omitempty"`
+	HostMeta
+}
+
+// addedHosts keeps the hosts added through the API, in the history
+// directory when one is configured so they survive restarts.
+type addedHosts struct {
+	mu    sync.Mutex
+	path  string
+	hosts []AddedHost
+}
+
+// open loads the hosts saved in dir.
+func (a *addedHosts) open(dir string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.path = filepath.Join(dir, "added-hosts.json")
+	b, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &a.hosts)
+}
+
+// saveLocked writes the hosts out. a.mu must be held.
+func (a *addedHosts) saveLocked() error {
+	if a.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(a.hosts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.path, b, 0644)
+}
+
+// syncAddedHosts schedules the hosts added through the API, dropping those
+// removed.
+func (m *Monitor) syncAddedHosts() {
+	m.added.mu.Lock()
+	defer m.added.mu.Unlock()
+	var targets []*target
+	for _, h := range m.added.hosts {
+		ts, err := m.addedTargets(h)
+		if err != nil {
+			log.Printf("Adding host: %v", err)
+			continue
+		}
+		targets = append(targets, ts...)
+	}
+	m.syncHosts(apiHostsProvider, targets)
+}
+
+// addedTargets returns the targets of a host added through the API. Probe
+// types that run programs on this host, such as exec://, are refused:
+// whoever may add hosts could otherwise run any command.
+func (m *Monitor) addedTargets(h AddedHost) ([]*target, error) {
+	ts, err := m.config.hostTargets(HostConfig{Target: h.Target, Tags: h.Tags, HostMeta: h.HostMeta})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range ts {
+		if t.runsLocally() {
+			return nil, fmt.Errorf("%s: %s targets run programs on this host and can only be set in the config file", h.Target, t.kind)
+		}
+	}
+	return ts, nil
+}
+
+// handleHostAdd serves POST /api/hosts, monitoring another host with the
+// settings of the config file's groups for its tags.
+func (m *Monitor) handleHostAdd(w http.ResponseWriter, r *http.Request) {
+	var h AddedHost
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid host: "+err.Error())
+		return
+	}
+	if h.Target == "" {
+		writeError(w, http.StatusBadRequest, "target is required")
+		return
+	}
+	ts, err := m.addedTargets(h)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, t := range ts {
+		if _, ok := m.hosts().stats[t.name]; ok {
+			writeError(w, http.StatusConflict, fmt.Sprintf("%s is already monitored", t.name))
+			return
+		}
+	}
+
+	m.added.mu.Lock()
+	m.added.hosts = append(m.added.hosts, h)
+	if err := m.added.saveLocked(); err != nil {
+		m.added.hosts = m.added.hosts[:len(m.added.hosts)-1]
+		m.added.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	m.added.mu.Unlock()
+	m.syncAddedHosts()
+	m.auditRequest(r, "host.add", h.Target, nil, h)
+
+	var stats []PingStats
+	for _, t := range ts {
+		if s, ok := m.hosts().stats[t.name]; ok {
+			stats = append(stats, s.load())
+		}
+	}
+	writeJSON(w, http.StatusCreated, stats)
+}
+
+// handleHostRemove serves DELETE /api/hosts?host=..., dropping a host
+// added through the API. Hosts of the config file and discovery stay.
+func (m *Monitor) handleHostRemove(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("host")
+	if target == "" {
+		writeError(w, http.StatusBadRequest, "host parameter is required")
+		return
+	}
+	m.added.mu.Lock()
+	i := slices.IndexFunc(m.added.hosts, func(h AddedHost) bool { return h.Target == target })
+	if i < 0 {
+		m.added.mu.Unlock()
+		writeError(w, http.StatusNotFound, "no host of that target was added through the API")
+		return
+	}
+	h := m.added.hosts[i]
+	m.added.hosts = slices.Delete(m.added.hosts, i, i+1)
+	if err := m.added.saveLocked(); err != nil {
+		m.added.hosts = slices.Insert(m.added.hosts, i, h)
+		m.added.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	m.added.mu.Unlock()
+	m.syncAddedHosts()
+	m.auditRequest(r, "host.remove", h.Target, h, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
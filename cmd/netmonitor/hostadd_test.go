@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostAddRefusesLocalProbes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"exec:///bin/sh?arg=-c&arg=id", http.StatusBadRequest},
+		{"browser://example.com/", http.StatusBadRequest},
+		{"192.0.2.1", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		m := NewMonitor(nil, 0, time.Minute)
+		m.config = &Config{}
+		body := strings.NewReader(`{"target": "` + tt.target + `"}`)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/hosts", body))
+		if rec.Code != tt.want {
+			t.Errorf("POST %s: status %d, want %d: %s", tt.target, rec.Code, tt.want, rec.Body)
+		}
+		if _, ok := m.hosts().stats[tt.target]; ok != (tt.want == http.StatusCreated) {
+			t.Errorf("POST %s: monitored %v", tt.target, ok)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// hostIcons are the categories a host can be shown with.
+var hostIcons = []string{"router", "switch", "firewall", "access-point", "server", "database", "camera", "printer", "phone", "cloud"}
+
+// HostMeta describes a host for people: a friendly name, an icon and notes
+// such as where it is or who looks after it.
+type HostMeta struct {
+	DisplayName string `json:"displayName,omitempty" yaml:"name"`
+	Icon        string `json:"icon,omitempty" yaml:"icon"`
+	Notes       string `json:"notes,omitempty" yaml:"notes"`
+}
+
+func (h HostMeta) validate() error {
+	if h.Icon != "" && !slices.Contains(hostIcons, h.Icon) {
+		return fmt.Errorf("unknown icon %q, expected one of %v", h.Icon, hostIcons)
+	}
+	return nil
+}
+
+// hostMetaEdits keeps changes made through the API, in the history
+// directory when one is configured so they survive restarts. They win over
+// the config file.
+type hostMetaEdits struct {
+	mu    sync.Mutex
+	path  string
+	edits map[string]HostMeta
+}
+
+// open loads the edits saved in dir and applies them to the hosts.
+func (e *hostMetaEdits) open(dir string, hosts *hostSet) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.path = filepath.Join(dir, "hosts.json")
+	b, err := os.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &e.edits); err != nil {
+		return err
+	}
+	for name, meta := range e.edits {
+		if h, ok := hosts.stats[name]; ok {
+			h.update(func(s *PingStats) { s.HostMeta = meta })
+		}
+	}
+	return nil
+}
+
+func (e *hostMetaEdits) save(name string, meta HostMeta) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.edits == nil {
+		e.edits = map[string]HostMeta{}
+	}
+	e.edits[name] = meta
+	if e.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(e.edits, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.path, b, 0644)
+}
+
+// lookup returns the edits made to a host.
+func (e *hostMetaEdits) lookup(name string) (HostMeta, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	meta, ok := e.edits[name]
+	return meta, ok
+}
+
+// hostMetaUpdate changes the fields it sets.
+type hostMetaUpdate struct {
+	DisplayName *string `json:"displayName"`
+	Icon        *string `json:"icon"`
+	Notes       *string `json:"notes"`
+}
+
+// handleHostUpdate serves PATCH /api/hosts?host=..., changing how a host
+// is shown.
+func (m *Monitor) handleHostUpdate(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("host")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "host parameter is required")
+		return
+	}
+	h, ok := m.hosts().stats[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown host")
+		return
+	}
+	var req hostMetaUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	before := h.load().HostMeta
+	meta := before
+	for dst, v := range map[*string]*string{&meta.DisplayName: req.DisplayName, &meta.Icon: req.Icon, &meta.Notes: req.Notes} {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	if err := meta.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := m.hostEdits.save(name, meta); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.update(func(s *PingStats) { s.HostMeta = meta })
+	m.auditRequest(r, "host.update", name, before, meta)
+	writeJSON(w, http.StatusOK, h.load())
+}
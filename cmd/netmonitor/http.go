@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTP targets fetch a URL and fail on errors and 4xx or 5xx answers:
+//
+//	https://example.com/health
+//
+// The query belongs to the URL, so there are no parameters. Instead,
+// steps in the config file script a transaction such as a user logging
+// in and looking at a page, one request after the other with the cookies
+// they were given:
+//
+//	hosts:
+//	  - target: https://shop.example.com
+//	    name: Login journey
+//	    steps:
+//	      - name: form
+//	        url: /login
+//	        extract: {csrf: 'name="csrf" value="([^"]+)"'}
+//	      - name: login
+//	        method: POST
+//	        url: /login
+//	        form: {user: monitor, password: s3cret, csrf: "${csrf}"}
+//	        expect_status: 200
+//	      - name: account
+//	        url: /account
+//	        expect_text: Welcome back
+//
+// The version the server answered with is the http_version metric.
+// http_version in the config file forces one, failing servers that do not
+// speak it: 1.1, 2 (also over plain http://) or 3, over QUIC.
+//
+// URLs are relative to the target's. Extracted values are the first group
+// of their expression and replace ${name} in the URLs, headers, bodies and
+// forms of later steps. Every step has the timeout of the host; the time
+// of each is a metric of its own and the latency is the total.
+func init() {
+	probers["http"] = probeHTTP
+	probers["https"] = probeHTTP
+	probeDurations["http"] = httpDuration
+	probeDurations["https"] = httpDuration
+}
+
+// HTTPStep is one request of a scripted HTTP transaction.
+type HTTPStep struct {
+	Name         string            `yaml:"name"`
+	Method       string            `yaml:"method"` // GET, or POST with a body or form
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers"`
+	Body         string            `yaml:"body"`
+	Form         map[string]string `yaml:"form"`
+	ExpectStatus int               `yaml:"expect_status"` // anything below 400 unless set
+	ExpectText   string            `yaml:"expect_text"`
+	Extract      map[string]string `yaml:"extract"` // name: regular expression
+}
+
+// httpStep is a validated HTTPStep.
+type httpStep struct {
+	HTTPStep
+	base       *url.URL // the target's, URL is relative to
+	extract    map[string]*regexp.Regexp
+	extractKey []string // sorted names of extract
+}
+
+// maxHTTPBody is how much of a response is read for checks and extracts.
+const maxHTTPBody = 1 << 20
+
+// newHTTPSteps validates the steps of the HTTP target t.
+func newHTTPSteps(t *target, steps []HTTPStep) ([]httpStep, error) {
+	var result []httpStep
+	for i, s := range steps {
+		step := httpStep{HTTPStep: s, extract: map[string]*regexp.Regexp{}}
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if !isCheckName(step.Name) || slices.ContainsFunc(result, func(o httpStep) bool { return o.Name == step.Name }) {
+			return nil, fmt.Errorf("step %d: invalid or repeated name %q", i+1, step.Name)
+		}
+		if step.Body != "" && len(step.Form) > 0 {
+			return nil, fmt.Errorf("step %s: body and form exclude each other", step.Name)
+		}
+		if step.Method == "" {
+			step.Method = http.MethodGet
+			if step.Body != "" || len(step.Form) > 0 {
+				step.Method = http.MethodPost
+			}
+		}
+		step.Method = strings.ToUpper(step.Method)
+		if _, err := url.Parse(step.URL); err != nil {
+			return nil, fmt.Errorf("step %s: invalid url %q", step.Name, step.URL)
+		}
+		step.base = t.url
+		if step.ExpectStatus != 0 && (step.ExpectStatus < 100 || step.ExpectStatus > 599) {
+			return nil, fmt.Errorf("step %s: invalid expect_status %d", step.Name, step.ExpectStatus)
+		}
+		for name, expr := range step.Extract {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("step %s: extract %s: %v", step.Name, name, err)
+			}
+			if re.NumSubexp() < 1 {
+				return nil, fmt.Errorf("step %s: extract %s has no group to extract", step.Name, name)
+			}
+			step.extract[name] = re
+		}
+		step.extractKey = slices.Sorted(maps.Keys(step.extract))
+		result = append(result, step)
+	}
+	return result, nil
+}
+
+// httpDuration is how long all the steps of a target may take.
+func httpDuration(t *target) time.Duration {
+	return time.Duration(max(len(t.httpSteps), 1)) * t.timeout()
+}
+
+// newHTTPTransport returns the transport for the HTTP version of t:
+// HTTP/1.1 or HTTP/2 as negotiated with the server unless forced, HTTP/2
+// without TLS for http:// targets, or HTTP/3 over QUIC.
+func newHTTPTransport(t *target) interface {
+	http.RoundTripper
+	CloseIdleConnections()
+} {
+	if t.httpVersion == "3" {
+		return &http3Transport{t: t}
+	}
+	transport := t.proxied(&http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: t.tlsConfig(t.host)})
+	switch t.httpVersion {
+	case "1.1":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP1(true)
+	case "2":
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// httpVariable matches the ${name} of extracted values.
+var httpVariable = regexp.MustCompile(`\$\{(\w+)\}`)
+
+func probeHTTP(t *target) (probeResult, error) {
+	steps := t.httpSteps
+	if len(steps) == 0 {
+		steps = []httpStep{{HTTPStep: HTTPStep{Name: "get", Method: http.MethodGet}, base: t.url}}
+	}
+	jar, _ := cookiejar.New(nil)
+	transport := newHTTPTransport(t)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Jar: jar}
+
+	vars := map[string]string{}
+	expand := func(s string) string {
+		return httpVariable.ReplaceAllStringFunc(s, func(m string) string {
+			if v, ok := vars[m[2:len(m)-1]]; ok {
+				return v
+			}
+			return m
+		})
+	}
+	result := probeResult{Metrics: map[string]float64{}}
+	for _, step := range steps {
+		start := time.Now()
+		version, err := step.run(client, t.timeout(), expand, vars)
+		elapsed := msSince(start)
+		if err == nil && t.httpVersion != "" && version != t.httpVersion {
+			err = fmt.Errorf("answered over HTTP/%s instead of HTTP/%s", version, t.httpVersion)
+		}
+		if err != nil {
+			if len(steps) == 1 {
+				return probeResult{}, err
+			}
+			return probeResult{}, fmt.Errorf("%s: %w", step.Name, err)
+		}
+		result.Latency += elapsed
+		result.Metrics["http_version"], _ = strconv.ParseFloat(version, 64)
+		if len(t.httpSteps) > 0 {
+			result.Metrics[step.Name+"_ms"] = elapsed
+		}
+	}
+	return result, nil
+}
+
+// run sends the request of a step and checks the response, adding what it
+// extracts to vars. It returns the HTTP version the server answered with.
+func (s *httpStep) run(client *http.Client, timeout time.Duration, expand func(string) string, vars map[string]string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ref, err := url.Parse(expand(s.URL))
+	if err != nil {
+		return "", err
+	}
+	var body io.Reader
+	contentType := ""
+	switch {
+	case len(s.Form) > 0:
+		form := url.Values{}
+		for k, v := range s.Form {
+			form.Set(k, expand(v))
+		}
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	case s.Body != "":
+		body = strings.NewReader(expand(s.Body))
+	}
+	req, err := http.NewRequestWithContext(ctx, s.Method, s.base.ResolveReference(ref).String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "netmonitor")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range s.Headers {
+		req.Header.Set(k, expand(v))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	version := strconv.Itoa(resp.ProtoMajor)
+	if resp.ProtoMajor == 1 {
+		version += "." + strconv.Itoa(resp.ProtoMinor)
+	}
+	if s.ExpectStatus != 0 && resp.StatusCode != s.ExpectStatus {
+		return version, fmt.Errorf("expected status %d, got %s", s.ExpectStatus, resp.Status)
+	}
+	if s.ExpectStatus == 0 && resp.StatusCode >= 400 {
+		return version, errors.New(resp.Status)
+	}
+	if s.ExpectText == "" && len(s.extract) == 0 {
+		_, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxHTTPBody))
+		return version, err
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return version, err
+	}
+	if s.ExpectText != "" && !strings.Contains(string(page), expand(s.ExpectText)) {
+		return version, fmt.Errorf("%q not found in the response", s.ExpectText)
+	}
+	for _, name := range s.extractKey {
+		m := s.extract[name].FindSubmatch(page)
+		if m == nil {
+			return version, fmt.Errorf("nothing to extract as %s", name)
+		}
+		vars[name] = string(m[1])
+	}
+	return version, nil
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+	"golang.org/x/net/quic"
+)
+
+// http3Transport sends the requests of an HTTP target over HTTP/3, one
+// QUIC connection per server, from the target's source and interface.
+// It implements just what probes need: no server push, no dynamic QPACK
+// table and no connection sharing beyond a single probe.
+type http3Transport struct {
+	t *target
+
+	mu       sync.Mutex
+	endpoint *quic.Endpoint
+	conns    map[string]*quic.Conn // by host:port
+}
+
+// HTTP/3 frame and stream types (RFC 9114).
+const (
+	h3FrameData     = 0x00
+	h3FrameHeaders  = 0x01
+	h3FrameSettings = 0x04
+	h3StreamControl = 0x00
+
+	h3NoError = 0x100
+
+	// maxH3Headers is the size of the largest header section accepted.
+	maxH3Headers = 64 << 10
+)
+
+func (tr *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("HTTP/3 needs https, not %s", req.URL.Scheme)
+	}
+	conn, err := tr.conn(req.Context(), req.URL.Hostname(), cmp.Or(req.URL.Port(), "443"))
+	if err != nil {
+		return nil, err
+	}
+	s, err := conn.NewStream(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	s.SetReadContext(req.Context())
+	s.SetWriteContext(req.Context())
+
+	fields := [][2]string{
+		{":method", req.Method},
+		{":scheme", "https"},
+		{":authority", cmp.Or(req.Host, req.URL.Host)},
+		{":path", req.URL.RequestURI()},
+	}
+	for name, values := range req.Header {
+		for _, v := range values {
+			fields = append(fields, [2]string{strings.ToLower(name), v})
+		}
+	}
+	if req.ContentLength > 0 {
+		fields = append(fields, [2]string{"content-length", strconv.FormatInt(req.ContentLength, 10)})
+	}
+	if _, err := s.Write(appendH3Frame(nil, h3FrameHeaders, encodeQPACK(fields))); err != nil {
+		return nil, err
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			if _, err := s.Write(appendH3Frame(nil, h3FrameData, body)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.CloseWrite()
+
+	for {
+		typ, payload, err := readH3Frame(s, maxH3Headers)
+		if err != nil {
+			s.CloseRead()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("reading response headers: %w", err)
+		}
+		if typ != h3FrameHeaders {
+			continue // DATA before HEADERS is not allowed, reserved types are skipped
+		}
+		fields, err := decodeQPACK(payload)
+		if err != nil {
+			s.CloseRead()
+			return nil, err
+		}
+		resp := &http.Response{
+			Proto: "HTTP/3.0", ProtoMajor: 3,
+			Header:        http.Header{},
+			Request:       req,
+			ContentLength: -1,
+			Body:          &http3Body{s: s},
+		}
+		status := ""
+		for _, f := range fields {
+			switch {
+			case f[0] == ":status":
+				status = f[1]
+			case strings.HasPrefix(f[0], ":"):
+			default:
+				resp.Header.Add(f[0], f[1])
+			}
+		}
+		resp.StatusCode, err = strconv.Atoi(status)
+		if err != nil || resp.StatusCode < 100 || resp.StatusCode > 599 {
+			s.CloseRead()
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+		if resp.StatusCode < 200 {
+			continue // informational, the final response follows
+		}
+		resp.Status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+		if n, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+			resp.ContentLength = n
+		}
+		return resp, nil
+	}
+}
+
+// conn returns the connection to host, dialing it along with the control
+// stream HTTP/3 requires on first use.
+func (tr *http3Transport) conn(ctx context.Context, host, port string) (*quic.Conn, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	key := net.JoinHostPort(host, port)
+	if c, ok := tr.conns[key]; ok {
+		return c, nil
+	}
+	if tr.endpoint == nil {
+		local := ":0"
+		if tr.t.source != nil {
+			local = net.JoinHostPort(tr.t.source.String(), "0")
+		}
+		pc, err := tr.t.listenConfig().ListenPacket(ctx, "udp", local)
+		if err != nil {
+			return nil, err
+		}
+		if tr.endpoint, err = quic.NewEndpoint(pc, &quic.Config{}); err != nil {
+			pc.Close()
+			return nil, err
+		}
+		tr.conns = map[string]*quic.Conn{}
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		if host != tr.t.host {
+			ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+			if err != nil || len(ips) == 0 {
+				return nil, fmt.Errorf("resolving %s: %v", host, err)
+			}
+			addr = ips[0]
+		} else if addr, err = tr.t.resolve(); err != nil {
+			return nil, err
+		}
+	}
+	config := tr.t.tlsConfig(host)
+	config.NextProtos, config.MinVersion = []string{"h3"}, tls.VersionTLS13
+	c, err := tr.endpoint.Dial(ctx, "udp", net.JoinHostPort(addr.Unmap().String(), port), &quic.Config{TLSConfig: config})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("no QUIC answer from %s", key)
+		}
+		return nil, fmt.Errorf("QUIC: %w", err)
+	}
+	control, err := c.NewSendOnlyStream(ctx)
+	if err == nil {
+		// The control stream starts with the client's settings, none here,
+		// and must stay open as long as the connection.
+		_, err = control.Write(appendH3Frame([]byte{h3StreamControl}, h3FrameSettings, nil))
+		if err == nil {
+			err = control.Flush()
+		}
+	}
+	if err != nil {
+		c.Abort(nil)
+		return nil, err
+	}
+	tr.conns[key] = c
+	return c, nil
+}
+
+// CloseIdleConnections closes every connection and the socket.
+func (tr *http3Transport) CloseIdleConnections() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.endpoint == nil {
+		return
+	}
+	for _, c := range tr.conns {
+		c.Abort(&quic.ApplicationError{Code: h3NoError})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	tr.endpoint.Close(ctx)
+	tr.endpoint, tr.conns = nil, nil
+}
+
+// http3Body reads the DATA frames of a response, skipping anything else
+// such as trailers.
+type http3Body struct {
+	s    *quic.Stream
+	left int64 // of the current DATA frame
+}
+
+func (b *http3Body) Read(p []byte) (int, error) {
+	for b.left == 0 {
+		typ, err := readVarint(b.s)
+		if err != nil {
+			return 0, err // io.EOF at the end of the stream
+		}
+		size, err := readVarint(b.s)
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if typ == h3FrameData {
+			b.left = int64(size)
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, b.s, int64(size)); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	n, err := b.s.Read(p[:min(int64(len(p)), b.left)])
+	b.left -= int64(n)
+	if err == io.EOF && b.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (b *http3Body) Close() error {
+	b.s.CloseRead()
+	return nil
+}
+
+// readVarint reads a QUIC variable-length integer (RFC 9000, 16).
+func readVarint(r io.ByteReader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(first & 0x3f)
+	for range 1<<(first>>6) - 1 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendH3Frame(b []byte, typ uint64, payload []byte) []byte {
+	b = appendVarint(b, typ)
+	b = appendVarint(b, uint64(len(payload)))
+	return append(b, payload...)
+}
+
+// readH3Frame reads a frame of at most limit bytes.
+func readH3Frame(s *quic.Stream, limit uint64) (uint64, []byte, error) {
+	typ, err := readVarint(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := readVarint(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > limit {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(s, payload); err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return typ, payload, nil
+}
+
+// encodeQPACK encodes header fields as literals (RFC 9204), which needs
+// neither table.
+func encodeQPACK(fields [][2]string) []byte {
+	b := []byte{0, 0} // required insert count and base
+	for _, f := range fields {
+		b = appendQPACKInt(b, 0x20, 3, uint64(len(f[0])))
+		b = append(b, f[0]...)
+		b = appendQPACKInt(b, 0, 7, uint64(len(f[1])))
+		b = append(b, f[1]...)
+	}
+	return b
+}
+
+// decodeQPACK decodes a header section. Without a dynamic table on offer,
+// servers may only refer to the static one.
+func decodeQPACK(b []byte) ([][2]string, error) {
+	d := &qpackDecoder{b: b}
+	ric, err := d.int(8)
+	if err != nil {
+		return nil, err
+	}
+	if ric != 0 {
+		return nil, errors.New("QPACK: dynamic table used without being offered")
+	}
+	if _, err := d.int(7); err != nil { // base
+		return nil, err
+	}
+	var fields [][2]string
+	for len(d.b) > 0 {
+		first := d.b[0]
+		var name, value string
+		switch {
+		case first&0x80 != 0: // indexed field line
+			if first&0x40 == 0 {
+				return nil, errors.New("QPACK: dynamic table reference")
+			}
+			i, err := d.int(6)
+			if err != nil || i >= uint64(len(qpackStatic)) {
+				return nil, errors.New("QPACK: invalid static index")
+			}
+			name, value = qpackStatic[i][0], qpackStatic[i][1]
+		case first&0x40 != 0: // literal with name reference
+			if first&0x10 == 0 {
+				return nil, errors.New("QPACK: dynamic table reference")
+			}
+			i, err := d.int(4)
+			if err != nil || i >= uint64(len(qpackStatic)) {
+				return nil, errors.New("QPACK: invalid static index")
+			}
+			name = qpackStatic[i][0]
+			if value, err = d.string(7); err != nil {
+				return nil, err
+			}
+		case first&0x20 != 0: // literal with literal name
+			if name, err = d.string(3); err != nil {
+				return nil, err
+			}
+			if value, err = d.string(7); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("QPACK: dynamic table reference")
+		}
+		fields = append(fields, [2]string{name, value})
+	}
+	return fields, nil
+}
+
+type qpackDecoder struct{ b []byte }
+
+// int reads an integer with an n-bit prefix (RFC 7541, 5.1).
+func (d *qpackDecoder) int(n uint) (uint64, error) {
+	if len(d.b) == 0 {
+		return 0, errors.New("QPACK: truncated")
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(d.b[0]) & mask
+	d.b = d.b[1:]
+	if v < mask {
+		return v, nil
+	}
+	for shift := uint(0); shift < 63; shift += 7 {
+		if len(d.b) == 0 {
+			return 0, errors.New("QPACK: truncated")
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("QPACK: integer overflow")
+}
+
+// string reads a string whose length has an n-bit prefix, preceded by the
+// bit flagging Huffman coding.
+func (d *qpackDecoder) string(n uint) (string, error) {
+	if len(d.b) == 0 {
+		return "", errors.New("QPACK: truncated")
+	}
+	huffman := d.b[0]&(1<<n) != 0
+	size, err := d.int(n)
+	if err != nil {
+		return "", err
+	}
+	if size > uint64(len(d.b)) {
+		return "", errors.New("QPACK: truncated")
+	}
+	raw := d.b[:size]
+	d.b = d.b[size:]
+	if huffman {
+		return hpack.HuffmanDecodeToString(raw)
+	}
+	return string(raw), nil
+}
+
+func appendQPACKInt(b []byte, flags byte, n uint, v uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if v < mask {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(mask))
+	for v -= mask; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// qpackStatic is the QPACK static table (RFC 9204, appendix A).
+var qpackStatic = [...][2]string{
+	{":authority", ""}, {":path", "/"}, {"age", "0"}, {"content-disposition", ""},
+	{"content-length", "0"}, {"cookie", ""}, {"date", ""}, {"etag", ""},
+	{"if-modified-since", ""}, {"if-none-match", ""}, {"last-modified", ""}, {"link", ""},
+	{"location", ""}, {"referer", ""}, {"set-cookie", ""}, {":method", "CONNECT"},
+	{":method", "DELETE"}, {":method", "GET"}, {":method", "HEAD"}, {":method", "OPTIONS"},
+	{":method", "POST"}, {":method", "PUT"}, {":scheme", "http"}, {":scheme", "https"},
+	{":status", "103"}, {":status", "200"}, {":status", "304"}, {":status", "404"},
+	{":status", "503"}, {"accept", "*/*"}, {"accept", "application/dns-message"}, {"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"}, {"access-control-allow-headers", "cache-control"}, {"access-control-allow-headers", "content-type"}, {"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"}, {"cache-control", "max-age=2592000"}, {"cache-control", "max-age=604800"}, {"cache-control", "no-cache"},
+	{"cache-control", "no-store"}, {"cache-control", "public, max-age=31536000"}, {"content-encoding", "br"}, {"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"}, {"content-type", "application/javascript"}, {"content-type", "application/json"}, {"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"}, {"content-type", "image/jpeg"}, {"content-type", "image/png"}, {"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"}, {"content-type", "text/plain"}, {"content-type", "text/plain;charset=utf-8"}, {"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"}, {"strict-transport-security", "max-age=31536000; includesubdomains"}, {"strict-transport-security", "max-age=31536000; includesubdomains; preload"}, {"vary", "accept-encoding"},
+	{"vary", "origin"}, {"x-content-type-options", "nosniff"}, {"x-xss-protection", "1; mode=block"}, {":status", "100"},
+	{":status", "204"}, {":status", "206"}, {":status", "302"}, {":status", "400"},
+	{":status", "403"}, {":status", "421"}, {":status", "425"}, {":status", "500"},
+	{"accept-language", ""}, {"access-control-allow-credentials", "FALSE"}, {"access-control-allow-credentials", "TRUE"}, {"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"}, {"access-control-allow-methods", "get, post, options"}, {"access-control-allow-methods", "options"}, {"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"}, {"access-control-request-method", "get"}, {"access-control-request-method", "post"}, {"alt-svc", "clear"},
+	{"authorization", ""}, {"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"}, {"early-data", "1"}, {"expect-ct", ""},
+	{"forwarded", ""}, {"if-range", ""}, {"origin", ""}, {"purpose", "prefetch"},
+	{"server", ""}, {"timing-allow-origin", "*"}, {"upgrade-insecure-requests", "1"}, {"user-agent", ""},
+	{"x-forwarded-for", ""}, {"x-frame-options", "deny"}, {"x-frame-options", "sameorigin"},
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// The examples of RFC 9000, appendix A.1.
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		encoded []byte
+		v       uint64
+		minimal bool
+	}{
+		{[]byte{0xc2, 0x19, 0x7c, 0x5e, 0xff, 0x14, 0xe8, 0x8c}, 151288809941952652, true},
+		{[]byte{0x9d, 0x7f, 0x3e, 0x7d}, 494878333, true},
+		{[]byte{0x7b, 0xbd}, 15293, true},
+		{[]byte{0x25}, 37, true},
+		{[]byte{0x40, 0x25}, 37, false},
+	}
+	for _, tt := range tests {
+		v, err := readVarint(bytes.NewReader(tt.encoded))
+		if err != nil || v != tt.v {
+			t.Errorf("% x: read %d, %v; want %d", tt.encoded, v, err, tt.v)
+		}
+		if got := appendVarint(nil, tt.v); tt.minimal && !bytes.Equal(got, tt.encoded) {
+			t.Errorf("%d: encoded % x, want % x", tt.v, got, tt.encoded)
+		}
+	}
+	if _, err := readVarint(bytes.NewReader([]byte{0x9d, 0x7f})); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated varint: error %v", err)
+	}
+}
+
+// The integers of RFC 7541, appendix C.1.
+func TestQPACKInt(t *testing.T) {
+	tests := []struct {
+		v       uint64
+		n       uint
+		encoded []byte
+	}{
+		{10, 5, []byte{0x0a}},
+		{1337, 5, []byte{0x1f, 0x9a, 0x0a}},
+		{42, 8, []byte{0x2a}},
+		{31, 5, []byte{0x1f, 0x00}},
+	}
+	for _, tt := range tests {
+		if got := appendQPACKInt(nil, 0, tt.n, tt.v); !bytes.Equal(got, tt.encoded) {
+			t.Errorf("%d with a %d-bit prefix: encoded % x, want % x", tt.v, tt.n, got, tt.encoded)
+		}
+		d := &qpackDecoder{b: tt.encoded}
+		if v, err := d.int(tt.n); err != nil || v != tt.v || len(d.b) != 0 {
+			t.Errorf("% x: decoded %d, %v", tt.encoded, v, err)
+		}
+	}
+	d := &qpackDecoder{b: []byte{0x1f, 0x9a}}
+	if _, err := d.int(5); err == nil {
+		t.Error("truncated integer decoded")
+	}
+}
+
+func TestDecodeQPACK(t *testing.T) {
+	huffman := func(s string) []byte {
+		b := appendQPACKInt(nil, 0x80, 7, hpack.HuffmanEncodeLength(s))
+		return hpack.AppendHuffmanString(b, s)
+	}
+	tests := []struct {
+		name    string
+		encoded []byte
+		want    [][2]string
+		err     string
+	}{
+		// RFC 9204, appendix B.1.
+		{"name reference", []byte("\x00\x00\x51\x0b/index.html"), [][2]string{{":path", "/index.html"}}, ""},
+		{"indexed", []byte{0, 0, 0xc0 | 25, 0xc0 | 31}, [][2]string{{":status", "200"}, {"accept-encoding", "gzip, deflate, br"}}, ""},
+		{"long index", append([]byte{0, 0, 0x5f, 46 - 15}, huffman("text/html")...), [][2]string{{"content-type", "text/html"}}, ""},
+		{"literals", encodeQPACK([][2]string{{"server", "netmonitor"}, {"x-a-rather-long-header-name", ""}}),
+			[][2]string{{"server", "netmonitor"}, {"x-a-rather-long-header-name", ""}}, ""},
+		{"no fields", []byte{0, 0}, nil, ""},
+		{"dynamic table", []byte{2, 0, 0x80}, nil, "dynamic table used"},
+		{"dynamic indexed", []byte{0, 0, 0x80}, nil, "dynamic table reference"},
+		{"dynamic name", []byte{0, 0, 0x41, 0}, nil, "dynamic table reference"},
+		{"post-base", []byte{0, 0, 0x10}, nil, "dynamic table reference"},
+		{"past the static table", []byte{0, 0, 0xff, 0x24}, nil, "invalid static index"},
+		{"truncated value", []byte("\x00\x00\x51\x0b/index"), nil, "truncated"},
+		{"no base", []byte{0}, nil, "truncated"},
+	}
+	for _, tt := range tests {
+		got, err := decodeQPACK(tt.encoded)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
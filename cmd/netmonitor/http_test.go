@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHTTPVersion(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	http1 := httptest.NewServer(ok)
+	defer http1.Close()
+	h2c := httptest.NewUnstartedServer(ok)
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+	secure := httptest.NewUnstartedServer(ok)
+	secure.EnableHTTP2 = true
+	secure.StartTLS()
+	defer secure.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(secure.Certificate())
+	tlsSettings.Lock()
+	tlsSettings.roots = roots
+	tlsSettings.Unlock()
+	defer func() {
+		tlsSettings.Lock()
+		tlsSettings.roots = nil
+		tlsSettings.Unlock()
+	}()
+
+	tests := []struct {
+		name    string
+		url     string
+		version string
+		want    float64
+		err     string
+	}{
+		{"http/1.1", http1.URL, "", 1.1, ""},
+		{"forced http/1.1", h2c.URL, "1.1", 1.1, ""},
+		{"h2c", h2c.URL, "2", 2, ""},
+		{"h2c refused", http1.URL, "2", 0, "http2"},
+		{"negotiated over tls", secure.URL, "", 2, ""},
+		{"forced http/1.1 over tls", secure.URL, "1.1", 1.1, ""},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tgt.httpVersion = tt.version
+		result, err := probeHTTP(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Metrics["http_version"] != tt.want {
+			t.Errorf("%s: %v, %v; want HTTP/%v", tt.name, result.Metrics, err, tt.want)
+		}
+	}
+}
+
+func TestNewHTTPSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []HTTPStep
+		err   string
+	}{
+		{"ok", []HTTPStep{{URL: "/login", Extract: map[string]string{"csrf": `value="([^"]+)"`}}, {Name: "login", Form: map[string]string{"user": "monitor"}}}, ""},
+		{"repeated name", []HTTPStep{{Name: "a"}, {Name: "a"}}, `step 2: invalid or repeated name "a"`},
+		{"bad name", []HTTPStep{{Name: "log in"}}, `step 1: invalid or repeated name "log in"`},
+		{"body and form", []HTTPStep{{Body: "x", Form: map[string]string{"a": "b"}}}, "step step1: body and form exclude each other"},
+		{"bad url", []HTTPStep{{URL: "%zz"}}, `step step1: invalid url "%zz"`},
+		{"bad status", []HTTPStep{{ExpectStatus: 99}}, "step step1: invalid expect_status 99"},
+		{"bad extract", []HTTPStep{{Extract: map[string]string{"csrf": "("}}}, "step step1: extract csrf:"},
+		{"nothing to extract", []HTTPStep{{Extract: map[string]string{"csrf": "csrf"}}}, "extract csrf has no group"},
+	}
+	for _, tt := range tests {
+		targets, err := (&Config{}).hostTargets(HostConfig{Target: "https://shop.example.com", Steps: tt.steps})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		steps := targets[0].httpSteps
+		if len(steps) != 2 || steps[0].Name != "step1" || steps[0].Method != "GET" || steps[1].Method != "POST" || steps[0].extractKey[0] != "csrf" {
+			t.Errorf("%s: %+v", tt.name, steps)
+		}
+	}
+	if _, err := (&Config{}).hostTargets(HostConfig{Target: "192.0.2.1", Steps: []HTTPStep{{URL: "/"}}}); err == nil {
+		t.Error("steps accepted for a ping target")
+	}
+}
+
+func TestProbeHTTPSteps(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1"})
+		io.WriteString(w, `<form><input name="csrf" value="t0k3n"></form>`)
+	})
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "s1" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		if r.FormValue("csrf") != "t0k3n" || r.FormValue("user") != "monitor" || r.Header.Get("X-Shop") != "t0k3n" {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+	mux.HandleFunc("GET /account", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "Welcome back, monitor")
+	})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	form := HTTPStep{Name: "form", URL: "/login", Extract: map[string]string{"csrf": `name="csrf" value="([^"]+)"`}}
+	login := HTTPStep{Name: "login", URL: "/login", Form: map[string]string{"user": "monitor", "csrf": "${csrf}"}, Headers: map[string]string{"X-Shop": "${csrf}"}, ExpectText: "Welcome back"}
+	tests := []struct {
+		name    string
+		url     string
+		steps   []HTTPStep
+		metrics []string
+		err     string
+	}{
+		{"plain", server.URL + "/account", nil, nil, ""},
+		{"plain failing", server.URL + "/broken", nil, nil, "500 Internal Server Error"},
+		{"journey", server.URL, []HTTPStep{form, login}, []string{"form_ms", "login_ms"}, ""},
+		{"without the token", server.URL, []HTTPStep{{Name: "form", URL: "/login"}, login}, nil, "login: 400 Bad Request"},
+		// A single step fails without its name.
+		{"expected status", server.URL, []HTTPStep{{Name: "account", URL: "/account", ExpectStatus: 201}}, nil, "expected status 201, got 200 OK"},
+		{"expected text", server.URL, []HTTPStep{{Name: "account", URL: "/account", ExpectText: "Goodbye"}}, nil, `"Goodbye" not found in the response`},
+		{"nothing extracted", server.URL, []HTTPStep{{Name: "account", URL: "/account", Extract: map[string]string{"csrf": `value="(\w+)"`}}}, nil, "nothing to extract as csrf"},
+		{"status only", server.URL, []HTTPStep{{Name: "broken", URL: "/broken", ExpectStatus: 500}}, []string{"broken_ms"}, ""},
+	}
+	for _, tt := range tests {
+		targets, err := (&Config{}).hostTargets(HostConfig{Target: tt.url, Steps: tt.steps})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		result, err := probeHTTP(targets[0])
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var total float64
+		for _, name := range tt.metrics {
+			v, ok := result.Metrics[name]
+			if !ok {
+				t.Errorf("%s: no %s in %v", tt.name, name, result.Metrics)
+			}
+			total += v
+		}
+		if tt.metrics != nil && result.Latency != total {
+			t.Errorf("%s: latency %v, want the total %v", tt.name, result.Latency, total)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A small reader of iCalendar (RFC 5545) files, enough for the events of a
+// team calendar: start and end or duration in UTC, a named time zone or
+// floating local time, all-day events, and simple recurrences with
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY for weekly events, and EXDATE.
+
+// calendarEvent is a VEVENT of a calendar.
+type calendarEvent struct {
+	summary    string
+	start, end time.Time
+	rule       *recurrence // nil for a single occurrence
+	exdates    []time.Time
+}
+
+type recurrence struct {
+	freq     string // DAILY, WEEKLY, MONTHLY or YEARLY
+	interval int
+	count    int // 0 for no limit
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+// occurrence is one span of time an event takes up.
+type occurrence struct {
+	summary    string
+	start, end time.Time
+}
+
+// maxOccurrences bounds how many occurrences of one event are expanded.
+const maxOccurrences = 10000
+
+// parseCalendar reads the events of an iCalendar file.
+func parseCalendar(r io.Reader) ([]calendarEvent, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []calendarEvent
+	var ev *calendarEvent
+	var duration time.Duration
+	var allDay bool
+	for i, line := range lines {
+		name, params, value, ok := parseContentLine(line)
+		if !ok {
+			continue // tolerate junk, as calendar servers produce some
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			ev, duration, allDay = &calendarEvent{}, -1, false
+		case ev == nil:
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if ev.start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no DTSTART", i+1, ev.summary)
+			}
+			if ev.end.IsZero() {
+				switch {
+				case duration >= 0:
+					ev.end = ev.start.Add(duration)
+				case allDay:
+					ev.end = ev.start.AddDate(0, 0, 1)
+				default:
+					ev.end = ev.start
+				}
+			}
+			events = append(events, *ev)
+			ev = nil
+		case name == "SUMMARY":
+			ev.summary = unescapeText(value)
+		case name == "DTSTART":
+			if ev.start, err = parseCalendarTime(params, value); err != nil {
+				return nil, fmt.Errorf("line %d: DTSTART: %v", i+1, err)
+			}
+			allDay = !strings.Contains(value, "T")
+		case name == "DTEND":
+			if ev.end, err = parseCalendarTime(params, value); err != nil {
+				return nil, fmt.Errorf("line %d: DTEND: %v", i+1, err)
+			}
+		case name == "DURATION":
+			if duration, err = parseCalendarDuration(value); err != nil {
+				return nil, fmt.Errorf("line %d: DURATION: %v", i+1, err)
+			}
+		case name == "RRULE":
+			if ev.rule, err = parseRecurrence(value); err != nil {
+				return nil, fmt.Errorf("line %d: RRULE: %v", i+1, err)
+			}
+		case name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				t, err := parseCalendarTime(params, v)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: EXDATE: %v", i+1, err)
+				}
+				ev.exdates = append(ev.exdates, t)
+			}
+		}
+	}
+	return events, nil
+}
+
+// unfoldLines joins lines continued with a leading space or tab.
+func unfoldLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseContentLine splits NAME;PARAM=VALUE;...:VALUE. Parameter values
+// may be quoted and contain colons.
+func parseContentLine(line string) (name string, params map[string]string, value string, ok bool) {
+	params = map[string]string{}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return "", nil, "", false
+	}
+	name = strings.ToUpper(line[:i])
+	rest := line[i:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return "", nil, "", false
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return "", nil, "", false
+			}
+			v, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return "", nil, "", false
+			}
+			v, rest = rest[:end], rest[end:]
+		}
+		params[key] = v
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return "", nil, "", false
+	}
+	return name, params, rest[1:], true
+}
+
+var textEscapes = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+func unescapeText(s string) string {
+	return textEscapes.Replace(s)
+}
+
+// parseCalendarTime parses a DATE or DATE-TIME value: in UTC with a Z, in
+// the zone of a TZID parameter, or else local time.
+func parseCalendarTime(params map[string]string, value string) (time.Time, error) {
+	loc := time.Local
+	if tzid := params["TZID"]; tzid != "" {
+		// Calendars exported from Outlook name zones like "W. Europe
+		// Standard Time", which Go does not know; local time is the best
+		// guess for those.
+		if l, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = l
+		}
+	}
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+	return time.ParseInLocation("20060102", value, loc)
+}
+
+var calendarDurationRe = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseCalendarDuration parses a duration such as PT2H30M or P1D.
+func parseCalendarDuration(s string) (time.Duration, error) {
+	m := calendarDurationRe.FindStringSubmatch(s)
+	if m == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+2] != "" {
+			n, _ := strconv.Atoi(m[i+2])
+			d += time.Duration(n) * unit
+		}
+	}
+	if m[1] == "-" {
+		return 0, fmt.Errorf("negative duration %q", s)
+	}
+	return d, nil
+}
+
+var icalDays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+func parseRecurrence(s string) (*recurrence, error) {
+	r := &recurrence{interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+			if !slices.Contains([]string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}, r.freq) {
+				return nil, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(value); err != nil || r.interval < 1 {
+				return nil, fmt.Errorf("invalid interval %q", value)
+			}
+		case "COUNT":
+			if r.count, err = strconv.Atoi(value); err != nil || r.count < 1 {
+				return nil, fmt.Errorf("invalid count %q", value)
+			}
+		case "UNTIL":
+			if r.until, err = parseCalendarTime(nil, value); err != nil {
+				return nil, fmt.Errorf("invalid until %q", value)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				// Ordinals such as 1MO only make sense monthly; the
+				// weekday is what counts for weekly events.
+				day = strings.TrimLeft(day, "+-0123456789")
+				wd, ok := icalDays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("invalid day %q", day)
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		}
+	}
+	if r.freq == "" {
+		return nil, fmt.Errorf("no frequency in %q", s)
+	}
+	return r, nil
+}
+
+// occurrences returns the spans of the event that overlap from..to.
+func (e calendarEvent) occurrences(from, to time.Time) []occurrence {
+	length := e.end.Sub(e.start)
+	var spans []occurrence
+	add := func(start time.Time) {
+		if slices.ContainsFunc(e.exdates, start.Equal) {
+			return
+		}
+		if end := start.Add(length); end.After(from) && start.Before(to) {
+			spans = append(spans, occurrence{summary: e.summary, start: start, end: end})
+		}
+	}
+	if e.rule == nil {
+		add(e.start)
+		return spans
+	}
+
+	r := e.rule
+	n := 0 // occurrences so far, for COUNT
+	for i := 0; i < maxOccurrences; i++ {
+		var starts []time.Time
+		switch r.freq {
+		case "DAILY":
+			starts = []time.Time{e.start.AddDate(0, 0, i*r.interval)}
+		case "WEEKLY":
+			if len(r.byDay) == 0 {
+				starts = []time.Time{e.start.AddDate(0, 0, 7*i*r.interval)}
+				break
+			}
+			// Weeks start on Monday.
+			monday := e.start.AddDate(0, 0, -((int(e.start.Weekday())+6)%7)+7*i*r.interval)
+			for offset := range 7 {
+				day := monday.AddDate(0, 0, offset)
+				if slices.Contains(r.byDay, day.Weekday()) && !day.Before(e.start) {
+					starts = append(starts, day)
+				}
+			}
+		case "MONTHLY":
+			starts = []time.Time{e.start.AddDate(0, i*r.interval, 0)}
+		case "YEARLY":
+			starts = []time.Time{e.start.AddDate(i*r.interval, 0, 0)}
+		}
+		for _, start := range starts {
+			if !r.until.IsZero() && start.After(r.until) || r.count > 0 && n >= r.count || !start.Before(to) {
+				return spans
+			}
+			n++
+			add(start)
+		}
+	}
+	return spans
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContentLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		params map[string]string
+		value  string
+		ok     bool
+	}{
+		{"SUMMARY:Patch day", "SUMMARY", map[string]string{}, "Patch day", true},
+		{"dtstart;tzid=Europe/Berlin:20260107T220000", "DTSTART", map[string]string{"TZID": "Europe/Berlin"}, "20260107T220000", true},
+		{`ATTENDEE;CN="Ops: Night";ROLE=CHAIR:mailto:ops@example.com`, "ATTENDEE", map[string]string{"CN": "Ops: Night", "ROLE": "CHAIR"}, "mailto:ops@example.com", true},
+		{"DESCRIPTION:", "DESCRIPTION", map[string]string{}, "", true},
+		{"no colon", "", nil, "", false},
+		{":value", "", nil, "", false},
+		{"X;PARAM:value", "", nil, "", false},
+		{`X;CN="unterminated:value`, "", nil, "", false},
+		{"X;A=1", "", nil, "", false},
+	}
+	for _, tt := range tests {
+		name, params, value, ok := parseContentLine(tt.line)
+		if ok != tt.ok || ok && (name != tt.name || !maps.Equal(params, tt.params) || value != tt.value) {
+			t.Errorf("%q: %q %v %q %v", tt.line, name, params, value, ok)
+		}
+	}
+}
+
+func TestParseCalendarDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"PT2H30M", 150 * time.Minute, false},
+		{"P1D", 24 * time.Hour, false},
+		{"P1W", 7 * 24 * time.Hour, false},
+		{"+P1DT12H", 36 * time.Hour, false},
+		{"PT90S", 90 * time.Second, false},
+		{"P", 0, true},
+		{"PT", 0, true},
+		{"P1DT", 0, true},
+		{"-PT15M", 0, true},
+		{"2 hours", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseCalendarDuration(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("%s: %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestParseRecurrence(t *testing.T) {
+	tests := []struct {
+		in  string
+		err string
+	}{
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,+1FR;COUNT=10", ""},
+		{"freq=daily;until=20261231T235959Z", ""},
+		{"FREQ=HOURLY", `unsupported frequency "HOURLY"`},
+		{"FREQ=DAILY;INTERVAL=0", `invalid interval "0"`},
+		{"FREQ=DAILY;COUNT=-1", `invalid count "-1"`},
+		{"FREQ=DAILY;UNTIL=tomorrow", `invalid until "tomorrow"`},
+		{"FREQ=WEEKLY;BYDAY=XX", `invalid day "XX"`},
+		{"COUNT=3", "no frequency"},
+	}
+	for _, tt := range tests {
+		r, err := parseRecurrence(tt.in)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || r.freq == "" || r.interval < 1 {
+			t.Errorf("%s: %+v, %v", tt.in, r, err)
+		}
+	}
+	r, _ := parseRecurrence("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,+1FR;COUNT=10")
+	if r.freq != "WEEKLY" || r.interval != 2 || r.count != 10 || len(r.byDay) != 3 || r.byDay[2] != time.Friday {
+		t.Errorf("weekly rule: %+v", r)
+	}
+}
+
+func TestParseCalendar(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	const calendar = "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Core router maintenance\\, phase 1\r\n" +
+		"DTSTART:20260107T220000Z\r\n" +
+		"DTEND:20260108T010000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Weekly patch\r\n" +
+		" ing window\r\n" +
+		"DTSTART;TZID=Europe/Berlin:20260105T200000\r\n" +
+		"DURATION:PT2H\r\n" +
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,TH\r\n" +
+		"EXDATE;TZID=Europe/Berlin:20260112T200000,20260115T200000\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Office move\r\n" +
+		"DTSTART;VALUE=DATE:20260110\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Reminder\r\n" +
+		"DTSTART:20260111T090000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"garbage\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := parseCalendar(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("%d events, want 4", len(events))
+	}
+	tests := []struct {
+		summary    string
+		start, end time.Time
+		exdates    int
+		recurring  bool
+	}{
+		{"Core router maintenance, phase 1", time.Date(2026, 1, 7, 22, 0, 0, 0, time.UTC), time.Date(2026, 1, 8, 1, 0, 0, 0, time.UTC), 0, false},
+		{"Weekly patching window", time.Date(2026, 1, 5, 20, 0, 0, 0, berlin), time.Date(2026, 1, 5, 22, 0, 0, 0, berlin), 2, true},
+		{"Office move", time.Date(2026, 1, 10, 0, 0, 0, 0, time.Local), time.Date(2026, 1, 11, 0, 0, 0, 0, time.Local), 0, false},
+		{"Reminder", time.Date(2026, 1, 11, 9, 0, 0, 0, time.UTC), time.Date(2026, 1, 11, 9, 0, 0, 0, time.UTC), 0, false},
+	}
+	for i, tt := range tests {
+		e := events[i]
+		if e.summary != tt.summary || !e.start.Equal(tt.start) || !e.end.Equal(tt.end) || len(e.exdates) != tt.exdates || (e.rule != nil) != tt.recurring {
+			t.Errorf("event %d: %q %v-%v, %d exdates, rule %+v", i+1, e.summary, e.start, e.end, len(e.exdates), e.rule)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		calendar string
+		err      string
+	}{
+		{"no start", "BEGIN:VEVENT\nSUMMARY:Nothing\nEND:VEVENT\n", `line 3: event "Nothing" has no DTSTART`},
+		{"bad start", "BEGIN:VEVENT\nDTSTART:tomorrow\nEND:VEVENT\n", "line 2: DTSTART"},
+		{"bad duration", "BEGIN:VEVENT\nDTSTART:20260101T000000Z\nDURATION:1h\nEND:VEVENT\n", "line 3: DURATION"},
+		{"bad rule", "BEGIN:VEVENT\nDTSTART:20260101T000000Z\nRRULE:FREQ=SECONDLY\nEND:VEVENT\n", "line 3: RRULE"},
+		{"bad exdate", "BEGIN:VEVENT\nDTSTART:20260101T000000Z\nEXDATE:20260102T000000Z,soon\nEND:VEVENT\n", "line 3: EXDATE"},
+	} {
+		if _, err := parseCalendar(strings.NewReader(tt.calendar)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2026, 1, day, hour, 0, 0, 0, time.UTC) }
+	rule := func(s string) *recurrence {
+		r, err := parseRecurrence(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	// 2026-01-05 is a Monday.
+	tests := []struct {
+		name     string
+		event    calendarEvent
+		from, to time.Time
+		starts   []time.Time
+	}{
+		{"single", calendarEvent{start: at(7, 22), end: at(8, 1)}, at(8, 0), at(9, 0), []time.Time{at(7, 22)}},
+		{"single ended", calendarEvent{start: at(7, 22), end: at(8, 1)}, at(8, 1), at(9, 0), nil},
+		{"single not yet", calendarEvent{start: at(7, 22), end: at(8, 1)}, at(6, 0), at(7, 22), nil},
+		{"daily count", calendarEvent{start: at(5, 2), end: at(5, 3), rule: rule("FREQ=DAILY;COUNT=3")}, at(1, 0), at(31, 0),
+			[]time.Time{at(5, 2), at(6, 2), at(7, 2)}},
+		{"every other day until", calendarEvent{start: at(5, 2), end: at(5, 3), rule: rule("FREQ=DAILY;INTERVAL=2;UNTIL=20260111T020000Z")}, at(1, 0), at(31, 0),
+			[]time.Time{at(5, 2), at(7, 2), at(9, 2), at(11, 2)}},
+		{"weekly by day", calendarEvent{start: at(7, 20), end: at(7, 22), rule: rule("FREQ=WEEKLY;BYDAY=MO,WE,FR"), exdates: []time.Time{at(12, 20)}}, at(7, 0), at(17, 0),
+			[]time.Time{at(7, 20), at(9, 20), at(14, 20), at(16, 20)}},
+		{"fortnightly", calendarEvent{start: at(5, 20), end: at(5, 22), rule: rule("FREQ=WEEKLY;INTERVAL=2")}, at(10, 0), at(31, 0),
+			[]time.Time{at(19, 20)}},
+		{"count before the window", calendarEvent{start: at(1, 0), end: at(1, 1), rule: rule("FREQ=DAILY;COUNT=5")}, at(4, 0), at(31, 0),
+			[]time.Time{at(4, 0), at(5, 0)}},
+		{"monthly", calendarEvent{start: at(15, 0), end: at(15, 1), rule: rule("FREQ=MONTHLY;COUNT=2")}, at(1, 0), time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{at(15, 0), time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)}},
+	}
+	for _, tt := range tests {
+		var starts []time.Time
+		for _, o := range tt.event.occurrences(tt.from, tt.to) {
+			if o.end.Sub(o.start) != tt.event.end.Sub(tt.event.start) {
+				t.Errorf("%s: occurrence %v-%v", tt.name, o.start, o.end)
+			}
+			starts = append(starts, o.start)
+		}
+		if len(starts) != len(tt.starts) {
+			t.Errorf("%s: %v, want %v", tt.name, starts, tt.starts)
+			continue
+		}
+		for i := range starts {
+			if !starts[i].Equal(tt.starts[i]) {
+				t.Errorf("%s: %v, want %v", tt.name, starts, tt.starts)
+				break
+			}
+		}
+	}
+}
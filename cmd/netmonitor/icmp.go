@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// ICMPv4 message types the pinger deals with.
+const (
+	icmpTypeEchoReply    = 0
+	icmpTypeUnreachable  = 3
+	icmpTypeEchoRequest  = 8
+	icmpTypeTimeExceeded = 11
+)
+
+const (
+	icmpHeaderLen      = 8
+	ipv4MinHeaderLen   = 20
+	icmpProtocolNumber = 1 // in the protocol field of IPv4 headers
+)
+
+// icmpReply is what an ICMP message says about one of our echo requests.
+type icmpReply struct {
+	seq uint16
+	// peer is where the echo request went: the source of an echo reply,
+	// or the destination quoted by an error.
+	peer netip.Addr
+	err  *icmpError // nil for an echo reply
+}
+
+// icmpError is a Destination Unreachable or Time Exceeded message answering
+// an echo request, sent by the router that gave up on it.
+type icmpError struct {
+	Type, Code uint8
+	From       netip.Addr
+}
+
+func (e *icmpError) Error() string {
+	if !e.From.IsValid() {
+		return e.reason()
+	}
+	return fmt.Sprintf("%s (from %s)", e.reason(), e.From)
+}
+
+var unreachableReasons = map[uint8]string{
+	0:  "destination net unreachable",
+	1:  "destination host unreachable",
+	2:  "destination protocol unreachable",
+	3:  "destination port unreachable",
+	4:  "fragmentation needed",
+	5:  "source route failed",
+	6:  "destination net unknown",
+	7:  "destination host unknown",
+	9:  "destination net administratively prohibited",
+	10: "destination host administratively prohibited",
+	13: "communication administratively prohibited",
+}
+
+func (e *icmpError) reason() string {
+	switch e.Type {
+	case icmpTypeUnreachable:
+		if r, ok := unreachableReasons[e.Code]; ok {
+			return r
+		}
+		return fmt.Sprintf("destination unreachable (code %d)", e.Code)
+	case icmpTypeTimeExceeded:
+		if e.Code == 1 {
+			return "fragment reassembly time exceeded"
+		}
+		return "TTL expired in transit"
+	}
+	return fmt.Sprintf("ICMP type %d code %d", e.Type, e.Code)
+}
+
+// noReplyError is the error of a ping nothing answered in time.
+type noReplyError struct {
+	timeout time.Duration
+}
+
+func (e noReplyError) Error() string { return fmt.Sprintf("no echo reply within %v", e.timeout) }
+
+func (noReplyError) Timeout() bool { return true }
+
+// errNotOurs is returned for well-formed messages about something other
+// than our echo requests, such as pings of other programs.
+var errNotOurs = errors.New("not an answer to our echo requests")
+
+// parseICMPReply parses an ICMPv4 message received from from, without its
+// IP header, and returns the echo request of ours it answers. Raw sockets
+// see every ICMP message the host gets, and nothing here trusts them:
+// lengths, checksums and the quoted request are all checked, so a
+// malformed or forged message is reported as an error and never
+// attributed to a host.
+func parseICMPReply(b []byte, from netip.Addr, id uint16) (icmpReply, error) {
+	if len(b) < icmpHeaderLen {
+		return icmpReply{}, fmt.Errorf("ICMP message of %d bytes is too short", len(b))
+	}
+	if internetChecksum(b) != 0 {
+		return icmpReply{}, errors.New("bad ICMP checksum")
+	}
+	typ, code := b[0], b[1]
+	switch typ {
+	case icmpTypeEchoReply:
+		if code != 0 {
+			return icmpReply{}, fmt.Errorf("echo reply with code %d", code)
+		}
+		if binary.BigEndian.Uint16(b[4:]) != id {
+			return icmpReply{}, errNotOurs
+		}
+		return icmpReply{seq: binary.BigEndian.Uint16(b[6:]), peer: from.Unmap()}, nil
+
+	case icmpTypeUnreachable, icmpTypeTimeExceeded:
+		// After the header comes the IP header of the packet that was
+		// dropped and at least the first 8 bytes of its payload, which for
+		// an echo request are its ICMP header.
+		if len(b) < icmpHeaderLen+ipv4MinHeaderLen+icmpHeaderLen {
+			return icmpReply{}, fmt.Errorf("ICMP error of %d bytes is too short to quote a packet", len(b))
+		}
+		ip := b[icmpHeaderLen:]
+		if ip[0]>>4 != 4 {
+			return icmpReply{}, fmt.Errorf("ICMP error quotes an IPv%d packet", ip[0]>>4)
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		if ihl < ipv4MinHeaderLen || len(ip) < ihl+icmpHeaderLen {
+			return icmpReply{}, fmt.Errorf("ICMP error quotes a truncated packet")
+		}
+		if ip[9] != icmpProtocolNumber {
+			return icmpReply{}, errNotOurs
+		}
+		quoted := ip[ihl:]
+		if quoted[0] != icmpTypeEchoRequest || binary.BigEndian.Uint16(quoted[4:]) != id {
+			return icmpReply{}, errNotOurs
+		}
+		dst := netip.AddrFrom4([4]byte(ip[16:20]))
+		return icmpReply{
+			seq:  binary.BigEndian.Uint16(quoted[6:]),
+			peer: dst,
+			err:  &icmpError{Type: typ, Code: code, From: from.Unmap()},
+		}, nil
+	}
+	return icmpReply{}, errNotOurs
+}
+
+// internetChecksum is the checksum of RFC 1071, which is zero over a
+// message that carries a correct one.
+func internetChecksum(b []byte) uint16 {
+	var sum uint32
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
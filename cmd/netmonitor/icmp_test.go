@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+)
+
+// icmpMessage builds an ICMPv4 message with a correct checksum.
+func icmpMessage(typ, code uint8, rest []byte, body []byte) []byte {
+	b := append([]byte{typ, code, 0, 0}, rest...)
+	b = append(b, body...)
+	binary.BigEndian.PutUint16(b[2:], internetChecksum(b))
+	return b
+}
+
+// echoHeader is the ICMP header of an echo request or reply.
+func echoHeader(typ uint8, id, seq uint16) []byte {
+	b := []byte{typ, 0, 0, 0}
+	b = binary.BigEndian.AppendUint16(b, id)
+	return binary.BigEndian.AppendUint16(b, seq)
+}
+
+// quotedPacket is the IPv4 header, ihl bytes long, of an echo request to
+// dst followed by as much of its ICMP header as fits in quote.
+func quotedPacket(ihl int, dst [4]byte, id, seq uint16, quote int) []byte {
+	ip := make([]byte, max(ihl, ipv4MinHeaderLen))
+	ip[0] = 4<<4 | byte(ihl/4)
+	ip[9] = icmpProtocolNumber
+	copy(ip[16:], dst[:])
+	return append(ip, echoHeader(icmpTypeEchoRequest, id, seq)[:quote]...)
+}
+
+func FuzzParseICMPReply(f *testing.F) {
+	const id = 0x1234
+	dst := [4]byte{192, 0, 2, 1}
+	seeds := [][]byte{
+		// Echo replies, ours and another program's.
+		icmpMessage(icmpTypeEchoReply, 0, echoHeader(0, id, 7)[4:], []byte("payload")),
+		icmpMessage(icmpTypeEchoReply, 0, echoHeader(0, id+1, 7)[4:], nil),
+		// Errors quoting one of our requests.
+		icmpMessage(icmpTypeUnreachable, 1, make([]byte, 4), quotedPacket(20, dst, id, 9, 8)),
+		icmpMessage(icmpTypeTimeExceeded, 0, make([]byte, 4), quotedPacket(20, dst, id, 10, 8)),
+		icmpMessage(icmpTypeTimeExceeded, 0, make([]byte, 4), quotedPacket(24, dst, id, 11, 8)),
+		// A quote cut short of the echo header.
+		icmpMessage(icmpTypeUnreachable, 3, make([]byte, 4), quotedPacket(20, dst, id, 12, 4)),
+		// IHLs below the minimum and past the quote.
+		icmpMessage(icmpTypeUnreachable, 3, make([]byte, 4), quotedPacket(16, dst, id, 13, 8)),
+		icmpMessage(icmpTypeTimeExceeded, 0, make([]byte, 4), quotedPacket(60, dst, id, 14, 8)[:40]),
+		// A bad checksum and a bare header.
+		{icmpTypeEchoReply, 0, 0xff, 0xff, 0x12, 0x34, 0, 1},
+		{icmpTypeEchoReply, 0, 0},
+	}
+	for _, b := range seeds {
+		f.Add(b, uint16(id))
+	}
+
+	from := netip.MustParseAddr("198.51.100.1")
+	f.Fuzz(func(t *testing.T, b []byte, id uint16) {
+		reply, err := parseICMPReply(b, from, id)
+		if err != nil {
+			return
+		}
+		if internetChecksum(b) != 0 {
+			t.Fatalf("accepted a message with a bad checksum")
+		}
+		// Whatever was accepted must carry our id and the sequence
+		// number returned, in the echo reply or in the quoted request.
+		echo := b
+		if reply.err != nil {
+			ihl := int(b[icmpHeaderLen]&0x0f) * 4
+			echo = b[icmpHeaderLen+ihl:]
+			if echo[0] != icmpTypeEchoRequest {
+				t.Fatalf("attributed an error quoting ICMP type %d", echo[0])
+			}
+			if reply.peer != netip.AddrFrom4([4]byte(b[icmpHeaderLen+16:icmpHeaderLen+20])) {
+				t.Fatalf("peer %s is not the quoted destination", reply.peer)
+			}
+		} else if b[0] != icmpTypeEchoReply || reply.peer != from {
+			t.Fatalf("accepted ICMP type %d from %s as an echo reply from %s", b[0], from, reply.peer)
+		}
+		if got := binary.BigEndian.Uint16(echo[4:]); got != id {
+			t.Fatalf("attributed a message for id %d to id %d", got, id)
+		}
+		if got := binary.BigEndian.Uint16(echo[6:]); got != reply.seq {
+			t.Fatalf("sequence number %d reported as %d", got, reply.seq)
+		}
+	})
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ImportedHost is a host read from another tool's host list, in the shape
+// of a hosts entry of the config file.
+type ImportedHost struct {
+	Target string   `json:"target" yaml:"target"`
+	Name   string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Icon   string   `json:"icon,omitempty" yaml:"icon,omitempty"`
+	Notes  string   `json:"notes,omitempty" yaml:"notes,omitempty"`
+	Tags   []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// importers read host lists by format name.
+var importers = map[string]func(io.Reader) ([]ImportedHost, error){
+	"csv":    importCSV,
+	"hosts":  importHostsFile,
+	"nagios": importNagios,
+}
+
+// importFormat guesses the format of a file from its name.
+func importFormat(path string) string {
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".csv"):
+		return "csv"
+	case strings.EqualFold(filepath.Ext(path), ".cfg"):
+		return "nagios"
+	case filepath.Base(path) == "hosts":
+		return "hosts"
+	}
+	return ""
+}
+
+// importCSV reads a CSV file with a header row. The target comes from a
+// target, host, address or ip column; name, icon and notes columns are
+// copied and tags are separated by semicolons. A type column other than
+// icmp turns the host into a target URL such as dns://host.
+func importCSV(r io.Reader) ([]ImportedHost, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "host", "hostname", "address", "ip":
+			name = "target"
+		case "display_name", "alias":
+			name = "name"
+		case "kind", "probe":
+			name = "type"
+		}
+		if _, ok := columns[name]; !ok {
+			columns[name] = i
+		}
+	}
+	if _, ok := columns["target"]; !ok {
+		return nil, errors.New("no target, host, address or ip column in header")
+	}
+
+	var hosts []ImportedHost
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return hosts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		h := ImportedHost{Target: field("target"), Name: field("name"), Icon: field("icon"), Notes: field("notes")}
+		if h.Target == "" {
+			continue
+		}
+		if kind := strings.ToLower(field("type")); kind != "" && kind != "icmp" && !strings.Contains(h.Target, "://") {
+			h.Target = kind + "://" + h.Target
+		}
+		for _, tag := range strings.Split(field("tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				h.Tags = append(h.Tags, tag)
+			}
+		}
+		line, _ := cr.FieldPos(0)
+		if err := (HostMeta{Icon: h.Icon}).validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		hosts = append(hosts, h)
+	}
+}
+
+// importHostsFile reads /etc/hosts style lines: an address followed by
+// names. The first name becomes the display name. Loopback, multicast and
+// unspecified addresses are skipped.
+func importHostsFile(r io.Reader) ([]ImportedHost, error) {
+	var hosts []ImportedHost
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, fields[0])
+		}
+		if ip.IsLoopback() || ip.IsMulticast() || ip.IsUnspecified() {
+			continue
+		}
+		h := ImportedHost{Target: fields[0]}
+		if len(fields) > 1 {
+			h.Name = fields[1]
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, scanner.Err()
+}
+
+// importNagios reads the host definitions of a Nagios object file:
+//
+//	define host {
+//	    host_name   core-rtr
+//	    alias       Core router
+//	    address     192.168.1.1
+//	    hostgroups  network,prod
+//	}
+//
+// Templates (register 0) are skipped and not applied to hosts using them.
+func importNagios(r io.Reader) ([]ImportedHost, error) {
+	var hosts []ImportedHost
+	var def map[string]string // directives of the host definition being read
+	inDefine := false
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(nagiosComment(scanner.Text()))
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "define"):
+			if inDefine {
+				return nil, fmt.Errorf("line %d: define inside a definition", line)
+			}
+			inDefine = true
+			kind := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "define"), "{"))
+			def = nil
+			if kind == "host" {
+				def = map[string]string{}
+			}
+		case text == "}":
+			if !inDefine {
+				return nil, fmt.Errorf("line %d: unexpected }", line)
+			}
+			inDefine = false
+			if def == nil || def["register"] == "0" {
+				continue
+			}
+			h := ImportedHost{Target: def["address"], Name: def["alias"], Notes: def["notes"]}
+			if h.Target == "" {
+				h.Target = def["host_name"]
+			}
+			if h.Name == "" && h.Target != def["host_name"] {
+				h.Name = def["host_name"]
+			}
+			if h.Target == "" {
+				return nil, fmt.Errorf("line %d: host without host_name or address", line)
+			}
+			h.Tags = splitList(def["hostgroups"])
+			hosts = append(hosts, h)
+		case inDefine && def != nil:
+			key := strings.Fields(text)[0]
+			def[key] = strings.TrimSpace(strings.TrimPrefix(text, key))
+		}
+	}
+	if inDefine {
+		return nil, errors.New("unterminated definition")
+	}
+	return hosts, scanner.Err()
+}
+
+// nagiosComment strips a comment: a line starting with # or text after an
+// unescaped semicolon.
+func nagiosComment(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return ""
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] == ';' && (i == 0 || line[i-1] != '\\') {
+			return line[:i]
+		}
+	}
+	return strings.ReplaceAll(line, `\;`, ";")
+}
+
+// runImport implements "netmonitor import", printing the hosts of a file
+// as a hosts section for the config file.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "Format of the file: csv, hosts or nagios (guessed from the file name if empty)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: netmonitor import [-format csv|hosts|nagios] FILE\n\n")
+		fmt.Fprintf(flags.Output(), "Prints the hosts of FILE as a hosts section for the config file.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = importFormat(path)
+	}
+	importer, ok := importers[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q, use -format csv, hosts or nagios", *format)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hosts, err := importer(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	out := yaml.NewEncoder(os.Stdout)
+	out.SetIndent(2)
+	return out.Encode(struct {
+		Hosts []ImportedHost `yaml:"hosts"`
+	}{hosts})
+}
+
+// handleImport serves POST /api/import?format=csv|hosts|nagios, converting
+// the host list in the body into hosts entries.
+func (m *Monitor) handleImport(w http.ResponseWriter, r *http.Request) {
+	importer, ok := importers[r.URL.Query().Get("format")]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "format must be csv, hosts or nagios")
+		return
+	}
+	hosts, err := importer(http.MaxBytesReader(w, r.Body, 10<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hosts == nil {
+		hosts = []ImportedHost{}
+	}
+	writeJSON(w, http.StatusOK, hosts)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []ImportedHost
+		err  string
+	}{
+		{"columns", "Host,Display_Name,Icon,Tags,Notes\n192.0.2.1,Core router,router,prod; network,Rack 4\n",
+			[]ImportedHost{{Target: "192.0.2.1", Name: "Core router", Icon: "router", Tags: []string{"prod", "network"}, Notes: "Rack 4"}}, ""},
+		{"types", "ip,probe\n192.0.2.1,icmp\n192.0.2.53,dns\nhttps://example.com,http\n",
+			[]ImportedHost{{Target: "192.0.2.1"}, {Target: "dns://192.0.2.53"}, {Target: "https://example.com"}}, ""},
+		{"short rows and blank targets", "name,address,notes\nfirst,192.0.2.1\nsecond,,skipped\n",
+			[]ImportedHost{{Target: "192.0.2.1", Name: "first"}}, ""},
+		{"first column wins", "target,host\n192.0.2.1,192.0.2.2\n", []ImportedHost{{Target: "192.0.2.1"}}, ""},
+		{"header only", "host\n", nil, ""},
+		{"no target column", "name,notes\nrouter,rack 4\n", nil, "no target, host, address or ip column"},
+		{"unknown icon", "host,icon\n192.0.2.1,router\n192.0.2.2,toaster\n", nil, `line 3: unknown icon "toaster"`},
+		{"empty", "", nil, "reading header"},
+		{"bad quoting", "host\n\"192.0.2.1\n", nil, "extraneous or missing"},
+	}
+	for _, tt := range tests {
+		got, err := importCSV(strings.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportHostsFile(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []ImportedHost
+		err  string
+	}{
+		{"hosts", "127.0.0.1 localhost\n::1 localhost ip6-localhost\n\n# LAN\n192.168.1.1\trouter router.lan # gateway\n2001:db8::10 nas\n192.168.1.20\n",
+			[]ImportedHost{{Target: "192.168.1.1", Name: "router"}, {Target: "2001:db8::10", Name: "nas"}, {Target: "192.168.1.20"}}, ""},
+		{"multicast and unspecified", "ff02::1 ip6-allnodes\n0.0.0.0 blocked.example\n", nil, ""},
+		{"invalid address", "192.168.1.1 router\nrouter 192.168.1.1\n", nil, `line 2: invalid address "router"`},
+	}
+	for _, tt := range tests {
+		got, err := importHostsFile(strings.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportNagios(t *testing.T) {
+	const objects = `# Generated by NagiosQL
+define host {
+    name                generic-host    ; the template
+    register            0
+}
+
+define host{
+    use                 generic-host
+    host_name           core-rtr
+    alias               Core router
+    address             192.168.1.1
+    hostgroups          network,prod
+    notes               Rack 4\; top
+}
+
+define service {
+    host_name           core-rtr
+    address             192.168.1.99
+}
+
+define host {
+    host_name           nas.lan
+}
+`
+	tests := []struct {
+		name string
+		in   string
+		want []ImportedHost
+		err  string
+	}{
+		{"objects", objects, []ImportedHost{
+			{Target: "192.168.1.1", Name: "Core router", Notes: "Rack 4; top", Tags: []string{"network", "prod"}},
+			{Target: "nas.lan"},
+		}, ""},
+		{"name without alias", "define host {\n host_name web1\n address 192.0.2.80\n}\n",
+			[]ImportedHost{{Target: "192.0.2.80", Name: "web1"}}, ""},
+		{"no address", "define host {\n alias Nothing\n}\n", nil, "line 3: host without host_name or address"},
+		{"nested", "define host {\ndefine host {\n", nil, "line 2: define inside a definition"},
+		{"stray brace", "}\n", nil, "line 1: unexpected }"},
+		{"unterminated", "define host {\n host_name web1\n", nil, "unterminated definition"},
+	}
+	for _, tt := range tests {
+		got, err := importNagios(strings.NewReader(tt.in))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %+v, %v; want %+v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportFormat(t *testing.T) {
+	for path, want := range map[string]string{
+		"hosts.CSV":                   "csv",
+		"/etc/nagios/objects/lan.cfg": "nagios",
+		"/etc/hosts":                  "hosts",
+		"hosts.txt":                   "",
+	} {
+		if got := importFormat(path); got != want {
+			t.Errorf("%s: %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestHandleImport(t *testing.T) {
+	m := NewMonitor(nil, 0, time.Second)
+	m.config = &Config{}
+	tests := []struct {
+		query string
+		body  string
+		code  int
+		want  string
+	}{
+		{"?format=hosts", "192.0.2.1 router\n", http.StatusOK, `[{"target":"192.0.2.1","name":"router"}]`},
+		{"?format=hosts", "127.0.0.1 localhost\n", http.StatusOK, `[]`},
+		{"?format=csv", "name\nrouter\n", http.StatusBadRequest, ""},
+		{"?format=xlsx", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		m.handleImport(w, httptest.NewRequest("POST", "/api/import"+tt.query, strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s %q: %d %s, want %d", tt.query, tt.body, w.Code, w.Body, tt.code)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		var got, want any
+		json.Unmarshal(w.Body.Bytes(), &got)
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s %q: %s, want %s", tt.query, tt.body, w.Body, tt.want)
+		}
+	}
+}
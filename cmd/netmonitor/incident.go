@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Incident tracks one outage of a host from the first alert until it is up
+// again. Acknowledging an incident silences its reminders and intermediate
+// status changes; the recovery is always announced.
+type Incident struct {
+	ID             int            `json:"id"`
+	Host           string         `json:"host"`
+	Status         string         `json:"status"`
+	Severity       string         `json:"severity"`
+	Message        string         `json:"message,omitempty"`
+	Opened         time.Time      `json:"opened"`
+	Resolved       time.Time      `json:"resolved,omitzero"`
+	Acknowledged   bool           `json:"acknowledged"`
+	AcknowledgedBy string         `json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt time.Time      `json:"acknowledgedAt,omitzero"`
+	Notes          []IncidentNote `json:"notes"`
+
+	lastNotified time.Time
+	alert        Alert                 // the last one, for escalations
+	escalations  []*incidentEscalation // set by routing its first alert
+}
+
+type IncidentNote struct {
+	Time   time.Time `json:"time"`
+	Author string    `json:"author,omitempty"`
+	Text   string    `json:"text"`
+}
+
+// maxIncidents bounds how many incidents, open or resolved, are kept.
+const maxIncidents = 1000
+
+var errNoIncident = errors.New("no such incident")
+
+type incidentLog struct {
+	mu     sync.Mutex
+	nextID int
+	open   map[string]*Incident // by host
+	all    []*Incident          // oldest first
+	repeat time.Duration        // reminder interval for open incidents, 0 disables
+}
+
+func newIncidentLog(repeat time.Duration) *incidentLog {
+	return &incidentLog{open: make(map[string]*Incident), repeat: repeat}
+}
+
+// record opens, updates or resolves the incident of the alert's host and
+// reports whether the alert should be sent.
+func (l *incidentLog) record(a *Alert) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.open[a.Host]
+	if a.Status == "up" {
+		if inc == nil {
+			return true
+		}
+		inc.Status = a.Status
+		inc.Resolved = a.Time
+		delete(l.open, a.Host)
+		a.IncidentID = inc.ID
+		return true
+	}
+
+	if inc == nil {
+		l.nextID++
+		inc = &Incident{ID: l.nextID, Host: a.Host, Opened: a.Time, Notes: []IncidentNote{}}
+		l.open[a.Host] = inc
+		l.all = append(l.all, inc)
+		if len(l.all) > maxIncidents {
+			l.all = l.all[len(l.all)-maxIncidents:]
+		}
+	}
+	inc.Status = a.Status
+	inc.Severity = a.Severity
+	inc.Message = a.Message
+	a.IncidentID = inc.ID
+	inc.alert = *a
+	if inc.Acknowledged {
+		return false
+	}
+	inc.lastNotified = a.Time
+	return true
+}
+
+// reminderDue reports whether the open incident of host has gone without a
+// notification for longer than the repeat interval.
+func (l *incidentLog) reminderDue(host string, now time.Time) bool {
+	if l.repeat <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.open[host]
+	return inc != nil && !inc.Acknowledged && now.Sub(inc.lastNotified) >= l.repeat
+}
+
+func (l *incidentLog) isOpen(host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open[host] != nil
+}
+
+// restore loads the incidents of a restored backup from dir, once: the
+// file is removed after. Incidents still open carry on, acknowledged or
+// not, until their host is up, with reminders counting from now.
+func (l *incidentLog) restore(dir string, now time.Time) error {
+	path := filepath.Join(dir, incidentsFile)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var incidents []Incident // newest first
+	if err := json.Unmarshal(b, &incidents); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	for i := len(incidents) - 1; i >= 0; i-- {
+		inc := incidents[i]
+		inc.lastNotified = now
+		l.all = append(l.all, &inc)
+		if inc.Resolved.IsZero() {
+			l.open[inc.Host] = &inc
+		}
+		l.nextID = max(l.nextID, inc.ID)
+	}
+	if len(l.all) > maxIncidents {
+		l.all = l.all[len(l.all)-maxIncidents:]
+	}
+	l.mu.Unlock()
+	log.Printf("Restored %d incidents", len(incidents))
+	return os.Remove(path)
+}
+
+func (l *incidentLog) find(id int) *Incident {
+	for _, inc := range l.all {
+		if inc.ID == id {
+			return inc
+		}
+	}
+	return nil
+}
+
+func (l *incidentLog) acknowledge(id int, by, note string, now time.Time) (Incident, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.find(id)
+	if inc == nil {
+		return Incident{}, errNoIncident
+	}
+	if !inc.Acknowledged {
+		inc.Acknowledged = true
+		inc.AcknowledgedBy = by
+		inc.AcknowledgedAt = now
+	}
+	if note != "" {
+		inc.Notes = append(inc.Notes, IncidentNote{Time: now, Author: by, Text: note})
+	}
+	return inc.snapshot(), nil
+}
+
+// ackResult is what became of an acknowledgement made elsewhere.
+type ackResult int
+
+const (
+	ackIgnored ackResult = iota // the incident was acknowledged already
+	ackApplied
+	ackStale // no incident is open, or only one opened since
+)
+
+// acknowledgeShared applies an acknowledgement made on another instance to
+// the open incident of host.
+func (l *incidentLog) acknowledgeShared(host string, ack sharedAck) ackResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.open[host]
+	switch {
+	case inc == nil || inc.Opened.After(ack.Time):
+		return ackStale
+	case inc.Acknowledged:
+		return ackIgnored
+	}
+	inc.Acknowledged = true
+	inc.AcknowledgedBy = ack.By
+	inc.AcknowledgedAt = ack.Time
+	if ack.Note != "" {
+		inc.Notes = append(inc.Notes, IncidentNote{Time: ack.Time, Author: ack.By, Text: ack.Note})
+	}
+	return ackApplied
+}
+
+func (l *incidentLog) addNote(id int, author, text string, now time.Time) (Incident, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inc := l.find(id)
+	if inc == nil {
+		return Incident{}, errNoIncident
+	}
+	inc.Notes = append(inc.Notes, IncidentNote{Time: now, Author: author, Text: text})
+	return inc.snapshot(), nil
+}
+
+// list returns the incidents newest first, optionally only the open ones.
+func (l *incidentLog) list(openOnly bool) []Incident {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]Incident, 0, len(l.all))
+	for i := len(l.all) - 1; i >= 0; i-- {
+		inc := l.all[i]
+		if openOnly && !inc.Resolved.IsZero() {
+			continue
+		}
+		result = append(result, inc.snapshot())
+	}
+	return result
+}
+
+// snapshot copies an incident so it can be encoded outside the lock.
+func (inc *Incident) snapshot() Incident {
+	c := *inc
+	c.Notes = append([]IncidentNote{}, inc.Notes...)
+	return c
+}
+
+// HTTP API
+
+func (m *Monitor) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	openOnly := r.URL.Query().Get("open") == "true"
+	writeJSON(w, http.StatusOK, m.incidents.list(openOnly))
+}
+
+type incidentRequest struct {
+	By   string `json:"by"`
+	Note string `json:"note"`
+}
+
+func (m *Monitor) handleIncidentAck(w http.ResponseWriter, r *http.Request) {
+	m.updateIncident(w, r, "incident.acknowledge", func(id int, req incidentRequest) (Incident, error) {
+		inc, err := m.incidents.acknowledge(id, req.By, req.Note, m.clock.Now())
+		if err == nil {
+			m.ha.shareAck(inc, req.Note)
+		}
+		return inc, err
+	})
+}
+
+func (m *Monitor) handleIncidentNote(w http.ResponseWriter, r *http.Request) {
+	m.updateIncident(w, r, "incident.note", func(id int, req incidentRequest) (Incident, error) {
+		if strings.TrimSpace(req.Note) == "" {
+			return Incident{}, errors.New("note must not be empty")
+		}
+		return m.incidents.addNote(id, req.By, req.Note, m.clock.Now())
+	})
+}
+
+// updateIncident decodes the incident id and request body shared by the
+// acknowledge and note endpoints.
+func (m *Monitor) updateIncident(w http.ResponseWriter, r *http.Request, action string, update func(int, incidentRequest) (Incident, error)) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid incident id")
+		return
+	}
+	var req incidentRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+	}
+	if u := userFrom(r.Context()); u != nil {
+		req.By = u.Name
+	}
+
+	inc, err := update(id, req)
+	switch {
+	case errors.Is(err, errNoIncident):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		m.auditRequest(r, action, fmt.Sprintf("incident %d (%s)", inc.ID, inc.Host), nil, req)
+		writeJSON(w, http.StatusOK, inc)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// iperf3 targets run throughput tests against an iperf3 server (iperf3 -s):
+//
+//	iperf3://iperf.lan
+//	iperf3://iperf.lan:5201?udp=true&bandwidth=50M&max_loss=1&max_jitter=30ms
+//
+// By default netmonitor sends TCP to the server for time (10s) over
+// parallel streams (1); reverse=true has the server send instead, and
+// udp=true sends UDP at bandwidth bits per second (1M). Tests run once per
+// refresh (an hour) and report the bandwidth the receiver saw, TCP
+// retransmits (where the sender can tell) and UDP jitter and loss. The host
+// is degraded below min_bandwidth (in Mbit/s) or above max_loss (in
+// percent) or max_jitter.
+func init() {
+	probers["iperf3"] = every("1h", probeIperf)
+	probeDurations["iperf3"] = func(t *target) time.Duration {
+		duration, _ := time.ParseDuration(t.param("time", "10s"))
+		return duration + 4*t.timeout()
+	}
+}
+
+// iperf3 control connection states, see iperf_api.h.
+const (
+	iperfTestStart       = 1
+	iperfTestRunning     = 2
+	iperfTestEnd         = 4
+	iperfParamExchange   = 9
+	iperfCreateStreams   = 10
+	iperfServerTerminate = 11
+	iperfExchangeResults = 13
+	iperfDisplayResults  = 14
+	iperfDone            = 16
+	iperfAccessDenied    = -1
+	iperfServerError     = -2
+)
+
+const (
+	iperfCookieSize  = 37
+	iperfTCPBlock    = 128 << 10
+	iperfUDPBlock    = 1460
+	iperfUDPConnect  = 0x36373839 // "6789" in the server's byte order
+	iperfUDPReply    = 0x39383736
+	iperfLegacyReply = 987654321
+)
+
+// iperfParams are the test parameters the client sends.
+type iperfParams struct {
+	TCP           bool   `json:"tcp,omitempty"`
+	UDP           bool   `json:"udp,omitempty"`
+	Omit          int    `json:"omit"`
+	Time          int    `json:"time"`
+	Parallel      int    `json:"parallel"`
+	Reverse       bool   `json:"reverse,omitempty"`
+	Len           int    `json:"len"`
+	Bandwidth     uint64 `json:"bandwidth,omitempty"`
+	UDPCounters64 int    `json:"udp_counters_64bit,omitempty"`
+	ClientVersion string `json:"client_version"`
+}
+
+// iperfResults are what each side measured, exchanged at the end.
+type iperfResults struct {
+	CPUUtilTotal         float64             `json:"cpu_util_total"`
+	CPUUtilUser          float64             `json:"cpu_util_user"`
+	CPUUtilSystem        float64             `json:"cpu_util_system"`
+	SenderHasRetransmits int                 `json:"sender_has_retransmits"`
+	Streams              []iperfStreamResult `json:"streams"`
+}
+
+type iperfStreamResult struct {
+	ID          int     `json:"id"`
+	Bytes       uint64  `json:"bytes"`
+	Retransmits int     `json:"retransmits"`
+	Jitter      float64 `json:"jitter"` // seconds
+	Errors      int     `json:"errors"` // lost datagrams
+	Packets     int     `json:"packets"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time"`
+}
+
+// iperfStream is one data connection and what this side measured on it.
+type iperfStream struct {
+	id   int
+	conn net.Conn
+
+	bytes       atomic.Uint64
+	packets     int
+	errors      int
+	jitter      float64 // seconds
+	retransmits int
+}
+
+// iperfTest is a client's run against a server.
+type iperfTest struct {
+	t       *target
+	params  iperfParams
+	cookie  []byte
+	ctrl    net.Conn
+	streams []*iperfStream
+}
+
+func probeIperf(t *target) (probeResult, error) {
+	duration, err := time.ParseDuration(t.param("time", "10s"))
+	if err != nil || duration < time.Second {
+		return probeResult{}, fmt.Errorf("invalid time %q", t.param("time", ""))
+	}
+	parallel, err := strconv.Atoi(t.param("parallel", "1"))
+	if err != nil || parallel < 1 || parallel > 128 {
+		return probeResult{}, fmt.Errorf("invalid parallel %q", t.param("parallel", ""))
+	}
+	test := &iperfTest{t: t, params: iperfParams{
+		Time:          int(duration.Round(time.Second) / time.Second),
+		Parallel:      parallel,
+		Reverse:       t.param("reverse", "false") == "true",
+		Len:           iperfTCPBlock,
+		ClientVersion: "3.16",
+	}}
+	if t.param("udp", "false") == "true" {
+		bandwidth, err := parseBandwidth(t.param("bandwidth", "1M"))
+		if err != nil {
+			return probeResult{}, err
+		}
+		test.params.UDP, test.params.Len, test.params.Bandwidth, test.params.UDPCounters64 = true, iperfUDPBlock, bandwidth, 1
+	} else {
+		test.params.TCP = true
+	}
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	test.cookie = make([]byte, iperfCookieSize)
+	rand.Read(test.cookie[:iperfCookieSize-1])
+	for i := range iperfCookieSize - 1 {
+		test.cookie[i] = alphabet[int(test.cookie[i])%len(alphabet)]
+	}
+	test.cookie[iperfCookieSize-1] = 0
+
+	start := time.Now()
+	if test.ctrl, err = t.dial("tcp", t.hostPort("5201")); err != nil {
+		return probeResult{}, err
+	}
+	defer test.ctrl.Close()
+	latency := msSince(start)
+	defer func() {
+		for _, s := range test.streams {
+			s.conn.Close()
+		}
+	}()
+	server, err := test.run(duration)
+	if err != nil {
+		return probeResult{}, err
+	}
+	return test.result(latency, duration, server)
+}
+
+// parseBandwidth reads a rate in bits per second such as 50M or 1G.
+func parseBandwidth(s string) (uint64, error) {
+	mult := 1.0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1e3
+	case "M":
+		mult = 1e6
+	case "G":
+		mult = 1e9
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return uint64(v * mult), nil
+}
+
+// run goes through the test's states until the server has sent its
+// results.
+func (test *iperfTest) run(duration time.Duration) (*iperfResults, error) {
+	ctrl := test.ctrl
+	ctrl.SetDeadline(time.Now().Add(duration + 4*test.t.timeout()))
+	if _, err := ctrl.Write(test.cookie); err != nil {
+		return nil, err
+	}
+	for {
+		state, err := test.readState()
+		if err != nil {
+			return nil, err
+		}
+		switch state {
+		case iperfParamExchange:
+			if err := iperfWriteJSON(ctrl, test.params); err != nil {
+				return nil, err
+			}
+		case iperfCreateStreams:
+			for i := range test.params.Parallel {
+				s, err := test.connectStream(i)
+				if err != nil {
+					return nil, fmt.Errorf("creating stream: %v", err)
+				}
+				test.streams = append(test.streams, s)
+			}
+		case iperfTestStart:
+		case iperfTestRunning:
+			// The client keeps the time and ends the test.
+			var wg sync.WaitGroup
+			stop := make(chan struct{})
+			for _, s := range test.streams {
+				wg.Go(func() { test.transfer(s, stop) })
+			}
+			time.Sleep(duration)
+			close(stop)
+			wg.Wait()
+			for _, s := range test.streams {
+				s.retransmits = tcpRetransmits(s.conn)
+			}
+			if _, err := ctrl.Write([]byte{iperfTestEnd}); err != nil {
+				return nil, err
+			}
+		case iperfExchangeResults:
+			if err := iperfWriteJSON(ctrl, test.ownResults(duration)); err != nil {
+				return nil, err
+			}
+			var server iperfResults
+			if err := iperfReadJSON(ctrl, &server); err != nil {
+				return nil, fmt.Errorf("reading results: %v", err)
+			}
+			// The server goes on to DISPLAY_RESULTS and waits for
+			// IPERF_DONE.
+			if state, err := test.readState(); err == nil && state == iperfDisplayResults {
+				ctrl.Write([]byte{iperfDone})
+			}
+			return &server, nil
+		case iperfAccessDenied:
+			return nil, errors.New("iperf3 server is busy running another test")
+		case iperfServerError:
+			var codes [8]byte
+			io.ReadFull(ctrl, codes[:])
+			return nil, fmt.Errorf("iperf3 server error %d (errno %d)", int32(binary.BigEndian.Uint32(codes[:4])), int32(binary.BigEndian.Uint32(codes[4:])))
+		case iperfServerTerminate:
+			return nil, errors.New("iperf3 server terminated the test")
+		default:
+			return nil, fmt.Errorf("unexpected iperf3 state %d", state)
+		}
+	}
+}
+
+func (test *iperfTest) readState() (int8, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(test.ctrl, b[:]); err != nil {
+		return 0, err
+	}
+	return int8(b[0]), nil
+}
+
+// iperfStreamID numbers streams the way iperf3 does: 1, 3, 4, 5...
+func iperfStreamID(i int) int {
+	if i == 0 {
+		return 1
+	}
+	return i + 2
+}
+
+// connectStream opens a data connection. TCP streams identify themselves
+// with the cookie; UDP ones with a datagram the server answers.
+func (test *iperfTest) connectStream(i int) (*iperfStream, error) {
+	network := "tcp"
+	if test.params.UDP {
+		network = "udp"
+	}
+	conn, err := test.t.dial(network, test.t.hostPort("5201"))
+	if err != nil {
+		return nil, err
+	}
+	s := &iperfStream{id: iperfStreamID(i), conn: conn}
+	if !test.params.UDP {
+		if _, err := conn.Write(test.cookie); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return s, nil
+	}
+	msg := binary.LittleEndian.AppendUint32(nil, iperfUDPConnect)
+	if _, err := conn.Write(msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(test.t.timeout()))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no reply to UDP connect: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		if v := order.Uint32(reply); v == iperfUDPReply || v == iperfLegacyReply {
+			return s, nil
+		}
+	}
+	conn.Close()
+	return nil, fmt.Errorf("unexpected UDP connect reply %x", reply)
+}
+
+// transfer sends or receives on a stream until stop is closed.
+func (test *iperfTest) transfer(s *iperfStream, stop chan struct{}) {
+	go func() {
+		<-stop
+		// Unblock reads and writes in progress.
+		s.conn.SetDeadline(time.Now())
+	}()
+	switch {
+	case test.params.Reverse && test.params.UDP:
+		test.receiveUDP(s)
+	case test.params.Reverse:
+		buf := make([]byte, iperfTCPBlock)
+		for {
+			n, err := s.conn.Read(buf)
+			s.bytes.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	case test.params.UDP:
+		test.sendUDP(s, stop)
+	default:
+		buf := make([]byte, iperfTCPBlock)
+		rand.Read(buf)
+		for {
+			n, err := s.conn.Write(buf)
+			s.bytes.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendUDP paces datagrams to the stream's share of the bandwidth. Each
+// starts with the time it was sent and a 64-bit sequence number.
+func (test *iperfTest) sendUDP(s *iperfStream, stop chan struct{}) {
+	rate := float64(test.params.Bandwidth) / float64(test.params.Parallel) / 8 // bytes per second
+	buf := make([]byte, test.params.Len)
+	start := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if ahead := float64(s.bytes.Load())/rate - time.Since(start).Seconds(); ahead > 0 {
+			time.Sleep(time.Duration(math.Min(ahead, 0.01) * float64(time.Second)))
+			continue
+		}
+		now := time.Now()
+		s.packets++
+		binary.BigEndian.PutUint32(buf[0:], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(buf[4:], uint32(now.Nanosecond()/1000))
+		binary.BigEndian.PutUint64(buf[8:], uint64(s.packets))
+		n, err := s.conn.Write(buf)
+		if err != nil {
+			return
+		}
+		s.bytes.Add(uint64(n))
+	}
+}
+
+// receiveUDP counts the server's datagrams, working out loss from gaps in
+// their sequence numbers and jitter as in RFC 1889.
+func (test *iperfTest) receiveUDP(s *iperfStream) {
+	buf := make([]byte, 65536)
+	var (
+		maxSeq      uint64
+		lastTransit float64
+	)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if n < 16 {
+			continue
+		}
+		s.bytes.Add(uint64(n))
+		s.packets++
+		sent := time.Unix(int64(binary.BigEndian.Uint32(buf[0:])), int64(binary.BigEndian.Uint32(buf[4:]))*1000)
+		seq := binary.BigEndian.Uint64(buf[8:])
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+		transit := time.Since(sent).Seconds()
+		if s.packets > 1 {
+			s.jitter += (math.Abs(transit-lastTransit) - s.jitter) / 16
+		}
+		lastTransit = transit
+	}
+	if lost := int(maxSeq) - s.packets; lost > 0 {
+		s.errors = lost
+	}
+}
+
+// ownResults reports what this side measured.
+func (test *iperfTest) ownResults(duration time.Duration) iperfResults {
+	r := iperfResults{Streams: []iperfStreamResult{}}
+	if tcpRetransmitsSupported && test.params.TCP && !test.params.Reverse {
+		r.SenderHasRetransmits = 1
+	}
+	for _, s := range test.streams {
+		r.Streams = append(r.Streams, iperfStreamResult{
+			ID:          s.id,
+			Bytes:       s.bytes.Load(),
+			Retransmits: s.retransmits,
+			Jitter:      s.jitter,
+			Errors:      s.errors,
+			Packets:     s.packets,
+			EndTime:     duration.Seconds(),
+		})
+	}
+	return r
+}
+
+// result turns the measurements of the receiving side, and the
+// retransmits of the sending one, into metrics.
+func (test *iperfTest) result(latency float64, duration time.Duration, server *iperfResults) (probeResult, error) {
+	own := test.ownResults(duration)
+	receiver, sender := server, &own
+	if test.params.Reverse {
+		receiver, sender = &own, server
+	}
+	var (
+		bytes           uint64
+		packets, lost   int
+		jitter          float64
+		retransmits     int
+		haveRetransmits = sender.SenderHasRetransmits == 1
+		receivedStreams = len(receiver.Streams)
+	)
+	if receivedStreams == 0 {
+		return probeResult{}, errors.New("iperf3 results have no streams")
+	}
+	for _, s := range receiver.Streams {
+		bytes += s.Bytes
+		packets += s.Packets
+		lost += s.Errors
+		jitter += s.Jitter / float64(receivedStreams)
+	}
+	for _, s := range sender.Streams {
+		retransmits += max(s.Retransmits, 0)
+	}
+	mbps := float64(bytes) * 8 / duration.Seconds() / 1e6
+	result := probeResult{
+		Latency:     latency,
+		Metrics:     map[string]float64{"bandwidth_mbps": mbps},
+		KeepMetrics: true,
+	}
+	if test.params.TCP && haveRetransmits {
+		result.Metrics["retransmits"] = float64(retransmits)
+	}
+	loss := 0.0
+	if test.params.UDP {
+		if total := packets + lost; total > 0 {
+			loss = float64(lost) / float64(total) * 100
+		}
+		result.Metrics["jitter_ms"] = jitter * 1000
+		result.Metrics["loss_percent"] = loss
+	}
+
+	var problems []string
+	if limit, _ := strconv.ParseFloat(test.t.param("min_bandwidth", "0"), 64); mbps < limit {
+		problems = append(problems, fmt.Sprintf("bandwidth %.1f Mbit/s is below %g", mbps, limit))
+	}
+	if s := test.t.param("max_loss", ""); s != "" && test.params.UDP {
+		if limit, err := strconv.ParseFloat(s, 64); err == nil && loss > limit {
+			problems = append(problems, fmt.Sprintf("loss %.2f%% is above %g%%", loss, limit))
+		}
+	}
+	if s := test.t.param("max_jitter", ""); s != "" && test.params.UDP {
+		if limit, err := time.ParseDuration(s); err == nil && jitter > limit.Seconds() {
+			problems = append(problems, fmt.Sprintf("jitter %.2f ms is above %v", jitter*1000, limit))
+		}
+	}
+	result.Warning = strings.Join(problems, ", ")
+	return result, nil
+}
+
+// iperfWriteJSON sends a message prefixed with its length, as iperf3's
+// JSON_write does.
+func iperfWriteJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(b))), b...))
+	return err
+}
+
+func iperfReadJSON(r io.Reader, v any) error {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > 1<<20 {
+		return fmt.Errorf("message of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// tcpRetransmitsSupported tells whether tcpRetransmits can count.
+const tcpRetransmitsSupported = true
+
+// tcpRetransmits returns how many segments the kernel retransmitted on a
+// TCP connection, or -1 for other connections.
+func tcpRetransmits(conn net.Conn) int {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return -1
+	}
+	rc, err := tcp.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	retransmits := -1
+	rc.Control(func(fd uintptr) {
+		if info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO); err == nil {
+			retransmits = int(info.Total_retrans)
+		}
+	})
+	return retransmits
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+const tcpRetransmitsSupported = false
+
+func tcpRetransmits(conn net.Conn) int {
+	return -1
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kafkaRecordsOf decodes a v2 record batch, checking its length and CRC.
+func kafkaRecordsOf(t *testing.T, batch []byte) []kafkaRecord {
+	t.Helper()
+	d := &kafkaDecoder{b: batch}
+	d.int64() // base offset
+	if n := d.int32(); int(n) != len(batch)-12 {
+		t.Fatalf("batch length %d, have %d bytes", n, len(batch)-12)
+	}
+	d.int32() // partition leader epoch
+	if magic := d.int8(); magic != 2 {
+		t.Fatalf("magic %d", magic)
+	}
+	if crc := uint32(d.int32()); crc != crc32.Checksum(d.b, castagnoli) {
+		t.Fatalf("CRC %#x does not match", crc)
+	}
+	d.int16() // attributes
+	lastDelta := d.int32()
+	first := d.int64()
+	d.int64()     // max timestamp
+	d.next(8 + 2) // producer id and epoch
+	d.int32()     // base sequence
+	count := d.int32()
+	if d.err != nil || lastDelta != count-1 {
+		t.Fatalf("batch of %d records, last offset delta %d: %v", count, lastDelta, d.err)
+	}
+
+	varint := func(b []byte) (int64, []byte) {
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			t.Fatal("truncated record")
+		}
+		return v, b[n:]
+	}
+	var records []kafkaRecord
+	b := d.b
+	for i := range count {
+		var size, delta, offset, n int64
+		size, b = varint(b)
+		rest := b[size:]
+		b = b[1:] // attributes
+		delta, b = varint(b)
+		offset, b = varint(b)
+		if offset != int64(i) {
+			t.Errorf("record %d has offset delta %d", i, offset)
+		}
+		n, b = varint(b)
+		key := b[:n]
+		n, b = varint(b[n:])
+		value := b[:n]
+		records = append(records, kafkaRecord{key, value, time.UnixMilli(first + delta)})
+		b = rest
+	}
+	if len(b) != 0 {
+		t.Errorf("%d bytes after the records", len(b))
+	}
+	return records
+}
+
+func TestKafkaRecordBatch(t *testing.T) {
+	start := time.UnixMilli(1767225600000)
+	tests := []struct {
+		name    string
+		records []kafkaRecord
+	}{
+		{"one", []kafkaRecord{{[]byte("a"), []byte(`{"status":"up"}`), start}}},
+		{"out of order", []kafkaRecord{
+			{[]byte("a"), []byte("1"), start.Add(2 * time.Second)},
+			{[]byte("b"), []byte("2"), start},
+			{[]byte(""), bytes.Repeat([]byte("x"), 300), start.Add(time.Second)},
+		}},
+	}
+	for _, tt := range tests {
+		batch := kafkaRecordBatch(tt.records)
+		got := kafkaRecordsOf(t, batch)
+		equal := func(a, b kafkaRecord) bool {
+			return bytes.Equal(a.key, b.key) && bytes.Equal(a.value, b.value) && a.time.Equal(b.time)
+		}
+		if !slices.EqualFunc(got, tt.records, equal) {
+			t.Errorf("%s: decoded %v, want %v", tt.name, got, tt.records)
+		}
+		// The max timestamp is the latest, not the last.
+		maxTime := time.UnixMilli(int64(binary.BigEndian.Uint64(batch[35:])))
+		latest := slices.MaxFunc(tt.records, func(a, b kafkaRecord) int { return a.time.Compare(b.time) }).time
+		if !maxTime.Equal(latest) {
+			t.Errorf("%s: max timestamp %v, want %v", tt.name, maxTime, latest)
+		}
+	}
+}
+
+func TestKafkaDecoder(t *testing.T) {
+	var e kafkaEncoder
+	e.int8(-1)
+	e.int16(-2)
+	e.int32(-3)
+	e.int64(-4)
+	e.string("topic")
+	e.int16(-1) // null string
+	e.int32(2)
+	e.int32(7)
+	e.int32(8)
+	e.int32(9)
+
+	d := &kafkaDecoder{b: e.b}
+	if d.int8() != -1 || d.int16() != -2 || d.int32() != -3 || d.int64() != -4 || d.string() != "topic" || d.string() != "" {
+		t.Error("fields did not read back")
+	}
+	d.skipInt32Array()
+	if v := d.int32(); v != 9 || d.err != nil || len(d.b) != 0 {
+		t.Errorf("after the array read %d, %v", v, d.err)
+	}
+	// Reading past the end reports a short response and keeps reading zeros.
+	if v := d.int32(); v != 0 || d.err == nil || d.string() != "" {
+		t.Errorf("past the end read %d, %v", v, d.err)
+	}
+}
+
+func TestKafkaError(t *testing.T) {
+	for code, want := range map[int16]string{3: "unknown topic or partition", 58: "SASL authentication failed", 87: "error code 87"} {
+		if err := kafkaError(code); err.Error() != want {
+			t.Errorf("%d: %v, want %q", code, err, want)
+		}
+	}
+}
+
+type kafkaProduced struct {
+	topic     string
+	partition int32
+	batch     []byte
+}
+
+// kafkaBroker answers metadata with itself as broker 1 leading every
+// partition of the topics, SASL/PLAIN logins for password, and hands over
+// the record batches produced. Partitions give the partition count of each topic.
+func kafkaBroker(t *testing.T, password string, partitions map[string]int32, produced chan<- kafkaProduced) func(net.Conn) {
+	return func(conn net.Conn) {
+		host, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+		portNum, _ := strconv.Atoi(port)
+		r := bufio.NewReader(conn)
+		for {
+			var size int32
+			if binary.Read(r, binary.BigEndian, &size) != nil {
+				return
+			}
+			req := make([]byte, size)
+			if _, err := io.ReadFull(r, req); err != nil {
+				return
+			}
+			d := &kafkaDecoder{b: req}
+			api, _ := d.int16(), d.int16()
+			correlation := d.int32()
+			if client := d.string(); client != "netmonitor" {
+				t.Errorf("client id %q", client)
+			}
+
+			var resp kafkaEncoder
+			resp.int32(correlation)
+			switch api {
+			case kafkaSaslHandshake:
+				if mechanism := d.string(); mechanism != "PLAIN" {
+					resp.int16(33) // unsupported mechanism
+				} else {
+					resp.int16(0)
+				}
+				resp.int32(1)
+				resp.string("PLAIN")
+			case kafkaSaslAuthenticate:
+				token := d.next(int(d.int32()))
+				if string(token) == "\x00monitor\x00"+password {
+					resp.int16(0)
+					resp.int16(-1)
+				} else {
+					resp.int16(58)
+					resp.string("Authentication failed: Invalid username or password")
+				}
+				resp.int32(0)
+			case kafkaMetadata:
+				resp.int32(1)
+				resp.int32(1)
+				resp.string(host)
+				resp.int32(int32(portNum))
+				resp.int16(-1)
+				resp.int32(1) // controller
+				count := d.int32()
+				resp.int32(count)
+				for range count {
+					name := d.string()
+					n, ok := partitions[name]
+					if ok {
+						resp.int16(0)
+					} else {
+						resp.int16(3) // unknown topic or partition
+					}
+					resp.string(name)
+					resp.int8(0)
+					resp.int32(n)
+					for i := range n {
+						resp.int16(0)
+						resp.int32(i)
+						resp.int32(1)
+						resp.int32(1)
+						resp.int32(1)
+						resp.int32(1)
+						resp.int32(1)
+					}
+				}
+			case kafkaProduce:
+				d.string() // transactional id
+				if acks := d.int16(); acks != 1 {
+					t.Errorf("acks %d", acks)
+				}
+				d.int32() // timeout
+				count := d.int32()
+				resp.int32(count)
+				for range count {
+					topic := d.string()
+					resp.string(topic)
+					n := d.int32()
+					resp.int32(n)
+					for range n {
+						partition := d.int32()
+						set := d.next(int(d.int32()))
+						produced <- kafkaProduced{topic, partition, set}
+						resp.int32(partition)
+						resp.int16(0)
+						resp.int64(0)
+						resp.int64(-1)
+					}
+				}
+				resp.int32(0) // throttle time
+			default:
+				t.Errorf("unexpected API key %d", api)
+				return
+			}
+			out := binary.BigEndian.AppendUint32(nil, uint32(len(resp.b)))
+			conn.Write(append(out, resp.b...))
+		}
+	}
+}
+
+func TestKafkaExporter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	produced := make(chan kafkaProduced, 10)
+	partitions := map[string]int32{"results": 3, "changes": 1}
+	addr := serveTCP(t, kafkaBroker(t, "", partitions, produced))
+	e, err := parseExporter("kafka://" + addr + "/results?changes=changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.1"}
+	for _, h := range hosts {
+		e.Export(ProbeEvent{Host: h, Type: "icmp", Status: "up", Previous: "up"})
+	}
+	e.Export(ProbeEvent{Host: "192.0.2.2", Type: "icmp", Status: "down", Previous: "up"})
+
+	results := map[string]int{}
+	var changes []kafkaRecord
+	for n := 0; n < 6; {
+		select {
+		case p := <-produced:
+			records := kafkaRecordsOf(t, p.batch)
+			n += len(records)
+			for _, r := range records {
+				var ev ProbeEvent
+				if err := json.Unmarshal(r.value, &ev); err != nil || ev.Host != string(r.key) {
+					t.Errorf("record %s = %s", r.key, r.value)
+				}
+				h := fnv.New32a()
+				h.Write(r.key)
+				if want := int32(h.Sum32() % uint32(partitions[p.topic])); p.partition != want {
+					t.Errorf("%s went to %s partition %d, want %d", r.key, p.topic, p.partition, want)
+				}
+				if p.topic == "changes" {
+					changes = append(changes, r)
+				} else {
+					results[string(r.key)]++
+				}
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("got %d records, want 6", n)
+		}
+	}
+	if want := map[string]int{"192.0.2.1": 2, "192.0.2.2": 2, "192.0.2.3": 1}; !maps.Equal(results, want) {
+		t.Errorf("results %v, want %v", results, want)
+	}
+	if len(changes) != 1 || string(changes[0].key) != "192.0.2.2" {
+		t.Errorf("changes %v", changes)
+	}
+}
+
+func TestKafkaSession(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name string
+		user *url.Userinfo
+		err  string
+	}{
+		{"login", url.UserPassword("monitor", "s3cret"), ""},
+		{"wrong password", url.UserPassword("monitor", "guess"), "Invalid username or password"},
+	}
+	for _, tt := range tests {
+		addr := serveTCP(t, kafkaBroker(t, "s3cret", map[string]int32{"results": 1}, nil))
+		e := &kafkaExporter{brokers: []string{addr}, topic: "results", user: tt.user}
+		c, err := e.dial(addr)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if err := e.metadata(c); err != nil || !slices.Equal(e.partitions["results"], []int32{1}) || e.addrs[1] != addr {
+			t.Errorf("%s: metadata %v %v, %v", tt.name, e.partitions, e.addrs, err)
+		}
+		c.Close()
+	}
+
+	// A topic the broker does not know fails the metadata.
+	addr := serveTCP(t, kafkaBroker(t, "", map[string]int32{"results": 1}, nil))
+	e := &kafkaExporter{brokers: []string{addr}, topic: "results", changes: "missing"}
+	c, err := e.dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := e.metadata(c); err == nil || !strings.Contains(err.Error(), "topic missing: unknown topic") {
+		t.Errorf("missing topic: error %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	discovererFactories["kubernetes"] = newKubernetesDiscoverer
+	discovererFactories["k8s"] = newKubernetesDiscoverer
+}
+
+// kubernetesDiscoverer monitors the pods or services matching a label
+// selector, watching the API server for changes:
+//
+//	kubernetes://?namespace=prod&selector=app%3Dweb
+//	kubernetes://staging-context?kind=services&kubeconfig=/etc/netmonitor/kubeconfig
+//
+// Inside a cluster the pod's service account is used, elsewhere the given
+// kubeconfig, $KUBECONFIG or ~/.kube/config; the URL's host picks a context
+// other than the current one. Pods are monitored by pod IP while running,
+// services by cluster IP.
+type kubernetesDiscoverer struct {
+	client    *kubeClient
+	kind      string // "pods" or "services"
+	namespace string // empty for all
+	selector  string
+	template  hostTemplate
+}
+
+func newKubernetesDiscoverer(u *url.URL) (discoverer, error) {
+	q := u.Query()
+	d := &kubernetesDiscoverer{
+		kind:      cmp.Or(q.Get("kind"), "pods"),
+		namespace: q.Get("namespace"),
+		selector:  q.Get("selector"),
+		template:  newHostTemplate(q),
+	}
+	if d.kind != "pods" && d.kind != "services" {
+		return nil, fmt.Errorf("kind must be pods or services, not %q", d.kind)
+	}
+
+	var err error
+	kubeconfig := q.Get("kubeconfig")
+	if kubeconfig == "" && u.Host == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		d.client, err = inClusterClient()
+	} else {
+		d.client, err = kubeconfigClient(kubeconfig, u.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// kubeObject holds the fields of pods and services discovery needs.
+type kubeObject struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
+	} `json:"metadata"`
+	Spec struct {
+		ClusterIP string `json:"clusterIP"`
+		Ports     []struct {
+			Port int `json:"port"`
+		} `json:"ports"`
+		Containers []struct {
+			Ports []struct {
+				ContainerPort int `json:"containerPort"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+}
+
+// host returns the entry for a pod or service, or false while it has no
+// address to probe.
+func (d *kubernetesDiscoverer) host(o kubeObject) (HostConfig, bool) {
+	name := o.Metadata.Namespace + "/" + o.Metadata.Name
+	var address string
+	var port int
+	if d.kind == "pods" {
+		if o.Status.Phase != "Running" || o.Status.PodIP == "" {
+			return HostConfig{}, false
+		}
+		address = o.Status.PodIP
+		if len(o.Spec.Containers) > 0 && len(o.Spec.Containers[0].Ports) > 0 {
+			port = o.Spec.Containers[0].Ports[0].ContainerPort
+		}
+	} else {
+		if o.Spec.ClusterIP == "" || o.Spec.ClusterIP == "None" {
+			return HostConfig{}, false
+		}
+		address = o.Spec.ClusterIP
+		if len(o.Spec.Ports) > 0 {
+			port = o.Spec.Ports[0].Port
+		}
+	}
+	p := ""
+	if port > 0 {
+		p = strconv.Itoa(port)
+	}
+	return d.template.host(address, p, name, nil), true
+}
+
+func (d *kubernetesDiscoverer) hosts(objects map[string]kubeObject) []HostConfig {
+	var hosts []HostConfig
+	for _, o := range objects {
+		if h, ok := d.host(o); ok {
+			hosts = append(hosts, h)
+		}
+	}
+	slices.SortFunc(hosts, func(a, b HostConfig) int { return strings.Compare(a.DisplayName, b.DisplayName) })
+	return hosts
+}
+
+func (d *kubernetesDiscoverer) path() string {
+	if d.namespace == "" {
+		return "/api/v1/" + d.kind
+	}
+	return "/api/v1/namespaces/" + url.PathEscape(d.namespace) + "/" + d.kind
+}
+
+// errWatchExpired is the API server refusing to resume a watch from a
+// resource version it no longer has, which calls for a new list.
+var errWatchExpired = errors.New("watch expired")
+
+func (d *kubernetesDiscoverer) run(update func([]HostConfig)) error {
+	for {
+		if err := d.listAndWatch(update); !errors.Is(err, errWatchExpired) {
+			return err
+		}
+	}
+}
+
+// listAndWatch lists the matching objects and then follows the changes.
+func (d *kubernetesDiscoverer) listAndWatch(update func([]HostConfig)) error {
+	query := url.Values{}
+	if d.selector != "" {
+		query.Set("labelSelector", d.selector)
+	}
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []kubeObject `json:"items"`
+	}
+	if err := d.client.get(d.path(), query, &list); err != nil {
+		return err
+	}
+	objects := map[string]kubeObject{}
+	for _, o := range list.Items {
+		objects[o.Metadata.UID] = o
+	}
+	update(d.hosts(objects))
+
+	version := list.Metadata.ResourceVersion
+	for {
+		query.Set("watch", "1")
+		query.Set("allowWatchBookmarks", "true")
+		// Have the server end quiet watches now and then, so a connection
+		// that died silently is noticed.
+		query.Set("timeoutSeconds", "300")
+		query.Set("resourceVersion", version)
+		body, err := d.client.stream(d.path(), query)
+		if err != nil {
+			return err
+		}
+		version, err = d.follow(body, objects, version, update)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// follow applies watch events until the server ends the stream, returning
+// the resource version to resume from.
+func (d *kubernetesDiscoverer) follow(body io.Reader, objects map[string]kubeObject, version string, update func([]HostConfig)) (string, error) {
+	dec := json.NewDecoder(body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err == io.EOF {
+			return version, nil
+		} else if err != nil {
+			return version, err
+		}
+		if event.Type == "ERROR" {
+			var status struct {
+				Message string `json:"message"`
+				Code    int    `json:"code"`
+			}
+			json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				return version, errWatchExpired
+			}
+			return version, fmt.Errorf("watch: %s", status.Message)
+		}
+
+		var o kubeObject
+		var meta struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Object, &o); err != nil {
+			return version, err
+		}
+		json.Unmarshal(event.Object, &meta)
+		version = meta.Metadata.ResourceVersion
+
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			objects[o.Metadata.UID] = o
+		case "DELETED":
+			delete(objects, o.Metadata.UID)
+		default: // BOOKMARK
+			continue
+		}
+		update(d.hosts(objects))
+	}
+}
+
+// kubeClient calls the Kubernetes API.
+type kubeClient struct {
+	server string
+	token  func() (string, error) // bearer token, nil for none
+	user   string                 // basic auth, when set
+	pass   string
+	http   *http.Client
+}
+
+// get decodes the JSON response to a GET request.
+func (c *kubeClient) get(path string, query url.Values, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	body, err := c.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+// stream returns the body of a long-running watch request.
+func (c *kubeClient) stream(path string, query url.Values) (io.ReadCloser, error) {
+	return c.do(context.Background(), path, query)
+}
+
+func (c *kubeClient) do(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.user != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&status)
+		return nil, fmt.Errorf("%s: %s %s", path, resp.Status, status.Message)
+	}
+	return resp.Body, nil
+}
+
+func newKubeClient(server string, tlsConfig *tls.Config) *kubeClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &kubeClient{server: strings.TrimSuffix(server, "/"), http: &http.Client{Transport: transport}}
+}
+
+// serviceAccountDir holds the credentials of a pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+func inClusterClient() (*kubeClient, error) {
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in service account ca.crt")
+	}
+	server := "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	c := newKubeClient(server, &tls.Config{RootCAs: pool})
+	// Service account tokens are rotated, so read the file every time.
+	c.token = func() (string, error) {
+		b, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		return strings.TrimSpace(string(b)), err
+	}
+	return c, nil
+}
+
+// kubeconfig holds the parts of a kubeconfig file used to connect.
+// Credential plugins (exec and auth-provider) are not supported.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server   string `yaml:"server"`
+			CA       string `yaml:"certificate-authority"`
+			CAData   string `yaml:"certificate-authority-data"`
+			Insecure bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token          string `yaml:"token"`
+			TokenFile      string `yaml:"tokenFile"`
+			ClientCert     string `yaml:"client-certificate"`
+			ClientCertData string `yaml:"client-certificate-data"`
+			ClientKey      string `yaml:"client-key"`
+			ClientKeyData  string `yaml:"client-key-data"`
+			Username       string `yaml:"username"`
+			Password       string `yaml:"password"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+func kubeconfigClient(path, contextName string) (*kubeClient, error) {
+	if path == "" {
+		path = os.Getenv("KUBECONFIG")
+		if i := strings.IndexByte(path, os.PathListSeparator); i >= 0 {
+			path = path[:i]
+		}
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".kube", "config")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Files named in the kubeconfig are relative to it.
+	dir := filepath.Dir(path)
+	read := func(file, data string) ([]byte, error) {
+		if data != "" {
+			return base64.StdEncoding.DecodeString(data)
+		}
+		if file == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		return os.ReadFile(file)
+	}
+
+	contextName = cmp.Or(contextName, kc.CurrentContext)
+	var cluster, user string
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			cluster, user = c.Context.Cluster, c.Context.User
+		}
+	}
+	if cluster == "" {
+		return nil, fmt.Errorf("%s: no context %q", path, contextName)
+	}
+
+	tlsConfig := &tls.Config{}
+	server := ""
+	for _, cl := range kc.Clusters {
+		if cl.Name != cluster {
+			continue
+		}
+		ca, err := read(cl.Cluster.CA, cl.Cluster.CAData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: cluster %s: %v", path, cl.Name, err)
+		}
+		if ca != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("%s: cluster %s: no certificates in certificate authority", path, cl.Name)
+			}
+		}
+		tlsConfig.InsecureSkipVerify = cl.Cluster.Insecure
+		server = cl.Cluster.Server
+	}
+	if server == "" {
+		return nil, fmt.Errorf("%s: no server for cluster %q", path, cluster)
+	}
+
+	var token func() (string, error)
+	var username, password string
+	for _, u := range kc.Users {
+		if u.Name != user {
+			continue
+		}
+		cert, err := read(u.User.ClientCert, u.User.ClientCertData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: user %s: %v", path, u.Name, err)
+		}
+		key, err := read(u.User.ClientKey, u.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("%s: user %s: %v", path, u.Name, err)
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: user %s: %v", path, u.Name, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		switch {
+		case u.User.Token != "":
+			t := u.User.Token
+			token = func() (string, error) { return t, nil }
+		case u.User.TokenFile != "":
+			file := u.User.TokenFile
+			token = func() (string, error) {
+				b, err := read(file, "")
+				return strings.TrimSpace(string(b)), err
+			}
+		}
+		username, password = u.User.Username, u.User.Password
+	}
+
+	c := newKubeClient(server, tlsConfig)
+	c.token, c.user, c.pass = token, username, password
+	return c, nil
+}
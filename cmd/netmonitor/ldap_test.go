@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+// ldapResult builds an LDAP response of type op to message id.
+func ldapResult(id int64, op byte, code byte, diagnostic string) []byte {
+	content := berAppend(nil, ldapEnumerated, []byte{code})
+	content = berAppend(content, ldapOctetString, nil)
+	content = berAppend(content, ldapOctetString, []byte(diagnostic))
+	return ldapMessage(id, op, content)
+}
+
+func TestReadLDAPResult(t *testing.T) {
+	long := ldapResult(1, ldapBindResponse, 49, strings.Repeat("x", 300))
+	tests := []struct {
+		name   string
+		stream []byte
+		err    string
+	}{
+		{"success", ldapResult(1, ldapBindResponse, 0, ""), ""},
+		{"invalid credentials", ldapResult(1, ldapBindResponse, 49, "80090308: LdapErr: DSID-0C09044E"), "invalidCredentials (49): 80090308: LdapErr: DSID-0C09044E"},
+		{"no diagnostic", ldapResult(1, ldapBindResponse, 53, ""), "unwillingToPerform (53)"},
+		{"unknown code", ldapResult(1, ldapBindResponse, 99, ""), "error (99)"},
+		{"long form length", long, "invalidCredentials (49): xxx"},
+		{"earlier message skipped", append(ldapResult(7, ldapBindResponse, 49, ""), ldapResult(1, ldapBindResponse, 0, "")...), ""},
+		{"notice of disconnection", ldapResult(0, ldapExtendedResponse, 52, ""), "server closed the session"},
+		{"wrong response", ldapResult(1, ldapExtendedResponse, 0, ""), "unexpected response 0x78"},
+		{"not a sequence", []byte{0x04, 1, 0}, "invalid LDAP message"},
+		{"no result code", ldapMessage(1, ldapBindResponse, nil), "invalid LDAP result"},
+		{"indefinite length", []byte{0x30, 0x80}, "invalid BER length"},
+		{"truncated", ldapResult(1, ldapBindResponse, 0, "")[:5], "unexpected EOF"},
+		{"closed", nil, "EOF"},
+	}
+	for _, tt := range tests {
+		err := readLDAPResult(bufio.NewReader(bytes.NewReader(tt.stream)), 1, ldapBindResponse)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// serveLDAP accepts binds as cn=monitor with password secret, and
+// anonymous ones, and refuses STARTTLS.
+func serveLDAP(t *testing.T) string {
+	return serveTCP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := readBERElement(r)
+			if err != nil {
+				return
+			}
+			_, content, _, _ := berRead(msg)
+			id, rest, _ := berReadInt(content)
+			op, req, _, _ := berRead(rest)
+			switch op {
+			case ldapExtendedRequest:
+				conn.Write(ldapResult(id, ldapExtendedResponse, 2, "unsupported extended operation"))
+			case ldapBindRequest:
+				_, _, rest, _ := berRead(req) // version
+				_, name, rest, _ := berRead(rest)
+				_, password, _, _ := berRead(rest)
+				var code byte
+				if (string(name) != "cn=monitor" || string(password) != "secret") && (len(name) > 0 || len(password) > 0) {
+					code = 49
+				}
+				conn.Write(ldapResult(id, ldapBindResponse, code, ""))
+			}
+		}
+	})
+}
+
+func TestProbeLDAP(t *testing.T) {
+	addr := serveLDAP(t)
+	tests := []struct {
+		name   string
+		target string
+		err    string
+	}{
+		{"dn", "ldap://:secret@" + addr + "?dn=cn=monitor", ""},
+		{"user", "ldap://cn%3Dmonitor:secret@" + addr, ""},
+		{"anonymous", "ldap://" + addr, ""},
+		{"wrong password", "ldap://cn%3Dmonitor:guess@" + addr, "bind: invalidCredentials (49)"},
+		{"starttls refused", "ldap://" + addr + "?starttls=true", "starttls: protocolError (2): unsupported extended operation"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeLDAP(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if _, ok := result.Metrics["bind_ms"]; !ok || result.Latency <= 0 {
+			t.Errorf("%s: %+v", tt.name, result)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mail targets look like
+//
+//	smtp://mx.example.com?starttls=true    banner, EHLO and optional STARTTLS
+//	smtps://mail.example.com               the same over implicit TLS (465)
+//	imap://mail.example.com?starttls=true  greeting and CAPABILITY
+//	imaps://mail.example.com               the same over implicit TLS (993)
+//
+// Time to banner is reported separately from the full exchange because a
+// slow greeting usually points at DNS or anti-spam checks on the server.
+func init() {
+	probers["smtp"] = probeSMTP
+	probers["smtps"] = probeSMTP
+	probers["imap"] = probeIMAP
+	probers["imaps"] = probeIMAP
+}
+
+// dialMail connects to a mail server, wrapping the connection in TLS right
+// away for the implicit TLS schemes.
+func dialMail(t *target, plainPort, tlsPort string) (net.Conn, map[string]float64, error) {
+	metrics := map[string]float64{}
+	implicitTLS := strings.HasSuffix(t.kind, "s")
+	port := plainPort
+	if implicitTLS {
+		port = tlsPort
+	}
+
+	start := time.Now()
+	conn, err := t.dial("tcp", t.hostPort(port))
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(start.Add(t.timeout()))
+	metrics["connect_ms"] = msSince(start)
+
+	if implicitTLS {
+		tlsStart := time.Now()
+		tlsConn := tls.Client(conn, t.tlsConfig(t.host))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		metrics["tls_ms"] = msSince(tlsStart)
+		conn = tlsConn
+	}
+	return conn, metrics, nil
+}
+
+// startTLS upgrades an established connection after the server agreed to
+// STARTTLS and returns a fresh textproto reader on top of it.
+func startTLS(conn net.Conn, t *target, metrics map[string]float64) (net.Conn, *textproto.Conn, error) {
+	tlsStart := time.Now()
+	tlsConn := tls.Client(conn, t.tlsConfig(t.host))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	metrics["tls_ms"] = msSince(tlsStart)
+	return tlsConn, textproto.NewConn(tlsConn), nil
+}
+
+func probeSMTP(t *target) (probeResult, error) {
+	start := time.Now()
+	conn, metrics, err := dialMail(t, "25", "465")
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	if _, _, err := text.ReadResponse(220); err != nil {
+		return probeResult{}, fmt.Errorf("smtp banner: %v", err)
+	}
+	metrics["banner_ms"] = msSince(start)
+
+	helo := t.param("helo", "netmonitor")
+	ehloStart := time.Now()
+	if err := smtpCmd(text, 250, "EHLO %s", helo); err != nil {
+		return probeResult{}, err
+	}
+	metrics["ehlo_ms"] = msSince(ehloStart)
+
+	if t.kind == "smtp" && t.param("starttls", "false") == "true" {
+		if err := smtpCmd(text, 220, "STARTTLS"); err != nil {
+			return probeResult{}, err
+		}
+		if conn, text, err = startTLS(conn, t, metrics); err != nil {
+			return probeResult{}, fmt.Errorf("smtp starttls: %v", err)
+		}
+		defer conn.Close()
+		if err := smtpCmd(text, 250, "EHLO %s", helo); err != nil {
+			return probeResult{}, err
+		}
+	}
+
+	smtpCmd(text, 221, "QUIT")
+	return probeResult{Latency: msSince(start), Metrics: metrics}, nil
+}
+
+func smtpCmd(text *textproto.Conn, code int, format string, args ...any) error {
+	id, err := text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+	if _, _, err := text.ReadResponse(code); err != nil {
+		return fmt.Errorf("smtp %s: %v", strings.Fields(format)[0], err)
+	}
+	return nil
+}
+
+func probeIMAP(t *target) (probeResult, error) {
+	start := time.Now()
+	conn, metrics, err := dialMail(t, "143", "993")
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	greeting, err := text.ReadLine()
+	if err != nil {
+		return probeResult{}, fmt.Errorf("imap greeting: %v", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return probeResult{}, fmt.Errorf("imap greeting: %s", greeting)
+	}
+	metrics["banner_ms"] = msSince(start)
+
+	tag := 0
+	capStart := time.Now()
+	caps, err := imapCmd(text, &tag, "CAPABILITY")
+	if err != nil {
+		return probeResult{}, err
+	}
+	metrics["capability_ms"] = msSince(capStart)
+
+	secure := t.kind == "imaps"
+	if t.kind == "imap" && t.param("starttls", "false") == "true" {
+		if !strings.Contains(caps, "STARTTLS") {
+			return probeResult{}, fmt.Errorf("imap server does not offer STARTTLS")
+		}
+		if _, err := imapCmd(text, &tag, "STARTTLS"); err != nil {
+			return probeResult{}, err
+		}
+		if conn, text, err = startTLS(conn, t, metrics); err != nil {
+			return probeResult{}, fmt.Errorf("imap starttls: %v", err)
+		}
+		defer conn.Close()
+		if caps, err = imapCmd(text, &tag, "CAPABILITY"); err != nil {
+			return probeResult{}, err
+		}
+		secure = true
+	}
+
+	// LOGINDISABLED is expected on a plaintext session but means nobody can
+	// log in once TLS is up.
+	result := probeResult{Latency: msSince(start), Metrics: metrics}
+	if secure && strings.Contains(caps, "LOGINDISABLED") {
+		result.Warning = "imap server advertises LOGINDISABLED"
+	}
+	imapCmd(text, &tag, "LOGOUT")
+	return result, nil
+}
+
+// imapCmd sends a tagged command and collects the untagged lines of its
+// response until the tagged completion arrives.
+func imapCmd(text *textproto.Conn, tag *int, cmd string) (string, error) {
+	*tag++
+	id := "a" + strconv.Itoa(*tag)
+	if err := text.PrintfLine("%s %s", id, cmd); err != nil {
+		return "", err
+	}
+
+	var untagged strings.Builder
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		if rest, ok := strings.CutPrefix(line, id+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				return "", fmt.Errorf("imap %s: %s", cmd, rest)
+			}
+			return untagged.String(), nil
+		}
+		untagged.WriteString(line)
+		untagged.WriteByte('\n')
+	}
+}
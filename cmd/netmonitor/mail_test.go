@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// serveTCP runs session for every connection to a local port and returns
+// the address.
+func serveTCP(t *testing.T, session func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				session(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// mailServer answers with greeting and then each command it gets with the
+// lines of the first entry of replies whose key the command starts with.
+// Replies may use {tag} for the tag of an IMAP command.
+func mailServer(greeting string, replies map[string]string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		text := textproto.NewConn(conn)
+		text.PrintfLine("%s", greeting)
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			tag, cmd := "", line
+			if strings.HasPrefix(line, "a") {
+				tag, cmd, _ = strings.Cut(line, " ")
+			}
+			reply, ok := replies[strings.Fields(cmd)[0]]
+			if !ok {
+				reply = "500 unknown command"
+				if tag != "" {
+					reply = "{tag} BAD unknown command"
+				}
+			}
+			text.PrintfLine("%s", strings.ReplaceAll(reply, "{tag}", tag))
+		}
+	}
+}
+
+func TestProbeSMTP(t *testing.T) {
+	ok := map[string]string{"EHLO": "250-mx.example.com\r\n250 PIPELINING", "QUIT": "221 bye"}
+	tests := []struct {
+		name     string
+		greeting string
+		replies  map[string]string
+		err      string
+	}{
+		{"ok", "220 mx.example.com ESMTP", ok, ""},
+		{"refused", "554 no service", ok, "smtp banner"},
+		{"ehlo rejected", "220 mx.example.com ESMTP", map[string]string{"EHLO": "502 not implemented"}, "smtp EHLO"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("smtp://" + serveTCP(t, mailServer(tt.greeting, tt.replies)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeSMTP(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if _, ok := result.Metrics["banner_ms"]; !ok {
+			t.Errorf("%s: no banner_ms in %v", tt.name, result.Metrics)
+		}
+	}
+}
+
+func TestProbeIMAP(t *testing.T) {
+	caps := func(list string) map[string]string {
+		return map[string]string{
+			"CAPABILITY": "* CAPABILITY " + list + "\r\n{tag} OK done",
+			"LOGOUT":     "* BYE\r\n{tag} OK bye",
+		}
+	}
+	tests := []struct {
+		name     string
+		greeting string
+		replies  map[string]string
+		params   string
+		err      string
+	}{
+		{"ok", "* OK ready", caps("IMAP4rev1 STARTTLS LOGINDISABLED"), "", ""},
+		{"preauthenticated", "* PREAUTH welcome", caps("IMAP4rev1"), "", ""},
+		{"busy", "* BYE too many connections", caps("IMAP4rev1"), "", "imap greeting: * BYE"},
+		{"capability refused", "* OK ready", map[string]string{"CAPABILITY": "{tag} NO go away"}, "", "imap CAPABILITY: NO go away"},
+		{"no starttls", "* OK ready", caps("IMAP4rev1"), "?starttls=true", "does not offer STARTTLS"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("imap://" + serveTCP(t, mailServer(tt.greeting, tt.replies)) + tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeIMAP(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		// LOGINDISABLED only matters once the session is encrypted.
+		if err != nil || result.Warning != "" {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+		}
+	}
+}
@@ -4,19 +4,24 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
 type PingStats struct {
 	Host           string    `json:"host"`
+	Type           string    `json:"type"`
 	Status         string    `json:"status"`
 	LastSeen       time.Time `json:"lastSeen"`
 	PacketsSent    int       `json:"packetsSent"`
@@ -27,399 +32,1041 @@ type PingStats struct {
 	MaxLatency     float64   `json:"maxLatency"`
 	CurrentLatency float64   `json:"currentLatency"`
 	Jitter         float64   `json:"jitter"`
+	Warning        string    `json:"warning,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+
+	// Severity is how bad the status is, warning or critical, and unset
+	// while the host is up. Degraded hosts are critical when their
+	// thresholds say so.
+	Severity string `json:"severity,omitempty"`
+
+	// LastError is why the last probe failed and FailureReason what kind
+	// of failure that was, one of dns_error, timeout, icmp_unreachable,
+	// connection_refused, socket_error, check_failed or, for TLS targets,
+	// cert_expired, intermediate_expired, cert_revoked, hostname_mismatch
+	// or untrusted_chain. Both are cleared when a probe succeeds.
+	LastError     string `json:"lastError,omitempty"`
+	FailureReason string `json:"failureReason,omitempty"`
+
+	// Paused is set while probing of the host is paused, since
+	// PausedSince. Status is the one it had before.
+	Paused      bool      `json:"paused,omitempty"`
+	PausedSince time.Time `json:"pausedSince,omitzero"`
+
+	// OffSchedule is set while the schedule of the host says not to
+	// probe it.
+	OffSchedule bool `json:"offSchedule,omitempty"`
+
+	// StatsSince is when the counters and latency statistics were last
+	// reset, unset if they count from the start.
+	StatsSince time.Time `json:"statsSince,omitzero"`
+
+	// Windows holds the statistics of the last hour, day or other
+	// configured windows, by window such as "1h". The map is replaced,
+	// never mutated.
+	Windows map[string]WindowStats `json:"windows,omitempty"`
+
+	// DownSince is when the current outage started, and OutageDuration
+	// how long it has lasted so far in seconds. Both are unset while the
+	// host is not down.
+	DownSince      time.Time `json:"downSince,omitzero"`
+	OutageDuration float64   `json:"outageDuration,omitempty"`
+
+	// Address is what the hostname resolved to last, and AddressChanged
+	// when that last changed.
+	Address        string    `json:"address,omitempty"`
+	AddressChanged time.Time `json:"addressChanged,omitzero"`
+
+	// OpenPorts is what the last port scan found open, at PortsScanned.
+	OpenPorts    []int     `json:"openPorts,omitempty"`
+	PortsScanned time.Time `json:"portsScanned,omitzero"`
+
+	// Metrics holds probe specific measurements such as the TLS handshake
+	// time of a DNS-over-TLS query. The map is replaced, never mutated.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+
+	// Info holds the address, reverse DNS name and AS of the host when
+	// enrichment is configured.
+	Info *HostInfo `json:"info,omitempty"`
+
+	// Path names the uplink of hosts probed over several paths.
+	Path string `json:"path,omitempty"`
+
+	Thresholds Thresholds `json:"thresholds"`
+
+	// HostMeta is the display name, icon and notes of the host.
+	HostMeta
 }
 
 type Monitor struct {
-	hosts    []string
 	port     int
 	interval time.Duration
-	stats    map[string]*PingStats
-	mu       sync.RWMutex
+	workers  int // probes running at once
+	windows  []time.Duration
+	clock    Clock
+	prober   Prober
+	limiter  *rateLimiter
+	probes   *probeState
+	// hostSet holds the monitored hosts. Each host's statistics have
+	// their own lock.
+	hostSet atomic.Pointer[hostSet]
+	hostsMu sync.Mutex      // serializes changes to hostSet
+	synced  map[string]bool // providers of hosts synced since starting, guarded by hostsMu
+	wheel   *timerWheel
+
+	mu      sync.RWMutex
+	started time.Time // when the probes were launched, guarded by mu
+
+	notifiers []*namedNotifier
+	routes    []*route
+	actions   []*action
+	webhooks  []*webhook
+	oncall    []*onCallSchedule
+	blackouts blackouts
+	ha        *haState
+	exporters []Exporter
+	streams   resultStreams
+
+	// enricher looks up the details of hosts as they are discovered.
+	enricher *enricher
+
+	// arpWatcher keeps the inventory of devices on local segments.
+	arpWatcher *arpWatcher
+
+	// bgpWatcher follows the routing of our prefixes.
+	bgpWatcher *bgpWatcher
+
+	// capturer records the traffic of hosts that misbehave.
+	capturer *capturer
+
+	// auth signs users in when OIDC is configured.
+	auth *oidcAuth
+
+	// dashboardURL is linked from notifications when set.
+	dashboardURL string
+
+	// basePath is the prefix the web interface is served under, such as
+	// "/netmonitor", or empty. corsOrigins may call the API from browsers.
+	basePath    string
+	corsOrigins []string
+
+	// web holds the pages and their assets.
+	web fs.FS
+
+	// config is what hosts added through the API are set up with, read
+	// from configPath unless given by flags.
+	config     *Config
+	configPath string
+	added      addedHosts
+
+	ui        uiStore
+	audit     auditLog
+	tokens    tokenStore
+	hostEdits hostMetaEdits
+	paused    pausedHosts
+	incidents *incidentLog
+	history   *history
+	mux       *http.ServeMux
 }
 
-func NewMonitor(hosts []string, port int, interval time.Duration) *Monitor {
+func NewMonitor(targets []*target, port int, interval time.Duration) *Monitor {
 	m := &Monitor{
-		hosts:    hosts,
 		port:     port,
 		interval: interval,
-		stats:    make(map[string]*PingStats),
+		workers:  defaultWorkers,
+		windows:  defaultWindows,
+		clock:    realClock{},
+		prober:   registeredProbers{},
+		web:      embeddedWeb,
+
+		incidents: newIncidentLog(0),
+		history:   newHistory(defaultRetention),
 	}
+	m.mux = m.newMux()
+	m.probes = newProbeState(func() time.Time { return m.clock.Now() })
 
-	for _, host := range hosts {
-		m.stats[host] = &PingStats{
-			Host:       host,
-			Status:     "unknown",
-			MinLatency: -1,
-			MaxLatency: -1,
-		}
+	hs := &hostSet{targets: targets, stats: make(map[string]*hostStats)}
+	for _, t := range targets {
+		m.adopt(t)
+		hs.stats[t.name] = newTargetStats(t)
 	}
+	m.hostSet.Store(hs)
 
 	return m
 }
 
-func (m *Monitor) ping(host string) (float64, error) {
-	// Resolve the host
-	addr, err := net.ResolveIPAddr("ip4", host)
-	if err != nil {
-		return 0, err
+// adopt makes a target, and the checks of a composite one, remember what
+// its probes need to in the monitor's probe state.
+func (m *Monitor) adopt(t *target) {
+	t.state = m.probes
+	if t.composite != nil {
+		for _, c := range t.composite.checks {
+			c.state = m.probes
+		}
 	}
+}
 
-	// Create ICMP connection
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+// probeOnce runs one probe of a host and records the result.
+func (m *Monitor) probeOnce(h *hostState) {
+	t := h.t
+	// Probes other than ICMP resolve names on their own, normally getting
+	// the same answer; this keeps track of it.
+	var addr netip.Addr
+	if m.probesNetwork() && t.kind != "sim" {
+		addr, _ = t.resolve()
+	}
+	telemetry.inFlight.Add(1)
+	result, err := m.prober.Probe(t)
+	telemetry.inFlight.Add(-1)
+	telemetry.probes.Add(1)
 	if err != nil {
-		return 0, err
+		telemetry.probeErrors.Add(1)
 	}
-	defer conn.Close()
-
-	// Set timeout
-	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	latency := result.Latency
 
-	// Create ICMP message
-	msg := icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   1,
-			Seq:  1,
-			Data: []byte("PING"),
-		},
+	host := h.stats
+	now := m.clock.Now()
+	host.lastRun.Store(now.UnixNano())
+	host.mu.Lock()
+	stats := &host.stats
+	previous, previousSeverity := stats.Status, stats.Severity
+	if resets := host.resets.Load(); resets != h.resets {
+		h.resets, h.windows, h.lastLatency = resets, nil, 0
 	}
+	stats.PacketsSent++
 
-	msgBytes, err := msg.Marshal(nil)
 	if err != nil {
-		return 0, err
+		stats.Status = "down"
+		if previous != "down" {
+			stats.DownSince = now
+		}
+		stats.LastError = err.Error()
+		stats.FailureReason = failureReason(err)
+	} else {
+		stats.Status = "up"
+		stats.LastError, stats.FailureReason = "", ""
+		stats.DownSince = time.Time{}
+		if result.Warning != "" {
+			stats.Status = "degraded"
+		}
+		stats.Warning = result.Warning
+		stats.PacketsRecv++
+		stats.LastSeen = now
+		stats.CurrentLatency = latency
+		stats.Metrics = result.Metrics
+
+		// Update min/max
+		if stats.MinLatency == -1 || latency < stats.MinLatency {
+			stats.MinLatency = latency
+		}
+		if latency > stats.MaxLatency {
+			stats.MaxLatency = latency
+		}
+
+		// Calculate average latency
+		if stats.PacketsRecv == 1 {
+			stats.AvgLatency = latency
+		} else {
+			stats.AvgLatency = (stats.AvgLatency*float64(stats.PacketsRecv-1) + latency) / float64(stats.PacketsRecv)
+		}
+
+		// Calculate jitter (variance in latency)
+		if h.lastLatency > 0 {
+			jitter := latency - h.lastLatency
+			if jitter < 0 {
+				jitter = -jitter
+			}
+			stats.Jitter = (stats.Jitter*0.9 + jitter*0.1) // Exponential moving average
+		}
+		h.lastLatency = latency
 	}
 
-	// Send ping
-	start := time.Now()
-	_, err = conn.WriteTo(msgBytes, addr)
-	if err != nil {
-		return 0, err
+	// Calculate packet loss
+	if stats.PacketsSent > 0 {
+		stats.PacketLoss = float64(stats.PacketsSent-stats.PacketsRecv) / float64(stats.PacketsSent) * 100
+	}
+	if h.windows == nil {
+		h.windows = newWindows(m.windows)
+	}
+	stats.Windows = h.windows.add(now, err == nil, latency)
+	crossed := h.crossThresholds(stats)
+	stats.Severity = ""
+	if stats.Status != "up" {
+		stats.Severity = statusSeverity(stats.Status)
+	}
+	if stats.Thresholds.Alert && err == nil {
+		if severity, warning := h.thresholdProblem(stats); severity != "" {
+			stats.Status = "degraded"
+			stats.Warning = strings.TrimPrefix(stats.Warning+"; "+warning, "; ")
+			if severityRank[severity] > severityRank[stats.Severity] {
+				stats.Severity = severity
+			}
+		}
 	}
 
-	// Wait for reply
-	reply := make([]byte, 1500)
-	_, _, err = conn.ReadFrom(reply)
+	event := ProbeEvent{
+		Host:     t.name,
+		Type:     t.kind,
+		Tags:     t.tags,
+		Time:     now,
+		Status:   stats.Status,
+		Previous: previous,
+		Latency:  latency,
+		Loss:     stats.PacketLoss,
+		Metrics:  result.Metrics,
+	}
 	if err != nil {
-		return 0, err
+		event.Error, event.Reason = stats.LastError, stats.FailureReason
 	}
+	if addr.IsValid() && addr.String() != stats.Address {
+		if stats.Address != "" {
+			log.Printf("%s changed address from %s to %s", stats.Host, stats.Address, addr)
+			event.PreviousAddress = stats.Address
+			stats.AddressChanged = event.Time
+		}
+		stats.Address = addr.String()
+	}
+	event.Address = stats.Address
+	m.captureAnomaly(h, stats, previous, latency, err, addr)
 
-	duration := time.Since(start)
-	return duration.Seconds() * 1000, nil // Return in milliseconds
-}
-
-func (m *Monitor) monitorHost(host string) {
-	ticker := time.NewTicker(m.interval)
-	defer ticker.Stop()
-
-	var lastLatency float64
-
-	for range ticker.C {
-		latency, err := m.ping(host)
+	var alert Alert
+	sendAlert := false
+	if stats.Status != previous {
+		logStatusChange(stats, previous, err)
+		alert, sendAlert = m.alertFor(stats, previous, previousSeverity, h.statusSince, err)
+		h.statusSince = now
+	} else if stats.Severity != previousSeverity {
+		log.Printf("ALERT %s is %s, now %s: %s", stats.Host, stats.Status, stats.Severity, stats.Warning)
+		alert, sendAlert = m.alertFor(stats, previous, previousSeverity, h.statusSince, err)
+	} else if m.incidents.reminderDue(t.name, now) {
+		alert, sendAlert = m.alertFor(stats, previous, previousSeverity, h.statusSince, err)
+		alert.Repeat = true
+	}
 
-		m.mu.Lock()
-		stats := m.stats[host]
-		stats.PacketsSent++
+	status := stats.Status
+	host.publish()
+	host.mu.Unlock()
 
-		if err != nil {
-			stats.Status = "down"
-		} else {
-			stats.Status = "up"
-			stats.PacketsRecv++
-			stats.LastSeen = time.Now()
-			stats.CurrentLatency = latency
-
-			// Update min/max
-			if stats.MinLatency == -1 || latency < stats.MinLatency {
-				stats.MinLatency = latency
-			}
-			if latency > stats.MaxLatency {
-				stats.MaxLatency = latency
-			}
+	if m.ha != nil {
+		sendAlert = m.ha.confirm(h, status, status != previous, &alert, sendAlert)
+	}
 
-			// Calculate average latency
-			if stats.PacketsRecv == 1 {
-				stats.AvgLatency = latency
-			} else {
-				stats.AvgLatency = (stats.AvgLatency*float64(stats.PacketsRecv-1) + latency) / float64(stats.PacketsRecv)
-			}
+	sample := Sample{Time: event.Time, Latency: latency, Up: err == nil}
+	if result.KeepMetrics {
+		sample.Metrics = result.Metrics
+	}
+	m.history.add(t.name, sample)
+	m.export(event)
+	if event.Changed() {
+		m.emit("status_change", t.name, t.tags, event)
+	}
+	for _, c := range crossed {
+		m.emit("threshold_crossed", t.name, t.tags, c)
+	}
 
-			// Calculate jitter (variance in latency)
-			if lastLatency > 0 {
-				jitter := latency - lastLatency
-				if jitter < 0 {
-					jitter = -jitter
-				}
-				stats.Jitter = (stats.Jitter*0.9 + jitter*0.1) // Exponential moving average
-			}
-			lastLatency = latency
+	if sendAlert {
+		// Actions run on status changes even while their incident is
+		// acknowledged.
+		if !alert.Repeat && len(m.actions) > 0 {
+			go m.runActions(alert)
 		}
-
-		// Calculate packet loss
-		if stats.PacketsSent > 0 {
-			stats.PacketLoss = float64(stats.PacketsSent-stats.PacketsRecv) / float64(stats.PacketsSent) * 100
+		if m.incidents.record(&alert) && !m.blackedOut(alert) {
+			go m.notify(alert)
 		}
+	}
+}
 
-		m.mu.Unlock()
+// logStatusChange reports a host changing state. Entering the degraded
+// state is an alert and carries the probe's warning.
+func logStatusChange(stats *PingStats, previous string, err error) {
+	switch {
+	case stats.Status == "degraded":
+		log.Printf("ALERT %s is degraded: %s", stats.Host, stats.Warning)
+	case err != nil:
+		log.Printf("%s is %s (was %s): %v", stats.Host, stats.Status, previous, err)
+	default:
+		log.Printf("%s is %s (was %s)", stats.Host, stats.Status, previous)
 	}
 }
 
 func (m *Monitor) Start() {
-	for _, host := range m.hosts {
-		go m.monitorHost(host)
+	m.mu.Lock()
+	m.started = m.clock.Now()
+	for _, stats := range m.hosts().stats {
+		stats.lastRun.Store(m.started.UnixNano())
+	}
+	m.mu.Unlock()
+	m.schedule(m.workers)
+	go measureProbeRate()
+	if m.probesNetwork() {
+		go m.scanPortsLoop()
 	}
 }
 
-func (m *Monitor) GetStats() []PingStats {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// probesNetwork reports whether the monitor sends real probes, rather
+// than simulating them or running fakes.
+func (m *Monitor) probesNetwork() bool {
+	_, ok := m.prober.(registeredProbers)
+	return ok
+}
 
-	result := make([]PingStats, 0, len(m.stats))
-	for _, stats := range m.stats {
-		result = append(result, *stats)
+// stalled returns the hosts whose probe loop has not finished a probe for
+// longer than a couple of intervals plus probe cycles allow.
+func (m *Monitor) stalled() []string {
+	var hosts []string
+	hs := m.hosts()
+	for _, t := range hs.targets {
+		limit := 2*m.interval + 2*max(t.retry.duration(), probeTimeout)
+		if d, ok := probeDurations[t.kind]; ok {
+			limit += 2 * time.Duration(t.retry.Attempts) * d(t)
+		}
+		if s := hs.stats[t.name]; !s.load().Paused && m.since(s.lastRunTime()) > limit {
+			hosts = append(hosts, t.name)
+		}
 	}
-	return result
+	return hosts
 }
 
-func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == "/api/stats" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(m.GetStats())
-		return
+// GetStats returns the statistics of every host in configured order.
+func (m *Monitor) GetStats() []PingStats {
+	hs := m.hosts()
+	result := make([]PingStats, 0, len(hs.targets))
+	for _, t := range hs.targets {
+		result = append(result, m.withOutage(hs.stats[t.name].load()))
 	}
+	return result
+}
 
-	if r.URL.Path == "/" {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprint(w, htmlPage)
-		return
+// withOutage fills in how long the current outage of a host has lasted.
+func (m *Monitor) withOutage(s PingStats) PingStats {
+	if !s.DownSince.IsZero() {
+		s.OutageDuration = m.since(s.DownSince).Round(time.Second).Seconds()
 	}
+	return s
+}
+
+func (m *Monitor) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", m.page("index.html"))
+	mux.HandleFunc("GET /assets/", cached(m.handleAssets))
+	mux.HandleFunc("/api/stats", cached(m.handleStats))
+	mux.HandleFunc("GET /healthz", m.handleHealthz)
+	mux.HandleFunc("GET /readyz", m.handleReadyz)
+	mux.HandleFunc("GET /api/self", m.handleSelf)
+	mux.HandleFunc("GET /metrics", m.handleMetrics)
+	mux.HandleFunc("GET /api/incidents", m.handleIncidents)
+	mux.HandleFunc("GET /api/oncall", m.handleOnCall)
+	mux.HandleFunc("POST /api/incidents/{id}/ack", requireAccess(roleOperator, scopeWriteSilences, m.handleIncidentAck))
+	mux.HandleFunc("POST /api/incidents/{id}/notes", requireAccess(roleOperator, scopeWriteSilences, m.handleIncidentNote))
+	mux.HandleFunc("GET /api/history", cached(m.handleHistory))
+	mux.HandleFunc("/api/graphql", cached(m.handleGraphQL))
+	mux.HandleFunc("GET /api/results/stream", m.handleResultStream)
+	mux.HandleFunc("GET /api/report", cached(m.handleReport))
+	mux.HandleFunc("GET /api/report/compare", cached(m.handleComparison))
+	mux.HandleFunc("GET /api/heatmap", cached(m.handleHeatmap))
+	mux.HandleFunc("PATCH /api/hosts", requireAccess(roleOperator, scopeWriteHosts, m.handleHostUpdate))
+	mux.HandleFunc("POST /api/hosts", requireAccess(roleOperator, scopeWriteHosts, m.handleHostAdd))
+	mux.HandleFunc("DELETE /api/hosts", requireAccess(roleOperator, scopeWriteHosts, m.handleHostRemove))
+	mux.HandleFunc("POST /api/hosts/reset", requireAccess(roleOperator, scopeWriteHosts, m.handleHostsReset))
+	mux.HandleFunc("POST /api/hosts/{host}/reset", requireAccess(roleOperator, scopeWriteHosts, m.handleHostReset))
+	mux.HandleFunc("POST /api/hosts/{host}/pause", requireAccess(roleOperator, scopeWriteHosts, m.handleHostPause(true)))
+	mux.HandleFunc("POST /api/hosts/{host}/resume", requireAccess(roleOperator, scopeWriteHosts, m.handleHostPause(false)))
+	mux.HandleFunc("POST /api/import", requireAccess(roleAdmin, scopeWriteHosts, m.handleImport))
+	mux.HandleFunc("GET /api/ui", m.handleUI)
+	mux.HandleFunc("PUT /api/ui", requireRole(roleOperator, m.handleUIUpdate))
+	mux.HandleFunc("GET /api/me", m.handleMe)
+	mux.HandleFunc("GET /api/audit", requireRole(roleAdmin, m.handleAudit))
+	mux.HandleFunc("GET /api/tokens", requireLogin(roleAdmin, m.handleTokens))
+	mux.HandleFunc("POST /api/tokens", requireLogin(roleAdmin, m.handleTokenCreate))
+	mux.HandleFunc("DELETE /api/tokens/{id}", requireLogin(roleAdmin, m.handleTokenDelete))
+	mux.HandleFunc("GET /api/backup", requireRole(roleAdmin, m.handleBackup))
+	mux.HandleFunc("GET /api/geo", m.handleGeo)
+	mux.HandleFunc("GET /map", m.page("map.html"))
+	mux.HandleFunc("GET /api/paths", m.handlePaths)
+	mux.HandleFunc("GET /api/devices", m.handleDevices)
+	mux.HandleFunc("GET /api/bgp", m.handleBGP)
+	mux.HandleFunc("GET /api/captures", m.handleCaptures)
+	mux.HandleFunc("GET /api/captures/{name}", m.handleCapture)
+	mux.HandleFunc("GET /api/screenshots", m.handleScreenshots)
+	mux.HandleFunc("GET /api/screenshots/{name}", m.handleScreenshot)
+	mux.HandleFunc("GET /paths", m.page("paths.html"))
+	mux.HandleFunc("GET /speed", m.page("speed.html"))
+	mux.HandleFunc("GET /heatmap", m.page("heatmap.html"))
+	mux.HandleFunc("GET /grafana/{$}", m.handleGrafanaTest)
+	mux.HandleFunc("POST /grafana/search", m.handleGrafanaSearch)
+	mux.HandleFunc("POST /grafana/query", m.handleGrafanaQuery)
+	mux.HandleFunc("POST /grafana/annotations", m.handleGrafanaAnnotations)
+	return mux
+}
 
-	http.NotFound(w, r)
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
 
-const htmlPage = `<!DOCTYPE html>
-<html>
-<head>
-    <title>Network Monitor</title>
-    <style>
-        body {
-            font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif;
-            margin: 0;
-            padding: 20px;
-            background: #f5f5f5;
-        }
-        .container {
-            max-width: 1400px;
-            margin: 0 auto;
-        }
-        h1 {
-            color: #333;
-            margin-bottom: 30px;
-        }
-        .host-grid {
-            display: grid;
-            grid-template-columns: repeat(auto-fill, minmax(400px, 1fr));
-            gap: 20px;
-        }
-        .host-card {
-            background: white;
-            border-radius: 8px;
-            padding: 20px;
-            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
-            transition: box-shadow 0.3s;
-        }
-        .host-card:hover {
-            box-shadow: 0 4px 8px rgba(0,0,0,0.15);
-        }
-        .host-header {
-            display: flex;
-            justify-content: space-between;
-            align-items: center;
-            margin-bottom: 15px;
-            padding-bottom: 15px;
-            border-bottom: 2px solid #f0f0f0;
-        }
-        .host-name {
-            font-size: 18px;
-            font-weight: bold;
-            color: #333;
-        }
-        .status {
-            padding: 5px 15px;
-            border-radius: 20px;
-            font-size: 12px;
-            font-weight: bold;
-            text-transform: uppercase;
-        }
-        .status.up {
-            background: #4caf50;
-            color: white;
-        }
-        .status.down {
-            background: #f44336;
-            color: white;
-        }
-        .status.unknown {
-            background: #999;
-            color: white;
-        }
-        .metric {
-            display: flex;
-            justify-content: space-between;
-            padding: 8px 0;
-            border-bottom: 1px solid #f5f5f5;
-        }
-        .metric-label {
-            color: #666;
-            font-size: 14px;
-        }
-        .metric-value {
-            font-weight: bold;
-            color: #333;
-            font-size: 14px;
-        }
-        .metric-value.good {
-            color: #4caf50;
-        }
-        .metric-value.warning {
-            color: #ff9800;
-        }
-        .metric-value.bad {
-            color: #f44336;
-        }
-        .last-update {
-            text-align: center;
-            color: #999;
-            margin-top: 20px;
-            font-size: 14px;
-        }
-    </style>
-</head>
-<body>
-    <div class="container">
-        <h1>Network Monitor</h1>
-        <div class="host-grid" id="hostGrid"></div>
-        <div class="last-update" id="lastUpdate"></div>
-    </div>
-
-    <script>
-        function formatLatency(ms) {
-            return ms > 0 ? ms.toFixed(2) + ' ms' : 'N/A';
-        }
-
-        function formatPacketLoss(loss) {
-            return loss.toFixed(2) + '%';
-        }
-
-        function getLatencyClass(latency) {
-            if (latency < 0) return '';
-            if (latency < 50) return 'good';
-            if (latency < 100) return 'warning';
-            return 'bad';
-        }
-
-        function getPacketLossClass(loss) {
-            if (loss === 0) return 'good';
-            if (loss < 5) return 'warning';
-            return 'bad';
-        }
-
-        function formatLastSeen(timestamp) {
-            if (!timestamp || timestamp === '0001-01-01T00:00:00Z') return 'Never';
-            const date = new Date(timestamp);
-            const now = new Date();
-            const diff = Math.floor((now - date) / 1000);
-            
-            if (diff < 60) return diff + 's ago';
-            if (diff < 3600) return Math.floor(diff / 60) + 'm ago';
-            return Math.floor(diff / 3600) + 'h ago';
-        }
-
-        function updateStats() {
-            fetch('/api/stats')
-                .then(response => response.json())
-                .then(data => {
-                    const grid = document.getElementById('hostGrid');
-                    grid.innerHTML = '';
-                    
-                    data.forEach(host => {
-                        const card = document.createElement('div');
-                        card.className = 'host-card';
-                        card.innerHTML = 
-                            '<div class="host-header">' +
-                                '<div class="host-name">' + host.host + '</div>' +
-                                '<div class="status ' + host.status + '">' + host.status + '</div>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Current Latency</span>' +
-                                '<span class="metric-value ' + getLatencyClass(host.currentLatency) + '">' + formatLatency(host.currentLatency) + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Average Latency</span>' +
-                                '<span class="metric-value ' + getLatencyClass(host.avgLatency) + '">' + formatLatency(host.avgLatency) + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Min / Max Latency</span>' +
-                                '<span class="metric-value">' + formatLatency(host.minLatency) + ' / ' + formatLatency(host.maxLatency) + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Jitter</span>' +
-                                '<span class="metric-value">' + formatLatency(host.jitter) + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Packet Loss</span>' +
-                                '<span class="metric-value ' + getPacketLossClass(host.packetLoss) + '">' + formatPacketLoss(host.packetLoss) + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Packets Sent / Received</span>' +
-                                '<span class="metric-value">' + host.packetsSent + ' / ' + host.packetsRecv + '</span>' +
-                            '</div>' +
-                            '<div class="metric">' +
-                                '<span class="metric-label">Last Seen</span>' +
-                                '<span class="metric-value">' + formatLastSeen(host.lastSeen) + '</span>' +
-                            '</div>';
-                        grid.appendChild(card);
-                    });
-                    
-                    document.getElementById('lastUpdate').textContent = 'Last updated: ' + new Date().toLocaleTimeString();
-                })
-                .catch(error => console.error('Error fetching stats:', error));
-        }
-
-        // Update every 2 seconds
-        updateStats();
-        setInterval(updateStats, 2000);
-    </script>
-</body>
-</html>`
+// writeError sends an API error as {"error": msg}.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
 
 func main() {
-	hostsFlag := flag.String("hosts", "", "Comma-separated list of hosts to monitor")
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := runValidate(os.Args[2:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(os.Args[2:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+	if strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe") == "check_netmonitor" {
+		os.Exit(runCheck("check_netmonitor", os.Args[1:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck("netmonitor check", os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		if err := runCtl(os.Args[2:]); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	configFlag := flag.String("config", "", "Path to a YAML configuration file")
+	hostsFlag := flag.String("hosts", "", "Comma-separated list of hosts to monitor (plain hosts are pinged, URLs such as dot://1.1.1.1/example.com select other probes)")
 	portFlag := flag.Int("port", 8080, "Port for the web server")
 	intervalFlag := flag.Duration("interval", 5*time.Second, "Ping interval (e.g., 5s, 1m)")
+	timeoutFlag := flag.Duration("timeout", probeTimeout, "How long a probe waits for an answer, independent of the interval")
+	workersFlag := flag.Int("workers", defaultWorkers, "Maximum number of probes running at once")
+	notifyFlag := flag.String("notify", "", "Comma-separated list of notifier URLs to alert on status changes")
+	pluginsFlag := flag.String("plugins-dir", "", "Directory of probe and notifier plugins to load at startup")
+	exportFlag := flag.String("export", "", "Comma-separated list of exporter URLs that receive every probe result (e.g. statsd://localhost:8125)")
+	historyFlag := flag.String("history-dir", "", "Directory to keep probe history in across restarts")
+	debugFlag := flag.Bool("debug", false, "Serve pprof profiles under /debug/pprof/ (loopback only unless debug_token is set)")
+	userFlag := flag.String("user", "", "Unprivileged user to switch to after opening sockets when started as root")
+	basePathFlag := flag.String("base-path", "", "Path prefix to serve the web interface under behind a reverse proxy (e.g. /netmonitor)")
+	webDirFlag := flag.String("web-dir", "", "Directory of web interface files that replace the built-in ones")
+	dryRunFlag := flag.Bool("dry-run", false, "Simulate every probe instead of sending packets (no network access or root needed)")
+	seedFlag := flag.Uint64("seed", 0, "Seed of the simulated results of -dry-run and sim:// hosts (random if 0)")
+	corsFlag := flag.String("cors-origin", "", "Comma-separated list of origins allowed to call the API from browsers, or *")
 
 	flag.Parse()
 
-	if *hostsFlag == "" {
-		log.Fatal("Error: -hosts flag is required (comma-separated list of hosts)")
+	cfg := &Config{}
+	if *configFlag != "" {
+		var err error
+		if cfg, err = loadConfig(*configFlag); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	// Flags given on the command line win over the config file.
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["port"] || cfg.Port == 0 {
+		cfg.Port = *portFlag
+	}
+	if set["interval"] || cfg.Interval == 0 {
+		cfg.Interval = *intervalFlag
+	}
+	if set["timeout"] {
+		cfg.Timeout = *timeoutFlag
+	}
+	if set["workers"] || cfg.Workers == 0 {
+		cfg.Workers = *workersFlag
+	}
+	if cfg.Workers < 1 {
+		log.Fatal("Error: workers must be at least 1")
+	}
+	if set["plugins-dir"] {
+		cfg.PluginDir = *pluginsFlag
+	}
+	if set["history-dir"] {
+		cfg.HistoryDir = *historyFlag
+	}
+	if set["user"] {
+		cfg.User = *userFlag
+	}
+	if set["debug"] {
+		cfg.Debug = *debugFlag
+	}
+	if set["base-path"] {
+		cfg.BasePath = *basePathFlag
+	}
+	if set["web-dir"] {
+		cfg.WebDir = *webDirFlag
+	}
+	if set["cors-origin"] {
+		cfg.CORSOrigins = splitList(*corsFlag)
+	}
+	if set["dry-run"] {
+		cfg.DryRun = *dryRunFlag
+	}
+	if set["seed"] {
+		cfg.Seed = *seedFlag
+	}
+	if cfg.Seed != 0 {
+		simulation = newSimulator(cfg.Seed)
 	}
+	for _, host := range splitList(*hostsFlag) {
+		cfg.Hosts = append(cfg.Hosts, HostConfig{Target: host})
+	}
+	for _, raw := range splitList(*notifyFlag) {
+		cfg.Notifiers = append(cfg.Notifiers, NotifierConfig{URL: raw})
+	}
+	cfg.Exporters = append(cfg.Exporters, splitList(*exportFlag)...)
 
-	hosts := strings.Split(*hostsFlag, ",")
-	for i := range hosts {
-		hosts[i] = strings.TrimSpace(hosts[i])
+	if len(cfg.Hosts) == 0 && len(cfg.Discovery) == 0 && len(cfg.Tenants) == 0 {
+		log.Fatal("Error: -hosts flag or a config file with hosts or discovery is required (comma-separated list of hosts)")
+	}
+
+	var user *account
+	if cfg.User != "" {
+		var err error
+		if user, err = lookupAccount(cfg.User); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
+	if cfg.PluginDir != "" {
+		if err := loadPlugins(cfg.PluginDir, user); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+
+	setup, err := newMonitorSetup(cfg, *configFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	tenants, err := loadTenants(cfg, *configFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	hosts := make([]string, 0, len(setup.targets))
+	for _, t := range setup.targets {
+		hosts = append(hosts, t.name)
+	}
 	fmt.Printf("Starting Network Monitor\n")
 	fmt.Printf("Monitoring hosts: %v\n", hosts)
-	fmt.Printf("Ping interval: %v\n", *intervalFlag)
-	fmt.Printf("Web server port: %d\n", *portFlag)
-	fmt.Println("\nNote: This program requires raw socket access. Run with sudo if needed.")
+	for _, t := range tenants {
+		fmt.Printf("Tenant %s: %d hosts\n", t.name, len(t.setup.targets))
+	}
+	fmt.Printf("Ping interval: %v\n", cfg.Interval)
+	fmt.Printf("Web server port: %d\n", cfg.Port)
+	if cfg.DryRun {
+		fmt.Println("\nDry run: probes are simulated, nothing is sent.")
+	} else {
+		fmt.Println("\nNote: This program requires raw socket access. Run with sudo if needed.")
+	}
 
-	monitor := NewMonitor(hosts, *portFlag, *intervalFlag)
-	monitor.Start()
+	arpWatcher, err := newARPWatcher(cfg.ARPWatch)
+	if err != nil {
+		log.Fatalf("Error: arp_watch: %v", err)
+	}
+	bgpWatcher, err := newBGPWatcher(cfg.BGP)
+	if err != nil {
+		log.Fatalf("Error: bgp: %v", err)
+	}
+	// The packet socket needs root too.
+	capturer, err := newCapturer(cfg.Capture, cfg.HistoryDir)
+	if err != nil {
+		log.Fatalf("Error: capture: %v", err)
+	}
+	if err := configureTLS(cfg.TLS); err != nil {
+		log.Fatalf("Error: tls: %v", err)
+	}
+	if err := configureBrowser(cfg.Browser, cfg.HistoryDir); err != nil {
+		log.Fatalf("Error: browser: %v", err)
+	}
+
+	// Raw sockets and low ports need root; open them before switching
+	// to the unprivileged user.
+	snmp, err := newSNMPAgent(cfg.SNMP)
+	if err != nil {
+		log.Fatalf("Error: snmp: %v", err)
+	}
+	reflector, err := newRTPReflector(cfg.RTPReflector)
+	if err != nil {
+		log.Fatalf("Error: rtp_reflector: %v", err)
+	}
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	listener, err := sdListener()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if listener == nil {
+		if listener, err = net.Listen("tcp", addr); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if _, port, err := net.SplitHostPort(listener.Addr().String()); err == nil {
+		addr = ":" + port
+	}
+	if user != nil {
+		sockets := setup.sockets()
+		for _, t := range tenants {
+			sockets = append(sockets, t.setup.sockets()...)
+		}
+		if err := openICMPSockets(sockets); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := user.drop(); err != nil {
+			log.Fatalf("Error: switching to user %s: %v", user.name, err)
+		}
+	}
+
+	monitor, err := setup.build()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if cfg.Debug {
+		monitor.enableDebug(cfg.DebugToken)
+	}
+	if arpWatcher != nil && cfg.HistoryDir != "" {
+		if err := arpWatcher.open(cfg.HistoryDir); err != nil {
+			log.Fatalf("Error: loading devices: %v", err)
+		}
+	}
+	if capturer != nil {
+		if err := capturer.open(); err != nil {
+			log.Fatalf("Error: loading captures: %v", err)
+		}
+		monitor.capturer = capturer
+	}
+	setup.run(monitor)
+	if snmp != nil {
+		go snmp.serve(monitor)
+	}
+	if reflector != nil {
+		go reflector.serve()
+	}
+	if arpWatcher != nil {
+		monitor.arpWatcher = arpWatcher
+		go monitor.watchNeighbors(arpWatcher)
+	}
+	if bgpWatcher != nil {
+		monitor.bgpWatcher = bgpWatcher
+		if bgpWatcher.risURL != "" {
+			go monitor.watchRISLive(bgpWatcher)
+		}
+		if bgpWatcher.bmp != nil {
+			go monitor.serveBMP(bgpWatcher)
+		}
+	}
+
+	monitored := len(setup.targets)
+	router := &tenantRouter{fallback: monitor}
+	if len(tenants) > 0 && len(cfg.Hosts) == 0 && len(cfg.Discovery) == 0 {
+		// Requests for no tenant in particular find nothing.
+		router.fallback = nil
+	}
+	for _, t := range tenants {
+		if t.monitor, err = t.setup.build(); err != nil {
+			log.Fatalf("Error: tenant %s: %v", t.name, err)
+		}
+		t.setup.run(t.monitor)
+		router.tenants = append(router.tenants, t)
+		monitored += len(t.setup.targets)
+	}
+
+	fmt.Printf("\nWeb interface available at: http://localhost%s%s/\n", addr, monitor.basePath)
+
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go monitor.watchdog(interval)
+	}
+	if err := sdNotify(fmt.Sprintf("READY=1\nSTATUS=Monitoring %d hosts", monitored)); err != nil {
+		log.Printf("Notifying systemd failed: %v", err)
+	}
+
+	var handler http.Handler = monitor
+	if len(tenants) > 0 {
+		handler = router
+	}
+	server := &http.Server{
+		Handler:           newAPILimiter(cfg.APILimits).wrap(handler),
+		ReadHeaderTimeout: 10 * time.Second,
+		MaxHeaderBytes:    64 << 10,
+	}
+	log.Fatal(server.Serve(listener))
+}
+
+// monitorSetup is a config file turned into what its monitor needs, with
+// everything that can fail checked before the monitor starts.
+type monitorSetup struct {
+	cfg         *Config
+	configPath  string
+	targets     []*target
+	notifiers   []*namedNotifier
+	routes      []*route
+	actions     []*action
+	webhooks    []*webhook
+	oncall      []*onCallSchedule
+	exporters   []Exporter
+	discoverers map[string]discoverer
+	enricher    *enricher
+	reports     []*reportSchedule
+	parquet     *parquetExport
+	archive     *historyArchive
+	blackouts   blackouts
+	ha          *haState
+}
+
+func newMonitorSetup(cfg *Config, configPath string) (*monitorSetup, error) {
+	s := &monitorSetup{cfg: cfg, configPath: configPath, discoverers: map[string]discoverer{}}
+	if err := validateWindows(cfg.Windows); err != nil {
+		return nil, err
+	}
+	for _, h := range cfg.Hosts {
+		ts, err := cfg.hostTargets(h)
+		if err != nil {
+			return nil, err
+		}
+		s.targets = append(s.targets, ts...)
+	}
+
+	for _, oc := range cfg.OnCall {
+		o, err := newOnCallSchedule(oc)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(s.oncall, func(other *onCallSchedule) bool { return other.Name == o.Name }) {
+			return nil, fmt.Errorf("on-call schedule %s is defined twice", o.Name)
+		}
+		s.oncall = append(s.oncall, o)
+	}
+
+	for _, nc := range cfg.Notifiers {
+		n, err := parseNotifier(nc.URL, s.oncall)
+		if err != nil {
+			return nil, err
+		}
+		if nc.Name != "" {
+			n.name = nc.Name
+		}
+		own := TemplateConfig{Title: nc.Title, Text: nc.Text}
+		if n.templates, err = parseTemplates(own, cfg.Templates); err != nil {
+			return nil, fmt.Errorf("notifier %s: %v", n.name, err)
+		}
+		s.notifiers = append(s.notifiers, n)
+	}
+
+	var escalations []*escalation
+	for _, ec := range cfg.Escalations {
+		e, err := newEscalation(ec, s.notifiers)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(escalations, func(o *escalation) bool { return o.name == e.name }) {
+			return nil, fmt.Errorf("escalation %s is defined twice", e.name)
+		}
+		escalations = append(escalations, e)
+	}
+
+	for i, rc := range cfg.Routes {
+		r, err := newRoute(rc, s.notifiers, escalations)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i+1, err)
+		}
+		s.routes = append(s.routes, r)
+	}
+
+	for i, ac := range cfg.Actions {
+		a, err := newAction(ac)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %v", i+1, err)
+		}
+		s.actions = append(s.actions, a)
+	}
+
+	for i, wc := range cfg.Webhooks {
+		w, err := newWebhook(wc)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %d: %v", i+1, err)
+		}
+		s.webhooks = append(s.webhooks, w)
+	}
+
+	for _, raw := range cfg.Exporters {
+		e, err := parseExporter(raw)
+		if err != nil {
+			return nil, err
+		}
+		s.exporters = append(s.exporters, e)
+	}
+
+	for _, raw := range cfg.Discovery {
+		d, err := parseDiscoverer(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u, _ := url.Parse(raw); u.User != nil {
+			raw = u.Redacted()
+		}
+		s.discoverers[raw] = d
+	}
+
+	var err error
+	if s.enricher, err = newEnricher(cfg.Enrich); err != nil {
+		return nil, fmt.Errorf("enrich: %v", err)
+	}
+
+	for i, rc := range cfg.Reports {
+		r, err := newReportSchedule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("report %d: %v", i+1, err)
+		}
+		s.reports = append(s.reports, r)
+	}
+	if s.parquet, err = newParquetExport(cfg.Parquet, cfg.HistoryDir); err != nil {
+		return nil, fmt.Errorf("parquet: %v", err)
+	}
+	if cfg.HistoryArchive != "" && cfg.HistoryDir == "" {
+		return nil, fmt.Errorf("history_archive needs history_dir")
+	}
+	if s.archive, err = newHistoryArchive(cfg.HistoryArchive); err != nil {
+		return nil, fmt.Errorf("history_archive: %v", err)
+	}
+
+	if s.blackouts, err = newBlackouts(cfg.Blackouts); err != nil {
+		return nil, err
+	}
+	if s.ha, err = newHA(cfg.HA); err != nil {
+		return nil, fmt.Errorf("ha: %v", err)
+	}
+	return s, nil
+}
+
+// sockets returns the targets whose ICMP sockets must be opened while
+// still root. Hosts discovered or added through the API use the default
+// socket unless a group sets their source address or interface.
+func (s *monitorSetup) sockets() []*target {
+	return append(slices.Clip(s.targets), &target{kind: "icmp"})
+}
+
+// build creates the monitor and loads what it keeps on disk. Files are
+// created as the unprivileged user, so this runs after switching.
+func (s *monitorSetup) build() (*Monitor, error) {
+	cfg := s.cfg
+	monitor := NewMonitor(s.targets, cfg.Port, cfg.Interval)
+	monitor.workers = cfg.Workers
+	if cfg.Windows != nil {
+		monitor.windows = cfg.Windows
+	}
+	for _, t := range s.targets {
+		if d := t.retry.duration(); d > cfg.Interval {
+			log.Printf("%s: a probe cycle can take up to %v, longer than the %v interval; runs due while one is still going are skipped", t.name, d, cfg.Interval)
+		}
+	}
+	if cfg.DryRun {
+		monitor.prober = simulation
+	}
+	monitor.limiter = newRateLimiter(cfg.RateLimit)
+	monitor.notifiers = s.notifiers
+	monitor.routes = s.routes
+	monitor.actions = s.actions
+	monitor.webhooks = s.webhooks
+	monitor.oncall = s.oncall
+	monitor.blackouts = s.blackouts
+	monitor.ha = s.ha
+	monitor.exporters = s.exporters
+	monitor.config = cfg
+	monitor.configPath = s.configPath
+	monitor.dashboardURL = cfg.DashboardURL
+	monitor.basePath = normalizeBasePath(cfg.BasePath)
+	monitor.corsOrigins = cfg.CORSOrigins
+	var err error
+	if monitor.auth, err = newOIDCAuth(cfg.OIDC, cfg.DashboardURL, monitor.basePath); err != nil {
+		return nil, fmt.Errorf("oidc: %v", err)
+	}
+	if cfg.WebDir != "" {
+		if monitor.web, err = overrideWeb(cfg.WebDir); err != nil {
+			return nil, err
+		}
+	}
+	monitor.incidents.repeat = cfg.RepeatInterval
+	if cfg.HistoryRetention > 0 {
+		monitor.history.retention = cfg.HistoryRetention
+	}
+	if cfg.HistoryDir != "" {
+		monitor.history.archive = s.archive
+		if err := monitor.history.open(cfg.HistoryDir); err != nil {
+			return nil, fmt.Errorf("loading history: %v", err)
+		}
+		if err := monitor.ui.open(cfg.HistoryDir); err != nil {
+			return nil, fmt.Errorf("loading UI settings: %v", err)
+		}
+		if err := monitor.hostEdits.open(cfg.HistoryDir, monitor.hosts()); err != nil {
+			return nil, fmt.Errorf("loading host edits: %v", err)
+		}
+		if err := monitor.paused.open(cfg.HistoryDir, monitor.hosts()); err != nil {
+			return nil, fmt.Errorf("loading paused hosts: %v", err)
+		}
+		if err := monitor.audit.open(cfg.HistoryDir); err != nil {
+			return nil, fmt.Errorf("loading audit log: %v", err)
+		}
+		if err := monitor.tokens.open(cfg.HistoryDir); err != nil {
+			return nil, fmt.Errorf("loading API tokens: %v", err)
+		}
+		if err := monitor.added.open(cfg.HistoryDir); err != nil {
+			return nil, fmt.Errorf("loading added hosts: %v", err)
+		}
+		if err := monitor.incidents.restore(cfg.HistoryDir, monitor.clock.Now()); err != nil {
+			return nil, fmt.Errorf("loading restored incidents: %v", err)
+		}
+	}
+	if s.configPath != "" {
+		monitor.auditConfig(s.configPath, len(s.targets))
+	}
+	return monitor, nil
+}
 
-	addr := fmt.Sprintf(":%d", *portFlag)
-	fmt.Printf("\nWeb interface available at: http://localhost%s\n", addr)
+// run starts probing and everything that runs alongside.
+func (s *monitorSetup) run(monitor *Monitor) {
+	if s.ha != nil {
+		s.ha.loadStats(monitor)
+		s.ha.elect()
+		s.ha.heartbeat()
+		go s.ha.run(monitor)
+	}
+	monitor.Start()
+	monitor.syncAddedHosts()
+	if s.enricher != nil {
+		monitor.enricher = s.enricher
+		go monitor.enrichHosts(s.enricher)
+	}
+	for _, r := range s.reports {
+		go monitor.runReports(r)
+	}
+	if s.parquet != nil {
+		go monitor.runParquetExport(s.parquet)
+	}
+	s.blackouts.run(monitor.clock)
+	for name, d := range s.discoverers {
+		go monitor.discover(name, d, s.cfg)
+	}
+	if len(s.cfg.Escalations) > 0 {
+		go monitor.runEscalations()
+	}
+}
 
-	log.Fatal(http.ListenAndServe(addr, monitor))
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
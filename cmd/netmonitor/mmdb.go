@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdb reads MaxMind DB files such as GeoLite2-ASN and GeoLite2-City, as
+// described at https://maxmind.github.io/MaxMind-DB/. The whole file is
+// kept in memory; lookups decode records into plain Go values
+// (map[string]any, []any, string, float64, uint64, int64, bool, []byte).
+type mmdb struct {
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	dataStart  uint
+	ipv4Start  uint
+	Metadata   map[string]any
+}
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+func openMMDB(path string) (*mmdb, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(data, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+	}
+	db := &mmdb{data: data}
+	meta, _, err := db.decode(data[i+len(mmdbMetadataMarker):], 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid metadata: %v", path, err)
+	}
+	db.Metadata, _ = meta.(map[string]any)
+	db.nodeCount = mmdbUint(db.Metadata["node_count"])
+	db.recordSize = mmdbUint(db.Metadata["record_size"])
+	db.ipVersion = mmdbUint(db.Metadata["ip_version"])
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, db.recordSize)
+	}
+	// The search tree is followed by 16 zero bytes, then the data section.
+	db.dataStart = db.nodeCount*db.recordSize/4 + 16
+	if db.dataStart > uint(i) {
+		return nil, fmt.Errorf("%s: search tree exceeds file", path)
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 trees.
+	if db.ipVersion == 6 {
+		for range 96 {
+			if db.ipv4Start >= db.nodeCount {
+				break
+			}
+			db.ipv4Start = db.readNode(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+func mmdbUint(v any) uint {
+	switch n := v.(type) {
+	case uint64:
+		return uint(n)
+	case int64:
+		return uint(n)
+	}
+	return 0
+}
+
+// readNode returns the left (bit 0) or right (bit 1) record of a node.
+func (db *mmdb) readNode(node uint, bit uint) uint {
+	size := db.recordSize
+	off := node * size / 4
+	b := db.data[off:]
+	switch size {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Lookup returns the record for ip and the prefix length of the network it
+// was found in, or nil when the database has no data for it.
+func (db *mmdb) Lookup(ip net.IP) (any, int, error) {
+	// IPv4 lookups start at the IPv4 subtree, so the prefix length counts
+	// IPv4 bits.
+	bits := ip.To16()
+	node := uint(0)
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		node = db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, 0, errors.New("IPv6 lookup in an IPv4 database")
+	}
+
+	prefix := 0
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-i%8)) & 1
+		node = db.readNode(node, bit)
+		prefix = i + 1
+	}
+	switch {
+	case node == db.nodeCount:
+		return nil, 0, nil
+	case node < db.nodeCount:
+		return nil, 0, errors.New("invalid search tree")
+	}
+
+	off := node - db.nodeCount - 16
+	if db.dataStart+off >= uint(len(db.data)) {
+		return nil, 0, errors.New("invalid data pointer")
+	}
+	v, _, err := db.decode(db.data[db.dataStart:], off)
+	return v, prefix, err
+}
+
+// decode reads the value at off in section, returning it and the offset
+// after it. Pointers are relative to the start of section.
+func (db *mmdb) decode(section []byte, off uint) (any, uint, error) {
+	if off >= uint(len(section)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	ctrl := section[off]
+	off++
+	typ := ctrl >> 5
+
+	if typ == 1 { // pointer
+		ss := (ctrl >> 3) & 3
+		if off+uint(ss)+1 > uint(len(section)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		b := section[off : off+uint(ss)+1]
+		var p uint
+		switch ss {
+		case 0:
+			p = uint(ctrl&7)<<8 | uint(b[0])
+		case 1:
+			p = (uint(ctrl&7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 2:
+			p = (uint(ctrl&7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		case 3:
+			p = uint(binary.BigEndian.Uint32(b))
+		}
+		v, _, err := db.decode(section, p)
+		return v, off + uint(ss) + 1, err
+	}
+
+	if typ == 0 { // extended
+		if off >= uint(len(section)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		typ = section[off] + 7
+		off++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if off+n > uint(len(section)) {
+			return nil, 0, errors.New("unexpected end of data")
+		}
+		var extra uint
+		for _, b := range section[off : off+n] {
+			extra = extra<<8 | uint(b)
+		}
+		off += n
+		size = [...]uint{29, 285, 65821}[n-1] + extra
+	}
+
+	payload := func() ([]byte, error) {
+		if off+size > uint(len(section)) {
+			return nil, errors.New("unexpected end of data")
+		}
+		return section[off : off+size], nil
+	}
+	switch typ {
+	case 2, 4: // string, bytes
+		b, err := payload()
+		if err != nil {
+			return nil, 0, err
+		}
+		if typ == 2 {
+			return string(b), off + size, nil
+		}
+		return append([]byte(nil), b...), off + size, nil
+	case 3, 15: // double, float
+		b, err := payload()
+		if err != nil {
+			return nil, 0, err
+		}
+		if typ == 3 && size == 8 {
+			return math.Float64frombits(binary.BigEndian.Uint64(b)), off + size, nil
+		}
+		if typ == 15 && size == 4 {
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off + size, nil
+		}
+		return nil, 0, errors.New("invalid float size")
+	case 5, 6, 9, 10: // unsigned integers
+		b, err := payload()
+		if err != nil {
+			return nil, 0, err
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c) // uint128 values beyond 64 bits are truncated
+		}
+		return n, off + size, nil
+	case 8: // int32
+		b, err := payload()
+		if err != nil {
+			return nil, 0, err
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		if size == 4 {
+			return int64(int32(n)), off + size, nil
+		}
+		return int64(n), off + size, nil
+	case 14: // boolean, the value is the size
+		return size != 0, off, nil
+	case 7: // map
+		m := map[string]any{}
+		for range size {
+			k, next, err := db.decode(section, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			v, next, err := db.decode(section, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case 11: // array
+		var a []any
+		for range size {
+			v, next, err := db.decode(section, off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
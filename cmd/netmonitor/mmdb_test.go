@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/binary"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMMDBDecode(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	double := binary.BigEndian.AppendUint64([]byte{0x68}, math.Float64bits(52.52))
+	float := binary.BigEndian.AppendUint32([]byte{0x04, 0x08}, math.Float32bits(1.5))
+	tests := []struct {
+		name string
+		in   []byte
+		off  uint
+		want any
+		next uint
+		err  string
+	}{
+		{"string", []byte("\x42hi"), 0, "hi", 3, ""},
+		{"empty string", []byte{0x40}, 0, "", 1, ""},
+		{"string of 30", append([]byte{0x5d, 1}, long[:30]...), 0, long[:30], 32, ""},
+		{"string of 300", append([]byte{0x5e, 0, 15}, long...), 0, long, 303, ""},
+		{"bytes", []byte{0x82, 1, 2}, 0, []byte{1, 2}, 3, ""},
+		{"double", double, 0, 52.52, 9, ""},
+		{"float", float, 0, 1.5, 6, ""},
+		{"uint16", []byte{0xa2, 1, 0}, 0, uint64(256), 3, ""},
+		{"uint32", []byte{0xc1, 42}, 0, uint64(42), 2, ""},
+		{"uint32 zero", []byte{0xc0}, 0, uint64(0), 1, ""},
+		{"uint64", []byte{0x01, 2, 5}, 0, uint64(5), 3, ""},
+		{"uint128", []byte{0x02, 3, 1, 2}, 0, uint64(0x0102), 4, ""},
+		{"int32", []byte{0x04, 1, 0xff, 0xff, 0xff, 0xfe}, 0, int64(-2), 6, ""},
+		{"short int32", []byte{0x01, 1, 0xff}, 0, int64(255), 3, ""},
+		{"true", []byte{0x01, 7}, 0, true, 2, ""},
+		{"false", []byte{0x00, 7}, 0, false, 2, ""},
+		{"map", []byte("\xe2\x41a\xc1\x01\x41b\x42hi"), 0, map[string]any{"a": uint64(1), "b": "hi"}, 10, ""},
+		{"array", []byte("\x02\x04\x41x\x41y"), 0, []any{"x", "y"}, 6, ""},
+		{"pointer", []byte("\x42hi\x20\x00"), 3, "hi", 5, ""},
+		{"pointer in a map", []byte("\x42hi\xe1\x20\x00\x20\x00"), 3, map[string]any{"hi": "hi"}, 8, ""},
+		{"truncated string", []byte("\x45hi"), 0, nil, 0, "unexpected end"},
+		{"truncated pointer", []byte{0x28}, 0, nil, 0, "unexpected end"},
+		{"past the end", []byte{0x40}, 1, nil, 0, "unexpected end"},
+		{"double of 4 bytes", []byte{0x64, 0, 0, 0, 0}, 0, nil, 0, "invalid float size"},
+		{"numeric key", []byte{0xe1, 0xc1, 1, 0xc1, 1}, 0, nil, 0, "not a string"},
+		{"data cache container", []byte{0x00, 5}, 0, nil, 0, "unsupported data type 12"},
+	}
+	db := &mmdb{}
+	for _, tt := range tests {
+		v, next, err := db.decode(tt.in, tt.off)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(v, tt.want) || next != tt.next {
+			t.Errorf("%s: %#v up to %d, %v; want %#v up to %d", tt.name, v, next, err, tt.want, tt.next)
+		}
+	}
+}
+
+// mmdbEncode encodes strings, uint32s as uint32, maps and slices, each of
+// fewer than 285 bytes or entries.
+func mmdbEncode(v any) []byte {
+	header := func(typ byte, size int) []byte {
+		var extra []byte
+		if size >= 29 {
+			extra = []byte{byte(size - 29)}
+			size = 29
+		}
+		b := []byte{typ<<5 | byte(size)}
+		if typ > 7 {
+			b = []byte{byte(size), typ - 7}
+		}
+		return append(b, extra...)
+	}
+	switch v := v.(type) {
+	case string:
+		return append(header(2, len(v)), v...)
+	case uint32:
+		b := binary.BigEndian.AppendUint32(nil, v)
+		return append(header(6, 4), b...)
+	case map[string]any:
+		b := header(7, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			b = append(b, mmdbEncode(k)...)
+			b = append(b, mmdbEncode(v[k])...)
+		}
+		return b
+	case []any:
+		b := header(11, len(v))
+		for _, e := range v {
+			b = append(b, mmdbEncode(e)...)
+		}
+		return b
+	}
+	panic("cannot encode " + reflect.TypeOf(v).String())
+}
+
+// writeMMDB writes a database with the records of networks and returns its
+// path.
+func writeMMDB(t *testing.T, ipVersion, recordSize int, networks map[string]any) string {
+	t.Helper()
+	// Records are node indexes, empty for no data or -1-i for data i.
+	const empty = math.MinInt
+	type node [2]int
+	nodes := []node{{empty, empty}}
+	var data []byte
+	var offsets []int
+	for _, cidr := range slices.Sorted(maps.Keys(networks)) {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		bits := []byte(ipnet.IP.To16())
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			bits = ip4
+			if ipVersion == 6 {
+				bits = append(make([]byte, 12), ip4...)
+				ones += 96
+			}
+		}
+		offsets = append(offsets, len(data))
+		data = append(data, mmdbEncode(networks[cidr])...)
+
+		n := 0
+		for i := range ones {
+			bit := bits[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				nodes[n][bit] = -len(offsets)
+				break
+			}
+			if nodes[n][bit] == empty {
+				nodes = append(nodes, node{empty, empty})
+				nodes[n][bit] = len(nodes) - 1
+			}
+			n = nodes[n][bit]
+		}
+	}
+
+	var tree []byte
+	for _, n := range nodes {
+		var records [2]uint
+		for i, r := range n {
+			switch {
+			case r == empty:
+				records[i] = uint(len(nodes))
+			case r < 0:
+				records[i] = uint(len(nodes) + 16 + offsets[-r-1])
+			default:
+				records[i] = uint(r)
+			}
+		}
+		l, r := records[0], records[1]
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(l>>20&0xf0|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			tree = binary.BigEndian.AppendUint32(tree, uint32(l))
+			tree = binary.BigEndian.AppendUint32(tree, uint32(r))
+		}
+	}
+
+	file := append(tree, make([]byte, 16)...)
+	file = append(file, data...)
+	file = append(file, mmdbMetadataMarker...)
+	file = append(file, mmdbEncode(map[string]any{
+		"node_count":    uint32(len(nodes)),
+		"record_size":   uint32(recordSize),
+		"ip_version":    uint32(ipVersion),
+		"database_type": "GeoLite2-ASN",
+	})...)
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMMDBLookup(t *testing.T) {
+	google := map[string]any{"autonomous_system_number": uint32(15169), "autonomous_system_organization": "GOOGLE"}
+	cloudflare := map[string]any{"autonomous_system_number": uint32(13335), "autonomous_system_organization": "CLOUDFLARENET"}
+	tests := []struct {
+		ip      string
+		asn     uint32
+		network string
+	}{
+		{"8.8.8.8", 15169, "8.8.8.0/24"},
+		{"8.8.4.4", 15169, "8.8.4.0/22"},
+		{"1.1.1.1", 13335, "1.1.1.0/24"},
+		{"9.9.9.9", 0, ""},
+		{"2001:4860:4860::8888", 15169, "2001:4860::/32"},
+	}
+	for _, version := range []int{4, 6} {
+		networks := map[string]any{"8.8.8.0/24": google, "8.8.4.0/22": google, "1.1.1.0/24": cloudflare}
+		if version == 6 {
+			networks["2001:4860::/32"] = google
+		}
+		for _, size := range []int{24, 28, 32} {
+			db, err := openMMDB(writeMMDB(t, version, size, networks))
+			if err != nil {
+				t.Fatalf("IPv%d, %d bit records: %v", version, size, err)
+			}
+			if db.Metadata["database_type"] != "GeoLite2-ASN" {
+				t.Errorf("IPv%d, %d bit records: metadata %v", version, size, db.Metadata)
+			}
+			lookup := mmdbASNLookup{db}
+			for _, tt := range tests {
+				info, err := lookup.LookupASN(net.ParseIP(tt.ip))
+				if version == 4 && strings.Contains(tt.ip, ":") {
+					if err == nil {
+						t.Errorf("IPv4 database, %d bit records: %s found %+v", size, tt.ip, info)
+					}
+					continue
+				}
+				if err != nil || info.ASN != tt.asn || info.Network != tt.network {
+					t.Errorf("IPv%d, %d bit records: %s is %+v, %v; want AS%d in %s", version, size, tt.ip, info, err, tt.asn, tt.network)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenMMDB(t *testing.T) {
+	file := func(metadata map[string]any) []byte {
+		return append(append(make([]byte, 64), mmdbMetadataMarker...), mmdbEncode(metadata)...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"not mmdb", []byte("GeoIP legacy data"), "not a MaxMind DB"},
+		{"no record size", file(map[string]any{"node_count": uint32(1), "ip_version": uint32(4)}), "unsupported record size 0"},
+		{"record size", file(map[string]any{"node_count": uint32(1), "record_size": uint32(20), "ip_version": uint32(4)}), "unsupported record size 20"},
+		{"tree too large", file(map[string]any{"node_count": uint32(100), "record_size": uint32(24), "ip_version": uint32(4)}), "search tree exceeds file"},
+		{"bad metadata", append([]byte("\xab\xcd\xefMaxMind.com"), 0x5f), "invalid metadata"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "test.mmdb")
+		os.WriteFile(path, tt.data, 0o644)
+		if _, err := openMMDB(path); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
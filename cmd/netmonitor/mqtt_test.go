@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The remaining lengths of the MQTT 3.1.1 specification, 2.2.3.
+func TestMQTTPacket(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{0x30, 0x00}},
+		{127, []byte{0x30, 0x7f}},
+		{128, []byte{0x30, 0x80, 0x01}},
+		{16383, []byte{0x30, 0xff, 0x7f}},
+		{16384, []byte{0x30, 0x80, 0x80, 0x01}},
+		{2097152, []byte{0x30, 0x80, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		body := bytes.Repeat([]byte{'x'}, tt.size)
+		p := mqttPacket(0x30, body)
+		if !bytes.Equal(p[:len(tt.header)], tt.header) || len(p) != len(tt.header)+tt.size {
+			t.Errorf("%d bytes: header % x", tt.size, p[:min(len(p), 5)])
+		}
+		typ, got, err := mqttReadPacket(bufio.NewReader(bytes.NewReader(p)))
+		if err != nil || typ != 0x30 || !bytes.Equal(got, body) {
+			t.Errorf("%d bytes: read type %#x, %d bytes, %v", tt.size, typ, len(got), err)
+		}
+	}
+	if _, _, err := mqttReadPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}))); err == nil {
+		t.Error("five byte remaining length read")
+	}
+	if _, _, err := mqttReadPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0x05, 'a'}))); err == nil {
+		t.Error("truncated packet read")
+	}
+}
+
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// mqttBroker accepts a connection, hands over the body of its CONNECT and
+// then every message published.
+func mqttBroker(connects chan<- []byte, messages chan<- mqttMessage) func(net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		typ, body, err := mqttReadPacket(r)
+		if err != nil || typ != 0x10 {
+			return
+		}
+		connects <- body
+		conn.Write([]byte{0x20, 2, 0, 0})
+		for {
+			header, err := r.Peek(1)
+			if err != nil {
+				return
+			}
+			retain := header[0]&0x01 != 0
+			typ, body, err := mqttReadPacket(r)
+			if err != nil {
+				return
+			}
+			if typ == 0x30 {
+				n := int(binary.BigEndian.Uint16(body))
+				messages <- mqttMessage{string(body[2 : 2+n]), string(body[2+n:]), retain}
+			}
+		}
+	}
+}
+
+func TestMQTTExporter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	connects, messages := make(chan []byte, 1), make(chan mqttMessage, 20)
+	addr := serveTCP(t, mqttBroker(connects, messages))
+	e, err := parseExporter("mqtt://monitor:s3cret@" + addr + "?prefix=/lab/&discovery=homeassistant&client_id=probe1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var connect []byte
+	select {
+	case connect = <-connects:
+	case <-time.After(time.Second):
+		t.Fatal("no CONNECT")
+	}
+	want := mqttString(nil, "MQTT")
+	want = append(want, 4, 0xe6, 0, 60)
+	for _, s := range []string{"probe1", "lab/status", "offline", "monitor", "s3cret"} {
+		want = mqttString(want, s)
+	}
+	if !bytes.Equal(connect, want) {
+		t.Errorf("CONNECT % x, want % x", connect, want)
+	}
+
+	e.Export(ProbeEvent{Host: "8.8.8.8", Type: "icmp", Status: "up", Latency: 12.5})
+	e.Export(ProbeEvent{Host: "8.8.8.8", Type: "icmp", Status: "down", Error: "timeout"})
+	var got []mqttMessage
+	for len(got) < 7 {
+		select {
+		case msg := <-messages:
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("got %d messages, want 7", len(got))
+		}
+	}
+
+	var topics []string
+	for _, msg := range got {
+		topics = append(topics, msg.topic)
+		if !msg.retain {
+			t.Errorf("%s: not retained", msg.topic)
+		}
+	}
+	wantTopics := []string{
+		"lab/status",
+		"homeassistant/binary_sensor/netmonitor_8_8_8_8/connectivity/config",
+		"homeassistant/sensor/netmonitor_8_8_8_8/status/config",
+		"homeassistant/sensor/netmonitor_8_8_8_8/latency/config",
+		"homeassistant/sensor/netmonitor_8_8_8_8/loss/config",
+		"lab/8_8_8_8/state",
+		"lab/8_8_8_8/state",
+	}
+	if !slices.Equal(topics, wantTopics) {
+		t.Errorf("published to %q, want %q", topics, wantTopics)
+	}
+	if got[0].payload != "online" {
+		t.Errorf("status %q, want online", got[0].payload)
+	}
+
+	var config struct {
+		StateTopic        string `json:"state_topic"`
+		AvailabilityTopic string `json:"availability_topic"`
+		UniqueID          string `json:"unique_id"`
+	}
+	if err := json.Unmarshal([]byte(got[1].payload), &config); err != nil || config.StateTopic != "lab/8_8_8_8/state" ||
+		config.AvailabilityTopic != "lab/status" || config.UniqueID != "netmonitor_8_8_8_8_connectivity" {
+		t.Errorf("discovery config %s", got[1].payload)
+	}
+	var state struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(got[6].payload), &state); err != nil || state.Status != "down" || state.Error != "timeout" {
+		t.Errorf("state %s", got[6].payload)
+	}
+}
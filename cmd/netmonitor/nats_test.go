@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type natsMessage struct {
+	subject string
+	payload string
+}
+
+// natsServer greets with info, hands over the options of the CONNECT and
+// then answers the PING with reply and hands over every message published.
+func natsServer(info, reply string, connects chan<- map[string]any, messages chan<- natsMessage) func(net.Conn) {
+	return func(conn net.Conn) {
+		fmt.Fprintf(conn, "INFO %s\r\n", info)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch cmd {
+			case "CONNECT":
+				var opts map[string]any
+				json.Unmarshal([]byte(args), &opts)
+				connects <- opts
+			case "PING":
+				fmt.Fprintf(conn, "%s\r\n", reply)
+			case "PUB":
+				var subject string
+				var n int
+				fmt.Sscan(args, &subject, &n)
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				messages <- natsMessage{subject, string(payload[:n])}
+			}
+		}
+	}
+}
+
+func TestNATSExporter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name    string
+		url     string
+		connect map[string]any
+	}{
+		{"user", "nats://monitor:s3cret@%s/lab/probes", map[string]any{"user": "monitor", "pass": "s3cret"}},
+		{"token", "nats://t0ken@%s/lab/probes", map[string]any{"auth_token": "t0ken"}},
+	}
+	for _, tt := range tests {
+		connects, messages := make(chan map[string]any, 1), make(chan natsMessage, 10)
+		addr := serveTCP(t, natsServer(`{"server_id":"test"}`, "PONG", connects, messages))
+		e, err := parseExporter(fmt.Sprintf(tt.url, addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var connect map[string]any
+		select {
+		case connect = <-connects:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: no CONNECT", tt.name)
+		}
+		for k, v := range tt.connect {
+			if connect[k] != v {
+				t.Errorf("%s: CONNECT %s = %v, want %v", tt.name, k, connect[k], v)
+			}
+		}
+		if connect["verbose"] != false || connect["name"] != "netmonitor" {
+			t.Errorf("%s: CONNECT %v", tt.name, connect)
+		}
+
+		e.Export(ProbeEvent{Host: "example.com:443", Type: "tls", Status: "up", Previous: "up"})
+		e.Export(ProbeEvent{Host: "example.com:443", Type: "tls", Status: "down", Previous: "up", Error: "expired"})
+		var subjects []string
+		var last natsMessage
+		for len(subjects) < 3 {
+			select {
+			case last = <-messages:
+				subjects = append(subjects, last.subject)
+			case <-time.After(time.Second):
+				t.Fatalf("%s: got %d messages, want 3", tt.name, len(subjects))
+			}
+		}
+		want := []string{"lab.probes.results.example_com_443", "lab.probes.results.example_com_443", "lab.probes.changes.example_com_443"}
+		if !slices.Equal(subjects, want) {
+			t.Errorf("%s: published to %q, want %q", tt.name, subjects, want)
+		}
+		var ev ProbeEvent
+		if err := json.Unmarshal([]byte(last.payload), &ev); err != nil || ev.Status != "down" || ev.Error != "expired" {
+			t.Errorf("%s: change %s", tt.name, last.payload)
+		}
+	}
+}
+
+func TestNATSSession(t *testing.T) {
+	tests := []struct {
+		name  string
+		info  string
+		reply string
+		err   string
+	}{
+		{"refused", `{}`, "-ERR 'Authorization Violation'", "Authorization Violation"},
+		{"not nats", `not json`, "PONG", "unexpected greeting"},
+	}
+	for _, tt := range tests {
+		addr := serveTCP(t, natsServer(tt.info, tt.reply, make(chan map[string]any, 1), nil))
+		e := &natsExporter{addr: addr, subject: "netmonitor"}
+		if err := e.session(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
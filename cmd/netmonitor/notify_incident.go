@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Incident management notifiers open an incident when a host goes down or
+// degrades and resolve it automatically when the host recovers:
+//
+//	pagerduty://ROUTING_KEY?service.db=DB_ROUTING_KEY&service.10.0.0.1=KEY
+//	opsgenie://API_KEY?region=eu&team.db=dba-oncall
+//
+// The service.<host or tag> parameters send alerts for matching hosts to
+// another PagerDuty service; team.<host or tag> adds an Opsgenie team as
+// responder. The host name is matched first, then the host's tags in order.
+func init() {
+	notifierFactories["pagerduty"] = newPagerDutyNotifier
+	notifierFactories["opsgenie"] = newOpsgenieNotifier
+}
+
+// prefixedParams collects the query parameters starting with prefix, keyed
+// by the rest of their name.
+func prefixedParams(q url.Values, prefix string) map[string]string {
+	m := map[string]string{}
+	for key, values := range q {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			m[name] = values[0]
+		}
+	}
+	return m
+}
+
+// routeFor looks an alert up in a map keyed by host name or tag.
+func routeFor(routes map[string]string, a Alert) (string, bool) {
+	if v, ok := routes[a.Host]; ok {
+		return v, true
+	}
+	for _, tag := range a.Stats.Tags {
+		if v, ok := routes[tag]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// alertDetails is the latency context attached to incidents.
+func alertDetails(a Alert) map[string]any {
+	details := map[string]any{
+		"status":          a.Status,
+		"previous_status": a.Previous,
+		"current_latency": a.Stats.CurrentLatency,
+		"avg_latency":     a.Stats.AvgLatency,
+		"min_latency":     a.Stats.MinLatency,
+		"max_latency":     a.Stats.MaxLatency,
+		"jitter":          a.Stats.Jitter,
+		"packet_loss":     a.Stats.PacketLoss,
+	}
+	for name, v := range a.Stats.Metrics {
+		details[name] = v
+	}
+	if a.Message != "" {
+		details["message"] = a.Message
+	}
+	return details
+}
+
+// incidentKey identifies a host's incident so recovery resolves it.
+func incidentKey(a Alert) string {
+	return "netmonitor/" + a.Host
+}
+
+// PagerDuty Events API v2
+
+type pagerDutyNotifier struct {
+	routingKey string
+	services   map[string]string
+}
+
+func newPagerDutyNotifier(u *url.URL) (Notifier, error) {
+	if u.Host == "" {
+		return nil, errors.New("expected pagerduty://ROUTING_KEY")
+	}
+	return &pagerDutyNotifier{
+		routingKey: u.Host,
+		services:   prefixedParams(u.Query(), "service."),
+	}, nil
+}
+
+func (n *pagerDutyNotifier) Notify(a Alert) error {
+	routingKey := n.routingKey
+	if key, ok := routeFor(n.services, a); ok {
+		routingKey = key
+	}
+
+	event := map[string]any{
+		"routing_key":  routingKey,
+		"event_action": "trigger",
+		"dedup_key":    incidentKey(a),
+	}
+	if a.Resolved {
+		event["event_action"] = "resolve"
+	} else {
+		summary := a.Title()
+		if a.Message != "" {
+			summary += ": " + a.Message
+		}
+		event["payload"] = map[string]any{
+			"summary":        summary,
+			"source":         a.Host,
+			"severity":       a.Severity,
+			"timestamp":      a.Time,
+			"component":      a.Stats.Type,
+			"group":          strings.Join(a.Stats.Tags, ","),
+			"custom_details": alertDetails(a),
+		}
+	}
+	return postJSON("https://events.pagerduty.com/v2/enqueue", event, nil)
+}
+
+// Opsgenie Alert API
+
+type opsgenieNotifier struct {
+	apiKey string
+	base   string
+	teams  map[string]string
+}
+
+func newOpsgenieNotifier(u *url.URL) (Notifier, error) {
+	if u.Host == "" {
+		return nil, errors.New("expected opsgenie://API_KEY")
+	}
+	base := "https://api.opsgenie.com"
+	if u.Query().Get("region") == "eu" {
+		base = "https://api.eu.opsgenie.com"
+	}
+	return &opsgenieNotifier{
+		apiKey: u.Host,
+		base:   base,
+		teams:  prefixedParams(u.Query(), "team."),
+	}, nil
+}
+
+var opsgeniePriority = map[string]string{
+	severityInfo:     "P5",
+	severityWarning:  "P3",
+	severityCritical: "P1",
+}
+
+func (n *opsgenieNotifier) Notify(a Alert) error {
+	header := http.Header{"Authorization": {"GenieKey " + n.apiKey}}
+	alias := incidentKey(a)
+
+	if a.Resolved {
+		endpoint := n.base + "/v2/alerts/" + url.PathEscape(alias) + "/close?identifierType=alias"
+		return postJSON(endpoint, map[string]any{"source": "netmonitor", "note": a.Text()}, header)
+	}
+
+	alert := map[string]any{
+		"message":     a.Title(),
+		"alias":       alias,
+		"description": a.Text(),
+		"source":      "netmonitor",
+		"entity":      a.Host,
+		"tags":        a.Stats.Tags,
+		"details":     stringDetails(alertDetails(a)),
+		"priority":    opsgeniePriority[a.Severity],
+	}
+	if team, ok := routeFor(n.teams, a); ok {
+		alert["responders"] = []map[string]string{{"name": team, "type": "team"}}
+	}
+	return postJSON(n.base+"/v2/alerts", alert, header)
+}
+
+// stringDetails formats detail values as strings, which is all Opsgenie
+// accepts for custom properties.
+func stringDetails(details map[string]any) map[string]string {
+	out := make(map[string]string, len(details))
+	for k, v := range details {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case float64:
+			out[k] = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// NTP targets look like ntp://pool.ntp.org?max_offset=100ms. The probe sends
+// a single SNTP client request and reports the clock offset, round trip
+// delay and stratum of the server. When max_offset is set and the local
+// clock drifts further than that, the host is marked degraded.
+func init() {
+	probers["ntp"] = probeNTP
+}
+
+// ntpEpochOffset is the number of seconds between 1900 and 1970.
+const ntpEpochOffset = 2208988800
+
+func probeNTP(t *target) (probeResult, error) {
+	maxOffset, err := time.ParseDuration(t.param("max_offset", "0s"))
+	if err != nil {
+		return probeResult{}, fmt.Errorf("invalid max_offset: %v", err)
+	}
+
+	conn, err := t.dial("udp", t.hostPort("123"))
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(t.timeout()))
+
+	// LI = 0, version 4, mode 3 (client). The transmit timestamp is echoed
+	// back as the origin timestamp, which lets us match the reply.
+	req := make([]byte, 48)
+	req[0] = 0<<6 | 4<<3 | 3
+	t1 := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+
+	if _, err := conn.Write(req); err != nil {
+		return probeResult{}, err
+	}
+	reply := make([]byte, 48)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return probeResult{}, err
+	}
+	t4 := time.Now()
+	if n < 48 {
+		return probeResult{}, errors.New("short ntp reply")
+	}
+
+	mode := reply[0] & 0x7
+	leap := reply[0] >> 6
+	stratum := reply[1]
+	switch {
+	case mode != 4:
+		return probeResult{}, fmt.Errorf("unexpected ntp mode %d", mode)
+	case binary.BigEndian.Uint64(reply[24:]) != binary.BigEndian.Uint64(req[40:]):
+		return probeResult{}, errors.New("ntp reply does not match request")
+	case stratum == 0:
+		return probeResult{}, fmt.Errorf("ntp kiss-o'-death %q", reply[12:16])
+	case leap == 3:
+		return probeResult{}, errors.New("ntp server is not synchronized")
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(reply[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(reply[40:]))
+
+	offset := (t2.Sub(t1) + t3.Sub(t4)) / 2
+	delay := t4.Sub(t1) - t3.Sub(t2)
+
+	result := probeResult{
+		Latency: delay.Seconds() * 1000,
+		Metrics: map[string]float64{
+			"offset_ms": offset.Seconds() * 1000,
+			"stratum":   float64(stratum),
+		},
+	}
+	if maxOffset > 0 && math.Abs(float64(offset)) > float64(maxOffset) {
+		result.Warning = fmt.Sprintf("clock offset %v exceeds %v", offset.Round(time.Millisecond), maxOffset)
+	}
+	return result, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
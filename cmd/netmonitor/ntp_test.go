@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNTPTime(t *testing.T) {
+	for _, tm := range []time.Time{
+		time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 6, 30, 23, 59, 59, 999999000, time.UTC),
+		time.Unix(0, 500000000),
+	} {
+		if got := fromNTPTime(toNTPTime(tm)); got.Sub(tm).Abs() > time.Nanosecond {
+			t.Errorf("%v came back as %v", tm, got)
+		}
+	}
+	if got := toNTPTime(time.Unix(0, 0)) >> 32; got != ntpEpochOffset {
+		t.Errorf("1970 is %d seconds after 1900, want %d", got, ntpEpochOffset)
+	}
+}
+
+// serveNTP answers NTP requests on a local port with the reply reply
+// makes of them, and returns the address.
+func serveNTP(t *testing.T, reply func(req []byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		b := make([]byte, 48)
+		for {
+			n, from, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply(b[:n]), from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestProbeNTP(t *testing.T) {
+	// ntpReply answers as a server whose clock is skew ahead.
+	ntpReply := func(skew time.Duration, change func(b []byte)) func(req []byte) []byte {
+		return func(req []byte) []byte {
+			b := make([]byte, 48)
+			b[0] = 0<<6 | 4<<3 | 4
+			b[1] = 2
+			copy(b[24:32], req[40:48])
+			now := toNTPTime(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(b[32:], now)
+			binary.BigEndian.PutUint64(b[40:], now)
+			if change != nil {
+				change(b)
+			}
+			return b
+		}
+	}
+
+	tests := []struct {
+		name    string
+		params  string
+		reply   func(req []byte) []byte
+		warning string
+		err     string
+	}{
+		{"in sync", "?max_offset=1s", ntpReply(0, nil), "", ""},
+		{"drifted", "?max_offset=1s", ntpReply(5*time.Second, nil), "exceeds 1s", ""},
+		{"drift allowed", "", ntpReply(5*time.Second, nil), "", ""},
+		{"kiss of death", "", ntpReply(0, func(b []byte) { b[1] = 0; copy(b[12:], "RATE") }), "", `kiss-o'-death "RATE"`},
+		{"unsynchronized", "", ntpReply(0, func(b []byte) { b[0] |= 3 << 6 }), "", "not synchronized"},
+		{"not a server", "", ntpReply(0, func(b []byte) { b[0] = 4<<3 | 3 }), "", "unexpected ntp mode 3"},
+		{"another request's", "", ntpReply(0, func(b []byte) { b[24]++ }), "", "does not match"},
+		{"short", "", func(req []byte) []byte { return ntpReply(0, nil)(req)[:40] }, "", "short"},
+		{"invalid max_offset", "?max_offset=1", ntpReply(0, nil), "", "invalid max_offset"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("ntp://" + serveNTP(t, tt.reply) + tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeNTP(tgt)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !strings.Contains(result.Warning, tt.warning) || (tt.warning == "") != (result.Warning == "") {
+			t.Errorf("%s: warning %q, want %q", tt.name, result.Warning, tt.warning)
+		}
+		if result.Metrics["stratum"] != 2 {
+			t.Errorf("%s: stratum %v", tt.name, result.Metrics["stratum"])
+		}
+	}
+}
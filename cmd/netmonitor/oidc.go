@@ -0,0 +1,646 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCConfig signs users in with an OpenID Connect provider such as
+// Keycloak, Okta or Google before they see the dashboard or use the API.
+// Roles maps the provider's groups to roles; users in none of them get
+// default_role, or are turned away without one:
+//
+//	oidc:
+//	  issuer: https://sso.example.com/realms/noc
+//	  client_id: netmonitor
+//	  client_secret: ...
+//	  roles:
+//	    noc-admins: admin
+//	    noc: operator
+//	  default_role: viewer
+//
+// The provider must allow <dashboard_url>/auth/callback, or redirect_url,
+// as a redirect URI. Sessions last session_lifetime (12h) and are signed
+// with session_secret, or a random key that does not survive restarts.
+type OIDCConfig struct {
+	Issuer          string            `yaml:"issuer"`
+	ClientID        string            `yaml:"client_id"`
+	ClientSecret    string            `yaml:"client_secret"`
+	RedirectURL     string            `yaml:"redirect_url"`
+	Scopes          []string          `yaml:"scopes"`       // openid, profile and email unless set
+	GroupsClaim     string            `yaml:"groups_claim"` // groups unless set
+	Roles           map[string]string `yaml:"roles"`
+	DefaultRole     string            `yaml:"default_role"`
+	SessionLifetime time.Duration     `yaml:"session_lifetime"`
+	SessionSecret   string            `yaml:"session_secret"`
+}
+
+const (
+	sessionCookie = "netmonitor_session"
+	loginCookie   = "netmonitor_login"
+)
+
+// oidcAuth is the login flow and the check of every request.
+type oidcAuth struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	roles        map[string]role
+	defaultRole  role
+	lifetime     time.Duration
+	cookies      signer
+	basePath     string
+	secure       bool
+	client       *http.Client
+
+	mu        sync.Mutex
+	provider  *oidcProvider
+	keys      map[string]crypto.PublicKey
+	keysFetch time.Time
+}
+
+// oidcProvider is the provider's discovery document.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// loginState is kept in a cookie between sending the browser to the
+// provider and its return.
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Return   string    `json:"return"`
+	Expires  time.Time `json:"expires"`
+}
+
+func newOIDCAuth(cfg *OIDCConfig, dashboardURL, basePath string) (*oidcAuth, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("issuer and client_id are required")
+	}
+	a := &oidcAuth{
+		issuer:       strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+		redirectURL:  cfg.RedirectURL,
+		scopes:       cfg.Scopes,
+		groupsClaim:  cmp.Or(cfg.GroupsClaim, "groups"),
+		roles:        map[string]role{},
+		lifetime:     cmp.Or(cfg.SessionLifetime, 12*time.Hour),
+		basePath:     basePath,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(a.scopes) == 0 {
+		a.scopes = []string{"openid", "profile", "email"}
+	}
+	if !slices.Contains(a.scopes, "openid") {
+		a.scopes = append([]string{"openid"}, a.scopes...)
+	}
+	if a.redirectURL == "" {
+		if dashboardURL == "" {
+			return nil, errors.New("set redirect_url or dashboard_url")
+		}
+		a.redirectURL = strings.TrimSuffix(dashboardURL, "/") + "/auth/callback"
+	}
+	u, err := url.Parse(a.redirectURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid redirect_url %q", a.redirectURL)
+	}
+	a.secure = u.Scheme == "https"
+	for group, name := range cfg.Roles {
+		if a.roles[group], err = parseRole(name); err != nil {
+			return nil, fmt.Errorf("roles: %s: %v", group, err)
+		}
+	}
+	if cfg.DefaultRole != "" {
+		if a.defaultRole, err = parseRole(cfg.DefaultRole); err != nil {
+			return nil, fmt.Errorf("default_role: %v", err)
+		}
+	}
+	key := []byte(cfg.SessionSecret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	} else if len(key) < 16 {
+		return nil, errors.New("session_secret must be at least 16 characters")
+	}
+	a.cookies = signer{key: key}
+	return a, nil
+}
+
+// wrap lets signed-in users through to next and sends everyone else to
+// the provider, or answers 401 for API calls. The health checks and
+// metrics stay open for load balancers and Prometheus.
+func (a *oidcAuth) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		case "/auth/login":
+			a.handleLogin(w, r)
+			return
+		case "/auth/callback":
+			a.handleCallback(w, r)
+			return
+		case "/auth/logout":
+			a.handleLogout(w, r)
+			return
+		}
+		if userFrom(r.Context()) != nil {
+			// Signed in with an API token already.
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			var u User
+			if a.cookies.verify(c.Value, &u) == nil && time.Now().Before(u.Expires) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, &u)))
+				return
+			}
+		}
+		if r.Method != http.MethodGet || strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/grafana/") {
+			writeError(w, http.StatusUnauthorized, "sign in first")
+			return
+		}
+		target := a.basePath + "/auth/login?return=" + url.QueryEscape(a.basePath+r.URL.RequestURI())
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+// discover fetches the provider's endpoints once. The document has to be
+// the configured issuer's (OpenID Connect Discovery 1.0, section 4.3), since
+// ID tokens are checked against the issuer it names.
+func (a *oidcAuth) discover() (*oidcProvider, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider != nil {
+		return a.provider, nil
+	}
+	var p oidcProvider
+	if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", &p); err != nil {
+		return nil, fmt.Errorf("discovery: %v", err)
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != a.issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", p.Issuer, a.issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("discovery: incomplete provider configuration")
+	}
+	a.provider = &p
+	return a.provider, nil
+}
+
+func (a *oidcAuth) getJSON(url string, v any) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *oidcAuth) cookie(name, value string, maxAge time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     a.basePath + "/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// handleLogin sends the browser to the provider with a fresh state, nonce
+// and PKCE challenge.
+func (a *oidcAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	p, err := a.discover()
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		http.Error(w, "the login provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	ret := r.URL.Query().Get("return")
+	if !strings.HasPrefix(ret, "/") || strings.HasPrefix(ret, "//") || strings.HasPrefix(ret, "/\\") {
+		ret = a.basePath + "/"
+	}
+	state := loginState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: randomToken(),
+		Return:   ret,
+		Expires:  time.Now().Add(10 * time.Minute),
+	}
+	value, err := a.cookies.sign(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, a.cookie(loginCookie, value, 10*time.Minute))
+
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {a.clientID},
+		"redirect_uri":          {a.redirectURL},
+		"scope":                 {strings.Join(a.scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// handleCallback exchanges the code for an ID token, checks it and starts
+// a session.
+func (a *oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		authFailed(w, http.StatusForbidden, fmt.Sprintf("The login provider refused: %s %s", e, q.Get("error_description")))
+		return
+	}
+	var state loginState
+	c, err := r.Cookie(loginCookie)
+	if err != nil || a.cookies.verify(c.Value, &state) != nil || time.Now().After(state.Expires) || q.Get("state") != state.State {
+		authFailed(w, http.StatusBadRequest, "The login expired or was started elsewhere.")
+		return
+	}
+	http.SetCookie(w, a.cookie(loginCookie, "", -time.Second))
+
+	claims, err := a.exchange(q.Get("code"), state)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		authFailed(w, http.StatusBadGateway, "Signing in failed: "+err.Error())
+		return
+	}
+	u := a.userFor(claims)
+	if u.Role == 0 {
+		log.Printf("OIDC: %s (%s) has no role", u.Name, u.Subject)
+		authFailed(w, http.StatusForbidden, fmt.Sprintf("%s is not allowed to use this dashboard.", u.Name))
+		return
+	}
+	value, err := a.cookies.sign(u)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, a.cookie(sessionCookie, value, a.lifetime))
+	http.Redirect(w, r, state.Return, http.StatusFound)
+}
+
+// handleLogout ends the session here and, when it supports that, at the
+// provider.
+func (a *oidcAuth) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, a.cookie(sessionCookie, "", -time.Second))
+	if p, err := a.discover(); err == nil && p.EndSessionEndpoint != "" {
+		q := url.Values{
+			"client_id":                {a.clientID},
+			"post_logout_redirect_uri": {strings.TrimSuffix(a.redirectURL, "auth/callback")},
+		}
+		http.Redirect(w, r, p.EndSessionEndpoint+"?"+q.Encode(), http.StatusFound)
+		return
+	}
+	authFailed(w, http.StatusOK, "You are signed out.")
+}
+
+var authPage = template.Must(template.New("auth").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Network Monitor</title></head>
+<body style="font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; padding: 40px; color: #333">
+<h1>Network Monitor</h1>
+<p>{{.}}</p>
+<p><a href="login">Sign in</a></p>
+</body>
+</html>
+`))
+
+func authFailed(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	authPage.Execute(w, msg)
+}
+
+// exchange trades the authorization code for tokens and returns the
+// verified claims of the ID token.
+func (a *oidcAuth) exchange(code string, state loginState) (map[string]any, error) {
+	p, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {a.redirectURL},
+		"client_id":     {a.clientID},
+		"code_verifier": {state.Verifier},
+	}
+	if a.clientSecret != "" {
+		form.Set("client_secret", a.clientSecret)
+	}
+	resp, err := a.client.PostForm(p.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("token endpoint: %s: %v", resp.Status, err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token endpoint returned no ID token")
+	}
+	claims, err := a.verifyToken(tokens.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims["nonce"] != state.Nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// verifyToken checks the signature, issuer, audience and expiry of an ID
+// token and returns its claims.
+func (a *oidcAuth) verifyToken(raw string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("ID token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID token signature: %v", err)
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("ID token claims: %v", err)
+	}
+	p, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q, expected %q", iss, p.Issuer)
+	}
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, v := range aud {
+			if s, ok := v.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, a.clientID) {
+		return nil, errors.New("ID token is meant for another client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-time.Minute).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("ID token has expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the provider's signing key with the given ID, fetching the
+// key set again when it is unknown, at most once a minute.
+func (a *oidcAuth) key(kid string) (crypto.PublicKey, error) {
+	p, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	find := func() crypto.PublicKey {
+		if kid == "" && len(a.keys) == 1 {
+			for _, k := range a.keys {
+				return k
+			}
+		}
+		return a.keys[kid]
+	}
+	if k := find(); k != nil {
+		return k, nil
+	}
+	if time.Since(a.keysFetch) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	a.keysFetch = time.Now()
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := a.getJSON(p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("signing keys: %v", err)
+	}
+	a.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.publicKey(); err == nil {
+			a.keys[jwk.Kid] = k
+		}
+	}
+	if k := find(); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// jsonWebKey is an RSA or elliptic curve key of a JWK set.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b), err
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := num(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := num(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks a JWS signature made with RS256/384/512 or
+// ES256/384.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' || len(sig)%2 != 0 {
+			break
+		}
+		half := len(sig) / 2
+		r, s := new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid ID token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signing key does not fit %s", alg)
+}
+
+// userFor makes the session user of verified claims, with the highest role
+// any of their groups maps to.
+func (a *oidcAuth) userFor(claims map[string]any) User {
+	str := func(key string) string {
+		s, _ := claims[key].(string)
+		return s
+	}
+	u := User{
+		Subject: str("sub"),
+		Name:    cmp.Or(str("name"), str("preferred_username"), str("email"), str("sub")),
+		Email:   str("email"),
+		Role:    a.defaultRole,
+		Expires: time.Now().Add(a.lifetime),
+	}
+	var groups []string
+	switch g := claims[a.groupsClaim].(type) {
+	case string:
+		groups = []string{g}
+	case []any:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	for _, g := range groups {
+		if r := a.roles[g]; r > u.Role {
+			u.Role = r
+		}
+	}
+	return u
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider serves a discovery document naming issuer and the signing
+// key, and signs ID tokens with it.
+type fakeProvider struct {
+	*httptest.Server
+	issuer string
+	key    *rsa.PrivateKey
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.issuer,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		e := big.NewInt(int64(key.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA",
+			Kid: "k1",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(e),
+		}}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	p.issuer = p.URL
+	return p
+}
+
+// sign makes an RS256 ID token of claims.
+func (p *fakeProvider) sign(t *testing.T, claims map[string]any) string {
+	segment := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCDiscoveryIssuer(t *testing.T) {
+	p := newFakeProvider(t)
+	tests := []struct {
+		declared string
+		ok       bool
+	}{
+		{p.URL, true},
+		{p.URL + "/", true},
+		{"https://evil.example.com", false},
+		{p.URL + "/realms/other", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		p.issuer = tt.declared
+		a, err := newOIDCAuth(&OIDCConfig{Issuer: p.URL, ClientID: "netmonitor"}, "https://nm.example.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.discover(); (err == nil) != tt.ok {
+			t.Errorf("issuer %q declared for %s: error %v", tt.declared, p.URL, err)
+		}
+	}
+}
+
+func TestOIDCVerifyToken(t *testing.T) {
+	p := newFakeProvider(t)
+	a, err := newOIDCAuth(&OIDCConfig{Issuer: p.URL, ClientID: "netmonitor"}, "https://nm.example.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{"iss": p.URL, "aud": "netmonitor", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	other := newFakeProvider(t)
+
+	tests := []struct {
+		name  string
+		token string
+		err   string // part of the error, empty when valid
+	}{
+		{"valid", p.sign(t, claims(nil)), ""},
+		{"audience list", p.sign(t, claims(func(c map[string]any) { c["aud"] = []string{"other", "netmonitor"} })), ""},
+		{"other issuer", p.sign(t, claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })), "issued by"},
+		{"other client", p.sign(t, claims(func(c map[string]any) { c["aud"] = "other" })), "another client"},
+		{"expired", p.sign(t, claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), "expired"},
+		{"other key", other.sign(t, claims(nil)), "signature"},
+		{"malformed", "a.b", "malformed"},
+	}
+	for _, tt := range tests {
+		_, err := a.verifyToken(tt.token)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want one about %q", tt.name, err, tt.err)
+		}
+	}
+}
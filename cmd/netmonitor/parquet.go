@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ParquetConfig exports the history as Parquet files for analytics, laid
+// out by day and host the way Hive partitions tables:
+//
+//	parquet:
+//	  dir: s3://analytics/netmonitor?region=eu-central-1
+//	  at: "01:00"
+//
+// writes day=2024-05-13/host=8.8.8.8/data.parquet below dir, a directory or
+// a bucket (see s3Bucket), once a day is over. Days missed while the
+// server was down are caught up on as far as the history goes back.
+type ParquetConfig struct {
+	Dir string `yaml:"dir"`
+	At  string `yaml:"at"` // 00:30 unless set
+}
+
+type parquetExport struct {
+	store objectStore
+	at    int // minutes after midnight
+	state string
+}
+
+// parquetStateFile remembers in the history directory the last day
+// exported to each destination.
+const parquetStateFile = "parquet.json"
+
+func newParquetExport(cfg *ParquetConfig, historyDir string) (*parquetExport, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	store, err := newObjectStore(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	at, err := parseClock(cmp.Or(cfg.At, "00:30"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q, expected HH:MM", cfg.At)
+	}
+	e := &parquetExport{store: store, at: at}
+	if historyDir != "" {
+		e.state = filepath.Join(historyDir, parquetStateFile)
+	}
+	return e, nil
+}
+
+// runParquetExport exports the days that are over at the time of day of e.
+func (m *Monitor) runParquetExport(e *parquetExport) {
+	for {
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		at := today.Add(time.Duration(e.at) * time.Minute)
+		if now.Before(at) {
+			time.Sleep(time.Until(at))
+			continue
+		}
+		if m.leads() {
+			m.exportParquet(e, today)
+		}
+		time.Sleep(time.Until(at.AddDate(0, 0, 1)))
+	}
+}
+
+// exportParquet exports the days before until that were not exported yet
+// and are still in the history.
+func (m *Monitor) exportParquet(e *parquetExport, until time.Time) {
+	exported := e.loadState()
+	oldest := until.Add(-m.history.retention)
+	day := time.Date(oldest.Year(), oldest.Month(), oldest.Day(), 0, 0, 0, 0, until.Location())
+	if last, err := time.ParseInLocation("2006-01-02", exported[e.store.String()], until.Location()); err == nil && !last.Before(day) {
+		day = last.AddDate(0, 0, 1)
+	}
+	for ; day.Before(until); day = day.AddDate(0, 0, 1) {
+		name := day.Format("2006-01-02")
+		hosts := 0
+		for _, host := range m.history.hostNames() {
+			samples := m.history.rangeOf(host, day, day.AddDate(0, 0, 1))
+			if len(samples) == 0 {
+				continue
+			}
+			data, err := samplesParquet(samples)
+			if err == nil {
+				err = e.store.put(fmt.Sprintf("day=%s/host=%s/data.parquet", name, hiveEscape(host)), data)
+			}
+			if err != nil {
+				log.Printf("Exporting the history of %s of %s to %s failed: %v", host, name, e.store, err)
+				return // tried again on the next run
+			}
+			hosts++
+		}
+		if hosts > 0 {
+			log.Printf("Exported the history of %d hosts of %s to %s", hosts, name, e.store)
+		}
+		exported[e.store.String()] = name
+		e.saveState(exported)
+	}
+}
+
+func (e *parquetExport) loadState() map[string]string {
+	exported := map[string]string{}
+	if e.state != "" {
+		if b, err := os.ReadFile(e.state); err == nil {
+			json.Unmarshal(b, &exported)
+		}
+	}
+	return exported
+}
+
+func (e *parquetExport) saveState(exported map[string]string) {
+	if e.state == "" {
+		return
+	}
+	b, _ := json.MarshalIndent(exported, "", "  ")
+	if err := os.WriteFile(e.state, b, 0644); err != nil {
+		log.Printf("Saving %s failed: %v", e.state, err)
+	}
+}
+
+// hiveEscape escapes a partition value the way Hive does, so that it makes
+// a single path segment.
+func hiveEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '.' || c == '-' || c == '_' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// samplesParquet encodes samples as a Parquet file with the columns time
+// (a UTC timestamp in milliseconds), latency_ms (null when the probe
+// failed), up and metrics (JSON, null for most probes).
+func samplesParquet(samples []Sample) ([]byte, error) {
+	times := &parquetColumn{name: "time", typ: parquetInt64, converted: parquetTimestampMillis}
+	latencies := &parquetColumn{name: "latency_ms", typ: parquetDouble, converted: -1, optional: true}
+	ups := &parquetColumn{name: "up", typ: parquetBoolean, converted: -1}
+	metrics := &parquetColumn{name: "metrics", typ: parquetByteArray, converted: parquetJSON, optional: true}
+	for _, s := range samples {
+		times.values = binary.LittleEndian.AppendUint64(times.values, uint64(s.Time.UnixMilli()))
+		latencies.defined = append(latencies.defined, s.Up)
+		if s.Up {
+			latencies.values = binary.LittleEndian.AppendUint64(latencies.values, math.Float64bits(s.Latency))
+		}
+		ups.bools = append(ups.bools, s.Up)
+		metrics.defined = append(metrics.defined, len(s.Metrics) > 0)
+		if len(s.Metrics) > 0 {
+			b, err := json.Marshal(s.Metrics)
+			if err != nil {
+				return nil, err
+			}
+			metrics.values = binary.LittleEndian.AppendUint32(metrics.values, uint32(len(b)))
+			metrics.values = append(metrics.values, b...)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeParquet(&buf, len(samples), []*parquetColumn{times, latencies, ups, metrics}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A minimal Parquet writer: flat columns, required or optional, in one row
+// group with one gzipped data page each, PLAIN encoded.
+
+// Physical types, converted types and other numbers of the format.
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetTimestampMillis = 9
+	parquetJSON            = 19
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+	parquetGzip  = 2
+	parquetData  = 0 // page type
+)
+
+// parquetColumn holds the encoded values of a column.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	optional  bool
+
+	values  []byte // PLAIN encoded, nulls left out
+	bools   []bool // the values of a boolean column, packed when written
+	defined []bool // whether each row has a value, for optional columns
+}
+
+// page returns the content of the data page of the column: definition
+// levels for an optional column, then the values.
+func (c *parquetColumn) page() []byte {
+	var page []byte
+	if c.optional {
+		// Runs of the RLE/bit-packing hybrid encoding, with one byte
+		// for each level.
+		var levels []byte
+		for i := 0; i < len(c.defined); {
+			j := i
+			for j < len(c.defined) && c.defined[j] == c.defined[i] {
+				j++
+			}
+			levels = binary.AppendUvarint(levels, uint64(j-i)<<1)
+			levels = append(levels, boolByte(c.defined[i]))
+			i = j
+		}
+		page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+		page = append(page, levels...)
+	}
+	if c.typ == parquetBoolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, b := range c.bools {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append(page, packed...)
+	}
+	return append(page, c.values...)
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeParquet(w *bytes.Buffer, rows int, columns []*parquetColumn) error {
+	w.WriteString("PAR1")
+	type written struct {
+		offset, size, compressedSize int64
+	}
+	var chunks []written
+	for _, c := range columns {
+		page := c.page()
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(page)
+		if err := gz.Close(); err != nil {
+			return err
+		}
+		var header thriftWriter
+		header.begin()
+		header.i32(1, parquetData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32(1, int32(rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks = append(chunks, written{
+			offset:         int64(w.Len()),
+			size:           int64(len(header.buf) + len(page)),
+			compressedSize: int64(len(header.buf) + compressed.Len()),
+		})
+		w.Write(header.buf)
+		w.Write(compressed.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.begin()
+	meta.i32(1, 1) // version
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.begin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for _, c := range columns {
+		repetition := int32(parquetRequired)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		meta.begin()
+		meta.i32(1, c.typ)
+		meta.i32(3, repetition)
+		meta.binary(4, c.name)
+		if c.converted >= 0 {
+			meta.i32(6, c.converted)
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(rows))
+	meta.list(4, thriftStruct, 1)
+	meta.begin()
+	meta.list(1, thriftStruct, len(columns))
+	var total int64
+	for i, c := range columns {
+		chunk := chunks[i]
+		total += chunk.size
+		meta.begin()
+		meta.i64(2, chunk.offset)
+		meta.structField(3)
+		meta.i32(1, c.typ)
+		meta.list(2, thriftI32, 2)
+		meta.listI32(parquetPlain, parquetRLE)
+		meta.list(3, thriftBinary, 1)
+		meta.listBinary(c.name)
+		meta.i32(4, parquetGzip)
+		meta.i64(5, int64(rows))
+		meta.i64(6, chunk.size)
+		meta.i64(7, chunk.compressedSize)
+		meta.i64(9, chunk.offset)
+		meta.end()
+		meta.end()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(rows))
+	meta.end()
+	meta.binary(6, "netmonitor")
+	meta.end()
+
+	w.Write(meta.buf)
+	w.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(meta.buf))))
+	w.WriteString("PAR1")
+	return nil
+}
+
+// thriftWriter writes the Thrift compact protocol Parquet metadata is
+// encoded in.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // the last field id of each open struct
+}
+
+// Compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+func (w *thriftWriter) begin() { w.last = append(w.last, 0) }
+
+func (w *thriftWriter) end() {
+	w.buf = append(w.buf, 0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf = append(w.buf, byte(delta)<<4|typ)
+	} else {
+		w.buf = append(w.buf, typ)
+		w.buf = binary.AppendVarint(w.buf, int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.buf = binary.AppendVarint(w.buf, int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+func (w *thriftWriter) binary(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.listBinary(s)
+}
+
+// structField starts a struct field, which end closes.
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+// list starts a list field of n elements, which follow with listI32,
+// listBinary or begin and end for structs.
+func (w *thriftWriter) list(id int16, elem byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf = append(w.buf, byte(n)<<4|elem)
+		return
+	}
+	w.buf = append(w.buf, 0xf0|elem)
+	w.buf = binary.AppendUvarint(w.buf, uint64(n))
+}
+
+func (w *thriftWriter) listI32(vs ...int32) {
+	for _, v := range vs {
+		w.buf = binary.AppendVarint(w.buf, int64(v))
+	}
+}
+
+func (w *thriftWriter) listBinary(vs ...string) {
+	for _, v := range vs {
+		w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+		w.buf = append(w.buf, v...)
+	}
+}
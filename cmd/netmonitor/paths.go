@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PathConfig names an uplink, such as the primary or backup WAN. Hosts
+// listing several paths are probed over each of them simultaneously so
+// failover paths are verified continuously, not only when they are needed.
+//
+//	paths:
+//	  - name: primary
+//	    interface: eth0
+//	  - name: backup
+//	    interface: wwan0
+//	hosts:
+//	  - target: 8.8.8.8
+//	    paths: [primary, backup]
+type PathConfig struct {
+	Name      string `yaml:"name"`
+	SourceIP  string `yaml:"source_ip"`
+	Interface string `yaml:"interface"`
+}
+
+// PathComparison holds the stats of one host per path.
+type PathComparison struct {
+	Host  string      `json:"host"`
+	Paths []PingStats `json:"paths"`
+}
+
+// handlePaths serves GET /api/paths, grouping hosts probed over several
+// paths in the configured order.
+func (m *Monitor) handlePaths(w http.ResponseWriter, r *http.Request) {
+	comparisons := []PathComparison{}
+	index := map[string]int{}
+	hs := m.hosts()
+	for _, t := range hs.targets {
+		if t.path == "" {
+			continue
+		}
+		host := strings.TrimSuffix(t.name, " via "+t.path)
+		i, ok := index[host]
+		if !ok {
+			i = len(comparisons)
+			index[host] = i
+			comparisons = append(comparisons, PathComparison{Host: host})
+		}
+		comparisons[i].Paths = append(comparisons[i].Paths, hs.stats[t.name].load())
+	}
+	writeJSON(w, http.StatusOK, comparisons)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pausedHosts keeps the hosts paused through the API, in the history
+// directory when one is configured so they stay paused across restarts.
+// A paused host keeps its place, statistics and history but is not
+// probed, so it neither alerts nor counts losses until resumed.
+type pausedHosts struct {
+	mu     sync.Mutex
+	path   string
+	paused map[string]time.Time // since when
+}
+
+// open loads the hosts paused in dir and pauses them.
+func (p *pausedHosts) open(dir string, hosts *hostSet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.path = filepath.Join(dir, "paused.json")
+	b, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &p.paused); err != nil {
+		return err
+	}
+	for name, since := range p.paused {
+		if h, ok := hosts.stats[name]; ok {
+			h.update(func(s *PingStats) { s.Paused, s.PausedSince = true, since })
+		}
+	}
+	return nil
+}
+
+// saveLocked writes the paused hosts out. p.mu must be held.
+func (p *pausedHosts) saveLocked() error {
+	if p.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(p.paused, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.path, b, 0644)
+}
+
+// set pauses or resumes a host, returning false if it already was.
+func (p *pausedHosts) set(name string, paused bool, now time.Time) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.paused[name]; ok == paused {
+		return false, nil
+	}
+	if paused {
+		if p.paused == nil {
+			p.paused = map[string]time.Time{}
+		}
+		p.paused[name] = now
+	} else {
+		delete(p.paused, name)
+	}
+	return true, p.saveLocked()
+}
+
+// lookup returns since when a host is paused.
+func (p *pausedHosts) lookup(name string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	since, ok := p.paused[name]
+	return since, ok
+}
+
+// handleHostPause serves POST /api/hosts/{host}/pause and resume, escaped
+// like reset.
+func (m *Monitor) handleHostPause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("host")
+		h, ok := m.hosts().stats[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown host")
+			return
+		}
+		now := m.clock.Now()
+		changed, err := m.paused.set(name, paused, now)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if changed {
+			h.update(func(s *PingStats) {
+				s.Paused = paused
+				s.PausedSince = time.Time{}
+				if paused {
+					s.PausedSince = now
+				}
+			})
+			// The probe loop resumes from here rather than counting the
+			// pause as a stall.
+			h.lastRun.Store(now.UnixNano())
+			action := "host.resume"
+			if paused {
+				action = "host.pause"
+			}
+			m.auditRequest(r, action, name, nil, nil)
+		}
+		stats, _ := m.statsOf(name)
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
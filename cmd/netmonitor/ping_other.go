@@ -0,0 +1,197 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// icmpSocket is a raw ICMP socket shared by every ping with the same source
+// address, interface and DSCP marking. Sockets stay open for the life of
+// the process, so they can be created before it drops root.
+type icmpSocket struct {
+	conn net.PacketConn
+}
+
+type icmpWaiter struct {
+	peer  netip.Addr
+	reply chan error // nil for an echo reply
+}
+
+var (
+	icmpSocketsMu sync.Mutex
+	icmpSockets   = map[string]*icmpSocket{}
+
+	// Echo requests carry the process ID and a sequence number unique
+	// across all sockets. Every raw socket sees every reply, so pings
+	// wait in one table shared by all of them.
+	icmpID  = os.Getpid() & 0xffff
+	icmpSeq atomic.Uint32
+
+	icmpMu      sync.Mutex
+	icmpWaiting = map[uint16]icmpWaiter{}
+	icmpExpired = map[uint16]time.Time{} // pings that gave up, to count late replies
+)
+
+// openICMPSockets opens the sockets all ICMP and traceroute targets need.
+func openICMPSockets(targets []*target) error {
+	for _, t := range targets {
+		if t.kind != "icmp" && t.kind != "traceroute" {
+			continue
+		}
+		if _, err := icmpSocketFor(t); err != nil {
+			return fmt.Errorf("%s: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+func icmpSocketFor(t *target) (*icmpSocket, error) {
+	key := fmt.Sprintf("%v|%s|%d", t.source, t.iface, t.dscp)
+	icmpSocketsMu.Lock()
+	defer icmpSocketsMu.Unlock()
+	if s, ok := icmpSockets[key]; ok {
+		return s, nil
+	}
+
+	source := "0.0.0.0"
+	if t.source != nil {
+		source = t.source.String()
+	}
+	conn, err := t.listenConfig().ListenPacket(context.Background(), "ip4:icmp", source)
+	if err != nil {
+		return nil, err
+	}
+	s := &icmpSocket{conn: conn}
+	icmpSockets[key] = s
+	go s.read()
+	return s, nil
+}
+
+// read hands echo replies to the pings waiting for them, and errors
+// about UDP probes to traceroutes.
+func (s *icmpSocket) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			telemetry.socketErrors.Add(1)
+			continue
+		}
+		var from netip.Addr
+		if ipAddr, ok := peer.(*net.IPAddr); ok {
+			from, _ = netip.AddrFromSlice(ipAddr.IP)
+		}
+		reply, err := parseICMPReply(buf[:n], from, uint16(icmpID))
+		if err == errNotOurs {
+			deliverTraceQuote(buf[:n], from)
+			continue
+		}
+		if err != nil {
+			telemetry.badReplies.Add(1)
+			continue
+		}
+		icmpMu.Lock()
+		w, ok := icmpWaiting[reply.seq]
+		if _, late := icmpExpired[reply.seq]; late {
+			delete(icmpExpired, reply.seq)
+			telemetry.droppedReplies.Add(1)
+		}
+		icmpMu.Unlock()
+		// Errors come from routers on the way, so they are matched by the
+		// destination they quote.
+		if ok && w.peer == reply.peer {
+			var err error
+			if reply.err != nil {
+				err = reply.err
+			}
+			select {
+			case w.reply <- err:
+			default:
+			}
+		}
+	}
+}
+
+func ping(t *target) (float64, error) {
+	ip, err := t.resolve()
+	if err != nil {
+		return 0, err
+	}
+	ip = ip.Unmap()
+	addr := &net.IPAddr{IP: ip.AsSlice()}
+
+	s, err := icmpSocketFor(t)
+	if err != nil {
+		return 0, err
+	}
+
+	// Create ICMP message
+	seq := uint16(icmpSeq.Add(1))
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   icmpID,
+			Seq:  int(seq),
+			Data: []byte("PING"),
+		},
+	}
+
+	msgBytes, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	reply := make(chan error, 1)
+	icmpMu.Lock()
+	icmpWaiting[seq] = icmpWaiter{peer: ip, reply: reply}
+	icmpMu.Unlock()
+	answered := false
+	defer func() {
+		icmpMu.Lock()
+		delete(icmpWaiting, seq)
+		if !answered {
+			for old, at := range icmpExpired {
+				if time.Since(at) > time.Minute {
+					delete(icmpExpired, old)
+				}
+			}
+			icmpExpired[seq] = time.Now()
+		}
+		icmpMu.Unlock()
+	}()
+
+	// Send ping
+	start := time.Now()
+	if _, err := s.conn.WriteTo(msgBytes, addr); err != nil {
+		telemetry.socketErrors.Add(1)
+		return 0, err
+	}
+
+	// Wait for reply
+	select {
+	case err := <-reply:
+		answered = true
+		if err != nil {
+			return 0, err
+		}
+		return msSince(start), nil
+	case <-time.After(t.timeout()):
+		return 0, noReplyError{t.timeout()}
+	}
+}
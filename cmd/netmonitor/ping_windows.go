@@ -0,0 +1,112 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Raw ICMP sockets need administrator rights on Windows, so pings go
+// through the ICMP helper API of iphlpapi.dll instead, which any user may
+// call.
+var (
+	iphlpapi            = syscall.NewLazyDLL("iphlpapi.dll")
+	procIcmpCreateFile  = iphlpapi.NewProc("IcmpCreateFile")
+	procIcmpCloseHandle = iphlpapi.NewProc("IcmpCloseHandle")
+	procIcmpSendEcho2Ex = iphlpapi.NewProc("IcmpSendEcho2Ex")
+)
+
+// ipOptionInformation mirrors IP_OPTION_INFORMATION.
+type ipOptionInformation struct {
+	TTL         uint8
+	TOS         uint8
+	Flags       uint8
+	OptionsSize uint8
+	OptionsData uintptr
+}
+
+// icmpEchoReply mirrors ICMP_ECHO_REPLY.
+type icmpEchoReply struct {
+	Address       uint32
+	Status        uint32
+	RoundTripTime uint32
+	DataSize      uint16
+	Reserved      uint16
+	Data          uintptr
+	Options       ipOptionInformation
+}
+
+// icmpStatusError returns the error of a ping that ended with an IP_STATUS
+// code, reported by the address from when known.
+func icmpStatusError(status uint32, from netip.Addr, timeout time.Duration) error {
+	switch status {
+	case 11002, 11003, 11004, 11005: // destination net, host, protocol and port unreachable
+		return &icmpError{Type: icmpTypeUnreachable, Code: uint8(status - 11002), From: from}
+	case 11013:
+		return &icmpError{Type: icmpTypeTimeExceeded, From: from}
+	case 11010:
+		return noReplyError{timeout}
+	case 11050:
+		return errors.New("general failure")
+	}
+	return fmt.Errorf("ICMP status %d", status)
+}
+
+func ping(t *target) (float64, error) {
+	ip, err := t.resolve()
+	if err != nil {
+		return 0, err
+	}
+	var source uint32
+	if ip4 := t.source.To4(); ip4 != nil {
+		source = binary.LittleEndian.Uint32(ip4) // IPAddr is in network order
+	}
+	dest := binary.LittleEndian.Uint32(ip.AsSlice())
+
+	handle, _, err := procIcmpCreateFile.Call()
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return 0, fmt.Errorf("IcmpCreateFile: %v", err)
+	}
+	defer procIcmpCloseHandle.Call(handle)
+
+	data := []byte("PING")
+	options := ipOptionInformation{TTL: 128}
+	// The reply holds the echo reply, the echoed data, room for an ICMP
+	// error and an IO_STATUS_BLOCK.
+	reply := make([]byte, int(unsafe.Sizeof(icmpEchoReply{}))+len(data)+8+16)
+
+	start := time.Now()
+	n, _, err := procIcmpSendEcho2Ex.Call(
+		handle, 0, 0, 0,
+		uintptr(source), uintptr(dest),
+		uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)),
+		uintptr(unsafe.Pointer(&options)),
+		uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)),
+		uintptr(t.timeout()/time.Millisecond),
+	)
+	duration := time.Since(start)
+	if n == 0 {
+		if errno, ok := err.(syscall.Errno); ok && errno >= 11000 && errno < 12000 {
+			return 0, icmpStatusError(uint32(errno), netip.Addr{}, t.timeout())
+		}
+		return 0, err
+	}
+	echo := (*icmpEchoReply)(unsafe.Pointer(&reply[0]))
+	if echo.Status != 0 {
+		var from [4]byte
+		binary.LittleEndian.PutUint32(from[:], echo.Address)
+		return 0, icmpStatusError(echo.Status, netip.AddrFrom4(from), t.timeout())
+	}
+	return duration.Seconds() * 1000, nil
+}
+
+// openICMPSockets is a no-op; the ICMP API needs no privileges.
+func openICMPSockets(targets []*target) error {
+	return nil
+}
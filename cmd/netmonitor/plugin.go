@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Plugins are executables in the -plugins-dir directory. Each one is started
+// once and kept running; netmonitor talks to it with newline delimited JSON
+// over stdin and stdout. On startup the plugin announces itself:
+//
+//	{"protocol":1,"name":"snmp","probes":["snmp"],"notifiers":["matrix"]}
+//
+// after which netmonitor sends requests and the plugin answers each one with
+// the same id, in any order:
+//
+//	{"id":1,"method":"probe","target":"snmp://10.0.0.1?oid=..."}
+//	{"id":1,"latency":4.2,"metrics":{"ifInErrors":0}}
+//
+//	{"id":2,"method":"notify","notifier":"matrix://...","alert":{...}}
+//	{"id":2}
+//
+// A non-empty "error" field fails the request; "warning" marks a probed host
+// degraded. Anything the plugin writes to stderr ends up in our log. A
+// plugin that exits is restarted on the next request.
+const pluginProtocol = 1
+
+type pluginHandshake struct {
+	Protocol  int      `json:"protocol"`
+	Name      string   `json:"name"`
+	Probes    []string `json:"probes"`
+	Notifiers []string `json:"notifiers"`
+}
+
+type pluginRequest struct {
+	ID       uint64 `json:"id"`
+	Method   string `json:"method"`
+	Target   string `json:"target,omitempty"`
+	Notifier string `json:"notifier,omitempty"`
+	Alert    *Alert `json:"alert,omitempty"`
+}
+
+type pluginResponse struct {
+	ID      uint64             `json:"id"`
+	Error   string             `json:"error,omitempty"`
+	Latency float64            `json:"latency"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	Warning string             `json:"warning,omitempty"`
+}
+
+// plugin is one running plugin process.
+type plugin struct {
+	path string
+	name string
+	user *account // runs the process when set and still root
+
+	mu      sync.Mutex
+	stdin   io.WriteCloser
+	pending map[uint64]chan pluginResponse
+	nextID  uint64
+}
+
+// loadPlugins starts every executable in dir, as user when set, and
+// registers the probe and notifier types it offers.
+func loadPlugins(dir string, user *account) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		p := &plugin{path: filepath.Join(dir, entry.Name()), user: user}
+		hs, err := p.start()
+		if err != nil {
+			return fmt.Errorf("plugin %s: %v", entry.Name(), err)
+		}
+		p.name = hs.Name
+
+		for _, kind := range hs.Probes {
+			if _, exists := probers[kind]; exists {
+				return fmt.Errorf("plugin %s: probe type %q is already registered", p.name, kind)
+			}
+			probers[kind] = p.probe
+			localProbes[kind] = true
+		}
+		for _, scheme := range hs.Notifiers {
+			if _, exists := notifierFactories[scheme]; exists {
+				return fmt.Errorf("plugin %s: notifier type %q is already registered", p.name, scheme)
+			}
+			notifierFactories[scheme] = func(u *url.URL) (Notifier, error) {
+				return &pluginNotifier{plugin: p, url: u.String()}, nil
+			}
+		}
+		log.Printf("Loaded plugin %s (probes %v, notifiers %v)", p.name, hs.Probes, hs.Notifiers)
+	}
+	return nil
+}
+
+// start launches the plugin process and waits for its handshake. The
+// caller must hold p.mu or be the only user of p.
+func (p *plugin) start() (pluginHandshake, error) {
+	cmd := exec.Command(p.path)
+	cmd.Stderr = os.Stderr
+	p.user.apply(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return pluginHandshake{}, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return pluginHandshake{}, err
+	}
+	if err := cmd.Start(); err != nil {
+		return pluginHandshake{}, err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	handshake := make(chan error, 1)
+	var hs pluginHandshake
+	go func() {
+		if !scanner.Scan() {
+			handshake <- errors.New("plugin exited before handshake")
+			return
+		}
+		handshake <- json.Unmarshal(scanner.Bytes(), &hs)
+	}()
+
+	select {
+	case err = <-handshake:
+	case <-time.After(5 * time.Second):
+		err = errors.New("timed out waiting for handshake")
+	}
+	if err == nil && hs.Protocol != pluginProtocol {
+		err = fmt.Errorf("unsupported protocol version %d", hs.Protocol)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return pluginHandshake{}, err
+	}
+
+	p.stdin = stdin
+	p.pending = make(map[uint64]chan pluginResponse)
+	go p.readLoop(cmd, scanner, p.pending)
+	return hs, nil
+}
+
+// readLoop hands responses to their waiting callers until the process
+// exits, then fails whatever is still pending.
+func (p *plugin) readLoop(cmd *exec.Cmd, scanner *bufio.Scanner, pending map[uint64]chan pluginResponse) {
+	for scanner.Scan() {
+		var resp pluginResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Printf("Plugin %s sent invalid response: %v", p.name, err)
+			continue
+		}
+		p.mu.Lock()
+		ch, ok := pending[resp.ID]
+		delete(pending, resp.ID)
+		p.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	err := cmd.Wait()
+	log.Printf("Plugin %s exited: %v", p.name, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, ch := range pending {
+		ch <- pluginResponse{ID: id, Error: "plugin exited"}
+		delete(pending, id)
+	}
+	if p.stdin != nil {
+		p.stdin.Close()
+		p.stdin = nil
+	}
+}
+
+// call sends a request and waits for the matching response.
+func (p *plugin) call(req pluginRequest, timeout time.Duration) (pluginResponse, error) {
+	p.mu.Lock()
+	if p.stdin == nil {
+		if _, err := p.start(); err != nil {
+			p.mu.Unlock()
+			return pluginResponse{}, fmt.Errorf("plugin %s: restart failed: %v", p.name, err)
+		}
+	}
+	p.nextID++
+	req.ID = p.nextID
+	ch := make(chan pluginResponse, 1)
+	p.pending[req.ID] = ch
+
+	line, err := json.Marshal(req)
+	if err == nil {
+		_, err = p.stdin.Write(append(line, '\n'))
+	}
+	if err != nil {
+		delete(p.pending, req.ID)
+		p.mu.Unlock()
+		return pluginResponse{}, fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+	p.mu.Unlock()
+
+	select {
+	case resp := <-ch:
+		if resp.Error != "" {
+			return resp, errors.New(resp.Error)
+		}
+		return resp, nil
+	case <-time.After(timeout):
+		p.mu.Lock()
+		delete(p.pending, req.ID)
+		p.mu.Unlock()
+		return pluginResponse{}, fmt.Errorf("plugin %s: timed out", p.name)
+	}
+}
+
+func (p *plugin) probe(t *target) (probeResult, error) {
+	resp, err := p.call(pluginRequest{Method: "probe", Target: t.url.String()}, t.timeout())
+	if err != nil {
+		return probeResult{}, err
+	}
+	return probeResult{Latency: resp.Latency, Metrics: resp.Metrics, Warning: resp.Warning}, nil
+}
+
+// pluginNotifier forwards alerts to a notifier implemented by a plugin.
+type pluginNotifier struct {
+	plugin *plugin
+	url    string
+}
+
+func (n *pluginNotifier) Notify(a Alert) error {
+	_, err := n.plugin.call(pluginRequest{Method: "notify", Notifier: n.url, Alert: &a}, 30*time.Second)
+	return err
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PortScanConfig checks which TCP ports of a host are open on a schedule
+// of its own and alerts when that changes, catching firewall openings and
+// services that went away. It can be set at the top level of the config
+// file, for a group or for a single host:
+//
+//	port_scan:
+//	  ports: [22, 80, 443, 8000-8100]
+//	  interval: 1h
+type PortScanConfig struct {
+	Ports    []string      `yaml:"ports"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PortScan is the resolved port scan of a host; no ports means none.
+type PortScan struct {
+	Ports    []int
+	Interval time.Duration
+}
+
+const (
+	defaultPortScanInterval = time.Hour
+	maxScannedPorts         = 4096
+	portScanParallel        = 64 // connections in flight per host
+)
+
+// over returns base with the configured settings replaced.
+func (c PortScanConfig) over(base PortScan) (PortScan, error) {
+	if len(c.Ports) > 0 {
+		ports, err := parsePorts(c.Ports)
+		if err != nil {
+			return PortScan{}, err
+		}
+		base.Ports = ports
+	}
+	base.Interval = cmp.Or(c.Interval, base.Interval)
+	return base, nil
+}
+
+// parsePorts expands a list of ports and ranges such as 8000-8100.
+func parsePorts(specs []string) ([]int, error) {
+	var ports []int
+	for _, spec := range specs {
+		first, last, isRange := strings.Cut(spec, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(first))
+		hi := lo
+		if err == nil && isRange {
+			hi, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || lo < 1 || hi > 65535 || lo > hi {
+			return nil, fmt.Errorf("invalid port %q", spec)
+		}
+		for p := lo; p <= hi; p++ {
+			ports = append(ports, p)
+		}
+	}
+	slices.Sort(ports)
+	ports = slices.Compact(ports)
+	if len(ports) > maxScannedPorts {
+		return nil, fmt.Errorf("%d ports to scan, at most %d allowed", len(ports), maxScannedPorts)
+	}
+	return ports, nil
+}
+
+// hostPortScan resolves the port scan of a host: its own settings win,
+// then those of the first matching group, then the top level ones.
+func (cfg *Config) hostPortScan(h HostConfig) (PortScan, error) {
+	s, err := cfg.PortScan.over(PortScan{Interval: defaultPortScanInterval})
+	if err != nil {
+		return s, err
+	}
+	for i := len(cfg.Groups) - 1; i >= 0; i-- {
+		if cfg.Groups[i].matches(h.Tags) {
+			if s, err = cfg.Groups[i].PortScan.over(s); err != nil {
+				return s, err
+			}
+		}
+	}
+	return h.PortScan.over(s)
+}
+
+// PortChange lists the ports that opened and closed since the last scan.
+type PortChange struct {
+	Opened []int `json:"opened,omitempty"`
+	Closed []int `json:"closed,omitempty"`
+}
+
+// scanPorts returns the ports of the target that accept TCP connections.
+func scanPorts(t *target, ports []int) ([]int, error) {
+	addr, err := t.resolve()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mu   sync.Mutex
+		open []int
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, portScanParallel)
+	)
+	for _, port := range ports {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			conn, err := t.dial("tcp", net.JoinHostPort(addr.String(), strconv.Itoa(port)))
+			if err != nil {
+				return
+			}
+			conn.Close()
+			mu.Lock()
+			open = append(open, port)
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	slices.Sort(open)
+	return open, nil
+}
+
+// diffPorts compares two sorted port lists.
+func diffPorts(before, after []int) PortChange {
+	var c PortChange
+	for _, p := range after {
+		if _, found := slices.BinarySearch(before, p); !found {
+			c.Opened = append(c.Opened, p)
+		}
+	}
+	for _, p := range before {
+		if _, found := slices.BinarySearch(after, p); !found {
+			c.Closed = append(c.Closed, p)
+		}
+	}
+	return c
+}
+
+// scanPortsLoop scans the hosts that have ports configured once their
+// interval has passed since the last scan.
+func (m *Monitor) scanPortsLoop() {
+	for {
+		hs := m.hosts()
+		for _, t := range hs.targets {
+			stats := hs.stats[t.name]
+			if len(t.portScan.Ports) == 0 || time.Since(stats.load().PortsScanned) < t.portScan.Interval {
+				continue
+			}
+			// Hosts that are not probed right now are not scanned either.
+			if s := stats.load(); s.Paused || s.OffSchedule {
+				continue
+			}
+			m.scanHostPorts(t, stats)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// scanHostPorts scans one host and alerts when its open ports changed.
+// The first scan only records them.
+func (m *Monitor) scanHostPorts(t *target, stats *hostStats) {
+	open, err := scanPorts(t, t.portScan.Ports)
+	if err != nil {
+		log.Printf("Scanning the ports of %s failed: %v", t.name, err)
+		return
+	}
+	var (
+		change  PortChange
+		first   bool
+		current PingStats
+	)
+	stats.update(func(s *PingStats) {
+		first = s.PortsScanned.IsZero()
+		change = diffPorts(s.OpenPorts, open)
+		s.OpenPorts = open
+		s.PortsScanned = time.Now()
+		current = *s
+	})
+	if first || len(change.Opened)+len(change.Closed) == 0 {
+		return
+	}
+	log.Printf("Open ports of %s changed: opened %v, closed %v", t.name, change.Opened, change.Closed)
+	go m.notify(Alert{
+		Host:         t.name,
+		Status:       current.Status,
+		Previous:     current.Status,
+		Severity:     severityWarning,
+		Message:      change.String(),
+		Time:         current.PortsScanned,
+		Stats:        current,
+		DashboardURL: m.dashboardURL,
+		Ports:        &change,
+	})
+}
+
+func (c PortChange) String() string {
+	join := func(ports []int) string {
+		s := make([]string, len(ports))
+		for i, p := range ports {
+			s[i] = strconv.Itoa(p)
+		}
+		return strings.Join(s, ", ")
+	}
+	var parts []string
+	if len(c.Opened) > 0 {
+		parts = append(parts, "opened "+join(c.Opened))
+	}
+	if len(c.Closed) > 0 {
+		parts = append(parts, "closed "+join(c.Closed))
+	}
+	return "Ports " + strings.Join(parts, "; ")
+}
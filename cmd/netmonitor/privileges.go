@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+)
+
+// account is the unprivileged user netmonitor switches to once its raw
+// sockets and listener are open, so the web server and probes do not run
+// as root.
+type account struct {
+	name     string
+	uid, gid int
+}
+
+func lookupAccount(name string) (*account, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: unsupported uid %q", name, u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: unsupported gid %q", name, u.Gid)
+	}
+	return &account{name: name, uid: uid, gid: gid}, nil
+}
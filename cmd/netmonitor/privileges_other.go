@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func (a *account) drop() error {
+	return errors.New("switching user is not supported on this platform")
+}
+
+func (a *account) apply(cmd *exec.Cmd) {}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// drop switches the whole process to the account. It is a no-op when not
+// running as root, e.g. with only CAP_NET_RAW.
+func (a *account) drop() error {
+	if os.Getuid() != 0 {
+		return nil
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setgid(a.gid); err != nil {
+		return fmt.Errorf("setgid: %v", err)
+	}
+	if err := syscall.Setuid(a.uid); err != nil {
+		return fmt.Errorf("setuid: %v", err)
+	}
+	return nil
+}
+
+// apply makes cmd run as the account, for processes started before the
+// drop.
+func (a *account) apply(cmd *exec.Cmd) {
+	if a == nil || os.Getuid() != 0 {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(a.uid), Gid: uint32(a.gid), Groups: []uint32{}},
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// probeTimeout bounds a single probe, including name resolution and any
+// handshakes it performs.
+const probeTimeout = 3 * time.Second
+
+// probeResult is the outcome of a successful probe.
+type probeResult struct {
+	Latency float64            // total round trip in milliseconds
+	Metrics map[string]float64 // probe specific measurements, may be nil
+
+	// Warning marks the host degraded: the target answered but something
+	// about the answer is wrong, such as an NTP server with too much drift.
+	Warning string
+
+	// KeepMetrics stores the metrics in the history along with the
+	// latency, for measurements such as throughput that are charted over
+	// time.
+	KeepMetrics bool
+}
+
+// probeFunc runs a single check against a target.
+type probeFunc func(t *target) (probeResult, error)
+
+// probers maps a target kind to the function that checks it. Probe types
+// register themselves from their own files.
+var probers = map[string]probeFunc{}
+
+// Prober runs the probes of a monitor. The registered probe types do
+// unless the monitor is given another, such as the simulator of -dry-run
+// or a fake in tests.
+type Prober interface {
+	Probe(t *target) (probeResult, error)
+}
+
+// registeredProbers runs the probe type of each target, retrying as its
+// policy allows.
+type registeredProbers struct{}
+
+func (registeredProbers) Probe(t *target) (probeResult, error) {
+	return probeWithRetries(t)
+}
+
+// probeDurations holds, for probe types that take longer than their
+// timeout on purpose, how long one probe of a target may run.
+var probeDurations = map[string]func(t *target) time.Duration{}
+
+// localProbes holds the probe types that run programs on the monitoring
+// host, which only the config file may use.
+var localProbes = map[string]bool{}
+
+// runsLocally reports whether probing t runs a program on this host.
+func (t *target) runsLocally() bool {
+	if t.composite != nil {
+		for _, c := range t.composite.checks {
+			if c.runsLocally() {
+				return true
+			}
+		}
+	}
+	return localProbes[t.kind]
+}
+
+func init() {
+	probers["icmp"] = probeICMP
+}
+
+func probeICMP(t *target) (probeResult, error) {
+	latency, err := ping(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	return probeResult{Latency: latency}, nil
+}
+
+// msSince returns the time elapsed since start in milliseconds.
+func msSince(start time.Time) float64 {
+	return time.Since(start).Seconds() * 1000
+}
+
+// probeState is what the probes of a monitor's targets remember between
+// runs, by target name. Every monitor, such as a tenant's, has its own, so
+// targets of the same name in two of them share nothing.
+type probeState struct {
+	now func() time.Time // the monitor's clock
+
+	mu     sync.Mutex
+	checks map[string]checkResult // last results of probes wrapped by every
+	paths  map[string][]tracePath // last found by traceroute probes
+}
+
+func newProbeState(now func() time.Time) *probeState {
+	return &probeState{now: now, checks: map[string]checkResult{}, paths: map[string][]tracePath{}}
+}
+
+type checkResult struct {
+	at     time.Time
+	result probeResult
+	err    error
+}
+
+// every wraps a probe that is too expensive or too impolite to run every
+// interval, such as a speed test, so it runs at most once per refresh, a
+// duration the target gives as its refresh parameter. Probes in between
+// report the last result again, without keeping its metrics a second time.
+// Targets outside a monitor are probed every time.
+func every(defaultRefresh string, probe probeFunc) probeFunc {
+	return func(t *target) (probeResult, error) {
+		refresh, err := time.ParseDuration(t.param("refresh", defaultRefresh))
+		if err != nil {
+			return probeResult{}, fmt.Errorf("invalid refresh: %v", err)
+		}
+		s := t.state
+		if s == nil {
+			return probe(t)
+		}
+		s.mu.Lock()
+		last, ok := s.checks[t.name]
+		s.mu.Unlock()
+		if ok && s.now().Sub(last.at) < refresh {
+			last.result.KeepMetrics = false
+			return last.result, last.err
+		}
+		result, err := probe(t)
+		s.mu.Lock()
+		s.checks[t.name] = checkResult{at: s.now(), result: result, err: err}
+		s.mu.Unlock()
+		return result, err
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEveryKeepsResultsPerMonitor(t *testing.T) {
+	runs := map[*probeState]int{}
+	probe := every("1m", func(t *target) (probeResult, error) {
+		runs[t.state]++
+		return probeResult{Latency: float64(runs[t.state]), KeepMetrics: true}, nil
+	})
+
+	// Two tenants monitoring a host of the same name.
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	var targets [2]*target
+	for i := range targets {
+		tgt, err := parseTarget("192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMonitor([]*target{tgt}, 0, time.Second)
+		m.clock = clock
+		targets[i] = tgt
+	}
+
+	tests := []struct {
+		advance time.Duration
+		tenant  int
+		latency float64
+		keep    bool
+	}{
+		{0, 0, 1, true},
+		{30 * time.Second, 0, 1, false}, // within the refresh
+		{0, 1, 1, true},                 // the other tenant's is its own
+		{30 * time.Second, 0, 2, true},  // a minute after the first
+		{0, 1, 1, false},
+	}
+	for i, tt := range tests {
+		clock.advance(tt.advance)
+		result, err := probe(targets[tt.tenant])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Latency != tt.latency || result.KeepMetrics != tt.keep {
+			t.Errorf("probe %d of tenant %d: latency %v, keep %v; want %v, %v", i, tt.tenant, result.Latency, result.KeepMetrics, tt.latency, tt.keep)
+		}
+	}
+
+	// Outside a monitor nothing is remembered.
+	tgt, _ := parseTarget("192.0.2.1")
+	probe(tgt)
+	if result, _ := probe(tgt); result.Latency != 2 {
+		t.Errorf("probe outside a monitor: latency %v, want a second run", result.Latency)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// statsQuery narrows down /api/stats for large deployments and lightweight
+// clients:
+//
+//	?status=down,degraded  hosts in any of the states, paused or off_schedule
+//	?tag=prod&tag=db       hosts with any of the tags
+//	?sort=-latency         see sortStats
+//	?offset=50&limit=50    one page; X-Total-Count has the unpaged count
+//	?fields=host,status    only these JSON fields
+type statsQuery struct {
+	status []string
+	tags   []string
+	sort   string
+	offset int
+	limit  int // 0 means no limit
+	fields []string
+}
+
+// statsFields are the JSON field names of PingStats.
+var statsFields = jsonFields(reflect.TypeFor[PingStats]())
+
+// jsonFields lists the JSON names of a struct's fields, including those of
+// embedded structs.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous {
+			names = append(names, jsonFields(f.Type)...)
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		names = append(names, name)
+	}
+	return names
+}
+
+func parseStatsQuery(q url.Values) (statsQuery, error) {
+	sq := statsQuery{sort: q.Get("sort")}
+	for _, v := range q["status"] {
+		sq.status = append(sq.status, splitList(v)...)
+	}
+	for _, v := range q["tag"] {
+		sq.tags = append(sq.tags, splitList(v)...)
+	}
+	for _, v := range q["fields"] {
+		sq.fields = append(sq.fields, splitList(v)...)
+	}
+	for _, f := range sq.fields {
+		if !slices.Contains(statsFields, f) {
+			return sq, fmt.Errorf("unknown field %q", f)
+		}
+	}
+	for name, n := range map[string]*int{"offset": &sq.offset, "limit": &sq.limit} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return sq, fmt.Errorf("invalid %s %q", name, v)
+		}
+		*n = i
+	}
+	return sq, sortStats(nil, sq.sort)
+}
+
+// filter returns the requested page of stats and how many hosts matched.
+func (sq statsQuery) filter(stats []PingStats) ([]PingStats, int) {
+	stats = slices.DeleteFunc(stats, func(s PingStats) bool {
+		if len(sq.status) > 0 && !slices.Contains(sq.status, s.Status) &&
+			!(s.Paused && slices.Contains(sq.status, "paused")) && !(s.OffSchedule && slices.Contains(sq.status, "off_schedule")) {
+			return true
+		}
+		return len(sq.tags) > 0 && !slices.ContainsFunc(sq.tags, func(tag string) bool {
+			return slices.Contains(s.Tags, tag)
+		})
+	})
+	sortStats(stats, sq.sort)
+	total := len(stats)
+	stats = stats[min(sq.offset, total):]
+	if sq.limit > 0 {
+		stats = stats[:min(sq.limit, len(stats))]
+	}
+	return stats, total
+}
+
+// project keeps only the selected fields of each host.
+func (sq statsQuery) project(stats []PingStats) ([]map[string]json.RawMessage, error) {
+	result := make([]map[string]json.RawMessage, 0, len(stats))
+	for _, s := range stats {
+		b, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, err
+		}
+		host := map[string]json.RawMessage{}
+		for _, f := range sq.fields {
+			if v, ok := all[f]; ok {
+				host[f] = v
+			}
+		}
+		result = append(result, host)
+	}
+	return result, nil
+}
+
+// handleStats serves /api/stats, narrowed down by a statsQuery.
+func (m *Monitor) handleStats(w http.ResponseWriter, r *http.Request) {
+	sq, err := parseStatsQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stats, total := sq.filter(m.GetStats())
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if len(sq.fields) == 0 {
+		writeJSON(w, http.StatusOK, stats)
+		return
+	}
+	hosts, err := sq.project(stats)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, hosts)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatsQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  statsQuery
+		err   string
+	}{
+		{"", statsQuery{}, ""},
+		{"status=down,degraded&status=paused&tag=prod", statsQuery{status: []string{"down", "degraded", "paused"}, tags: []string{"prod"}}, ""},
+		{"sort=-latency&offset=50&limit=25", statsQuery{sort: "-latency", offset: 50, limit: 25}, ""},
+		{"fields=host,status", statsQuery{fields: []string{"host", "status"}}, ""},
+		{"fields=host,password", statsQuery{}, `unknown field "password"`},
+		{"sort=uptime", statsQuery{}, `unknown sort order "uptime"`},
+		{"limit=-1", statsQuery{}, `invalid limit "-1"`},
+		{"offset=ten", statsQuery{}, `invalid offset "ten"`},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := parseStatsQuery(q)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got.status, tt.want.status) || !slices.Equal(got.tags, tt.want.tags) ||
+			!slices.Equal(got.fields, tt.want.fields) || got.sort != tt.want.sort || got.offset != tt.want.offset || got.limit != tt.want.limit {
+			t.Errorf("%q: %+v, %v; want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestStatsQueryFilter(t *testing.T) {
+	stats := []PingStats{
+		{Host: "a", Status: "up", CurrentLatency: 30, Tags: []string{"prod"}},
+		{Host: "b", Status: "down", Tags: []string{"prod", "db"}},
+		{Host: "c", Status: "degraded", CurrentLatency: 10},
+		{Host: "d", Status: "up", CurrentLatency: 20, Paused: true, Tags: []string{"db"}},
+		{Host: "e", Status: "up", CurrentLatency: 5, OffSchedule: true},
+	}
+	tests := []struct {
+		query string
+		hosts []string
+		total int
+	}{
+		{"", []string{"a", "b", "c", "d", "e"}, 5},
+		{"status=down,degraded", []string{"b", "c"}, 2},
+		{"status=paused", []string{"d"}, 1},
+		{"status=off_schedule,down", []string{"b", "e"}, 2},
+		{"tag=db", []string{"b", "d"}, 2},
+		{"tag=prod&status=up", []string{"a"}, 1},
+		{"sort=-latency", []string{"a", "d", "c", "e", "b"}, 5},
+		{"sort=latency&offset=1&limit=2", []string{"e", "c"}, 5},
+		{"offset=10", []string{}, 5},
+		{"tag=staging", []string{}, 0},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		sq, err := parseStatsQuery(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, total := sq.filter(slices.Clone(stats))
+		hosts := []string{}
+		for _, s := range page {
+			hosts = append(hosts, s.Host)
+		}
+		if !slices.Equal(hosts, tt.hosts) || total != tt.total {
+			t.Errorf("%q: %v of %d, want %v of %d", tt.query, hosts, total, tt.hosts, tt.total)
+		}
+	}
+}
+
+func TestHandleStatsQuery(t *testing.T) {
+	var targets []*target
+	for _, raw := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		tgt, err := parseTarget(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, tgt)
+	}
+	m := NewMonitor(targets, 0, time.Second)
+	m.config = &Config{}
+
+	tests := []struct {
+		query  string
+		code   int
+		total  string
+		fields []string
+		hosts  int
+	}{
+		{"?limit=2&fields=host,status", http.StatusOK, "3", []string{"host", "status"}, 2},
+		{"?fields=host&offset=2", http.StatusOK, "3", []string{"host"}, 1},
+		{"?fields=secret", http.StatusBadRequest, "", nil, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("GET", "/api/stats"+tt.query, nil))
+		if w.Code != tt.code || w.Header().Get("X-Total-Count") != tt.total {
+			t.Errorf("%s: %d with X-Total-Count %q, want %d with %q", tt.query, w.Code, w.Header().Get("X-Total-Count"), tt.code, tt.total)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		var hosts []map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &hosts); err != nil || len(hosts) != tt.hosts {
+			t.Errorf("%s: %s", tt.query, w.Body)
+			continue
+		}
+		for _, h := range hosts {
+			var keys []string
+			for k := range h {
+				keys = append(keys, k)
+			}
+			slices.Sort(keys)
+			if !slices.Equal(keys, tt.fields) {
+				t.Errorf("%s: fields %v, want %v", tt.query, keys, tt.fields)
+			}
+		}
+	}
+}
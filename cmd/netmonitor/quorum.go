@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// With ha.quorum, instances that probe the same hosts agree on outages
+// before alerting: each records in Redis the status it sees every host in,
+// and a host going down is only alerted once quorum of the live instances
+// see it down, so a problem local to one of them does not page anyone.
+// Fewer live instances than the quorum all have to agree.
+
+// heartbeat records that this instance is alive, for counting votes.
+func (ha *haState) heartbeat() {
+	ha.do("HSET", ha.key("instances"), ha.name, strconv.FormatInt(time.Now().UnixMilli(), 10))
+}
+
+// vote records the status this instance sees a host in.
+func (ha *haState) vote(host, status string) {
+	if _, err := ha.do("HSET", ha.key("votes", host), ha.name, status); err != nil {
+		log.Printf("HA: recording the status of %s failed: %v", host, err)
+	}
+}
+
+// agreed reports whether enough live instances see host down, along with
+// how many do and how many are needed. Without Redis every instance
+// decides on its own.
+func (ha *haState) agreed(host string) (ok bool, down, needed int) {
+	reply, err := ha.do("HGETALL", ha.key("instances"))
+	if err != nil {
+		return true, 0, 0
+	}
+	live := map[string]bool{ha.name: true}
+	items, _ := reply.([]any)
+	for i := 0; i+1 < len(items); i += 2 {
+		name, _ := items[i].(string)
+		raw, _ := items[i+1].(string)
+		if ms, err := strconv.ParseInt(raw, 10, 64); err == nil && time.Since(time.UnixMilli(ms)) <= 2*ha.lease {
+			live[name] = true
+		}
+	}
+	if reply, err = ha.do("HGETALL", ha.key("votes", host)); err != nil {
+		return true, 0, 0
+	}
+	items, _ = reply.([]any)
+	for i := 0; i+1 < len(items); i += 2 {
+		name, _ := items[i].(string)
+		if live[name] && items[i+1] == "down" {
+			down++
+		}
+	}
+	needed = min(ha.quorum, len(live))
+	return down >= needed, down, needed
+}
+
+// confirm records the status of a host after a probe and decides whether
+// its alert, if any, goes out. A down alert is held until a quorum agrees
+// and is sent by a later probe then, or dropped with its recovery if the
+// host comes back first.
+func (ha *haState) confirm(h *hostState, status string, changed bool, a *Alert, send bool) bool {
+	host := h.t.name
+	if changed {
+		ha.vote(host, status)
+	}
+	if ha.quorum <= 1 {
+		return send
+	}
+	switch {
+	case send && !a.Repeat && a.Status == "down":
+		ok, down, needed := ha.agreed(host)
+		if ok {
+			return true
+		}
+		log.Printf("%s is down on %d of the %d instances needed to alert", host, down, needed)
+		held := *a
+		h.unconfirmed = &held
+		return false
+	case h.unconfirmed != nil && status == "down":
+		if ok, _, _ := ha.agreed(host); !ok {
+			return false
+		}
+		log.Printf("%s is down on enough instances to alert", host)
+		*a, h.unconfirmed = *h.unconfirmed, nil
+		return true
+	case h.unconfirmed != nil:
+		log.Printf("%s came back before enough instances saw it down", host)
+		h.unconfirmed = nil
+		return send && a.Status != "up"
+	}
+	return send
+}
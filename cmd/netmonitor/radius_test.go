@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// radiusResponse builds a response with code to the request, signed with
+// secret.
+func radiusResponse(request []byte, code byte, secret string, attrs ...[]byte) []byte {
+	p := []byte{code, request[1], 0, 0}
+	p = append(p, request[4:20]...)
+	for _, a := range attrs {
+		p = append(p, a...)
+	}
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	sum := md5.Sum(append(bytes.Clone(p), secret...))
+	copy(p[4:20], sum[:])
+	return p
+}
+
+// radiusAttributes returns the attributes of a packet by type.
+func radiusAttributes(p []byte) map[byte][]byte {
+	attrs := map[byte][]byte{}
+	for rest := p[20:]; len(rest) >= 2 && int(rest[1]) <= len(rest); rest = rest[rest[1]:] {
+		attrs[rest[0]] = rest[2:rest[1]]
+	}
+	return attrs
+}
+
+func TestRADIUSAccessRequest(t *testing.T) {
+	const secret = "sharedsecret"
+	for _, password := range []string{"", "secret", "exactly16bytes!!", "a password longer than sixteen bytes"} {
+		p, authenticator := radiusAccessRequestPacket(42, "monitor", password, "probe1", secret)
+		if p[0] != radiusAccessRequest || p[1] != 42 || int(binary.BigEndian.Uint16(p[2:])) != len(p) || !bytes.Equal(p[4:20], authenticator) {
+			t.Fatalf("%q: header % x", password, p[:20])
+		}
+		attrs := radiusAttributes(p)
+		if string(attrs[radiusUserName]) != "monitor" || string(attrs[radiusNASIdentifier]) != "probe1" {
+			t.Errorf("%q: attributes %q", password, attrs)
+		}
+
+		// Hiding is its own inverse.
+		hidden := attrs[radiusUserPassword]
+		if len(hidden) == 0 || len(hidden)%16 != 0 {
+			t.Errorf("%q: hidden password of %d bytes", password, len(hidden))
+			continue
+		}
+		var plain []byte
+		for prev, i := authenticator, 0; i < len(hidden); prev, i = hidden[i:i+16], i+16 {
+			h := md5.Sum(append([]byte(secret), prev...))
+			for j := range 16 {
+				plain = append(plain, hidden[i+j]^h[j])
+			}
+		}
+		if got := string(bytes.TrimRight(plain, "\x00")); got != password {
+			t.Errorf("%q: password hidden as %q", password, got)
+		}
+
+		signed := bytes.Clone(p)
+		copy(signed[len(signed)-16:], make([]byte, 16))
+		mac := hmac.New(md5.New, []byte(secret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), attrs[radiusMessageAuthenticator]) {
+			t.Errorf("%q: Message-Authenticator does not match", password)
+		}
+	}
+}
+
+func TestParseRADIUSResponse(t *testing.T) {
+	const secret = "sharedsecret"
+	request, authenticator := radiusAccessRequestPacket(7, "monitor", "secret", "netmonitor", secret)
+	reply := func(s string) []byte { return radiusAttribute(nil, radiusReplyMessage, []byte(s)) }
+	padded := append(radiusResponse(request, radiusAccessAccept, secret), 0, 0, 0)
+	truncated := radiusResponse(request, radiusAccessAccept, secret, reply("Welcome"))
+	truncated = truncated[:len(truncated)-1]
+	otherID := radiusResponse(request, radiusAccessAccept, secret)
+	otherID[1] = 8
+	tests := []struct {
+		name    string
+		p       []byte
+		code    byte
+		message string
+		err     string
+	}{
+		{"accept", radiusResponse(request, radiusAccessAccept, secret), radiusAccessAccept, "", ""},
+		{"reject", radiusResponse(request, radiusAccessReject, secret, reply("Bad password. "), reply("Try again\n")), radiusAccessReject, "Bad password. Try again", ""},
+		{"padded", padded, radiusAccessAccept, "", ""},
+		{"wrong secret", radiusResponse(request, radiusAccessAccept, "guess"), 0, "", "authenticator mismatch, check the secret"},
+		{"other request", otherID, 0, "", "RADIUS response to another request"},
+		{"short", request[:19], 0, "", "invalid RADIUS response"},
+		{"truncated", truncated, 0, "", "invalid RADIUS response"},
+		{"bad attribute", radiusResponse(request, radiusAccessAccept, secret, []byte{radiusReplyMessage, 1}), 0, "", "invalid RADIUS attribute"},
+	}
+	for _, tt := range tests {
+		code, message, err := parseRADIUSResponse(tt.p, 7, authenticator, secret)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || code != tt.code || message != tt.message {
+			t.Errorf("%s: %d %q, %v", tt.name, code, message, err)
+		}
+	}
+}
+
+func TestProbeRADIUS(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The server answers by user name, and slow only once asked again.
+	go func() {
+		seen := map[string]bool{}
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := bytes.Clone(buf[:n])
+			var response []byte
+			switch user := string(radiusAttributes(request)[radiusUserName]); user {
+			case "monitor":
+				response = radiusResponse(request, radiusAccessAccept, "sharedsecret")
+			case "mfa":
+				response = radiusResponse(request, radiusAccessChallenge, "sharedsecret")
+			case "locked":
+				response = radiusResponse(request, radiusAccessReject, "sharedsecret", radiusAttribute(nil, radiusReplyMessage, []byte("Account locked")))
+			case "slow":
+				if !seen[user] {
+					seen[user] = true
+					continue
+				}
+				response = radiusResponse(request, radiusAccessAccept, "sharedsecret")
+			default:
+				response = radiusResponse(request, radiusAccessReject, "sharedsecret")
+			}
+			conn.WriteTo(response, addr)
+		}
+	}()
+
+	addr := conn.LocalAddr().String()
+	tests := []struct {
+		name    string
+		target  string
+		warning string
+		err     string
+	}{
+		{"accept", "radius://monitor:secret@" + addr + "?secret=sharedsecret", "", ""},
+		{"challenge", "radius://mfa:secret@" + addr + "?secret=sharedsecret", "access challenge", ""},
+		{"resent", "radius://slow:secret@" + addr + "?secret=sharedsecret", "", ""},
+		{"reject", "radius://nobody:secret@" + addr + "?secret=sharedsecret", "", "access rejected"},
+		{"reject with message", "radius://locked:secret@" + addr + "?secret=sharedsecret", "", "access rejected: Account locked"},
+		{"wrong secret", "radius://monitor:secret@" + addr + "?secret=guess", "", "RADIUS response authenticator mismatch, check the secret"},
+		{"no secret", "radius://monitor:secret@" + addr, "", "missing secret"},
+		{"no user", "radius://" + addr + "?secret=sharedsecret", "", "missing user"},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(tgt.name, "sharedsecret") {
+			t.Errorf("%s: secret shown in %s", tt.name, tgt.name)
+		}
+		result, err := probeRADIUS(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Warning != tt.warning {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig paces outgoing probes so a large host list does not go
+// out in bursts that upstream IDS mistake for a ping flood. Rates are in
+// probes per second; zero means unlimited. Per-network limits apply to
+// each destination /24 (IPv4) or /64 (IPv6).
+//
+//	rate_limit:
+//	  global: 500
+//	  per_network: 20
+type RateLimitConfig struct {
+	Global     float64 `yaml:"global"`
+	PerNetwork float64 `yaml:"per_network"`
+}
+
+// tokenBucket allows rate events per second with bursts of up to burst.
+type tokenBucket struct {
+	rate, burst float64
+	tokens      float64
+	last        time.Time
+}
+
+// newTokenBucket returns a full bucket. Its last refill is unset, which
+// the first wait treats as long ago.
+func newTokenBucket(rate float64) *tokenBucket {
+	// Allow a tenth of a second's worth at once to keep pacing smooth.
+	burst := max(rate/10, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// wait refills the bucket and returns how long until a token is available.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter combines the global and per-network buckets.
+type rateLimiter struct {
+	mu         sync.Mutex
+	global     *tokenBucket
+	perNetwork float64
+	networks   map[string]*tokenBucket
+	hostNets   map[string]string // resolved network of each host
+}
+
+// newRateLimiter returns nil when no limit is configured.
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	if cfg.Global <= 0 && cfg.PerNetwork <= 0 {
+		return nil
+	}
+	l := &rateLimiter{perNetwork: cfg.PerNetwork, networks: map[string]*tokenBucket{}, hostNets: map[string]string{}}
+	if cfg.Global > 0 {
+		l.global = newTokenBucket(cfg.Global)
+	}
+	return l
+}
+
+// admit takes a token for probing t at now, or returns how long to wait
+// before trying again. A nil limiter admits everything.
+func (l *rateLimiter) admit(t *target, now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	key := ""
+	if l.perNetwork > 0 && t.host != "" {
+		key = l.network(t.host)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var network *tokenBucket
+	if key != "" {
+		network = l.networks[key]
+		if network == nil {
+			network = newTokenBucket(l.perNetwork)
+			l.networks[key] = network
+		}
+	}
+	var wait time.Duration
+	if l.global != nil {
+		wait = l.global.wait(now)
+	}
+	if network != nil {
+		wait = max(wait, network.wait(now))
+	}
+	if wait > 0 {
+		return wait
+	}
+	if l.global != nil {
+		l.global.tokens--
+	}
+	if network != nil {
+		network.tokens--
+	}
+	return 0
+}
+
+// network returns the /24 or /64 a host is in. Names are resolved once;
+// unresolvable names count as a network of their own.
+func (l *rateLimiter) network(host string) string {
+	l.mu.Lock()
+	key, ok := l.hostNets[host]
+	l.mu.Unlock()
+	if ok {
+		return key
+	}
+
+	key = host
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+			i := slices.IndexFunc(ips, func(ip net.IP) bool { return ip.To4() != nil })
+			ip = ips[max(i, 0)]
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		key = ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	} else if ip != nil {
+		key = ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+
+	l.mu.Lock()
+	l.hostNets[host] = key
+	l.mu.Unlock()
+	return key
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPaces(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{Global: 10})
+	host := &target{name: "192.0.2.1", kind: "icmp", host: "192.0.2.1"}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A rate of 10 per second bursts one probe, then paces them 100ms
+	// apart by the time admit is given.
+	if wait := l.admit(host, now); wait != 0 {
+		t.Fatalf("first probe waits %v", wait)
+	}
+	if wait := l.admit(host, now); wait != 100*time.Millisecond {
+		t.Fatalf("second probe waits %v, want 100ms", wait)
+	}
+	if wait := l.admit(host, now.Add(50*time.Millisecond)); wait != 50*time.Millisecond {
+		t.Fatalf("probe 50ms later waits %v, want 50ms", wait)
+	}
+	if wait := l.admit(host, now.Add(100*time.Millisecond)); wait != 0 {
+		t.Fatalf("probe 100ms later waits %v", wait)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal client for the few commands shared state
+// needs. It keeps one connection, made on first use and again after an
+// error, and runs one command at a time.
+type redisClient struct {
+	addr     string
+	user     string
+	password string
+	db       string
+	tls      bool
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// newRedisClient takes a URL such as redis://:secret@redis.lan:6379/2,
+// or rediss:// for TLS.
+func newRedisClient(raw string) (*redisClient, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q", raw)
+	}
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss", db: strings.Trim(u.Path, "/")}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if c.db != "" {
+		if _, err := strconv.Atoi(c.db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", c.db)
+		}
+	}
+	return c, nil
+}
+
+// do runs a command and returns its reply: a string, an int64, nil or a
+// []any of those.
+func (c *redisClient) do(args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.roundTrip(args...)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			c.conn.Close()
+			c.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (c *redisClient) connect() error {
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if c.tls {
+		host, _, _ := net.SplitHostPort(c.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		tlsConn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.password != "" {
+		if c.user != "" {
+			setup = append(setup, []string{"AUTH", c.user, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != "" {
+		setup = append(setup, []string{"SELECT", c.db})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args...); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(args ...string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return readRESP(c.r)
+}
+
+// readRESP reads one reply in the Redis serialization protocol.
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > 512<<20 {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > 1<<20 {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
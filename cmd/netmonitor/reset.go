@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// reset clears the counters and latency statistics of a host, lifetime and
+// windowed, to start measuring afresh after a fix. Its state, last error
+// and history are kept.
+func (h *hostStats) reset(now time.Time) {
+	h.update(func(s *PingStats) {
+		s.PacketsSent, s.PacketsRecv, s.PacketLoss = 0, 0, 0
+		s.AvgLatency, s.Jitter = 0, 0
+		s.MinLatency, s.MaxLatency = -1, -1
+		s.Windows = nil
+		s.StatsSince = now
+		// The probe of the host drops its windows when it next sees this
+		// change, under the same lock.
+		h.resets.Add(1)
+	})
+}
+
+// handleHostReset serves POST /api/hosts/{host}/reset. Targets with
+// slashes in them are escaped, such as dns:%2F%2F1.1.1.1%2Fexample.com.
+func (m *Monitor) handleHostReset(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("host")
+	h, ok := m.hosts().stats[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown host")
+		return
+	}
+	h.reset(m.clock.Now())
+	m.auditRequest(r, "host.reset", name, nil, nil)
+	stats, _ := m.statsOf(name)
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleHostsReset serves POST /api/hosts/reset, resetting every host or
+// those matching the ?status= and ?tag= filters of /api/stats.
+func (m *Monitor) handleHostsReset(w http.ResponseWriter, r *http.Request) {
+	sq, err := parseStatsQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sq.offset, sq.limit = 0, 0
+	matched, _ := sq.filter(m.GetStats())
+	hs := m.hosts()
+	now := m.clock.Now()
+	target := "all hosts"
+	if q := r.URL.RawQuery; q != "" {
+		target = q
+	}
+	for _, s := range matched {
+		if h, ok := hs.stats[s.Host]; ok {
+			h.reset(now)
+		}
+	}
+	m.auditRequest(r, "host.reset", target, nil, map[string]int{"hosts": len(matched)})
+	stats := make([]PingStats, 0, len(matched))
+	for _, s := range matched {
+		if s, ok := m.statsOf(s.Host); ok {
+			stats = append(stats, s)
+		}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
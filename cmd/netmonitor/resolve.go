@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// defaultResolveTTL is how long a resolved address is used before the
+// name is looked up again, unless resolve_ttl says otherwise.
+const defaultResolveTTL = 5 * time.Minute
+
+// resolvedAddr caches the address a target's hostname resolved to.
+type resolvedAddr struct {
+	mu      sync.Mutex
+	addr    netip.Addr
+	expires time.Time
+}
+
+// resolve returns the address probes of the target go to, looking the
+// hostname up again once the last answer is older than the target's
+// resolve TTL. ICMP is IPv4 only; other probes prefer IPv4 when the name
+// has both. Targets without a host resolve to the zero address.
+func (t *target) resolve() (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(t.host); err == nil {
+		return addr.Unmap(), nil
+	}
+	if t.host == "" {
+		return netip.Addr{}, nil
+	}
+	r := &t.resolved
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.addr.IsValid() && time.Now().Before(r.expires) {
+		return r.addr, nil
+	}
+
+	network := "ip"
+	if t.kind == "icmp" {
+		network = "ip4"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, t.host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr := addrs[0].Unmap()
+	for _, a := range addrs {
+		if a.Unmap().Is4() {
+			addr = a.Unmap()
+			break
+		}
+	}
+	r.addr, r.expires = addr, time.Now().Add(t.resolveTTL)
+	return addr, nil
+}
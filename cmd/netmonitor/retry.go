@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"time"
+)
+
+// RetryPolicy is how hard a probe cycle tries a host before counting it
+// lost: up to Attempts probes, each given Timeout, Delay apart. Warnings
+// are not retried; only failures are.
+type RetryPolicy struct {
+	Attempts int
+	Timeout  time.Duration
+	Delay    time.Duration
+}
+
+// defaultRetryPolicy is a single try, as before retries existed.
+var defaultRetryPolicy = RetryPolicy{Attempts: 1, Timeout: probeTimeout}
+
+// RetryConfig overrides some of the retry policy, for every host at the
+// top level of the config file or for a group or a single host:
+//
+//	retry:
+//	  attempts: 3
+//	  timeout: 1s
+//	  delay: 1s
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Delay    time.Duration `yaml:"delay"`
+}
+
+// withTimeout returns c with timeout, set by the timeout key next to it,
+// unless c sets one itself.
+func (c RetryConfig) withTimeout(timeout time.Duration) RetryConfig {
+	c.Timeout = cmp.Or(c.Timeout, timeout)
+	return c
+}
+
+// over returns base with the configured settings replaced.
+func (c RetryConfig) over(base RetryPolicy) RetryPolicy {
+	return RetryPolicy{
+		Attempts: cmp.Or(c.Attempts, base.Attempts),
+		Timeout:  cmp.Or(c.Timeout, base.Timeout),
+		Delay:    cmp.Or(c.Delay, base.Delay),
+	}
+}
+
+func (p RetryPolicy) validate() error {
+	if p.Attempts < 1 {
+		return errors.New("retry attempts must be at least 1")
+	}
+	if p.Timeout <= 0 || p.Delay < 0 {
+		return errors.New("retry timeout must be positive and delay not negative")
+	}
+	return nil
+}
+
+// duration is the longest a probe cycle can take.
+func (p RetryPolicy) duration() time.Duration {
+	return time.Duration(p.Attempts)*p.Timeout + time.Duration(p.Attempts-1)*p.Delay
+}
+
+// hostRetry resolves the retry policy of a host: its own settings win,
+// then those of the first matching group, then the top level ones.
+func (cfg *Config) hostRetry(h HostConfig) (RetryPolicy, error) {
+	p := cfg.Retry.withTimeout(cfg.Timeout).over(defaultRetryPolicy)
+	for i := len(cfg.Groups) - 1; i >= 0; i-- {
+		if g := cfg.Groups[i]; g.matches(h.Tags) {
+			p = g.Retry.withTimeout(g.Timeout).over(p)
+		}
+	}
+	p = h.Retry.withTimeout(h.Timeout).over(p)
+	return p, p.validate()
+}
+
+// timeout is how long one probe of the target may take.
+func (t *target) timeout() time.Duration {
+	return cmp.Or(t.retry.Timeout, probeTimeout)
+}
+
+// probeWithRetries runs the target's probe, trying again after failures
+// as its retry policy allows.
+func probeWithRetries(t *target) (probeResult, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := probers[t.kind](t)
+		if err == nil || attempt >= t.retry.Attempts {
+			return result, err
+		}
+		telemetry.retries.Add(1)
+		time.Sleep(t.retry.Delay)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RouteConfig sends matching alerts to a set of named notifiers. Routes are
+// tried in order and the first match wins unless it sets continue. When a
+// config file defines routes, alerts that match none of them are dropped;
+// without routes every notifier receives every alert.
+//
+//	routes:
+//	  - tags: [prod]
+//	    severity: critical
+//	    days: [mon, tue, wed, thu, fri]
+//	    hours: "09:00-18:00"
+//	    timezone: Europe/Berlin
+//	    notifiers: [team-chat]
+//	  - tags: [prod]
+//	    severity: critical
+//	    notifiers: [pagerduty]
+type RouteConfig struct {
+	Hosts     []string `yaml:"hosts"`
+	Tags      []string `yaml:"tags"`
+	Severity  string   `yaml:"severity"`
+	Days      []string `yaml:"days"`
+	Hours     string   `yaml:"hours"`
+	Timezone  string   `yaml:"timezone"`
+	Notifiers []string `yaml:"notifiers"`
+	Continue  bool     `yaml:"continue"`
+
+	// Escalation names the escalation policy of the incidents routed
+	// here, if any.
+	Escalation string `yaml:"escalation"`
+}
+
+// route is a RouteConfig with its names resolved and times parsed.
+type route struct {
+	hosts       []string
+	tags        []string
+	minSeverity string
+	timeWindow
+	notifiers  []*namedNotifier
+	escalation *escalation
+	cont       bool
+}
+
+// timeWindow is a daily span of hours on some days of the week, in a time
+// zone, such as 09:00-18:00 on weekdays in Europe/Berlin. Routes and probe
+// schedules use it.
+type timeWindow struct {
+	days       map[time.Weekday]bool // nil means every day
+	start, end int                   // minutes after midnight, end exclusive; equal means all day
+	location   *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// newRoute validates a route and looks up its notifiers and escalation
+// policy by name.
+func newRoute(rc RouteConfig, notifiers []*namedNotifier, escalations []*escalation) (*route, error) {
+	r := &route{
+		hosts:       rc.Hosts,
+		tags:        rc.Tags,
+		minSeverity: rc.Severity,
+		cont:        rc.Continue,
+	}
+	if r.minSeverity == "" {
+		r.minSeverity = severityInfo
+	}
+	if _, ok := severityRank[r.minSeverity]; !ok {
+		return nil, fmt.Errorf("unknown severity %q", rc.Severity)
+	}
+	var err error
+	if r.timeWindow, err = parseTimeWindow(rc.Days, rc.Hours, rc.Timezone); err != nil {
+		return nil, err
+	}
+
+	if len(rc.Notifiers) == 0 && rc.Escalation == "" {
+		return nil, fmt.Errorf("route has no notifiers")
+	}
+	if r.notifiers, err = lookupNotifiers(rc.Notifiers, notifiers); err != nil {
+		return nil, err
+	}
+	if rc.Escalation != "" {
+		i := slices.IndexFunc(escalations, func(e *escalation) bool { return e.name == rc.Escalation })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown escalation %q", rc.Escalation)
+		}
+		r.escalation = escalations[i]
+	}
+	return r, nil
+}
+
+// lookupNotifiers finds notifiers by name.
+func lookupNotifiers(names []string, notifiers []*namedNotifier) ([]*namedNotifier, error) {
+	var found []*namedNotifier
+	for _, name := range names {
+		i := slices.IndexFunc(notifiers, func(n *namedNotifier) bool { return n.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown notifier %q", name)
+		}
+		found = append(found, notifiers[i])
+	}
+	return found, nil
+}
+
+// parseTimeWindow parses days such as [mon, tue], hours such as
+// "09:00-18:00" and the name of a time zone, the local one if empty.
+func parseTimeWindow(days []string, hours, timezone string) (timeWindow, error) {
+	w := timeWindow{location: time.Local}
+	if len(days) > 0 {
+		w.days = map[time.Weekday]bool{}
+		for _, day := range days {
+			wd, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+			if !ok {
+				return w, fmt.Errorf("unknown day %q", day)
+			}
+			w.days[wd] = true
+		}
+	}
+
+	if hours != "" {
+		from, to, ok := strings.Cut(hours, "-")
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil {
+			return w, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", hours)
+		}
+		w.start, w.end = start, end
+	}
+
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return w, err
+		}
+		w.location = loc
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// matches reports whether the route applies to a at its time.
+func (r *route) matches(a Alert) bool {
+	if len(r.hosts) > 0 && !slices.Contains(r.hosts, a.Host) {
+		return false
+	}
+	if len(r.tags) > 0 && !slices.ContainsFunc(r.tags, func(tag string) bool {
+		return slices.Contains(a.Stats.Tags, tag)
+	}) {
+		return false
+	}
+	if severityRank[a.Severity] < severityRank[r.minSeverity] {
+		return false
+	}
+	return r.activeAt(a.Time)
+}
+
+// activeAt checks the day and hour window. A window such as 18:00-08:00
+// wraps past midnight and belongs to the day it starts on.
+func (w timeWindow) activeAt(t time.Time) bool {
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.start != w.end {
+		switch {
+		case w.start < w.end:
+			if minute < w.start || minute >= w.end {
+				return false
+			}
+		case minute < w.end:
+			// Early morning part of a window that started yesterday.
+			day = (day + 6) % 7
+		case minute < w.start:
+			return false
+		}
+	}
+	return w.days == nil || w.days[day]
+}
+
+// routeAlert returns the notifiers that should receive a. An alert that
+// opens an incident starts the escalation policies of its routes, and every
+// alert of the incident also goes to the steps they have reached.
+func (m *Monitor) routeAlert(a Alert) []*namedNotifier {
+	if len(m.routes) == 0 {
+		return m.notifiers
+	}
+	var targets []*namedNotifier
+	var policies []*escalation
+	for _, r := range m.routes {
+		if !r.matches(a) {
+			continue
+		}
+		targets = appendUnique(targets, r.notifiers...)
+		if r.escalation != nil && !slices.Contains(policies, r.escalation) {
+			policies = append(policies, r.escalation)
+		}
+		if !r.cont {
+			break
+		}
+	}
+	if a.IncidentID != 0 {
+		targets = appendUnique(targets, m.incidents.escalate(a.IncidentID, policies)...)
+	}
+	return targets
+}
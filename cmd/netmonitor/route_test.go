@@ -0,0 +1,130 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeWindowActiveAt(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 2026-01-07 is a Wednesday.
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 1, day, hour, minute, 0, 0, berlin) }
+	tests := []struct {
+		name  string
+		days  []string
+		hours string
+		at    time.Time
+		want  bool
+	}{
+		{"office hours", []string{"mon", "tue", "wed", "thu", "fri"}, "09:00-18:00", at(7, 10, 0), true},
+		{"at the start", []string{"mon", "tue", "wed", "thu", "fri"}, "09:00-18:00", at(7, 9, 0), true},
+		{"at the end", []string{"mon", "tue", "wed", "thu", "fri"}, "09:00-18:00", at(7, 18, 0), false},
+		{"weekend", []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"}, "09:00-18:00", at(10, 10, 0), false},
+		{"every day", nil, "09:00-18:00", at(10, 10, 0), true},
+		{"all day", []string{"sat", "sun"}, "", at(10, 23, 59), true},
+		{"night of the day", []string{"fri"}, "18:00-08:00", at(9, 23, 0), true},
+		{"morning after", []string{"fri"}, "18:00-08:00", at(10, 7, 59), true},
+		{"morning before", []string{"fri"}, "18:00-08:00", at(9, 7, 0), false},
+		{"between", []string{"fri"}, "18:00-08:00", at(9, 12, 0), false},
+		{"in another zone", nil, "09:00-18:00", time.Date(2026, 1, 7, 8, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		w, err := parseTimeWindow(tt.days, tt.hours, "Europe/Berlin")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := w.activeAt(tt.at); got != tt.want {
+			t.Errorf("%s: active at %v: %v, want %v", tt.name, tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestNewRoute(t *testing.T) {
+	notifiers := []*namedNotifier{{name: "chat"}, {name: "pager"}}
+	tests := []struct {
+		name string
+		rc   RouteConfig
+		err  string
+	}{
+		{"ok", RouteConfig{Tags: []string{"prod"}, Days: []string{"mon"}, Hours: "09:00-17:30", Timezone: "UTC", Notifiers: []string{"chat", "pager"}}, ""},
+		{"no notifiers", RouteConfig{Tags: []string{"prod"}}, "no notifiers"},
+		{"unknown notifier", RouteConfig{Notifiers: []string{"email"}}, `unknown notifier "email"`},
+		{"unknown severity", RouteConfig{Severity: "urgent", Notifiers: []string{"chat"}}, `unknown severity "urgent"`},
+		{"unknown escalation", RouteConfig{Escalation: "night"}, `unknown escalation "night"`},
+		{"unknown day", RouteConfig{Days: []string{"someday"}, Notifiers: []string{"chat"}}, `unknown day "someday"`},
+		{"open hours", RouteConfig{Hours: "09:00", Notifiers: []string{"chat"}}, "invalid hours"},
+		{"bad hours", RouteConfig{Hours: "9am-5pm", Notifiers: []string{"chat"}}, "invalid hours"},
+		{"unknown zone", RouteConfig{Timezone: "Mars/Olympus", Notifiers: []string{"chat"}}, "Mars/Olympus"},
+	}
+	for _, tt := range tests {
+		r, err := newRoute(tt.rc, notifiers, nil)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || len(r.notifiers) != 2 || r.minSeverity != severityInfo || r.start != 9*60 || r.end != 17*60+30 {
+			t.Errorf("%s: %+v, %v", tt.name, r, err)
+		}
+	}
+}
+
+func TestRouteAlert(t *testing.T) {
+	var notifiers []*namedNotifier
+	for _, name := range []string{"dba", "chat", "pager", "email"} {
+		notifiers = append(notifiers, &namedNotifier{name: name})
+	}
+	m := NewMonitor(nil, 0, time.Minute)
+	m.notifiers = notifiers
+	for _, rc := range []RouteConfig{
+		{Hosts: []string{"db1"}, Notifiers: []string{"dba"}, Continue: true},
+		{Tags: []string{"prod"}, Severity: severityCritical, Days: []string{"mon", "tue", "wed", "thu", "fri"}, Hours: "09:00-18:00", Timezone: "UTC", Notifiers: []string{"chat"}},
+		{Tags: []string{"prod"}, Severity: severityCritical, Notifiers: []string{"pager"}},
+		{Severity: severityWarning, Notifiers: []string{"email"}},
+	} {
+		r, err := newRoute(rc, notifiers, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.routes = append(m.routes, r)
+	}
+
+	wednesday := time.Date(2026, 1, 7, 10, 0, 0, 0, time.UTC)
+	saturday := time.Date(2026, 1, 10, 10, 0, 0, 0, time.UTC)
+	alert := func(host, tag, severity string, at time.Time) Alert {
+		return Alert{Host: host, Severity: severity, Time: at, Stats: PingStats{Host: host, Tags: []string{tag}}}
+	}
+	tests := []struct {
+		name  string
+		alert Alert
+		want  []string
+	}{
+		{"office hours", alert("web1", "prod", severityCritical, wednesday), []string{"chat"}},
+		{"weekend", alert("web1", "prod", severityCritical, saturday), []string{"pager"}},
+		{"warning", alert("web1", "prod", severityWarning, wednesday), []string{"email"}},
+		{"database", alert("db1", "prod", severityCritical, wednesday), []string{"dba", "chat"}},
+		{"database info", alert("db1", "prod", severityInfo, wednesday), []string{"dba"}},
+		{"staging", alert("web2", "staging", severityCritical, wednesday), []string{"email"}},
+		{"unrouted", alert("web2", "staging", severityInfo, wednesday), nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, n := range m.routeAlert(tt.alert) {
+			got = append(got, n.name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sent to %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	m.routes = nil
+	if got := m.routeAlert(alert("web2", "staging", severityInfo, wednesday)); len(got) != len(notifiers) {
+		t.Errorf("without routes: sent to %d notifiers, want all %d", len(got), len(notifiers))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// objectStore is where files such as exports and archives are kept: a
+// local directory or a bucket. Keys are slash-separated paths; get returns
+// an error wrapping os.ErrNotExist for a missing object.
+type objectStore interface {
+	put(key string, data []byte) error
+	get(key string) ([]byte, error)
+	String() string
+}
+
+// newObjectStore takes a directory or an s3:// URL.
+func newObjectStore(dest string) (objectStore, error) {
+	if strings.HasPrefix(dest, "s3://") {
+		return newS3Bucket(dest)
+	}
+	if dest == "" {
+		return nil, fmt.Errorf("no directory or bucket")
+	}
+	return dirStore(dest), nil
+}
+
+// dirStore keeps objects as files below a directory.
+type dirStore string
+
+func (d dirStore) put(key string, data []byte) error {
+	path := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d dirStore) get(key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+func (d dirStore) String() string { return string(d) }
+
+// s3Bucket keeps objects in an S3 bucket, or one of a compatible store such
+// as MinIO or Ceph given by its endpoint:
+//
+//	s3://analytics/netmonitor?region=eu-central-1
+//	s3://netmonitor?endpoint=https://minio.lan:9000
+//
+// Keys start with the path of the URL. Credentials are found as for the
+// aws discoverer, with the profile parameter choosing the profile.
+type s3Bucket struct {
+	awsCredentialSource
+	bucket, prefix, region string
+	endpoint               *url.URL // nil for AWS itself
+}
+
+func newS3Bucket(raw string) (*s3Bucket, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid bucket URL %q", raw)
+	}
+	q := u.Query()
+	b := &s3Bucket{
+		awsCredentialSource: newAWSCredentialSource(q.Get("profile")),
+		bucket:              u.Host,
+		prefix:              strings.Trim(u.Path, "/"),
+		region:              cmp.Or(q.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+	}
+	if e := q.Get("endpoint"); e != "" {
+		if b.endpoint, err = url.Parse(e); err != nil || b.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", e)
+		}
+	}
+	return b, nil
+}
+
+func (b *s3Bucket) String() string {
+	return strings.TrimSuffix("s3://"+b.bucket+"/"+b.prefix, "/")
+}
+
+// url returns the URL of an object: path-style for a custom endpoint, as
+// compatible stores expect, and virtual-hosted for AWS.
+func (b *s3Bucket) url(key string) *url.URL {
+	if b.prefix != "" {
+		key = b.prefix + "/" + key
+	}
+	u := &url.URL{Scheme: "https", Host: b.bucket + ".s3." + b.region + ".amazonaws.com", Path: "/" + key}
+	if b.endpoint != nil {
+		u = &url.URL{Scheme: b.endpoint.Scheme, Host: b.endpoint.Host, Path: "/" + b.bucket + "/" + key}
+	}
+	// Signatures are over the path with everything but unreserved
+	// characters escaped, which is stricter than Go.
+	u.RawPath = awsEscapePath(u.Path)
+	return u
+}
+
+func (b *s3Bucket) put(key string, data []byte) error {
+	resp, err := b.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *s3Bucket) get(key string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// do sends a signed request for an object and returns the response if it
+// succeeded.
+func (b *s3Bucket) do(method, key string, body []byte) (*http.Response, error) {
+	creds, err := b.credentials()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, b.url(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	awsSign(req, body, creds, b.region, "s3", time.Now())
+	resp, err := cloudClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		err := fmt.Errorf("%s %s: %s %s: %s", method, b.String()+"/"+key, resp.Status, e.Code, e.Message)
+		if resp.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %v", os.ErrNotExist, err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// awsEscapePath escapes a path the way Signature Version 4 expects.
+func awsEscapePath(p string) string {
+	var sb strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
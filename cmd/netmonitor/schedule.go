@@ -0,0 +1,65 @@
+package main
+
+import "fmt"
+
+// ScheduleConfig limits when a host is probed, for equipment that is
+// switched off outside office hours and should not count as down then:
+//
+//	schedule:
+//	  days: [mon, tue, wed, thu, fri]
+//	  hours: "07:00-19:00"
+//	  timezone: Europe/Berlin
+//
+// Days and hours work like those of routes. Outside its schedule a host is
+// not probed, so it neither alerts nor counts losses.
+type ScheduleConfig struct {
+	Days     []string `yaml:"days"`
+	Hours    string   `yaml:"hours"`
+	Timezone string   `yaml:"timezone"`
+}
+
+func (c ScheduleConfig) isSet() bool {
+	return len(c.Days) > 0 || c.Hours != "" || c.Timezone != ""
+}
+
+// hostSchedule resolves the schedule of a host, nil to probe it all the
+// time. A schedule is taken as a whole: the host's own, else that of the
+// first matching group, else the top level one.
+func (cfg *Config) hostSchedule(h HostConfig) (*timeWindow, error) {
+	sc := cfg.Schedule
+	for _, g := range cfg.Groups {
+		if g.matches(h.Tags) && g.Schedule.isSet() {
+			sc = g.Schedule
+			break
+		}
+	}
+	if h.Schedule.isSet() {
+		sc = h.Schedule
+	}
+	if !sc.isSet() {
+		return nil, nil
+	}
+	w, err := parseTimeWindow(sc.Days, sc.Hours, sc.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %v", err)
+	}
+	return &w, nil
+}
+
+// onSchedule reports whether a host is due for probing by its schedule,
+// marking it off schedule on the dashboard while it is not.
+func (m *Monitor) onSchedule(h *hostState) bool {
+	if h.t.schedule == nil {
+		return true
+	}
+	now := m.clock.Now()
+	on := h.t.schedule.activeAt(now)
+	if h.stats.load().OffSchedule == on {
+		h.stats.update(func(s *PingStats) { s.OffSchedule = !on })
+	}
+	if !on {
+		// Not probing is what the host should be doing, not a stall.
+		h.stats.lastRun.Store(now.UnixNano())
+	}
+	return on
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostSchedule(t *testing.T) {
+	weekdays := ScheduleConfig{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Timezone: "UTC"}
+	nights := ScheduleConfig{Hours: "22:00-06:00", Timezone: "UTC"}
+	cfg := &Config{
+		Schedule: ScheduleConfig{Hours: "07:00-19:00", Timezone: "UTC"},
+		Groups: []GroupConfig{
+			{Tags: []string{"office"}, Schedule: weekdays},
+			{Tags: []string{"office", "backup"}, Schedule: nights},
+			{Tags: []string{"lab"}},
+		},
+	}
+	// 2026-01-10 is a Saturday.
+	saturdayNoon := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	saturdayNight := time.Date(2026, 1, 10, 23, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		host  HostConfig
+		noon  bool
+		night bool
+		err   string
+	}{
+		{"top level", HostConfig{Target: "192.0.2.1"}, true, false, ""},
+		{"first group", HostConfig{Target: "192.0.2.1", Tags: []string{"backup", "office"}}, false, false, ""},
+		{"second group", HostConfig{Target: "192.0.2.1", Tags: []string{"backup"}}, false, true, ""},
+		{"group without schedule", HostConfig{Target: "192.0.2.1", Tags: []string{"lab"}}, true, false, ""},
+		{"own", HostConfig{Target: "192.0.2.1", Tags: []string{"office"}, Schedule: nights}, false, true, ""},
+		{"invalid", HostConfig{Target: "192.0.2.1", Schedule: ScheduleConfig{Hours: "7-19"}}, false, false, "schedule:"},
+	}
+	for _, tt := range tests {
+		w, err := cfg.hostSchedule(tt.host)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if w == nil || w.activeAt(saturdayNoon) != tt.noon || w.activeAt(saturdayNight) != tt.night {
+			t.Errorf("%s: %+v, want on at noon %v and at night %v", tt.name, w, tt.noon, tt.night)
+		}
+	}
+
+	// Without any schedule hosts are probed all the time.
+	if w, err := (&Config{Groups: cfg.Groups}).hostSchedule(HostConfig{Target: "192.0.2.1", Tags: []string{"lab"}}); w != nil || err != nil {
+		t.Errorf("no schedule: %+v, %v", w, err)
+	}
+}
+
+func TestOnSchedule(t *testing.T) {
+	tgt, err := parseTarget("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt.schedule, err = (&Config{Schedule: ScheduleConfig{Hours: "07:00-19:00", Timezone: "UTC"}}).hostSchedule(HostConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMonitor([]*target{tgt}, 0, time.Second)
+	clock := newFakeClock(time.Date(2026, 1, 7, 6, 0, 0, 0, time.UTC))
+	m.clock = clock
+	h := &hostState{t: tgt, stats: m.hosts().stats[tgt.name]}
+
+	if m.onSchedule(h) {
+		t.Error("probed before hours")
+	}
+	if s := h.stats.load(); !s.OffSchedule {
+		t.Errorf("before hours: %+v", s)
+	}
+	if last := h.stats.lastRun.Load(); last != clock.Now().UnixNano() {
+		t.Errorf("last run %v, want now so the host does not look stalled", time.Unix(0, last))
+	}
+
+	clock.advance(2 * time.Hour)
+	if !m.onSchedule(h) || h.stats.load().OffSchedule {
+		t.Errorf("during hours: %+v", h.stats.load())
+	}
+
+	tgt.schedule = nil
+	clock.advance(12 * time.Hour)
+	if !m.onSchedule(h) {
+		t.Error("host without a schedule not probed")
+	}
+}
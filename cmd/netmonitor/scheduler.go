@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Probes are scheduled on a hashed timer wheel and run by a fixed pool of
+// workers, so goroutines and open sockets stay bounded no matter how many
+// hosts are monitored. Each host is rescheduled once its probe finishes,
+// which keeps at most one probe per host queued or running.
+
+const (
+	wheelTick  = 10 * time.Millisecond
+	wheelSlots = 1024
+
+	// defaultWorkers bounds concurrent probes. A probe can wait up to
+	// probeTimeout, so this sustains defaultWorkers/probeTimeout probes
+	// per second even when every host is down.
+	defaultWorkers = 256
+)
+
+// hostState is what a host's probes carry over from one run to the next.
+type hostState struct {
+	t           *target
+	stats       *hostStats
+	due         time.Time
+	rounds      int // wheel revolutions left before due
+	lastLatency float64
+	windows     windows // created on the first probe
+	resets      uint64  // of stats, as of the last probe
+	statusSince time.Time
+	anomalous   bool   // the last probe was worth a packet capture
+	unconfirmed *Alert // a down alert held until a quorum agrees
+
+	// latencyLevel and lossLevel rate the host by its thresholds, for
+	// threshold_crossed events.
+	latencyLevel, lossLevel string
+}
+
+// timerWheel hands hosts to the workers when they are due.
+type timerWheel struct {
+	mu    sync.Mutex
+	slots [wheelSlots][]*hostState
+	pos   int
+	now   time.Time // time of slot pos
+	clock Clock
+
+	due chan *hostState
+}
+
+func newTimerWheel(clock Clock, capacity int) *timerWheel {
+	return &timerWheel{now: clock.Now(), clock: clock, due: make(chan *hostState, capacity)}
+}
+
+// add schedules h at h.due.
+func (w *timerWheel) add(h *hostState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ticks := int((h.due.Sub(w.now) + wheelTick - 1) / wheelTick)
+	ticks = max(ticks, 1)
+	h.rounds = (ticks - 1) / wheelSlots
+	slot := (w.pos + ticks) % wheelSlots
+	w.slots[slot] = append(w.slots[slot], h)
+}
+
+// run advances the wheel with its clock, catching up on ticks missed
+// while the process was descheduled.
+func (w *timerWheel) run() {
+	ticks, stop := w.clock.NewTicker(wheelTick)
+	defer stop()
+	for now := range ticks {
+		w.mu.Lock()
+		var fired []*hostState
+		for !w.now.Add(wheelTick).After(now) {
+			w.now = w.now.Add(wheelTick)
+			w.pos = (w.pos + 1) % wheelSlots
+			pending := w.slots[w.pos][:0]
+			for _, h := range w.slots[w.pos] {
+				if h.rounds > 0 {
+					h.rounds--
+					pending = append(pending, h)
+				} else {
+					fired = append(fired, h)
+				}
+			}
+			clear(w.slots[w.pos][len(pending):])
+			w.slots[w.pos] = pending
+		}
+		w.mu.Unlock()
+		// due holds every host monitored at startup, so this only waits
+		// for workers when many have been discovered since.
+		for _, h := range fired {
+			w.due <- h
+		}
+	}
+}
+
+// schedule starts the wheel and workers. First probes are spread evenly
+// over one interval instead of all firing at once.
+func (m *Monitor) schedule(workers int) {
+	m.hostsMu.Lock()
+	hs := m.hosts()
+	wheel := newTimerWheel(m.clock, len(hs.targets))
+	start := m.clock.Now()
+	for i, t := range hs.targets {
+		offset := m.interval * time.Duration(i) / time.Duration(len(hs.targets))
+		wheel.add(&hostState{t: t, stats: hs.stats[t.name], due: start.Add(m.interval + offset), statusSince: start})
+	}
+	m.wheel = wheel
+	m.hostsMu.Unlock()
+	go wheel.run()
+
+	for range workers {
+		go func() {
+			for h := range wheel.due {
+				// Hosts that are no longer monitored drop out here.
+				if m.hosts().stats[h.t.name] != h.stats {
+					continue
+				}
+				// Hosts over the rate limit go back on the wheel rather
+				// than holding up a worker. Paused ones keep their place
+				// on it without being probed.
+				if !h.stats.load().Paused && m.onSchedule(h) {
+					if wait := m.limiter.admit(h.t, m.clock.Now()); wait > 0 {
+						h.due = m.clock.Now().Add(wait)
+						wheel.add(h)
+						continue
+					}
+					m.probeOnce(h)
+				}
+				// Skip runs that were missed while the probe was slow or
+				// queued, like a ticker does. They are not counted as sent
+				// or lost: a slow answer is one probe, not several.
+				for h.due = h.due.Add(m.interval); !h.due.After(m.clock.Now()); {
+					h.due = h.due.Add(m.interval)
+					telemetry.skippedRuns.Add(1)
+				}
+				wheel.add(h)
+			}
+		}()
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when advanced, ticking every
+// ticker it handed out.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker ticks when the clock is advanced, however far, dropping ticks
+// a slow reader misses like time.Ticker does.
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.tickers = append(c.tickers, ch)
+	return ch, func() {}
+}
+
+// waitTickers waits until n tickers were handed out, so the goroutines
+// reading them see the next advance.
+func (c *fakeClock) waitTickers(n int) {
+	for {
+		c.mu.Lock()
+		ready := len(c.tickers) >= n
+		c.mu.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now, tickers := c.now, c.tickers
+	c.mu.Unlock()
+	for _, ch := range tickers {
+		select {
+		case ch <- now:
+		default:
+		}
+	}
+}
+
+// countingProber answers every probe at once and counts them.
+type countingProber struct {
+	probes atomic.Int64
+}
+
+func (p *countingProber) Probe(t *target) (probeResult, error) {
+	p.probes.Add(1)
+	return probeResult{Latency: 1}, nil
+}
+
+// wheelSize returns how many hosts wait on the wheel.
+func wheelSize(w *timerWheel) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, slot := range w.slots {
+		n += len(slot)
+	}
+	return n
+}
+
+// openSockets counts the sockets the process has open, or returns -1
+// where /proc does not tell.
+func openSockets() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	n := 0
+	for _, fd := range fds {
+		if link, err := os.Readlink("/proc/self/fd/" + fd.Name()); err == nil && strings.HasPrefix(link, "socket:") {
+			n++
+		}
+	}
+	return n
+}
+
+// BenchmarkScheduler10k runs one interval of probes of 10,000 hosts per
+// iteration through the timer wheel and worker pool, and reports the
+// goroutines and sockets that took.
+func BenchmarkScheduler10k(b *testing.B) {
+	const hosts = 10000
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	targets := make([]*target, hosts)
+	for i := range targets {
+		t, err := parseTarget(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff))
+		if err != nil {
+			b.Fatal(err)
+		}
+		targets[i] = t
+	}
+	clock := newFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	prober := &countingProber{}
+	m := NewMonitor(targets, 0, time.Second)
+	m.clock, m.prober = clock, prober
+
+	goroutines := runtime.NumGoroutine()
+	m.Start()
+	clock.waitTickers(1)
+	// The first probes are spread over the interval after the first.
+	clock.advance(time.Second)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		clock.advance(time.Second)
+		// Wait for the workers to put every host back on the wheel, or
+		// those still out would miss the next tick.
+		for want := int64(hosts * (i + 1)); prober.probes.Load() < want || wheelSize(m.wheel) < hosts; {
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(runtime.NumGoroutine()-goroutines), "goroutines")
+	if n := openSockets(); n >= 0 {
+		b.ReportMetric(float64(n), "sockets")
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*hosts), "ns/probe")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// telemetry counts what the monitor itself is doing, for watching the
+// watcher at /api/self and /metrics.
+var telemetry struct {
+	probes         atomic.Uint64 // probes finished
+	probeErrors    atomic.Uint64 // probes that failed
+	retries        atomic.Uint64 // probes tried again after a failure
+	skippedRuns    atomic.Uint64 // runs skipped because the previous probe of the host was still going
+	inFlight       atomic.Int64  // probes running right now
+	droppedReplies atomic.Uint64 // ICMP replies nobody was waiting for
+	badReplies     atomic.Uint64 // malformed ICMP messages
+	socketErrors   atomic.Uint64 // failed reads and writes on raw sockets
+	exportDropped  atomic.Uint64 // events dropped by full exporter queues
+
+	probeRate atomic.Uint64 // probes per second over the last rateWindow, as float bits
+}
+
+const rateWindow = 10 * time.Second
+
+// measureProbeRate keeps telemetry.probeRate current.
+func measureProbeRate() {
+	last := telemetry.probes.Load()
+	for range time.Tick(rateWindow) {
+		now := telemetry.probes.Load()
+		telemetry.probeRate.Store(math.Float64bits(float64(now-last) / rateWindow.Seconds()))
+		last = now
+	}
+}
+
+// Self is the body of /api/self.
+type Self struct {
+	Uptime          float64 `json:"uptimeSeconds"`
+	Goroutines      int     `json:"goroutines"`
+	HeapBytes       uint64  `json:"heapBytes"`
+	SysBytes        uint64  `json:"sysBytes"`
+	GCRuns          uint32  `json:"gcRuns"`
+	Hosts           int     `json:"hosts"`
+	ProbesInFlight  int64   `json:"probesInFlight"`
+	ProbesTotal     uint64  `json:"probesTotal"`
+	ProbesPerSecond float64 `json:"probesPerSecond"`
+	ProbeErrors     uint64  `json:"probeErrors"`
+	Retries         uint64  `json:"retries"`
+	SkippedRuns     uint64  `json:"skippedRuns"`
+	DroppedReplies  uint64  `json:"droppedReplies"`
+	BadReplies      uint64  `json:"badReplies"`
+	SocketErrors    uint64  `json:"socketErrors"`
+	ExportDropped   uint64  `json:"exportDropped"`
+	HistorySamples  int     `json:"historySamples"`
+	HistoryBytes    int     `json:"historyBytes"`
+
+	// HA is the high availability state, when configured.
+	HA *HAStatus `json:"ha,omitempty"`
+}
+
+func (m *Monitor) self() Self {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	m.mu.RLock()
+	started := m.started
+	m.mu.RUnlock()
+	s := Self{
+		Goroutines:      runtime.NumGoroutine(),
+		HeapBytes:       mem.HeapAlloc,
+		SysBytes:        mem.Sys,
+		GCRuns:          mem.NumGC,
+		Hosts:           len(m.hosts().targets),
+		ProbesInFlight:  telemetry.inFlight.Load(),
+		ProbesTotal:     telemetry.probes.Load(),
+		ProbesPerSecond: math.Float64frombits(telemetry.probeRate.Load()),
+		ProbeErrors:     telemetry.probeErrors.Load(),
+		Retries:         telemetry.retries.Load(),
+		SkippedRuns:     telemetry.skippedRuns.Load(),
+		DroppedReplies:  telemetry.droppedReplies.Load(),
+		BadReplies:      telemetry.badReplies.Load(),
+		SocketErrors:    telemetry.socketErrors.Load(),
+		ExportDropped:   telemetry.exportDropped.Load(),
+	}
+	s.HistorySamples, s.HistoryBytes = m.history.size()
+	if m.ha != nil {
+		s.HA = m.ha.status()
+	}
+	if !started.IsZero() {
+		s.Uptime = time.Since(started).Seconds()
+	}
+	return s
+}
+
+func (m *Monitor) handleSelf(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.self())
+}
+
+// handleMetrics serves GET /metrics in the Prometheus text format: the
+// state of every host followed by the monitor's own telemetry.
+func (m *Monitor) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := m.GetStats()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+
+	var b strings.Builder
+	hostMetric := func(name, help string, value func(PingStats) float64) {
+		fmt.Fprintf(&b, "# HELP netmonitor_%s %s\n# TYPE netmonitor_%s gauge\n", name, help, name)
+		for _, s := range stats {
+			fmt.Fprintf(&b, "netmonitor_%s{host=%s,type=%s} %g\n", name, promLabel(s.Host), promLabel(s.Type), value(s))
+		}
+	}
+	hostMetric("up", "Whether the host answered its last probe.", func(s PingStats) float64 {
+		if s.Status == "up" || s.Status == "degraded" {
+			return 1
+		}
+		return 0
+	})
+	hostMetric("latency_ms", "Latency of the last successful probe.", func(s PingStats) float64 { return s.CurrentLatency })
+	hostMetric("avg_latency_ms", "Average latency of successful probes.", func(s PingStats) float64 { return s.AvgLatency })
+	hostMetric("packet_loss_percent", "Share of failed probes.", func(s PingStats) float64 { return s.PacketLoss })
+	windowMetric := func(name, help string, value func(WindowStats) float64) {
+		fmt.Fprintf(&b, "# HELP netmonitor_window_%s %s\n# TYPE netmonitor_window_%s gauge\n", name, help, name)
+		for _, s := range stats {
+			for _, window := range slices.Sorted(maps.Keys(s.Windows)) {
+				fmt.Fprintf(&b, "netmonitor_window_%s{host=%s,type=%s,window=%s} %g\n", name, promLabel(s.Host), promLabel(s.Type), promLabel(window), value(s.Windows[window]))
+			}
+		}
+	}
+	windowMetric("avg_latency_ms", "Average latency of successful probes over the window.", func(w WindowStats) float64 { return w.AvgLatency })
+	windowMetric("packet_loss_percent", "Share of failed probes over the window.", func(w WindowStats) float64 { return w.PacketLoss })
+
+	self := m.self()
+	selfMetric := func(name, typ, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP netmonitor_self_%s %s\n# TYPE netmonitor_self_%s %s\nnetmonitor_self_%s %g\n", name, help, name, typ, name, value)
+	}
+	selfMetric("uptime_seconds", "gauge", "Time since the probes started.", self.Uptime)
+	selfMetric("goroutines", "gauge", "Number of goroutines.", float64(self.Goroutines))
+	selfMetric("heap_bytes", "gauge", "Bytes of allocated heap objects.", float64(self.HeapBytes))
+	selfMetric("sys_bytes", "gauge", "Bytes of memory obtained from the OS.", float64(self.SysBytes))
+	selfMetric("gc_runs_total", "counter", "Completed garbage collections.", float64(self.GCRuns))
+	selfMetric("probes_in_flight", "gauge", "Probes currently running.", float64(self.ProbesInFlight))
+	selfMetric("probes_total", "counter", "Probes finished.", float64(self.ProbesTotal))
+	selfMetric("probes_per_second", "gauge", "Probe rate over the last 10 seconds.", self.ProbesPerSecond)
+	selfMetric("probe_errors_total", "counter", "Probes that failed.", float64(self.ProbeErrors))
+	selfMetric("retries_total", "counter", "Probes tried again after a failure.", float64(self.Retries))
+	selfMetric("skipped_runs_total", "counter", "Probe runs skipped because the previous probe of the host was still going.", float64(self.SkippedRuns))
+	selfMetric("dropped_replies_total", "counter", "ICMP replies that arrived after their probe gave up.", float64(self.DroppedReplies))
+	selfMetric("bad_replies_total", "counter", "Malformed ICMP messages, with a bad length or checksum.", float64(self.BadReplies))
+	selfMetric("socket_errors_total", "counter", "Failed reads and writes on raw sockets.", float64(self.SocketErrors))
+	selfMetric("export_dropped_total", "counter", "Events dropped because an exporter fell behind.", float64(self.ExportDropped))
+	selfMetric("history_samples", "gauge", "Probe results kept in the history.", float64(self.HistorySamples))
+	selfMetric("history_bytes", "gauge", "Bytes the compressed history takes in memory.", float64(self.HistoryBytes))
+	if self.HA != nil {
+		leader := 0.0
+		if self.HA.Leader {
+			leader = 1
+		}
+		selfMetric("ha_leader", "gauge", "Whether this instance sends notifications.", leader)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
+
+var promReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(s string) string {
+	return `"` + promReplacer.Replace(s) + `"`
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The sim probe type makes up results instead of sending packets, for
+// demos, working on the web interface and tests without network access
+// or root. Each host gets a base latency, jitter, loss and now and then an
+// outage, drawn from a random model seeded with -seed and the host's name,
+// so the same seed gives every host the same results run after run:
+//
+//	sim://core-router?latency=12&jitter=2&loss=0.5&outages=0.002
+//
+// With -dry-run every host is simulated whatever its type.
+func init() {
+	probers["sim"] = func(t *target) (probeResult, error) {
+		return simulation.Probe(t)
+	}
+}
+
+// simulation holds the state of every simulated host.
+var simulation = newSimulator(uint64(time.Now().UnixNano()))
+
+type simulator struct {
+	seed uint64
+
+	mu    sync.Mutex
+	hosts map[string]*simHost
+}
+
+// simHost is the model of one host and where it is in it.
+type simHost struct {
+	rng     *rand.Rand
+	latency float64 // ms
+	jitter  float64 // ms
+	loss    float64 // probability of a lost probe
+	outages float64 // probability of an outage starting at a probe
+	down    int     // probes left in the current outage
+}
+
+func newSimulator(seed uint64) *simulator {
+	return &simulator{seed: seed, hosts: map[string]*simHost{}}
+}
+
+// host returns the model of a target, made up on first use. Parameters of
+// sim targets override the made-up values.
+func (s *simulator) host(t *target) (*simHost, error) {
+	if h, ok := s.hosts[t.name]; ok {
+		return h, nil
+	}
+	f := fnv.New64a()
+	f.Write([]byte(t.name))
+	rng := rand.New(rand.NewPCG(s.seed, f.Sum64()))
+	h := &simHost{
+		rng:     rng,
+		latency: 2 + rng.Float64()*rng.Float64()*120, // mostly close, some far away
+		loss:    rng.Float64() * rng.Float64() * 0.03,
+		outages: 0.002,
+	}
+	h.jitter = h.latency * (0.05 + rng.Float64()*0.15)
+	if t.kind == "sim" {
+		for name, v := range map[string]*float64{"latency": &h.latency, "jitter": &h.jitter, "loss": &h.loss, "outages": &h.outages} {
+			raw := t.param(name, "")
+			if raw == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil || f < 0 {
+				return nil, errors.New("invalid " + name + ": " + raw)
+			}
+			if name == "loss" {
+				f /= 100 // given in percent
+			}
+			*v = f
+		}
+	}
+	s.hosts[t.name] = h
+	return h, nil
+}
+
+var (
+	errSimTimeout = errors.New("simulated timeout")
+	errSimOutage  = errors.New("simulated outage")
+)
+
+// Probe makes up the result of probing t.
+func (s *simulator) Probe(t *target) (probeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.host(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	if h.down > 0 {
+		h.down--
+		return probeResult{}, errSimOutage
+	}
+	if h.rng.Float64() < h.outages {
+		h.down = 3 + h.rng.IntN(20)
+		return probeResult{}, errSimOutage
+	}
+	if h.rng.Float64() < h.loss {
+		return probeResult{}, errSimTimeout
+	}
+	// Busier in the evening, like most links.
+	hour := float64(time.Now().Hour()) + float64(time.Now().Minute())/60
+	load := 1 + 0.2*math.Sin((hour-14)/24*2*math.Pi)
+	latency := h.latency*load + math.Abs(h.rng.NormFloat64())*h.jitter
+	if h.rng.Float64() < 0.01 {
+		latency *= 3 + h.rng.Float64()*5 // a spike now and then
+	}
+	return probeResult{Latency: latency}, nil
+}
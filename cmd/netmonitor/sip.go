@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SIP targets send OPTIONS to a PBX or trunk and time the answer:
+//
+//	sip://pbx.example.com
+//	sip://trunk@sbc.example.com:5080?transport=tcp
+//	sips://sbc.example.com?rtp=40000&min_mos=3.6
+//
+// sip:// goes over UDP unless transport=tcp, sips:// over TLS. Any final
+// response is an answer: 2xx is up, other answers below 500 such as 401 or
+// 404 mean the server is alive but refuses, which marks the host degraded,
+// and 408, 5xx and 6xx take it down.
+//
+// With rtp set to the port of an RTP reflector (another netmonitor with
+// rtp_reflector set, on the same host unless given as host:port), the
+// probe then streams RTP packets through it like a call would, packets of
+// 20ms (ptime) for a second (duration), and measures loss and jitter each
+// way, which needs no synchronized clocks. Those make up the MOS, the
+// estimated call quality from 1 to about 4.4; below min_mos the host is
+// degraded.
+func init() {
+	probers["sip"] = probeSIP
+	probers["sips"] = probeSIP
+	probeDurations["sip"] = sipDuration
+	probeDurations["sips"] = sipDuration
+}
+
+func sipDuration(t *target) time.Duration {
+	d := t.timeout()
+	if t.param("rtp", "") != "" {
+		stream, _ := time.ParseDuration(t.param("duration", "1s"))
+		d += stream + 2*t.timeout()
+	}
+	return d
+}
+
+func probeSIP(t *target) (probeResult, error) {
+	minMOS, err := strconv.ParseFloat(t.param("min_mos", "0"), 64)
+	if err != nil || minMOS < 0 || minMOS > 5 {
+		return probeResult{}, fmt.Errorf("invalid min_mos %q", t.param("min_mos", ""))
+	}
+	start := time.Now()
+	code, reason, err := sipOptions(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	result := probeResult{Latency: msSince(start), Metrics: map[string]float64{"status": float64(code)}}
+	switch {
+	case code == 408 || code >= 500:
+		return probeResult{}, fmt.Errorf("answered %d %s", code, reason)
+	case code >= 300:
+		result.Warning = fmt.Sprintf("answered %d %s", code, reason)
+	}
+
+	if t.param("rtp", "") == "" {
+		return result, nil
+	}
+	s, err := streamRTP(t)
+	if err != nil {
+		return probeResult{}, fmt.Errorf("rtp: %w", err)
+	}
+	mos := s.mos()
+	result.KeepMetrics = true
+	result.Metrics["rtp_rtt_ms"] = s.rtt
+	result.Metrics["loss_out_pct"] = s.lossOut
+	result.Metrics["loss_in_pct"] = s.lossIn
+	result.Metrics["jitter_out_ms"] = s.jitterOut
+	result.Metrics["jitter_in_ms"] = s.jitterIn
+	result.Metrics["mos"] = mos
+	if mos < minMOS && result.Warning == "" {
+		result.Warning = fmt.Sprintf("MOS %.2f below %.2f", mos, minMOS)
+	}
+	return result, nil
+}
+
+// sipOptions sends an OPTIONS request and returns the final response.
+func sipOptions(t *target) (int, string, error) {
+	transport := strings.ToUpper(t.param("transport", "udp"))
+	port := "5060"
+	switch {
+	case t.kind == "sips":
+		transport, port = "TLS", "5061"
+	case transport != "UDP" && transport != "TCP":
+		return 0, "", fmt.Errorf("invalid transport %q", t.param("transport", ""))
+	}
+	network := "udp"
+	if transport != "UDP" {
+		network = "tcp"
+	}
+	conn, err := t.dial(network, t.hostPort(port))
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(t.timeout())
+	conn.SetDeadline(deadline)
+	if transport == "TLS" {
+		tlsConn := tls.Client(conn, t.tlsConfig(t.host))
+		if err := tlsConn.Handshake(); err != nil {
+			return 0, "", err
+		}
+		conn = tlsConn
+	}
+
+	local := conn.LocalAddr().String()
+	callID, branch, tag := sipToken(), "z9hG4bK"+sipToken(), sipToken()
+	uri := "sip:" + t.hostPort(port)
+	if t.kind == "sips" {
+		uri = "sips:" + t.hostPort(port)
+	}
+	if t.url.User != nil {
+		uri = strings.Replace(uri, ":", ":"+t.url.User.Username()+"@", 1)
+	}
+	req := fmt.Sprintf("OPTIONS %s SIP/2.0\r\n"+
+		"Via: SIP/2.0/%s %s;branch=%s;rport\r\n"+
+		"Max-Forwards: 70\r\n"+
+		"From: <sip:netmonitor@%s>;tag=%s\r\n"+
+		"To: <%s>\r\n"+
+		"Call-ID: %s\r\n"+
+		"CSeq: 1 OPTIONS\r\n"+
+		"Contact: <sip:netmonitor@%s>\r\n"+
+		"Accept: application/sdp\r\n"+
+		"User-Agent: netmonitor\r\n"+
+		"Content-Length: 0\r\n\r\n",
+		uri, transport, local, branch, local, tag, uri, callID, local)
+
+	if network == "tcp" {
+		if _, err := io.WriteString(conn, req); err != nil {
+			return 0, "", err
+		}
+		r := bufio.NewReader(conn)
+		for {
+			code, reason, err := readSIPResponse(r, callID)
+			if err != nil || code >= 200 {
+				return code, reason, err
+			}
+		}
+	}
+
+	// Over UDP the request is sent again after 500ms, doubling up to 4s,
+	// until a final response (RFC 3261, 17.1.2.2).
+	buf := make([]byte, 65535)
+	for retransmit := 500 * time.Millisecond; ; retransmit = min(2*retransmit, 4*time.Second) {
+		if _, err := io.WriteString(conn, req); err != nil {
+			return 0, "", err
+		}
+		resend := time.Now().Add(retransmit)
+		if resend.After(deadline) {
+			resend = deadline
+		}
+		conn.SetReadDeadline(resend)
+		for {
+			n, err := conn.Read(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) && resend.Before(deadline) {
+				break
+			}
+			if err != nil {
+				return 0, "", err
+			}
+			code, reason, err := readSIPResponse(bufio.NewReader(strings.NewReader(string(buf[:n]))), callID)
+			if err == nil && code >= 200 {
+				return code, reason, nil
+			}
+			if err == nil {
+				// A provisional response stops retransmissions.
+				resend = deadline
+				conn.SetReadDeadline(deadline)
+			}
+		}
+	}
+}
+
+// readSIPResponse reads a response to the request with callID and returns
+// its status.
+func readSIPResponse(r *bufio.Reader, callID string) (int, string, error) {
+	tp := textproto.NewReader(r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return 0, "", err
+	}
+	version, status, ok := strings.Cut(line, " ")
+	if !ok || version != "SIP/2.0" {
+		return 0, "", fmt.Errorf("not a SIP response: %q", line)
+	}
+	codeText, reason, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeText)
+	if err != nil || code < 100 || code > 699 {
+		return 0, "", fmt.Errorf("invalid SIP status %q", status)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return 0, "", err
+	}
+	if got := sipHeader(header, "Call-Id", "I"); got != callID {
+		return 0, "", fmt.Errorf("response to another call %q", got)
+	}
+	if n, _ := strconv.Atoi(sipHeader(header, "Content-Length", "L")); n > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return 0, "", err
+		}
+	}
+	return code, reason, nil
+}
+
+// sipHeader returns a SIP header by its name or compact form.
+func sipHeader(h textproto.MIMEHeader, name, compact string) string {
+	if v := h.Get(name); v != "" {
+		return v
+	}
+	return h.Get(compact)
+}
+
+func sipToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RTP probe packets carry, after the RTP header, when they were sent and
+// what the reflector saw of them:
+//
+//	0      4        12          20        28
+//	| NMRT | sent   | reflected | replied | received |
+//
+// Times are nanoseconds of each side's clock, received the count of
+// packets of the stream the reflector had seen when it replied.
+const (
+	rtpHeaderSize  = 12
+	rtpPayloadSize = 160 // 20ms of G.711, like a call
+	rtpMagic       = "NMRT"
+)
+
+// rtpStream is what a stream through a reflector measured.
+type rtpStream struct {
+	rtt                 float64 // average, in milliseconds
+	lossOut, lossIn     float64 // percent, to and from the reflector
+	jitterOut, jitterIn float64 // milliseconds (RFC 3550, 6.4.1)
+}
+
+func streamRTP(t *target) (rtpStream, error) {
+	addr := t.param("rtp", "")
+	if _, err := strconv.Atoi(addr); err == nil {
+		addr = net.JoinHostPort(t.host, addr)
+	}
+	ptime, err := time.ParseDuration(t.param("ptime", "20ms"))
+	if err != nil || ptime < time.Millisecond {
+		return rtpStream{}, fmt.Errorf("invalid ptime %q", t.param("ptime", ""))
+	}
+	duration, err := time.ParseDuration(t.param("duration", "1s"))
+	if err != nil || duration < ptime || duration > time.Minute {
+		return rtpStream{}, fmt.Errorf("invalid duration %q", t.param("duration", ""))
+	}
+	count := int(duration / ptime)
+
+	conn, err := t.dial("udp", addr)
+	if err != nil {
+		return rtpStream{}, err
+	}
+	defer conn.Close()
+
+	ssrc := make([]byte, 4)
+	rand.Read(ssrc)
+	type reply struct {
+		seq                          int
+		sent, reflected, replied, at time.Duration // since start
+		received                     uint32
+	}
+	replies := make(chan reply, count)
+	start := time.Now()
+	go func() {
+		defer close(replies)
+		buf := make([]byte, 1500)
+		for {
+			conn.SetReadDeadline(start.Add(duration + t.timeout()))
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			at := time.Since(start)
+			p := buf[:n]
+			if n < rtpHeaderSize+32 || string(p[8:12]) != string(ssrc) || string(p[12:16]) != rtpMagic {
+				continue
+			}
+			body := p[rtpHeaderSize+4:]
+			replies <- reply{
+				seq:       int(binary.BigEndian.Uint16(p[2:4])),
+				sent:      time.Duration(binary.BigEndian.Uint64(body[0:])),
+				reflected: time.Duration(binary.BigEndian.Uint64(body[8:])),
+				replied:   time.Duration(binary.BigEndian.Uint64(body[16:])),
+				received:  binary.BigEndian.Uint32(body[24:]),
+				at:        at,
+			}
+		}
+	}()
+
+	packet := make([]byte, rtpHeaderSize+rtpPayloadSize)
+	packet[0], packet[1] = 0x80, 0 // version 2, PCMU
+	copy(packet[8:], ssrc)
+	copy(packet[12:], rtpMagic)
+	for seq := range count {
+		time.Sleep(time.Until(start.Add(time.Duration(seq) * ptime)))
+		binary.BigEndian.PutUint16(packet[2:], uint16(seq))
+		binary.BigEndian.PutUint32(packet[4:], uint32(seq*rtpPayloadSize))
+		binary.BigEndian.PutUint64(packet[rtpHeaderSize+4:], uint64(time.Since(start)))
+		if _, err := conn.Write(packet); err != nil {
+			return rtpStream{}, err
+		}
+	}
+
+	var s rtpStream
+	var got int
+	var received uint32
+	var rtt time.Duration
+	var last *reply
+	for r := range replies {
+		got++
+		received = max(received, r.received)
+		rtt += r.at - r.sent - (r.replied - r.reflected)
+		if last != nil {
+			// The transit time of each way includes the offset of the
+			// clocks, which differences between packets cancel.
+			s.jitterOut += (math.Abs(float64((r.reflected-last.reflected)-(r.sent-last.sent)))/1e6 - s.jitterOut) / 16
+			s.jitterIn += (math.Abs(float64((r.at-last.at)-(r.replied-last.replied)))/1e6 - s.jitterIn) / 16
+		}
+		last = &r
+		if got == count {
+			break
+		}
+	}
+	if got == 0 {
+		return rtpStream{}, fmt.Errorf("no packets back from %s", addr)
+	}
+	s.rtt = float64(rtt) / float64(got) / 1e6
+	s.lossOut = 100 * float64(count-int(received)) / float64(count)
+	s.lossIn = 100 * float64(int(received)-got) / float64(max(received, 1))
+	return s, nil
+}
+
+// mos estimates call quality from 1 to 4.4 with the simplified E-model
+// (ITU-T G.107) of a G.711 call.
+func (s rtpStream) mos() float64 {
+	latency := s.rtt/2 + 2*max(s.jitterOut, s.jitterIn) + 10
+	r := 93.2 - latency/40
+	if latency >= 160 {
+		r = 93.2 - (latency-120)/10
+	}
+	loss := 100 - (100-s.lossOut)*(100-s.lossIn)/100
+	r -= 2.5 * loss
+	if r <= 0 {
+		return 1
+	}
+	return min(1+0.035*r+7e-6*r*(r-60)*(100-r), 4.5)
+}
+
+// rtpReflector returns the RTP packets of probes to their sender, stamped
+// with when it received and returned them.
+type rtpReflector struct {
+	conn net.PacketConn
+}
+
+func newRTPReflector(addr string) (*rtpReflector, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &rtpReflector{conn: conn}, nil
+}
+
+func (r *rtpReflector) serve() {
+	start := time.Now()
+	buf := make([]byte, 1500)
+	counts := map[string]uint32{} // packets received by stream
+	var streams []string          // oldest first, to forget old ones
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("RTP reflector: %v", err)
+			return
+		}
+		reflected := time.Since(start)
+		p := buf[:n]
+		if n < rtpHeaderSize+32 || string(p[12:16]) != rtpMagic {
+			continue
+		}
+		stream := addr.String() + "/" + string(p[8:12])
+		if _, ok := counts[stream]; !ok {
+			streams = append(streams, stream)
+			if len(streams) > 1000 {
+				delete(counts, streams[0])
+				streams = streams[1:]
+			}
+		}
+		counts[stream]++
+		body := p[rtpHeaderSize+4:]
+		binary.BigEndian.PutUint64(body[8:], uint64(reflected))
+		binary.BigEndian.PutUint64(body[16:], uint64(time.Since(start)))
+		binary.BigEndian.PutUint32(body[24:], counts[stream])
+		r.conn.WriteTo(p, addr)
+	}
+}
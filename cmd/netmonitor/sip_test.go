@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSIPResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		code     int
+		reason   string
+		err      string
+	}{
+		{"ok", "SIP/2.0 200 OK\r\nCall-ID: abc\r\nContent-Length: 0\r\n\r\n", 200, "OK", ""},
+		{"compact", "SIP/2.0 404 Not Found\r\ni: abc\r\nl: 5\r\n\r\nv=0\r\n", 404, "Not Found", ""},
+		{"no reason", "SIP/2.0 100\r\nCall-ID: abc\r\n\r\n", 100, "", ""},
+		{"http", "HTTP/1.1 200 OK\r\n\r\n", 0, "", `not a SIP response: "HTTP/1.1 200 OK"`},
+		{"bad status", "SIP/2.0 2000 OK\r\nCall-ID: abc\r\n\r\n", 0, "", `invalid SIP status "2000 OK"`},
+		{"other call", "SIP/2.0 200 OK\r\nCall-ID: xyz\r\n\r\n", 0, "", `response to another call "xyz"`},
+		{"short body", "SIP/2.0 200 OK\r\nCall-ID: abc\r\nContent-Length: 10\r\n\r\nv=0", 0, "", "EOF"},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.response))
+		code, reason, err := readSIPResponse(r, "abc")
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || code != tt.code || reason != tt.reason {
+			t.Errorf("%s: %d %q, %v", tt.name, code, reason, err)
+		}
+		if rest, _ := io.ReadAll(r); len(rest) > 0 {
+			t.Errorf("%s: %q left unread", tt.name, rest)
+		}
+	}
+}
+
+func TestRTPStreamMOS(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        rtpStream
+		min, max float64
+	}{
+		{"perfect", rtpStream{rtt: 1}, 4.3, 4.45},
+		{"far away", rtpStream{rtt: 600}, 3.5, 4},
+		{"jittery", rtpStream{rtt: 40, jitterOut: 80, jitterIn: 5}, 4, 4.3},
+		{"lossy", rtpStream{rtt: 20, lossOut: 5, lossIn: 5}, 3, 3.7},
+		{"unusable", rtpStream{rtt: 20, lossOut: 50}, 1, 1},
+	}
+	for _, tt := range tests {
+		if mos := tt.s.mos(); mos < tt.min || mos > tt.max {
+			t.Errorf("%s: MOS %.2f, want %.1f to %.1f", tt.name, mos, tt.min, tt.max)
+		}
+	}
+}
+
+// sipAnswer answers an OPTIONS request with the status lines of statuses,
+// one response each.
+func sipAnswer(request string, statuses []string) []string {
+	header, _ := textproto.NewReader(bufio.NewReader(strings.NewReader(request))).ReadMIMEHeader()
+	var responses []string
+	for _, status := range statuses {
+		responses = append(responses, fmt.Sprintf("SIP/2.0 %s\r\nVia: %s\r\nCall-ID: %s\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n",
+			status, header.Get("Via"), header.Get("Call-Id")))
+	}
+	return responses
+}
+
+// serveSIPUDP answers the nth OPTIONS request with answers(n), ignoring it
+// when that is empty.
+func serveSIPUDP(t *testing.T, answers func(n int) []string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for n := 1; ; n++ {
+			size, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// The request line goes before the header.
+			_, request, _ := strings.Cut(string(buf[:size]), "\r\n")
+			for _, response := range sipAnswer(request, answers(n)) {
+				conn.WriteTo([]byte(response), addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestProbeSIP(t *testing.T) {
+	answer := func(statuses ...string) func(int) []string {
+		return func(int) []string { return statuses }
+	}
+	tests := []struct {
+		name    string
+		answers func(n int) []string
+		warning string
+		err     string
+	}{
+		{"ok", answer("100 Trying", "200 OK"), "", ""},
+		{"refused", answer("401 Unauthorized"), "answered 401 Unauthorized", ""},
+		{"unavailable", answer("503 Service Unavailable"), "", "answered 503 Service Unavailable"},
+		{"timed out", answer("408 Request Timeout"), "", "answered 408 Request Timeout"},
+		{"lost request", func(n int) []string {
+			if n == 1 {
+				return nil
+			}
+			return []string{"200 OK"}
+		}, "", ""},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("sip://" + serveSIPUDP(t, tt.answers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeSIP(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Warning != tt.warning {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+		}
+	}
+
+	// Over TCP, as a user of the server.
+	addr := serveTCP(t, func(conn net.Conn) {
+		tp := textproto.NewReader(bufio.NewReader(conn))
+		line, _ := tp.ReadLine()
+		if !strings.HasPrefix(line, "OPTIONS sip:trunk@") {
+			io.WriteString(conn, "SIP/2.0 400 Bad Request\r\n\r\n")
+			return
+		}
+		var request strings.Builder
+		for {
+			l, err := tp.ReadLine()
+			if err != nil || l == "" {
+				break
+			}
+			request.WriteString(l + "\r\n")
+		}
+		for _, response := range sipAnswer(request.String()+"\r\n", []string{"180 Ringing", "200 OK"}) {
+			io.WriteString(conn, response)
+		}
+	})
+	tgt, err := parseTarget("sip://trunk@" + addr + "?transport=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := probeSIP(tgt); err != nil || result.Metrics["status"] != 200 {
+		t.Errorf("tcp: %+v, %v", result, err)
+	}
+
+	for _, raw := range []string{"sip://" + addr + "?transport=sctp", "sip://" + addr + "?min_mos=6"} {
+		tgt, err := parseTarget(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := probeSIP(tgt); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%s: error %v", raw, err)
+		}
+	}
+}
+
+func TestProbeSIPWithRTP(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	reflector, err := newRTPReflector("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reflector.conn.Close()
+	go reflector.serve()
+
+	pbx := serveSIPUDP(t, func(int) []string { return []string{"200 OK"} })
+	tests := []struct {
+		name    string
+		params  string
+		warning string
+		err     string
+	}{
+		{"good call", "ptime=10ms&duration=200ms", "", ""},
+		{"demanding", "ptime=10ms&duration=100ms&min_mos=4.5", "MOS", ""},
+		{"bad ptime", "ptime=0", "", `rtp: invalid ptime "0"`},
+		{"bad duration", "duration=2m", "", `rtp: invalid duration "2m"`},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget("sip://" + pbx + "?rtp=" + reflector.conn.LocalAddr().String() + "&" + tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeSIP(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !strings.HasPrefix(result.Warning, tt.warning) || (tt.warning == "") != (result.Warning == "") {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+			continue
+		}
+		m := result.Metrics
+		if !result.KeepMetrics || m["loss_out_pct"] != 0 || m["loss_in_pct"] != 0 || m["mos"] < 4 || m["rtp_rtt_ms"] <= 0 {
+			t.Errorf("%s: metrics %v", tt.name, m)
+		}
+	}
+
+	// Nothing comes back without a reflector.
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	tgt, err := parseTarget("sip://" + pbx + "?rtp=" + silent.LocalAddr().String() + "&ptime=10ms&duration=50ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt.retry.Timeout = 100 * time.Millisecond
+	if _, err := probeSIP(tgt); err == nil || !strings.Contains(err.Error(), "no packets back") {
+		t.Errorf("without a reflector: error %v", err)
+	}
+}
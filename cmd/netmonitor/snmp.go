@@ -0,0 +1,457 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SNMPConfig runs a read-only SNMP agent (v1 and v2c) that serves the
+// state of the hosts, for pollers such as PRTG, Observium or LibreNMS:
+//
+//	snmp:
+//	  listen: ":161"
+//	  community: s3cret
+//
+// Below oid, configs/NETMONITOR-MIB.txt describes the table of hosts at
+// .1.1 and the counts of hosts by state at .2.
+type SNMPConfig struct {
+	Listen    string `yaml:"listen"`
+	Community string `yaml:"community"` // public unless set
+	OID       string `yaml:"oid"`
+}
+
+// defaultSNMPOID is in the playground NET-SNMP keeps for experiments.
+const defaultSNMPOID = "1.3.6.1.4.1.8072.9999.9999"
+
+// snmpStates number the host states in the MIB.
+var snmpStates = map[string]int64{"up": 1, "degraded": 2, "down": 3, "unknown": 4, "paused": 5}
+
+type snmpAgent struct {
+	conn      net.PacketConn
+	community string
+	base      snmpOID
+}
+
+// snmpOID is an object identifier such as 1.3.6.1.2.1.1.
+type snmpOID []uint32
+
+func parseSNMPOID(s string) (snmpOID, error) {
+	var oid snmpOID
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 || oid[0] > 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+func (o snmpOID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+func (o snmpOID) append(ns ...uint32) snmpOID {
+	return append(slices.Clip(o), ns...)
+}
+
+// newSNMPAgent opens the agent's socket, which for port 161 needs root and
+// so is opened before switching users.
+func newSNMPAgent(cfg *SNMPConfig) (*snmpAgent, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	base, err := parseSNMPOID(cmp.Or(cfg.OID, defaultSNMPOID))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", cmp.Or(cfg.Listen, ":161"))
+	if err != nil {
+		return nil, err
+	}
+	return &snmpAgent{conn: conn, community: cmp.Or(cfg.Community, "public"), base: base}, nil
+}
+
+// snmpVar is a variable binding: an OID and its value, BER encoded.
+type snmpVar struct {
+	oid   snmpOID
+	value []byte
+}
+
+// variables returns the variables of the agent, sorted by OID.
+func (a *snmpAgent) variables(m *Monitor) []snmpVar {
+	stats := m.GetStats()
+	table := a.base.append(1, 1)
+	var vars []snmpVar
+	counts := map[string]int{}
+	column := func(col uint32, value func(i int, s PingStats) []byte) {
+		for i, s := range stats {
+			vars = append(vars, snmpVar{table.append(col, uint32(i+1)), value(i, s)})
+		}
+	}
+	state := func(s PingStats) string {
+		if s.Paused {
+			return "paused"
+		}
+		return s.Status
+	}
+	for _, s := range stats {
+		counts[state(s)]++
+	}
+	micros := func(ms float64) []byte { return berUint(snmpGauge, uint32(min(ms*1000, math.MaxUint32))) }
+	column(1, func(i int, s PingStats) []byte { return berInt(snmpInteger, int64(i+1)) })
+	column(2, func(i int, s PingStats) []byte { return berAppend(nil, snmpOctetString, []byte(s.Host)) })
+	column(3, func(i int, s PingStats) []byte { return berAppend(nil, snmpOctetString, []byte(s.Type)) })
+	column(4, func(i int, s PingStats) []byte {
+		return berInt(snmpInteger, cmp.Or(snmpStates[state(s)], snmpStates["unknown"]))
+	})
+	column(5, func(i int, s PingStats) []byte { return micros(s.CurrentLatency) })
+	column(6, func(i int, s PingStats) []byte { return micros(s.AvgLatency) })
+	column(7, func(i int, s PingStats) []byte { return micros(s.Jitter) })
+	column(8, func(i int, s PingStats) []byte { return berUint(snmpGauge, uint32(s.PacketLoss*100)) })
+	column(9, func(i int, s PingStats) []byte { return berUint(snmpCounter, uint32(s.PacketsSent)) })
+	column(10, func(i int, s PingStats) []byte { return berUint(snmpCounter, uint32(s.PacketsRecv)) })
+	column(11, func(i int, s PingStats) []byte { return berAppend(nil, snmpOctetString, []byte(s.LastError)) })
+
+	summary := a.base.append(2)
+	vars = append(vars, snmpVar{summary.append(1, 0), berUint(snmpGauge, uint32(len(stats)))})
+	for i, name := range []string{"up", "degraded", "down", "unknown", "paused"} {
+		vars = append(vars, snmpVar{summary.append(uint32(i+2), 0), berUint(snmpGauge, uint32(counts[name]))})
+	}
+	slices.SortFunc(vars, func(a, b snmpVar) int { return slices.Compare(a.oid, b.oid) })
+	return vars
+}
+
+// serve answers requests until the socket is closed.
+func (a *snmpAgent) serve(m *Monitor) {
+	log.Printf("SNMP agent listening on %s, serving %s", a.conn.LocalAddr(), a.base)
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("SNMP agent stopped: %v", err)
+			}
+			return
+		}
+		resp, err := a.handle(m, buf[:n])
+		if err != nil {
+			if m.config.Debug {
+				log.Printf("SNMP request from %s: %v", addr, err)
+			}
+			continue
+		}
+		a.conn.WriteTo(resp, addr)
+	}
+}
+
+// BER tags of SNMP.
+const (
+	snmpInteger     = 0x02
+	snmpOctetString = 0x04
+	snmpNull        = 0x05
+	snmpObjectID    = 0x06
+	snmpSequence    = 0x30
+	snmpCounter     = 0x41
+	snmpGauge       = 0x42
+
+	snmpGet      = 0xa0
+	snmpGetNext  = 0xa1
+	snmpResponse = 0xa2
+	snmpSet      = 0xa3
+	snmpGetBulk  = 0xa5
+
+	snmpNoSuchObject = 0x80
+	snmpEndOfMIBView = 0x82
+)
+
+// SNMP error statuses.
+const (
+	snmpNoSuchName  = 2
+	snmpReadOnly    = 4
+	snmpNotWritable = 17
+)
+
+// snmpMaxRepetitions bounds the rows of a GetBulk response.
+const snmpMaxRepetitions = 64
+
+// handle answers one request. Requests with another community are
+// dropped, as agents do.
+func (a *snmpAgent) handle(m *Monitor, packet []byte) ([]byte, error) {
+	tag, msg, _, err := berRead(packet)
+	if err != nil || tag != snmpSequence {
+		return nil, fmt.Errorf("not an SNMP message")
+	}
+	var version int64
+	var community, pdu []byte
+	var pduType byte
+	if version, msg, err = berReadInt(msg); err != nil {
+		return nil, err
+	}
+	if version != 0 && version != 1 {
+		return nil, fmt.Errorf("unsupported version, only v1 and v2c are")
+	}
+	if tag, community, msg, err = berRead(msg); err != nil || tag != snmpOctetString {
+		return nil, fmt.Errorf("no community")
+	}
+	if subtle.ConstantTimeCompare(community, []byte(a.community)) != 1 {
+		return nil, fmt.Errorf("wrong community")
+	}
+	if pduType, pdu, _, err = berRead(msg); err != nil {
+		return nil, err
+	}
+	var requestID, nonRepeaters, repetitions int64
+	if requestID, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	if nonRepeaters, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	if repetitions, pdu, err = berReadInt(pdu); err != nil {
+		return nil, err
+	}
+	var oids []snmpOID
+	if tag, pdu, _, err = berRead(pdu); err != nil || tag != snmpSequence {
+		return nil, fmt.Errorf("no variable bindings")
+	}
+	for len(pdu) > 0 {
+		var vb, raw []byte
+		if tag, vb, pdu, err = berRead(pdu); err != nil || tag != snmpSequence {
+			return nil, fmt.Errorf("invalid variable binding")
+		}
+		if tag, raw, _, err = berRead(vb); err != nil || tag != snmpObjectID {
+			return nil, fmt.Errorf("invalid variable binding")
+		}
+		oid, err := berParseOID(raw)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+
+	vars := a.variables(m)
+	// next returns the first variable after oid, or nil past the end.
+	next := func(oid snmpOID) *snmpVar {
+		i, found := slices.BinarySearchFunc(vars, oid, func(v snmpVar, oid snmpOID) int { return slices.Compare(v.oid, oid) })
+		if found {
+			i++
+		}
+		if i < len(vars) {
+			return &vars[i]
+		}
+		return nil
+	}
+	v1 := version == 0
+	var results []snmpVar
+	var errStatus, errIndex int64
+	fail := func(status int64, index int) {
+		if errStatus == 0 {
+			errStatus, errIndex = status, int64(index+1)
+		}
+	}
+	switch pduType {
+	case snmpGet:
+		for i, oid := range oids {
+			j, found := slices.BinarySearchFunc(vars, oid, func(v snmpVar, oid snmpOID) int { return slices.Compare(v.oid, oid) })
+			switch {
+			case found:
+				results = append(results, vars[j])
+			case v1:
+				fail(snmpNoSuchName, i)
+				results = append(results, snmpVar{oid, berAppend(nil, snmpNull, nil)})
+			default:
+				results = append(results, snmpVar{oid, berAppend(nil, snmpNoSuchObject, nil)})
+			}
+		}
+	case snmpGetNext:
+		for i, oid := range oids {
+			switch v := next(oid); {
+			case v != nil:
+				results = append(results, *v)
+			case v1:
+				fail(snmpNoSuchName, i)
+				results = append(results, snmpVar{oid, berAppend(nil, snmpNull, nil)})
+			default:
+				results = append(results, snmpVar{oid, berAppend(nil, snmpEndOfMIBView, nil)})
+			}
+		}
+	case snmpGetBulk:
+		if v1 {
+			return nil, fmt.Errorf("GetBulk in SNMPv1")
+		}
+		nonRepeaters = min(max(nonRepeaters, 0), int64(len(oids)))
+		repetitions = min(max(repetitions, 0), snmpMaxRepetitions)
+		bulkNext := func(oid snmpOID) snmpVar {
+			if v := next(oid); v != nil {
+				return *v
+			}
+			return snmpVar{oid, berAppend(nil, snmpEndOfMIBView, nil)}
+		}
+		for _, oid := range oids[:nonRepeaters] {
+			results = append(results, bulkNext(oid))
+		}
+		cursors := slices.Clone(oids[nonRepeaters:])
+		for range repetitions {
+			done := true
+			for i, oid := range cursors {
+				v := bulkNext(oid)
+				results = append(results, v)
+				cursors[i] = v.oid
+				done = done && v.value[0] == snmpEndOfMIBView
+			}
+			if done || len(cursors) == 0 {
+				break
+			}
+		}
+	case snmpSet:
+		status := int64(snmpNotWritable)
+		if v1 {
+			status = snmpReadOnly
+		}
+		for i, oid := range oids {
+			fail(status, i)
+			results = append(results, snmpVar{oid, berAppend(nil, snmpNull, nil)})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PDU type %#x", pduType)
+	}
+
+	var bindings []byte
+	for _, v := range results {
+		bindings = berAppend(bindings, snmpSequence, append(berAppend(nil, snmpObjectID, berOID(v.oid)), v.value...))
+	}
+	body := berInt(snmpInteger, requestID)
+	body = append(body, berInt(snmpInteger, errStatus)...)
+	body = append(body, berInt(snmpInteger, errIndex)...)
+	body = berAppend(body, snmpSequence, bindings)
+	message := berInt(snmpInteger, version)
+	message = berAppend(message, snmpOctetString, community)
+	message = berAppend(message, snmpResponse, body)
+	return berAppend(nil, snmpSequence, message), nil
+}
+
+// berRead splits the first element off b.
+func berRead(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errors.New("short BER element")
+	}
+	tag, n := b[0], int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return 0, nil, nil, errors.New("invalid BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n > len(b) {
+		return 0, nil, nil, errors.New("short BER element")
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+func berReadInt(b []byte) (int64, []byte, error) {
+	tag, content, rest, err := berRead(b)
+	if err != nil || tag != snmpInteger || len(content) == 0 || len(content) > 8 {
+		return 0, nil, errors.New("invalid integer")
+	}
+	v := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, rest, nil
+}
+
+// berAppend appends an element to buf.
+func berAppend(buf []byte, tag byte, content []byte) []byte {
+	buf = append(buf, tag)
+	switch n := len(content); {
+	case n < 0x80:
+		buf = append(buf, byte(n))
+	case n < 0x100:
+		buf = append(buf, 0x81, byte(n))
+	case n < 0x10000:
+		buf = append(buf, 0x82, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(buf, content...)
+}
+
+// berInt encodes a signed integer in as few bytes as it takes.
+func berInt(tag byte, v int64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(v))
+	// Leading bytes that only repeat the sign bit are left out.
+	for len(b) > 1 && (b[0] == 0 && b[1]&0x80 == 0 || b[0] == 0xff && b[1]&0x80 != 0) {
+		b = b[1:]
+	}
+	return berAppend(nil, tag, b)
+}
+
+// berUint encodes an unsigned 32-bit value such as a Counter32.
+func berUint(tag byte, v uint32) []byte {
+	return berInt(tag, int64(v))
+}
+
+func berOID(oid snmpOID) []byte {
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var digits []byte
+		for {
+			digits = append(digits, byte(n&0x7f))
+			n >>= 7
+			if n == 0 {
+				break
+			}
+		}
+		for i := len(digits) - 1; i >= 0; i-- {
+			if i > 0 {
+				digits[i] |= 0x80
+			}
+			b = append(b, digits[i])
+		}
+	}
+	return b
+}
+
+func berParseOID(b []byte) (snmpOID, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	first := uint32(b[0])
+	oid := snmpOID{min(first/40, 2), first - min(first/40, 2)*40}
+	var n uint32
+	for i, c := range b[1:] {
+		if n > math.MaxUint32>>7 {
+			return nil, errors.New("OID part too large")
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		} else if i == len(b)-2 {
+			return nil, errors.New("truncated OID")
+		}
+	}
+	return oid, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSNMPOID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"1.3.6.1.4.1.8072.9999.9999", "1.3.6.1.4.1.8072.9999.9999", false},
+		{".1.3.6.1.2.1.1.", "1.3.6.1.2.1.1", false},
+		{"2.5.4.3", "2.5.4.3", false},
+		{"1", "", true},
+		{"3.1.1", "", true},
+		{"1.3.six", "", true},
+		{"1.3.4294967296", "", true},
+	}
+	for _, tt := range tests {
+		oid, err := parseSNMPOID(tt.in)
+		if (err != nil) != tt.err || !tt.err && oid.String() != tt.want {
+			t.Errorf("%q: %v, %v; want %q", tt.in, oid, err, tt.want)
+		}
+	}
+}
+
+func TestBEROID(t *testing.T) {
+	tests := []struct {
+		oid  string
+		want []byte
+	}{
+		{"1.3.6.1.2.1.1.3.0", []byte{0x2b, 6, 1, 2, 1, 1, 3, 0}},
+		{"1.3.6.1.4.1.8072", []byte{0x2b, 6, 1, 4, 1, 0xbf, 0x08}},
+		{"2.5.4.3", []byte{0x55, 4, 3}},
+		{"1.3.4294967295", []byte{0x2b, 0x8f, 0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		oid, _ := parseSNMPOID(tt.oid)
+		got := berOID(oid)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: encoded % x, want % x", tt.oid, got, tt.want)
+		}
+		if back, err := berParseOID(got); err != nil || back.String() != tt.oid {
+			t.Errorf("%s: decoded %v, %v", tt.oid, back, err)
+		}
+	}
+
+	for name, b := range map[string][]byte{
+		"empty":     nil,
+		"truncated": {0x2b, 6, 0x81},
+		"too large": {0x2b, 0x9f, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		if oid, err := berParseOID(b); err == nil {
+			t.Errorf("%s: decoded %v", name, oid)
+		}
+	}
+}
+
+func TestBERInt(t *testing.T) {
+	tests := []struct {
+		v    int64
+		want []byte
+	}{
+		{0, []byte{2, 1, 0}},
+		{127, []byte{2, 1, 0x7f}},
+		{128, []byte{2, 2, 0, 0x80}},
+		{-1, []byte{2, 1, 0xff}},
+		{-129, []byte{2, 2, 0xff, 0x7f}},
+		{1 << 40, []byte{2, 6, 1, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got := berInt(snmpInteger, tt.v)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%d: encoded % x, want % x", tt.v, got, tt.want)
+		}
+		if back, rest, err := berReadInt(got); err != nil || back != tt.v || len(rest) != 0 {
+			t.Errorf("%d: decoded %d, %v", tt.v, back, err)
+		}
+	}
+	// A Counter32 at its top keeps a leading zero so it reads as positive.
+	if got, want := berUint(snmpCounter, 1<<32-1), []byte{0x41, 5, 0, 0xff, 0xff, 0xff, 0xff}; !bytes.Equal(got, want) {
+		t.Errorf("max counter encoded % x, want % x", got, want)
+	}
+}
+
+func TestBERRead(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	tests := []struct {
+		name    string
+		in      []byte
+		content []byte
+		err     bool
+	}{
+		{"short form", []byte{4, 2, 'h', 'i', 9}, []byte("hi"), false},
+		{"long form", berAppend(nil, snmpOctetString, long[:200]), long[:200], false},
+		{"two length bytes", berAppend(nil, snmpOctetString, long), long, false},
+		{"indefinite length", []byte{0x30, 0x80, 0, 0}, nil, true},
+		{"content missing", []byte{4, 5, 'h', 'i'}, nil, true},
+		{"length missing", []byte{4, 0x82, 1}, nil, true},
+		{"tag only", []byte{4}, nil, true},
+	}
+	for _, tt := range tests {
+		tag, content, _, err := berRead(tt.in)
+		if (err != nil) != tt.err || !tt.err && (tag != tt.in[0] || !bytes.Equal(content, tt.content)) {
+			t.Errorf("%s: tag %#x, %d bytes, %v", tt.name, tag, len(content), err)
+		}
+	}
+}
+
+// snmpRequest encodes a request for oids.
+func snmpRequest(version int64, community string, pdu byte, nonRepeaters, repetitions int64, oids ...string) []byte {
+	var bindings []byte
+	for _, s := range oids {
+		oid, _ := parseSNMPOID(s)
+		bindings = berAppend(bindings, snmpSequence, append(berAppend(nil, snmpObjectID, berOID(oid)), snmpNull, 0))
+	}
+	body := berInt(snmpInteger, 42)
+	body = append(body, berInt(snmpInteger, nonRepeaters)...)
+	body = append(body, berInt(snmpInteger, repetitions)...)
+	body = berAppend(body, snmpSequence, bindings)
+	msg := berInt(snmpInteger, version)
+	msg = berAppend(msg, snmpOctetString, []byte(community))
+	msg = berAppend(msg, pdu, body)
+	return berAppend(nil, snmpSequence, msg)
+}
+
+// snmpResponseOf decodes a response into its error status and index and
+// its variables.
+func snmpResponseOf(t *testing.T, resp []byte) (status, index int64, vars []snmpVar) {
+	t.Helper()
+	_, msg, _, _ := berRead(resp)
+	_, msg, _ = berReadInt(msg)
+	_, _, msg, _ = berRead(msg)
+	tag, pdu, _, err := berRead(msg)
+	if err != nil || tag != snmpResponse {
+		t.Fatalf("response % x is not a Response PDU", resp)
+	}
+	var id int64
+	id, pdu, _ = berReadInt(pdu)
+	status, pdu, _ = berReadInt(pdu)
+	index, pdu, _ = berReadInt(pdu)
+	if id != 42 {
+		t.Errorf("response to request %d, want 42", id)
+	}
+	_, bindings, _, _ := berRead(pdu)
+	for len(bindings) > 0 {
+		var vb, raw []byte
+		_, vb, bindings, _ = berRead(bindings)
+		_, raw, value, _ := berRead(vb)
+		oid, err := berParseOID(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vars = append(vars, snmpVar{oid, value})
+	}
+	return status, index, vars
+}
+
+func TestSNMPAgent(t *testing.T) {
+	var targets []*target
+	for _, raw := range []string{"192.0.2.1", "192.0.2.2"} {
+		tgt, err := parseTarget(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, tgt)
+	}
+	m := NewMonitor(targets, 0, time.Second)
+	m.config = &Config{}
+	base, _ := parseSNMPOID("1.3.6.1.4.1.8072.9999.9999")
+	a := &snmpAgent{community: "s3cret", base: base}
+
+	const b = "1.3.6.1.4.1.8072.9999.9999"
+	gauge := func(n uint32) []byte { return berUint(snmpGauge, n) }
+	hostName := berAppend(nil, snmpOctetString, []byte("192.0.2.2"))
+	null := []byte{snmpNull, 0}
+	tests := []struct {
+		name    string
+		request []byte
+		status  int64
+		index   int64
+		oids    []string
+		values  [][]byte
+	}{
+		{"get host count", snmpRequest(1, "s3cret", snmpGet, 0, 0, b+".2.1.0"), 0, 0, []string{b + ".2.1.0"}, [][]byte{gauge(2)}},
+		{"get unknown hosts and a name", snmpRequest(1, "s3cret", snmpGet, 0, 0, b+".2.5.0", b+".1.1.2.2"), 0, 0,
+			[]string{b + ".2.5.0", b + ".1.1.2.2"}, [][]byte{gauge(2), hostName}},
+		{"get missing", snmpRequest(1, "s3cret", snmpGet, 0, 0, b+".2.1.0", b+".3.0"), 0, 0,
+			[]string{b + ".2.1.0", b + ".3.0"}, [][]byte{gauge(2), {snmpNoSuchObject, 0}}},
+		{"get missing in v1", snmpRequest(0, "s3cret", snmpGet, 0, 0, b+".2.1.0", b+".3.0"), snmpNoSuchName, 2,
+			[]string{b + ".2.1.0", b + ".3.0"}, [][]byte{gauge(2), null}},
+		{"walk from the base", snmpRequest(1, "s3cret", snmpGetNext, 0, 0, b), 0, 0, []string{b + ".1.1.1.1"}, [][]byte{berInt(snmpInteger, 1)}},
+		{"walk to a state", snmpRequest(1, "s3cret", snmpGetNext, 0, 0, b+".1.1.4.1"), 0, 0, []string{b + ".1.1.4.2"}, [][]byte{berInt(snmpInteger, 4)}},
+		{"walk past the end", snmpRequest(1, "s3cret", snmpGetNext, 0, 0, b+".2.6.0"), 0, 0, []string{b + ".2.6.0"}, [][]byte{{snmpEndOfMIBView, 0}}},
+		{"walk past the end in v1", snmpRequest(0, "s3cret", snmpGetNext, 0, 0, b+".2.6.0"), snmpNoSuchName, 1, []string{b + ".2.6.0"}, [][]byte{null}},
+		{"bulk", snmpRequest(1, "s3cret", snmpGetBulk, 1, 2, b+".2", b+".1.1.2"), 0, 0,
+			[]string{b + ".2.1.0", b + ".1.1.2.1", b + ".1.1.2.2"},
+			[][]byte{gauge(2), berAppend(nil, snmpOctetString, []byte("192.0.2.1")), hostName}},
+		{"bulk off the end", snmpRequest(1, "s3cret", snmpGetBulk, 0, 3, b+".2.5.0"), 0, 0,
+			[]string{b + ".2.6.0", b + ".2.6.0"}, [][]byte{gauge(0), {snmpEndOfMIBView, 0}}},
+		{"set", snmpRequest(1, "s3cret", snmpSet, 0, 0, b+".1.1.2.1"), snmpNotWritable, 1, []string{b + ".1.1.2.1"}, [][]byte{null}},
+		{"set in v1", snmpRequest(0, "s3cret", snmpSet, 0, 0, b+".1.1.2.1"), snmpReadOnly, 1, []string{b + ".1.1.2.1"}, [][]byte{null}},
+	}
+	for _, tt := range tests {
+		resp, err := a.handle(m, tt.request)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		status, index, vars := snmpResponseOf(t, resp)
+		if status != tt.status || index != tt.index {
+			t.Errorf("%s: error status %d at %d, want %d at %d", tt.name, status, index, tt.status, tt.index)
+		}
+		var oids []string
+		var values [][]byte
+		for _, v := range vars {
+			oids = append(oids, v.oid.String())
+			values = append(values, v.value)
+		}
+		if !slices.Equal(oids, tt.oids) || !slices.EqualFunc(values, tt.values, bytes.Equal) {
+			t.Errorf("%s: got %v = % x, want %v = % x", tt.name, oids, values, tt.oids, tt.values)
+		}
+	}
+
+	// Requests the agent does not answer at all.
+	for _, tt := range []struct {
+		name    string
+		request []byte
+		err     string
+	}{
+		{"wrong community", snmpRequest(1, "public", snmpGet, 0, 0, b+".2.1.0"), "wrong community"},
+		{"v3", snmpRequest(3, "s3cret", snmpGet, 0, 0, b+".2.1.0"), "unsupported version"},
+		{"bulk in v1", snmpRequest(0, "s3cret", snmpGetBulk, 0, 5, b), "GetBulk in SNMPv1"},
+		{"trap", snmpRequest(1, "s3cret", 0xa7, 0, 0, b), "unsupported PDU"},
+		{"not BER", []byte("GET / HTTP/1.1\r\n"), "not an SNMP message"},
+	} {
+		if resp, err := a.handle(m, tt.request); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: answered % x, error %v; want %q", tt.name, resp, err, tt.err)
+		}
+	}
+}
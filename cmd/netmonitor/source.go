@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// setSource binds the target's probes to a local address and/or network
+// interface, for monitoring boxes with several uplinks. Either may be empty.
+func (t *target) setSource(sourceIP, iface string) error {
+	if sourceIP != "" {
+		if t.source = net.ParseIP(sourceIP); t.source == nil {
+			return fmt.Errorf("invalid source_ip %q", sourceIP)
+		}
+	}
+	if iface != "" {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("binding to an interface is only supported on Linux")
+		}
+		if _, err := net.InterfaceByName(iface); err != nil {
+			return fmt.Errorf("interface %q: %v", iface, err)
+		}
+		t.iface = iface
+	}
+	return nil
+}
+
+// dialer returns a dialer for network ("tcp" or "udp") that honors the
+// target's source address and interface.
+func (t *target) dialer(network string) *net.Dialer {
+	d := &net.Dialer{Timeout: t.timeout(), Control: t.control}
+	if t.source != nil {
+		switch network {
+		case "tcp":
+			d.LocalAddr = &net.TCPAddr{IP: t.source}
+		case "udp":
+			d.LocalAddr = &net.UDPAddr{IP: t.source}
+		}
+	}
+	return d
+}
+
+// dial connects to address from the target's source, through its proxy
+// for TCP.
+func (t *target) dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+	defer cancel()
+	return t.dialContext(ctx, network, address)
+}
+
+// listenConfig returns a ListenConfig for sockets such as raw ICMP that
+// are not dialed.
+func (t *target) listenConfig() *net.ListenConfig {
+	return &net.ListenConfig{Control: t.control}
+}
+
+// control binds sockets to the target's interface and sets their DSCP
+// marking before they connect.
+func (t *target) control(network, address string, c syscall.RawConn) error {
+	if t.iface == "" && t.dscp == 0 {
+		return nil
+	}
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if t.iface != "" {
+			err = bindToDevice(fd, t.iface)
+		}
+		if err == nil && t.dscp != 0 {
+			// network is e.g. "udp4", "tcp6" or "ip4:icmp".
+			err = setTOS(fd, strings.Contains(network, "6"), t.dscp<<2)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// bindToDevice makes the socket send and receive only through iface.
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func bindToDevice(fd uintptr, iface string) error {
+	return errors.New("binding to an interface is only supported on Linux")
+}
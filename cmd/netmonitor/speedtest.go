@@ -0,0 +1,240 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Speed test targets measure download and upload throughput against a
+// self-hosted LibreSpeed server or a speedtest.net-compatible HTTP server:
+//
+//	speedtest://speed.example.com/backend/?tls=true
+//	speedtest://speedtest.isp.net:8080/speedtest/?type=speedtest&min_download=200
+//
+// type picks the server's API, librespeed unless set. A test downloads,
+// then uploads, over streams parallel connections (4) for duration each
+// (10s), and runs once per refresh (an hour); upload=false skips the
+// upload. min_download and min_upload, in Mbit/s, mark the host degraded
+// when it falls short. Results are kept in the history for the speed
+// chart.
+func init() {
+	probers["speedtest"] = every("1h", probeSpeedtest)
+	probeDurations["speedtest"] = func(t *target) time.Duration {
+		duration, _ := time.ParseDuration(t.param("duration", "10s"))
+		return 2*duration + 4*t.timeout()
+	}
+}
+
+// speedtestURLs are the endpoints of a speed test server.
+type speedtestURLs struct {
+	ping, download, upload string
+}
+
+func newSpeedtestURLs(t *target) (speedtestURLs, error) {
+	scheme := "http"
+	if t.param("tls", "false") == "true" {
+		scheme = "https"
+	}
+	base := scheme + "://" + t.url.Host + t.url.Path
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	switch t.param("type", "librespeed") {
+	case "librespeed":
+		return speedtestURLs{
+			ping:     base + "empty.php",
+			download: base + "garbage.php?ckSize=100",
+			upload:   base + "empty.php",
+		}, nil
+	case "speedtest":
+		return speedtestURLs{
+			ping:     base + "latency.txt",
+			download: base + "random4000x4000.jpg",
+			upload:   base + "upload.php",
+		}, nil
+	default:
+		return speedtestURLs{}, fmt.Errorf("unknown type %q, expected librespeed or speedtest", t.param("type", ""))
+	}
+}
+
+func probeSpeedtest(t *target) (probeResult, error) {
+	urls, err := newSpeedtestURLs(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	duration, err := time.ParseDuration(t.param("duration", "10s"))
+	if err != nil || duration <= 0 {
+		return probeResult{}, fmt.Errorf("invalid duration %q", t.param("duration", ""))
+	}
+	streams, err := strconv.Atoi(t.param("streams", "4"))
+	if err != nil || streams < 1 {
+		return probeResult{}, fmt.Errorf("invalid streams %q", t.param("streams", ""))
+	}
+	client := &http.Client{Transport: t.proxied(&http.Transport{
+		TLSHandshakeTimeout: t.timeout(),
+		TLSClientConfig:     t.tlsConfig(t.host),
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: streams,
+	})}
+	defer client.CloseIdleConnections()
+
+	latency, err := speedtestPing(t, client, urls.ping)
+	if err != nil {
+		return probeResult{}, err
+	}
+	result := probeResult{Latency: latency, Metrics: map[string]float64{}, KeepMetrics: true}
+	download, err := measureThroughput(streams, duration, func(ctx context.Context, count *atomic.Int64) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, urls.download, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("download: %s", resp.Status)
+		}
+		_, err = io.Copy(io.Discard, &countingReader{r: resp.Body, n: count})
+		return err
+	})
+	if err != nil {
+		return probeResult{}, fmt.Errorf("download: %v", err)
+	}
+	result.Metrics["download_mbps"] = download
+
+	if t.param("upload", "true") != "false" {
+		chunk := make([]byte, 1<<20)
+		rand.Read(chunk) // incompressible, so proxies cannot shrink it
+		upload, err := measureThroughput(streams, duration, func(ctx context.Context, count *atomic.Int64) error {
+			// Each request sends up to 25 MiB.
+			body := &countingReader{r: io.LimitReader(&repeatReader{chunk: chunk}, 25<<20), n: count}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, urls.upload, body)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("upload: %s", resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			return probeResult{}, fmt.Errorf("upload: %v", err)
+		}
+		result.Metrics["upload_mbps"] = upload
+	}
+
+	var short []string
+	for _, dir := range []string{"download", "upload"} {
+		limit, _ := strconv.ParseFloat(t.param("min_"+dir, "0"), 64)
+		if got, ok := result.Metrics[dir+"_mbps"]; ok && got < limit {
+			short = append(short, fmt.Sprintf("%s %.1f Mbit/s is below %g", dir, got, limit))
+		}
+	}
+	result.Warning = strings.Join(short, ", ")
+	return result, nil
+}
+
+// speedtestPing returns the lowest of a few round trips to the server.
+func speedtestPing(t *target, client *http.Client, url string) (float64, error) {
+	best := -1.0
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		cancel()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("ping: %s", resp.Status)
+		}
+		if ms := msSince(start); best < 0 || ms < best {
+			best = ms
+		}
+	}
+	return best, nil
+}
+
+// measureThroughput runs transfer over streams connections, again and
+// again, until duration has passed and returns the rate in Mbit/s of the
+// bytes it counted.
+func measureThroughput(streams int, duration time.Duration, transfer func(ctx context.Context, count *atomic.Int64) error) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	var (
+		count    atomic.Int64
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	start := time.Now()
+	for range streams {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				if err := transfer(ctx, &count); err != nil && ctx.Err() == nil {
+					mu.Lock()
+					firstErr = cmp.Or(firstErr, err)
+					mu.Unlock()
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+	if count.Load() == 0 {
+		return 0, cmp.Or(firstErr, errors.New("nothing was transferred"))
+	}
+	return float64(count.Load()) * 8 / elapsed / 1e6, nil
+}
+
+// countingReader adds the bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// repeatReader reads chunk over and over.
+type repeatReader struct {
+	chunk []byte
+	off   int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.chunk[r.off:])
+	r.off = (r.off + n) % len(r.chunk)
+	return n, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostStats holds the statistics of one host. A probe updates them under
+// the host's own lock and then publishes a copy, so probes of different
+// hosts never wait for each other and readers such as /api/stats never
+// wait for probes at all.
+type hostStats struct {
+	mu    sync.Mutex
+	stats PingStats // guarded by mu
+
+	snapshot atomic.Pointer[PingStats]
+
+	// lastRun is when the host last finished a probe, in Unix nanoseconds.
+	lastRun atomic.Int64
+
+	// resets counts the resets of the statistics, changed with mu held.
+	resets atomic.Uint64
+}
+
+func newHostStats(stats PingStats) *hostStats {
+	h := &hostStats{stats: stats}
+	h.publish()
+	return h
+}
+
+// newTargetStats starts the statistics of a newly monitored target.
+func newTargetStats(t *target) *hostStats {
+	return newHostStats(PingStats{
+		Host:       t.name,
+		Type:       t.kind,
+		Tags:       t.tags,
+		Path:       t.path,
+		HostMeta:   t.meta,
+		Thresholds: t.thresholds,
+		Status:     "unknown",
+		MinLatency: -1,
+		MaxLatency: -1,
+	})
+}
+
+// hostSet is the monitored hosts at one point in time. It is never
+// modified: adding or removing hosts publishes a new set, so readers take
+// a consistent snapshot without locking.
+type hostSet struct {
+	targets []*target // in configured order, discovered hosts last
+	stats   map[string]*hostStats
+}
+
+// hosts returns the current host set.
+func (m *Monitor) hosts() *hostSet {
+	return m.hostSet.Load()
+}
+
+// update runs fn with the host's statistics locked and publishes the
+// result.
+func (h *hostStats) update(fn func(stats *PingStats)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn(&h.stats)
+	h.publish()
+}
+
+// publish makes the current statistics visible to readers. The caller
+// holds mu, except in newHostStats.
+func (h *hostStats) publish() {
+	s := h.stats
+	h.snapshot.Store(&s)
+}
+
+// load returns the last published statistics.
+func (h *hostStats) load() PingStats {
+	return *h.snapshot.Load()
+}
+
+// lastRunTime returns the zero time before the first probe was scheduled.
+func (h *hostStats) lastRunTime() time.Time {
+	ns := h.lastRun.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// statsOf returns the latest statistics of a host.
+func (m *Monitor) statsOf(name string) (PingStats, bool) {
+	h, ok := m.hosts().stats[name]
+	if !ok {
+		return PingStats{}, false
+	}
+	return m.withOutage(h.load()), true
+}
+
+// statusRank orders hosts worst first when sorting by status.
+var statusRank = map[string]int{"down": 0, "degraded": 1, "unknown": 2, "up": 3}
+
+// statsOrders are the ?sort= keys of /api/stats. A leading "-" reverses
+// the order; ties keep the configured order.
+var statsOrders = map[string]func(a, b PingStats) int{
+	"name": func(a, b PingStats) int {
+		return cmp.Compare(cmp.Or(a.DisplayName, a.Host), cmp.Or(b.DisplayName, b.Host))
+	},
+	"status":  func(a, b PingStats) int { return cmp.Compare(statusRank[a.Status], statusRank[b.Status]) },
+	"latency": func(a, b PingStats) int { return cmp.Compare(a.CurrentLatency, b.CurrentLatency) },
+	"loss":    func(a, b PingStats) int { return cmp.Compare(a.PacketLoss, b.PacketLoss) },
+}
+
+// sortStats orders stats by a ?sort= key. An empty key keeps the
+// configured order.
+func sortStats(stats []PingStats, key string) error {
+	if key == "" || key == "config" {
+		return nil
+	}
+	name, desc := strings.CutPrefix(key, "-")
+	order, ok := statsOrders[name]
+	if !ok {
+		return fmt.Errorf("unknown sort order %q", key)
+	}
+	slices.SortStableFunc(stats, func(a, b PingStats) int {
+		if desc {
+			return order(b, a)
+		}
+		return order(a, b)
+	})
+	return nil
+}
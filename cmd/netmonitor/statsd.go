@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// StatsD exporters:
+//
+//	statsd://localhost:8125?prefix=netmonitor
+//	dogstatsd://localhost:8125?tags=env:prod,site:home
+//
+// Every probe sends <prefix>.latency as a timer along with <prefix>.up as a
+// gauge and the probe's own metrics as <prefix>.<metric> gauges. Plain
+// StatsD has no tags, so the host is part of the name there:
+// netmonitor.8_8_8_8.latency. DogStatsD tags each metric with host, type
+// and the host's tags instead, and sends status changes as events.
+func init() {
+	exporterFactories["statsd"] = newStatsdExporter
+	exporterFactories["dogstatsd"] = newStatsdExporter
+}
+
+// statsdMaxPacket keeps datagrams within a typical Ethernet MTU.
+const statsdMaxPacket = 1432
+
+type statsdExporter struct {
+	conn   net.Conn
+	prefix string
+	dog    bool
+	tags   []string
+
+	// failing suppresses repeated errors while no agent is listening.
+	failing atomic.Bool
+}
+
+func newStatsdExporter(u *url.URL) (Exporter, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "8125")
+	}
+	// UDP dialing only resolves the address, so a missing agent never
+	// fails startup.
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	e := &statsdExporter{
+		conn:   conn,
+		prefix: u.Query().Get("prefix"),
+		dog:    u.Scheme == "dogstatsd",
+		tags:   splitList(u.Query().Get("tags")),
+	}
+	if e.prefix == "" {
+		e.prefix = "netmonitor"
+	}
+	return e, nil
+}
+
+func (e *statsdExporter) Export(ev ProbeEvent) {
+	var lines []string
+	metric := func(name, value, typ string) {
+		if e.dog {
+			lines = append(lines, fmt.Sprintf("%s.%s:%s|%s%s", e.prefix, name, value, typ, e.tagSuffix(ev)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s.%s.%s:%s|%s", e.prefix, statsdName(ev.Host), name, value, typ))
+		}
+	}
+
+	up := "0"
+	if ev.Error == "" {
+		up = "1"
+		metric("latency", fmt.Sprintf("%.3f", ev.Latency), "ms")
+	}
+	metric("up", up, "g")
+	for name, v := range ev.Metrics {
+		metric(statsdName(name), fmt.Sprintf("%g", v), "g")
+	}
+
+	if e.dog && ev.Changed() && ev.Previous != "unknown" {
+		title := fmt.Sprintf("%s is %s", ev.Host, ev.Status)
+		text := fmt.Sprintf("was %s", ev.Previous)
+		if ev.Error != "" {
+			text += ": " + ev.Error
+		}
+		alert := "success"
+		switch ev.Status {
+		case "down":
+			alert = "error"
+		case "degraded":
+			alert = "warning"
+		}
+		lines = append(lines, fmt.Sprintf("_e{%d,%d}:%s|%s|t:%s%s", len(title), len(text), title, text, alert, e.tagSuffix(ev)))
+	}
+	e.send(lines)
+}
+
+// tagSuffix renders the DogStatsD tags of an event.
+func (e *statsdExporter) tagSuffix(ev ProbeEvent) string {
+	tags := append([]string{"host:" + ev.Host, "type:" + ev.Type}, e.tags...)
+	tags = append(tags, ev.Tags...)
+	for i, tag := range tags {
+		tags[i] = statsdTagReplacer.Replace(tag)
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+// send packs lines into as few datagrams as fit.
+func (e *statsdExporter) send(lines []string) {
+	var packet strings.Builder
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write([]byte(packet.String())); err != nil {
+			if !e.failing.Swap(true) {
+				log.Printf("StatsD export failed: %v", err)
+			}
+		} else {
+			e.failing.Store(false)
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+}
+
+// statsdTagReplacer strips the separators of the DogStatsD tag list.
+var statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_")
+
+// statsdName replaces the characters StatsD uses as separators.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', '/', ' ', ',', '?', '=', '&':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsd returns a UDP socket for an exporter to send to.
+func listenStatsd(t *testing.T) net.PacketConn {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readDatagrams returns the datagrams sent to conn until it is quiet.
+func readDatagrams(conn net.PacketConn) []string {
+	var packets []string
+	b := make([]byte, 65535)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := conn.ReadFrom(b)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(b[:n]))
+	}
+}
+
+func TestStatsdExporter(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		event ProbeEvent
+		want  []string
+	}{
+		{
+			"statsd",
+			"statsd://%s",
+			ProbeEvent{Host: "8.8.8.8", Type: "icmp", Status: "up", Previous: "up", Latency: 12.5, Metrics: map[string]float64{"ttl": 117}},
+			[]string{"netmonitor.8_8_8_8.latency:12.500|ms", "netmonitor.8_8_8_8.up:1|g", "netmonitor.8_8_8_8.ttl:117|g"},
+		},
+		{
+			"statsd down",
+			"statsd://%s?prefix=lab",
+			ProbeEvent{Host: "https://example.com/health", Type: "https", Status: "down", Previous: "up", Error: "timeout"},
+			[]string{"lab.https___example_com_health.up:0|g"},
+		},
+		{
+			"dogstatsd",
+			"dogstatsd://%s?tags=env:prod",
+			ProbeEvent{Host: "8.8.8.8", Type: "icmp", Tags: []string{"team:net,ops"}, Status: "up", Previous: "up", Latency: 1},
+			[]string{
+				"netmonitor.latency:1.000|ms|#host:8.8.8.8,type:icmp,env:prod,team:net_ops",
+				"netmonitor.up:1|g|#host:8.8.8.8,type:icmp,env:prod,team:net_ops",
+			},
+		},
+		{
+			"dogstatsd event",
+			"dogstatsd://%s",
+			ProbeEvent{Host: "8.8.8.8", Type: "icmp", Status: "down", Previous: "up", Error: "timeout"},
+			[]string{
+				"netmonitor.up:0|g|#host:8.8.8.8,type:icmp",
+				"_e{15,15}:8.8.8.8 is down|was up: timeout|t:error|#host:8.8.8.8,type:icmp",
+			},
+		},
+		{
+			"first result",
+			"dogstatsd://%s",
+			ProbeEvent{Host: "8.8.8.8", Type: "icmp", Status: "up", Previous: "unknown", Latency: 1},
+			[]string{"netmonitor.latency:1.000|ms|#host:8.8.8.8,type:icmp", "netmonitor.up:1|g|#host:8.8.8.8,type:icmp"},
+		},
+	}
+	for _, tt := range tests {
+		conn := listenStatsd(t)
+		e, err := parseExporter(strings.Replace(tt.url, "%s", conn.LocalAddr().String(), 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Export(tt.event)
+		packets := readDatagrams(conn)
+		if len(packets) != 1 || !slices.Equal(strings.Split(packets[0], "\n"), tt.want) {
+			t.Errorf("%s: sent %q, want %q", tt.name, packets, tt.want)
+		}
+	}
+}
+
+func TestStatsdPacking(t *testing.T) {
+	conn := listenStatsd(t)
+	e, err := parseExporter("statsd://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 500)
+	e.(*statsdExporter).send([]string{line, line, line, line, line})
+	packets := readDatagrams(conn)
+	var sizes []int
+	for _, p := range packets {
+		sizes = append(sizes, len(p))
+	}
+	if !slices.Equal(sizes, []int{1001, 1001, 500}) {
+		t.Errorf("datagrams of %v bytes, want two lines to each", sizes)
+	}
+}
+
+func TestStatsdName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"8.8.8.8", "8_8_8_8"},
+		{"[2001:db8::1]:53", "[2001_db8__1]_53"},
+		{"https://example.com/a?b=c&d", "https___example_com_a_b_c_d"},
+		{"connect_ms", "connect_ms"},
+	}
+	for _, tt := range tests {
+		if got := statsdName(tt.in); got != tt.want {
+			t.Errorf("%q: %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// resultStreams hands probe results to the clients of
+// /api/results/stream.
+type resultStreams struct {
+	mu   sync.Mutex
+	subs map[chan ProbeEvent]struct{}
+}
+
+func (s *resultStreams) subscribe() chan ProbeEvent {
+	ch := make(chan ProbeEvent, exportQueue)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[chan ProbeEvent]struct{}{}
+	}
+	s.subs[ch] = struct{}{}
+	return ch
+}
+
+func (s *resultStreams) unsubscribe(ch chan ProbeEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+}
+
+// Export passes the event to every client, dropping it for those that
+// cannot keep up.
+func (s *resultStreams) Export(e ProbeEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+			telemetry.exportDropped.Add(1)
+		}
+	}
+}
+
+// handleResultStream serves GET /api/results/stream: every probe result
+// as a line of JSON as it comes in, for as long as the client stays
+// connected. host and tag (repeatable) narrow it down, and changes=true
+// only sends status changes.
+func (m *Monitor) handleResultStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	q := r.URL.Query()
+	hosts, tags := q["host"], q["tag"]
+	changes := q.Get("changes") == "true"
+
+	ch := m.streams.subscribe()
+	defer m.streams.unsubscribe(ch)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			if len(hosts) > 0 && !slices.Contains(hosts, e.Host) ||
+				len(tags) > 0 && !slices.ContainsFunc(tags, func(t string) bool { return slices.Contains(e.Tags, t) }) ||
+				changes && !e.Changed() {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
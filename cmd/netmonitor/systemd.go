@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends a state such as "READY=1" to systemd when running as a
+// Type=notify service, and does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' { // abstract namespace
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdListener returns the first socket passed by systemd socket activation,
+// or nil when the process was not socket activated.
+func sdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, nil
+	}
+	if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+		return nil, nil
+	}
+	// Passed sockets start at file descriptor 3.
+	f := os.NewFile(3, "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %v", err)
+	}
+	return l, nil
+}
+
+// sdWatchdogInterval returns how often systemd expects a keep-alive, or
+// zero when the watchdog is off.
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdog pings systemd's watchdog as long as every probe loop keeps
+// making progress, so a wedged monitor gets restarted.
+func (m *Monitor) watchdog(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	warned := false
+	for range ticker.C {
+		if stalled := m.stalled(); len(stalled) > 0 {
+			if !warned {
+				log.Printf("Probe loops stalled, withholding watchdog keep-alive: %s", strings.Join(stalled, ", "))
+				warned = true
+			}
+			continue
+		}
+		warned = false
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Printf("Notifying systemd failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// target describes what to probe for one entry of the host list.
+// Plain hostnames and IPs are pinged over ICMP; URL-style entries such as
+// dns://1.1.1.1/example.com select one of the other probe types.
+type target struct {
+	name string   // entry as configured with any password redacted, the stats key
+	kind string   // probe type, e.g. "icmp", "dns", "dot", "doh"
+	host string   // hostname or IP without port
+	port string   // explicit port, empty when the probe default applies
+	url  *url.URL // parsed entry, nil for plain ICMP hosts
+	tags []string // labels from the config file
+
+	source net.IP // local address probes are sent from, nil for any
+	iface  string // interface probes are bound to, empty for any
+	dscp   int    // DSCP code point of probe packets
+	path   string // uplink name when the host is probed over several paths
+
+	thresholds Thresholds  // dashboard coloring
+	retry      RetryPolicy // attempts per probe cycle
+	portScan   PortScan    // ports checked for changes
+	schedule   *timeWindow // when to probe, nil for always
+	meta       HostMeta    // how the host is shown
+	composite  *composite  // the checks of composite targets
+	httpSteps  []httpStep  // the transaction of HTTP targets, if scripted
+
+	httpVersion string   // forced on HTTP targets: "1.1", "2" or "3"
+	proxy       *url.URL // TCP connections go through, nil for direct
+
+	provider string // discovery provider that found the host, empty for configured ones
+
+	state *probeState // of the monitor probing it, nil outside one
+
+	resolveTTL time.Duration // how long a resolved address is used
+	resolved   resolvedAddr
+}
+
+// parseTarget turns a host list entry into a target.
+func parseTarget(raw string) (*target, error) {
+	if !strings.Contains(raw, "://") {
+		return &target{name: raw, kind: "icmp", host: raw}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %v", raw, err)
+	}
+
+	kind := strings.ToLower(u.Scheme)
+	if _, ok := probers[kind]; !ok {
+		return nil, fmt.Errorf("invalid target %q: unknown probe type %q", raw, kind)
+	}
+	// Commands run locally and are the only targets without a host.
+	if u.Hostname() == "" && kind != "exec" {
+		return nil, fmt.Errorf("invalid target %q: missing host", raw)
+	}
+
+	return &target{
+		name: redactTarget(u),
+		kind: kind,
+		host: u.Hostname(),
+		port: u.Port(),
+		url:  u,
+	}, nil
+}
+
+// redactTarget returns the target for display, with the password of the
+// URL and a secret parameter masked.
+func redactTarget(u *url.URL) string {
+	q := u.Query()
+	if !q.Has("secret") {
+		return u.Redacted()
+	}
+	q.Set("secret", "xxxxx")
+	shown := *u
+	shown.RawQuery = q.Encode()
+	return shown.Redacted()
+}
+
+// hostPort returns the target address, falling back to defaultPort when the
+// entry did not specify one.
+func (t *target) hostPort(defaultPort string) string {
+	port := t.port
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(t.host, port)
+}
+
+// param returns a query parameter of the target URL, or def when unset.
+func (t *target) param(name, def string) string {
+	if t.url == nil {
+		return def
+	}
+	if v := t.url.Query().Get(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Notification templates are Go text/templates executed with the Alert as
+// data, so they can use .Host, .Status, .Previous, .Severity, .Message,
+// .Duration, .DashboardURL, .Stats.Tags, .Stats.AvgLatency and so on:
+//
+//	templates:
+//	  title: "[{{.Severity | upper}}] {{.Host}} {{.Status}}"
+//	  text: |
+//	    {{.Host}} was {{.Previous}} for {{duration .Duration}}.
+//	    Avg latency {{ms .Stats.AvgLatency}}, loss {{printf "%.1f" .Stats.PacketLoss}}%
+//	    {{.DashboardURL}}
+//
+// A notifier can override either template with its own title and text.
+type TemplateConfig struct {
+	Title string `yaml:"title"`
+	Text  string `yaml:"text"`
+}
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"ms": func(v float64) string {
+		if v <= 0 {
+			return "N/A"
+		}
+		return fmt.Sprintf("%.2f ms", v)
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+}
+
+// alertTemplates holds the parsed title and text templates of a notifier.
+// Either may be nil, in which case the built-in wording is used.
+type alertTemplates struct {
+	title *template.Template
+	text  *template.Template
+}
+
+// parseTemplates compiles a notifier's templates, falling back to the
+// global ones for anything the notifier does not set.
+func parseTemplates(own, global TemplateConfig) (alertTemplates, error) {
+	var t alertTemplates
+	var err error
+	if t.title, err = parseTemplate("title", own.Title, global.Title); err != nil {
+		return t, err
+	}
+	if t.text, err = parseTemplate("text", own.Text, global.Text); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func parseTemplate(name, own, global string) (*template.Template, error) {
+	src := own
+	if src == "" {
+		src = global
+	}
+	if src == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s template: %v", name, err)
+	}
+	return t, nil
+}
+
+// apply renders the templates into a copy of a. Rendering errors are
+// reported in the message itself rather than dropping the alert.
+func (t alertTemplates) apply(a Alert) Alert {
+	render := func(tmpl *template.Template) string {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, a); err != nil {
+			return fmt.Sprintf("%s (template error: %v)", a.Host, err)
+		}
+		return strings.TrimSpace(b.String())
+	}
+	if t.title != nil {
+		a.title = render(t.title)
+	}
+	if t.text != nil {
+		a.text = render(t.text)
+	}
+	return a
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// TenantConfig serves a monitor of its own, with its own hosts, notifiers,
+// login and history, from the same process:
+//
+//	tenants:
+//	  - name: acme
+//	    config: tenants/acme.yaml
+//	    domains: [acme.monitor.example.com]
+//	    tokens: [ACME_API_TOKEN]
+//
+// Requests are sent to a tenant by a bearer token from its tokens, or by
+// the host name they were made for. The tenant's config file is a normal
+// one, relative to the main config file's directory; the port, user,
+// plugins, debug, arp_watch and capture settings of the main one apply to
+// the whole process and are ignored there. Interval, workers, base_path
+// and cors_origins default to the main one's, and history_dir to the
+// tenant's name in a tenants directory of the main one's.
+type TenantConfig struct {
+	Name    string   `yaml:"name"`
+	Config  string   `yaml:"config"`
+	Domains []string `yaml:"domains"`
+	Tokens  []string `yaml:"tokens"`
+}
+
+// tenant is a monitor serving one tenant.
+type tenant struct {
+	name    string
+	domains []string
+	tokens  [][sha256.Size]byte
+	setup   *monitorSetup
+	monitor *Monitor
+}
+
+// loadTenants reads the config files of the tenants and checks them like
+// the main one.
+func loadTenants(cfg *Config, configPath string) ([]*tenant, error) {
+	var tenants []*tenant
+	for i, tc := range cfg.Tenants {
+		if tc.Name == "" {
+			return nil, fmt.Errorf("tenant %d has no name", i+1)
+		}
+		if slices.ContainsFunc(tenants, func(t *tenant) bool { return t.name == tc.Name }) {
+			return nil, fmt.Errorf("tenant %s is listed twice", tc.Name)
+		}
+		t, err := newTenant(tc, cfg, configPath)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %v", tc.Name, err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+func newTenant(tc TenantConfig, main *Config, mainPath string) (*tenant, error) {
+	if tc.Config == "" {
+		return nil, errors.New("config is required")
+	}
+	if len(tc.Domains) == 0 && len(tc.Tokens) == 0 {
+		return nil, errors.New("set domains or tokens to reach it by")
+	}
+	path := tc.Config
+	if !filepath.IsAbs(path) && mainPath != "" {
+		path = filepath.Join(filepath.Dir(mainPath), path)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Tenants) > 0 {
+		return nil, errors.New("tenants cannot have tenants")
+	}
+	cfg.Interval = cmp.Or(cfg.Interval, main.Interval)
+	cfg.Workers = cmp.Or(cfg.Workers, main.Workers)
+	cfg.BasePath = cmp.Or(cfg.BasePath, main.BasePath)
+	if cfg.CORSOrigins == nil {
+		cfg.CORSOrigins = main.CORSOrigins
+	}
+	if cfg.HistoryDir == "" && main.HistoryDir != "" {
+		cfg.HistoryDir = filepath.Join(main.HistoryDir, "tenants", tc.Name)
+	}
+
+	t := &tenant{name: tc.Name}
+	for _, d := range tc.Domains {
+		t.domains = append(t.domains, strings.ToLower(d))
+	}
+	for _, token := range tc.Tokens {
+		t.tokens = append(t.tokens, sha256.Sum256([]byte(token)))
+	}
+	if t.setup, err = newMonitorSetup(cfg, path); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// tenantRouter hands requests to the monitor of their tenant, or the main
+// one when there is none.
+type tenantRouter struct {
+	tenants  []*tenant
+	fallback *Monitor // nil when the main config has no hosts of its own
+}
+
+func (tr *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		sum := sha256.Sum256([]byte(token))
+		for _, t := range tr.tenants {
+			if slices.ContainsFunc(t.tokens, func(s [sha256.Size]byte) bool { return subtle.ConstantTimeCompare(s[:], sum[:]) == 1 }) {
+				// Tokens are for scripts, which can do anything within
+				// their tenant.
+				u := &User{Subject: "token:" + t.name, Name: "API token", Role: roleAdmin}
+				t.monitor.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+				return
+			}
+		}
+	}
+
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, t := range tr.tenants {
+		if slices.Contains(t.domains, host) {
+			t.monitor.ServeHTTP(w, r)
+			return
+		}
+	}
+	if tr.fallback == nil {
+		writeError(w, http.StatusNotFound, "no tenant for "+host)
+		return
+	}
+	tr.fallback.ServeHTTP(w, r)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTenants(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"acme.yaml":   "hosts:\n  - target: 192.0.2.1\n",
+		"nested.yaml": "tenants:\n  - name: inner\n    config: acme.yaml\n    domains: [inner.example.com]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acme := TenantConfig{Name: "acme", Config: "acme.yaml", Domains: []string{"Acme.Example.com"}, Tokens: []string{"acme-token"}}
+
+	tests := []struct {
+		name    string
+		tenants []TenantConfig
+		err     string
+	}{
+		{"ok", []TenantConfig{acme}, ""},
+		{"no name", []TenantConfig{{Config: "acme.yaml", Tokens: []string{"x"}}}, "tenant 1 has no name"},
+		{"listed twice", []TenantConfig{acme, acme}, "tenant acme is listed twice"},
+		{"no config", []TenantConfig{{Name: "acme", Tokens: []string{"x"}}}, "config is required"},
+		{"unreachable", []TenantConfig{{Name: "acme", Config: "acme.yaml"}}, "set domains or tokens"},
+		{"missing config", []TenantConfig{{Name: "acme", Config: "missing.yaml", Tokens: []string{"x"}}}, "no such file"},
+		{"nested", []TenantConfig{{Name: "acme", Config: "nested.yaml", Tokens: []string{"x"}}}, "tenants cannot have tenants"},
+	}
+	for _, tt := range tests {
+		main := &Config{Interval: 30 * time.Second, HistoryDir: "/var/lib/netmonitor", Tenants: tt.tenants}
+		tenants, err := loadTenants(main, filepath.Join(dir, "netmonitor.yaml"))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		tn := tenants[0]
+		cfg := tn.setup.cfg
+		if cfg.Interval != 30*time.Second || cfg.HistoryDir != "/var/lib/netmonitor/tenants/acme" {
+			t.Errorf("%s: interval %v, history_dir %q; want the main config's", tt.name, cfg.Interval, cfg.HistoryDir)
+		}
+		if len(tn.setup.targets) != 1 || tn.domains[0] != "acme.example.com" || len(tn.tokens) != 1 {
+			t.Errorf("%s: tenant %+v", tt.name, tn)
+		}
+	}
+}
+
+func TestTenantRouter(t *testing.T) {
+	monitor := func(host string) *Monitor {
+		tgt, err := parseTarget(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := NewMonitor([]*target{tgt}, 0, time.Minute)
+		m.config = &Config{}
+		return m
+	}
+	newTenant := func(name, host, domain, token string) *tenant {
+		return &tenant{name: name, domains: []string{domain}, tokens: [][sha256.Size]byte{sha256.Sum256([]byte(token))}, monitor: monitor(host)}
+	}
+	tenants := []*tenant{
+		newTenant("acme", "192.0.2.1", "acme.example.com", "acme-token"),
+		newTenant("globex", "192.0.2.2", "globex.example.com", "globex-token"),
+	}
+	main := monitor("192.0.2.254")
+
+	tests := []struct {
+		name     string
+		host     string
+		token    string
+		fallback bool
+		want     string // the host whose stats are served
+		status   int
+	}{
+		{"by domain", "acme.example.com", "", true, "192.0.2.1", http.StatusOK},
+		{"by domain with port", "GLOBEX.example.com:8080", "", true, "192.0.2.2", http.StatusOK},
+		{"by token", "monitor.example.com", "globex-token", true, "192.0.2.2", http.StatusOK},
+		{"token before domain", "acme.example.com", "globex-token", true, "192.0.2.2", http.StatusOK},
+		{"other token", "acme.example.com", "nope", true, "192.0.2.1", http.StatusOK},
+		{"no tenant", "monitor.example.com", "", true, "192.0.2.254", http.StatusOK},
+		{"no tenant or main hosts", "monitor.example.com", "nope", false, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		tr := &tenantRouter{tenants: tenants}
+		if tt.fallback {
+			tr.fallback = main
+		}
+		r := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		r.Host = tt.host
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		tr.ServeHTTP(rec, r)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		var stats []PingStats
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(stats) != 1 || stats[0].Host != tt.want {
+			t.Errorf("%s: served %+v, want %s", tt.name, stats, tt.want)
+		}
+	}
+
+	// A tenant's token acts as its admin.
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("Authorization", "Bearer acme-token")
+	rec := httptest.NewRecorder()
+	(&tenantRouter{tenants: tenants}).ServeHTTP(rec, r)
+	var u User
+	if err := json.Unmarshal(rec.Body.Bytes(), &u); err != nil || u.Subject != "token:acme" || u.Role != roleAdmin {
+		t.Errorf("token user %+v, %v: %s", u, err, rec.Body)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TFTP targets download a file the way a PXE client does:
+//
+//	tftp://pxe.example.com/pxelinux.0
+//	tftp://10.0.0.2/ipxe/undionly.kpxe?blksize=512
+//
+// The whole file is transferred, so pick a small one such as the boot
+// loader. The latency is the transfer time, with the file size and the
+// time to the first block as metrics, and a server error such as a missing
+// file takes the host down. Larger blocks are negotiated (RFC 2348) unless
+// blksize is 512; servers that do not support options still work.
+func init() {
+	probers["tftp"] = probeTFTP
+}
+
+const (
+	tftpRRQ   = 1
+	tftpDATA  = 3
+	tftpACK   = 4
+	tftpERROR = 5
+	tftpOACK  = 6
+)
+
+func probeTFTP(t *target) (probeResult, error) {
+	file := strings.TrimPrefix(t.url.Path, "/")
+	if file == "" {
+		return probeResult{}, errors.New("missing file")
+	}
+	blksize, err := strconv.Atoi(t.param("blksize", "1428"))
+	if err != nil || blksize < 8 || blksize > 65464 {
+		return probeResult{}, fmt.Errorf("invalid blksize %q", t.param("blksize", ""))
+	}
+	addr, err := t.resolve()
+	if err != nil {
+		return probeResult{}, err
+	}
+	port, err := strconv.Atoi(t.port)
+	if t.port == "" {
+		port, err = 69, nil
+	}
+	if err != nil {
+		return probeResult{}, fmt.Errorf("invalid port %q", t.port)
+	}
+
+	local := ":0"
+	if t.source != nil {
+		local = net.JoinHostPort(t.source.String(), "0")
+	}
+	conn, err := t.listenConfig().ListenPacket(context.Background(), "udp", local)
+	if err != nil {
+		return probeResult{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(t.timeout())
+
+	// The server answers from a port of its own, which the rest of the
+	// transfer then goes to.
+	server := &net.UDPAddr{IP: addr.AsSlice(), Port: port}
+	var peer *net.UDPAddr
+	last := tftpRequest(file, blksize)
+	size := 512
+	block := uint16(1)
+	total, expected := 0, -1
+	metrics := map[string]float64{}
+	start := time.Now()
+	buf := make([]byte, 65536)
+	for {
+		to := server
+		if peer != nil {
+			to = peer
+		}
+		if _, err := conn.WriteTo(last, to); err != nil {
+			return probeResult{}, err
+		}
+		resend := time.Now().Add(time.Second)
+		if resend.After(deadline) {
+			resend = deadline
+		}
+		conn.SetReadDeadline(resend)
+		n, from, err := conn.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) && resend.Before(deadline) {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && peer == nil {
+			return probeResult{}, errors.New("no TFTP response")
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return probeResult{}, fmt.Errorf("transfer timed out after %d bytes", total)
+		}
+		if err != nil {
+			return probeResult{}, err
+		}
+		udp, ok := from.(*net.UDPAddr)
+		if !ok || !udp.IP.Equal(server.IP) || (peer != nil && udp.Port != peer.Port) || n < 4 {
+			continue
+		}
+		if peer == nil {
+			peer = udp
+			metrics["first_block_ms"] = msSince(start)
+		}
+		p := buf[:n]
+		switch binary.BigEndian.Uint16(p) {
+		case tftpERROR:
+			msg, _, _ := bytes.Cut(p[4:], []byte{0})
+			return probeResult{}, fmt.Errorf("tftp error %d: %s", binary.BigEndian.Uint16(p[2:]), msg)
+		case tftpOACK:
+			if block != 1 {
+				continue
+			}
+			options := tftpOptions(p[2:])
+			// RFC 2348 allows 8 to 65464 bytes, and no more than asked.
+			if v, ok := options["blksize"]; ok {
+				if size, err = strconv.Atoi(v); err != nil || size < 8 || size > blksize {
+					return probeResult{}, fmt.Errorf("invalid blksize %q acknowledged", v)
+				}
+			}
+			if v, ok := options["tsize"]; ok {
+				expected, _ = strconv.Atoi(v)
+			}
+			last = tftpAck(0)
+		case tftpDATA:
+			if binary.BigEndian.Uint16(p[2:]) != block {
+				continue // a duplicate, which the ACK sent again covers
+			}
+			data := p[4:]
+			total += len(data)
+			last = tftpAck(block)
+			if len(data) < size {
+				conn.WriteTo(last, peer)
+				if expected >= 0 && total != expected {
+					return probeResult{}, fmt.Errorf("received %d of %d bytes", total, expected)
+				}
+				metrics["size_bytes"] = float64(total)
+				return probeResult{Latency: msSince(start), Metrics: metrics}, nil
+			}
+			block++
+		}
+	}
+}
+
+// tftpRequest builds a read request asking for octet mode, blocks of
+// blksize and the transfer size.
+func tftpRequest(file string, blksize int) []byte {
+	p := binary.BigEndian.AppendUint16(nil, tftpRRQ)
+	p = append(append(p, file...), 0)
+	p = append(append(p, "octet"...), 0)
+	if blksize != 512 {
+		p = append(append(p, "blksize"...), 0)
+		p = append(append(p, strconv.Itoa(blksize)...), 0)
+	}
+	p = append(append(p, "tsize"...), 0)
+	return append(p, '0', 0)
+}
+
+func tftpAck(block uint16) []byte {
+	return binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, tftpACK), block)
+}
+
+// tftpOptions parses the name and value pairs of an option acknowledgement.
+func tftpOptions(p []byte) map[string]string {
+	options := map[string]string{}
+	fields := bytes.Split(bytes.TrimSuffix(p, []byte{0}), []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		options[strings.ToLower(string(fields[i]))] = string(fields[i+1])
+	}
+	return options
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTFTPRequest(t *testing.T) {
+	tests := []struct {
+		blksize int
+		want    string
+	}{
+		{1428, "\x00\x01pxelinux.0\x00octet\x00blksize\x001428\x00tsize\x000\x00"},
+		{512, "\x00\x01pxelinux.0\x00octet\x00tsize\x000\x00"},
+	}
+	for _, tt := range tests {
+		if got := string(tftpRequest("pxelinux.0", tt.blksize)); got != tt.want {
+			t.Errorf("blksize %d: %q, want %q", tt.blksize, got, tt.want)
+		}
+	}
+	if got := tftpAck(258); !bytes.Equal(got, []byte{0, tftpACK, 1, 2}) {
+		t.Errorf("ack % x", got)
+	}
+}
+
+func TestTFTPOptions(t *testing.T) {
+	tests := []struct {
+		p    string
+		want map[string]string
+	}{
+		{"blksize\x001428\x00tsize\x0024576\x00", map[string]string{"blksize": "1428", "tsize": "24576"}},
+		{"BLKSIZE\x00512\x00", map[string]string{"blksize": "512"}},
+		{"tsize\x0010", map[string]string{"tsize": "10"}},
+		{"blksize\x00", map[string]string{}},
+		{"", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := tftpOptions([]byte(tt.p)); !maps.Equal(got, tt.want) {
+			t.Errorf("%q: %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+// tftpPacket builds a packet with opcode op and the given fields, numbers
+// as two bytes and strings terminated by a zero byte.
+func tftpPacket(op uint16, fields ...any) []byte {
+	p := binary.BigEndian.AppendUint16(nil, op)
+	for _, f := range fields {
+		switch f := f.(type) {
+		case uint16:
+			p = binary.BigEndian.AppendUint16(p, f)
+		case string:
+			p = append(append(p, f...), 0)
+		case []byte:
+			p = append(p, f...)
+		}
+	}
+	return p
+}
+
+// serveTFTP serves files, each transfer from a port of its own. The server
+// ignores options for legacy.0, claims too large a file for liar.0 and
+// offers larger blocks than asked for greedy.0.
+func serveTFTP(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, client, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			fields := bytes.Split(bytes.TrimSuffix(buf[2:n], []byte{0}), []byte{0})
+			go tftpTransfer(string(fields[0]), files, tftpOptions(bytes.Join(fields[2:], []byte{0})), client)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func tftpTransfer(file string, files map[string][]byte, options map[string]string, client net.Addr) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	data, ok := files[file]
+	if !ok {
+		conn.WriteTo(tftpPacket(tftpERROR, uint16(1), "File not found"), client)
+		return
+	}
+
+	size := 512
+	var oack []any
+	switch file {
+	case "legacy.0":
+	case "greedy.0":
+		oack = []any{"blksize", "65464"}
+	case "liar.0":
+		oack = []any{"tsize", strconv.Itoa(len(data) + 100)}
+	default:
+		if v, ok := options["blksize"]; ok {
+			size, _ = strconv.Atoi(v)
+			oack = append(oack, "blksize", v)
+		}
+		oack = append(oack, "tsize", strconv.Itoa(len(data)))
+	}
+	// block is the last one sent, counting the OACK as 0.
+	send, block := tftpPacket(tftpOACK, oack...), uint16(0)
+	if oack == nil {
+		send, block = tftpPacket(tftpDATA, uint16(1), data[:min(size, len(data))]), 1
+	}
+	buf := make([]byte, 512)
+	for {
+		if _, err := conn.WriteTo(send, client); err != nil {
+			return
+		}
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil || n < 4 || binary.BigEndian.Uint16(buf) != tftpACK {
+			return
+		}
+		if binary.BigEndian.Uint16(buf[2:]) != block {
+			continue
+		}
+		if int(block)*size > len(data) {
+			return // the short last block arrived
+		}
+		block++
+		from := int(block-1) * size
+		send = tftpPacket(tftpDATA, block, data[from:min(from+size, len(data))])
+	}
+}
+
+func TestProbeTFTP(t *testing.T) {
+	boot := bytes.Repeat([]byte("pxe"), 1000)
+	addr := serveTFTP(t, map[string][]byte{
+		"pxelinux.0": boot,
+		"exact.0":    bytes.Repeat([]byte{1}, 1024),
+		"empty.0":    nil,
+		"legacy.0":   boot,
+		"liar.0":     boot,
+		"greedy.0":   boot,
+	})
+	tests := []struct {
+		name   string
+		target string
+		size   int
+		err    string
+	}{
+		{"negotiated", "tftp://" + addr + "/pxelinux.0", 3000, ""},
+		{"small blocks", "tftp://" + addr + "/pxelinux.0?blksize=512", 3000, ""},
+		{"odd blocks", "tftp://" + addr + "/pxelinux.0?blksize=1000", 3000, ""},
+		{"whole blocks", "tftp://" + addr + "/exact.0?blksize=512", 1024, ""},
+		{"empty", "tftp://" + addr + "/empty.0", 0, ""},
+		{"no options", "tftp://" + addr + "/legacy.0", 3000, ""},
+		{"missing", "tftp://" + addr + "/nope.0", 0, "tftp error 1: File not found"},
+		{"short", "tftp://" + addr + "/liar.0", 0, "received 3000 of 3100 bytes"},
+		{"too large blocks", "tftp://" + addr + "/greedy.0", 0, `invalid blksize "65464" acknowledged`},
+		{"no file", "tftp://" + addr, 0, "missing file"},
+		{"bad blksize", "tftp://" + addr + "/pxelinux.0?blksize=4", 0, `invalid blksize "4"`},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := probeTFTP(tgt)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || result.Metrics["size_bytes"] != float64(tt.size) {
+			t.Errorf("%s: %+v, %v", tt.name, result, err)
+			continue
+		}
+		if _, ok := result.Metrics["first_block_ms"]; !ok {
+			t.Errorf("%s: metrics %v", tt.name, result.Metrics)
+		}
+	}
+
+	// Nothing answers on the port of a closed socket.
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.LocalAddr().String()
+	closed.Close()
+	tgt, err := parseTarget("tftp://" + closedAddr + "/pxelinux.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt.retry.Timeout = 100 * time.Millisecond
+	if _, err := probeTFTP(tgt); err == nil || err.Error() != "no TFTP response" {
+		t.Errorf("nothing listening: error %v", err)
+	}
+}
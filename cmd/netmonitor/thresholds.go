@@ -0,0 +1,147 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+)
+
+// Thresholds are where the dashboard turns a host's latency (ms) and
+// packet loss (%) orange and red. Values above the warning level are
+// shown as warnings, values at or above the critical level as bad.
+//
+// With Alert set, a host whose average latency or loss over the shortest
+// window crosses them is also degraded, a warning or a critical alert by
+// the worse of the two.
+type Thresholds struct {
+	LatencyWarning  float64 `json:"latencyWarning"`
+	LatencyCritical float64 `json:"latencyCritical"`
+	LossWarning     float64 `json:"lossWarning"`
+	LossCritical    float64 `json:"lossCritical"`
+	Alert           bool    `json:"alert"`
+}
+
+// defaultThresholds suit wired terrestrial links: any loss is a warning.
+var defaultThresholds = Thresholds{LatencyWarning: 50, LatencyCritical: 100, LossWarning: 0, LossCritical: 5}
+
+// ThresholdConfig overrides some of the thresholds, for every host at the
+// top level of the config file or for a group or a single host:
+//
+//	thresholds:
+//	  latency_warning: 600
+//	  latency_critical: 900
+//	  loss_warning: 2
+//	  loss_critical: 10
+//	  alert: true
+type ThresholdConfig struct {
+	LatencyWarning  *float64 `yaml:"latency_warning"`
+	LatencyCritical *float64 `yaml:"latency_critical"`
+	LossWarning     *float64 `yaml:"loss_warning"`
+	LossCritical    *float64 `yaml:"loss_critical"`
+	Alert           *bool    `yaml:"alert"`
+}
+
+// over returns base with the configured thresholds replaced.
+func (c ThresholdConfig) over(base Thresholds) Thresholds {
+	set := func(dst *float64, v *float64) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	set(&base.LatencyWarning, c.LatencyWarning)
+	set(&base.LatencyCritical, c.LatencyCritical)
+	set(&base.LossWarning, c.LossWarning)
+	set(&base.LossCritical, c.LossCritical)
+	if c.Alert != nil {
+		base.Alert = *c.Alert
+	}
+	return base
+}
+
+func (t Thresholds) validate() error {
+	if t.LatencyWarning > t.LatencyCritical {
+		return fmt.Errorf("latency warning threshold %v ms is above the critical one, %v ms", t.LatencyWarning, t.LatencyCritical)
+	}
+	if t.LossWarning > t.LossCritical {
+		return fmt.Errorf("loss warning threshold %v%% is above the critical one, %v%%", t.LossWarning, t.LossCritical)
+	}
+	return nil
+}
+
+// hostThresholds resolves the thresholds of a host: its own settings win,
+// then those of the first matching group, then the top level ones.
+func (cfg *Config) hostThresholds(h HostConfig) (Thresholds, error) {
+	t := cfg.Thresholds.over(defaultThresholds)
+	for i := len(cfg.Groups) - 1; i >= 0; i-- {
+		if cfg.Groups[i].matches(h.Tags) {
+			t = cfg.Groups[i].Thresholds.over(t)
+		}
+	}
+	t = h.Thresholds.over(t)
+	return t, t.validate()
+}
+
+// thresholdLevel rates a value like the dashboard: above warning is a
+// warning and at or above critical critical.
+func thresholdLevel(v, warning, critical float64) string {
+	switch {
+	case v >= critical:
+		return severityCritical
+	case v > warning:
+		return severityWarning
+	}
+	return "ok"
+}
+
+// rated returns the average latency, if any probe succeeded, and the loss
+// of a host over its shortest window, or since the start without windows.
+func (h *hostState) rated(s *PingStats) (latency float64, answered bool, loss float64) {
+	latency, answered, loss = s.AvgLatency, s.PacketsRecv > 0, s.PacketLoss
+	if w := h.windows.shortest(); w != nil {
+		ws := s.Windows[windowName(w.size)]
+		latency, answered, loss = ws.AvgLatency, ws.PacketsRecv > 0, ws.PacketLoss
+	}
+	return latency, answered, loss
+}
+
+// crossThresholds rates a host by its thresholds and returns those it
+// crossed since the last probe. Hosts start out ok.
+func (h *hostState) crossThresholds(s *PingStats) []ThresholdCrossing {
+	var crossed []ThresholdCrossing
+	check := func(metric string, level *string, v, warning, critical float64) {
+		previous := cmp.Or(*level, "ok")
+		*level = thresholdLevel(v, warning, critical)
+		if *level != previous {
+			crossed = append(crossed, ThresholdCrossing{Metric: metric, Level: *level, Previous: previous, Value: v, Warning: warning, Critical: critical})
+		}
+	}
+	t := s.Thresholds
+	latency, answered, loss := h.rated(s)
+	if answered {
+		check("latency", &h.latencyLevel, latency, t.LatencyWarning, t.LatencyCritical)
+	}
+	check("loss", &h.lossLevel, loss, t.LossWarning, t.LossCritical)
+	return crossed
+}
+
+// thresholdProblem returns the severity and a description of a host
+// rated above its thresholds, or an empty severity while it is not.
+func (h *hostState) thresholdProblem(s *PingStats) (severity, warning string) {
+	latency, _, loss := h.rated(s)
+	var problems []string
+	for _, m := range []struct {
+		level, text string
+	}{
+		{h.latencyLevel, fmt.Sprintf("latency %.1f ms", latency)},
+		{h.lossLevel, fmt.Sprintf("packet loss %.1f%%", loss)},
+	} {
+		if m.level != severityWarning && m.level != severityCritical {
+			continue
+		}
+		problems = append(problems, m.text+" is "+m.level)
+		if severityRank[m.level] > severityRank[severity] {
+			severity = m.level
+		}
+	}
+	return severity, strings.Join(problems, ", ")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholdLevel(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "ok"},
+		{50, "ok"},
+		{50.1, severityWarning},
+		{99.9, severityWarning},
+		{100, severityCritical},
+		{250, severityCritical},
+	}
+	for _, tt := range tests {
+		if got := thresholdLevel(tt.v, 50, 100); got != tt.want {
+			t.Errorf("%v: %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestHostThresholdsAlert(t *testing.T) {
+	yes, no := true, false
+	cfg := &Config{
+		Thresholds: ThresholdConfig{Alert: &yes},
+		Groups:     []GroupConfig{{Tags: []string{"wifi"}, Thresholds: ThresholdConfig{Alert: &no}}},
+	}
+	tests := []struct {
+		name string
+		host HostConfig
+		want bool
+	}{
+		{"top level", HostConfig{Target: "192.0.2.1"}, true},
+		{"group", HostConfig{Target: "192.0.2.1", Tags: []string{"wifi"}}, false},
+		{"own", HostConfig{Target: "192.0.2.1", Tags: []string{"wifi"}, Thresholds: ThresholdConfig{Alert: &yes}}, true},
+	}
+	for _, tt := range tests {
+		th, err := cfg.hostThresholds(tt.host)
+		if err != nil || th.Alert != tt.want {
+			t.Errorf("%s: %+v, %v; want alert %v", tt.name, th, err, tt.want)
+		}
+	}
+	if th, _ := (&Config{}).hostThresholds(HostConfig{Target: "192.0.2.1"}); th.Alert {
+		t.Error("thresholds alert by default")
+	}
+}
+
+func TestCrossThresholds(t *testing.T) {
+	th := Thresholds{LatencyWarning: 50, LatencyCritical: 100, LossWarning: 0, LossCritical: 5}
+	h := &hostState{}
+	tests := []struct {
+		name     string
+		stats    PingStats
+		crossed  []string
+		severity string
+		warning  string
+	}{
+		{"ok", PingStats{AvgLatency: 20, PacketsRecv: 10}, nil, "", ""},
+		{"slow", PingStats{AvgLatency: 60, PacketsRecv: 10}, []string{"latency ok->warning"}, severityWarning, "latency 60.0 ms is warning"},
+		{"slower", PingStats{AvgLatency: 70, PacketsRecv: 10}, nil, severityWarning, "latency 70.0 ms is warning"},
+		{"lossy", PingStats{AvgLatency: 120, PacketsRecv: 9, PacketLoss: 10}, []string{"latency warning->critical", "loss ok->critical"}, severityCritical,
+			"latency 120.0 ms is critical, packet loss 10.0% is critical"},
+		{"better", PingStats{AvgLatency: 30, PacketsRecv: 99, PacketLoss: 1}, []string{"latency critical->ok", "loss critical->warning"}, severityWarning,
+			"packet loss 1.0% is warning"},
+	}
+	for _, tt := range tests {
+		s := tt.stats
+		s.Thresholds = th
+		var crossed []string
+		for _, c := range h.crossThresholds(&s) {
+			crossed = append(crossed, c.Metric+" "+c.Previous+"->"+c.Level)
+		}
+		if !slices.Equal(crossed, tt.crossed) {
+			t.Errorf("%s: crossed %q, want %q", tt.name, crossed, tt.crossed)
+		}
+		if severity, warning := h.thresholdProblem(&s); severity != tt.severity || warning != tt.warning {
+			t.Errorf("%s: %q %q, want %q %q", tt.name, severity, warning, tt.severity, tt.warning)
+		}
+	}
+
+	// With windows the host is rated by the shortest one.
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	h = &hostState{windows: newWindows([]time.Duration{time.Hour, 5 * time.Minute})}
+	s := PingStats{AvgLatency: 20, PacketsRecv: 100, Thresholds: th}
+	h.windows.add(start, true, 200)
+	s.Windows = h.windows.add(start.Add(time.Minute), true, 200)
+	if crossed := h.crossThresholds(&s); len(crossed) != 1 || crossed[0].Value != 200 || crossed[0].Level != severityCritical {
+		t.Errorf("rated by window: %+v", crossed)
+	}
+}
+
+// latencyProber answers with each of its latencies in turn.
+type latencyProber struct {
+	latencies []float64
+}
+
+func (p *latencyProber) Probe(t *target) (probeResult, error) {
+	latency := p.latencies[0]
+	p.latencies = p.latencies[1:]
+	return probeResult{Latency: latency}, nil
+}
+
+func TestThresholdAlerts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tgt, err := parseTarget("192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgt.thresholds = Thresholds{LatencyWarning: 40, LatencyCritical: 80, LossWarning: 10, LossCritical: 50, Alert: true}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notifier := make(recordingNotifier, 10)
+	m := NewMonitor([]*target{tgt}, 0, time.Minute)
+	m.clock = newFakeClock(start)
+	m.notifiers = []*namedNotifier{{name: "test", minSeverity: severityInfo, Notifier: notifier}}
+	h := &hostState{t: tgt, stats: m.hosts().stats[tgt.name], statusSince: start}
+
+	// The host is rated by its average latency since the start.
+	tests := []struct {
+		latency  float64
+		status   string
+		severity string
+		alert    string // its text, if one is sent
+	}{
+		{10, "up", "", ""},
+		{110, "degraded", severityWarning, "192.0.2.1 changed from up to degraded."},
+		{170, "degraded", severityCritical, "192.0.2.1 is degraded, now critical."},
+		{1, "degraded", severityWarning, "192.0.2.1 is degraded, now warning."},
+		{1, "degraded", severityWarning, ""},
+		{1, "degraded", severityWarning, ""},
+		{1, "degraded", severityWarning, ""},
+		{1, "up", "", "192.0.2.1 is up again"},
+	}
+	var latencies []float64
+	for _, tt := range tests {
+		latencies = append(latencies, tt.latency)
+	}
+	m.prober = &latencyProber{latencies}
+	for i, tt := range tests {
+		m.probeOnce(h)
+		s := h.stats.load()
+		if s.Status != tt.status || s.Severity != tt.severity {
+			t.Fatalf("probe %d: %s %q, want %s %q", i+1, s.Status, s.Severity, tt.status, tt.severity)
+		}
+		if tt.alert == "" {
+			notifier.none(t)
+			continue
+		}
+		a := notifier.next(t)
+		if text := a.Text(); !strings.HasPrefix(text, tt.alert) {
+			t.Errorf("probe %d: alert %q, want %q", i+1, text, tt.alert)
+		}
+		want := tt.severity
+		if tt.status == "up" {
+			want = severityWarning // that of the recovered problem
+		}
+		if a.Severity != want {
+			t.Errorf("probe %d: alert severity %s, want %s", i+1, a.Severity, want)
+		}
+	}
+}
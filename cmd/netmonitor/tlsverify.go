@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// TLSConfig sets how probes verify the certificates of TLS targets:
+//
+//	tls:
+//	  roots: [/etc/netmonitor/internal-ca.pem]
+//	  system_roots: false
+//	  revocation: true
+//
+// roots adds CA certificates, from PEM files or directories of them, to
+// the system's; with system_roots false only they are trusted. With
+// revocation set, every certificate of the chain is checked against the
+// OCSP response the server staples, the CA's OCSP responder or its CRL,
+// in that order. Answers are kept for up to an hour, and a CA that cannot
+// be asked does not fail the probe. A certificate problem fails the probe
+// with its own failure reason: cert_expired, intermediate_expired,
+// cert_revoked, hostname_mismatch or untrusted_chain.
+type TLSConfig struct {
+	Roots       []string `yaml:"roots"`
+	SystemRoots *bool    `yaml:"system_roots"` // true unless set
+	Revocation  bool     `yaml:"revocation"`
+}
+
+// tlsSettings are the TLS settings in effect, shared by every target.
+var tlsSettings struct {
+	sync.Mutex
+	roots      *x509.CertPool // nil for the system's
+	revocation bool
+}
+
+func configureTLS(cfg TLSConfig) error {
+	system := cfg.SystemRoots == nil || *cfg.SystemRoots
+	var roots *x509.CertPool
+	switch {
+	case !system && len(cfg.Roots) == 0:
+		return errors.New("system_roots is false but no roots are set")
+	case !system:
+		roots = x509.NewCertPool()
+	case len(cfg.Roots) > 0:
+		var err error
+		if roots, err = x509.SystemCertPool(); err != nil {
+			return fmt.Errorf("system roots: %w", err)
+		}
+	}
+	for _, path := range cfg.Roots {
+		if err := addRoots(roots, path); err != nil {
+			return err
+		}
+	}
+	s := &tlsSettings
+	s.Lock()
+	defer s.Unlock()
+	s.roots, s.revocation = roots, cfg.Revocation
+	return nil
+}
+
+// addRoots adds the certificates of a PEM file, or of the files in a
+// directory, to pool.
+func addRoots(pool *x509.CertPool, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(data) && !info.IsDir() {
+			return fmt.Errorf("%s: no PEM certificates", file)
+		}
+	}
+	return nil
+}
+
+// certError is a certificate problem, with the failure reason it counts
+// as.
+type certError struct {
+	reason string
+	err    error
+}
+
+func (e *certError) Error() string { return e.err.Error() }
+func (e *certError) Unwrap() error { return e.err }
+
+// tlsConfig returns the TLS settings for a connection to serverName.
+// Certificates are verified after the handshake rather than by crypto/tls,
+// to tell what is wrong with them. Since IP addresses are not sent as the
+// server name, serverName is what those are checked against, which for
+// HTTP transports following redirects is the target's host.
+func (t *target) tlsConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true, // verifyConnection does
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := cs.ServerName
+			if name == "" {
+				name = serverName
+			}
+			return t.verifyConnection(cs, name)
+		},
+	}
+}
+
+func (t *target) verifyConnection(cs tls.ConnectionState, name string) error {
+	tlsSettings.Lock()
+	roots, revocation := tlsSettings.roots, tlsSettings.revocation
+	tlsSettings.Unlock()
+
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		return &certError{reasonUntrustedChain, errors.New("server sent no certificate")}
+	}
+	leaf := certs[0]
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		return classifyChainError(leaf, certs[1:], opts, err)
+	}
+	if err := leaf.VerifyHostname(name); err != nil {
+		return &certError{reasonHostnameMismatch, err}
+	}
+	if !revocation {
+		return nil
+	}
+	chain := chains[0]
+	for i := 0; i+1 < len(chain); i++ {
+		var stapled []byte
+		if i == 0 {
+			stapled = cs.OCSPResponse
+		}
+		if err := t.checkRevocation(chain[i], chain[i+1], stapled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// classifyChainError says which certificate a failed verification is
+// down to. Go may report an expired intermediate only as an unknown
+// authority, when there were other candidates, so the chain is then tried
+// again at a time the intermediate was valid: if it verifies then, the
+// intermediate is what broke it.
+func classifyChainError(leaf *x509.Certificate, intermediates []*x509.Certificate, opts x509.VerifyOptions, err error) error {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Reason == x509.Expired {
+		if invalid.Cert.Equal(leaf) {
+			return &certError{reasonCertExpired, certExpiry("certificate", leaf)}
+		}
+		return &certError{reasonIntermediateExpired, certExpiry("intermediate certificate", invalid.Cert)}
+	}
+	now := time.Now()
+	for _, c := range intermediates {
+		if now.After(c.NotBefore) && now.Before(c.NotAfter) {
+			continue
+		}
+		opts.CurrentTime = c.NotAfter.Add(-time.Minute)
+		if now.Before(c.NotBefore) {
+			opts.CurrentTime = c.NotBefore.Add(time.Minute)
+		}
+		if leaf.NotBefore.After(opts.CurrentTime) || leaf.NotAfter.Before(opts.CurrentTime) {
+			continue
+		}
+		if _, err := leaf.Verify(opts); err == nil {
+			return &certError{reasonIntermediateExpired, certExpiry("intermediate certificate", c)}
+		}
+	}
+	return &certError{reasonUntrustedChain, err}
+}
+
+// certExpiry describes a certificate outside its validity period.
+func certExpiry(what string, c *x509.Certificate) error {
+	if time.Now().Before(c.NotBefore) {
+		return fmt.Errorf("%s %q is not valid before %s", what, certName(c), c.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return fmt.Errorf("%s %q expired %s", what, certName(c), c.NotAfter.UTC().Format(time.RFC3339))
+}
+
+func certName(c *x509.Certificate) string {
+	if c.Subject.CommonName != "" {
+		return c.Subject.CommonName
+	}
+	return c.Subject.String()
+}
+
+// revocationReasons names the reason codes of RFC 5280, 5.3.1.
+var revocationReasons = []string{
+	"unspecified", "keyCompromise", "cACompromise", "affiliationChanged",
+	"superseded", "cessationOfOperation", "certificateHold", "",
+	"removeFromCRL", "privilegeWithdrawn", "aACompromise",
+}
+
+// revocationStatus is what a CA said about a certificate.
+type revocationStatus struct {
+	revoked bool
+	at      time.Time
+	reason  int
+	source  string // OCSP or CRL
+	expires time.Time
+}
+
+// revocationCache keeps the status of certificates, by issuer and serial
+// number, and the CRLs fetched, by URL, until they are stale.
+var revocationCache = struct {
+	sync.Mutex
+	certs map[string]revocationStatus
+	crls  map[string]*x509.RevocationList
+}{certs: map[string]revocationStatus{}, crls: map[string]*x509.RevocationList{}}
+
+// checkRevocation fails if the CA of cert says it was revoked.
+func (t *target) checkRevocation(cert, issuer *x509.Certificate, stapled []byte) error {
+	key := fmt.Sprintf("%x/%s", sha256.Sum256(issuer.RawSubjectPublicKeyInfo), cert.SerialNumber)
+	now := time.Now()
+	c := &revocationCache
+	c.Lock()
+	status, ok := c.certs[key]
+	c.Unlock()
+	if !ok || now.After(status.expires) {
+		if status, ok = t.fetchRevocation(cert, issuer, stapled); !ok {
+			return nil
+		}
+		c.Lock()
+		c.certs[key] = status
+		c.Unlock()
+	}
+	if !status.revoked {
+		return nil
+	}
+	reason := ""
+	if status.reason >= 0 && status.reason < len(revocationReasons) && revocationReasons[status.reason] != "" {
+		reason = ", " + revocationReasons[status.reason]
+	}
+	what := "certificate"
+	if cert.IsCA {
+		what = "intermediate certificate"
+	}
+	return &certError{reasonCertRevoked, fmt.Errorf("%s %q revoked %s (%s%s)",
+		what, certName(cert), status.at.UTC().Format(time.RFC3339), status.source, reason)}
+}
+
+// fetchRevocation asks the CA about cert, returning false when it could
+// not say.
+func (t *target) fetchRevocation(cert, issuer *x509.Certificate, stapled []byte) (revocationStatus, bool) {
+	client := &http.Client{Timeout: t.timeout(), Transport: t.proxied(&http.Transport{})}
+	defer client.CloseIdleConnections()
+
+	responses := [][]byte{stapled}
+	for _, server := range cert.OCSPServer {
+		if !strings.HasPrefix(server, "http") {
+			continue
+		}
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		if err != nil {
+			break
+		}
+		resp, err := client.Post(server, "application/ocsp-request", bytes.NewReader(req))
+		if err != nil {
+			continue
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err == nil && resp.StatusCode == http.StatusOK {
+			responses = append(responses, body)
+		}
+	}
+	for _, raw := range responses {
+		if len(raw) == 0 {
+			continue
+		}
+		resp, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+		if err != nil || resp.Status == ocsp.Unknown {
+			continue
+		}
+		return revocationStatus{
+			revoked: resp.Status == ocsp.Revoked,
+			at:      resp.RevokedAt,
+			reason:  resp.RevocationReason,
+			source:  "OCSP",
+			expires: staleAt(resp.NextUpdate),
+		}, true
+	}
+
+	for _, url := range cert.CRLDistributionPoints {
+		crl := t.fetchCRL(client, url, issuer)
+		if crl == nil {
+			continue
+		}
+		status := revocationStatus{source: "CRL", expires: staleAt(crl.NextUpdate)}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				status.revoked, status.at, status.reason = true, entry.RevocationTime, entry.ReasonCode
+				break
+			}
+		}
+		return status, true
+	}
+	return revocationStatus{}, false
+}
+
+// fetchCRL returns the CRL at url, signed by issuer, from the cache while
+// it is fresh.
+func (t *target) fetchCRL(client *http.Client, url string, issuer *x509.Certificate) *x509.RevocationList {
+	if !strings.HasPrefix(url, "http") {
+		return nil
+	}
+	c := &revocationCache
+	c.Lock()
+	crl := c.crls[url]
+	c.Unlock()
+	if crl != nil && time.Now().Before(staleAt(crl.NextUpdate)) {
+		if crl.CheckSignatureFrom(issuer) == nil {
+			return crl
+		}
+		return nil
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	if block, _ := pem.Decode(body); block != nil {
+		body = block.Bytes
+	}
+	crl, err = x509.ParseRevocationList(body)
+	if err != nil || crl.CheckSignatureFrom(issuer) != nil {
+		return nil
+	}
+	c.Lock()
+	c.crls[url] = crl
+	c.Unlock()
+	return crl
+}
+
+// staleAt is when an answer valid until next must be asked for again: an
+// hour from now at the latest, so revocations show up soon.
+func staleAt(next time.Time) time.Time {
+	limit := time.Now().Add(time.Hour)
+	if next.IsZero() || next.After(limit) {
+		return limit
+	}
+	return next
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of API tokens. A token can only do what its scopes allow, and
+// nothing that needs the admin role. Tokens are only managed with login
+// configured: without it every request is let through anyway, so a token
+// would limit nobody.
+const (
+	scopeReadStats     = "read:stats"     // every GET of the API and pages, and Grafana queries
+	scopeWriteHosts    = "write:hosts"    // edit and import hosts
+	scopeWriteSilences = "write:silences" // acknowledge and annotate incidents, silencing reminders
+)
+
+var tokenScopes = []string{scopeReadStats, scopeWriteHosts, scopeWriteSilences}
+
+// tokenPrefix marks the API tokens of a monitor, and tells them apart from
+// tenant tokens and the debug token.
+const tokenPrefix = "nm_"
+
+// APIToken is a token for automation, granted only the scopes it needs.
+// The secret itself is shown once when it is created; only its hash is
+// kept.
+type APIToken struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Created   time.Time  `json:"created"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	LastUsed  *time.Time `json:"lastUsed,omitempty"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+}
+
+// tokenStore keeps the API tokens, in the history directory when one is
+// configured so they survive restarts.
+type tokenStore struct {
+	mu     sync.Mutex
+	path   string
+	tokens []APIToken
+}
+
+// open loads the tokens saved in dir.
+func (s *tokenStore) open(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = filepath.Join(dir, "tokens.json")
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &s.tokens)
+}
+
+// saveLocked writes the tokens out. s.mu must be held.
+func (s *tokenStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0600)
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// create adds a token and returns it with its secret.
+func (s *tokenStore) create(t APIToken) (APIToken, string, error) {
+	if t.Name == "" {
+		return t, "", errors.New("name is required")
+	}
+	if len(t.Scopes) == 0 {
+		return t, "", fmt.Errorf("scopes are required, any of %s", strings.Join(tokenScopes, ", "))
+	}
+	for _, scope := range t.Scopes {
+		if !slices.Contains(tokenScopes, scope) {
+			return t, "", fmt.Errorf("unknown scope %q, expected any of %s", scope, strings.Join(tokenScopes, ", "))
+		}
+	}
+	b := make([]byte, 36)
+	rand.Read(b)
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b[4:])
+	t.ID = hex.EncodeToString(b[:4])
+	t.Hash = hashToken(secret)
+	t.Created = time.Now()
+	t.LastUsed = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = append(s.tokens, t)
+	if err := s.saveLocked(); err != nil {
+		s.tokens = s.tokens[:len(s.tokens)-1]
+		return t, "", err
+	}
+	return t, secret, nil
+}
+
+// remove deletes a token, returning it.
+func (s *tokenStore) remove(id string) (APIToken, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.tokens, func(t APIToken) bool { return t.ID == id })
+	if i < 0 {
+		return APIToken{}, false, nil
+	}
+	t := s.tokens[i]
+	s.tokens = slices.Delete(s.tokens, i, i+1)
+	if err := s.saveLocked(); err != nil {
+		s.tokens = slices.Insert(s.tokens, i, t)
+		return t, true, err
+	}
+	return t, true, nil
+}
+
+// list returns the tokens without their hashes.
+func (s *tokenStore) list() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		t.Hash = ""
+		list = append(list, t)
+	}
+	return list
+}
+
+// lookup returns the unexpired token with the secret, noting its use.
+func (s *tokenStore) lookup(secret string) (APIToken, bool) {
+	hash := hashToken(secret)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tokens {
+		t := &s.tokens[i]
+		if t.Hash != hash || t.Expires != nil && now.After(*t.Expires) {
+			continue
+		}
+		t.LastUsed = &now
+		return *t, true
+	}
+	return APIToken{}, false
+}
+
+// wrap signs in requests carrying an API token as a user limited to its
+// scopes, without a role. Reads need read:stats; writes are refused
+// unless their route allows a scope of the token (see requireAccess).
+func (s *tokenStore) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "+tokenPrefix)
+		if !ok || userFrom(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, ok := s.lookup(tokenPrefix + secret)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "invalid or expired token")
+			return
+		}
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead || strings.HasPrefix(r.URL.Path, "/grafana/")
+		if read && !slices.Contains(t.Scopes, scopeReadStats) {
+			writeError(w, http.StatusForbidden, scopeReadStats+" scope required")
+			return
+		}
+		u := &User{Subject: "token:" + t.ID, Name: "token " + t.Name, Scopes: t.Scopes}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+// handleTokens serves GET /api/tokens.
+func (m *Monitor) handleTokens(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.tokens.list())
+}
+
+// handleTokenCreate serves POST /api/tokens, taking a name, scopes and
+// optionally how long the token is valid for, such as "720h". The secret
+// is only in the response.
+func (m *Monitor) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expiresIn"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid token: "+err.Error())
+		return
+	}
+	t := APIToken{Name: req.Name, Scopes: req.Scopes}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid expiresIn: %q", req.ExpiresIn))
+			return
+		}
+		expires := time.Now().Add(d)
+		t.Expires = &expires
+	}
+	if u := userFrom(r.Context()); u != nil {
+		t.CreatedBy = u.Name
+	}
+	t, secret, err := m.tokens.create(t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t.Hash = ""
+	m.auditRequest(r, "token.create", t.ID, nil, t)
+	writeJSON(w, http.StatusCreated, struct {
+		APIToken
+		Token string `json:"token"`
+	}{t, secret})
+}
+
+// handleTokenDelete serves DELETE /api/tokens/{id}, revoking a token.
+func (m *Monitor) handleTokenDelete(w http.ResponseWriter, r *http.Request) {
+	t, ok, err := m.tokens.remove(r.PathValue("id"))
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "no such token")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	t.Hash = ""
+	m.auditRequest(r, "token.delete", t.ID, t, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIAccess(t *testing.T) {
+	m := NewMonitor(nil, 0, time.Minute)
+	m.config = &Config{}
+	secrets := map[string]string{}
+	for _, scope := range tokenScopes {
+		_, secret, err := m.tokens.create(APIToken{Name: scope, Scopes: []string{scope}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		secrets[scope] = secret
+	}
+	admin := &User{Name: "admin", Role: roleAdmin}
+	operator := &User{Name: "operator", Role: roleOperator}
+	viewer := &User{Name: "viewer", Role: roleViewer}
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		user         *User  // signed in, nil without login
+		token        string // sent as the bearer token
+		want         int
+	}{
+		{"read token adds host", "POST", "/api/hosts", `{}`, nil, secrets[scopeReadStats], http.StatusForbidden},
+		{"hosts token adds host", "POST", "/api/hosts", `{}`, nil, secrets[scopeWriteHosts], http.StatusBadRequest},
+		{"silences token adds host", "POST", "/api/hosts", `{}`, nil, secrets[scopeWriteSilences], http.StatusForbidden},
+		{"hosts token reads stats", "GET", "/api/stats", "", nil, secrets[scopeWriteHosts], http.StatusForbidden},
+		{"read token reads stats", "GET", "/api/stats", "", nil, secrets[scopeReadStats], http.StatusOK},
+		{"unknown token", "GET", "/api/stats", "", nil, tokenPrefix + "nope", http.StatusUnauthorized},
+		{"viewer adds host", "POST", "/api/hosts", `{}`, viewer, "", http.StatusForbidden},
+		{"operator adds host", "POST", "/api/hosts", `{}`, operator, "", http.StatusBadRequest},
+		{"anonymous adds host without login", "POST", "/api/hosts", `{}`, nil, "", http.StatusBadRequest},
+		{"anonymous creates token without login", "POST", "/api/tokens", `{"name": "x", "scopes": ["read:stats"]}`, nil, "", http.StatusForbidden},
+		{"anonymous lists tokens without login", "GET", "/api/tokens", "", nil, "", http.StatusForbidden},
+		{"operator creates token", "POST", "/api/tokens", `{"name": "x", "scopes": ["read:stats"]}`, operator, "", http.StatusForbidden},
+		{"token creates token", "POST", "/api/tokens", `{"name": "x", "scopes": ["read:stats"]}`, nil, secrets[scopeWriteHosts], http.StatusForbidden},
+		{"admin creates token", "POST", "/api/tokens", `{"name": "x", "scopes": ["read:stats"]}`, admin, "", http.StatusCreated},
+		{"operator edits UI", "PUT", "/api/ui", `{}`, operator, "", http.StatusOK},
+		{"token edits UI", "PUT", "/api/ui", `{}`, nil, secrets[scopeWriteHosts], http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, tt.user))
+		}
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s: %s %s: status %d, want %d: %s", tt.name, tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
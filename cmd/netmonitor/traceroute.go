@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Traceroute targets map the load-balanced paths to a host and report when
+// they change:
+//
+//	traceroute://192.0.2.10
+//	traceroute://core.example.com?flows=16&max_hops=20
+//
+// Routers spreading traffic over equal-cost paths pick one by hashing the
+// addresses and ports of each packet, which sends the probes of a classic
+// traceroute, whose ports change with every probe, down different paths
+// and draws links that do not exist. Like Paris traceroute, each flow here
+// keeps its ports for all of its probes, so it follows one path, and flows
+// with different source ports (from sport, 61000 unless set) enumerate the
+// paths there are. The same flows are sent every time, so the same paths
+// are found again unless the network changed. Probes are UDP datagrams to
+// port 33434 (or port) with the TTL counting up, told apart in the ICMP
+// errors quoting them by their length.
+//
+// The first run only records the paths; a later one finding a flow taking
+// another way marks the host degraded once, naming the first hop that
+// differs, and logs the paths. Hops that do not answer are not counted as
+// changes. The latency is the
+// round trip to the destination, with the number of distinct paths and of
+// hops as metrics; a destination that does not answer past the last hop
+// that did marks the host degraded too. Like pings, the probes need a raw
+// ICMP socket.
+func init() {
+	probers["traceroute"] = probeTraceroute
+	probeDurations["traceroute"] = func(t *target) time.Duration {
+		opts, _ := parseTraceOptions(t)
+		return time.Duration(opts.maxHops)*traceRoundGap + t.timeout()
+	}
+}
+
+const (
+	defaultTraceFlows   = 8
+	defaultTraceMaxHops = 30
+	traceRoundGap       = 20 * time.Millisecond // between the probes of two TTLs
+	udpProtocolNumber   = 17
+)
+
+type traceOptions struct {
+	flows, maxHops   int
+	srcPort, dstPort int
+}
+
+func parseTraceOptions(t *target) (traceOptions, error) {
+	opts := traceOptions{flows: defaultTraceFlows, maxHops: defaultTraceMaxHops, srcPort: 61000, dstPort: 33434}
+	for _, p := range []struct {
+		name     string
+		v        *int
+		min, max int
+	}{
+		{"flows", &opts.flows, 1, 64},
+		{"max_hops", &opts.maxHops, 1, 64},
+		{"sport", &opts.srcPort, 1, 65535},
+		{"port", &opts.dstPort, 1, 65535},
+	} {
+		raw := t.param(p.name, "")
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < p.min || v > p.max {
+			return opts, fmt.Errorf("invalid %s %q", p.name, raw)
+		}
+		*p.v = v
+	}
+	if opts.srcPort+opts.flows > 65536 {
+		return opts, fmt.Errorf("sport %d leaves no room for %d flows", opts.srcPort, opts.flows)
+	}
+	return opts, nil
+}
+
+// tracePath is the hops of one flow by TTL, starting at 1, with invalid
+// addresses for those that did not answer. reached says whether the last
+// hop is the destination, and rtt is the round trip to it.
+type tracePath struct {
+	hops    []netip.Addr
+	reached bool
+	rtt     time.Duration
+}
+
+func (p tracePath) String() string {
+	hops := make([]string, len(p.hops))
+	for i, h := range p.hops {
+		hops[i] = "*"
+		if h.IsValid() {
+			hops[i] = h.String()
+		}
+	}
+	return strings.Join(hops, " > ")
+}
+
+// matches says whether two paths go the same way, as far as the hops that
+// answered both times tell.
+func (p tracePath) matches(q tracePath) bool {
+	if p.reached && q.reached && len(p.hops) != len(q.hops) {
+		return false
+	}
+	for i := range min(len(p.hops), len(q.hops)) {
+		if p.hops[i].IsValid() && q.hops[i].IsValid() && p.hops[i] != q.hops[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// distinctPaths drops the paths that match an earlier one.
+func distinctPaths(paths []tracePath) []tracePath {
+	var distinct []tracePath
+	for _, p := range paths {
+		if !slices.ContainsFunc(distinct, p.matches) {
+			distinct = append(distinct, p)
+		}
+	}
+	return distinct
+}
+
+func probeTraceroute(t *target) (probeResult, error) {
+	opts, err := parseTraceOptions(t)
+	if err != nil {
+		return probeResult{}, err
+	}
+	addr, err := t.resolve()
+	if err != nil {
+		return probeResult{}, err
+	}
+	if !addr.Is4() {
+		return probeResult{}, fmt.Errorf("traceroute to %s: only IPv4 is supported", addr)
+	}
+	flows, err := traceFlows(t, addr, opts)
+	if err != nil {
+		return probeResult{}, err
+	}
+	paths := distinctPaths(flows)
+	if len(paths) == 0 || !slices.ContainsFunc(paths, func(p tracePath) bool { return len(p.hops) > 0 }) {
+		return probeResult{}, fmt.Errorf("no answer from any hop to %s", addr)
+	}
+
+	result := probeResult{Metrics: map[string]float64{"paths": float64(len(paths))}}
+	var rtt time.Duration
+	hops := 0
+	for _, p := range flows {
+		hops = max(hops, len(p.hops))
+		if p.reached && (rtt == 0 || p.rtt < rtt) {
+			rtt = p.rtt
+		}
+	}
+	result.Metrics["hops"] = float64(hops)
+	if rtt > 0 {
+		result.Latency = float64(rtt) / float64(time.Millisecond)
+	} else {
+		result.Warning = fmt.Sprintf("%s did not answer past hop %d", addr, hops)
+	}
+
+	var changes []string
+	if s := t.state; s != nil {
+		s.mu.Lock()
+		changes = flowChanges(s.paths[t.name], flows)
+		s.paths[t.name] = flows
+		s.mu.Unlock()
+	}
+	if len(changes) > 0 {
+		change := strings.Join(changes, ", ")
+		log.Printf("Paths to %s changed: %s", t.name, change)
+		for i, p := range paths {
+			log.Printf("Path %d to %s: %s", i+1, t.name, p)
+		}
+		result.Warning = strings.TrimPrefix(result.Warning+"; paths changed: "+change, "; ")
+	}
+	return result, nil
+}
+
+// flowChanges compares the flows found now with those found before, flow
+// by flow since each takes the same path every time, and lists the first
+// hop that differs on each that changed. Hops that did not answer now are
+// filled in from before on flows that did not change, so a hop that only
+// answers now and then is still compared.
+func flowChanges(before, after []tracePath) []string {
+	var changes []string
+	for i := range min(len(before), len(after)) {
+		b, a := before[i], after[i]
+		changed := false
+		for ttl := range min(len(b.hops), len(a.hops)) {
+			if b.hops[ttl].IsValid() && a.hops[ttl].IsValid() && b.hops[ttl] != a.hops[ttl] {
+				change := fmt.Sprintf("hop %d %s -> %s", ttl+1, b.hops[ttl], a.hops[ttl])
+				if !slices.Contains(changes, change) {
+					changes = append(changes, change)
+				}
+				changed = true
+				break
+			}
+		}
+		if changed {
+			continue
+		}
+		for ttl := range min(len(b.hops), len(a.hops)) {
+			if !a.hops[ttl].IsValid() {
+				a.hops[ttl] = b.hops[ttl]
+			}
+		}
+	}
+	return changes
+}
+
+// traceQuote is what an ICMP error says about one of the UDP probes.
+type traceQuote struct {
+	dst              netip.Addr
+	srcPort, dstPort uint16
+	length           uint16 // of the UDP datagram, telling the TTL
+	from             netip.Addr
+	typ, code        uint8
+	at               time.Time // when it came
+}
+
+// parseUDPQuote parses an ICMPv4 error, without its IP header, that quotes
+// a UDP datagram, such as a Time Exceeded from a router or a Port
+// Unreachable from the destination.
+func parseUDPQuote(b []byte, from netip.Addr) (traceQuote, bool) {
+	const udpHeaderLen = 8
+	if len(b) < icmpHeaderLen+ipv4MinHeaderLen+udpHeaderLen || internetChecksum(b) != 0 {
+		return traceQuote{}, false
+	}
+	if b[0] != icmpTypeTimeExceeded && b[0] != icmpTypeUnreachable {
+		return traceQuote{}, false
+	}
+	ip := b[icmpHeaderLen:]
+	ihl := int(ip[0]&0x0f) * 4
+	if ip[0]>>4 != 4 || ihl < ipv4MinHeaderLen || len(ip) < ihl+udpHeaderLen || ip[9] != udpProtocolNumber {
+		return traceQuote{}, false
+	}
+	udp := ip[ihl:]
+	return traceQuote{
+		dst:     netip.AddrFrom4([4]byte(ip[16:20])),
+		srcPort: binary.BigEndian.Uint16(udp),
+		dstPort: binary.BigEndian.Uint16(udp[2:]),
+		length:  binary.BigEndian.Uint16(udp[4:]),
+		from:    from.Unmap(),
+		typ:     b[0],
+		code:    b[1],
+	}, true
+}
@@ -0,0 +1,173 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// traceKey identifies the flow of a traceroute by what ICMP errors quote.
+type traceKey struct {
+	dst              netip.Addr
+	srcPort, dstPort uint16
+}
+
+var (
+	traceMu      sync.Mutex
+	traceWaiting = map[traceKey]chan traceQuote{}
+)
+
+// deliverTraceQuote hands an ICMP error the pinger had no use for to the
+// traceroute whose probe it quotes, if any.
+func deliverTraceQuote(b []byte, from netip.Addr) {
+	q, ok := parseUDPQuote(b, from)
+	if !ok {
+		return
+	}
+	q.at = time.Now()
+	traceMu.Lock()
+	replies := traceWaiting[traceKey{q.dst, q.srcPort, q.dstPort}]
+	traceMu.Unlock()
+	if replies != nil {
+		select {
+		case replies <- q:
+		default:
+		}
+	}
+}
+
+// traceFlows sends the probes of every flow, a TTL at a time, and returns
+// the path each of them took.
+func traceFlows(t *target, dst netip.Addr, opts traceOptions) ([]tracePath, error) {
+	// The raw socket of pings sees the ICMP errors and its reader hands
+	// them over.
+	if _, err := icmpSocketFor(t); err != nil {
+		return nil, err
+	}
+	source := "0.0.0.0"
+	if t.source != nil {
+		source = t.source.String()
+	}
+	// Traceroutes to different hosts use the same source ports.
+	lc := t.listenConfig()
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if err := t.control(network, address, c); err != nil {
+			return err
+		}
+		var err error
+		c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		})
+		return err
+	}
+
+	replies := make(chan traceQuote, opts.flows*opts.maxHops*2)
+	conns := make([]*ipv4.PacketConn, opts.flows)
+	for i := range conns {
+		port := opts.srcPort + i
+		key := traceKey{dst, uint16(port), uint16(opts.dstPort)}
+		traceMu.Lock()
+		_, busy := traceWaiting[key]
+		if !busy {
+			traceWaiting[key] = replies
+		}
+		traceMu.Unlock()
+		if busy {
+			return nil, fmt.Errorf("another traceroute to %s is using source port %d", dst, port)
+		}
+		defer func() {
+			traceMu.Lock()
+			delete(traceWaiting, key)
+			traceMu.Unlock()
+		}()
+		pc, err := lc.ListenPacket(context.Background(), "udp4", net.JoinHostPort(source, strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		defer pc.Close()
+		conns[i] = ipv4.NewPacketConn(pc)
+	}
+
+	sent := make([][]time.Time, opts.flows)
+	hops := make([][]netip.Addr, opts.flows)
+	ends := make([]int, opts.flows) // TTL of the Destination Unreachable ending a flow
+	rtts := make([]time.Duration, opts.flows)
+	for i := range sent {
+		sent[i] = make([]time.Time, opts.maxHops)
+		hops[i] = make([]netip.Addr, opts.maxHops)
+	}
+	record := func(q traceQuote) {
+		flow, ttl := int(q.srcPort)-opts.srcPort, int(q.length)-8
+		if flow < 0 || flow >= opts.flows || ttl < 1 || ttl > opts.maxHops || sent[flow][ttl-1].IsZero() {
+			return
+		}
+		hops[flow][ttl-1] = q.from
+		if q.typ == icmpTypeUnreachable && (ends[flow] == 0 || ttl < ends[flow]) {
+			ends[flow] = ttl
+			rtts[flow] = q.at.Sub(sent[flow][ttl-1])
+		}
+	}
+	wait := func(until time.Time) {
+		timer := time.NewTimer(time.Until(until))
+		defer timer.Stop()
+		for {
+			select {
+			case q := <-replies:
+				record(q)
+			case <-timer.C:
+				return
+			}
+		}
+	}
+	ended := func(ttl int) bool {
+		for _, end := range ends {
+			if end == 0 || end > ttl {
+				return false
+			}
+		}
+		return true
+	}
+
+	to := &net.UDPAddr{IP: dst.AsSlice(), Port: opts.dstPort}
+	payload := make([]byte, opts.maxHops)
+	for ttl := 1; ttl <= opts.maxHops && !ended(ttl-1); ttl++ {
+		for i, c := range conns {
+			if err := c.SetTTL(ttl); err != nil {
+				return nil, err
+			}
+			sent[i][ttl-1] = time.Now()
+			if _, err := c.WriteTo(payload[:ttl], nil, to); err != nil {
+				return nil, err
+			}
+		}
+		wait(time.Now().Add(traceRoundGap))
+	}
+	wait(time.Now().Add(t.timeout()))
+
+	paths := make([]tracePath, opts.flows)
+	for i := range paths {
+		n := ends[i]
+		if n == 0 {
+			for ttl, h := range hops[i] {
+				if h.IsValid() {
+					n = ttl + 1
+				}
+			}
+		}
+		reached := ends[i] > 0 && hops[i][n-1] == dst
+		paths[i] = tracePath{hops: hops[i][:n], reached: reached}
+		if reached {
+			paths[i].rtt = rtts[i]
+		}
+	}
+	return paths, nil
+}
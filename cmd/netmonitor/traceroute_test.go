@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tracePathOf parses hops such as "10.0.0.1 > * > 192.0.2.1".
+func tracePathOf(s string) tracePath {
+	var p tracePath
+	for _, hop := range strings.Split(s, " > ") {
+		addr, _ := netip.ParseAddr(hop)
+		p.hops = append(p.hops, addr)
+	}
+	return p
+}
+
+func TestFlowChanges(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after []string
+		want          []string
+	}{
+		{"same", []string{"10.0.0.1 > 10.1.0.1 > 192.0.2.1"}, []string{"10.0.0.1 > 10.1.0.1 > 192.0.2.1"}, nil},
+		{"hop silent now", []string{"10.0.0.1 > 10.1.0.1 > 192.0.2.1"}, []string{"10.0.0.1 > * > 192.0.2.1"}, nil},
+		{"hop moved", []string{"10.0.0.1 > 10.1.0.1 > 192.0.2.1"}, []string{"10.0.0.1 > 10.2.0.1 > 192.0.2.1"}, []string{"hop 2 10.1.0.1 -> 10.2.0.1"}},
+		{
+			"two flows moved the same way",
+			[]string{"10.0.0.1 > 10.1.0.1", "10.0.0.1 > 10.1.0.1"},
+			[]string{"10.0.0.1 > 10.2.0.1", "10.0.0.1 > 10.2.0.1"},
+			[]string{"hop 2 10.1.0.1 -> 10.2.0.1"},
+		},
+		{"first probe", nil, []string{"10.0.0.1"}, nil},
+		{"more flows", []string{"10.0.0.1"}, []string{"10.0.0.1", "10.9.0.1"}, nil},
+	}
+	for _, tt := range tests {
+		var before, after []tracePath
+		for _, s := range tt.before {
+			before = append(before, tracePathOf(s))
+		}
+		for _, s := range tt.after {
+			after = append(after, tracePathOf(s))
+		}
+		if got := flowChanges(before, after); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: changes %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A hop that did not answer is filled in from before, so it still counts
+// when it answers differently later.
+func TestFlowChangesFillsSilentHops(t *testing.T) {
+	before := []tracePath{tracePathOf("10.0.0.1 > 10.1.0.1")}
+	now := []tracePath{tracePathOf("10.0.0.1 > *")}
+	flowChanges(before, now)
+	if got := flowChanges(now, []tracePath{tracePathOf("10.0.0.1 > 10.2.0.1")}); len(got) != 1 {
+		t.Errorf("changes %q after a silent hop, want the move from 10.1.0.1", got)
+	}
+}
+
+func TestParseTraceOptions(t *testing.T) {
+	tests := []struct {
+		target string
+		want   traceOptions
+		err    bool
+	}{
+		{"traceroute://192.0.2.1", traceOptions{flows: 8, maxHops: 30, srcPort: 61000, dstPort: 33434}, false},
+		{"traceroute://192.0.2.1?flows=16&max_hops=20&sport=40000&port=443", traceOptions{flows: 16, maxHops: 20, srcPort: 40000, dstPort: 443}, false},
+		{"traceroute://192.0.2.1?flows=0", traceOptions{}, true},
+		{"traceroute://192.0.2.1?max_hops=65", traceOptions{}, true},
+		{"traceroute://192.0.2.1?sport=65530&flows=8", traceOptions{}, true},
+		{"traceroute://192.0.2.1?port=x", traceOptions{}, true},
+	}
+	for _, tt := range tests {
+		tgt, err := parseTarget(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseTraceOptions(tgt)
+		if (err != nil) != tt.err || !tt.err && got != tt.want {
+			t.Errorf("%s: %+v, %v", tt.target, got, err)
+		}
+	}
+}
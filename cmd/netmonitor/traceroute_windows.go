@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"net/netip"
+)
+
+// traceFlows needs a raw ICMP socket to see the routers' answers, which
+// the ICMP helper API pings use on Windows does not offer.
+func traceFlows(t *target, dst netip.Addr, opts traceOptions) ([]tracePath, error) {
+	return nil, errors.New("traceroute is not supported on Windows")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// UISettings are preferences shared by everyone viewing the dashboard.
+type UISettings struct {
+	Theme string `json:"theme"` // "light", "dark" or empty to follow the browser
+}
+
+var uiThemes = []string{"", "light", "dark"}
+
+// uiStore keeps the settings, in the history directory when one is
+// configured so they survive restarts.
+type uiStore struct {
+	mu       sync.Mutex
+	path     string
+	settings UISettings
+}
+
+// open loads the settings saved in dir.
+func (s *uiStore) open(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = filepath.Join(dir, "ui.json")
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &s.settings)
+}
+
+func (s *uiStore) get() UISettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings
+}
+
+func (s *uiStore) set(settings UISettings) error {
+	if !slices.Contains(uiThemes, settings.Theme) {
+		return fmt.Errorf("unknown theme %q", settings.Theme)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path != "" {
+		b, err := json.Marshal(settings)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(s.path, b, 0644); err != nil {
+			return err
+		}
+	}
+	s.settings = settings
+	return nil
+}
+
+func (m *Monitor) handleUI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, m.ui.get())
+}
+
+func (m *Monitor) handleUIUpdate(w http.ResponseWriter, r *http.Request) {
+	var settings UISettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid settings: "+err.Error())
+		return
+	}
+	before := m.ui.get()
+	if err := m.ui.set(settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m.auditRequest(r, "ui.update", "ui", before, settings)
+	writeJSON(w, http.StatusOK, settings)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"cmp"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runValidate checks a config file without starting anything: its syntax,
+// keys, values and everything the monitor would refuse at startup.
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to the YAML configuration file to check")
+	resolve := flags.Bool("resolve", false, "Also check that every host name resolves")
+	schema := flags.Bool("schema", false, "Print the JSON schema of the config file instead")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: netmonitor validate [-resolve] -config FILE\n       netmonitor validate -schema\n\n")
+		fmt.Fprintf(flags.Output(), "Checks a config file and prints what is wrong with it.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if *schema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configSchema())
+	}
+	if *configPath == "" || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	problems, summary := validateConfig(*configPath, *resolve)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	switch len(problems) {
+	case 0:
+	case 1:
+		return fmt.Errorf("%s has a problem", *configPath)
+	default:
+		return fmt.Errorf("%s has %d problems", *configPath, len(problems))
+	}
+	fmt.Printf("%s: OK, %s\n", *configPath, summary)
+	return nil
+}
+
+// validateConfig returns the problems of a config file and those of its
+// tenants, or a summary of what it configures.
+func validateConfig(path string, resolve bool) (problems []string, summary string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}, ""
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []string{fmt.Sprintf("%s: %v", path, err)}, ""
+	}
+	for _, p := range unknownKeys(&root, reflect.TypeFor[Config](), "") {
+		problems = append(problems, fmt.Sprintf("%s:%s", path, p))
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		msg := err.Error()
+		if strings.Contains(msg, "into time.Duration") {
+			msg += "\n  durations need a unit, such as 30s, 5m or 1h"
+		}
+		return append(problems, msg), ""
+	}
+	if len(cfg.Hosts) == 0 && len(cfg.Discovery) == 0 && len(cfg.Tenants) == 0 {
+		problems = append(problems, fmt.Sprintf("%s: no hosts, discovery or tenants, so there is nothing to monitor", path))
+	}
+	setup, err := newMonitorSetup(cfg, path)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+	} else {
+		if _, err := newOIDCAuth(cfg.OIDC, cfg.DashboardURL, normalizeBasePath(cfg.BasePath)); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: oidc: %v", path, err))
+		}
+		if cfg.WebDir != "" {
+			if _, err := overrideWeb(cfg.WebDir); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: web_dir: %v", path, err))
+			}
+		}
+		if resolve {
+			problems = append(problems, unresolvable(path, setup.targets)...)
+		}
+		summary = fmt.Sprintf("%d hosts, %d notifiers, %d routes", len(setup.targets), len(setup.notifiers), len(setup.routes))
+	}
+
+	for i, tc := range cfg.Tenants {
+		if tc.Config == "" {
+			continue // reported by loadTenants below
+		}
+		tenantPath := tc.Config
+		if !filepath.IsAbs(tenantPath) {
+			tenantPath = filepath.Join(filepath.Dir(path), tenantPath)
+		}
+		tenantProblems, _ := validateConfig(tenantPath, resolve)
+		for _, p := range tenantProblems {
+			problems = append(problems, fmt.Sprintf("tenant %s: %s", cmp.Or(tc.Name, strconv.Itoa(i+1)), p))
+		}
+	}
+	if len(problems) == 0 {
+		if _, err := loadTenants(cfg, path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}
+	}
+	if len(cfg.Tenants) > 0 {
+		summary += fmt.Sprintf(", %d tenants", len(cfg.Tenants))
+	}
+	return problems, summary
+}
+
+// unresolvable returns the targets whose host names do not resolve.
+func unresolvable(path string, targets []*target) []string {
+	var problems []string
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if t.host == "" || seen[t.host] || net.ParseIP(t.host) != nil {
+			continue
+		}
+		seen[t.host] = true
+		if _, err := net.LookupHost(t.host); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s: %v", path, t.name, err))
+		}
+	}
+	return problems
+}
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// shorthandTypes can be written as a plain string: a host as its target
+// and a notifier as its URL.
+var shorthandTypes = []reflect.Type{reflect.TypeFor[HostConfig](), reflect.TypeFor[NotifierConfig]()}
+
+// yamlFields returns the keys of a struct and their field types, those of
+// inline structs included.
+func yamlFields(t reflect.Type) (names []string, types []reflect.Type) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if opts == "inline" {
+			n, ts := yamlFields(f.Type)
+			names, types = append(names, n...), append(types, ts...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		names = append(names, name)
+		types = append(types, f.Type)
+	}
+	return names, types
+}
+
+// unknownKeys returns the keys in node that t has no field for, with
+// their line and the closest known key when there is one.
+func unknownKeys(node *yaml.Node, t reflect.Type, at string) []string {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return unknownKeys(node.Content[0], t, at)
+	}
+	if node.Kind == yaml.AliasNode {
+		return unknownKeys(node.Alias, t, at)
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return nil
+	}
+
+	var problems []string
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil // a shorthand, or a type error reported by the decoder
+		}
+		names, types := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			j := slices.Index(names, key.Value)
+			if j < 0 {
+				msg := fmt.Sprintf("%d: unknown key %q%s", key.Line, key.Value, within(at))
+				if s := closest(key.Value, names); s != "" {
+					msg += fmt.Sprintf(", did you mean %q?", s)
+				}
+				problems = append(problems, msg)
+				continue
+			}
+			problems = append(problems, unknownKeys(value, types[j], keyPath(at, key.Value))...)
+		}
+	case reflect.Slice:
+		if node.Kind == yaml.SequenceNode {
+			for i, item := range node.Content {
+				problems = append(problems, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", at, i))...)
+			}
+		}
+	case reflect.Map:
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				problems = append(problems, unknownKeys(node.Content[i+1], t.Elem(), keyPath(at, node.Content[i].Value))...)
+			}
+		}
+	}
+	return problems
+}
+
+func keyPath(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+func within(at string) string {
+	if at == "" {
+		return ""
+	}
+	return " in " + at
+}
+
+// closest returns the key most like s, if one is close enough to be a
+// likely typo.
+func closest(s string, keys []string) string {
+	best, bestDist := "", 3
+	for _, k := range keys {
+		if d := editDistance(s, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// configSchema is the JSON schema of the config file, for editors that
+// complete and check YAML against one.
+func configSchema() map[string]any {
+	s := typeSchema(reflect.TypeFor[Config]())
+	s["$schema"] = "http://json-schema.org/draft-07/schema#"
+	s["title"] = "netmonitor configuration"
+	return s
+}
+
+func typeSchema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == durationType {
+		return map[string]any{"type": "string", "pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`, "description": "duration such as 30s, 5m or 1h30m"}
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		if t == reflect.TypeFor[role]() {
+			return map[string]any{"type": "string", "enum": slices.Sorted(maps.Keys(roleNames))}
+		}
+		return map[string]any{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		names, types := yamlFields(t)
+		props := map[string]any{}
+		for i, name := range names {
+			props[name] = typeSchema(types[i])
+		}
+		s := map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+		if slices.Contains(shorthandTypes, t) {
+			return map[string]any{"oneOf": []any{map[string]any{"type": "string"}, s}}
+		}
+		return s
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// embeddedWeb is the built-in web interface: a page per view in the top
+// directory and their styles and scripts under assets/.
+var embeddedWeb, _ = fs.Sub(webFiles, "web")
+
+// overlayFS serves files from dir, falling back to base for those it lacks.
+type overlayFS struct {
+	dir, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// overrideWeb lets the files in dir replace the built-in ones.
+func overrideWeb(dir string) (fs.FS, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return overlayFS{dir: os.DirFS(dir), base: embeddedWeb}, nil
+}
+
+// page serves one of the HTML pages.
+func (m *Monitor) page(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, err := fs.ReadFile(m.web, name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(b)
+	}
+}
+
+// handleAssets serves the pages' styles and scripts.
+func (m *Monitor) handleAssets(w http.ResponseWriter, r *http.Request) {
+	http.FileServerFS(m.web).ServeHTTP(w, r)
+}
+
+// normalizeBasePath turns "netmonitor/" or "/netmonitor" into
+// "/netmonitor", and "/" into "".
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.cors(w, r) {
+		return
+	}
+	var h http.Handler = m.mux
+	if m.auth != nil {
+		h = m.auth.wrap(h)
+	}
+	h = m.tokens.wrap(h)
+	if m.basePath == "" {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	// Behind a reverse proxy that passes the prefix through, serve
+	// everything below it. The pages use relative links, so the
+	// dashboard must be reached with the trailing slash.
+	if r.URL.Path == m.basePath {
+		target := m.basePath + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	http.StripPrefix(m.basePath, h).ServeHTTP(w, r)
+}
+
+// cors adds CORS headers for allowed origins and answers preflight
+// requests, returning true when the request has been handled.
+func (m *Monitor) cors(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(m.corsOrigins) == 0 {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	switch {
+	case slices.Contains(m.corsOrigins, "*"):
+		h.Set("Access-Control-Allow-Origin", "*")
+	case slices.Contains(m.corsOrigins, origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+	default:
+		return false
+	}
+	h.Set("Access-Control-Expose-Headers", "ETag, X-Total-Count")
+
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+		h.Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	return false
+}
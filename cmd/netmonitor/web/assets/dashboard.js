@@ -0,0 +1,306 @@
+function formatLatency(ms) {
+    return ms > 0 ? ms.toFixed(2) + ' ms' : 'N/A';
+}
+
+function formatPacketLoss(loss) {
+    return loss.toFixed(2) + '%';
+}
+
+const hostIcons = {
+    'router': '🛜 ',
+    'switch': '🔀 ',
+    'firewall': '🧱 ',
+    'access-point': '📶 ',
+    'server': '🖥️ ',
+    'database': '🗄️ ',
+    'camera': '📷 ',
+    'printer': '🖨️ ',
+    'phone': '📞 ',
+    'cloud': '☁️ '
+};
+
+// Thresholds are configured per host on the server.
+function getLatencyClass(latency, t) {
+    if (latency < 0) return '';
+    if (latency >= t.latencyCritical) return 'bad';
+    if (latency > t.latencyWarning) return 'warning';
+    return 'good';
+}
+
+function getPacketLossClass(loss, t) {
+    if (loss >= t.lossCritical) return 'bad';
+    if (loss > t.lossWarning) return 'warning';
+    return 'good';
+}
+
+function formatLastSeen(timestamp) {
+    if (!timestamp || timestamp === '0001-01-01T00:00:00Z') return 'Never';
+    const date = new Date(timestamp);
+    const now = new Date();
+    const diff = Math.floor((now - date) / 1000);
+
+    if (diff < 60) return diff + 's ago';
+    if (diff < 3600) return Math.floor(diff / 60) + 'm ago';
+    return Math.floor(diff / 3600) + 'h ago';
+}
+
+// formatOutage turns seconds into "14m 32s", "3h 5m" or "2d 4h".
+function formatOutage(seconds) {
+    const units = [['d', 86400], ['h', 3600], ['m', 60], ['s', 1]];
+    const parts = [];
+    for (const [unit, size] of units) {
+        if (seconds >= size || (parts.length === 0 && unit === 's')) {
+            parts.push(Math.floor(seconds / size) + unit);
+            seconds %= size;
+        }
+        if (parts.length === 2) break;
+    }
+    return parts.join(' ');
+}
+
+let sortOrder = localStorage.getItem('sort') || '';
+document.getElementById('sort').value = sortOrder;
+
+function setSort(order) {
+    sortOrder = order;
+    localStorage.setItem('sort', order);
+    updateStats();
+}
+
+function updateStats() {
+    fetch('api/stats?sort=' + encodeURIComponent(sortOrder))
+        .then(response => response.json())
+        .then(data => {
+            const grid = document.getElementById('hostGrid');
+            grid.innerHTML = '';
+
+            data.forEach(host => {
+                const card = document.createElement('div');
+                card.className = 'host-card';
+                card.innerHTML = 
+                    '<div class="host-header">' +
+                        '<div>' +
+                            '<div class="host-name">' + (hostIcons[host.icon] || '') + escapeHTML(host.displayName || host.host) + '</div>' +
+                            (host.displayName ? '<div class="host-target">' + escapeHTML(host.host) + '</div>' : '') +
+                        '</div>' +
+                        (host.paused ?
+                            '<div class="status paused" title="since ' + new Date(host.pausedSince).toLocaleString() + '">paused</div>' :
+                        host.offSchedule ?
+                            '<div class="status paused" title="not probed outside its schedule">off schedule</div>' :
+                            '<div class="status ' + host.status + ' ' + (host.severity || '') + '"' + (host.downSince ? ' title="since ' + new Date(host.downSince).toLocaleString() + '"' : '') + '>' +
+                                (host.downSince ? 'down for ' + formatOutage(host.outageDuration) :
+                                    host.status === 'degraded' && host.severity === 'critical' ? 'degraded · critical' : host.status) + '</div>') +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Current Latency</span>' +
+                        '<span class="metric-value ' + getLatencyClass(host.currentLatency, host.thresholds) + '">' + formatLatency(host.currentLatency) + '</span>' +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Average Latency</span>' +
+                        '<span class="metric-value ' + getLatencyClass(host.avgLatency, host.thresholds) + '">' + formatLatency(host.avgLatency) + '</span>' +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Min / Max Latency</span>' +
+                        '<span class="metric-value">' + formatLatency(host.minLatency) + ' / ' + formatLatency(host.maxLatency) + '</span>' +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Jitter</span>' +
+                        '<span class="metric-value">' + formatLatency(host.jitter) + '</span>' +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Packet Loss</span>' +
+                        '<span class="metric-value ' + getPacketLossClass(host.packetLoss, host.thresholds) + '">' + formatPacketLoss(host.packetLoss) + '</span>' +
+                    '</div>' +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Packets Sent / Received</span>' +
+                        '<span class="metric-value">' + host.packetsSent + ' / ' + host.packetsRecv + '</span>' +
+                    '</div>' +
+                    Object.keys(host.windows || {}).map(window => {
+                        const w = host.windows[window];
+                        return '<div class="metric">' +
+                            '<span class="metric-label">Avg Latency / Loss (last ' + window + ')</span>' +
+                            '<span class="metric-value">' +
+                                '<span class="' + getLatencyClass(w.avgLatency, host.thresholds) + '">' + formatLatency(w.avgLatency) + '</span> / ' +
+                                '<span class="' + getPacketLossClass(w.packetLoss, host.thresholds) + '">' + formatPacketLoss(w.packetLoss) + '</span>' +
+                            '</span>' +
+                        '</div>';
+                    }).join('') +
+                    '<div class="metric">' +
+                        '<span class="metric-label">Last Seen</span>' +
+                        '<span class="metric-value">' + formatLastSeen(host.lastSeen) + '</span>' +
+                    '</div>';
+                if (host.address && !host.info && host.host.indexOf(host.address) < 0) {
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">Address</span>' +
+                            '<span class="metric-value">' + escapeHTML(host.address) +
+                                (host.addressChanged ? ' (changed ' + formatLastSeen(host.addressChanged) + ')' : '') + '</span>' +
+                        '</div>';
+                }
+                if (host.info) {
+                    let network = host.info.ip;
+                    if (host.info.ptr) network += ' (' + host.info.ptr + ')';
+                    if (host.info.asn) network += ' · AS' + host.info.asn + (host.info.org ? ' ' + host.info.org : '');
+                    const loc = host.info.location;
+                    if (loc) network += ' · ' + [loc.city, loc.country].filter(Boolean).join(', ');
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">Network</span>' +
+                            '<span class="metric-value">' + escapeHTML(network) + '</span>' +
+                        '</div>';
+                }
+                card.innerHTML +=
+                    '<div class="host-actions"><button data-host="' + escapeHTML(host.host) + '" onclick="pauseHost(this.dataset.host, ' + !host.paused + ')">' +
+                        (host.paused ? 'Resume' : 'Pause') + '</button></div>';
+                if (host.notes) {
+                    card.innerHTML += '<div class="host-notes">' + escapeHTML(host.notes) + '</div>';
+                }
+                if (host.lastError) {
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">Error</span>' +
+                            '<span class="metric-value bad" title="' + escapeHTML(host.failureReason || '') + '">' + escapeHTML(host.lastError) + '</span>' +
+                        '</div>';
+                }
+                if (routeIssues[host.host]) {
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">Routing</span>' +
+                            '<span class="metric-value warning">' + escapeHTML(routeIssues[host.host]) + '</span>' +
+                        '</div>';
+                }
+                if (host.warning) {
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">Warning</span>' +
+                            '<span class="metric-value warning">' + escapeHTML(host.warning) + '</span>' +
+                        '</div>';
+                }
+                Object.keys(host.metrics || {}).sort().forEach(name => {
+                    card.innerHTML +=
+                        '<div class="metric">' +
+                            '<span class="metric-label">' + escapeHTML(name) + '</span>' +
+                            '<span class="metric-value">' + host.metrics[name].toFixed(2) + '</span>' +
+                        '</div>';
+                });
+                grid.appendChild(card);
+            });
+
+            document.getElementById('lastUpdate').textContent = 'Last updated: ' + new Date().toLocaleTimeString();
+        })
+        .catch(error => console.error('Error fetching stats:', error));
+}
+
+function updateIncidents() {
+    fetch('api/incidents?open=true')
+        .then(response => response.json())
+        .then(incidents => {
+            const list = document.getElementById('incidents');
+            list.innerHTML = '';
+
+            incidents.forEach(inc => {
+                const item = document.createElement('div');
+                item.className = 'incident ' + inc.status + ' ' + inc.severity + (inc.acknowledged ? ' acknowledged' : '');
+                let ack = '<button onclick="ackIncident(' + inc.id + ')">Acknowledge</button>';
+                if (inc.acknowledged) {
+                    ack = 'Acknowledged' + (inc.acknowledgedBy ? ' by ' + escapeHTML(inc.acknowledgedBy) : '');
+                }
+                item.innerHTML =
+                    '<div class="incident-header">' +
+                        '<div><strong>' + escapeHTML(inc.host) + '</strong> is ' + inc.status +
+                            ' (opened ' + formatLastSeen(inc.opened) + ')' +
+                            (inc.message ? ': ' + escapeHTML(inc.message) : '') + '</div>' +
+                        '<div>' + ack + '<button onclick="noteIncident(' + inc.id + ')">Add note</button></div>' +
+                    '</div>';
+                inc.notes.forEach(note => {
+                    item.innerHTML +=
+                        '<div class="incident-note">' + formatLastSeen(note.time) +
+                            (note.author ? ' ' + escapeHTML(note.author) : '') + ': ' + escapeHTML(note.text) + '</div>';
+                });
+                list.appendChild(item);
+            });
+        })
+        .catch(error => console.error('Error fetching incidents:', error));
+}
+
+// Hosts inside a prefix whose routing has an open problem, with what it
+// is, so their cards show it next to their reachability.
+let routeIssues = {};
+
+function updateRoutes() {
+    fetch('api/bgp')
+        .then(response => response.json())
+        .then(bgp => {
+            const list = document.getElementById('routes');
+            list.innerHTML = '';
+            routeIssues = {};
+
+            bgp.prefixes.filter(p => p.status === 'unexpected_origin' || p.status === 'withdrawn').forEach(p => {
+                const events = bgp.events.filter(e => e.prefix === p.prefix && !e.resolved);
+                const last = events[events.length - 1];
+                const issue = p.prefix + ' ' + p.status.replace('_', ' ');
+                p.hosts.forEach(h => routeIssues[h.host] = issue);
+                const item = document.createElement('div');
+                item.className = 'incident route critical';
+                item.innerHTML =
+                    '<div class="incident-header">' +
+                        '<div><strong>' + escapeHTML(p.prefix) + '</strong> ' + p.status.replace('_', ' ') +
+                            (last ? ' (' + formatLastSeen(last.time) + '): ' + escapeHTML(last.message) : '') + '</div>' +
+                    '</div>' +
+                    '<div class="incident-note">Seen by ' + p.peers + ' peers, withdrawn by ' + p.withdrawn +
+                        (p.hosts.length ? ' · ' + p.hosts.map(h => escapeHTML(h.host) + ' is ' + h.status).join(', ') : '') + '</div>';
+                list.appendChild(item);
+            });
+        })
+        .catch(error => console.error('Error fetching routes:', error));
+}
+
+function postIncident(id, action, body) {
+    fetch('api/incidents/' + id + '/' + action, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(body)
+    }).then(updateIncidents);
+}
+
+function pauseHost(host, pause) {
+    fetch('api/hosts/' + encodeURIComponent(host) + '/' + (pause ? 'pause' : 'resume'), {method: 'POST'})
+        .then(updateStats);
+}
+
+// The signed-in user when login is configured.
+let me = null;
+
+function loadMe() {
+    fetch('api/me')
+        .then(response => response.ok ? response.json() : null)
+        .then(user => {
+            me = user;
+            if (!user) return;
+            const span = document.getElementById('user');
+            span.textContent = ' · ' + user.name + ' (' + user.role + ') · ';
+            span.insertAdjacentHTML('beforeend', '<a href="auth/logout">Sign out</a>');
+        });
+}
+
+function ackIncident(id) {
+    const by = me ? me.name : prompt('Acknowledge as:');
+    if (by === null) return;
+    const note = prompt('Note (optional):') || '';
+    postIncident(id, 'ack', {by: by, note: note});
+}
+
+function noteIncident(id) {
+    const note = prompt('Note:');
+    if (!note) return;
+    postIncident(id, 'notes', {note: note});
+}
+
+// Update every 2 seconds
+loadMe();
+updateStats();
+updateIncidents();
+updateRoutes();
+setInterval(updateStats, 2000);
+setInterval(updateIncidents, 2000);
+setInterval(updateRoutes, 10000);
@@ -0,0 +1,60 @@
+// Shows a host's latency or loss by day of the week and hour of the day,
+// green at nothing and red at the host's critical threshold.
+const days = ['Mon', 'Tue', 'Wed', 'Thu', 'Fri', 'Sat', 'Sun'];
+let thresholds = {};
+
+function color(value, critical) {
+    const hue = 120 * (1 - Math.min(value / (critical || 1), 1));
+    return 'hsl(' + hue.toFixed(0) + ', 70%, 50%)';
+}
+
+function render(heatmap, metric) {
+    const t = thresholds[heatmap.host] || {};
+    const critical = metric === 'loss' ? t.lossCritical : t.latencyCritical;
+    const unit = metric === 'loss' ? '%' : ' ms';
+    let html = '<table><tr><th></th>';
+    for (let hour = 0; hour < 24; hour++) html += '<th>' + hour + '</th>';
+    html += '</tr>';
+    heatmap.cells.forEach((row, day) => {
+        html += '<tr><th>' + days[day] + '</th>';
+        row.forEach((cell, hour) => {
+            if (!cell.samples || (metric === 'latency' && cell.loss === 100)) {
+                html += '<td class="none" title="' + days[day] + ' ' + hour + ':00, no data"></td>';
+                return;
+            }
+            const value = cell[metric];
+            html += '<td style="background:' + color(value, critical) + '" title="' + days[day] + ' ' + hour +
+                ':00, ' + value.toFixed(1) + unit + ' over ' + cell.samples + ' probes"></td>';
+        });
+        html += '</tr>';
+    });
+    return html + '</table><div class="detail">Hours in ' + escapeHTML(heatmap.timezone) + ', red at ' + critical + unit + '</div>';
+}
+
+function loadHosts() {
+    return fetch('api/stats')
+        .then(response => response.json())
+        .then(hosts => {
+            const select = document.getElementById('host');
+            const selected = select.value || new URLSearchParams(location.search).get('host');
+            select.innerHTML = hosts.map(h => '<option value="' + escapeHTML(h.host) + '">' + escapeHTML(h.displayName || h.host) + '</option>').join('');
+            if (selected) select.value = selected;
+            hosts.forEach(h => thresholds[h.host] = h.thresholds);
+            document.getElementById('empty').textContent = hosts.length ? '' : 'No hosts are configured.';
+        });
+}
+
+function updateHeatmap() {
+    const host = document.getElementById('host').value;
+    if (!host) return;
+    const metric = document.getElementById('metric').value;
+    const span = document.getElementById('span').value;
+    fetch('api/heatmap?host=' + encodeURIComponent(host) + '&from=' + span +
+        '&tz=' + encodeURIComponent(Intl.DateTimeFormat().resolvedOptions().timeZone))
+        .then(response => response.json())
+        .then(heatmap => document.getElementById('heatmap').innerHTML = render(heatmap, metric))
+        .catch(error => console.error('Error fetching heatmap:', error));
+}
+
+loadHosts().then(updateHeatmap);
+setInterval(updateHeatmap, 300000);
@@ -0,0 +1,6 @@
+// escapeHTML makes text from the API, such as host names and the notes
+// users edit, safe to put into markup and attribute values.
+function escapeHTML(s) {
+    const entities = {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'};
+    return String(s).replace(/[&<>"']/g, c => entities[c]);
+}
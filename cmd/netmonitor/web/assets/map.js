@@ -0,0 +1,61 @@
+// Hosts are drawn on an equirectangular world map. The coastlines are
+// deliberately coarse so the page needs no tiles or other downloads.
+const land = [
+    [[-168,66],[-162,70],[-140,70],[-120,74],[-95,72],[-80,73],[-62,60],[-55,52],[-66,44],[-76,35],[-81,25],[-90,29],[-97,26],[-97,18],[-88,15],[-83,9],[-78,8],[-86,12],[-92,15],[-105,20],[-112,30],[-118,34],[-124,40],[-124,48],[-135,58],[-150,60],[-165,60]],
+    [[-73,78],[-60,82],[-30,83],[-20,76],[-22,70],[-43,60],[-52,64],[-60,70]],
+    [[-78,8],[-60,10],[-50,0],[-35,-5],[-38,-13],[-48,-26],[-58,-38],[-65,-42],[-68,-55],[-75,-50],[-72,-30],[-71,-18],[-81,-5],[-80,1]],
+    [[-10,36],[-9,43],[-2,47],[-5,48],[5,53],[8,57],[5,62],[15,69],[28,71],[40,67],[60,69],[80,73],[105,78],[140,73],[180,69],[180,65],[170,60],[160,55],[143,59],[135,54],[140,48],[130,42],[122,40],[121,31],[117,24],[108,21],[109,12],[104,9],[100,13],[98,8],[104,1],[98,10],[94,17],[90,22],[80,15],[77,8],[73,17],[67,25],[57,26],[52,28],[48,30],[56,25],[59,22],[52,16],[43,13],[38,22],[35,28],[34,31],[36,36],[28,37],[27,41],[23,40],[20,40],[15,44],[12,44],[18,40],[16,38],[10,44],[3,43],[-1,37],[-5,36]],
+    [[-6,36],[10,37],[11,33],[20,31],[32,31],[34,28],[43,12],[51,12],[40,-3],[40,-15],[33,-26],[27,-34],[18,-34],[12,-18],[13,-6],[9,4],[-8,4],[-17,14],[-17,21],[-10,30]],
+    [[114,-22],[122,-18],[130,-12],[137,-12],[142,-11],[146,-19],[153,-25],[150,-37],[140,-38],[131,-31],[116,-35],[114,-26]],
+    [[-5,50],[1,51],[0,53],[-3,56],[-5,58],[-6,56],[-3,54]],
+    [[130,31],[135,34],[140,35],[141,41],[145,44],[141,45],[139,38],[132,35]],
+    [[109,1],[117,7],[119,1],[116,-4],[110,-3]],
+    [[95,5],[106,-6],[102,-4]],
+    [[172,-34],[178,-38],[174,-41],[167,-46],[171,-44]],
+    [[44,-25],[50,-15],[49,-12],[43,-17]]
+];
+const svg = document.getElementById('map');
+const ns = 'http://www.w3.org/2000/svg';
+const project = (lon, lat) => [lon + 180, 90 - lat];
+
+function drawBase() {
+    for (let lon = -150; lon < 180; lon += 30) {
+        svg.insertAdjacentHTML('beforeend', '<line class="grid" x1="' + (lon + 180) + '" y1="0" x2="' + (lon + 180) + '" y2="180"/>');
+    }
+    for (let lat = -60; lat < 90; lat += 30) {
+        svg.insertAdjacentHTML('beforeend', '<line class="grid" x1="0" y1="' + (90 - lat) + '" x2="360" y2="' + (90 - lat) + '"/>');
+    }
+    land.forEach(shape => {
+        const points = shape.map(p => project(p[0], p[1]).join(',')).join(' ');
+        svg.insertAdjacentHTML('beforeend', '<polygon class="land" points="' + points + '"/>');
+    });
+}
+
+function updateMap() {
+    fetch('api/geo')
+        .then(response => response.json())
+        .then(hosts => {
+            svg.querySelectorAll('.host').forEach(el => el.remove());
+            hosts.forEach(host => {
+                const [x, y] = project(host.lon, host.lat);
+                const dot = document.createElementNS(ns, 'circle');
+                dot.setAttribute('class', 'host ' + host.status + ' ' + (host.severity || ''));
+                dot.setAttribute('cx', x);
+                dot.setAttribute('cy', y);
+                dot.setAttribute('r', 2);
+                const title = document.createElementNS(ns, 'title');
+                title.textContent = host.host + ' - ' + host.status +
+                    (host.city ? ' - ' + host.city : '') + (host.country ? ', ' + host.country : '') +
+                    ' - ' + host.latency.toFixed(2) + ' ms';
+                dot.appendChild(title);
+                svg.appendChild(dot);
+            });
+            document.getElementById('empty').textContent = hosts.length ? '' :
+                'No host has a location yet. Set enrich.geo to a GeoIP city database.';
+        })
+        .catch(error => console.error('Error fetching locations:', error));
+}
+
+drawBase();
+updateMap();
+setInterval(updateMap, 2000);
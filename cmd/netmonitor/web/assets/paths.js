@@ -0,0 +1,43 @@
+const ms = v => v > 0 ? v.toFixed(2) + ' ms' : '-';
+
+function updatePaths() {
+    fetch('api/paths')
+        .then(response => response.json())
+        .then(comparisons => {
+            const names = [];
+            comparisons.forEach(c => c.paths.forEach(p => {
+                if (!names.includes(p.path)) names.push(p.path);
+            }));
+            let html = '<tr><th>Host</th>' + names.map(n => '<th>' + escapeHTML(n) + '</th>').join('') + '<th>Difference</th></tr>';
+            comparisons.forEach(c => {
+                const byPath = {};
+                c.paths.forEach(p => byPath[p.path] = p);
+                html += '<tr><td>' + escapeHTML(c.host) + '</td>';
+                names.forEach(n => {
+                    const p = byPath[n];
+                    if (!p) {
+                        html += '<td></td>';
+                        return;
+                    }
+                    html += '<td><span class="status ' + p.status + '">' + p.status + '</span>' +
+                        '<div class="detail">' + ms(p.currentLatency) + ' now, ' + ms(p.avgLatency) + ' avg</div>' +
+                        '<div class="detail">' + p.packetLoss.toFixed(1) + '% loss, ' + ms(p.jitter) + ' jitter</div></td>';
+                });
+                // Compare every path with the first one.
+                const first = c.paths[0];
+                const diffs = c.paths.slice(1).map(p => {
+                    if (!(first.avgLatency > 0 && p.avgLatency > 0)) return escapeHTML(p.path) + ': -';
+                    const d = p.avgLatency - first.avgLatency;
+                    return escapeHTML(p.path) + ': ' + (d >= 0 ? '+' : '') + d.toFixed(2) + ' ms';
+                });
+                html += '<td>' + diffs.join('<br>') + '</td></tr>';
+            });
+            document.getElementById('paths').innerHTML = comparisons.length ? html : '';
+            document.getElementById('empty').textContent = comparisons.length ? '' :
+                'No host is probed over several paths. List paths in the config file and add them to hosts.';
+        })
+        .catch(error => console.error('Error fetching paths:', error));
+}
+
+updatePaths();
+setInterval(updatePaths, 2000);
@@ -0,0 +1,57 @@
+// Charts the throughput of every speed test and iperf3 host from the
+// history, which keeps each test's results.
+const width = 1000, height = 200, pad = 30;
+const rates = ['download_mbps', 'upload_mbps', 'bandwidth_mbps'];
+
+const isTest = s => s.m && rates.some(key => key in s.m);
+
+function chart(samples, from, to) {
+    const tests = samples.filter(isTest);
+    const top = Math.max(1, ...tests.map(s => Math.max(...rates.map(key => s.m[key] || 0))));
+    const x = t => pad + (new Date(t) - from) / (to - from) * (width - pad);
+    const y = v => height - pad - v / top * (height - 2 * pad);
+    const line = key => tests.filter(s => key in s.m)
+        .map(s => x(s.t).toFixed(1) + ',' + y(s.m[key]).toFixed(1)).join(' ');
+    return '<svg viewBox="0 0 ' + width + ' ' + height + '" preserveAspectRatio="none">' +
+        '<line class="axis" x1="' + pad + '" y1="' + y(0) + '" x2="' + width + '" y2="' + y(0) + '"/>' +
+        '<line class="axis" x1="' + pad + '" y1="' + y(top) + '" x2="' + width + '" y2="' + y(top) + '"/>' +
+        '<text x="0" y="' + (y(top) + 4) + '">' + top.toFixed(0) + '</text>' +
+        '<text x="0" y="' + (y(0) + 4) + '">0</text>' +
+        '<polyline class="line download" points="' + line('download_mbps') + '"/>' +
+        '<polyline class="line upload" points="' + line('upload_mbps') + '"/>' +
+        '<polyline class="line bandwidth" points="' + line('bandwidth_mbps') + '"/>' +
+        '</svg>';
+}
+
+function latest(samples) {
+    const tests = samples.filter(isTest);
+    if (!tests.length) return 'No completed test in this period yet.';
+    const last = tests[tests.length - 1];
+    const parts = rates.filter(key => key in last.m).map(key => {
+        const name = key.replace('_mbps', '');
+        return '<span class="' + name + '">▬</span> ' + name + ' ' + last.m[key].toFixed(1) + ' Mbit/s';
+    });
+    return parts.join(', ') + ', ' + last.l.toFixed(1) + ' ms, ' + new Date(last.t).toLocaleString();
+}
+
+function updateSpeed() {
+    const span = document.getElementById('span').value;
+    fetch('api/stats')
+        .then(response => response.json())
+        .then(hosts => {
+            hosts = hosts.filter(h => h.type === 'speedtest' || h.type === 'iperf3');
+            document.getElementById('empty').textContent = hosts.length ? '' :
+                'No speed tests are configured. Add speedtest:// or iperf3:// hosts to the config file.';
+            const to = new Date(), from = new Date(to - parseInt(span) * 3600 * 1000);
+            return Promise.all(hosts.map(h =>
+                fetch('api/history?host=' + encodeURIComponent(h.host) + '&from=' + span)
+                    .then(response => response.json())
+                    .then(samples => '<div class="chart"><h2>' + escapeHTML(h.displayName || h.host) + '</h2>' +
+                        chart(samples, from, to) + '<div class="detail">' + latest(samples) + '</div></div>')));
+        })
+        .then(charts => document.getElementById('charts').innerHTML = charts.join(''))
+        .catch(error => console.error('Error fetching speed tests:', error));
+}
+
+updateSpeed();
+setInterval(updateSpeed, 60000);
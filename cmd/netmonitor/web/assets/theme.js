@@ -0,0 +1,28 @@
+// The theme is stored on the server through /api/ui, so every browser
+// showing the dashboard uses the same one. Without a choice the browser's
+// preference applies.
+let uiSettings = {theme: ''};
+
+function applyTheme(theme) {
+    const dark = theme === 'dark' || (!theme && window.matchMedia('(prefers-color-scheme: dark)').matches);
+    document.body.classList.toggle('dark', dark);
+}
+
+function toggleTheme() {
+    uiSettings.theme = document.body.classList.contains('dark') ? 'light' : 'dark';
+    applyTheme(uiSettings.theme);
+    fetch('api/ui', {
+        method: 'PUT',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(uiSettings)
+    }).catch(error => console.error('Error saving theme:', error));
+}
+
+applyTheme('');
+fetch('api/ui')
+    .then(response => response.json())
+    .then(settings => {
+        uiSettings = settings;
+        applyTheme(settings.theme);
+    })
+    .catch(error => console.error('Error fetching UI settings:', error));
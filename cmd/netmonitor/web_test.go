@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	m := NewMonitor(nil, 0, time.Minute)
+	m.corsOrigins = []string{"https://grafana.example.com"}
+
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		r := httptest.NewRequest(http.MethodOptions, "/api/hosts", nil)
+		r.Header.Set("Origin", "https://grafana.example.com")
+		r.Header.Set("Access-Control-Request-Method", method)
+		r.Header.Set("Access-Control-Request-Headers", "authorization")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+
+		h := rec.Header()
+		if rec.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://grafana.example.com" {
+			t.Fatalf("%s preflight: status %d, origin %q", method, rec.Code, h.Get("Access-Control-Allow-Origin"))
+		}
+		if !strings.Contains(h.Get("Access-Control-Allow-Methods"), method) {
+			t.Errorf("%s preflight: methods %q", method, h.Get("Access-Control-Allow-Methods"))
+		}
+		if !strings.Contains(h.Get("Access-Control-Allow-Headers"), "Authorization") {
+			t.Errorf("%s preflight: headers %q", method, h.Get("Access-Control-Allow-Headers"))
+		}
+	}
+}
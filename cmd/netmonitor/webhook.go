@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// WebhookConfig sends events to a URL as they happen, more of them than
+// alerts and without the rules that decide who gets paged:
+//
+//	webhooks:
+//	  - url: https://hooks.example.com/netmonitor
+//	    events: [status_change, threshold_crossed]
+//	    tags: [prod]
+//	    secret: s3cret
+//
+// Each event is POSTed as a JSON WebhookEvent. With a secret, the
+// HMAC-SHA256 of the body is sent in X-Netmonitor-Signature as
+// sha256=<hex>. Deliveries that fail to connect or get a 429 or 5xx
+// response are retried with backoff.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"` // all unless set
+	Tags    []string          `yaml:"tags"`   // hosts with any of them, all unless set
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+// webhookEvents are the types of events. Hosts added through the API are
+// host_added, those found by discovery discovery_found, and host_removed
+// is either going away. Hosts are not added or found on startup.
+var webhookEvents = []string{"host_added", "host_removed", "discovery_found", "status_change", "threshold_crossed"}
+
+// WebhookEvent is what webhooks get. Data is a ProbeEvent for
+// status_change, a ThresholdCrossing for threshold_crossed and a
+// WebhookHost otherwise.
+type WebhookEvent struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	Tags []string  `json:"tags,omitempty"`
+	Data any       `json:"data"`
+}
+
+// WebhookHost is the data of the events about hosts coming and going.
+type WebhookHost struct {
+	Type     string `json:"type"`     // of probe
+	Provider string `json:"provider"` // the discovery, or api
+}
+
+// ThresholdCrossing is the data of threshold_crossed: the average latency
+// or loss of a host over its shortest window went from one level to
+// another, ok, warning or critical, by its dashboard thresholds.
+type ThresholdCrossing struct {
+	Metric   string  `json:"metric"`
+	Level    string  `json:"level"`
+	Previous string  `json:"previous"`
+	Value    float64 `json:"value"`
+	Warning  float64 `json:"warning"`
+	Critical float64 `json:"critical"`
+}
+
+const (
+	// webhookAttempts is how often a delivery is tried, with
+	// webhookBackoff before the first retry, doubling up to
+	// webhookMaxBackoff.
+	webhookAttempts   = 8
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 5 * time.Minute
+
+	webhookTimeout = 10 * time.Second
+)
+
+type webhook struct {
+	url     string
+	events  []string
+	tags    []string
+	secret  []byte
+	headers map[string]string
+
+	queue chan WebhookEvent
+	full  atomic.Bool // set while events are dropped, so that is logged once
+}
+
+func newWebhook(cfg WebhookConfig) (*webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	for _, ev := range cfg.Events {
+		if !slices.Contains(webhookEvents, ev) {
+			return nil, fmt.Errorf("unknown event %q, expected one of %s", ev, strings.Join(webhookEvents, ", "))
+		}
+	}
+	w := &webhook{
+		url:     u.String(),
+		events:  cfg.Events,
+		tags:    cfg.Tags,
+		secret:  []byte(cfg.Secret),
+		headers: cfg.Headers,
+		queue:   make(chan WebhookEvent, exportQueue),
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *webhook) wants(ev WebhookEvent) bool {
+	if len(w.events) > 0 && !slices.Contains(w.events, ev.Type) {
+		return false
+	}
+	return len(w.tags) == 0 || slices.ContainsFunc(w.tags, func(tag string) bool {
+		return slices.Contains(ev.Tags, tag)
+	})
+}
+
+// redacted is the URL of the webhook for logs, which may hold a token.
+func (w *webhook) redacted() string {
+	u, _ := url.Parse(w.url)
+	u.RawQuery = ""
+	return u.Redacted()
+}
+
+// run delivers the queued events in order.
+func (w *webhook) run() {
+	for ev := range w.queue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("Webhook %s: %v", w.redacted(), err)
+			continue
+		}
+		backoff := webhookBackoff
+		for attempt := 1; ; attempt++ {
+			wait, err := w.deliver(ev, body)
+			if err == nil {
+				break
+			}
+			if wait < 0 || attempt == webhookAttempts {
+				log.Printf("Webhook %s: %s event for %s not delivered after %d attempts: %v", w.redacted(), ev.Type, ev.Host, attempt, err)
+				break
+			}
+			time.Sleep(max(wait, backoff))
+			backoff = min(backoff*2, webhookMaxBackoff)
+		}
+	}
+}
+
+// deliver posts an event once. On failure it returns how long the server
+// asked to wait before retrying, or -1 if retrying is pointless.
+func (w *webhook) deliver(ev WebhookEvent, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Netmonitor-Event", ev.Type)
+	req.Header.Set("X-Netmonitor-Delivery", ev.ID)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set("X-Netmonitor-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: %s", resp.Status, firstLine(strings.TrimSpace(string(msg))))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, err
+	}
+	seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return min(time.Duration(seconds)*time.Second, webhookMaxBackoff), err
+}
+
+// emit queues an event for the webhooks that want it. Like actions, only
+// the leader sends them.
+func (m *Monitor) emit(typ, host string, tags []string, data any) {
+	if len(m.webhooks) == 0 || !m.leads() {
+		return
+	}
+	ev := WebhookEvent{ID: rand.Text(), Type: typ, Time: m.clock.Now(), Host: host, Tags: tags, Data: data}
+	for _, w := range m.webhooks {
+		if !w.wants(ev) {
+			continue
+		}
+		select {
+		case w.queue <- ev:
+			w.full.Store(false)
+		default:
+			if !w.full.Swap(true) {
+				log.Printf("Webhook %s: too many events waiting, dropping new ones", w.redacted())
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lifetime statistics never forget: a bad hour three weeks ago still
+// weighs on the average. Windowed statistics cover only the last hour or
+// day, or whatever windows are configured, next to the lifetime ones.
+
+// defaultWindows are the windows kept unless the config file sets others.
+var defaultWindows = []time.Duration{time.Hour, 24 * time.Hour}
+
+// windowBuckets is how many buckets a window is divided into. A window
+// drops its oldest bucket at once, so its numbers cover between
+// (windowBuckets-1)/windowBuckets of it and all of it.
+const windowBuckets = 60
+
+// WindowStats summarizes the probes of a host over a recent window.
+type WindowStats struct {
+	PacketsSent int     `json:"packetsSent"`
+	PacketsRecv int     `json:"packetsRecv"`
+	PacketLoss  float64 `json:"packetLoss"`
+	AvgLatency  float64 `json:"avgLatency"`
+	MinLatency  float64 `json:"minLatency"`
+	MaxLatency  float64 `json:"maxLatency"`
+}
+
+type windowBucket struct {
+	epoch      int64 // which bucket-long period since the Unix epoch this holds
+	sent, recv int
+	sum        float64
+	min, max   float64
+}
+
+// window is a ring of buckets covering the last size of probes.
+type window struct {
+	size    time.Duration
+	buckets [windowBuckets]windowBucket
+}
+
+func (w *window) add(at time.Time, ok bool, latency float64) {
+	epoch := at.UnixNano() / int64(w.size/windowBuckets)
+	b := &w.buckets[epoch%windowBuckets]
+	if b.epoch != epoch {
+		*b = windowBucket{epoch: epoch}
+	}
+	b.sent++
+	if !ok {
+		return
+	}
+	if b.recv == 0 || latency < b.min {
+		b.min = latency
+	}
+	if b.recv == 0 || latency > b.max {
+		b.max = latency
+	}
+	b.recv++
+	b.sum += latency
+}
+
+// stats sums up the buckets still in the window at now.
+func (w *window) stats(now time.Time) WindowStats {
+	epoch := now.UnixNano() / int64(w.size/windowBuckets)
+	s := WindowStats{MinLatency: -1, MaxLatency: -1}
+	var sum float64
+	for _, b := range w.buckets {
+		if b.sent == 0 || b.epoch <= epoch-windowBuckets || b.epoch > epoch {
+			continue
+		}
+		s.PacketsSent += b.sent
+		if b.recv == 0 {
+			continue
+		}
+		if s.PacketsRecv == 0 || b.min < s.MinLatency {
+			s.MinLatency = b.min
+		}
+		if s.PacketsRecv == 0 || b.max > s.MaxLatency {
+			s.MaxLatency = b.max
+		}
+		s.PacketsRecv += b.recv
+		sum += b.sum
+	}
+	if s.PacketsSent > 0 {
+		s.PacketLoss = float64(s.PacketsSent-s.PacketsRecv) / float64(s.PacketsSent) * 100
+	}
+	if s.PacketsRecv > 0 {
+		s.AvgLatency = sum / float64(s.PacketsRecv)
+	}
+	return s
+}
+
+// windows are the windows of one host.
+type windows []*window
+
+func newWindows(sizes []time.Duration) windows {
+	ws := make(windows, len(sizes))
+	for i, size := range sizes {
+		ws[i] = &window{size: size}
+	}
+	return ws
+}
+
+// shortest returns the shortest window, nil if there are none.
+func (ws windows) shortest() *window {
+	var shortest *window
+	for _, w := range ws {
+		if shortest == nil || w.size < shortest.size {
+			shortest = w
+		}
+	}
+	return shortest
+}
+
+// add records a probe in every window and returns their new statistics,
+// by window name.
+func (ws windows) add(at time.Time, ok bool, latency float64) map[string]WindowStats {
+	stats := make(map[string]WindowStats, len(ws))
+	for _, w := range ws {
+		w.add(at, ok, latency)
+		stats[windowName(w.size)] = w.stats(at)
+	}
+	return stats
+}
+
+// windowName formats a window the short way it is usually written, such
+// as 1h, 24h or 1h30m.
+func windowName(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func validateWindows(sizes []time.Duration) error {
+	for _, size := range sizes {
+		if size < time.Minute {
+			return fmt.Errorf("window %v is shorter than a minute", size)
+		}
+	}
+	return nil
+}
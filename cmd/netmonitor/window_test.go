@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := &window{size: time.Hour}
+	w.add(start, true, 20)
+	w.add(start.Add(30*time.Second), false, 0)
+	w.add(start.Add(10*time.Minute), true, 10)
+	w.add(start.Add(40*time.Minute), true, 60)
+
+	tests := []struct {
+		name string
+		at   time.Duration
+		want WindowStats
+	}{
+		{"nothing yet", -time.Minute, WindowStats{MinLatency: -1, MaxLatency: -1}},
+		{"first minute", 59 * time.Second, WindowStats{PacketsSent: 2, PacketsRecv: 1, PacketLoss: 50, AvgLatency: 20, MinLatency: 20, MaxLatency: 20}},
+		{"all", 50 * time.Minute, WindowStats{PacketsSent: 4, PacketsRecv: 3, PacketLoss: 25, AvgLatency: 30, MinLatency: 10, MaxLatency: 60}},
+		{"last moment of the first bucket", 59*time.Minute + 59*time.Second, WindowStats{PacketsSent: 4, PacketsRecv: 3, PacketLoss: 25, AvgLatency: 30, MinLatency: 10, MaxLatency: 60}},
+		{"first bucket dropped", time.Hour, WindowStats{PacketsSent: 2, PacketsRecv: 2, AvgLatency: 35, MinLatency: 10, MaxLatency: 60}},
+		{"only the last", 70 * time.Minute, WindowStats{PacketsSent: 1, PacketsRecv: 1, AvgLatency: 60, MinLatency: 60, MaxLatency: 60}},
+		{"all gone", 100 * time.Minute, WindowStats{MinLatency: -1, MaxLatency: -1}},
+	}
+	for _, tt := range tests {
+		if got := w.stats(start.Add(tt.at)); got != tt.want {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// A bucket coming round again starts over.
+	w.add(start.Add(time.Hour+10*time.Second), false, 0)
+	want := WindowStats{PacketsSent: 3, PacketsRecv: 2, PacketLoss: float64(1) / 3 * 100, AvgLatency: 35, MinLatency: 10, MaxLatency: 60}
+	if got := w.stats(start.Add(time.Hour + 10*time.Second)); got != want {
+		t.Errorf("after wrapping: %+v, want %+v", got, want)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	ws := newWindows([]time.Duration{24 * time.Hour, 90 * time.Minute, time.Hour})
+	if s := ws.shortest(); s.size != time.Hour {
+		t.Errorf("shortest is %v", s.size)
+	}
+	if s := newWindows(nil).shortest(); s != nil {
+		t.Errorf("shortest of none is %v", s.size)
+	}
+	stats := ws.add(time.Now(), true, 5)
+	for _, name := range []string{"24h", "1h30m", "1h"} {
+		if s := stats[name]; s.PacketsRecv != 1 || s.AvgLatency != 5 {
+			t.Errorf("%s: %+v", name, s)
+		}
+	}
+}
+
+func TestWindowName(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		time.Hour:                     "1h",
+		24 * time.Hour:                "24h",
+		90 * time.Minute:              "1h30m",
+		15 * time.Minute:              "15m",
+		time.Hour + 30*time.Second:    "1h0m30s",
+		2*time.Minute + 5*time.Second: "2m5s",
+	} {
+		if got := windowName(d); got != want {
+			t.Errorf("%v: %q, want %q", d, got, want)
+		}
+	}
+}
+
+func TestValidateWindows(t *testing.T) {
+	if err := validateWindows([]time.Duration{time.Minute, time.Hour}); err != nil {
+		t.Error(err)
+	}
+	if err := validateWindows([]time.Duration{time.Hour, 30 * time.Second}); err == nil {
+		t.Error("30s window accepted")
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zabbix exporter, speaking the protocol of zabbix_sender to a server or
+// proxy:
+//
+//	zabbix://zabbix.lan:10051?host=netmonitor
+//
+// Every probe sends the trapper items netmonitor.up, .status, .latency and
+// .loss of the Zabbix host named by the host parameter, netmonitor by
+// default, with the monitored host as their parameter, such as
+// netmonitor.latency[8.8.8.8]. The probe's own metrics are sent as
+// netmonitor.metric[8.8.8.8,name]. Items Zabbix does not know are counted
+// as failed by the server and logged once.
+func init() {
+	exporterFactories["zabbix"] = newZabbixExporter
+}
+
+const (
+	// zabbixBatch is how many values are sent at most in one request,
+	// which are otherwise sent once a second.
+	zabbixBatch = 250
+
+	zabbixMaxResponse = 1 << 20
+)
+
+type zabbixExporter struct {
+	streamExporter
+
+	addr string
+	host string
+
+	// rejected is set while the server fails some of the values, so that
+	// is logged once. It is only used by the run goroutine.
+	rejected bool
+}
+
+// zabbixValue is one item value of a sender request.
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+	NS    int    `json:"ns"`
+}
+
+func newZabbixExporter(u *url.URL) (Exporter, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing server")
+	}
+	e := &zabbixExporter{addr: u.Host, host: u.Query().Get("host")}
+	if u.Port() == "" {
+		e.addr = net.JoinHostPort(u.Hostname(), "10051")
+	}
+	if e.host == "" {
+		e.host = "netmonitor"
+	}
+	e.streamExporter = newStreamExporter("Zabbix " + e.addr)
+	go e.run(e.session)
+	return e, nil
+}
+
+// session sends the queued values in batches. Zabbix answers each request
+// and closes the connection, so every batch has one of its own.
+func (e *zabbixExporter) session() error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var batch []zabbixValue
+	for {
+		select {
+		case ev := <-e.events:
+			batch = append(batch, e.values(ev)...)
+			if len(batch) < zabbixBatch {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		err := e.send(batch)
+		batch = batch[:0]
+		if err != nil {
+			return err
+		}
+		if e.failing {
+			e.connected()
+		}
+	}
+}
+
+// values turns an event into item values.
+func (e *zabbixExporter) values(ev ProbeEvent) []zabbixValue {
+	var values []zabbixValue
+	add := func(key, value string) {
+		values = append(values, zabbixValue{Host: e.host, Key: key, Value: value, Clock: ev.Time.Unix(), NS: ev.Time.Nanosecond()})
+	}
+	param := zabbixParam(ev.Host)
+	up := "0"
+	if ev.Error == "" {
+		up = "1"
+		add("netmonitor.latency["+param+"]", strconv.FormatFloat(ev.Latency, 'f', 3, 64))
+	}
+	add("netmonitor.up["+param+"]", up)
+	add("netmonitor.status["+param+"]", ev.Status)
+	add("netmonitor.loss["+param+"]", strconv.FormatFloat(ev.Loss, 'f', 2, 64))
+	for name, v := range ev.Metrics {
+		add("netmonitor.metric["+param+","+zabbixParam(name)+"]", strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return values
+}
+
+var zabbixPlainParam = regexp.MustCompile(`^[^",\[\]\s][^,\]]*$`)
+
+// zabbixParam quotes an item key parameter unless it can do without.
+func zabbixParam(s string) string {
+	if zabbixPlainParam.MatchString(s) {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// send makes a sender request: ZBXD, flags, the length of the JSON and
+// four reserved bytes, then the JSON, answered the same way.
+func (e *zabbixExporter) send(values []zabbixValue) error {
+	body, err := json.Marshal(map[string]any{"request": "sender data", "data": values})
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", e.addr, probeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	packet := append([]byte("ZBXD\x01"), binary.LittleEndian.AppendUint64(nil, uint64(len(body)))...)
+	if _, err := conn.Write(append(packet, body...)); err != nil {
+		return err
+	}
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint32(header[5:9])
+	if string(header[:4]) != "ZBXD" || n > zabbixMaxResponse {
+		return fmt.Errorf("unexpected response")
+	}
+	reply := make([]byte, n)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	var resp struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return fmt.Errorf("unexpected response: %v", err)
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("server answered %s: %s", resp.Response, resp.Info)
+	}
+	// info reads "processed: 3; failed: 1; total: 4; seconds spent: ...".
+	var processed, failed int
+	fmt.Sscanf(resp.Info, "processed: %d; failed: %d", &processed, &failed)
+	switch {
+	case failed > 0 && !e.rejected:
+		log.Printf("%s export: %d of %d values were rejected, are the trapper items of host %q set up? (%s)", e.name, failed, len(values), e.host, resp.Info)
+		e.rejected = true
+	case failed == 0 && e.rejected:
+		log.Printf("%s export: all values accepted again", e.name)
+		e.rejected = false
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zabbixServer hands over the JSON of each sender request and answers it
+// with reply.
+func zabbixServer(reply string, requests chan<- []byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		header := make([]byte, 13)
+		if _, err := io.ReadFull(conn, header); err != nil || string(header[:5]) != "ZBXD\x01" {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		requests <- body
+		packet := append([]byte("ZBXD\x01"), binary.LittleEndian.AppendUint64(nil, uint64(len(reply)))...)
+		conn.Write(append(packet, reply...))
+	}
+}
+
+func TestZabbixParam(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"8.8.8.8", "8.8.8.8"},
+		{"example.com:443", "example.com:443"},
+		{"https://example.com/a?b=c", "https://example.com/a?b=c"},
+		{"a,b", `"a,b"`},
+		{"[2001:db8::1]:53", `"[2001:db8::1]:53"`},
+		{"x]", `"x]"`},
+		{"two words", "two words"},
+		{" leading", `" leading"`},
+		{`say "hi"`, `say "hi"`},
+		{`"quoted"`, `"\"quoted\""`},
+		{"", `""`},
+	}
+	for _, tt := range tests {
+		if got := zabbixParam(tt.in); got != tt.want {
+			t.Errorf("%q: %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestZabbixValues(t *testing.T) {
+	e := &zabbixExporter{host: "netmonitor"}
+	at := time.Unix(1767225600, 500)
+	tests := []struct {
+		name string
+		ev   ProbeEvent
+		want []string
+	}{
+		{"up", ProbeEvent{Host: "8.8.8.8", Time: at, Status: "up", Latency: 12.3456, Loss: 0},
+			[]string{"netmonitor.latency[8.8.8.8]=12.346", "netmonitor.up[8.8.8.8]=1", "netmonitor.status[8.8.8.8]=up", "netmonitor.loss[8.8.8.8]=0.00"}},
+		{"down", ProbeEvent{Host: "a,b", Time: at, Status: "down", Loss: 100, Error: "timeout"},
+			[]string{`netmonitor.up["a,b"]=0`, `netmonitor.status["a,b"]=down`, `netmonitor.loss["a,b"]=100.00`}},
+		{"metrics", ProbeEvent{Host: "speed", Time: at, Status: "up", Latency: 1, Metrics: map[string]float64{"download_mbps": 94.5}},
+			[]string{"netmonitor.latency[speed]=1.000", "netmonitor.up[speed]=1", "netmonitor.status[speed]=up", "netmonitor.loss[speed]=0.00", "netmonitor.metric[speed,download_mbps]=94.5"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range e.values(tt.ev) {
+			if v.Host != "netmonitor" || v.Clock != 1767225600 || v.NS != 500 {
+				t.Errorf("%s: value %+v", tt.name, v)
+			}
+			got = append(got, v.Key+"="+v.Value)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZabbixExporter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	requests := make(chan []byte, 1)
+	addr := serveTCP(t, zabbixServer(`{"response":"success","info":"processed: 4; failed: 0; total: 4"}`, requests))
+	e, err := parseExporter("zabbix://" + addr + "?host=lab-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Export(ProbeEvent{Host: "192.0.2.1", Time: time.Now(), Status: "up", Latency: 3})
+
+	var body []byte
+	select {
+	case body = <-requests:
+	case <-time.After(3 * time.Second):
+		t.Fatal("no sender request")
+	}
+	var req struct {
+		Request string        `json:"request"`
+		Data    []zabbixValue `json:"data"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Request != "sender data" || len(req.Data) != 4 || req.Data[0].Host != "lab-monitor" || req.Data[0].Key != "netmonitor.latency[192.0.2.1]" {
+		t.Errorf("request %s", body)
+	}
+
+	for _, tt := range []struct {
+		url, addr, host string
+	}{
+		{"zabbix://zabbix.lan", "zabbix.lan:10051", "netmonitor"},
+		{"zabbix://zabbix.lan:10151?host=edge", "zabbix.lan:10151", "edge"},
+	} {
+		u, _ := url.Parse(tt.url)
+		e, err := newZabbixExporter(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if z := e.(*zabbixExporter); z.addr != tt.addr || z.host != tt.host {
+			t.Errorf("%s: sending to %s as %s", tt.url, z.addr, z.host)
+		}
+	}
+	if _, err := newZabbixExporter(&url.URL{Scheme: "zabbix"}); err == nil {
+		t.Error("exporter without a server accepted")
+	}
+}
+
+func TestZabbixSend(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name     string
+		reply    string
+		err      string
+		rejected bool
+	}{
+		{"accepted", `{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000055"}`, "", false},
+		{"unknown items", `{"response":"success","info":"processed: 0; failed: 1; total: 1; seconds spent: 0.000055"}`, "", true},
+		{"failed", `{"response":"failed","info":"host not found"}`, "server answered failed: host not found", false},
+		{"not json", `ok`, "unexpected response", false},
+	}
+	values := []zabbixValue{{Host: "netmonitor", Key: "netmonitor.up[192.0.2.1]", Value: "1"}}
+	for _, tt := range tests {
+		requests := make(chan []byte, 1)
+		e := &zabbixExporter{addr: serveTCP(t, zabbixServer(tt.reply, requests)), host: "netmonitor"}
+		err := e.send(values)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if e.rejected != tt.rejected {
+			t.Errorf("%s: rejected %v", tt.name, e.rejected)
+		}
+	}
+
+	// Anything but a Zabbix header is refused.
+	addr := serveTCP(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 13))
+		fmt.Fprint(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+	})
+	if err := (&zabbixExporter{addr: addr}).send(values); err == nil || err.Error() != "unexpected response" {
+		t.Errorf("not zabbix: error %v", err)
+	}
+}
@@ -2,6 +2,9 @@ module netmonitor
 
 go 1.25.3
 
-require golang.org/x/net v0.46.0
-
-require golang.org/x/sys v0.37.0 // indirect
+require (
+	golang.org/x/crypto v0.43.0
+	golang.org/x/net v0.46.0
+	golang.org/x/sys v0.37.0
+	gopkg.in/yaml.v3 v3.0.1
+)